@@ -64,6 +64,12 @@ adding an extra layer of security over the normal (SSH style) trust model.
 We shall call this: TOFUS.
 */
 func ValidateRoot(certStore trustmanager.X509Store, root *data.Signed, gun string) error {
+	return ValidateRootAt(certStore, root, gun, time.Now())
+}
+
+// ValidateRootAt is ValidateRoot, checking the expiry of the root and of its
+// certificates as of the time now rather than the present time
+func ValidateRootAt(certStore trustmanager.X509Store, root *data.Signed, gun string, now time.Time) error {
 	logrus.Debugf("entered ValidateRoot with dns: %s", gun)
 	signedRoot, err := data.RootFromSigned(root)
 	if err != nil {
@@ -71,7 +77,7 @@ func ValidateRoot(certStore trustmanager.X509Store, root *data.Signed, gun strin
 	}
 
 	// Retrieve all the leaf certificates in root for which the CN matches the GUN
-	allValidCerts, err := validRootLeafCerts(signedRoot, gun)
+	allValidCerts, err := validRootLeafCerts(signedRoot, gun, now)
 	if err != nil {
 		logrus.Debugf("error retrieving valid leaf certificates for: %s, %v", gun, err)
 		return &ErrValidationFail{Reason: "unable to retrieve valid leaf certificates"}
@@ -93,7 +99,7 @@ func ValidateRoot(certStore trustmanager.X509Store, root *data.Signed, gun strin
 	// use them first to validate that this new root is valid.
 	if len(certsForCN) != 0 {
 		logrus.Debugf("found %d valid root certificates for %s", len(certsForCN), gun)
-		err = signed.VerifyRoot(root, 0, trustmanager.CertsToKeys(certsForCN))
+		err = signed.VerifyRootAt(root, 0, trustmanager.CertsToKeys(certsForCN), now)
 		if err != nil {
 			logrus.Debugf("failed to verify TUF data for: %s, %v", gun, err)
 			return &ErrValidationFail{Reason: "failed to validate data with current trusted certificates"}
@@ -103,7 +109,7 @@ func ValidateRoot(certStore trustmanager.X509Store, root *data.Signed, gun strin
 	}
 
 	// Validate the integrity of the new root (does it have valid signatures)
-	err = signed.VerifyRoot(root, 0, trustmanager.CertsToKeys(allValidCerts))
+	err = signed.VerifyRootAt(root, 0, trustmanager.CertsToKeys(allValidCerts), now)
 	if err != nil {
 		logrus.Debugf("failed to verify TUF data for: %s, %v", gun, err)
 		return &ErrValidationFail{Reason: "failed to validate integrity of roots"}
@@ -142,9 +148,9 @@ func ValidateRoot(certStore trustmanager.X509Store, root *data.Signed, gun strin
 	return nil
 }
 
-// validRootLeafCerts returns a list of non-exipired, non-sha1 certificates whose
-// Common-Names match the provided GUN
-func validRootLeafCerts(root *data.SignedRoot, gun string) ([]*x509.Certificate, error) {
+// validRootLeafCerts returns a list of non-exipired (as of now), non-sha1
+// certificates whose Common-Names match the provided GUN
+func validRootLeafCerts(root *data.SignedRoot, gun string, now time.Time) ([]*x509.Certificate, error) {
 	// Get a list of all of the leaf certificates present in root
 	allLeafCerts, _ := parseAllCerts(root)
 	var validLeafCerts []*x509.Certificate
@@ -158,7 +164,7 @@ func validRootLeafCerts(root *data.SignedRoot, gun string) ([]*x509.Certificate,
 			continue
 		}
 		// Make sure the certificate is not expired
-		if time.Now().After(cert.NotAfter) {
+		if now.After(cert.NotAfter) {
 			logrus.Debugf("error leaf certificate is expired")
 			continue
 		}
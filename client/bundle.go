@@ -0,0 +1,299 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/docker/notary"
+	"github.com/docker/notary/tuf"
+	"github.com/docker/notary/tuf/data"
+	"github.com/docker/notary/tuf/signed"
+	"github.com/docker/notary/tuf/store"
+)
+
+// Bundle is the signed metadata that a publish of a repository would send to
+// the server, by role name, so that it can be signed on one host and sent to
+// the server later from another.
+type Bundle struct {
+	GUN      string            `json:"gun"`
+	Metadata map[string][]byte `json:"metadata"`
+}
+
+// Roles returns the sorted names of the roles in the bundle
+func (b *Bundle) Roles() []string {
+	return sortedRoleNames(b.Metadata)
+}
+
+// ErrNoSnapshotKey is returned when the snapshot has to be signed locally, but
+// none of the snapshot keys of the repository are available
+type ErrNoSnapshotKey struct {
+	GUN string
+}
+
+func (err ErrNoSnapshotKey) Error() string {
+	return fmt.Sprintf("no snapshot key for %s is available locally, so the snapshot can only be signed by the server", err.GUN)
+}
+
+// SignOffline signs the metadata that the next Publish would send to the
+// server, with the staged changes applied, using only the cached metadata and
+// the local keys, and returns it as a bundle for PublishBundle.  The server is
+// never contacted, so the repository has to have been updated since it was
+// last published for the server to accept the bundle, and one that has never
+// been published can't be signed offline.  The changelist is left as it is.
+func (r *NotaryRepository) SignOffline() (*Bundle, error) {
+	return r.signOffline(false)
+}
+
+// ResignSnapshot signs the staged changes offline like SignOffline, and always
+// regenerates the snapshot from the metadata they result in and signs it with
+// the local snapshot key, failing with ErrNoSnapshotKey if there is none,
+// rather than leaving the snapshot to the server.
+func (r *NotaryRepository) ResignSnapshot() (*Bundle, error) {
+	return r.signOffline(true)
+}
+
+func (r *NotaryRepository) signOffline(requireSnapshot bool) (*Bundle, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	// without a round tripper, the remote store is an offline store
+	roundTrip := r.roundTrip
+	r.roundTrip = nil
+	defer func() { r.roundTrip = roundTrip }()
+
+	// only a repository that has been updated since it was published has a
+	// cached timestamp
+	_, err := r.fileStore.GetMeta(data.CanonicalTimestampRole, notary.MaxTimestampSize)
+	if _, ok := err.(store.ErrMetaNotFound); ok {
+		return nil, fmt.Errorf("%s has to be published and updated before it can be signed offline", r.gun)
+	} else if err != nil {
+		return nil, err
+	}
+	if _, err := r.update(false); err != nil {
+		return nil, err
+	}
+	if requireSnapshot && !r.hasSnapshotKey() {
+		return nil, ErrNoSnapshotKey{GUN: r.gun}
+	}
+	cl, err := r.GetChangelist()
+	if err != nil {
+		return nil, err
+	}
+
+	// the changes are only applied to be signed, so put the repo back the way
+	// it was afterwards
+	original, err := r.tufRepo.Copy()
+	if err != nil {
+		return nil, err
+	}
+	defer func() { r.tufRepo = original }()
+
+	updatedFiles, err := r.signChangelist(cl, false, nil)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := updatedFiles[data.CanonicalSnapshotRole]; requireSnapshot && !ok {
+		return nil, ErrNoSnapshotKey{GUN: r.gun}
+	}
+	return &Bundle{GUN: r.gun, Metadata: updatedFiles}, nil
+}
+
+// hasSnapshotKey is whether one of the snapshot keys listed in the root is
+// available locally
+func (r *NotaryRepository) hasSnapshotKey() bool {
+	snapshotRole, err := r.tufRepo.GetBaseRole(data.CanonicalSnapshotRole)
+	if err != nil {
+		return false
+	}
+	// non-root keys are listed by their path, which is prefixed with the GUN
+	for _, keyPath := range r.CryptoService.ListKeys(data.CanonicalSnapshotRole) {
+		if _, ok := snapshotRole.Keys[filepath.Base(keyPath)]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// PublishBundle sends the metadata in a bundle made by SignOffline to the
+// server, once it has checked that the bundle is for this repository, and
+// that the metadata of every role in it is newer than the published one, has
+// not expired, and is signed by enough of the keys of the role.
+func (r *NotaryRepository) PublishBundle(bundle *Bundle) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if bundle.GUN != r.gun {
+		return fmt.Errorf("the bundle is for %s, not %s", bundle.GUN, r.gun)
+	}
+	if len(bundle.Metadata) == 0 {
+		return fmt.Errorf("the bundle has no metadata")
+	}
+
+	initialPublish, err := r.updateForPublish()
+	if err != nil {
+		return err
+	}
+	verified, err := r.verifyBundle(bundle, initialPublish)
+	if err != nil {
+		return err
+	}
+
+	remote, err := getRemoteStore(r.baseURL, r.gun, r.roundTrip)
+	if err != nil {
+		return err
+	}
+	if err := remote.SetMultiMeta(bundle.Metadata); err != nil {
+		return err
+	}
+	r.tufRepo = verified
+	r.publishedRoles = bundle.Roles()
+	r.publishedSignatures = r.signatureCounts(r.publishedRoles)
+	return nil
+}
+
+// verifyBundle checks the metadata of every role in the bundle against the
+// keys of the role, as given by the metadata in the bundle that the role
+// is delegated by, or else by the trusted metadata.  A root in the bundle has
+// to be signed by the trusted root keys as well as its own.  Unless the repository is
+// being published for the first time, the metadata has to be newer than the
+// trusted metadata.  It returns a copy of the repo with the metadata in the
+// bundle.
+func (r *NotaryRepository) verifyBundle(bundle *Bundle, initialPublish bool) (*tuf.Repo, error) {
+	for role := range bundle.Metadata {
+		if !data.ValidRole(role) {
+			return nil, data.ErrInvalidRole{Role: role, Reason: "the bundle has metadata for an invalid role"}
+		}
+	}
+	repo, err := r.tufRepo.Copy()
+	if err != nil {
+		return nil, err
+	}
+	for _, role := range bundleRoleOrder(bundle.Metadata) {
+		s := &data.Signed{}
+		if err := json.Unmarshal(bundle.Metadata[role], s); err != nil {
+			return nil, fmt.Errorf("the metadata of %s in the bundle is invalid: %v", role, err)
+		}
+		minVersion := 1
+		if !initialPublish {
+			minVersion = trustedVersion(repo, role) + 1
+		}
+
+		// the root has to be signed by the currently trusted root keys, as
+		// for a root rotation, and by the keys it lists itself
+		if role == data.CanonicalRootRole {
+			trustedRoot, err := repo.GetBaseRole(data.CanonicalRootRole)
+			if err != nil {
+				return nil, err
+			}
+			if err := signed.VerifySignatures(s, trustedRoot); err != nil {
+				return nil, fmt.Errorf("the root in the bundle is not signed by the trusted root keys: %v", err)
+			}
+			root, err := data.RootFromSigned(s)
+			if err != nil {
+				return nil, err
+			}
+			repo.SetRoot(root)
+		}
+
+		var baseRole data.BaseRole
+		if data.IsDelegation(role) {
+			var delgRole data.DelegationRole
+			delgRole, err = repo.GetDelegationRole(role)
+			baseRole = delgRole.BaseRole
+		} else {
+			baseRole, err = repo.GetBaseRole(role)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := signed.Verify(s, baseRole, minVersion); err != nil {
+			return nil, fmt.Errorf("the metadata of %s in the bundle does not verify: %v", role, err)
+		}
+
+		switch role {
+		case data.CanonicalRootRole:
+		case data.CanonicalSnapshotRole:
+			snapshot, err := data.SnapshotFromSigned(s)
+			if err != nil {
+				return nil, err
+			}
+			repo.SetSnapshot(snapshot)
+		case data.CanonicalTimestampRole:
+			timestamp, err := data.TimestampFromSigned(s)
+			if err != nil {
+				return nil, err
+			}
+			repo.SetTimestamp(timestamp)
+		default:
+			targets, err := data.TargetsFromSigned(s)
+			if err != nil {
+				return nil, err
+			}
+			repo.SetTargets(role, targets)
+		}
+	}
+	return repo, nil
+}
+
+// trustedVersion is the version of the trusted metadata of a role, or 0 if
+// there is none
+func trustedVersion(repo *tuf.Repo, role string) int {
+	switch role {
+	case data.CanonicalRootRole:
+		if repo.Root != nil {
+			return repo.Root.Signed.Version
+		}
+	case data.CanonicalSnapshotRole:
+		if repo.Snapshot != nil {
+			return repo.Snapshot.Signed.Version
+		}
+	case data.CanonicalTimestampRole:
+		if repo.Timestamp != nil {
+			return repo.Timestamp.Signed.Version
+		}
+	default:
+		if targets, ok := repo.Targets[role]; ok {
+			return targets.Signed.Version
+		}
+	}
+	return 0
+}
+
+// bundleRoleOrder sorts the roles in a bundle in the order they need to be
+// verified in: the root, then targets and the delegations, each after the
+// role that delegates to it, then the snapshot and the timestamp
+func bundleRoleOrder(metadata map[string][]byte) []string {
+	var roles, targetsRoles []string
+	if _, ok := metadata[data.CanonicalRootRole]; ok {
+		roles = append(roles, data.CanonicalRootRole)
+	}
+	for role := range metadata {
+		if role == data.CanonicalTargetsRole || data.IsDelegation(role) {
+			targetsRoles = append(targetsRoles, role)
+		}
+	}
+	sort.Sort(byDepth(targetsRoles))
+	roles = append(roles, targetsRoles...)
+	for _, role := range []string{data.CanonicalSnapshotRole, data.CanonicalTimestampRole} {
+		if _, ok := metadata[role]; ok {
+			roles = append(roles, role)
+		}
+	}
+	return roles
+}
+
+// byDepth sorts role names by how deep they are in the delegation tree, then
+// by name
+type byDepth []string
+
+func (b byDepth) Len() int      { return len(b) }
+func (b byDepth) Swap(i, j int) { b[i], b[j] = b[j], b[i] }
+func (b byDepth) Less(i, j int) bool {
+	di, dj := strings.Count(b[i], "/"), strings.Count(b[j], "/")
+	if di != dj {
+		return di < dj
+	}
+	return b[i] < b[j]
+}
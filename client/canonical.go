@@ -0,0 +1,138 @@
+package client
+
+import (
+	"bytes"
+
+	canonicaljson "github.com/docker/go/canonical/json"
+	"github.com/docker/notary/tuf/data"
+)
+
+// canonicalContext is how many bytes either side of where the metadata first
+// differs from its canonical JSON are shown
+const canonicalContext = 24
+
+// CanonicalizationCheck compares the signed part of the metadata of a role,
+// exactly as the server sent it, with the canonical JSON that notary verifies
+// the signatures over, for diagnosing clients that verify signatures
+// differently
+type CanonicalizationCheck struct {
+	Role string `json:"role"`
+	// Canonical is whether the metadata as sent is already canonical JSON
+	Canonical bool `json:"canonical"`
+	// Offset is where the metadata as sent first differs from the canonical
+	// JSON, and SentContext and CanonicalContext are the bytes around that
+	// offset in each, if they differ
+	Offset           int    `json:"offset,omitempty"`
+	SentContext      string `json:"sent_context,omitempty"`
+	CanonicalContext string `json:"canonical_context,omitempty"`
+	// Signatures are checked over both the canonical JSON and the metadata
+	// as sent
+	Signatures []CanonicalSignatureCheck `json:"signatures"`
+}
+
+// CanonicalSignatureCheck is the status of a signature, as one of the
+// Signature* statuses, over the canonical JSON and over the metadata as sent
+type CanonicalSignatureCheck struct {
+	KeyID     string `json:"key_id"`
+	Method    string `json:"method"`
+	Canonical string `json:"canonical"`
+	Sent      string `json:"sent"`
+}
+
+// Consistent is whether the metadata as sent is canonical JSON, and every
+// signature has the same status over it as over the canonical JSON, so that
+// clients verifying either way agree
+func (c CanonicalizationCheck) Consistent() bool {
+	if !c.Canonical {
+		return false
+	}
+	for _, sig := range c.Signatures {
+		if sig.Canonical != sig.Sent {
+			return false
+		}
+	}
+	return true
+}
+
+// CheckCanonicalization re-canonicalizes the signed part of the latest
+// metadata of a role, as it was downloaded from the server, checks every
+// signature on it over both the canonical JSON and the bytes as downloaded,
+// and reports any discrepancy between them.
+func (r *NotaryRepository) CheckCanonicalization(role string) (CanonicalizationCheck, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if !data.ValidRole(role) {
+		return CanonicalizationCheck{}, data.ErrInvalidRole{Role: role, Reason: "invalid role name"}
+	}
+	if _, err := r.update(false); err != nil {
+		return CanonicalizationCheck{}, err
+	}
+	baseRole, err := r.baseRoleForSignatures(role)
+	if err != nil {
+		return CanonicalizationCheck{}, err
+	}
+	raw, err := r.fileStore.GetMeta(role, -1)
+	if err != nil {
+		return CanonicalizationCheck{}, data.ErrInvalidRole{Role: role, Reason: "the role has no metadata"}
+	}
+
+	s := &data.Signed{}
+	if err := canonicaljson.Unmarshal(raw, s); err != nil {
+		return CanonicalizationCheck{}, err
+	}
+	// this is how the signatures are verified, by tuf/signed
+	var decoded map[string]interface{}
+	if err := canonicaljson.Unmarshal(s.Signed, &decoded); err != nil {
+		return CanonicalizationCheck{}, err
+	}
+	canonical, err := canonicaljson.MarshalCanonical(decoded)
+	if err != nil {
+		return CanonicalizationCheck{}, err
+	}
+
+	check := CanonicalizationCheck{
+		Role:       role,
+		Canonical:  bytes.Equal(s.Signed, canonical),
+		Signatures: make([]CanonicalSignatureCheck, 0, len(s.Signatures)),
+	}
+	if !check.Canonical {
+		check.Offset = firstDifference(s.Signed, canonical)
+		check.SentContext = byteContext(s.Signed, check.Offset)
+		check.CanonicalContext = byteContext(canonical, check.Offset)
+	}
+	knownKeys := r.knownKeyIDs()
+	for _, sig := range s.Signatures {
+		check.Signatures = append(check.Signatures, CanonicalSignatureCheck{
+			KeyID:     sig.KeyID,
+			Method:    sig.Method.String(),
+			Canonical: signatureStatus(canonical, sig, baseRole, knownKeys),
+			Sent:      signatureStatus(s.Signed, sig, baseRole, knownKeys),
+		})
+	}
+	return check, nil
+}
+
+// firstDifference is the offset of the first byte where a and b differ,
+// which is the length of the shorter one if it is a prefix of the other
+func firstDifference(a, b []byte) int {
+	i := 0
+	for i < len(a) && i < len(b) && a[i] == b[i] {
+		i++
+	}
+	return i
+}
+
+// byteContext is the bytes of b around an offset
+func byteContext(b []byte, offset int) string {
+	start, end := offset-canonicalContext, offset+canonicalContext
+	if start < 0 {
+		start = 0
+	}
+	if end > len(b) {
+		end = len(b)
+	}
+	if start > end {
+		start = end
+	}
+	return string(b[start:end])
+}
@@ -0,0 +1,92 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/docker/notary/tuf/data"
+	"github.com/stretchr/testify/require"
+)
+
+// Metadata that notary signed is canonical JSON, and its signatures verify
+// over it as sent
+func TestCheckCanonicalizationCanonical(t *testing.T) {
+	_, serverSwizzler := newServerSwizzler(t)
+	ts := readOnlyServer(t, serverSwizzler.MetadataCache, http.StatusNotFound, "docker.com/notary")
+	defer ts.Close()
+
+	repo := newBlankRepo(t, ts.URL)
+	defer os.RemoveAll(repo.baseDir)
+
+	for _, role := range []string{data.CanonicalRootRole, data.CanonicalTargetsRole, "targets/a", data.CanonicalTimestampRole} {
+		check, err := repo.CheckCanonicalization(role)
+		require.NoError(t, err)
+		require.Equal(t, role, check.Role)
+		require.True(t, check.Canonical, role)
+		require.True(t, check.Consistent(), role)
+		require.NotEmpty(t, check.Signatures, role)
+		for _, sig := range check.Signatures {
+			require.Equal(t, SignatureValid, sig.Canonical)
+			require.Equal(t, SignatureValid, sig.Sent)
+		}
+	}
+
+	_, err := repo.CheckCanonicalization("targets/nonexistent")
+	require.Error(t, err)
+	_, err = repo.CheckCanonicalization("invalid")
+	require.Error(t, err)
+}
+
+// Metadata that is not sent as canonical JSON still verifies for notary, which
+// re-canonicalizes it, but not for a client that verifies the bytes as sent
+func TestCheckCanonicalizationNotCanonical(t *testing.T) {
+	_, serverSwizzler := newServerSwizzler(t)
+
+	metaBytes, err := serverSwizzler.MetadataCache.GetMeta(data.CanonicalTargetsRole, -1)
+	require.NoError(t, err)
+	var s data.Signed
+	require.NoError(t, json.Unmarshal(metaBytes, &s))
+	var indented bytes.Buffer
+	require.NoError(t, json.Indent(&indented, s.Signed, "", "  "))
+	// the signed part is written as is, since marshalling would compact it
+	sigsBytes, err := json.Marshal(s.Signatures)
+	require.NoError(t, err)
+	metaBytes = []byte(`{"signed":` + indented.String() + `,"signatures":` + string(sigsBytes) + `}`)
+	require.NoError(t, serverSwizzler.MetadataCache.SetMeta(data.CanonicalTargetsRole, metaBytes))
+	require.NoError(t, serverSwizzler.UpdateSnapshotHashes(data.CanonicalTargetsRole))
+	require.NoError(t, serverSwizzler.UpdateTimestampHash())
+
+	ts := readOnlyServer(t, serverSwizzler.MetadataCache, http.StatusNotFound, "docker.com/notary")
+	defer ts.Close()
+
+	repo := newBlankRepo(t, ts.URL)
+	defer os.RemoveAll(repo.baseDir)
+
+	check, err := repo.CheckCanonicalization(data.CanonicalTargetsRole)
+	require.NoError(t, err)
+	require.False(t, check.Canonical)
+	require.False(t, check.Consistent())
+	require.Equal(t, 1, check.Offset)
+	require.Contains(t, check.SentContext, "\n")
+	require.NotContains(t, check.CanonicalContext, "\n")
+	require.Len(t, check.Signatures, 1)
+	require.Equal(t, SignatureValid, check.Signatures[0].Canonical)
+	require.Equal(t, SignatureInvalid, check.Signatures[0].Sent)
+
+	// the other roles are unaffected
+	check, err = repo.CheckCanonicalization(data.CanonicalSnapshotRole)
+	require.NoError(t, err)
+	require.True(t, check.Consistent())
+}
+
+func TestFirstDifference(t *testing.T) {
+	require.Equal(t, 0, firstDifference([]byte("abc"), []byte("xbc")))
+	require.Equal(t, 2, firstDifference([]byte("abc"), []byte("abd")))
+	require.Equal(t, 2, firstDifference([]byte("ab"), []byte("abc")))
+	require.Equal(t, 3, firstDifference([]byte("abc"), []byte("abc")))
+	require.Equal(t, "", byteContext([]byte{}, 0))
+	require.Equal(t, "abc", byteContext([]byte("abc"), 1))
+}
@@ -1,6 +1,8 @@
 package changelist
 
 import (
+	"time"
+
 	"github.com/docker/notary/tuf/data"
 )
 
@@ -89,6 +91,23 @@ type TufDelegation struct {
 	AddPaths      []string     `json:"add_paths,omitempty"`
 	RemovePaths   []string     `json:"remove_paths,omitempty"`
 	ClearAllPaths bool         `json:"clear_paths,omitempty"`
+	ValidUntil    *time.Time   `json:"valid_until,omitempty"`
+	Replace       bool         `json:"replace,omitempty"`
+	// the path hash prefixes are removed along with the paths by
+	// ClearAllPaths
+	AddPathHashPrefixes    []string `json:"add_prefixes,omitempty"`
+	RemovePathHashPrefixes []string `json:"remove_prefixes,omitempty"`
+	// AddPathNotes are notes documenting why paths are delegated, keyed by
+	// path, which replace the notes the paths already have
+	AddPathNotes map[string]string `json:"add_path_notes,omitempty"`
+}
+
+// TufDelegationsBase is the delegations of a role in an earlier version of its
+// metadata, which the delegations of the role are reset to so that the
+// changes after it are made to that version
+type TufDelegationsBase struct {
+	Version     int              `json:"version"`
+	Delegations data.Delegations `json:"delegations"`
 }
 
 // ToNewRole creates a fresh role object from the TufDelegation data
@@ -97,5 +116,12 @@ func (td TufDelegation) ToNewRole(scope string) (*data.Role, error) {
 	if td.NewName != "" {
 		name = td.NewName
 	}
-	return data.NewRole(name, td.NewThreshold, td.AddKeys.IDs(), td.AddPaths)
+	r, err := data.NewRole(name, td.NewThreshold, td.AddKeys.IDs(), td.AddPaths)
+	if err != nil {
+		return nil, err
+	}
+	r.AddPathHashPrefixes(td.AddPathHashPrefixes)
+	r.AddPathNotes(td.AddPathNotes)
+	r.ValidUntil = td.ValidUntil
+	return r, nil
 }
@@ -0,0 +1,125 @@
+package changelist
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/docker/notary/tuf/data"
+)
+
+// ErrGUNMismatch is returned when importing a changelist that was exported
+// for a different GUN than the one it is being imported into
+type ErrGUNMismatch struct {
+	Expected string
+	Actual   string
+}
+
+func (e ErrGUNMismatch) Error() string {
+	return fmt.Sprintf("changelist was exported for %s, cannot import it into %s", e.Actual, e.Expected)
+}
+
+// ErrBadExport is returned when an exported changelist cannot be imported
+// because it is malformed or fails its integrity check
+type ErrBadExport struct {
+	Reason string
+}
+
+func (e ErrBadExport) Error() string {
+	return fmt.Sprintf("invalid changelist export: %s", e.Reason)
+}
+
+// exportedChangelist is the portable representation of a changelist.  The
+// checksum is the hex encoded SHA256 of the JSON encoded changes, so that
+// corruption of the file in transit can be detected on import.
+type exportedChangelist struct {
+	GUN      string       `json:"gun"`
+	Changes  []*TufChange `json:"changes"`
+	Checksum string       `json:"checksum"`
+}
+
+func checksumChanges(changes []*TufChange) (string, error) {
+	changesJSON, err := json.Marshal(changes)
+	if err != nil {
+		return "", err
+	}
+	checksum := sha256.Sum256(changesJSON)
+	return hex.EncodeToString(checksum[:]), nil
+}
+
+// Export serializes all the changes in the changelist for the given GUN to
+// the writer, in the order in which they would be applied
+func Export(cl Changelist, gun string, w io.Writer) error {
+	changes := []*TufChange{}
+	for _, c := range cl.List() {
+		changes = append(changes, NewTufChange(c.Action(), c.Scope(), c.Type(), c.Path(), c.Content()))
+	}
+	checksum, err := checksumChanges(changes)
+	if err != nil {
+		return err
+	}
+	return json.NewEncoder(w).Encode(exportedChangelist{
+		GUN:      gun,
+		Changes:  changes,
+		Checksum: checksum,
+	})
+}
+
+// Import reads a changelist previously written by Export, verifies that it was
+// exported for the given GUN and has not been modified, and appends its
+// changes to the changelist.  No changes are added if validation fails.
+func Import(cl Changelist, gun string, r io.Reader) error {
+	var exported exportedChangelist
+	if err := json.NewDecoder(r).Decode(&exported); err != nil {
+		return ErrBadExport{Reason: err.Error()}
+	}
+	if exported.GUN != gun {
+		return ErrGUNMismatch{Expected: gun, Actual: exported.GUN}
+	}
+	checksum, err := checksumChanges(exported.Changes)
+	if err != nil {
+		return err
+	}
+	if checksum != exported.Checksum {
+		return ErrBadExport{Reason: "checksum does not match the exported changes"}
+	}
+	for _, c := range exported.Changes {
+		if err := validateChange(c); err != nil {
+			return err
+		}
+	}
+	for _, c := range exported.Changes {
+		if err := cl.Add(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateChange makes sure that an imported change is one that could have
+// been created by a notary client
+func validateChange(c *TufChange) error {
+	if c == nil {
+		return ErrBadExport{Reason: "empty change"}
+	}
+	switch c.Action() {
+	case ActionCreate, ActionUpdate, ActionDelete, ActionBase:
+	default:
+		return ErrBadExport{Reason: fmt.Sprintf("unknown action %s", c.Action())}
+	}
+	switch {
+	case c.Scope() == ScopeRoot:
+		if c.Type() != TypeRootRole {
+			return ErrBadExport{Reason: fmt.Sprintf("unknown type %s for scope %s", c.Type(), c.Scope())}
+		}
+	case c.Scope() == ScopeTargets || data.IsDelegation(c.Scope()):
+		if c.Type() != TypeTargetsTarget && c.Type() != TypeTargetsDelegation {
+			return ErrBadExport{Reason: fmt.Sprintf("unknown type %s for scope %s", c.Type(), c.Scope())}
+		}
+	default:
+		return ErrBadExport{Reason: fmt.Sprintf("unsupported scope %s", c.Scope())}
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package changelist
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func exportTestChanges(t *testing.T) (Changelist, *bytes.Buffer) {
+	cl := NewMemChangelist()
+	assert.NoError(t, cl.Add(NewTufChange(ActionCreate, "targets", TypeTargetsTarget, "test/targ1", []byte{1})))
+	assert.NoError(t, cl.Add(NewTufChange(ActionDelete, "targets/level1", TypeTargetsTarget, "test/targ2", nil)))
+	assert.NoError(t, cl.Add(NewTufChange(ActionCreate, "targets/level1", TypeTargetsDelegation, "", []byte("{}"))))
+
+	buf := new(bytes.Buffer)
+	assert.NoError(t, Export(cl, "docker.com/notary", buf))
+	return cl, buf
+}
+
+func TestExportImportRoundTrip(t *testing.T) {
+	cl, buf := exportTestChanges(t)
+
+	imported := NewMemChangelist()
+	assert.NoError(t, Import(imported, "docker.com/notary", buf))
+
+	expected := cl.List()
+	actual := imported.List()
+	assert.Len(t, actual, len(expected))
+	for i := range expected {
+		assert.Equal(t, expected[i].Action(), actual[i].Action(), "Action mismatch")
+		assert.Equal(t, expected[i].Scope(), actual[i].Scope(), "Scope mismatch")
+		assert.Equal(t, expected[i].Type(), actual[i].Type(), "Type mismatch")
+		assert.Equal(t, expected[i].Path(), actual[i].Path(), "Path mismatch")
+		assert.Equal(t, expected[i].Content(), actual[i].Content(), "Content mismatch")
+	}
+}
+
+func TestImportMismatchedGUN(t *testing.T) {
+	_, buf := exportTestChanges(t)
+
+	imported := NewMemChangelist()
+	err := Import(imported, "docker.com/other", buf)
+	assert.Error(t, err)
+	assert.IsType(t, ErrGUNMismatch{}, err)
+	assert.Len(t, imported.List(), 0)
+}
+
+func TestImportTamperedChanges(t *testing.T) {
+	_, buf := exportTestChanges(t)
+
+	var exported exportedChangelist
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &exported))
+	exported.Changes[0].ChangePath = "test/evil"
+	tampered, err := json.Marshal(exported)
+	assert.NoError(t, err)
+
+	imported := NewMemChangelist()
+	err = Import(imported, "docker.com/notary", bytes.NewBuffer(tampered))
+	assert.Error(t, err)
+	assert.IsType(t, ErrBadExport{}, err)
+	assert.Len(t, imported.List(), 0)
+}
+
+func TestImportInvalidChange(t *testing.T) {
+	cl := NewMemChangelist()
+	assert.NoError(t, cl.Add(NewTufChange(ActionCreate, "targets", TypeTargetsTarget, "test/targ1", []byte{1})))
+	assert.NoError(t, cl.Add(NewTufChange(ActionCreate, "timestamp", TypeRootRole, "", nil)))
+	buf := new(bytes.Buffer)
+	assert.NoError(t, Export(cl, "docker.com/notary", buf))
+
+	imported := NewMemChangelist()
+	err := Import(imported, "docker.com/notary", buf)
+	assert.Error(t, err)
+	assert.IsType(t, ErrBadExport{}, err)
+	// nothing is imported if any of the changes are invalid
+	assert.Len(t, imported.List(), 0)
+}
+
+func TestImportMalformed(t *testing.T) {
+	imported := NewMemChangelist()
+	err := Import(imported, "docker.com/notary", bytes.NewBufferString("not json"))
+	assert.Error(t, err)
+	assert.IsType(t, ErrBadExport{}, err)
+}
@@ -31,6 +31,9 @@ const (
 	ActionUpdate = "update"
 	// ActionDelete represents a Delete action
 	ActionDelete = "delete"
+	// ActionBase represents resetting the delegations of a role to those of
+	// an earlier version of its metadata
+	ActionBase = "base"
 )
 
 // Change is the interface for a TUF Change
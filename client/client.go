@@ -2,27 +2,36 @@ package client
 
 import (
 	"bytes"
+	"crypto/subtle"
+	"crypto/x509"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"net/url"
 	"os"
+	"path"
 	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/Sirupsen/logrus"
+	canonicaljson "github.com/docker/go/canonical/json"
 	"github.com/docker/notary"
 	"github.com/docker/notary/certs"
 	"github.com/docker/notary/client/changelist"
 	"github.com/docker/notary/cryptoservice"
+	"github.com/docker/notary/passphrase"
 	"github.com/docker/notary/trustmanager"
 	"github.com/docker/notary/tuf"
 	tufclient "github.com/docker/notary/tuf/client"
 	"github.com/docker/notary/tuf/data"
 	"github.com/docker/notary/tuf/signed"
 	"github.com/docker/notary/tuf/store"
+	"github.com/docker/notary/tuf/utils"
 )
 
 func init() {
@@ -65,28 +74,219 @@ func (err ErrRepositoryNotExist) Error() string {
 	return fmt.Sprintf("%s does not have trust data for %s", err.remote, err.gun)
 }
 
+// ErrAlgorithmNotAllowed is returned when a delegation key is of a key type
+// that is not in the repository's allowed algorithms
+type ErrAlgorithmNotAllowed struct {
+	Role    string
+	KeyID   string
+	KeyType string
+}
+
+func (err ErrAlgorithmNotAllowed) Error() string {
+	return fmt.Sprintf(
+		"key %s of %s is a %s key, which is not an allowed algorithm", err.KeyID, err.Role, err.KeyType)
+}
+
+// ErrKeyRevoked is returned when a key of a delegation is on the list of
+// revoked keys
+type ErrKeyRevoked struct {
+	Role  string
+	KeyID string
+}
+
+func (err ErrKeyRevoked) Error() string {
+	return fmt.Sprintf("key %s of %s has been revoked", err.KeyID, err.Role)
+}
+
+// ErrVersionNotInHistory is returned when a version of the metadata of a role
+// is not in the history of metadata downloaded by this client
+type ErrVersionNotInHistory struct {
+	Role    string
+	Version int
+}
+
+func (err ErrVersionNotInHistory) Error() string {
+	return fmt.Sprintf("version %d of %s is not in the history of downloaded metadata", err.Version, err.Role)
+}
+
+// ErrExpiresSoon is returned when something that a repository is verified
+// with expires within the StrictExpiry window, and so is treated as expired
+type ErrExpiresSoon struct {
+	Role    string
+	What    string
+	Expires time.Time
+	Window  time.Duration
+}
+
+func (err ErrExpiresSoon) Error() string {
+	return fmt.Sprintf("the %s of %s expires at %s, within the strict expiry window of %s",
+		err.What, err.Role, err.Expires.UTC().Format(time.RFC3339), err.Window)
+}
+
+// ErrUnexpectedServerKey is returned when the metadata of a role that the
+// server signs is not signed by any of the keys pinned for the role
+type ErrUnexpectedServerKey struct {
+	Role   string
+	KeyIDs []string
+}
+
+func (err ErrUnexpectedServerKey) Error() string {
+	return fmt.Sprintf("the %s metadata is not signed by any of the server keys pinned for it, but by %s",
+		err.Role, strings.Join(err.KeyIDs, ", "))
+}
+
+// ErrNoSuchTarget is returned when the trusted metadata of a repository
+// doesn't have a target with the requested name
+type ErrNoSuchTarget struct {
+	Name string
+}
+
+func (err ErrNoSuchTarget) Error() string {
+	return fmt.Sprintf("No trust data for %s", err.Name)
+}
+
+// ErrTargetMismatch is returned when data being verified doesn't match the
+// length or hashes of the trusted target it was verified against
+type ErrTargetMismatch struct {
+	Name string
+	Role string
+}
+
+func (err ErrTargetMismatch) Error() string {
+	return fmt.Sprintf("data does not match the target %s trusted by %s", err.Name, err.Role)
+}
+
+// ErrUntrustedRole is returned when a target is verified against a set of
+// trusted roles, but the trusted target is signed by a role outside the set
+type ErrUntrustedRole struct {
+	Name         string
+	Role         string
+	TrustedRoles []string
+}
+
+func (err ErrUntrustedRole) Error() string {
+	return fmt.Sprintf("the target %s is signed by %s, which is not one of the trusted roles: %s",
+		err.Name, err.Role, strings.Join(err.TrustedRoles, ", "))
+}
+
 const (
 	tufDir = "tuf"
+
+	// DefaultHistoryLimit is how many versions of the metadata of each role
+	// are kept in the history, besides the latest, unless HistoryLimit is set
+	DefaultHistoryLimit = 10
 )
 
 // NotaryRepository stores all the information needed to operate on a notary
-// repository.
+// repository.  It is safe for concurrent use by multiple goroutines: the
+// operations that update the cached metadata or read or stage changes are
+// serialized, so that for instance a publish never clears a change staged
+// while it was signing.
 type NotaryRepository struct {
 	baseDir       string
 	gun           string
 	baseURL       string
 	tufRepoPath   string
 	fileStore     store.MetadataStore
+	historyStore  store.ModTimeStore
 	CryptoService signed.CryptoService
 	tufRepo       *tuf.Repo
 	roundTrip     http.RoundTripper
 	CertStore     trustmanager.X509Store
+
+	// AllowedAlgorithms are the only key types, as named by
+	// trustmanager.KeyType (e.g. "ECDSA P-256" or "RSA 2048"), that delegation
+	// keys may have.  Any key type is allowed if it is empty.
+	AllowedAlgorithms []string
+
+	// RevokedKeys are the IDs of keys that are no longer trusted, either the
+	// ID the key is listed under or its canonical ID.  Adding a revoked key to
+	// a delegation fails with ErrKeyRevoked, as does reading from a repository
+	// where any delegation has a revoked key.
+	RevokedKeys []string
+
+	// MaxTimestampAge, if set, is how long ago the cached timestamp can have
+	// been fetched from the server for it to be used when the server can't
+	// be reached.  An older timestamp is always downloaded again in full.
+	MaxTimestampAge time.Duration
+
+	// MaxMetadataSizes, if set, is the largest size in bytes of the metadata
+	// of each role that is downloaded from the server, instead of the size in
+	// tufclient.DefaultMaxMetadataSizes.  A delegation without a size of its
+	// own is limited to the size of targets.
+	MaxMetadataSizes map[string]int64
+
+	// HistoryLimit is how many versions of the metadata of each targets and
+	// delegation role are kept in the history, besides the latest one, when
+	// it is pruned after every publish.  0 keeps only the latest version, and a
+	// negative limit keeps every version.
+	HistoryLimit int
+
+	// OnlyRoles, if set, limits the delegations that are downloaded and
+	// verified when reading from the repository to these roles, their
+	// ancestors and their descendants.  Publishing always uses every role.
+	OnlyRoles []string
+
+	// NoCache, if set, makes every update download all of the metadata from
+	// the server and verify it, rather than use the cached copies even if
+	// they are still valid, starting from a root that is validated as on
+	// first use.  The cache is updated with the downloaded metadata.
+	NoCache bool
+
+	// StrictExpiry, if set, makes reading from the repository fail if the
+	// metadata of any of its roles, a certificate of any of their keys or a
+	// delegation expires within that long, as if it had already expired, so
+	// that they have to be renewed in good time.  The timestamp, and the
+	// snapshot if the server manages it, are only renewed by the server once
+	// they expire, so they are left out.
+	StrictExpiry time.Duration
+
+	// AsOf, if set, is the time at which reading from the repository checks
+	// the metadata of its roles, the certificates of its root keys and its
+	// delegations for expiry, instead of the present time, so that whether
+	// the repository was validly trusted at some point in the past can be
+	// checked after the fact.  The StrictExpiry window is counted from it too.
+	AsOf time.Time
+
+	// ServerKeyPins, if set, are the IDs of the keys that the server is
+	// expected to sign the metadata of the timestamp role, and of the snapshot
+	// role if the server manages it, with, by role.  Every update then fails
+	// with ErrUnexpectedServerKey unless the metadata of each of those roles
+	// has a valid signature by one of the keys pinned for it.  The keys can be
+	// given by either the ID the role lists them under or their canonical ID.
+	ServerKeyPins map[string][]string
+
+	// Telemetry, if set, is told about every download of metadata from the
+	// server, every rejection of that metadata and every signing of the
+	// metadata of a role to publish it, so that they can be monitored.
+	Telemetry Telemetry
+
+	// the roles sent to the server by the last successful publish, and how
+	// many signatures each of them was sent with
+	publishedRoles      []string
+	publishedSignatures []RoleSignatures
+
+	// lock serializes the operations on the cached metadata and the changelist
+	lock sync.Mutex
 }
 
-// repositoryFromKeystores is a helper function for NewNotaryRepository that
-// takes some basic NotaryRepository parameters as well as keystores (in order
-// of usage preference), and returns a NotaryRepository.
-func repositoryFromKeystores(baseDir, gun, baseURL string, rt http.RoundTripper,
+// NewNotaryRepository is a helper method that returns a new notary repository.
+// It takes the base directory under where all the trust files will be stored
+// (usually ~/.docker/trust/).  rt is used for every request to the server, so
+// it can be any http.RoundTripper, for instance one that wraps a transport to
+// add tracing or authentication headers; if it is nil, the repository can only
+// be used offline.
+func NewNotaryRepository(baseDir, gun, baseURL string, rt http.RoundTripper,
+	retriever passphrase.Retriever) (
+	*NotaryRepository, error) {
+
+	return NewNotaryRepositoryWithCache(baseDir, baseDir, gun, baseURL, rt, retriever)
+}
+
+// repositoryFromKeystores is a helper function for NewNotaryRepositoryWithCache
+// that takes some basic NotaryRepository parameters as well as keystores (in
+// order of usage preference), and returns a NotaryRepository.
+func repositoryFromKeystores(baseDir, cacheDir, gun, baseURL string, rt http.RoundTripper,
 	keyStores []trustmanager.KeyStore) (*NotaryRepository, error) {
 
 	certPath := filepath.Join(baseDir, notary.TrustedCertsDir)
@@ -108,10 +308,15 @@ func repositoryFromKeystores(baseDir, gun, baseURL string, rt http.RoundTripper,
 		CryptoService: cryptoService,
 		roundTrip:     rt,
 		CertStore:     certStore,
+		HistoryLimit:  DefaultHistoryLimit,
 	}
 
+	// the metadata cache has the same layout as under the base directory
+	if cacheDir == "" {
+		cacheDir = baseDir
+	}
 	fileStore, err := store.NewFilesystemStore(
-		nRepo.tufRepoPath,
+		filepath.Join(cacheDir, tufDir, filepath.FromSlash(gun)),
 		"metadata",
 		"json",
 	)
@@ -120,15 +325,28 @@ func repositoryFromKeystores(baseDir, gun, baseURL string, rt http.RoundTripper,
 	}
 	nRepo.fileStore = fileStore
 
+	// every version of the targets metadata that is downloaded is kept next
+	// to the cache, by version number
+	historyStore, err := store.NewFilesystemStore(
+		filepath.Join(cacheDir, tufDir, filepath.FromSlash(gun)),
+		"history",
+		"json",
+	)
+	if err != nil {
+		return nil, err
+	}
+	nRepo.historyStore = historyStore
+
 	return nRepo, nil
 }
 
 // Target represents a simplified version of the data TUF operates on, so external
 // applications don't have to depend on tuf data types.
 type Target struct {
-	Name   string      // the name of the target
-	Hashes data.Hashes // the hash of the target
-	Length int64       // the size in bytes of the target
+	Name   string          // the name of the target
+	Hashes data.Hashes     // the hash of the target
+	Length int64           // the size in bytes of the target
+	Custom json.RawMessage // the custom metadata of the target, if any
 }
 
 // TargetWithRole represents a Target that exists in a particular role - this is
@@ -153,9 +371,40 @@ func NewTarget(targetName string, targetPath string) (*Target, error) {
 	return &Target{Name: targetName, Hashes: meta.Hashes, Length: meta.Length}, nil
 }
 
+// rootCertKey generates a self-signed certificate for a root key of the given
+// GUN, and returns it along with the public key to store in the root metadata
+func rootCertKey(gun string, privKey data.PrivateKey) (*x509.Certificate, data.PublicKey, error) {
+	// Hard-coded policy: the generated certificate expires in 10 years.
+	startTime := time.Now()
+	rootCert, err := cryptoservice.GenerateCertificate(
+		privKey, gun, startTime, startTime.AddDate(10, 0, 0))
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// The root key gets stored in the TUF metadata X509 encoded, linking
+	// the tuf root.json to our X509 PKI.
+	// If the key is RSA, we store it as type RSAx509, if it is ECDSA we store it
+	// as ECDSAx509 to allow the gotuf verifiers to correctly decode the
+	// key on verification of signatures.
+	var rootKey data.PublicKey
+	switch privKey.Algorithm() {
+	case data.RSAKey:
+		rootKey = data.NewRSAx509PublicKey(trustmanager.CertToPEM(rootCert))
+	case data.ECDSAKey:
+		rootKey = data.NewECDSAx509PublicKey(trustmanager.CertToPEM(rootCert))
+	default:
+		return nil, nil, fmt.Errorf("invalid format for root key: %s", privKey.Algorithm())
+	}
+	return rootCert, rootKey, nil
+}
+
 // Initialize creates a new repository by using rootKey as the root Key for the
 // TUF repository.
 func (r *NotaryRepository) Initialize(rootKeyID string, serverManagedRoles ...string) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
 	privKey, _, err := r.CryptoService.GetPrivateKey(rootKeyID)
 	if err != nil {
 		return err
@@ -188,31 +437,12 @@ func (r *NotaryRepository) Initialize(rootKeyID string, serverManagedRoles ...st
 		}
 	}
 
-	// Hard-coded policy: the generated certificate expires in 10 years.
-	startTime := time.Now()
-	rootCert, err := cryptoservice.GenerateCertificate(
-		privKey, r.gun, startTime, startTime.AddDate(10, 0, 0))
-
+	rootCert, rootKey, err := rootCertKey(r.gun, privKey)
 	if err != nil {
 		return err
 	}
 	r.CertStore.AddCert(rootCert)
 
-	// The root key gets stored in the TUF metadata X509 encoded, linking
-	// the tuf root.json to our X509 PKI.
-	// If the key is RSA, we store it as type RSAx509, if it is ECDSA we store it
-	// as ECDSAx509 to allow the gotuf verifiers to correctly decode the
-	// key on verification of signatures.
-	var rootKey data.PublicKey
-	switch privKey.Algorithm() {
-	case data.RSAKey:
-		rootKey = data.NewRSAx509PublicKey(trustmanager.CertToPEM(rootCert))
-	case data.ECDSAKey:
-		rootKey = data.NewECDSAx509PublicKey(trustmanager.CertToPEM(rootCert))
-	default:
-		return fmt.Errorf("invalid format for root key: %s", privKey.Algorithm())
-	}
-
 	var (
 		rootRole = data.NewBaseRole(
 			data.CanonicalRootRole,
@@ -337,7 +567,8 @@ func addChange(cl *changelist.FileChangelist, c changelist.Change, roles ...stri
 // in the repository when the changelist gets applied at publish time.
 // If roles are unspecified, the default role is "targets".
 func (r *NotaryRepository) AddTarget(target *Target, roles ...string) error {
-
+	r.lock.Lock()
+	defer r.lock.Unlock()
 	cl, err := changelist.NewFileChangelist(filepath.Join(r.tufRepoPath, "changelist"))
 	if err != nil {
 		return err
@@ -346,6 +577,20 @@ func (r *NotaryRepository) AddTarget(target *Target, roles ...string) error {
 	logrus.Debugf("Adding target \"%s\" with sha256 \"%x\" and size %d bytes.\n", target.Name, target.Hashes["sha256"], target.Length)
 
 	meta := data.FileMeta{Length: target.Length, Hashes: target.Hashes}
+	if len(target.Custom) > 0 {
+		// the signatures are checked against the canonical JSON of the whole
+		// targets metadata, so the custom metadata has to be canonical too
+		var decoded interface{}
+		if err := canonicaljson.Unmarshal(target.Custom, &decoded); err != nil {
+			return fmt.Errorf("the custom metadata of %s is not valid JSON: %v", target.Name, err)
+		}
+		custom, err := canonicaljson.MarshalCanonical(decoded)
+		if err != nil {
+			return fmt.Errorf("the custom metadata of %s can't be signed: %v", target.Name, err)
+		}
+		rawCustom := canonicaljson.RawMessage(custom)
+		meta.Custom = &rawCustom
+	}
 	metaJSON, err := json.Marshal(meta)
 	if err != nil {
 		return err
@@ -361,7 +606,8 @@ func (r *NotaryRepository) AddTarget(target *Target, roles ...string) error {
 // roles in the repository when the changelist gets applied at publish time.
 // If roles are unspecified, the default role is "target".
 func (r *NotaryRepository) RemoveTarget(targetName string, roles ...string) error {
-
+	r.lock.Lock()
+	defer r.lock.Unlock()
 	cl, err := changelist.NewFileChangelist(filepath.Join(r.tufRepoPath, "changelist"))
 	if err != nil {
 		return err
@@ -380,7 +626,9 @@ func (r *NotaryRepository) RemoveTarget(targetName string, roles ...string) erro
 // subtree and also the "targets/x" subtree, as we will defer parsing it until
 // we explicitly reach it in our iteration of the provided list of roles.
 func (r *NotaryRepository) ListTargets(roles ...string) ([]*TargetWithRole, error) {
-	_, err := r.Update(false)
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	_, err := r.update(false)
 	if err != nil {
 		return nil, err
 	}
@@ -421,7 +669,7 @@ func (r *NotaryRepository) listSubtree(targets map[string]*TargetWithRole, role
 		for name, meta := range tgts.Signed.Targets {
 			if _, ok := targets[name]; !ok {
 				targets[name] = &TargetWithRole{
-					Target: Target{Name: name, Hashes: meta.Hashes, Length: meta.Length}, Role: role}
+					Target: Target{Name: name, Hashes: meta.Hashes, Length: meta.Length, Custom: customMetadata(meta)}, Role: role}
 			}
 		}
 		for _, d := range tgts.Signed.Delegations.Roles {
@@ -432,6 +680,14 @@ func (r *NotaryRepository) listSubtree(targets map[string]*TargetWithRole, role
 	}
 }
 
+// customMetadata returns the custom metadata of a target, if it has any
+func customMetadata(meta data.FileMeta) json.RawMessage {
+	if meta.Custom == nil {
+		return nil
+	}
+	return []byte(*meta.Custom)
+}
+
 // GetTargetByName returns a target given a name. If no roles are passed
 // it uses the targets role and does a search of the entire delegation
 // graph, finding the first entry in a breadth first search of the delegations.
@@ -440,7 +696,13 @@ func (r *NotaryRepository) listSubtree(targets map[string]*TargetWithRole, role
 // will be returned
 // See the IMPORTANT section on ListTargets above. Those roles also apply here.
 func (r *NotaryRepository) GetTargetByName(name string, roles ...string) (*TargetWithRole, error) {
-	c, err := r.Update(false)
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.getTargetByName(name, roles...)
+}
+
+func (r *NotaryRepository) getTargetByName(name string, roles ...string) (*TargetWithRole, error) {
+	c, err := r.update(false)
 	if err != nil {
 		return nil, err
 	}
@@ -452,14 +714,85 @@ func (r *NotaryRepository) GetTargetByName(name string, roles ...string) (*Targe
 		meta, foundRole := c.TargetMeta(role, name, roles...)
 		if meta != nil {
 			return &TargetWithRole{
-				Target: Target{Name: name, Hashes: meta.Hashes, Length: meta.Length}, Role: foundRole}, nil
+				Target: Target{Name: name, Hashes: meta.Hashes, Length: meta.Length, Custom: customMetadata(*meta)}, Role: foundRole}, nil
+		}
+	}
+	return nil, ErrNoSuchTarget{Name: name}
+
+}
+
+// VerifyTarget checks that the data read from the reader is the target with
+// the given name in the repository for the given GUN, as trusted by the
+// latest metadata, searching the whole delegation graph like GetTargetByName.
+// The data is streamed rather than read into memory.  It returns an
+// ErrNoSuchTarget if there is no trusted target with that name, and an
+// ErrTargetMismatch if the data is not that target.  If trusted roles are
+// given, the target is looked up in them only, in the order given, and the
+// data is compared with the target as signed by the first of them to sign it.
+// An ErrUntrustedRole is returned if none of them signs the target, even if
+// the data matches the target as signed by another role.
+func (r *NotaryRepository) VerifyTarget(gun, name string, content io.Reader, trustedRoles ...string) (bool, error) {
+	if gun != r.gun {
+		return false, fmt.Errorf("cannot verify a target of %s with the repository for %s", gun, r.gun)
+	}
+	for _, role := range trustedRoles {
+		if role != data.CanonicalTargetsRole && !data.IsDelegation(role) {
+			return false, data.ErrInvalidRole{Role: role, Reason: "only targets and delegation roles sign targets"}
+		}
+	}
+
+	target, err := r.GetTargetByName(name, trustedRoles...)
+	if _, ok := err.(ErrNoSuchTarget); ok && len(trustedRoles) > 0 {
+		// tell a target that only untrusted roles sign from a missing one
+		if untrusted, err := r.GetTargetByName(name); err == nil {
+			return false, ErrUntrustedRole{Name: name, Role: untrusted.Role, TrustedRoles: trustedRoles}
+		}
+	}
+	if err != nil {
+		return false, err
+	}
+	// the lookup also searches the delegations of the trusted roles
+	if len(trustedRoles) > 0 && !isTrustedRole(target.Role, trustedRoles) {
+		return false, ErrUntrustedRole{Name: name, Role: target.Role, TrustedRoles: trustedRoles}
+	}
+
+	var hashAlgorithms []string
+	for _, hashAlgorithm := range []string{"sha256", "sha512"} {
+		if _, ok := target.Hashes[hashAlgorithm]; ok {
+			hashAlgorithms = append(hashAlgorithms, hashAlgorithm)
 		}
 	}
-	return nil, fmt.Errorf("No trust data for %s", name)
+	if len(hashAlgorithms) == 0 {
+		return false, fmt.Errorf("target %s has no supported hashes to verify against", name)
+	}
+
+	meta, err := data.NewFileMeta(content, hashAlgorithms...)
+	if err != nil {
+		return false, err
+	}
+	if meta.Length != target.Length {
+		return false, ErrTargetMismatch{Name: name, Role: target.Role}
+	}
+	for _, hashAlgorithm := range hashAlgorithms {
+		if subtle.ConstantTimeCompare(meta.Hashes[hashAlgorithm], target.Hashes[hashAlgorithm]) == 0 {
+			return false, ErrTargetMismatch{Name: name, Role: target.Role}
+		}
+	}
+	return true, nil
+}
 
+func isTrustedRole(role string, trustedRoles []string) bool {
+	for _, trusted := range trustedRoles {
+		if role == trusted {
+			return true
+		}
+	}
+	return false
 }
 
-// GetChangelist returns the list of the repository's unpublished changes
+// GetChangelist returns the list of the repository's unpublished changes.
+// Using the changelist directly is not serialized with the operations of the
+// repository, so it should not be changed while the repository is in use.
 func (r *NotaryRepository) GetChangelist() (changelist.Changelist, error) {
 	changelistDir := filepath.Join(r.tufRepoPath, "changelist")
 	cl, err := changelist.NewFileChangelist(changelistDir)
@@ -470,6 +803,34 @@ func (r *NotaryRepository) GetChangelist() (changelist.Changelist, error) {
 	return cl, nil
 }
 
+// ExportChangelist writes the repository's unpublished changes to the writer
+// in a portable format that can be loaded on another host with
+// ImportChangelist
+func (r *NotaryRepository) ExportChangelist(w io.Writer) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	cl, err := r.GetChangelist()
+	if err != nil {
+		return err
+	}
+	defer cl.Close()
+	return changelist.Export(cl, r.gun, w)
+}
+
+// ImportChangelist adds the changes exported by ExportChangelist to the
+// repository's unpublished changes.  The changes must have been exported for
+// the same GUN as this repository.
+func (r *NotaryRepository) ImportChangelist(rd io.Reader) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	cl, err := r.GetChangelist()
+	if err != nil {
+		return err
+	}
+	defer cl.Close()
+	return changelist.Import(cl, r.gun, rd)
+}
+
 // RoleWithSignatures is a Role with its associated signatures
 type RoleWithSignatures struct {
 	Signatures []data.Signature
@@ -479,8 +840,10 @@ type RoleWithSignatures struct {
 // ListRoles returns a list of RoleWithSignatures objects for this repo
 // This represents the latest metadata for each role in this repo
 func (r *NotaryRepository) ListRoles() ([]RoleWithSignatures, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
 	// Update to latest repo state
-	_, err := r.Update(false)
+	_, err := r.update(false)
 	if err != nil {
 		return nil, err
 	}
@@ -517,46 +880,231 @@ func (r *NotaryRepository) ListRoles() ([]RoleWithSignatures, error) {
 	return roleWithSigs, nil
 }
 
+// ListRoleKeys returns the public keys of each role in this repo, including
+// the delegations, indexed by role name and then by the key ID the role
+// lists the key under
+func (r *NotaryRepository) ListRoleKeys() (map[string]data.Keys, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if _, err := r.update(false); err != nil {
+		return nil, err
+	}
+
+	roleKeys := make(map[string]data.Keys)
+	for _, role := range r.tufRepo.GetAllLoadedRoles() {
+		if data.IsDelegation(role.Name) {
+			_, keys, err := r.tufRepo.GetDelegation(role.Name)
+			if err != nil {
+				return nil, err
+			}
+			roleKeys[role.Name] = keys
+			continue
+		}
+		baseRole, err := r.tufRepo.GetBaseRole(role.Name)
+		if err != nil {
+			return nil, err
+		}
+		roleKeys[role.Name] = baseRole.Keys
+	}
+	return roleKeys, nil
+}
+
 // Publish pushes the local changes in signed material to the remote notary-server
 // Conceptually it performs an operation similar to a `git rebase`
 func (r *NotaryRepository) Publish() error {
-	var initialPublish bool
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.publish(nil)
+}
+
+// PublishWithSigningKey is like Publish, but signs the targets and delegation
+// roles being published with only the key with the given ID, instead of every
+// available key for each role.  It errors if the key is not one of the keys
+// of a role that needs to be signed.
+func (r *NotaryRepository) PublishWithSigningKey(keyID string) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if keyID == "" {
+		return fmt.Errorf("no signing key ID specified")
+	}
+	return r.publish([]string{keyID})
+}
+
+// PublishWithSigningKeys is like PublishWithSigningKey, but signs the targets
+// and delegation roles being published with each of the keys with the given
+// IDs, so that a role with a threshold above 1 can be signed with enough of
+// its keys at once.  Every key has to be one of the keys of each role that
+// needs to be signed.
+func (r *NotaryRepository) PublishWithSigningKeys(keyIDs []string) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if len(keyIDs) == 0 {
+		return fmt.Errorf("no signing key ID specified")
+	}
+	for _, keyID := range keyIDs {
+		if keyID == "" {
+			return fmt.Errorf("no signing key ID specified")
+		}
+	}
+	return r.publish(keyIDs)
+}
+
+func (r *NotaryRepository) publish(signingKeyIDs []string) error {
 	// update first before publishing
-	_, err := r.Update(true)
+	initialPublish, err := r.updateForPublish()
 	if err != nil {
-		// If the remote is not aware of the repo, then this is being published
-		// for the first time.  Try to load from disk instead for publishing.
-		if _, ok := err.(ErrRepositoryNotExist); ok {
-			err := r.bootstrapRepo()
-			if err != nil {
-				logrus.Debugf("Unable to load repository from local files: %s",
-					err.Error())
-				if _, ok := err.(store.ErrMetaNotFound); ok {
-					return ErrRepoNotInitialized{}
-				}
-				return err
-			}
-			// Ensure we will push the initial root and targets file.  Either or
-			// both of the root and targets may not be marked as Dirty, since
-			// there may not be any changes that update them, so use a
-			// different boolean.
-			initialPublish = true
-		} else {
-			// We could not update, so we cannot publish.
-			logrus.Error("Could not publish Repository: ", err.Error())
-			return err
-		}
+		return err
+	}
+
+	// drop the old root keys if a root key rotation overlap has ended
+	if err := r.finishRootOverlap(); err != nil {
+		return err
 	}
 
 	cl, err := r.GetChangelist()
 	if err != nil {
 		return err
 	}
+
+	// keep a copy of the repo from before the changelist is applied, and
+	// restore it if any part of the publish fails, so that a publish that is
+	// rejected does not leave partially applied changes behind
+	original, err := r.tufRepo.Copy()
+	if err != nil {
+		return err
+	}
+	published, err := r.publishChangelist(cl, initialPublish, signingKeyIDs)
+	if err != nil {
+		r.tufRepo = original
+		return err
+	}
+	r.publishedRoles = published
+	r.publishedSignatures = r.signatureCounts(published)
+	r.pruneTargetsHistory()
+
+	err = cl.Clear("")
+	if err != nil {
+		// This is not a critical problem when only a single host is pushing
+		// but will cause weird behaviour if changelist cleanup is failing
+		// and there are multiple hosts writing to the repo.
+		logrus.Warn("Unable to clear changelist. You may want to manually delete the folder ", filepath.Join(r.tufRepoPath, "changelist"))
+	}
+	if err := r.clearSigningRequests(); err != nil {
+		logrus.Warn("Unable to clear the signing requests. You may want to manually delete ", filepath.Join(r.tufRepoPath, externalSigningFile))
+	}
+	return nil
+}
+
+// updateForPublish updates the repo before its changes are signed, and
+// returns whether it is being published for the first time, in which case it
+// is loaded from the local files instead.
+func (r *NotaryRepository) updateForPublish() (bool, error) {
+	_, err := r.update(true)
+	if err == nil {
+		return false, nil
+	}
+	// If the remote is not aware of the repo, then this is being published
+	// for the first time.  Try to load from disk instead for publishing.
+	if _, ok := err.(ErrRepositoryNotExist); ok {
+		err := r.bootstrapRepo()
+		if err != nil {
+			logrus.Debugf("Unable to load repository from local files: %s",
+				err.Error())
+			if _, ok := err.(store.ErrMetaNotFound); ok {
+				return false, ErrRepoNotInitialized{}
+			}
+			return false, err
+		}
+		// Ensure we will push the initial root and targets file.  Either or
+		// both of the root and targets may not be marked as Dirty, since
+		// there may not be any changes that update them, so use a
+		// different boolean.
+		return true, nil
+	}
+	// We could not update, so we cannot publish.
+	logrus.Error("Could not publish Repository: ", err.Error())
+	return false, err
+}
+
+// WritePendingMetadata signs the metadata that the next Publish would send to
+// the server, with the staged changes applied, and writes it to the given
+// directory as one JSON file per role for offline review.  Nothing is sent to
+// the server and the changelist is left as it is.  It returns the sorted names
+// of the roles written.  The removal of the old root keys at the end of a root
+// key rotation overlap is only staged by Publish, so it is not included.
+func (r *NotaryRepository) WritePendingMetadata(dir string) ([]string, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	initialPublish, err := r.updateForPublish()
+	if err != nil {
+		return nil, err
+	}
+	cl, err := r.GetChangelist()
+	if err != nil {
+		return nil, err
+	}
+
+	// the changes are only applied to be signed, so put the repo back the way
+	// it was afterwards
+	original, err := r.tufRepo.Copy()
+	if err != nil {
+		return nil, err
+	}
+	defer func() { r.tufRepo = original }()
+
+	updatedFiles, err := r.signChangelist(cl, initialPublish, nil)
+	if err != nil {
+		return nil, err
+	}
+	outputStore, err := store.NewFilesystemStore(dir, "", "json")
+	if err != nil {
+		return nil, err
+	}
+	if err := outputStore.SetMultiMeta(updatedFiles); err != nil {
+		return nil, err
+	}
+	return sortedRoleNames(updatedFiles), nil
+}
+
+// publishChangelist applies the changelist to the repo, then signs all the
+// metadata that needs updating and sends it to the server in a single
+// request, so that the server either accepts all of it or none of it.  It
+// returns the sorted names of the roles that were sent.
+func (r *NotaryRepository) publishChangelist(cl changelist.Changelist, initialPublish bool, signingKeyIDs []string) ([]string, error) {
+	updatedFiles, err := r.signChangelist(cl, initialPublish, signingKeyIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	remote, err := getRemoteStore(r.baseURL, r.gun, r.roundTrip)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := remote.SetMultiMeta(updatedFiles); err != nil {
+		return nil, err
+	}
+	return sortedRoleNames(updatedFiles), nil
+}
+
+// signChangelist applies the changelist to the repo, and returns the signed
+// metadata of every role that needs updating, by role name.
+func (r *NotaryRepository) signChangelist(cl changelist.Changelist, initialPublish bool, signingKeyIDs []string) (map[string][]byte, error) {
+	// the roles with a signing request are signed externally, so their
+	// changes can be applied without their signing keys
+	requests, err := r.loadSigningRequests()
+	if err != nil {
+		return nil, err
+	}
+	for roleName := range requests {
+		r.tufRepo.SignExternally(roleName)
+	}
+
 	// apply the changelist to the repo
 	err = applyChangelist(r.tufRepo, cl)
 	if err != nil {
 		logrus.Debug("Error applying changelist")
-		return err
+		return nil, err
 	}
 
 	// these are the tuf files we will need to update, serialized as JSON before
@@ -567,25 +1115,42 @@ func (r *NotaryRepository) Publish() error {
 	// root is not dirty but we are publishing for the first time, then just
 	// publish the existing root we have.
 	if nearExpiry(r.tufRepo.Root) || r.tufRepo.Root.Dirty {
+		start := time.Now()
 		rootJSON, err := serializeCanonicalRole(r.tufRepo, data.CanonicalRootRole)
+		r.reportSigned(data.CanonicalRootRole, start, err)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		updatedFiles[data.CanonicalRootRole] = rootJSON
 	} else if initialPublish {
 		rootJSON, err := r.tufRepo.Root.MarshalJSON()
 		if err != nil {
-			return err
+			return nil, err
 		}
 		updatedFiles[data.CanonicalRootRole] = rootJSON
 	}
 
+	// the roles with a signing request are sent with the detached signatures
+	// applied to them, whether or not they have changed
+	for roleName, s := range requests {
+		targetsJSON, err := useExternalSignatures(r.tufRepo, roleName, s)
+		if err != nil {
+			return nil, err
+		}
+		updatedFiles[roleName] = targetsJSON
+	}
+
 	// iterate through all the targets files - if they are dirty, sign and update
 	for roleName, roleObj := range r.tufRepo.Targets {
+		if _, ok := requests[roleName]; ok {
+			continue
+		}
 		if roleObj.Dirty || (roleName == data.CanonicalTargetsRole && initialPublish) {
-			targetsJSON, err := serializeCanonicalRole(r.tufRepo, roleName)
+			start := time.Now()
+			targetsJSON, err := serializeTargetsRole(r.tufRepo, roleName, signingKeyIDs)
+			r.reportSigned(roleName, start, err)
 			if err != nil {
-				return err
+				return nil, err
 			}
 			updatedFiles[roleName] = targetsJSON
 		}
@@ -596,12 +1161,14 @@ func (r *NotaryRepository) Publish() error {
 	// have a local key (if there was a rotation), so initialize one.
 	if r.tufRepo.Snapshot == nil {
 		if err := r.tufRepo.InitSnapshot(); err != nil {
-			return err
+			return nil, err
 		}
 	}
 
+	start := time.Now()
 	snapshotJSON, err := serializeCanonicalRole(
 		r.tufRepo, data.CanonicalSnapshotRole)
+	r.reportSigned(data.CanonicalSnapshotRole, start, err)
 
 	if err == nil {
 		// Only update the snapshot if we've successfully signed it.
@@ -614,26 +1181,86 @@ func (r *NotaryRepository) Publish() error {
 			"Assuming that server should sign the snapshot.")
 	} else {
 		logrus.Debugf("Client was unable to sign the snapshot: %s", err.Error())
-		return err
+		return nil, err
 	}
+	return updatedFiles, nil
+}
 
-	remote, err := getRemoteStore(r.baseURL, r.gun, r.roundTrip)
-	if err != nil {
-		return err
+func sortedRoleNames(files map[string][]byte) []string {
+	names := make([]string, 0, len(files))
+	for role := range files {
+		names = append(names, role)
 	}
+	sort.Strings(names)
+	return names
+}
 
-	err = remote.SetMultiMeta(updatedFiles)
-	if err != nil {
-		return err
-	}
-	err = cl.Clear("")
-	if err != nil {
-		// This is not a critical problem when only a single host is pushing
-		// but will cause weird behaviour if changelist cleanup is failing
-		// and there are multiple hosts writing to the repo.
-		logrus.Warn("Unable to clear changelist. You may want to manually delete the folder ", filepath.Join(r.tufRepoPath, "changelist"))
+// PublishedRoles returns the sorted names of the roles whose metadata was sent
+// to the server by the last successful Publish of this repository.  This may
+// not include the snapshot or timestamp if the server signs them.
+func (r *NotaryRepository) PublishedRoles() []string {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.publishedRoles
+}
+
+// RoleSignatures is how many valid signatures a role was published with,
+// and how many it needs to meet its threshold
+type RoleSignatures struct {
+	Role       string
+	Signatures int
+	Threshold  int
+}
+
+// PublishedSignatures returns how many signatures each of the roles sent to
+// the server by the last successful Publish of this repository was signed
+// with, in the same order as PublishedRoles
+func (r *NotaryRepository) PublishedSignatures() []RoleSignatures {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.publishedSignatures
+}
+
+// signatureCounts counts the signatures of each of the roles by the keys of
+// the role, as they currently are in the repo
+func (r *NotaryRepository) signatureCounts(roles []string) []RoleSignatures {
+	counts := make([]RoleSignatures, 0, len(roles))
+	for _, role := range roles {
+		var (
+			baseRole   data.BaseRole
+			signatures []data.Signature
+			err        error
+		)
+		switch {
+		case role == data.CanonicalRootRole:
+			baseRole, err = r.tufRepo.GetBaseRole(role)
+			signatures = r.tufRepo.Root.Signatures
+		case role == data.CanonicalSnapshotRole:
+			baseRole, err = r.tufRepo.GetBaseRole(role)
+			signatures = r.tufRepo.Snapshot.Signatures
+		case role == data.CanonicalTargetsRole:
+			baseRole, err = r.tufRepo.GetBaseRole(role)
+			signatures = r.tufRepo.Targets[role].Signatures
+		case data.IsDelegation(role):
+			var delgRole data.DelegationRole
+			delgRole, err = r.tufRepo.GetDelegationRole(role)
+			baseRole = delgRole.BaseRole
+			signatures = r.tufRepo.Targets[role].Signatures
+		default:
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		count := RoleSignatures{Role: role, Threshold: baseRole.Threshold}
+		for _, sig := range signatures {
+			if _, ok := baseRole.Keys[sig.KeyID]; ok {
+				count.Signatures++
+			}
+		}
+		counts = append(counts, count)
 	}
-	return nil
+	return counts
 }
 
 // bootstrapRepo loads the repository from the local file system.  This attempts
@@ -743,7 +1370,15 @@ func (r *NotaryRepository) errRepositoryNotExist() error {
 // Update bootstraps a trust anchor (root.json) before updating all the
 // metadata from the repo.
 func (r *NotaryRepository) Update(forWrite bool) (*tufclient.Client, error) {
-	c, err := r.bootstrapClient(forWrite)
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.update(forWrite)
+}
+
+func (r *NotaryRepository) update(forWrite bool) (c *tufclient.Client, err error) {
+	defer func() { r.reportVerificationFailure(err) }()
+
+	c, err = r.bootstrapClient(forWrite)
 	if err != nil {
 		if _, ok := err.(store.ErrMetaNotFound); ok {
 			return nil, r.errRepositoryNotExist()
@@ -760,9 +1395,293 @@ func (r *NotaryRepository) Update(forWrite bool) (*tufclient.Client, error) {
 		}
 		return nil, err
 	}
+	if err := r.checkUpdatedDelegationKeys(); err != nil {
+		return nil, err
+	}
+	if err := r.checkServerKeyPins(); err != nil {
+		return nil, err
+	}
+	r.recordTargetsHistory()
+	// publishing is how whatever is about to expire gets renewed
+	if !forWrite {
+		if err := r.checkStrictExpiry(r.now()); err != nil {
+			return nil, err
+		}
+	}
 	return c, nil
 }
 
+// checkServerKeyPins verifies that the metadata of every role that has pinned
+// server keys is signed by one of them
+func (r *NotaryRepository) checkServerKeyPins() error {
+	roles := make([]string, 0, len(r.ServerKeyPins))
+	for role := range r.ServerKeyPins {
+		roles = append(roles, role)
+	}
+	sort.Strings(roles)
+
+	for _, role := range roles {
+		var (
+			s   *data.Signed
+			err error
+		)
+		switch role {
+		case data.CanonicalTimestampRole:
+			s, err = r.tufRepo.Timestamp.ToSigned()
+		case data.CanonicalSnapshotRole:
+			s, err = r.tufRepo.Snapshot.ToSigned()
+		default:
+			return data.ErrInvalidRole{Role: role, Reason: "only the timestamp and snapshot keys of the server can be pinned"}
+		}
+		if err != nil {
+			return err
+		}
+		baseRole, err := r.tufRepo.GetBaseRole(role)
+		if err != nil {
+			return err
+		}
+
+		// only the signatures by pinned keys count
+		pinned := data.BaseRole{Name: role, Threshold: notary.MinThreshold, Keys: make(map[string]data.PublicKey)}
+		for _, keyID := range r.ServerKeyPins[role] {
+			if key := roleKeyByID(baseRole, keyID); key != nil {
+				pinned.Keys[key.ID()] = key
+			}
+		}
+		if len(pinned.Keys) > 0 && signed.VerifySignatures(s, pinned) == nil {
+			continue
+		}
+		signers := make([]string, 0, len(s.Signatures))
+		for _, sig := range s.Signatures {
+			signers = append(signers, sig.KeyID)
+		}
+		return ErrUnexpectedServerKey{Role: role, KeyIDs: signers}
+	}
+	return nil
+}
+
+// now is the time that reading from the repository checks for expiry at
+func (r *NotaryRepository) now() time.Time {
+	if r.AsOf.IsZero() {
+		return time.Now()
+	}
+	return r.AsOf
+}
+
+// checkStrictExpiry verifies that nothing in the updated repository expires
+// within the StrictExpiry window from now: not the metadata of any role, nor
+// the certificates of the root or delegation keys, nor the delegations.  The
+// metadata that the server signs is left out.
+func (r *NotaryRepository) checkStrictExpiry(now time.Time) error {
+	if r.StrictExpiry <= 0 {
+		return nil
+	}
+	deadline := now.Add(r.StrictExpiry)
+	check := func(role, what string, expires time.Time) error {
+		if expires.Before(deadline) {
+			return ErrExpiresSoon{Role: role, What: what, Expires: expires, Window: r.StrictExpiry}
+		}
+		return nil
+	}
+	checkKey := func(role, keyID string, key data.PublicKey) error {
+		cert, err := trustmanager.LoadCertFromPEM(key.Public())
+		if err != nil {
+			// not a certificate, so it doesn't expire
+			return nil
+		}
+		return check(role, "certificate of key "+keyID, cert.NotAfter)
+	}
+
+	root := r.tufRepo.Root
+	if err := check(data.CanonicalRootRole, "metadata", root.Signed.Expires); err != nil {
+		return err
+	}
+	if rootRole, ok := root.Signed.Roles[data.CanonicalRootRole]; ok {
+		for _, keyID := range rootRole.KeyIDs {
+			if key, ok := root.Signed.Keys[keyID]; ok {
+				if err := checkKey(data.CanonicalRootRole, keyID, key); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	// the server signs the timestamp, and the snapshot if there is no local
+	// snapshot key, again once they expire, so they can't be renewed early
+	if r.tufRepo.Snapshot != nil && r.hasSnapshotKey() {
+		if err := check(data.CanonicalSnapshotRole, "metadata", r.tufRepo.Snapshot.Signed.Expires); err != nil {
+			return err
+		}
+	}
+	for roleName, targets := range r.tufRepo.Targets {
+		if err := check(roleName, "metadata", targets.Signed.Expires); err != nil {
+			return err
+		}
+		delegations := targets.Signed.Delegations
+		for _, role := range delegations.Roles {
+			if role.ValidUntil != nil {
+				if err := check(role.Name, "delegation", *role.ValidUntil); err != nil {
+					return err
+				}
+			}
+			for _, keyID := range role.KeyIDs {
+				if key, ok := delegations.Keys[keyID]; ok {
+					if err := checkKey(role.Name, keyID, key); err != nil {
+						return err
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// historyName is the name that a version of the metadata of a role is kept
+// under in the history store
+func historyName(role string, version int) string {
+	return fmt.Sprintf("%s.%d", role, version)
+}
+
+// recordTargetsHistory keeps a copy of the currently cached version of the
+// metadata of targets and of every delegation, unless that version has been
+// kept already.  The history can't be relied on to be complete, since only
+// the versions that this client happened to download are kept, so failing to
+// record it is not an error.
+func (r *NotaryRepository) recordTargetsHistory() {
+	for role := range r.tufRepo.Targets {
+		raw, err := r.fileStore.GetMeta(role, -1)
+		if err != nil {
+			// not published yet
+			continue
+		}
+		s := &data.Signed{}
+		if err := json.Unmarshal(raw, s); err != nil {
+			continue
+		}
+		tgts, err := data.TargetsFromSigned(s)
+		if err != nil {
+			continue
+		}
+		name := historyName(role, tgts.Signed.Version)
+		if _, err := r.historyStore.GetMeta(name, -1); err == nil {
+			continue
+		}
+		if err := r.historyStore.SetMeta(name, raw); err != nil {
+			logrus.Errorf("could not save version %d of %s to the history: %s", tgts.Signed.Version, role, err)
+		}
+	}
+}
+
+// pruneTargetsHistory removes all but HistoryLimit versions of the metadata of
+// every targets and delegation role from the history, besides its latest
+// version, which may just have been published and so not be recorded yet.  As
+// with recording it, failing to prune the history is not an error.
+func (r *NotaryRepository) pruneTargetsHistory() {
+	if r.HistoryLimit < 0 {
+		return
+	}
+	for role, tgts := range r.tufRepo.Targets {
+		kept := 0
+		for version := tgts.Signed.Version - 1; version > 0; version-- {
+			name := historyName(role, version)
+			if _, err := r.historyStore.GetMetaModTime(name); err != nil {
+				continue
+			}
+			if kept < r.HistoryLimit {
+				kept++
+				continue
+			}
+			if err := r.historyStore.RemoveMeta(name); err != nil {
+				logrus.Errorf("could not remove version %d of %s from the history: %s", version, role, err)
+			}
+		}
+	}
+}
+
+// checkAllowedAlgorithms returns an ErrAlgorithmNotAllowed if any of the keys
+// for the given role is not of an allowed key type
+func (r *NotaryRepository) checkAllowedAlgorithms(role string, keys ...data.PublicKey) error {
+	return CheckAllowedAlgorithms(r.AllowedAlgorithms, role, keys...)
+}
+
+// CheckAllowedAlgorithms returns an ErrAlgorithmNotAllowed if any of the keys
+// for the given role is not of one of the allowed key types, as named by
+// trustmanager.KeyType.  Any key type is allowed if none are given.
+func CheckAllowedAlgorithms(allowedAlgorithms []string, role string, keys ...data.PublicKey) error {
+	if len(allowedAlgorithms) == 0 {
+		return nil
+	}
+	for _, key := range keys {
+		keyType, err := trustmanager.KeyType(key)
+		if err != nil {
+			return err
+		}
+		allowed := false
+		for _, algorithm := range allowedAlgorithms {
+			if strings.EqualFold(algorithm, keyType) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return ErrAlgorithmNotAllowed{Role: role, KeyID: key.ID(), KeyType: keyType}
+		}
+	}
+	return nil
+}
+
+// checkDelegationKeys checks that the keys for a delegation role are of an
+// allowed key type, and have not been revoked
+func (r *NotaryRepository) checkDelegationKeys(role string, keys ...data.PublicKey) error {
+	if err := r.checkAllowedAlgorithms(role, keys...); err != nil {
+		return err
+	}
+	return CheckRevokedKeys(r.RevokedKeys, role, keys...)
+}
+
+// CheckRevokedKeys returns an ErrKeyRevoked if any of the keys for the given
+// role is one of the revoked keys, by either its ID or its canonical ID
+func CheckRevokedKeys(revokedKeys []string, role string, keys ...data.PublicKey) error {
+	if len(revokedKeys) == 0 {
+		return nil
+	}
+	for _, key := range keys {
+		if utils.StrSliceContains(revokedKeys, key.ID()) {
+			return ErrKeyRevoked{Role: role, KeyID: key.ID()}
+		}
+		canonicalID, err := utils.CanonicalKeyID(key)
+		if err != nil {
+			return err
+		}
+		if utils.StrSliceContains(revokedKeys, canonicalID) {
+			return ErrKeyRevoked{Role: role, KeyID: canonicalID}
+		}
+	}
+	return nil
+}
+
+// checkUpdatedDelegationKeys verifies that every delegation in the updated
+// repository only has keys of allowed key types, none of which are revoked
+func (r *NotaryRepository) checkUpdatedDelegationKeys() error {
+	if len(r.AllowedAlgorithms) == 0 && len(r.RevokedKeys) == 0 {
+		return nil
+	}
+	for _, targets := range r.tufRepo.Targets {
+		delegations := targets.Signed.Delegations
+		for _, role := range delegations.Roles {
+			for _, keyID := range role.KeyIDs {
+				key, ok := delegations.Keys[keyID]
+				if !ok {
+					continue
+				}
+				if err := r.checkDelegationKeys(role.Name, key); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
 // bootstrapClient attempts to bootstrap a root.json to be used as the trust
 // anchor for a repository. The checkInitialized argument indicates whether
 // we should always attempt to contact the server to determine if the repository
@@ -778,12 +1697,20 @@ func (r *NotaryRepository) bootstrapClient(checkInitialized bool) (*tufclient.Cl
 	// until we detect a problem during update which will cause
 	// us to download a new root and perform a rotation.
 	rootJSON, cachedRootErr := r.fileStore.GetMeta("root", -1)
+	if cachedRootErr == nil && r.NoCache {
+		// download the root as if it were not cached
+		cachedRootErr = store.ErrMetaNotFound{Resource: data.CanonicalRootRole}
+	}
 
 	if cachedRootErr == nil {
 		signedRoot, cachedRootErr = r.validateRoot(rootJSON)
 	}
 
 	remote, remoteErr := getRemoteStore(r.baseURL, r.gun, r.roundTrip)
+	if remoteErr == nil {
+		// the offline store used without a server has nothing to report
+		remote = r.withTelemetry(remote)
+	}
 	if remoteErr != nil {
 		logrus.Error(remoteErr)
 	} else if cachedRootErr != nil || checkInitialized {
@@ -791,8 +1718,24 @@ func (r *NotaryRepository) bootstrapClient(checkInitialized bool) (*tufclient.Cl
 		// checking for initialization of the repo).
 
 		// if remote store successfully set up, try and get root from remote
-		// We don't have any local data to determine the size of root, so try the maximum (though it is restricted at 100MB)
-		tmpJSON, err := remote.GetMeta("root", -1)
+		// We don't have any local data to determine the size of root, so try the maximum size allowed for it
+		// If we have a valid cached root, we only need to know that the
+		// remote has a root, so there is no need to download it again if it
+		// is the same as the cached one.
+		var tmpJSON []byte
+		rootLimit := tufclient.MaxMetadataSize(r.MaxMetadataSizes, data.CanonicalRootRole)
+		if conditional, ok := remote.(store.ConditionalRemoteStore); ok && cachedRootErr == nil {
+			tmpJSON, err = tufclient.GetMetaWithLimit(data.CanonicalRootRole, rootLimit, func(size int64) ([]byte, error) {
+				return conditional.GetMetaIfModified(data.CanonicalRootRole, size, rootJSON)
+			})
+			if _, ok := err.(store.ErrMetaNotModified); ok {
+				err = nil
+			}
+		} else {
+			tmpJSON, err = tufclient.GetMetaWithLimit(data.CanonicalRootRole, rootLimit, func(size int64) ([]byte, error) {
+				return remote.GetMeta(data.CanonicalRootRole, size)
+			})
+		}
 		if err != nil {
 			// we didn't have a root in cache and were unable to load one from
 			// the server. Nothing we can do but error.
@@ -815,6 +1758,7 @@ func (r *NotaryRepository) bootstrapClient(checkInitialized bool) (*tufclient.Cl
 	}
 
 	r.tufRepo = tuf.NewRepo(r.CryptoService)
+	r.tufRepo.AsOf = r.AsOf
 
 	if signedRoot == nil {
 		return nil, ErrRepoNotInitialized{}
@@ -825,11 +1769,19 @@ func (r *NotaryRepository) bootstrapClient(checkInitialized bool) (*tufclient.Cl
 		return nil, err
 	}
 
-	return tufclient.NewClient(
+	c := tufclient.NewClient(
 		r.tufRepo,
 		remote,
 		r.fileStore,
-	), nil
+	)
+	c.MaxTimestampAge = r.MaxTimestampAge
+	c.MaxMetadataSizes = r.MaxMetadataSizes
+	c.NoCache = r.NoCache
+	c.AsOf = r.AsOf
+	if !checkInitialized {
+		c.OnlyRoles = r.OnlyRoles
+	}
+	return c, nil
 }
 
 // validateRoot MUST only be used during bootstrapping. It will only validate
@@ -846,7 +1798,7 @@ func (r *NotaryRepository) validateRoot(rootJSON []byte) (*data.SignedRoot, erro
 		return nil, err
 	}
 
-	err = certs.ValidateRoot(r.CertStore, root, r.gun)
+	err = certs.ValidateRootAt(r.CertStore, root, r.gun, r.now())
 	if err != nil {
 		return nil, err
 	}
@@ -858,6 +1810,8 @@ func (r *NotaryRepository) validateRoot(rootJSON []byte) (*data.SignedRoot, erro
 // creates and adds one new key or delegates managing the key to the server.
 // These changes are staged in a changelist until publish is called.
 func (r *NotaryRepository) RotateKey(role string, serverManagesKey bool) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
 	if role == data.CanonicalRootRole || role == data.CanonicalTimestampRole {
 		return fmt.Errorf(
 			"notary does not currently support rotating the %s key", role)
@@ -882,15 +1836,164 @@ func (r *NotaryRepository) RotateKey(role string, serverManagesKey bool) error {
 	return r.rootFileKeyChange(role, changelist.ActionCreate, pubKey)
 }
 
-func (r *NotaryRepository) rootFileKeyChange(role, action string, key data.PublicKey) error {
+// RotateRootKey creates a changelist entry to replace the root key of the
+// repository with the root key with the given ID, which must already be in one
+// of the repository's key stores.  If keepOldKeys is true the current root keys
+// stay in the root role alongside the new key, so that the new root is signed by
+// both the old and the new keys and clients that have only ever seen the old
+// keys can still validate it.  The old keys can be dropped by a later rotation.
+// Any overlap staged by RotateRootKeyWithOverlap is superseded.
+func (r *NotaryRepository) RotateRootKey(newRootKeyID string, keepOldKeys bool) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.rotateRootKey(newRootKeyID, keepOldKeys)
+}
+
+func (r *NotaryRepository) rotateRootKey(newRootKeyID string, keepOldKeys bool) error {
+	privKey, _, err := r.CryptoService.GetPrivateKey(newRootKeyID)
+	if err != nil {
+		return err
+	}
+
+	// Update state of the repo to latest
+	if _, err := r.update(false); err != nil {
+		return err
+	}
+	if err := r.stageRootKeys(newRootKeyID, privKey, keepOldKeys); err != nil {
+		return err
+	}
+	if err := os.Remove(r.rootOverlapPath()); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// rootOverlap records a root key rotation whose old keys should be removed
+// from the root role by the first publish after Until.
+type rootOverlap struct {
+	NewRootKeyID string    `json:"new_root_key_id"`
+	Until        time.Time `json:"until"`
+}
+
+func (r *NotaryRepository) rootOverlapPath() string {
+	return filepath.Join(r.tufRepoPath, "root_overlap.json")
+}
+
+// RotateRootKeyWithOverlap stages a rotation of the root key like RotateRootKey
+// with keepOldKeys set, and records that the current root keys must only be
+// trusted until the given time.  The first publish after that time removes
+// them from the root role, leaving only the new root key.
+func (r *NotaryRepository) RotateRootKeyWithOverlap(newRootKeyID string, until time.Time) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if err := r.rotateRootKey(newRootKeyID, true); err != nil {
+		return err
+	}
+	overlapJSON, err := json.Marshal(rootOverlap{NewRootKeyID: newRootKeyID, Until: until})
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(r.rootOverlapPath(), overlapJSON, 0600)
+}
+
+// RootKeyOverlapEnd returns the time after which the old root keys kept by
+// RotateRootKeyWithOverlap will be removed, and false if no overlap is pending.
+func (r *NotaryRepository) RootKeyOverlapEnd() (time.Time, bool, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	overlap, err := r.readRootOverlap()
+	if err != nil || overlap == nil {
+		return time.Time{}, false, err
+	}
+	return overlap.Until, true, nil
+}
+
+func (r *NotaryRepository) readRootOverlap() (*rootOverlap, error) {
+	overlapJSON, err := ioutil.ReadFile(r.rootOverlapPath())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var overlap rootOverlap
+	if err := json.Unmarshal(overlapJSON, &overlap); err != nil {
+		return nil, err
+	}
+	return &overlap, nil
+}
+
+// finishRootOverlap stages the removal of the old root keys if the overlap
+// of a root key rotation has ended.  It must be called on an updated repo.
+func (r *NotaryRepository) finishRootOverlap() error {
+	overlap, err := r.readRootOverlap()
+	if err != nil || overlap == nil || time.Now().Before(overlap.Until) {
+		return err
+	}
+	privKey, _, err := r.CryptoService.GetPrivateKey(overlap.NewRootKeyID)
+	if err != nil {
+		return err
+	}
+	if err := r.stageRootKeys(overlap.NewRootKeyID, privKey, false); err != nil {
+		return err
+	}
+	return os.Remove(r.rootOverlapPath())
+}
+
+// stageRootKeys creates a changelist entry that makes the root key with the
+// given ID a root key, keeping the current root keys if keepOldKeys is true.
+func (r *NotaryRepository) stageRootKeys(newRootKeyID string, privKey data.PrivateKey, keepOldKeys bool) error {
+	currentRoot, err := r.tufRepo.GetBaseRole(data.CanonicalRootRole)
+	if err != nil {
+		return err
+	}
+
+	var (
+		rootKeys    []data.PublicKey
+		newKeyInUse bool
+	)
+	for _, pubKey := range currentRoot.Keys {
+		canonicalID, err := utils.CanonicalKeyID(pubKey)
+		if err != nil {
+			return err
+		}
+		// the certificate for the new key is kept if it is already a root key,
+		// so that clients that have it pinned keep trusting it
+		if canonicalID == newRootKeyID {
+			newKeyInUse = true
+			rootKeys = append(rootKeys, pubKey)
+		} else if keepOldKeys {
+			rootKeys = append(rootKeys, pubKey)
+		}
+	}
+
+	if !newKeyInUse {
+		rootCert, rootKey, err := rootCertKey(r.gun, privKey)
+		if err != nil {
+			return err
+		}
+		// trust the new certificate locally, since the new root might not be
+		// signed by any of the old keys
+		if err := r.CertStore.AddCert(rootCert); err != nil {
+			if _, ok := err.(*trustmanager.ErrCertExists); !ok {
+				return err
+			}
+		}
+		rootKeys = append(rootKeys, rootKey)
+	}
+
+	return r.rootFileKeyChange(data.CanonicalRootRole, changelist.ActionCreate, rootKeys...)
+}
+
+func (r *NotaryRepository) rootFileKeyChange(role, action string, keys ...data.PublicKey) error {
 	cl, err := changelist.NewFileChangelist(filepath.Join(r.tufRepoPath, "changelist"))
 	if err != nil {
 		return err
 	}
 	defer cl.Close()
 
-	kl := make(data.KeyList, 0, 1)
-	kl = append(kl, key)
+	kl := make(data.KeyList, 0, len(keys))
+	kl = append(kl, keys...)
 	meta := changelist.TufRootData{
 		RoleName: role,
 		Keys:     kl,
@@ -916,10 +2019,15 @@ func (r *NotaryRepository) rootFileKeyChange(role, action string, key data.Publi
 
 // DeleteTrustData removes the trust data stored for this repo in the TUF cache and certificate store on the client side
 func (r *NotaryRepository) DeleteTrustData() error {
-	// Clear TUF files and cache
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	// Clear TUF files and cache, which may be in a separate cache directory
 	if err := r.fileStore.RemoveAll(); err != nil {
 		return fmt.Errorf("error clearing TUF repo data: %v", err)
 	}
+	if err := os.RemoveAll(r.tufRepoPath); err != nil {
+		return fmt.Errorf("error clearing TUF repo data: %v", err)
+	}
 	r.tufRepo = tuf.NewRepo(nil)
 	// Clear certificates
 	certificates, err := r.CertStore.GetCertificatesByCN(r.gun)
@@ -937,3 +2045,42 @@ func (r *NotaryRepository) DeleteTrustData() error {
 	}
 	return nil
 }
+
+// DeleteRemoteTrustData deletes all of the trust data for this repo from the
+// remote trust server
+func (r *NotaryRepository) DeleteRemoteTrustData() error {
+	remote, err := getRemoteStore(r.baseURL, r.gun, r.roundTrip)
+	if err != nil {
+		return err
+	}
+	if err := remote.RemoveAll(); err != nil {
+		return fmt.Errorf("error deleting remote trust data for %s: %v", r.gun, err)
+	}
+	return nil
+}
+
+// ListRepoKeys returns the sorted IDs of the private keys that are stored
+// locally for this repo, which are its non-root keys.  Root keys are not
+// stored by repo and may be shared with other repos, so they are not listed.
+func (r *NotaryRepository) ListRepoKeys() []string {
+	var keyIDs []string
+	for keyPath, role := range r.CryptoService.ListAllKeys() {
+		// non-root keys are listed by their path, which is prefixed with the GUN
+		if role != data.CanonicalRootRole && path.Dir(keyPath) == r.gun {
+			keyIDs = append(keyIDs, path.Base(keyPath))
+		}
+	}
+	sort.Strings(keyIDs)
+	return keyIDs
+}
+
+// DeleteRepoKeys removes the private keys that are stored locally for this
+// repo, as listed by ListRepoKeys
+func (r *NotaryRepository) DeleteRepoKeys() error {
+	for _, keyID := range r.ListRepoKeys() {
+		if err := r.CryptoService.RemoveKey(keyID); err != nil {
+			return fmt.Errorf("error removing key %s: %v", keyID, err)
+		}
+	}
+	return nil
+}
@@ -15,6 +15,8 @@ import (
 	"path/filepath"
 	"reflect"
 	"sort"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -124,6 +126,10 @@ func simpleTestServer(t *testing.T, roles ...string) (
 }
 
 func fullTestServer(t *testing.T) *httptest.Server {
+	return httptest.NewServer(fullTestHandler(t))
+}
+
+func fullTestHandler(t *testing.T) http.Handler {
 	// Set up server
 	ctx := context.WithValue(
 		context.Background(), "metaStore", storage.NewMemStorage())
@@ -141,7 +147,7 @@ func fullTestServer(t *testing.T) *httptest.Server {
 
 	cryptoService := cryptoservice.NewCryptoService(
 		"", trustmanager.NewKeyMemoryStore(passphraseRetriever))
-	return httptest.NewServer(server.RootHandler(nil, ctx, cryptoService))
+	return server.RootHandler(nil, ctx, cryptoService)
 }
 
 // server that returns some particular error code all the time
@@ -232,6 +238,80 @@ func newRepoToTestRepo(t *testing.T, existingRepo *NotaryRepository, newDir bool
 	return repo, rec
 }
 
+// headerRoundTripper adds a header to every request, and counts them
+type headerRoundTripper struct {
+	base     http.RoundTripper
+	requests int
+}
+
+func (h *headerRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	h.requests++
+	req.Header.Set("X-Notary-Test", "custom transport")
+	return h.base.RoundTrip(req)
+}
+
+// The transport a repository is created with is used for every request to the
+// server
+func TestCustomRoundTripper(t *testing.T) {
+	ts := fullTestServer(t)
+	defer ts.Close()
+
+	repo, _ := initializeRepo(t, data.ECDSAKey, "docker.com/notary", ts.URL, false)
+	defer os.RemoveAll(repo.baseDir)
+	assert.NoError(t, repo.Publish())
+
+	rt := &headerRoundTripper{base: http.DefaultTransport}
+	customRepo, err := NewNotaryRepository(repo.baseDir, repo.gun, repo.baseURL, rt, passphraseRetriever)
+	assert.NoError(t, err)
+	_, err = customRepo.ListTargets()
+	assert.NoError(t, err)
+	assert.True(t, rt.requests > 0, "the custom transport was not used")
+}
+
+// A repository with a separate cache directory keeps its downloaded metadata
+// there, creating the directory, and its keys and certificates in the base
+// directory
+func TestRepositoryWithSeparateCache(t *testing.T) {
+	ts := fullTestServer(t)
+	defer ts.Close()
+
+	baseDir, err := ioutil.TempDir("", "notary-test-")
+	assert.NoError(t, err)
+	defer os.RemoveAll(baseDir)
+	cacheParent, err := ioutil.TempDir("", "notary-test-cache-")
+	assert.NoError(t, err)
+	defer os.RemoveAll(cacheParent)
+	cacheDir := filepath.Join(cacheParent, "cache")
+
+	gun := "docker.com/notary"
+	repo, err := NewNotaryRepositoryWithCache(baseDir, cacheDir, gun, ts.URL, http.DefaultTransport, passphraseRetriever)
+	assert.NoError(t, err)
+	rootPubKey, err := repo.CryptoService.Create(data.CanonicalRootRole, data.ECDSAKey)
+	assert.NoError(t, err)
+	assert.NoError(t, repo.Initialize(rootPubKey.ID()))
+	assert.NoError(t, repo.Publish())
+
+	for _, role := range data.BaseRoles {
+		if role == data.CanonicalTimestampRole {
+			continue
+		}
+		_, err := os.Stat(filepath.Join(cacheDir, tufDir, gun, "metadata", role+".json"))
+		assert.NoError(t, err, "%s was not cached in the cache directory", role)
+		_, err = os.Stat(filepath.Join(baseDir, tufDir, gun, "metadata", role+".json"))
+		assert.True(t, os.IsNotExist(err), "%s was cached in the base directory", role)
+	}
+	_, err = os.Stat(filepath.Join(baseDir, notary.PrivDir, notary.RootKeysSubdir, rootPubKey.ID()+".key"))
+	assert.NoError(t, err)
+	_, err = os.Stat(filepath.Join(baseDir, notary.TrustedCertsDir))
+	assert.NoError(t, err)
+
+	// another client of the same directories can read the cached metadata
+	other, err := NewNotaryRepositoryWithCache(baseDir, cacheDir, gun, ts.URL, http.DefaultTransport, passphraseRetriever)
+	assert.NoError(t, err)
+	_, err = other.ListTargets()
+	assert.NoError(t, err)
+}
+
 // Initializing a new repo while specifying that the server should manage the root
 // role will fail.
 func TestInitRepositoryManagedRolesIncludingRoot(t *testing.T) {
@@ -1145,6 +1225,12 @@ func (k targetSorter) Len() int           { return len(k) }
 func (k targetSorter) Swap(i, j int)      { k[i], k[j] = k[j], k[i] }
 func (k targetSorter) Less(i, j int) bool { return k[i].Name < k[j].Name }
 
+type roleSorter []*data.Role
+
+func (k roleSorter) Len() int           { return len(k) }
+func (k roleSorter) Swap(i, j int)      { k[i], k[j] = k[j], k[i] }
+func (k roleSorter) Less(i, j int) bool { return k[i].Name < k[j].Name }
+
 func testListTarget(t *testing.T, rootType string) {
 	ts, mux, keys := simpleTestServer(t)
 	defer ts.Close()
@@ -1321,6 +1407,75 @@ func testListTargetWithDelegates(t *testing.T, rootType string) {
 	assert.Equal(t, "targets/level2", newLevel2Target.Role)
 }
 
+// VerifyTarget streams the data to check it against the trusted target of
+// that name, searching delegations too, and tells a target that doesn't exist
+// apart from data that doesn't match the target.
+func TestVerifyTarget(t *testing.T) {
+	ts := fullTestServer(t)
+	defer ts.Close()
+
+	repo, _ := initializeRepo(t, data.ECDSAKey, "docker.com/notary", ts.URL, false)
+	defer os.RemoveAll(repo.baseDir)
+
+	delgKey := createKey(t, repo, "targets/a", false)
+	assert.NoError(t, repo.AddDelegation("targets/a", []data.PublicKey{delgKey}, []string{""}))
+	assert.NoError(t, repo.Publish())
+	addTarget(t, repo, "current", "../fixtures/intermediate-ca.crt")
+	addTarget(t, repo, "delegated", "../fixtures/root-ca.crt", "targets/a")
+	addTarget(t, repo, "shared", "../fixtures/intermediate-ca.crt")
+	addTarget(t, repo, "shared", "../fixtures/root-ca.crt", "targets/a")
+	assert.NoError(t, repo.Publish())
+
+	intermediate, err := ioutil.ReadFile("../fixtures/intermediate-ca.crt")
+	assert.NoError(t, err)
+	root, err := ioutil.ReadFile("../fixtures/root-ca.crt")
+	assert.NoError(t, err)
+
+	ok, err := repo.VerifyTarget(repo.gun, "current", bytes.NewReader(intermediate))
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	ok, err = repo.VerifyTarget(repo.gun, "delegated", bytes.NewReader(root))
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	// same length, different content
+	tampered := append([]byte{}, intermediate...)
+	tampered[0]++
+	for _, content := range [][]byte{root, intermediate[1:], tampered} {
+		ok, err = repo.VerifyTarget(repo.gun, "current", bytes.NewReader(content))
+		assert.False(t, ok)
+		assert.Equal(t, ErrTargetMismatch{Name: "current", Role: data.CanonicalTargetsRole}, err)
+	}
+
+	ok, err = repo.VerifyTarget(repo.gun, "missing", bytes.NewReader(intermediate))
+	assert.False(t, ok)
+	assert.Equal(t, ErrNoSuchTarget{Name: "missing"}, err)
+
+	ok, err = repo.VerifyTarget("docker.com/other", "current", bytes.NewReader(intermediate))
+	assert.False(t, ok)
+	assert.Error(t, err)
+
+	// the signing role has to be one of the trusted roles, if any are given
+	ok, err = repo.VerifyTarget(repo.gun, "delegated", bytes.NewReader(root), "targets/b", "targets/a")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	ok, err = repo.VerifyTarget(repo.gun, "current", bytes.NewReader(intermediate), "targets/a")
+	assert.False(t, ok)
+	assert.Equal(t, ErrUntrustedRole{
+		Name: "current", Role: data.CanonicalTargetsRole, TrustedRoles: []string{"targets/a"}}, err)
+	// a target is compared with the one a trusted role signs, even if a role
+	// with a higher priority signs another one with the same name
+	ok, err = repo.VerifyTarget(repo.gun, "shared", bytes.NewReader(root), "targets/a")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	ok, err = repo.VerifyTarget(repo.gun, "shared", bytes.NewReader(intermediate), "targets/a")
+	assert.False(t, ok)
+	assert.Equal(t, ErrTargetMismatch{Name: "shared", Role: "targets/a"}, err)
+	ok, err = repo.VerifyTarget(repo.gun, "current", bytes.NewReader(intermediate), data.CanonicalRootRole)
+	assert.False(t, ok)
+	assert.IsType(t, data.ErrInvalidRole{}, err)
+}
+
 // TestValidateRootKey verifies that the public data in root.json for the root
 // key is a valid x509 certificate.
 func TestValidateRootKey(t *testing.T) {
@@ -1978,179 +2133,1619 @@ func testPublishDelegations(t *testing.T, clearCache, x509Keys bool) {
 	}
 }
 
-// If a changelist specifies a particular role to push targets to, and there
-// is no such role, publish will try to publish to its parent.  If the parent
-// doesn't work, it falls back on its parent, and so forth, and eventually
-// falls back on publishing to "target".  This *only* falls back if the role
-// doesn't exist, not if the user doesn't have a key.  (different test)
-func TestPublishTargetsDelgationScopeFallback(t *testing.T) {
-	testPublishTargetsDelgationScopeFallback(t, true)
-	testPublishTargetsDelgationScopeFallback(t, false)
-}
-
-func testPublishTargetsDelgationScopeFallback(t *testing.T, clearCache bool) {
+// PublishWithSigningKey signs the delegation being published with only the
+// requested key, which may be given by its canonical ID, and refuses to
+// publish with a key that is not one of the delegation's keys.
+func TestPublishWithSigningKey(t *testing.T) {
 	ts := fullTestServer(t)
 	defer ts.Close()
 
 	repo, _ := initializeRepo(t, data.ECDSAKey, "docker.com/notary", ts.URL, false)
 	defer os.RemoveAll(repo.baseDir)
 
-	var rec *passRoleRecorder
-	if clearCache {
-		repo, rec = newRepoToTestRepo(t, repo, false)
-	}
+	delgKey1 := createKey(t, repo, "targets/a", true)
+	delgKey2 := createKey(t, repo, "targets/a", true)
+	assert.NoError(t, repo.AddDelegation(
+		"targets/a", []data.PublicKey{delgKey1, delgKey2}, []string{""}))
+	assert.NoError(t, repo.Publish())
 
-	assertPublishToRolesSucceeds(t, repo, []string{"targets/a/b", "targets/b/c"},
-		[]string{data.CanonicalTargetsRole})
+	addTarget(t, repo, "current", "../fixtures/intermediate-ca.crt", "targets/a")
 
-	if clearCache {
-		rec.assertAsked(t, []string{data.CanonicalTargetsRole, data.CanonicalSnapshotRole})
-		rec.assertCreated(t, nil)
-	}
-}
+	otherKey := createKey(t, repo, "targets/b", false)
+	err := repo.PublishWithSigningKey(otherKey.ID())
+	assert.Error(t, err)
+	assert.IsType(t, signed.ErrInvalidSigningKey{}, err)
+	assert.Len(t, getChanges(t, repo), 1, "changes should not have been published")
 
-// If a changelist specifies a particular role to push targets to, and there
-// is a role but no key, publish not fall back and just fail.
-func TestPublishTargetsDelgationScopeNoFallbackIfNoKeys(t *testing.T) {
-	testPublishTargetsDelgationScopeNoFallbackIfNoKeys(t, true)
-	testPublishTargetsDelgationScopeNoFallbackIfNoKeys(t, false)
+	canonicalID, err := utils.CanonicalKeyID(delgKey2)
+	assert.NoError(t, err)
+	assert.NoError(t, repo.PublishWithSigningKey(canonicalID))
+	assert.Len(t, getChanges(t, repo), 0)
+	assert.Contains(t, repo.PublishedRoles(), "targets/a")
+
+	sigs := repo.tufRepo.Targets["targets/a"].Signatures
+	assert.Len(t, sigs, 1)
+	assert.Equal(t, delgKey2.ID(), sigs[0].KeyID)
+
+	assert.Error(t, repo.PublishWithSigningKey(""))
 }
 
-func testPublishTargetsDelgationScopeNoFallbackIfNoKeys(t *testing.T, clearCache bool) {
+// PublishWithSigningKeys signs with each of the requested keys, so that a role
+// with a threshold of 2 can be published with two of its keys at once, and
+// the number of signatures of each published role is reported
+func TestPublishWithSigningKeys(t *testing.T) {
 	ts := fullTestServer(t)
 	defer ts.Close()
 
 	repo, _ := initializeRepo(t, data.ECDSAKey, "docker.com/notary", ts.URL, false)
 	defer os.RemoveAll(repo.baseDir)
 
-	// generate a key that isn't in the cryptoservice, so we can't sign this
-	// one
-	aPrivKey, err := trustmanager.GenerateECDSAKey(rand.Reader)
-	assert.NoError(t, err, "error generating key that is not in our cryptoservice")
-	aPubKey := data.PublicKeyFromPrivate(aPrivKey)
+	delgKey1 := createKey(t, repo, "targets/a", true)
+	delgKey2 := createKey(t, repo, "targets/a", true)
+	delgKey3 := createKey(t, repo, "targets/a", true)
+	tdJSON, err := json.Marshal(&changelist.TufDelegation{
+		NewThreshold: 2,
+		AddKeys:      data.KeyList{delgKey1, delgKey2, delgKey3},
+		AddPaths:     []string{""},
+	})
+	assert.NoError(t, err)
+	cl, err := changelist.NewFileChangelist(filepath.Join(repo.tufRepoPath, "changelist"))
+	assert.NoError(t, err)
+	assert.NoError(t, addChange(cl, newCreateDelegationChange("targets/a", tdJSON), "targets/a"))
+	assert.NoError(t, repo.Publish())
+	assert.Len(t, repo.PublishedSignatures(), len(repo.PublishedRoles()))
+	assert.Contains(t, repo.PublishedSignatures(),
+		RoleSignatures{Role: data.CanonicalTargetsRole, Signatures: 1, Threshold: 1})
 
-	var rec *passRoleRecorder
-	if clearCache {
-		repo, rec = newRepoToTestRepo(t, repo, false)
+	addTarget(t, repo, "current", "../fixtures/intermediate-ca.crt", "targets/a")
+	assert.Error(t, repo.PublishWithSigningKeys(nil))
+	assert.Error(t, repo.PublishWithSigningKeys([]string{delgKey1.ID(), ""}))
+	otherKey := createKey(t, repo, "targets/b", false)
+	err = repo.PublishWithSigningKeys([]string{delgKey1.ID(), otherKey.ID()})
+	assert.IsType(t, signed.ErrInvalidSigningKey{}, err)
+	assert.Len(t, getChanges(t, repo), 1, "changes should not have been published")
+
+	assert.NoError(t, repo.PublishWithSigningKeys([]string{delgKey1.ID(), delgKey3.ID()}))
+	assert.Contains(t, repo.PublishedSignatures(),
+		RoleSignatures{Role: "targets/a", Signatures: 2, Threshold: 2})
+	sigs := repo.tufRepo.Targets["targets/a"].Signatures
+	assert.Len(t, sigs, 2)
+	for _, sig := range sigs {
+		assert.NotEqual(t, delgKey2.ID(), sig.KeyID)
 	}
 
-	// ensure that the role exists
-	assert.NoError(t, repo.AddDelegation("targets/a", []data.PublicKey{aPubKey}, []string{""}))
+	// without any signing keys given, every available key signs
+	addTarget(t, repo, "latest", "../fixtures/intermediate-ca.crt", "targets/a")
 	assert.NoError(t, repo.Publish())
+	assert.Contains(t, repo.PublishedSignatures(),
+		RoleSignatures{Role: "targets/a", Signatures: 3, Threshold: 2})
+}
 
-	if clearCache {
-		rec.assertAsked(t, []string{data.CanonicalTargetsRole, data.CanonicalSnapshotRole})
-		rec.clear()
-	}
+// WritePendingMetadata writes the signed metadata the next publish would send,
+// with the staged changes applied, and leaves both the changelist and the
+// server alone
+func TestWritePendingMetadata(t *testing.T) {
+	ts := fullTestServer(t)
+	defer ts.Close()
 
-	// add a target to targets/a/b - no role b, so it falls back on a, which
-	// exists but there is no signing key for
-	addTarget(t, repo, "latest", "../fixtures/intermediate-ca.crt", "targets/a/b")
-	assert.Len(t, getChanges(t, repo), 1, "wrong number of changelist files found")
+	repo, _ := initializeRepo(t, data.ECDSAKey, "docker.com/notary", ts.URL, false)
+	defer os.RemoveAll(repo.baseDir)
 
-	// Now Publish should fail
-	assert.Error(t, repo.Publish())
-	assert.Len(t, getChanges(t, repo), 1, "wrong number of changelist files found")
-	if clearCache {
-		rec.assertAsked(t, nil)
-		rec.clear()
-	}
+	delgKey := createKey(t, repo, "targets/a", false)
+	assert.NoError(t, repo.AddDelegation("targets/a", []data.PublicKey{delgKey}, []string{""}))
+	addTarget(t, repo, "old", "../fixtures/intermediate-ca.crt", "targets/a")
+	assert.NoError(t, repo.Publish())
+	addTarget(t, repo, "current", "../fixtures/intermediate-ca.crt", "targets/a")
 
-	targets, err := repo.ListTargets("targets", "targets/a", "targets/a/b")
+	outputDir := filepath.Join(repo.baseDir, "review")
+	roles, err := repo.WritePendingMetadata(outputDir)
 	assert.NoError(t, err)
-	assert.Empty(t, targets)
+	assert.Equal(t, []string{data.CanonicalSnapshotRole, "targets/a"}, roles)
+	assert.Len(t, getChanges(t, repo), 1, "changes should not have been published")
+
+	delgJSON, err := ioutil.ReadFile(filepath.Join(outputDir, "targets", "a.json"))
+	assert.NoError(t, err)
+	delgTargets := &data.SignedTargets{}
+	assert.NoError(t, regJson.Unmarshal(delgJSON, delgTargets))
+	_, ok := delgTargets.Signed.Targets["current"]
+	assert.True(t, ok)
+	_, err = os.Stat(filepath.Join(outputDir, "snapshot.json"))
+	assert.NoError(t, err)
+
+	// the repo itself is left as it was
+	_, ok = repo.tufRepo.Targets["targets/a"].Signed.Targets["current"]
+	assert.False(t, ok)
+	otherRepo, _ := newRepoToTestRepo(t, repo, true)
+	defer os.RemoveAll(otherRepo.baseDir)
+	_, err = otherRepo.GetTargetByName("current")
+	assert.Error(t, err)
+
+	assert.NoError(t, repo.Publish())
+	assert.Equal(t, roles, repo.PublishedRoles())
 }
 
-// If a changelist specifies a particular role to push targets to, and such
-// a role and the keys are present, publish will write to that role only, and
-// not its parents.  This tests the case where the local machine knows about
-// all the roles (in fact, the role creations will be applied before the
-// targets)
-func TestPublishTargetsDelgationSuccessLocallyHasRoles(t *testing.T) {
+// Metadata signed with a detached signature over the bytes of a signing
+// request is published with that signature, without the signing key being
+// available locally.  Signatures that don't verify, and requests that are
+// out of date, are rejected.
+func TestExternalSigning(t *testing.T) {
 	ts := fullTestServer(t)
 	defer ts.Close()
 
 	repo, _ := initializeRepo(t, data.ECDSAKey, "docker.com/notary", ts.URL, false)
 	defer os.RemoveAll(repo.baseDir)
 
-	for _, delgName := range []string{"targets/a", "targets/a/b"} {
-		delgKey := createKey(t, repo, delgName, false)
-		assert.NoError(t,
-			repo.AddDelegation(delgName, []data.PublicKey{delgKey}, []string{""}),
-			"error creating delegation")
-	}
+	delgKey := createKey(t, repo, "targets/a", false)
+	assert.NoError(t, repo.AddDelegation("targets/a", []data.PublicKey{delgKey}, []string{""}))
+	addTarget(t, repo, "old", "../fixtures/intermediate-ca.crt", "targets/a")
+	assert.NoError(t, repo.Publish())
+	privKey, _, err := repo.CryptoService.GetPrivateKey(delgKey.ID())
+	assert.NoError(t, err)
+	assert.NoError(t, repo.CryptoService.RemoveKey(delgKey.ID()))
 
-	// just always check signing now, we've already established we can publish
-	// delgations with and without the metadata and key cache
-	var rec *passRoleRecorder
-	repo, rec = newRepoToTestRepo(t, repo, false)
+	_, err = repo.SigningRequest(data.CanonicalRootRole)
+	assert.Error(t, err)
+	assert.Error(t, repo.ApplySignature("targets/a", delgKey.ID(), []byte("sig")))
 
-	assertPublishToRolesSucceeds(t, repo, []string{"targets/a/b"},
-		[]string{"targets/a/b"})
+	addTarget(t, repo, "current", "../fixtures/intermediate-ca.crt", "targets/a")
+	payload, err := repo.SigningRequest("targets/a")
+	assert.NoError(t, err)
+	requested := &data.Targets{}
+	assert.NoError(t, regJson.Unmarshal(payload, requested))
+	_, ok := requested.Targets["current"]
+	assert.True(t, ok)
 
-	// first time publishing, so everything gets signed
-	rec.assertAsked(t, []string{data.CanonicalTargetsRole, "targets/a", "targets/a/b",
-		data.CanonicalSnapshotRole})
+	// the request can't be published until it is signed
+	assert.Error(t, repo.Publish())
+
+	sig, err := privKey.Sign(rand.Reader, payload, nil)
+	assert.NoError(t, err)
+	err = repo.ApplySignature("targets/a", delgKey.ID(), append([]byte("bad"), sig...))
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "does not verify")
+	otherKey := createKey(t, repo, "targets/a", false)
+	assert.IsType(t, signed.ErrInvalidSigningKey{}, repo.ApplySignature("targets/a", otherKey.ID(), sig))
+	assert.NoError(t, repo.ApplySignature("targets/a", delgKey.ID(), sig))
+
+	assert.NoError(t, repo.Publish())
+	assert.Contains(t, repo.PublishedRoles(), "targets/a")
+	otherRepo, _ := newRepoToTestRepo(t, repo, true)
+	defer os.RemoveAll(otherRepo.baseDir)
+	_, err = otherRepo.GetTargetByName("current")
+	assert.NoError(t, err)
+
+	// a request is out of date once the metadata changes
+	_, err = repo.SigningRequest("targets/a")
+	assert.NoError(t, err)
+	addTarget(t, repo, "latest", "../fixtures/intermediate-ca.crt", "targets/a")
+	payload, err = repo.SigningRequest("targets/a")
+	assert.NoError(t, err)
+	sig, err = privKey.Sign(rand.Reader, payload, nil)
+	assert.NoError(t, err)
+	assert.NoError(t, repo.ApplySignature("targets/a", delgKey.ID(), sig))
+	addTarget(t, repo, "later", "../fixtures/intermediate-ca.crt", "targets/a")
+	err = repo.Publish()
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "has changed since its signing request was made")
 }
 
-// If a changelist specifies a particular role to push targets to, and the role
-// is present, publish will write to that role only.  The targets keys are not
-// needed.
-func TestPublishTargetsDelgationNoTargetsKeyNeeded(t *testing.T) {
+// CanonicalMetadata returns the bytes the signatures of the latest metadata
+// of a role were made over
+func TestCanonicalMetadata(t *testing.T) {
 	ts := fullTestServer(t)
 	defer ts.Close()
 
 	repo, _ := initializeRepo(t, data.ECDSAKey, "docker.com/notary", ts.URL, false)
 	defer os.RemoveAll(repo.baseDir)
+	assert.NoError(t, repo.Publish())
 
-	for _, delgName := range []string{"targets/a", "targets/a/b"} {
-		delgKey := createKey(t, repo, delgName, false)
-		assert.NoError(t,
-			repo.AddDelegation(delgName, []data.PublicKey{delgKey}, []string{""}),
-			"error creating delegation")
+	for _, role := range data.BaseRoles {
+		canonical, err := repo.CanonicalMetadata(role)
+		assert.NoError(t, err, role)
+
+		var (
+			sigs     []data.Signature
+			baseRole data.BaseRole
+		)
+		baseRole, err = repo.tufRepo.GetBaseRole(role)
+		assert.NoError(t, err)
+		switch role {
+		case data.CanonicalRootRole:
+			sigs = repo.tufRepo.Root.Signatures
+		case data.CanonicalTargetsRole:
+			sigs = repo.tufRepo.Targets[role].Signatures
+		case data.CanonicalSnapshotRole:
+			sigs = repo.tufRepo.Snapshot.Signatures
+		case data.CanonicalTimestampRole:
+			sigs = repo.tufRepo.Timestamp.Signatures
+		}
+		assert.NotEmpty(t, sigs, role)
+		for _, sig := range sigs {
+			key, ok := baseRole.Keys[sig.KeyID]
+			assert.True(t, ok, role)
+			assert.NoError(t, signed.Verifiers[sig.Method].Verify(key, sig.Signature, canonical), role)
+		}
 	}
 
-	// just always check signing now, we've already established we can publish
-	// delgations with and without the metadata and key cache
-	var rec *passRoleRecorder
-	repo, rec = newRepoToTestRepo(t, repo, false)
+	_, err := repo.CanonicalMetadata("targets/missing")
+	assert.Error(t, err)
+	_, err = repo.CanonicalMetadata("invalid")
+	assert.IsType(t, data.ErrInvalidRole{}, err)
+}
+
+// CheckSignatures reports the key ID and status of every signature on the
+// metadata of every role, which are all valid after a publish
+func TestCheckSignatures(t *testing.T) {
+	ts := fullTestServer(t)
+	defer ts.Close()
 
+	repo, _ := initializeRepo(t, data.ECDSAKey, "docker.com/notary", ts.URL, false)
+	defer os.RemoveAll(repo.baseDir)
+	delgKey := createKey(t, repo, "targets/a", false)
+	assert.NoError(t, repo.AddDelegation("targets/a", []data.PublicKey{delgKey}, []string{""}))
+	addTarget(t, repo, "current", "../fixtures/intermediate-ca.crt", "targets/a")
 	assert.NoError(t, repo.Publish())
-	// first time publishing, so all delegation parents get signed
-	rec.assertAsked(t, []string{data.CanonicalTargetsRole, "targets/a", data.CanonicalSnapshotRole})
-	rec.clear()
 
-	// remove targets key - it is not even needed
-	targetsKeys := repo.CryptoService.ListKeys(data.CanonicalTargetsRole)
-	assert.Len(t, targetsKeys, 1)
-	assert.NoError(t, repo.CryptoService.RemoveKey(targetsKeys[0]))
+	checks, err := repo.CheckSignatures()
+	assert.NoError(t, err)
+	roles := make([]string, 0, len(checks))
+	for _, c := range checks {
+		roles = append(roles, c.Role)
+		assert.Equal(t, 1, c.Threshold, c.Role)
+		assert.Len(t, c.Signatures, 1, c.Role)
+		assert.Equal(t, 1, c.Valid(), c.Role)
+		assert.Equal(t, SignatureValid, c.Signatures[0].Status, c.Role)
+	}
+	assert.Equal(t, []string{data.CanonicalRootRole, data.CanonicalTargetsRole, "targets/a",
+		data.CanonicalSnapshotRole, data.CanonicalTimestampRole}, roles)
+	assert.Equal(t, delgKey.ID(), checks[2].Signatures[0].KeyID)
 
-	assertPublishToRolesSucceeds(t, repo, []string{"targets/a/b"},
-		[]string{"targets/a/b"})
+	// a signature that does not verify, one by the key of another role and one
+	// by a key the repository does not list are each flagged
+	s, err := repo.signedMetadata(data.CanonicalTargetsRole)
+	assert.NoError(t, err)
+	targetsRole, err := repo.tufRepo.GetBaseRole(data.CanonicalTargetsRole)
+	assert.NoError(t, err)
+	snapshotRole, err := repo.tufRepo.GetBaseRole(data.CanonicalSnapshotRole)
+	assert.NoError(t, err)
+	sig := s.Signatures[0]
+	known := map[string]struct{}{sig.KeyID: {}}
 
-	// only the target delegation gets signed - snapshot key has already been cached
-	rec.assertAsked(t, []string{"targets/a/b"})
+	assert.Equal(t, SignatureValid, signatureStatus(s.Signed, sig, targetsRole, known))
+	assert.Equal(t, SignatureInvalid, signatureStatus([]byte("tampered"), sig, targetsRole, known))
+	assert.Equal(t, SignatureUnauthorized, signatureStatus(s.Signed, sig, snapshotRole, known))
+	assert.Equal(t, SignatureUnknownKey, signatureStatus(s.Signed, sig, snapshotRole, map[string]struct{}{}))
 }
 
-// If a changelist specifies a particular role to push targets to, and is such
-// a role and the keys are present, publish will write to that role only, and
-// not its parents.  Tests:
-// - case where the local doesn't know about all the roles, and has to download
-//   them before publish.
-// - owner of a repo may not have the delegated keys, so can't sign a delegated
-//   role
-func TestPublishTargetsDelgationSuccessNeedsToDownloadRoles(t *testing.T) {
-	gun := "docker.com/notary"
+// A bundle signed offline can be published later, once its metadata has been
+// verified, but not if it has been tampered with or was already published
+func TestSignOfflineAndPublishBundle(t *testing.T) {
 	ts := fullTestServer(t)
 	defer ts.Close()
 
-	// this is the original repo - it owns the root/targets keys and creates
-	// the delegation to which it doesn't have the key (so server snapshot
-	// signing would be required)
-	ownerRepo, _ := initializeRepo(t, data.ECDSAKey, gun, ts.URL, true)
-	defer os.RemoveAll(ownerRepo.baseDir)
-
-	// this is a user, or otherwise a repo that only has access to the delegation
+	repo, _ := initializeRepo(t, data.ECDSAKey, "docker.com/notary", ts.URL, false)
+	defer os.RemoveAll(repo.baseDir)
+	cl, err := repo.GetChangelist()
+	assert.NoError(t, err)
+
+	// the repository has to be published and updated first
+	addTarget(t, repo, "v1", "../fixtures/intermediate-ca.crt")
+	_, err = repo.SignOffline()
+	assert.Error(t, err)
+	assert.NoError(t, repo.Publish())
+	_, err = repo.Update(false)
+	assert.NoError(t, err)
+
+	addTarget(t, repo, "v2", "../fixtures/intermediate-ca.crt")
+	bundle, err := repo.SignOffline()
+	assert.NoError(t, err)
+	assert.Equal(t, "docker.com/notary", bundle.GUN)
+	assert.Equal(t, []string{data.CanonicalSnapshotRole, data.CanonicalTargetsRole}, bundle.Roles())
+
+	assert.Error(t, repo.PublishBundle(&Bundle{GUN: "docker.com/other", Metadata: bundle.Metadata}))
+	assert.NoError(t, repo.PublishBundle(bundle))
+	assert.Equal(t, bundle.Roles(), repo.PublishedRoles())
+	assert.NoError(t, cl.Clear(""))
+	// the same metadata can't be published twice
+	assert.Error(t, repo.PublishBundle(bundle))
+
+	// the server can't be reached while signing
+	_, err = repo.Update(false)
+	assert.NoError(t, err)
+	addTarget(t, repo, "v3", "../fixtures/intermediate-ca.crt")
+	repo.baseURL = "http://127.0.0.1:9"
+	bundle, err = repo.SignOffline()
+	assert.NoError(t, err)
+	repo.baseURL = ts.URL
+	assert.Equal(t, []string{data.CanonicalSnapshotRole, data.CanonicalTargetsRole}, bundle.Roles())
+
+	s := &data.Signed{}
+	assert.NoError(t, json.Unmarshal(bundle.Metadata[data.CanonicalTargetsRole], s))
+	s.Signatures[0].Signature[0] ^= 0xff
+	tampered, err := json.Marshal(s)
+	assert.NoError(t, err)
+	err = repo.PublishBundle(&Bundle{GUN: bundle.GUN, Metadata: map[string][]byte{
+		data.CanonicalTargetsRole:  tampered,
+		data.CanonicalSnapshotRole: bundle.Metadata[data.CanonicalSnapshotRole],
+	}})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "does not verify")
+
+	assert.NoError(t, repo.PublishBundle(bundle))
+	assert.NoError(t, cl.Clear(""))
+
+	otherRepo, _ := newRepoToTestRepo(t, repo, true)
+	defer os.RemoveAll(otherRepo.baseDir)
+	targets, err := otherRepo.ListTargets(data.CanonicalTargetsRole)
+	assert.NoError(t, err)
+	assert.Len(t, targets, 3)
+}
+
+// A bundle can't replace the root with one that is only signed by the keys it
+// lists itself, rather than by the trusted root keys
+func TestPublishBundleRejectsForeignRoot(t *testing.T) {
+	ts := fullTestServer(t)
+	defer ts.Close()
+
+	repo, _ := initializeRepo(t, data.ECDSAKey, "docker.com/notary", ts.URL, false)
+	defer os.RemoveAll(repo.baseDir)
+	assert.NoError(t, repo.Publish())
+	_, err := repo.Update(false)
+	assert.NoError(t, err)
+
+	// a root for the same GUN, with a newer version, self-signed by keys that
+	// the repository has never trusted
+	foreign, _ := initializeRepo(t, data.ECDSAKey, "docker.com/notary", ts.URL, false)
+	defer os.RemoveAll(foreign.baseDir)
+	foreignRoot, err := foreign.tufRepo.SignRoot(data.DefaultExpires(data.CanonicalRootRole))
+	assert.NoError(t, err)
+	rootJSON, err := json.Marshal(foreignRoot)
+	assert.NoError(t, err)
+
+	err = repo.PublishBundle(&Bundle{GUN: "docker.com/notary", Metadata: map[string][]byte{
+		data.CanonicalRootRole: rootJSON,
+	}})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "trusted root keys")
+
+	// nothing was uploaded
+	_, err = repo.Update(false)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, repo.tufRepo.Root.Signed.Version)
+}
+
+// ResignSnapshot always signs a new snapshot with the local snapshot key,
+// even without staged changes, and fails if the server has the snapshot key
+func TestResignSnapshot(t *testing.T) {
+	ts := fullTestServer(t)
+	defer ts.Close()
+
+	repo, _ := initializeRepo(t, data.ECDSAKey, "docker.com/notary", ts.URL, false)
+	defer os.RemoveAll(repo.baseDir)
+	assert.NoError(t, repo.Publish())
+	_, err := repo.Update(false)
+	assert.NoError(t, err)
+	version := repo.tufRepo.Snapshot.Signed.Version
+
+	bundle, err := repo.ResignSnapshot()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{data.CanonicalSnapshotRole}, bundle.Roles())
+	assert.NoError(t, repo.PublishBundle(bundle))
+	assert.Equal(t, version+1, repo.tufRepo.Snapshot.Signed.Version)
+
+	_, err = repo.Update(false)
+	assert.NoError(t, err)
+	addTarget(t, repo, "v1", "../fixtures/intermediate-ca.crt")
+	bundle, err = repo.ResignSnapshot()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{data.CanonicalSnapshotRole, data.CanonicalTargetsRole}, bundle.Roles())
+
+	serverRepo, _ := initializeRepo(t, data.ECDSAKey, "docker.com/server", ts.URL, true)
+	defer os.RemoveAll(serverRepo.baseDir)
+	assert.NoError(t, serverRepo.Publish())
+	_, err = serverRepo.Update(false)
+	assert.NoError(t, err)
+	_, err = serverRepo.ResignSnapshot()
+	assert.Error(t, err)
+	assert.IsType(t, ErrNoSnapshotKey{}, err)
+
+	// without a snapshot key, signing offline leaves the snapshot to the server
+	addTarget(t, serverRepo, "v1", "../fixtures/intermediate-ca.crt")
+	bundle, err = serverRepo.SignOffline()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{data.CanonicalTargetsRole}, bundle.Roles())
+}
+
+// A repository can be used from several goroutines at once: listing the
+// delegations while targets are staged and published neither races (with
+// -race) nor loses any of the staged targets
+func TestConcurrentRepositoryUse(t *testing.T) {
+	ts := fullTestServer(t)
+	defer ts.Close()
+
+	repo, _ := initializeRepo(t, data.ECDSAKey, "docker.com/notary", ts.URL, false)
+	defer os.RemoveAll(repo.baseDir)
+
+	delgKey := createKey(t, repo, "targets/a", false)
+	assert.NoError(t, repo.AddDelegation("targets/a", []data.PublicKey{delgKey}, []string{""}))
+	assert.NoError(t, repo.Publish())
+
+	const numTargets = 5
+	var wg sync.WaitGroup
+	errs := make(chan error, 3*numTargets)
+	for i := 0; i < numTargets; i++ {
+		wg.Add(3)
+		go func() {
+			defer wg.Done()
+			roles, err := repo.GetDelegationRoles()
+			if err == nil && len(roles) != 1 {
+				err = fmt.Errorf("expected 1 delegation, got %d", len(roles))
+			}
+			errs <- err
+		}()
+		go func(i int) {
+			defer wg.Done()
+			target, err := NewTarget(fmt.Sprintf("target%d", i), "../fixtures/intermediate-ca.crt")
+			if err == nil {
+				err = repo.AddTarget(target, "targets/a")
+			}
+			errs <- err
+		}(i)
+		go func() {
+			defer wg.Done()
+			errs <- repo.Publish()
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		assert.NoError(t, err)
+	}
+
+	assert.NoError(t, repo.Publish())
+	assert.Len(t, getChanges(t, repo), 0)
+	targets, err := repo.ListTargets("targets/a")
+	assert.NoError(t, err)
+	assert.Len(t, targets, numTargets)
+}
+
+// A delegation given an expiry is listed along with it, and once it has
+// expired neither its targets nor those of the delegations below it are
+// trusted any longer, although other delegations still are.
+func TestDelegationExpiry(t *testing.T) {
+	ts := fullTestServer(t)
+	defer ts.Close()
+
+	repo, _ := initializeRepo(t, data.ECDSAKey, "docker.com/notary", ts.URL, false)
+	defer os.RemoveAll(repo.baseDir)
+
+	delgKey := createKey(t, repo, "targets/a", false)
+	for _, delgName := range []string{"targets/a", "targets/a/b", "targets/c"} {
+		assert.NoError(t, repo.AddDelegation(delgName, []data.PublicKey{delgKey}, []string{""}))
+	}
+	assert.NoError(t, repo.Publish())
+	addTarget(t, repo, "a", "../fixtures/intermediate-ca.crt", "targets/a")
+	addTarget(t, repo, "b", "../fixtures/intermediate-ca.crt", "targets/a/b")
+	addTarget(t, repo, "c", "../fixtures/intermediate-ca.crt", "targets/c")
+	assert.NoError(t, repo.Publish())
+
+	future := time.Now().Add(time.Hour).UTC().Round(time.Second)
+	assert.NoError(t, repo.SetDelegationExpiry("targets/c", future))
+	assert.NoError(t, repo.Publish())
+
+	roles, err := repo.GetDelegationRoles()
+	assert.NoError(t, err)
+	for _, role := range roles {
+		if role.Name == "targets/c" {
+			assert.NotNil(t, role.ValidUntil)
+			assert.True(t, future.Equal(*role.ValidUntil))
+		} else {
+			assert.Nil(t, role.ValidUntil)
+		}
+	}
+	targets, err := repo.ListTargets()
+	assert.NoError(t, err)
+	assert.Len(t, targets, 3)
+
+	past := time.Now().Add(-time.Hour)
+	assert.NoError(t, repo.SetDelegationExpiry("targets/a", past))
+	assert.NoError(t, repo.Publish())
+
+	// use a fresh repo, so the metadata is verified again
+	repo2, _ := newRepoToTestRepo(t, repo, false)
+	defer os.RemoveAll(repo2.baseDir)
+
+	targets, err = repo2.ListTargets()
+	assert.NoError(t, err)
+	assert.Len(t, targets, 1)
+	assert.Equal(t, "c", targets[0].Name)
+
+	for _, name := range []string{"a", "b"} {
+		_, err = repo2.GetTargetByName(name)
+		assert.Error(t, err)
+	}
+	_, err = repo2.GetTargetByName("c")
+	assert.NoError(t, err)
+
+	// like a missing delegation, an expired one can't be signed for, so its
+	// targets go to its parent instead
+	addTarget(t, repo2, "a2", "../fixtures/intermediate-ca.crt", "targets/a")
+	assert.NoError(t, repo2.Publish())
+	a2, err := repo2.GetTargetByName("a2")
+	assert.NoError(t, err)
+	assert.Equal(t, data.CanonicalTargetsRole, a2.Role)
+
+	// the expired delegation can be given a new expiry
+	assert.NoError(t, repo2.SetDelegationExpiry("targets/a", future))
+	assert.NoError(t, repo2.Publish())
+	targets, err = repo2.ListTargets()
+	assert.NoError(t, err)
+	assert.Len(t, targets, 4)
+
+	assert.Error(t, repo.SetDelegationExpiry(data.CanonicalTargetsRole, future))
+}
+
+// DelegationHistory lists the versions of the parent's metadata downloaded by
+// the client in which the delegation was created, changed or removed
+func TestDelegationHistory(t *testing.T) {
+	ts := fullTestServer(t)
+	defer ts.Close()
+
+	repo, _ := initializeRepo(t, data.ECDSAKey, "docker.com/notary", ts.URL, false)
+	defer os.RemoveAll(repo.baseDir)
+
+	publishAndUpdate := func() int {
+		assert.NoError(t, repo.Publish())
+		_, err := repo.Update(false)
+		assert.NoError(t, err)
+		return repo.tufRepo.Targets[data.CanonicalTargetsRole].Signed.Version
+	}
+	publishAndUpdate()
+
+	changes, err := repo.DelegationHistory("targets/a")
+	assert.NoError(t, err)
+	assert.Empty(t, changes)
+
+	key1 := createKey(t, repo, "targets/a", false)
+	key2 := createKey(t, repo, "targets/a", false)
+	keyID1, err := utils.CanonicalKeyID(key1)
+	assert.NoError(t, err)
+	keyID2, err := utils.CanonicalKeyID(key2)
+	assert.NoError(t, err)
+
+	assert.NoError(t, repo.AddDelegation("targets/a", []data.PublicKey{key1}, []string{"a"}))
+	created := publishAndUpdate()
+
+	assert.NoError(t, repo.AddDelegation("targets/a", []data.PublicKey{key2}, []string{"b"}))
+	assert.NoError(t, repo.RemoveDelegationPaths("targets/a", []string{"a"}))
+	changed := publishAndUpdate()
+
+	// a change to another delegation is not a change to this one
+	assert.NoError(t, repo.AddDelegation("targets/c", []data.PublicKey{key1}, []string{""}))
+	publishAndUpdate()
+
+	assert.NoError(t, repo.RemoveDelegationRole("targets/a"))
+	removed := publishAndUpdate()
+
+	changes, err = repo.DelegationHistory("targets/a")
+	assert.NoError(t, err)
+	assert.Len(t, changes, 3)
+
+	assert.Equal(t, created, changes[0].Version)
+	assert.True(t, changes[0].Created)
+	assert.Equal(t, []string{keyID1}, changes[0].KeyIDs)
+	assert.Equal(t, []string{keyID1}, changes[0].AddedKeys)
+	assert.Equal(t, []string{"a"}, changes[0].AddedPaths)
+	assert.Equal(t, 1, changes[0].Threshold)
+	assert.False(t, changes[0].Seen.IsZero())
+
+	assert.Equal(t, changed, changes[1].Version)
+	assert.False(t, changes[1].Created)
+	assert.Len(t, changes[1].KeyIDs, 2)
+	assert.Equal(t, []string{keyID2}, changes[1].AddedKeys)
+	assert.Empty(t, changes[1].RemovedKeys)
+	assert.Equal(t, []string{"b"}, changes[1].AddedPaths)
+	assert.Equal(t, []string{"a"}, changes[1].RemovedPaths)
+	assert.Equal(t, 0, changes[1].PreviousThreshold)
+
+	assert.Equal(t, removed, changes[2].Version)
+	assert.True(t, changes[2].Removed)
+
+	_, err = repo.DelegationHistory(data.CanonicalTargetsRole)
+	assert.Error(t, err)
+	_, err = repo.DelegationHistory("targets/missing/child")
+	assert.Error(t, err)
+	assert.IsType(t, data.ErrNoSuchRole{}, err)
+}
+
+// Publishing prunes the history of each role down to the latest version and
+// HistoryLimit versions before it
+func TestPublishPrunesHistory(t *testing.T) {
+	ts := fullTestServer(t)
+	defer ts.Close()
+
+	repo, _ := initializeRepo(t, data.ECDSAKey, "docker.com/notary", ts.URL, false)
+	defer os.RemoveAll(repo.baseDir)
+	assert.Equal(t, DefaultHistoryLimit, repo.HistoryLimit)
+
+	publishAndUpdate := func(target string) int {
+		addTarget(t, repo, target, "../fixtures/intermediate-ca.crt")
+		assert.NoError(t, repo.Publish())
+		_, err := repo.Update(false)
+		assert.NoError(t, err)
+		return repo.tufRepo.Targets[data.CanonicalTargetsRole].Signed.Version
+	}
+	historyVersions := func() []int {
+		var versions []int
+		latest := repo.tufRepo.Targets[data.CanonicalTargetsRole].Signed.Version
+		for version := 1; version <= latest; version++ {
+			if _, err := repo.historyStore.GetMeta(historyName(data.CanonicalTargetsRole, version), -1); err == nil {
+				versions = append(versions, version)
+			}
+		}
+		return versions
+	}
+
+	repo.HistoryLimit = 2
+	var versions []int
+	for _, target := range []string{"v1", "v2", "v3", "v4"} {
+		versions = append(versions, publishAndUpdate(target))
+	}
+	assert.Equal(t, versions[1:], historyVersions())
+
+	repo.HistoryLimit = 0
+	latest := publishAndUpdate("v5")
+	assert.Equal(t, []int{latest}, historyVersions())
+}
+
+// With StrictExpiry, reading from a repository fails if anything expires
+// within the window, but publishing, which renews the metadata, does not
+func TestStrictExpiry(t *testing.T) {
+	ts := fullTestServer(t)
+	defer ts.Close()
+
+	repo, _ := initializeRepo(t, data.ECDSAKey, "docker.com/notary", ts.URL, false)
+	defer os.RemoveAll(repo.baseDir)
+	addTarget(t, repo, "v1", "../fixtures/intermediate-ca.crt")
+	assert.NoError(t, repo.Publish())
+
+	repo.StrictExpiry = time.Hour
+	_, err := repo.ListTargets()
+	assert.NoError(t, err)
+
+	// the timestamp, which the server renews once it expires, is left out
+	repo.StrictExpiry = 30 * 24 * time.Hour
+	_, err = repo.ListTargets()
+	assert.NoError(t, err)
+
+	// the targets and the local snapshot expire within a few years
+	repo.StrictExpiry = 4 * 365 * 24 * time.Hour
+	_, err = repo.ListTargets()
+	assert.Error(t, err)
+	expiresSoon, ok := err.(ErrExpiresSoon)
+	assert.True(t, ok, "expected ErrExpiresSoon, got %v", err)
+	assert.Equal(t, "metadata", expiresSoon.What)
+
+	addTarget(t, repo, "v2", "../fixtures/intermediate-ca.crt")
+	assert.NoError(t, repo.Publish())
+	_, err = repo.GetTargetByName("v2")
+	assert.Error(t, err)
+
+	// certificates are checked as well as the metadata
+	repo.StrictExpiry = 0
+	_, err = repo.Update(false)
+	assert.NoError(t, err)
+	repo.tufRepo.Root.Signed.Expires = time.Now().AddDate(20, 0, 0)
+	repo.StrictExpiry = 11 * 365 * 24 * time.Hour
+	err = repo.checkStrictExpiry(time.Now())
+	assert.Error(t, err)
+	expiresSoon, ok = err.(ErrExpiresSoon)
+	assert.True(t, ok, "expected ErrExpiresSoon, got %v", err)
+	assert.Equal(t, data.CanonicalRootRole, expiresSoon.Role)
+	assert.Contains(t, expiresSoon.What, "certificate")
+}
+
+// With AsOf, the metadata is checked for expiry at that time rather than now
+func TestAsOf(t *testing.T) {
+	ts := fullTestServer(t)
+	defer ts.Close()
+
+	repo, _ := initializeRepo(t, data.ECDSAKey, "docker.com/notary", ts.URL, false)
+	defer os.RemoveAll(repo.baseDir)
+	addTarget(t, repo, "v1", "../fixtures/intermediate-ca.crt")
+	assert.NoError(t, repo.Publish())
+
+	repo.AsOf = time.Now().Add(-time.Hour)
+	_, err := repo.ListTargets()
+	assert.NoError(t, err)
+
+	// the timestamp expires within a few weeks
+	repo.AsOf = time.Now().AddDate(0, 2, 0)
+	_, err = repo.ListTargets()
+	assert.Error(t, err)
+	_, ok := err.(signed.ErrExpired)
+	assert.True(t, ok, "expected ErrExpired, got %v", err)
+
+	// the strict expiry window is counted from AsOf
+	repo.AsOf = time.Now().AddDate(0, 0, 1)
+	repo.StrictExpiry = 24 * time.Hour
+	_, err = repo.ListTargets()
+	assert.NoError(t, err)
+	repo.StrictExpiry = 30 * 24 * time.Hour
+	_, err = repo.ListTargets()
+	_, ok = err.(ErrExpiresSoon)
+	assert.True(t, ok, "expected ErrExpiresSoon, got %v", err)
+
+	repo.AsOf = time.Time{}
+	repo.StrictExpiry = 0
+	_, err = repo.ListTargets()
+	assert.NoError(t, err)
+}
+
+// With server keys pinned, updating fails unless the timestamp, and the
+// snapshot if it is pinned too, are signed by one of the pinned keys
+func TestServerKeyPins(t *testing.T) {
+	ts := fullTestServer(t)
+	defer ts.Close()
+
+	repo, _ := initializeRepo(t, data.ECDSAKey, "docker.com/notary", ts.URL, true)
+	defer os.RemoveAll(repo.baseDir)
+	assert.NoError(t, repo.Publish())
+
+	root := repo.tufRepo.Root.Signed
+	timestampKeyID := root.Roles[data.CanonicalTimestampRole].KeyIDs[0]
+	snapshotKeyID := root.Roles[data.CanonicalSnapshotRole].KeyIDs[0]
+
+	repo.ServerKeyPins = map[string][]string{
+		data.CanonicalTimestampRole: {"unknown", timestampKeyID},
+		data.CanonicalSnapshotRole:  {snapshotKeyID},
+	}
+	_, err := repo.ListTargets()
+	assert.NoError(t, err)
+
+	// a key of another role doesn't count
+	repo.ServerKeyPins = map[string][]string{data.CanonicalTimestampRole: {snapshotKeyID}}
+	_, err = repo.ListTargets()
+	assert.Error(t, err)
+	assert.IsType(t, ErrUnexpectedServerKey{}, err)
+	assert.Equal(t, data.CanonicalTimestampRole, err.(ErrUnexpectedServerKey).Role)
+	assert.Equal(t, []string{timestampKeyID}, err.(ErrUnexpectedServerKey).KeyIDs)
+
+	repo.ServerKeyPins = map[string][]string{data.CanonicalSnapshotRole: {"unknown"}}
+	_, err = repo.ListTargets()
+	assert.IsType(t, ErrUnexpectedServerKey{}, err)
+
+	repo.ServerKeyPins = map[string][]string{data.CanonicalTargetsRole: {timestampKeyID}}
+	_, err = repo.ListTargets()
+	assert.IsType(t, data.ErrInvalidRole{}, err)
+}
+
+// GetDelegationRolesToDepth only returns delegations down to the requested
+// depth in the delegation tree, and a negative depth returns all of them.
+func TestGetDelegationRolesToDepth(t *testing.T) {
+	ts := fullTestServer(t)
+	defer ts.Close()
+
+	repo, _ := initializeRepo(t, data.ECDSAKey, "docker.com/notary", ts.URL, false)
+	defer os.RemoveAll(repo.baseDir)
+
+	delgKey := createKey(t, repo, "targets/a", false)
+	for _, delgName := range []string{"targets/a", "targets/a/b", "targets/a/b/c", "targets/d"} {
+		assert.NoError(t,
+			repo.AddDelegation(delgName, []data.PublicKey{delgKey}, []string{""}),
+			"error creating delegation")
+	}
+	assert.NoError(t, repo.Publish())
+
+	expected := map[int][]string{
+		0:  {"targets/a", "targets/d"},
+		1:  {"targets/a", "targets/a/b", "targets/d"},
+		2:  {"targets/a", "targets/a/b", "targets/a/b/c", "targets/d"},
+		-1: {"targets/a", "targets/a/b", "targets/a/b/c", "targets/d"},
+	}
+	for depth, expectedNames := range expected {
+		roles, err := repo.GetDelegationRolesToDepth(depth)
+		assert.NoError(t, err)
+
+		var names []string
+		for _, r := range roles {
+			names = append(names, r.Name)
+		}
+		sort.Strings(names)
+		assert.Equal(t, expectedNames, names, "wrong delegations listed for depth %d", depth)
+	}
+}
+
+// A single delegation role can be retrieved along with its keys, indexed by
+// canonical key ID.  Missing roles produce ErrNoSuchRole.
+func TestGetDelegationRole(t *testing.T) {
+	ts := fullTestServer(t)
+	defer ts.Close()
+
+	repo, _ := initializeRepo(t, data.ECDSAKey, "docker.com/notary", ts.URL, false)
+	defer os.RemoveAll(repo.baseDir)
+
+	delgKey := createKey(t, repo, "targets/a", true)
+	assert.NoError(t,
+		repo.AddDelegation("targets/a", []data.PublicKey{delgKey}, []string{"a/"}),
+		"error creating delegation")
+	assert.NoError(t, repo.Publish())
+
+	canonicalID, err := utils.CanonicalKeyID(delgKey)
+	assert.NoError(t, err)
+
+	role, keys, err := repo.GetDelegationRole("targets/a")
+	assert.NoError(t, err)
+	assert.Equal(t, "targets/a", role.Name)
+	assert.Equal(t, []string{canonicalID}, role.KeyIDs)
+	assert.Equal(t, []string{"a/"}, role.Paths)
+	assert.Len(t, keys, 1)
+	assert.Equal(t, delgKey.ID(), keys[canonicalID].ID())
+
+	for _, missing := range []string{"targets/b", "targets/b/c"} {
+		_, _, err = repo.GetDelegationRole(missing)
+		assert.IsType(t, data.ErrNoSuchRole{}, err, "expected no such role for %s", missing)
+	}
+	_, _, err = repo.GetDelegationRole("invalid")
+	assert.IsType(t, data.ErrInvalidRole{}, err)
+}
+
+// Rotating a delegation key replaces only that key, leaving the other keys
+// and the paths of the delegation alone.
+func TestRotateDelegationKey(t *testing.T) {
+	ts := fullTestServer(t)
+	defer ts.Close()
+
+	repo, _ := initializeRepo(t, data.ECDSAKey, "docker.com/notary", ts.URL, false)
+	defer os.RemoveAll(repo.baseDir)
+
+	keepKey := createKey(t, repo, "targets/a", false)
+	oldKey := createKey(t, repo, "targets/a", false)
+	newKey := createKey(t, repo, "targets/a", false)
+	assert.NoError(t,
+		repo.AddDelegation("targets/a", []data.PublicKey{keepKey, oldKey}, []string{"a/"}),
+		"error creating delegation")
+	assert.NoError(t, repo.Publish())
+
+	assert.IsType(t, data.ErrInvalidRole{}, repo.RotateDelegationKey("invalid", oldKey.ID(), newKey))
+	assert.IsType(t, data.ErrNoSuchRole{}, repo.RotateDelegationKey("targets/nope", oldKey.ID(), newKey))
+
+	// a key ID that is not one of the role's keys is rejected without staging
+	// anything, so the new key is not added alongside the old one
+	err := repo.RotateDelegationKey("targets/a", newKey.ID(), keepKey)
+	assert.IsType(t, data.ErrInvalidRole{}, err)
+	assert.Contains(t, err.Error(), "is not a key of the delegation role")
+	assert.Len(t, getChanges(t, repo), 0)
+
+	assert.NoError(t, repo.RotateDelegationKey("targets/a", oldKey.ID(), newKey))
+	assert.Len(t, getChanges(t, repo), 1)
+	assert.NoError(t, repo.Publish())
+
+	role, keys, err := repo.GetDelegationRole("targets/a")
+	assert.NoError(t, err)
+	assert.Len(t, keys, 2)
+	for keyID, present := range map[string]bool{
+		keepKey.ID(): true, newKey.ID(): true, oldKey.ID(): false} {
+		_, ok := keys[keyID]
+		assert.Equal(t, present, ok, "wrong presence of key %s", keyID)
+	}
+	assert.Equal(t, []string{"a/"}, role.Paths)
+	assert.Equal(t, 1, role.Threshold)
+
+	// rotating the only key in a role does not delete the role
+	assert.NoError(t, repo.RemoveDelegationKeys("targets/a", []string{keepKey.ID()}))
+	assert.NoError(t, repo.Publish())
+	assert.NoError(t, repo.RotateDelegationKey("targets/a", newKey.ID(), oldKey))
+	assert.NoError(t, repo.Publish())
+
+	_, keys, err = repo.GetDelegationRole("targets/a")
+	assert.NoError(t, err)
+	assert.Len(t, keys, 1)
+	_, ok := keys[oldKey.ID()]
+	assert.True(t, ok)
+}
+
+// Replacing a delegation stages a single change that sets exactly the given
+// keys and paths, dropping the existing ones, while adding to a delegation
+// merges into them.  The expiry of the delegation is kept.
+func TestReplaceDelegation(t *testing.T) {
+	ts := fullTestServer(t)
+	defer ts.Close()
+
+	repo, _ := initializeRepo(t, data.ECDSAKey, "docker.com/notary", ts.URL, false)
+	defer os.RemoveAll(repo.baseDir)
+
+	oldKey := createKey(t, repo, "targets/a", false)
+	newKey := createKey(t, repo, "targets/a", false)
+	validUntil := time.Now().Add(24 * time.Hour).UTC().Round(time.Second)
+	assert.NoError(t,
+		repo.AddDelegation("targets/a", []data.PublicKey{oldKey}, []string{"a/", "b/"}),
+		"error creating delegation")
+	assert.NoError(t, repo.SetDelegationExpiry("targets/a", validUntil))
+	assert.NoError(t, repo.Publish())
+
+	assert.IsType(t, data.ErrInvalidRole{}, repo.ReplaceDelegation("invalid", []data.PublicKey{newKey}, nil))
+	assert.IsType(t, data.ErrInvalidRole{}, repo.ReplaceDelegation("targets/a", nil, []string{"c/"}))
+	assert.Len(t, getChanges(t, repo), 0)
+
+	assert.NoError(t, repo.ReplaceDelegation("targets/a", []data.PublicKey{newKey}, []string{"c/"}))
+	assert.Len(t, getChanges(t, repo), 1)
+	assert.NoError(t, repo.Publish())
+
+	role, keys, err := repo.GetDelegationRole("targets/a")
+	assert.NoError(t, err)
+	assert.Len(t, keys, 1)
+	_, ok := keys[newKey.ID()]
+	assert.True(t, ok)
+	assert.Equal(t, []string{"c/"}, role.Paths)
+	assert.Equal(t, 1, role.Threshold)
+	assert.NotNil(t, role.ValidUntil)
+	assert.True(t, validUntil.Equal(*role.ValidUntil))
+
+	// adding still merges into the existing keys and paths
+	assert.NoError(t, repo.AddDelegation("targets/a", []data.PublicKey{oldKey}, []string{"a/"}))
+	assert.NoError(t, repo.Publish())
+	role, keys, err = repo.GetDelegationRole("targets/a")
+	assert.NoError(t, err)
+	assert.Len(t, keys, 2)
+	assert.Equal(t, []string{"c/", "a/"}, role.Paths)
+
+	// replacing a delegation that doesn't exist creates it
+	assert.NoError(t, repo.ReplaceDelegation("targets/b", []data.PublicKey{newKey}, []string{""}))
+	assert.NoError(t, repo.Publish())
+	role, _, err = repo.GetDelegationRole("targets/b")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{""}, role.Paths)
+}
+
+// With OnlyRoles, reading from the repository skips the unrelated delegation
+// branches, but publishing still uses every role
+func TestOnlyRoles(t *testing.T) {
+	ts := fullTestServer(t)
+	defer ts.Close()
+
+	repo, _ := initializeRepo(t, data.ECDSAKey, "docker.com/notary", ts.URL, false)
+	defer os.RemoveAll(repo.baseDir)
+
+	for _, role := range []string{"targets/a", "targets/b"} {
+		key := createKey(t, repo, role, false)
+		assert.NoError(t, repo.AddDelegation(role, []data.PublicKey{key}, []string{""}))
+	}
+	addTarget(t, repo, "a", "../fixtures/root-ca.crt", "targets/a")
+	addTarget(t, repo, "b", "../fixtures/root-ca.crt", "targets/b")
+	assert.NoError(t, repo.Publish())
+
+	repo.OnlyRoles = []string{"targets/a"}
+	targets, err := repo.ListTargets("targets/a", "targets/b", data.CanonicalTargetsRole)
+	assert.NoError(t, err)
+	assert.Len(t, targets, 1)
+	assert.Equal(t, "a", targets[0].Name)
+	_, err = repo.GetTargetByName("b")
+	assert.Error(t, err)
+
+	addTarget(t, repo, "b2", "../fixtures/root-ca.crt", "targets/b")
+	assert.NoError(t, repo.Publish())
+	repo.OnlyRoles = nil
+	targets, err = repo.ListTargets("targets/b")
+	assert.NoError(t, err)
+	assert.Len(t, targets, 2)
+}
+
+// Delegations are matched by name, and only the ones that differ in keys,
+// threshold or paths are reported
+func TestCompareDelegations(t *testing.T) {
+	same := &data.Role{RootRole: data.RootRole{KeyIDs: []string{"1"}, Threshold: 1},
+		Name: "targets/same", Paths: []string{""}}
+	first := []*data.Role{
+		same,
+		{RootRole: data.RootRole{KeyIDs: []string{"1", "2"}, Threshold: 1}, Name: "targets/changed", Paths: []string{"a", "b"}},
+		{RootRole: data.RootRole{KeyIDs: []string{"1"}, Threshold: 1}, Name: "targets/first"},
+	}
+	second := []*data.Role{
+		{RootRole: data.RootRole{KeyIDs: []string{"2", "3"}, Threshold: 2}, Name: "targets/changed", Paths: []string{"b", "c"}},
+		same,
+		{RootRole: data.RootRole{KeyIDs: []string{"1"}, Threshold: 1}, Name: "targets/second"},
+	}
+
+	assert.Empty(t, CompareDelegations(first, first))
+	assert.Equal(t, []DelegationDifference{
+		{Role: "targets/changed", FirstThreshold: 1, SecondThreshold: 2,
+			KeysOnlyInFirst: []string{"1"}, KeysOnlyInSecond: []string{"3"},
+			PathsOnlyInFirst: []string{"a"}, PathsOnlyInSecond: []string{"c"}},
+		{Role: "targets/first", OnlyInFirst: true},
+		{Role: "targets/second", OnlyInSecond: true},
+	}, CompareDelegations(first, second))
+}
+
+// The details of a delegation include the resolved keys, with the algorithm
+// and expiry of each key (if the key is a certificate), and whether a signing
+// key for it is available locally.
+func TestNewDelegationDetail(t *testing.T) {
+	privKey, err := trustmanager.GenerateECDSAKey(rand.Reader)
+	assert.NoError(t, err)
+	cert, err := cryptoservice.GenerateTestingCertificate(privKey.CryptoSigner(), "docker.com/notary")
+	assert.NoError(t, err)
+	certKey := trustmanager.CertToKey(cert)
+	rawKey := data.NewPublicKey(data.ED25519Key, []byte("1"))
+
+	role := &data.Role{
+		Name:     "targets/bee",
+		Paths:    []string{"honey", "comb"},
+		RootRole: data.RootRole{KeyIDs: []string{"111", "222"}, Threshold: 1},
+	}
+	keys := data.Keys{"111": certKey, "222": rawKey}
+	detail := newDelegationDetail(role, keys, map[string]string{"222": "targets/bee"})
+
+	assert.Equal(t, "targets/bee", detail.Name)
+	assert.Equal(t, 1, detail.Threshold)
+	assert.Equal(t, []string{"honey", "comb"}, detail.Paths)
+	assert.Len(t, detail.Keys, 2)
+	assert.Equal(t, "111", detail.Keys[0].ID)
+	assert.Equal(t, certKey, detail.Keys[0].PublicKey)
+	assert.Equal(t, data.ECDSAx509Key, detail.Keys[0].Algorithm)
+	assert.Equal(t, "ECDSA P-256", detail.Keys[0].KeyType)
+	assert.NotNil(t, detail.Keys[0].Expiry)
+	assert.Equal(t, cert.NotAfter, *detail.Keys[0].Expiry)
+	assert.False(t, detail.Keys[0].CanSign)
+	assert.Equal(t, data.ED25519Key, detail.Keys[1].Algorithm)
+	assert.Equal(t, "ed25519", detail.Keys[1].KeyType)
+	assert.Nil(t, detail.Keys[1].Expiry)
+	assert.True(t, detail.Keys[1].CanSign)
+}
+
+// With the repo's own keystore, which lists non-root keys by path, the
+// details of a delegation show that its key can sign.  Getting the details
+// translates key IDs to canonical IDs without changing the key IDs in the
+// loaded metadata.
+func TestGetDelegationDetailWithKeystore(t *testing.T) {
+	ts := fullTestServer(t)
+	defer ts.Close()
+
+	repo, _ := initializeRepo(t, data.ECDSAKey, "docker.com/notary", ts.URL, false)
+	defer os.RemoveAll(repo.baseDir)
+
+	certKey := createKey(t, repo, "targets/a", true)
+	assert.NoError(t, repo.AddDelegation("targets/a", []data.PublicKey{certKey}, []string{""}))
+	assert.NoError(t, repo.Publish())
+	canonicalID, err := utils.CanonicalKeyID(certKey)
+	assert.NoError(t, err)
+	assert.NotEqual(t, certKey.ID(), canonicalID)
+
+	detail, err := repo.GetDelegationDetail("targets/a")
+	assert.NoError(t, err)
+	assert.Len(t, detail.Keys, 1)
+	assert.Equal(t, canonicalID, detail.Keys[0].ID)
+	assert.True(t, detail.Keys[0].CanSign)
+
+	_, err = repo.ListDelegationDetails()
+	assert.NoError(t, err)
+	loaded := repo.tufRepo.Targets[data.CanonicalTargetsRole].Signed.Delegations.Roles
+	assert.Len(t, loaded, 1)
+	assert.Equal(t, []string{certKey.ID()}, loaded[0].KeyIDs)
+}
+
+// ListDelegationDetails returns the details of all the delegations, nested
+// ones included
+func TestListDelegationDetails(t *testing.T) {
+	ts := fullTestServer(t)
+	defer ts.Close()
+
+	repo, _ := initializeRepo(t, data.ECDSAKey, "docker.com/notary", ts.URL, false)
+	defer os.RemoveAll(repo.baseDir)
+
+	ownedKey := createKey(t, repo, "targets/a", true)
+	otherKey := createKey(t, repo, "targets/a/b", true)
+	assert.NoError(t, repo.AddDelegation("targets/a", []data.PublicKey{ownedKey}, []string{""}))
+	assert.NoError(t, repo.AddDelegation("targets/a/b", []data.PublicKey{otherKey}, []string{"b/"}))
+	assert.NoError(t, repo.Publish())
+
+	// forget the private key for targets/a/b
+	otherID, err := utils.CanonicalKeyID(otherKey)
+	assert.NoError(t, err)
+	assert.NoError(t, repo.CryptoService.RemoveKey(otherID))
+
+	details, err := repo.ListDelegationDetails()
+	assert.NoError(t, err)
+	assert.Len(t, details, 2)
+
+	byName := make(map[string]DelegationDetail)
+	for _, d := range details {
+		byName[d.Name] = d
+	}
+	for name, canSign := range map[string]bool{"targets/a": true, "targets/a/b": false} {
+		d, ok := byName[name]
+		assert.True(t, ok, "missing details for %s", name)
+		assert.Len(t, d.Keys, 1)
+		assert.Equal(t, canSign, d.Keys[0].CanSign, "wrong signability for %s", name)
+		assert.NotNil(t, d.Keys[0].Expiry)
+		assert.Equal(t, data.ECDSAx509Key, d.Keys[0].Algorithm)
+	}
+	assert.Equal(t, []string{"b/"}, byName["targets/a/b"].Paths)
+	// targets/a has been published, since it delegates to targets/a/b
+	assert.NotNil(t, byName["targets/a"].Expires)
+}
+
+// Renaming a delegation publishes a new role with the old role's keys,
+// threshold, paths and targets, and removes the old role.
+func TestRenameDelegation(t *testing.T) {
+	ts := fullTestServer(t)
+	defer ts.Close()
+
+	repo, _ := initializeRepo(t, data.ECDSAKey, "docker.com/notary", ts.URL, false)
+	defer os.RemoveAll(repo.baseDir)
+
+	delgKey := createKey(t, repo, "targets/a", false)
+	assert.NoError(t,
+		repo.AddDelegation("targets/a", []data.PublicKey{delgKey}, []string{"a/"}),
+		"error creating delegation")
+	assert.NoError(t, repo.Publish())
+	addTarget(t, repo, "a/current", "../fixtures/intermediate-ca.crt", "targets/a")
+	assert.NoError(t, repo.Publish())
+
+	before, err := repo.GetDelegationRoles()
+	assert.NoError(t, err)
+	assert.Len(t, before, 1)
+
+	// invalid names, the same name, a name in the role's own subtree, and
+	// unknown roles are rejected
+	assert.IsType(t, data.ErrInvalidRole{}, repo.RenameDelegation("targets/a", "bad/name"))
+	assert.IsType(t, data.ErrInvalidRole{}, repo.RenameDelegation("targets/a", "targets/a"))
+	assert.IsType(t, data.ErrInvalidRole{}, repo.RenameDelegation("targets/a", "targets/a/b"))
+	assert.IsType(t, data.ErrNoSuchRole{}, repo.RenameDelegation("targets/nope", "targets/b"))
+	assert.Len(t, getChanges(t, repo), 0)
+
+	assert.NoError(t, repo.RenameDelegation("targets/a", "targets/b"))
+	assert.Len(t, getChanges(t, repo), 3)
+	assert.NoError(t, repo.Publish())
+
+	after, err := repo.GetDelegationRoles()
+	assert.NoError(t, err)
+	assert.Len(t, after, 1)
+	assert.Equal(t, "targets/b", after[0].Name)
+	assert.Equal(t, before[0].KeyIDs, after[0].KeyIDs)
+	assert.Equal(t, before[0].Threshold, after[0].Threshold)
+	assert.Equal(t, before[0].Paths, after[0].Paths)
+
+	targets, err := repo.ListTargets("targets/b")
+	assert.NoError(t, err)
+	assert.Len(t, targets, 1)
+	assert.Equal(t, "a/current", targets[0].Name)
+	assert.Equal(t, "targets/b", targets[0].Role)
+}
+
+// The existing keys of a delegation are the ones in the latest metadata, with
+// the staged changes applied, whether or not the repository can be reached
+func TestExistingDelegationKeys(t *testing.T) {
+	ts := fullTestServer(t)
+	defer ts.Close()
+
+	repo, _ := initializeRepo(t, data.ECDSAKey, "docker.com/notary", ts.URL, false)
+	defer os.RemoveAll(repo.baseDir)
+
+	key1 := createKey(t, repo, "targets/a", true)
+	key2 := createKey(t, repo, "targets/a", false)
+	key1ID, err := utils.CanonicalKeyID(key1)
+	assert.NoError(t, err)
+
+	_, err = repo.ExistingDelegationKeys("bad/role", []data.PublicKey{key1})
+	assert.IsType(t, data.ErrInvalidRole{}, err)
+
+	// nothing has been published or staged yet
+	existing, err := repo.ExistingDelegationKeys("targets/a", []data.PublicKey{key1, key2})
+	assert.NoError(t, err)
+	assert.Empty(t, existing)
+
+	// the staged keys count, even offline
+	assert.NoError(t, repo.AddDelegation("targets/a", []data.PublicKey{key1}, []string{""}))
+	roundTrip := repo.roundTrip
+	repo.roundTrip = nil
+	existing, err = repo.ExistingDelegationKeys("targets/a", []data.PublicKey{key1, key2})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{key1ID}, existing)
+	repo.roundTrip = roundTrip
+
+	assert.NoError(t, repo.Publish())
+	existing, err = repo.ExistingDelegationKeys("targets/a", []data.PublicKey{key1, key2})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{key1ID}, existing)
+
+	offlineRepo, _ := newRepoToTestRepo(t, repo, false)
+	offlineRepo.roundTrip = nil
+	existing, err = offlineRepo.ExistingDelegationKeys("targets/a", []data.PublicKey{key1, key2})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{key1ID}, existing)
+
+	// keys that are staged to be removed don't
+	assert.NoError(t, repo.AddDelegation("targets/a", []data.PublicKey{key2}, nil))
+	assert.NoError(t, repo.RemoveDelegationKeys("targets/a", []string{key1ID}))
+	existing, err = repo.ExistingDelegationKeys("targets/a", []data.PublicKey{key1, key2})
+	assert.NoError(t, err)
+	assert.Len(t, existing, 1)
+	assert.NotEqual(t, key1ID, existing[0])
+}
+
+// Cloning a repository stages its delegation roles, parents first, on another
+// repository, with either the same keys or newly generated ones
+func TestClone(t *testing.T) {
+	ts := fullTestServer(t)
+	defer ts.Close()
+
+	src, _ := initializeRepo(t, data.ECDSAKey, "docker.com/notary", ts.URL, false)
+	defer os.RemoveAll(src.baseDir)
+
+	aKey := createKey(t, src, "targets/a", false)
+	bKey := createKey(t, src, "targets/a/b", false)
+	assert.NoError(t, src.AddDelegation("targets/a", []data.PublicKey{aKey}, []string{"a/"}))
+	assert.NoError(t, src.AddDelegation("targets/a/b", []data.PublicKey{bKey}, []string{"a/b/"}))
+	assert.NoError(t, src.Publish())
+
+	before, err := src.GetDelegationRoles()
+	assert.NoError(t, err)
+	assert.Len(t, before, 2)
+	sort.Sort(roleSorter(before))
+
+	_, err = src.Clone(src, false)
+	assert.Error(t, err)
+
+	// the same keys are staged, which only their owners can sign with
+	reused, _ := initializeRepo(t, data.ECDSAKey, "docker.com/reused", ts.URL, false)
+	defer os.RemoveAll(reused.baseDir)
+	names, err := src.Clone(reused, false)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"targets/a", "targets/a/b"}, names)
+
+	changes := getChanges(t, reused)
+	assert.Len(t, changes, 2)
+	for i, c := range changes {
+		assert.Equal(t, before[i].Name, c.Scope())
+		assert.Equal(t, changelist.ActionCreate, c.Action())
+		td := changelist.TufDelegation{}
+		assert.NoError(t, json.Unmarshal(c.Content(), &td))
+		assert.Equal(t, before[i].KeyIDs, td.AddKeys.IDs())
+		assert.Equal(t, before[i].Threshold, td.NewThreshold)
+		assert.Equal(t, before[i].Paths, td.AddPaths)
+	}
+
+	// new keys are generated locally, so the clone can be published
+	regenerated, _ := initializeRepo(t, data.ECDSAKey, "docker.com/regenerated", ts.URL, false)
+	defer os.RemoveAll(regenerated.baseDir)
+	names, err = src.Clone(regenerated, true)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"targets/a", "targets/a/b"}, names)
+	assert.NoError(t, regenerated.Publish())
+
+	after, err := regenerated.GetDelegationRoles()
+	assert.NoError(t, err)
+	assert.Len(t, after, 2)
+	sort.Sort(roleSorter(after))
+	for i, role := range after {
+		assert.Equal(t, before[i].Name, role.Name)
+		assert.Equal(t, before[i].Threshold, role.Threshold)
+		assert.Equal(t, before[i].Paths, role.Paths)
+		assert.Len(t, role.KeyIDs, 1)
+		assert.NotEqual(t, before[i].KeyIDs, role.KeyIDs)
+		assert.Len(t, regenerated.CryptoService.ListKeys(role.Name), 1)
+	}
+}
+
+// Adding a delegation with its parents creates the missing parents with the
+// parent keys and the paths of the new delegation, and leaves the existing
+// ones (published or not) alone
+// With allowed algorithms set, delegation keys of any other key type can't be
+// added, and a repository that already has such a delegation fails to update
+func TestAllowedAlgorithms(t *testing.T) {
+	ts := fullTestServer(t)
+	defer ts.Close()
+
+	repo, _ := initializeRepo(t, data.ECDSAKey, "docker.com/notary", ts.URL, false)
+	defer os.RemoveAll(repo.baseDir)
+
+	ecdsaKey := createKey(t, repo, "targets/a", true)
+	rsaPrivKey, err := trustmanager.GenerateRSAKey(rand.Reader, 1024)
+	assert.NoError(t, err)
+	rsaKey := data.PublicKeyFromPrivate(rsaPrivKey)
+
+	// no policy allows any key type
+	assert.NoError(t, repo.AddDelegation("targets/rsa", []data.PublicKey{rsaKey}, []string{""}))
+	assert.NoError(t, repo.Publish())
+
+	repo.AllowedAlgorithms = []string{"ecdsa p-256"}
+	_, err = repo.Update(false)
+	assert.Error(t, err)
+	assert.IsType(t, ErrAlgorithmNotAllowed{}, err)
+	assert.Contains(t, err.Error(), "RSA 1024")
+
+	repo.AllowedAlgorithms = []string{"ECDSA P-256", "RSA 1024"}
+	_, err = repo.Update(false)
+	assert.NoError(t, err)
+
+	repo.AllowedAlgorithms = []string{"ECDSA P-256"}
+	err = repo.AddDelegation("targets/b", []data.PublicKey{rsaKey}, []string{""})
+	assert.IsType(t, ErrAlgorithmNotAllowed{}, err)
+	numChanges := len(getChanges(t, repo))
+	_, err = repo.AddDelegationWithParents("targets/c/d",
+		[]data.PublicKey{ecdsaKey}, []data.PublicKey{rsaKey}, []string{""})
+	assert.IsType(t, ErrAlgorithmNotAllowed{}, err)
+	assert.Len(t, getChanges(t, repo), numChanges, "changes were staged for a disallowed key")
+	assert.NoError(t, repo.AddDelegationRoleAndKeys("targets/a", []data.PublicKey{ecdsaKey}))
+}
+
+// With revoked keys set, they can't be added to a delegation, by either of
+// their IDs, and a repository that has a delegation with one fails to update
+func TestRevokedKeys(t *testing.T) {
+	ts := fullTestServer(t)
+	defer ts.Close()
+
+	repo, _ := initializeRepo(t, data.ECDSAKey, "docker.com/notary", ts.URL, false)
+	defer os.RemoveAll(repo.baseDir)
+
+	certKey := createKey(t, repo, "targets/a", true)
+	canonicalID, err := utils.CanonicalKeyID(certKey)
+	assert.NoError(t, err)
+	assert.NotEqual(t, certKey.ID(), canonicalID)
+	otherKey := createKey(t, repo, "targets/b", true)
+
+	assert.NoError(t, repo.AddDelegation("targets/a", []data.PublicKey{certKey}, []string{""}))
+	assert.NoError(t, repo.Publish())
+
+	for _, keyID := range []string{certKey.ID(), canonicalID} {
+		repo.RevokedKeys = []string{keyID}
+		_, err = repo.Update(false)
+		assert.Error(t, err)
+		assert.IsType(t, ErrKeyRevoked{}, err)
+		assert.Contains(t, err.Error(), "targets/a")
+
+		err = repo.AddDelegation("targets/b", []data.PublicKey{otherKey, certKey}, []string{""})
+		assert.IsType(t, ErrKeyRevoked{}, err)
+		assert.Len(t, getChanges(t, repo), 0, "changes were staged for a revoked key")
+	}
+
+	repo.RevokedKeys = []string{otherKey.ID()}
+	_, err = repo.Update(false)
+	assert.NoError(t, err)
+	assert.NoError(t, repo.AddDelegationRoleAndKeys("targets/a", []data.PublicKey{certKey}))
+}
+
+func TestAddDelegationWithParents(t *testing.T) {
+	ts := fullTestServer(t)
+	defer ts.Close()
+
+	repo, _ := initializeRepo(t, data.ECDSAKey, "docker.com/notary", ts.URL, false)
+	defer os.RemoveAll(repo.baseDir)
+
+	assert.NoError(t, repo.Publish())
+
+	parentKey := createKey(t, repo, "targets/a", false)
+	childKey := createKey(t, repo, "targets/a/b/c", false)
+
+	// the parent keys are needed when there are parents to create
+	_, err := repo.AddDelegationWithParents("targets/a/b/c", []data.PublicKey{childKey}, nil, []string{"c/"})
+	assert.IsType(t, data.ErrInvalidRole{}, err)
+	assert.Len(t, getChanges(t, repo), 0)
+
+	parents, err := repo.AddDelegationWithParents("targets/a/b/c",
+		[]data.PublicKey{childKey}, []data.PublicKey{parentKey}, []string{"c/"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"targets/a", "targets/a/b"}, parents)
+
+	// the parents are already staged
+	parents, err = repo.AddDelegationWithParents("targets/a/b/d",
+		[]data.PublicKey{childKey}, []data.PublicKey{parentKey}, []string{"c/"})
+	assert.NoError(t, err)
+	assert.Len(t, parents, 0)
+	assert.NoError(t, repo.Publish())
+
+	parentKeyID, err := utils.CanonicalKeyID(parentKey)
+	assert.NoError(t, err)
+	childKeyID, err := utils.CanonicalKeyID(childKey)
+	assert.NoError(t, err)
+
+	roles, err := repo.GetDelegationRoles()
+	assert.NoError(t, err)
+	byName := make(map[string]*data.Role)
+	for _, role := range roles {
+		byName[role.Name] = role
+	}
+	assert.Len(t, byName, 4)
+	for name, keyID := range map[string]string{
+		"targets/a":     parentKeyID,
+		"targets/a/b":   parentKeyID,
+		"targets/a/b/c": childKeyID,
+		"targets/a/b/d": childKeyID,
+	} {
+		role, ok := byName[name]
+		assert.True(t, ok, "missing delegation %s", name)
+		if ok {
+			assert.Equal(t, []string{keyID}, role.KeyIDs, "wrong keys for %s", name)
+			// the created parents only group the delegations, so have no paths
+			if keyID == parentKeyID {
+				assert.Empty(t, role.Paths, "wrong paths for %s", name)
+			} else {
+				assert.Equal(t, []string{"c/"}, role.Paths, "wrong paths for %s", name)
+			}
+		}
+	}
+
+	// the parents are already published
+	parents, err = repo.AddDelegationWithParents("targets/a/b/e", []data.PublicKey{childKey}, nil, []string{"c/"})
+	assert.NoError(t, err)
+	assert.Len(t, parents, 0)
+	assert.Len(t, getChanges(t, repo), 2)
+}
+
+// If a changelist specifies a particular role to push targets to, and there
+// is no such role, publish will try to publish to its parent.  If the parent
+// doesn't work, it falls back on its parent, and so forth, and eventually
+// falls back on publishing to "target".  This *only* falls back if the role
+// doesn't exist, not if the user doesn't have a key.  (different test)
+func TestPublishTargetsDelgationScopeFallback(t *testing.T) {
+	testPublishTargetsDelgationScopeFallback(t, true)
+	testPublishTargetsDelgationScopeFallback(t, false)
+}
+
+func testPublishTargetsDelgationScopeFallback(t *testing.T, clearCache bool) {
+	ts := fullTestServer(t)
+	defer ts.Close()
+
+	repo, _ := initializeRepo(t, data.ECDSAKey, "docker.com/notary", ts.URL, false)
+	defer os.RemoveAll(repo.baseDir)
+
+	var rec *passRoleRecorder
+	if clearCache {
+		repo, rec = newRepoToTestRepo(t, repo, false)
+	}
+
+	assertPublishToRolesSucceeds(t, repo, []string{"targets/a/b", "targets/b/c"},
+		[]string{data.CanonicalTargetsRole})
+
+	if clearCache {
+		rec.assertAsked(t, []string{data.CanonicalTargetsRole, data.CanonicalSnapshotRole})
+		rec.assertCreated(t, nil)
+	}
+}
+
+// If a changelist specifies a particular role to push targets to, and there
+// is a role but no key, publish not fall back and just fail.
+func TestPublishTargetsDelgationScopeNoFallbackIfNoKeys(t *testing.T) {
+	testPublishTargetsDelgationScopeNoFallbackIfNoKeys(t, true)
+	testPublishTargetsDelgationScopeNoFallbackIfNoKeys(t, false)
+}
+
+func testPublishTargetsDelgationScopeNoFallbackIfNoKeys(t *testing.T, clearCache bool) {
+	ts := fullTestServer(t)
+	defer ts.Close()
+
+	repo, _ := initializeRepo(t, data.ECDSAKey, "docker.com/notary", ts.URL, false)
+	defer os.RemoveAll(repo.baseDir)
+
+	// generate a key that isn't in the cryptoservice, so we can't sign this
+	// one
+	aPrivKey, err := trustmanager.GenerateECDSAKey(rand.Reader)
+	assert.NoError(t, err, "error generating key that is not in our cryptoservice")
+	aPubKey := data.PublicKeyFromPrivate(aPrivKey)
+
+	var rec *passRoleRecorder
+	if clearCache {
+		repo, rec = newRepoToTestRepo(t, repo, false)
+	}
+
+	// ensure that the role exists
+	assert.NoError(t, repo.AddDelegation("targets/a", []data.PublicKey{aPubKey}, []string{""}))
+	assert.NoError(t, repo.Publish())
+
+	if clearCache {
+		rec.assertAsked(t, []string{data.CanonicalTargetsRole, data.CanonicalSnapshotRole})
+		rec.clear()
+	}
+
+	// add a target to targets/a/b - no role b, so it falls back on a, which
+	// exists but there is no signing key for
+	addTarget(t, repo, "latest", "../fixtures/intermediate-ca.crt", "targets/a/b")
+	assert.Len(t, getChanges(t, repo), 1, "wrong number of changelist files found")
+
+	// Now Publish should fail
+	assert.Error(t, repo.Publish())
+	assert.Len(t, getChanges(t, repo), 1, "wrong number of changelist files found")
+	if clearCache {
+		rec.assertAsked(t, nil)
+		rec.clear()
+	}
+
+	targets, err := repo.ListTargets("targets", "targets/a", "targets/a/b")
+	assert.NoError(t, err)
+	assert.Empty(t, targets)
+}
+
+// If a changelist specifies a particular role to push targets to, and such
+// a role and the keys are present, publish will write to that role only, and
+// not its parents.  This tests the case where the local machine knows about
+// all the roles (in fact, the role creations will be applied before the
+// targets)
+func TestPublishTargetsDelgationSuccessLocallyHasRoles(t *testing.T) {
+	ts := fullTestServer(t)
+	defer ts.Close()
+
+	repo, _ := initializeRepo(t, data.ECDSAKey, "docker.com/notary", ts.URL, false)
+	defer os.RemoveAll(repo.baseDir)
+
+	for _, delgName := range []string{"targets/a", "targets/a/b"} {
+		delgKey := createKey(t, repo, delgName, false)
+		assert.NoError(t,
+			repo.AddDelegation(delgName, []data.PublicKey{delgKey}, []string{""}),
+			"error creating delegation")
+	}
+
+	// just always check signing now, we've already established we can publish
+	// delgations with and without the metadata and key cache
+	var rec *passRoleRecorder
+	repo, rec = newRepoToTestRepo(t, repo, false)
+
+	assertPublishToRolesSucceeds(t, repo, []string{"targets/a/b"},
+		[]string{"targets/a/b"})
+
+	// first time publishing, so everything gets signed
+	rec.assertAsked(t, []string{data.CanonicalTargetsRole, "targets/a", "targets/a/b",
+		data.CanonicalSnapshotRole})
+}
+
+// If a changelist specifies a particular role to push targets to, and the role
+// is present, publish will write to that role only.  The targets keys are not
+// needed.
+func TestPublishTargetsDelgationNoTargetsKeyNeeded(t *testing.T) {
+	ts := fullTestServer(t)
+	defer ts.Close()
+
+	repo, _ := initializeRepo(t, data.ECDSAKey, "docker.com/notary", ts.URL, false)
+	defer os.RemoveAll(repo.baseDir)
+
+	for _, delgName := range []string{"targets/a", "targets/a/b"} {
+		delgKey := createKey(t, repo, delgName, false)
+		assert.NoError(t,
+			repo.AddDelegation(delgName, []data.PublicKey{delgKey}, []string{""}),
+			"error creating delegation")
+	}
+
+	// just always check signing now, we've already established we can publish
+	// delgations with and without the metadata and key cache
+	var rec *passRoleRecorder
+	repo, rec = newRepoToTestRepo(t, repo, false)
+
+	assert.NoError(t, repo.Publish())
+	// first time publishing, so all delegation parents get signed
+	rec.assertAsked(t, []string{data.CanonicalTargetsRole, "targets/a", data.CanonicalSnapshotRole})
+	rec.clear()
+
+	// remove targets key - it is not even needed
+	targetsKeys := repo.CryptoService.ListKeys(data.CanonicalTargetsRole)
+	assert.Len(t, targetsKeys, 1)
+	assert.NoError(t, repo.CryptoService.RemoveKey(targetsKeys[0]))
+
+	assertPublishToRolesSucceeds(t, repo, []string{"targets/a/b"},
+		[]string{"targets/a/b"})
+
+	// only the target delegation gets signed - snapshot key has already been cached
+	rec.assertAsked(t, []string{"targets/a/b"})
+}
+
+// If a changelist specifies a particular role to push targets to, and is such
+// a role and the keys are present, publish will write to that role only, and
+// not its parents.  Tests:
+// - case where the local doesn't know about all the roles, and has to download
+//   them before publish.
+// - owner of a repo may not have the delegated keys, so can't sign a delegated
+//   role
+func TestPublishTargetsDelgationSuccessNeedsToDownloadRoles(t *testing.T) {
+	gun := "docker.com/notary"
+	ts := fullTestServer(t)
+	defer ts.Close()
+
+	// this is the original repo - it owns the root/targets keys and creates
+	// the delegation to which it doesn't have the key (so server snapshot
+	// signing would be required)
+	ownerRepo, _ := initializeRepo(t, data.ECDSAKey, gun, ts.URL, true)
+	defer os.RemoveAll(ownerRepo.baseDir)
+
+	// this is a user, or otherwise a repo that only has access to the delegation
 	// key so it can publish targets to the delegated role
 	delgRepo, _ := newRepoToTestRepo(t, ownerRepo, true)
 	defer os.RemoveAll(delgRepo.baseDir)
@@ -2567,6 +4162,176 @@ func testRotateKeySuccess(t *testing.T, serverManagesSnapshotInit bool,
 	}
 }
 
+// Rotating the root key while keeping the old root key lets clients that have
+// only seen the old root key validate the new root.  Once the old key is
+// dropped, only clients that updated during the overlap can validate the root.
+func TestRotateRootKeyWithOverlap(t *testing.T) {
+	ts := fullTestServer(t)
+	defer ts.Close()
+
+	repo, _ := initializeRepo(t, data.ECDSAKey, "docker.com/notary", ts.URL, false)
+	defer os.RemoveAll(repo.baseDir)
+	addTarget(t, repo, "latest", "../fixtures/intermediate-ca.crt")
+	assert.NoError(t, repo.Publish())
+
+	// two other clients that have pinned only the original root key
+	updatedClient, _ := newRepoToTestRepo(t, repo, true)
+	defer os.RemoveAll(updatedClient.baseDir)
+	staleClient, _ := newRepoToTestRepo(t, repo, true)
+	defer os.RemoveAll(staleClient.baseDir)
+	for _, client := range []*NotaryRepository{updatedClient, staleClient} {
+		_, err := client.GetTargetByName("latest")
+		assert.NoError(t, err)
+	}
+
+	newRootKey, err := repo.CryptoService.Create(data.CanonicalRootRole, data.ECDSAKey)
+	assert.NoError(t, err)
+
+	assert.Error(t, repo.RotateRootKey("nonexistent", true))
+
+	// rotate with an overlap - the root has both keys
+	assert.NoError(t, repo.RotateRootKey(newRootKey.ID(), true))
+	assert.NoError(t, repo.Publish())
+	rootRole, err := repo.tufRepo.GetBaseRole(data.CanonicalRootRole)
+	assert.NoError(t, err)
+	assert.Len(t, rootRole.Keys, 2)
+
+	_, err = updatedClient.GetTargetByName("latest")
+	assert.NoError(t, err, "client with the old root key could not validate the new root")
+
+	// finish the rotation by dropping the old key
+	assert.NoError(t, repo.RotateRootKey(newRootKey.ID(), false))
+	assert.NoError(t, repo.Publish())
+	rootRole, err = repo.tufRepo.GetBaseRole(data.CanonicalRootRole)
+	assert.NoError(t, err)
+	assert.Len(t, rootRole.Keys, 1)
+	for _, pubKey := range rootRole.Keys {
+		canonicalID, err := utils.CanonicalKeyID(pubKey)
+		assert.NoError(t, err)
+		assert.Equal(t, newRootKey.ID(), canonicalID)
+	}
+
+	_, err = updatedClient.GetTargetByName("latest")
+	assert.NoError(t, err, "client that updated during the overlap could not validate the new root")
+
+	_, err = staleClient.GetTargetByName("latest")
+	assert.Error(t, err, "client that did not update during the overlap validated the new root")
+}
+
+// Rotating the root key with an overlap keeps the old root key until the
+// overlap ends, and the first publish after that removes it.
+func TestRotateRootKeyOverlapEnds(t *testing.T) {
+	ts := fullTestServer(t)
+	defer ts.Close()
+
+	repo, _ := initializeRepo(t, data.ECDSAKey, "docker.com/notary", ts.URL, false)
+	defer os.RemoveAll(repo.baseDir)
+	assert.NoError(t, repo.Publish())
+
+	newRootKey, err := repo.CryptoService.Create(data.CanonicalRootRole, data.ECDSAKey)
+	assert.NoError(t, err)
+
+	_, pending, err := repo.RootKeyOverlapEnd()
+	assert.NoError(t, err)
+	assert.False(t, pending)
+
+	// the old key is kept by every publish during the overlap
+	until := time.Now().Add(time.Hour)
+	assert.NoError(t, repo.RotateRootKeyWithOverlap(newRootKey.ID(), until))
+	end, pending, err := repo.RootKeyOverlapEnd()
+	assert.NoError(t, err)
+	assert.True(t, pending)
+	assert.True(t, until.Equal(end))
+	for i := 0; i < 2; i++ {
+		assert.NoError(t, repo.Publish())
+		rootRole, err := repo.tufRepo.GetBaseRole(data.CanonicalRootRole)
+		assert.NoError(t, err)
+		assert.Len(t, rootRole.Keys, 2)
+	}
+
+	// once the overlap has ended, the next publish drops the old key
+	assert.NoError(t, repo.RotateRootKeyWithOverlap(newRootKey.ID(), time.Now().Add(-time.Second)))
+	assert.NoError(t, repo.Publish())
+	rootRole, err := repo.tufRepo.GetBaseRole(data.CanonicalRootRole)
+	assert.NoError(t, err)
+	assert.Len(t, rootRole.Keys, 1)
+	for _, pubKey := range rootRole.Keys {
+		canonicalID, err := utils.CanonicalKeyID(pubKey)
+		assert.NoError(t, err)
+		assert.Equal(t, newRootKey.ID(), canonicalID)
+	}
+	_, pending, err = repo.RootKeyOverlapEnd()
+	assert.NoError(t, err)
+	assert.False(t, pending)
+
+	// a rotation without an overlap cancels a pending one
+	assert.NoError(t, repo.RotateRootKeyWithOverlap(newRootKey.ID(), until))
+	assert.NoError(t, repo.RotateRootKey(newRootKey.ID(), false))
+	_, pending, err = repo.RootKeyOverlapEnd()
+	assert.NoError(t, err)
+	assert.False(t, pending)
+}
+
+// server that behaves like the full test server, except that it rejects
+// every publish with the given validation error while reject is set
+func rejectingTestServer(t *testing.T, rejection error, reject *bool) *httptest.Server {
+	serialized, err := validation.NewSerializableError(rejection)
+	assert.NoError(t, err)
+	detail, err := regJson.Marshal(serialized)
+	assert.NoError(t, err)
+
+	handler := fullTestHandler(t)
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if *reject && r.Method == "POST" {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, `{"errors": [{"code": "INVALID_UPDATE", "detail": %s}]}`, detail)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	}))
+}
+
+// If the server rejects a publish, the error names the rejected role, the
+// changelist is kept, and the repo is left as it was before publishing
+// rather than with the changes partially applied.
+func TestPublishRejectedRestoresRepo(t *testing.T) {
+	reject := false
+	ts := rejectingTestServer(t,
+		validation.ErrBadTargets{Msg: "bad signature", Role: "targets/a"}, &reject)
+	defer ts.Close()
+
+	repo, _ := initializeRepo(t, data.ECDSAKey, "docker.com/notary", ts.URL, false)
+	defer os.RemoveAll(repo.baseDir)
+
+	delgKey := createKey(t, repo, "targets/a", false)
+	assert.NoError(t, repo.AddDelegation("targets/a", []data.PublicKey{delgKey}, []string{""}))
+	addTarget(t, repo, "latest", "../fixtures/intermediate-ca.crt", "targets/a")
+	assert.NoError(t, repo.Publish())
+	version := repo.tufRepo.Targets["targets/a"].Signed.Version
+
+	addTarget(t, repo, "current", "../fixtures/intermediate-ca.crt", "targets/a")
+	assert.NoError(t, repo.AddDelegation("targets/b", []data.PublicKey{delgKey}, []string{""}))
+
+	reject = true
+	err := repo.Publish()
+	assert.Error(t, err)
+	assert.IsType(t, validation.ErrBadTargets{}, err)
+	assert.Equal(t, "targets/a", err.(validation.ErrBadTargets).Role)
+	assert.Len(t, getChanges(t, repo), 3, "changes should not have been cleared")
+
+	a := repo.tufRepo.Targets["targets/a"]
+	assert.Equal(t, version, a.Signed.Version)
+	assert.Len(t, a.Signed.Targets, 1)
+	assert.False(t, a.Dirty)
+	_, ok := repo.tufRepo.Targets["targets/b"]
+	assert.False(t, ok, "targets/b should not have been added")
+
+	reject = false
+	assert.NoError(t, repo.Publish())
+	assert.Len(t, getChanges(t, repo), 0)
+	assert.Len(t, repo.tufRepo.Targets["targets/a"].Signed.Targets, 2)
+}
+
 // If there is no local cache, notary operations return the remote error code
 func TestRemoteServerUnavailableNoLocalCache(t *testing.T) {
 	tempBaseDir, err := ioutil.TempDir("/tmp", "notary-test-")
@@ -2781,6 +4546,95 @@ func TestClearAllPathsDelegationChangefileApplicable(t *testing.T) {
 	assert.Len(t, delgRoles[0].Paths, 0)
 }
 
+// Exact paths are added as path hash prefixes, which let the role sign only
+// those paths, alongside its prefix paths, and are cleared along with them
+func TestAddDelegationExactPathsChangefileApplicable(t *testing.T) {
+	gun := "docker.com/notary"
+	ts, _, _ := simpleTestServer(t)
+	defer ts.Close()
+
+	repo, rootKeyID := initializeRepo(t, data.ECDSAKey, gun, ts.URL, false)
+	defer os.RemoveAll(repo.baseDir)
+	rootPubKey := repo.CryptoService.GetKey(rootKeyID)
+	assert.NotNil(t, rootPubKey)
+
+	assert.NoError(t, repo.AddDelegation("targets/a", []data.PublicKey{rootPubKey}, nil))
+	assert.NoError(t, repo.AddDelegationExactPaths("targets/a", []string{"releases/v1"}))
+	assert.Error(t, repo.AddDelegationExactPaths("invalid", []string{"releases/v1"}))
+	changes := getChanges(t, repo)
+	for _, c := range changes {
+		assert.NoError(t, applyTargetsChange(repo.tufRepo, c))
+	}
+
+	delgRoles := repo.tufRepo.Targets[data.CanonicalTargetsRole].Signed.Delegations.Roles
+	assert.Len(t, delgRoles, 1)
+	assert.Empty(t, delgRoles[0].Paths)
+	assert.Equal(t, []string{data.PathHex("releases/v1")}, delgRoles[0].PathHashPrefixes)
+
+	delgRole, err := repo.tufRepo.GetDelegationRole("targets/a")
+	assert.NoError(t, err)
+	assert.True(t, delgRole.CheckPaths("releases/v1"))
+	assert.False(t, delgRole.CheckPaths("releases/v1.1"))
+
+	// TUF only allows a role either paths or path hash prefixes
+	assert.NoError(t, repo.AddDelegationPaths("targets/a", []string{"docs/"}))
+	changes = getChanges(t, repo)
+	err = applyTargetsChange(repo.tufRepo, changes[len(changes)-1])
+	assert.IsType(t, data.ErrInvalidRole{}, err)
+	assert.Contains(t, err.Error(), "both paths and path hash prefixes")
+	delgRole, err = repo.tufRepo.GetDelegationRole("targets/a")
+	assert.NoError(t, err)
+	assert.Empty(t, delgRole.Paths)
+
+	meta := data.FileMeta{Length: 1, Hashes: data.Hashes{"sha256": []byte("abc")}}
+	_, err = repo.tufRepo.AddTargets("targets/a", data.Files{"releases/v1": meta})
+	assert.NoError(t, err)
+	invalid, err := repo.tufRepo.AddTargets("targets/a", data.Files{"releases/v1.1": meta})
+	assert.Error(t, err)
+	assert.Len(t, invalid, 1)
+
+	assert.NoError(t, repo.ClearDelegationPaths("targets/a"))
+	changes = getChanges(t, repo)
+	assert.NoError(t, applyTargetsChange(repo.tufRepo, changes[len(changes)-1]))
+	delgRoles = repo.tufRepo.Targets[data.CanonicalTargetsRole].Signed.Delegations.Roles
+	assert.Len(t, delgRoles[0].Paths, 0)
+	assert.Len(t, delgRoles[0].PathHashPrefixes, 0)
+}
+
+// Notes on paths are stored with the delegation, and only change which paths
+// it can sign by being removed along with them
+func TestAddDelegationPathNotesChangefileApplicable(t *testing.T) {
+	gun := "docker.com/notary"
+	ts, _, _ := simpleTestServer(t)
+	defer ts.Close()
+
+	repo, rootKeyID := initializeRepo(t, data.ECDSAKey, gun, ts.URL, false)
+	defer os.RemoveAll(repo.baseDir)
+	rootPubKey := repo.CryptoService.GetKey(rootKeyID)
+	assert.NotNil(t, rootPubKey)
+
+	assert.NoError(t, repo.AddDelegation("targets/a", []data.PublicKey{rootPubKey}, []string{"docs/", "releases/"}))
+	assert.NoError(t, repo.AddDelegationPathNotes("targets/a", map[string]string{"releases/": "release team"}))
+	assert.Error(t, repo.AddDelegationPathNotes("invalid", map[string]string{"releases/": "release team"}))
+	changes := getChanges(t, repo)
+	assert.Len(t, changes, 3)
+	for _, c := range changes {
+		assert.NoError(t, applyTargetsChange(repo.tufRepo, c))
+	}
+
+	delgRoles := repo.tufRepo.Targets[data.CanonicalTargetsRole].Signed.Delegations.Roles
+	assert.Len(t, delgRoles, 1)
+	assert.Equal(t, []string{"docs/", "releases/"}, delgRoles[0].Paths)
+	assert.Equal(t, map[string]string{"releases/": "release team"}, delgRoles[0].PathNotes)
+
+	assert.NoError(t, repo.RemoveDelegationPaths("targets/a", []string{"releases/"}))
+	changes = getChanges(t, repo)
+	assert.NoError(t, applyTargetsChange(repo.tufRepo, changes[len(changes)-1]))
+	delgRoles = repo.tufRepo.Targets[data.CanonicalTargetsRole].Signed.Delegations.Roles
+	assert.Equal(t, []string{"docs/"}, delgRoles[0].Paths)
+	assert.Empty(t, delgRoles[0].PathNotes)
+}
+
 // TestFullAddDelegationChangefileApplicable generates a single changelist with AddKeys and AddPaths set,
 // (in the old style of AddDelegation) and tests that all of its changes are reflected on publish
 func TestFullAddDelegationChangefileApplicable(t *testing.T) {
@@ -3111,6 +4965,79 @@ func TestDeleteRepoNoCerts(t *testing.T) {
 	assertRepoHasExpectedKeys(t, repo, rootKeyID, true)
 }
 
+// Orphaned delegation keys are the local delegation keys that are used neither
+// by a published delegation nor by an unpublished delegation change
+func TestListOrphanedDelegationKeys(t *testing.T) {
+	ts := fullTestServer(t)
+	defer ts.Close()
+
+	repo, _ := initializeRepo(t, data.ECDSAKey, "docker.com/notary", ts.URL, false)
+	defer os.RemoveAll(repo.baseDir)
+
+	certKey := createKey(t, repo, "targets/a", true)
+	rotatedKey := createKey(t, repo, "targets/a", false)
+	newKey := createKey(t, repo, "targets/a", false)
+	stagedKey := createKey(t, repo, "targets/b", false)
+	unusedKey := createKey(t, repo, "targets/c", false)
+
+	assert.NoError(t, repo.AddDelegation("targets/a", []data.PublicKey{certKey, rotatedKey}, []string{""}))
+	assert.NoError(t, repo.Publish())
+	assert.NoError(t, repo.RotateDelegationKey("targets/a", rotatedKey.ID(), newKey))
+	assert.NoError(t, repo.Publish())
+	assert.NoError(t, repo.AddDelegation("targets/b", []data.PublicKey{stagedKey}, []string{""}))
+
+	expected := []string{rotatedKey.ID(), unusedKey.ID()}
+	sort.Strings(expected)
+	orphaned, err := repo.ListOrphanedDelegationKeys()
+	assert.NoError(t, err)
+	assert.Equal(t, expected, orphaned)
+}
+
+// The keys of a repo are its non-root keys, which can be removed without
+// removing the root key
+func TestDeleteRepoKeys(t *testing.T) {
+	ts, _, _ := simpleTestServer(t)
+	defer ts.Close()
+
+	repo, rootKeyID := initializeRepo(t, data.ECDSAKey, "docker.com/notary", ts.URL, false)
+	defer os.RemoveAll(repo.baseDir)
+
+	// the CryptoService lists non-root keys by path
+	var expected []string
+	for _, role := range []string{data.CanonicalTargetsRole, data.CanonicalSnapshotRole} {
+		for _, keyPath := range repo.CryptoService.ListKeys(role) {
+			expected = append(expected, strings.TrimPrefix(keyPath, repo.gun+"/"))
+		}
+	}
+	sort.Strings(expected)
+	assert.Len(t, expected, 2)
+	assert.Equal(t, expected, repo.ListRepoKeys())
+
+	assert.NoError(t, repo.DeleteRepoKeys())
+	assert.Empty(t, repo.ListRepoKeys())
+	assert.Empty(t, repo.CryptoService.ListKeys(data.CanonicalTargetsRole))
+	assert.NotNil(t, repo.CryptoService.GetKey(rootKeyID))
+}
+
+// After the remote trust data of a published repo is deleted, the repo no
+// longer exists on the server
+func TestDeleteRemoteTrustData(t *testing.T) {
+	ts := fullTestServer(t)
+	defer ts.Close()
+
+	repo, _ := initializeRepo(t, data.ECDSAKey, "docker.com/notary", ts.URL, false)
+	defer os.RemoveAll(repo.baseDir)
+	assert.NoError(t, repo.Publish())
+
+	assert.NoError(t, repo.DeleteRemoteTrustData())
+
+	newRepo, _ := newRepoToTestRepo(t, repo, true)
+	defer os.RemoveAll(newRepo.baseDir)
+	_, err := newRepo.ListTargets()
+	assert.Error(t, err)
+	assert.IsType(t, ErrRepositoryNotExist{}, err)
+}
+
 // Test that we get a correct list of roles with keys and signatures
 func TestListRoles(t *testing.T) {
 	ts := fullTestServer(t)
@@ -3209,3 +5136,35 @@ func TestListRoles(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Len(t, rolesWithSigs, len(data.BaseRoles)+2)
 }
+
+// ListRoleKeys returns the public keys of the base roles and the delegations,
+// by the key IDs that the roles list them under
+func TestListRoleKeys(t *testing.T) {
+	ts := fullTestServer(t)
+	defer ts.Close()
+
+	repo, _ := initializeRepo(t, data.ECDSAKey, "docker.com/notary", ts.URL, false)
+	defer os.RemoveAll(repo.baseDir)
+
+	aKey := createKey(t, repo, "user", true)
+	assert.NoError(t, repo.AddDelegation("targets/a", []data.PublicKey{aKey}, []string{""}))
+	assert.NoError(t, repo.Publish())
+
+	roleKeys, err := repo.ListRoleKeys()
+	assert.NoError(t, err)
+	assert.Len(t, roleKeys, len(data.BaseRoles)+1)
+	for _, role := range data.BaseRoles {
+		baseRole, err := repo.tufRepo.GetBaseRole(role)
+		assert.NoError(t, err)
+		assert.Equal(t, len(baseRole.Keys), len(roleKeys[role]))
+		for keyID := range baseRole.Keys {
+			_, ok := roleKeys[role][keyID]
+			assert.True(t, ok, "missing key %s of %s", keyID, role)
+		}
+	}
+	assert.Equal(t, 1, len(roleKeys["targets/a"]))
+	for keyID, pubKey := range roleKeys["targets/a"] {
+		assert.Equal(t, aKey.ID(), keyID)
+		assert.Equal(t, aKey.Public(), pubKey.Public())
+	}
+}
@@ -987,6 +987,13 @@ func testUpdateRemoteCorruptValidChecksum(t *testing.T, opts updateOpts, expt sw
 	if shouldErr {
 		require.Error(t, err, "expected failure updating when %s", msg)
 
+		// failures to verify the targets and delegations come with the chain
+		// of roles down to the failing role
+		if verr, ok := err.(client.ErrVerification); ok {
+			require.Equal(t, opts.role, verr.Role(), "wrong failing role when %s", msg)
+			err = verr.Err
+		}
+
 		errType := reflect.TypeOf(err)
 		isExpectedType := false
 		var expectedTypes []string
@@ -1075,3 +1082,36 @@ func testUpdateLocalAndRemoteRootCorrupt(t *testing.T, forWrite bool, localExpt,
 	require.True(t, isExpectedType, "expected one of %v when %s: got %s",
 		expectedTypes, msg, errType)
 }
+
+// With NoCache, update downloads all the metadata again, and so fails if the
+// server is unavailable even though the cached metadata is still valid
+func TestUpdateNoCache(t *testing.T) {
+	serverMeta, _, err := testutils.NewRepoMetadata("docker.com/notary", metadataDelegations...)
+	require.NoError(t, err)
+
+	ts := readOnlyServer(t, store.NewMemoryStore(serverMeta), http.StatusNotFound, "docker.com/notary")
+	repo := newBlankRepo(t, ts.URL)
+	defer os.RemoveAll(repo.baseDir)
+
+	_, err = repo.Update(false)
+	require.NoError(t, err)
+	repo.NoCache = true
+	_, err = repo.Update(false)
+	require.NoError(t, err)
+	for _, role := range delegationsWithNonEmptyMetadata {
+		_, ok := repo.tufRepo.Targets[role]
+		require.True(t, ok, "%s should have been downloaded", role)
+	}
+	ts.Close()
+
+	unavailable := readOnlyServer(t, store.NewMemoryStore(nil), http.StatusServiceUnavailable, "docker.com/notary")
+	defer unavailable.Close()
+	repo.baseURL = unavailable.URL
+	_, err = repo.Update(false)
+	require.Error(t, err)
+	require.IsType(t, store.ErrServerUnavailable{}, err)
+
+	repo.NoCache = false
+	_, err = repo.Update(false)
+	require.NoError(t, err)
+}
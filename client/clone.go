@@ -0,0 +1,120 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sort"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/docker/notary/client/changelist"
+	"github.com/docker/notary/tuf/data"
+)
+
+// Clone creates changelist entries on dst that add delegation roles with the
+// names, thresholds, paths and expiry of all of this repository's delegation
+// roles, so that a new repository can be given the same trust setup.  The roles
+// are staged shallowest first, so that nested delegations are created after
+// their parents.  If regenerateKeys is false the roles get the same public keys
+// as in this repository.  Otherwise every key is replaced by a new key,
+// generated in the key stores of dst, with a self-signed certificate like a
+// root key.  It returns the names of the roles that were staged.
+func (r *NotaryRepository) Clone(dst *NotaryRepository, regenerateKeys bool) ([]string, error) {
+	if dst == r || dst.gun == r.gun {
+		return nil, fmt.Errorf("cannot clone %s onto itself", r.gun)
+	}
+	details, err := r.ListDelegationDetails()
+	if err != nil {
+		return nil, err
+	}
+	byName := make(map[string]DelegationDetail, len(details))
+	names := make([]string, 0, len(details))
+	for _, detail := range details {
+		byName[detail.Name] = detail
+		names = append(names, detail.Name)
+	}
+	sort.Sort(byDepth(names))
+
+	dst.lock.Lock()
+	defer dst.lock.Unlock()
+
+	// generated keys are of no use if nothing is staged, so remove them again
+	// on failure
+	var generated []string
+	staged := false
+	defer func() {
+		if staged {
+			return
+		}
+		for _, keyID := range generated {
+			dst.CryptoService.RemoveKey(keyID)
+		}
+	}()
+
+	// make every change before staging anything, so that a disallowed or
+	// missing key does not leave only some of the roles staged
+	changes := make([]changelist.Change, 0, len(names))
+	for _, name := range names {
+		detail := byName[name]
+		keys := make(data.KeyList, 0, len(detail.Keys))
+		for _, key := range detail.Keys {
+			if key.PublicKey == nil {
+				return nil, data.ErrInvalidRole{
+					Role:   name,
+					Reason: fmt.Sprintf("key %s of the delegation role could not be found", key.ID),
+				}
+			}
+			if !regenerateKeys {
+				keys = append(keys, key.PublicKey)
+				continue
+			}
+			pubKey, err := dst.CryptoService.Create(name, data.ECDSAKey)
+			if err != nil {
+				return nil, err
+			}
+			generated = append(generated, pubKey.ID())
+			privKey, _, err := dst.CryptoService.GetPrivateKey(pubKey.ID())
+			if err != nil {
+				return nil, err
+			}
+			_, certKey, err := rootCertKey(dst.gun, privKey)
+			if err != nil {
+				return nil, err
+			}
+			keys = append(keys, certKey)
+		}
+		if err := dst.checkDelegationKeys(name, keys...); err != nil {
+			return nil, err
+		}
+
+		tdJSON, err := json.Marshal(&changelist.TufDelegation{
+			NewThreshold:        detail.Threshold,
+			AddKeys:             keys,
+			AddPaths:            detail.Paths,
+			AddPathHashPrefixes: detail.PathHashPrefixes,
+			ValidUntil:          detail.ValidUntil,
+			AddPathNotes:        detail.PathNotes,
+		})
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, newCreateDelegationChange(name, tdJSON))
+	}
+
+	cl, err := changelist.NewFileChangelist(filepath.Join(dst.tufRepoPath, "changelist"))
+	if err != nil {
+		return nil, err
+	}
+	defer cl.Close()
+
+	logrus.Debugf(`Cloning %d delegations of "%s" onto "%s"\n`, len(changes), r.gun, dst.gun)
+
+	// once any change may have been staged, the keys it names have to be kept
+	staged = true
+	for _, c := range changes {
+		if err := cl.Add(c); err != nil {
+			return nil, err
+		}
+	}
+	return names, nil
+}
@@ -3,11 +3,16 @@ package client
 import (
 	"encoding/json"
 	"fmt"
+	"path"
 	"path/filepath"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/Sirupsen/logrus"
 	"github.com/docker/notary"
 	"github.com/docker/notary/client/changelist"
+	"github.com/docker/notary/trustmanager"
 	"github.com/docker/notary/tuf/data"
 	"github.com/docker/notary/tuf/store"
 	"github.com/docker/notary/tuf/utils"
@@ -16,14 +21,20 @@ import (
 // AddDelegation creates changelist entries to add provided delegation public keys and paths.
 // This method composes AddDelegationRoleAndKeys and AddDelegationPaths (each creates one changelist if called).
 func (r *NotaryRepository) AddDelegation(name string, delegationKeys []data.PublicKey, paths []string) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.addDelegation(name, delegationKeys, paths)
+}
+
+func (r *NotaryRepository) addDelegation(name string, delegationKeys []data.PublicKey, paths []string) error {
 	if len(delegationKeys) > 0 {
-		err := r.AddDelegationRoleAndKeys(name, delegationKeys)
+		err := r.addDelegationRoleAndKeys(name, delegationKeys)
 		if err != nil {
 			return err
 		}
 	}
 	if len(paths) > 0 {
-		err := r.AddDelegationPaths(name, paths)
+		err := r.addDelegationPaths(name, paths)
 		if err != nil {
 			return err
 		}
@@ -31,14 +42,106 @@ func (r *NotaryRepository) AddDelegation(name string, delegationKeys []data.Publ
 	return nil
 }
 
+// AddDelegationWithParents creates changelist entries to add a delegation like
+// AddDelegation, as well as any of its ancestor delegations that exist neither
+// in the repository nor in the unpublished changes.  The ancestors are created
+// with the parent keys and no paths, since they only group the delegations
+// below them and are not meant to sign any targets themselves.  It returns the
+// names of the ancestors that will be created, shallowest first.
+func (r *NotaryRepository) AddDelegationWithParents(name string, delegationKeys, parentKeys []data.PublicKey, paths []string) ([]string, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if !data.IsDelegation(name) {
+		return nil, data.ErrInvalidRole{Role: name, Reason: "invalid delegation role name"}
+	}
+
+	// Update state of the repo to latest
+	if _, err := r.update(false); err != nil {
+		return nil, err
+	}
+
+	cl, err := changelist.NewFileChangelist(filepath.Join(r.tufRepoPath, "changelist"))
+	if err != nil {
+		return nil, err
+	}
+	defer cl.Close()
+
+	staged := make(map[string]bool)
+	for _, c := range cl.List() {
+		if c.Type() == changelist.TypeTargetsDelegation && c.Action() == changelist.ActionCreate {
+			staged[c.Scope()] = true
+		}
+	}
+
+	// collect the ancestors below the targets role, shallowest first
+	var ancestors []string
+	for parent := path.Dir(name); parent != data.CanonicalTargetsRole; parent = path.Dir(parent) {
+		ancestors = append([]string{parent}, ancestors...)
+	}
+
+	var missing []string
+	for _, parent := range ancestors {
+		if staged[parent] {
+			continue
+		}
+		if len(missing) == 0 {
+			_, _, err := r.tufRepo.GetDelegation(parent)
+			if err == nil {
+				continue
+			}
+			switch err.(type) {
+			case data.ErrNoSuchRole, data.ErrInvalidRole:
+			default:
+				return nil, err
+			}
+		}
+		// the children of a missing delegation are necessarily missing too
+		missing = append(missing, parent)
+	}
+
+	if len(missing) > 0 && len(parentKeys) == 0 {
+		return nil, data.ErrInvalidRole{Role: missing[0], Reason: "no keys to create the missing parent delegation role with"}
+	}
+	// check every key before staging anything, so that a disallowed key does
+	// not leave only some of the delegations staged
+	if err := r.checkDelegationKeys(name, delegationKeys...); err != nil {
+		return nil, err
+	}
+	if len(missing) > 0 {
+		if err := r.checkDelegationKeys(missing[0], parentKeys...); err != nil {
+			return nil, err
+		}
+	}
+	for _, parent := range missing {
+		logrus.Debugf(`Adding parent delegation "%s" of "%s"\n`, parent, name)
+		if err := r.addDelegation(parent, parentKeys, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := r.addDelegation(name, delegationKeys, paths); err != nil {
+		return nil, err
+	}
+	return missing, nil
+}
+
 // AddDelegationRoleAndKeys creates a changelist entry to add provided delegation public keys.
 // This method is the simplest way to create a new delegation, because the delegation must have at least
 // one key upon creation to be valid since we will reject the changelist while validating the threshold.
 func (r *NotaryRepository) AddDelegationRoleAndKeys(name string, delegationKeys []data.PublicKey) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.addDelegationRoleAndKeys(name, delegationKeys)
+}
+
+func (r *NotaryRepository) addDelegationRoleAndKeys(name string, delegationKeys []data.PublicKey) error {
 
 	if !data.IsDelegation(name) {
 		return data.ErrInvalidRole{Role: name, Reason: "invalid delegation role name"}
 	}
+	if err := r.checkDelegationKeys(name, delegationKeys...); err != nil {
+		return err
+	}
 
 	cl, err := changelist.NewFileChangelist(filepath.Join(r.tufRepoPath, "changelist"))
 	if err != nil {
@@ -65,6 +168,12 @@ func (r *NotaryRepository) AddDelegationRoleAndKeys(name string, delegationKeys
 // AddDelegationPaths creates a changelist entry to add provided paths to an existing delegation.
 // This method cannot create a new delegation itself because the role must meet the key threshold upon creation.
 func (r *NotaryRepository) AddDelegationPaths(name string, paths []string) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.addDelegationPaths(name, paths)
+}
+
+func (r *NotaryRepository) addDelegationPaths(name string, paths []string) error {
 
 	if !data.IsDelegation(name) {
 		return data.ErrInvalidRole{Role: name, Reason: "invalid delegation role name"}
@@ -89,17 +198,233 @@ func (r *NotaryRepository) AddDelegationPaths(name string, paths []string) error
 	return addChange(cl, template, name)
 }
 
+// AddDelegationPathNotes creates a changelist entry to note why paths of a
+// delegation are delegated, keyed by path.  The notes are stored with the
+// delegation in the metadata of its parent for documentation only, and
+// replace the notes the paths already have.  The paths themselves are added
+// with AddDelegationPaths.
+func (r *NotaryRepository) AddDelegationPathNotes(name string, notes map[string]string) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if !data.IsDelegation(name) {
+		return data.ErrInvalidRole{Role: name, Reason: "invalid delegation role name"}
+	}
+
+	cl, err := changelist.NewFileChangelist(filepath.Join(r.tufRepoPath, "changelist"))
+	if err != nil {
+		return err
+	}
+	defer cl.Close()
+
+	logrus.Debugf(`Adding notes on %d paths to delegation %s\n`, len(notes), name)
+
+	tdJSON, err := json.Marshal(&changelist.TufDelegation{
+		AddPathNotes: notes,
+	})
+	if err != nil {
+		return err
+	}
+
+	template := newCreateDelegationChange(name, tdJSON)
+	return addChange(cl, template, name)
+}
+
+// AddDelegationExactPaths creates a changelist entry to let a delegation sign
+// the given target paths exactly, rather than every path that starts with
+// them.  TUF paths are always prefixes, so each path is added to the path hash
+// prefixes of the role as its whole SHA256 digest, which only that path
+// matches.
+func (r *NotaryRepository) AddDelegationExactPaths(name string, paths []string) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if !data.IsDelegation(name) {
+		return data.ErrInvalidRole{Role: name, Reason: "invalid delegation role name"}
+	}
+
+	cl, err := changelist.NewFileChangelist(filepath.Join(r.tufRepoPath, "changelist"))
+	if err != nil {
+		return err
+	}
+	defer cl.Close()
+
+	logrus.Debugf(`Adding %s exact paths to delegation %s\n`, paths, name)
+
+	prefixes := make([]string, 0, len(paths))
+	for _, p := range paths {
+		prefixes = append(prefixes, data.PathHex(p))
+	}
+	tdJSON, err := json.Marshal(&changelist.TufDelegation{
+		AddPathHashPrefixes: prefixes,
+	})
+	if err != nil {
+		return err
+	}
+
+	template := newCreateDelegationChange(name, tdJSON)
+	return addChange(cl, template, name)
+}
+
+// ExistingDelegationKeys returns the canonical IDs of those of the given keys
+// that the delegation role already has, either in the latest metadata that is
+// available or once the staged changes are applied, so that they need not be
+// added again.  A role that does not exist yet has no keys, and neither does
+// one in a repository that has not been published, or that can't be reached
+// and has no cached metadata.
+func (r *NotaryRepository) ExistingDelegationKeys(name string, keys []data.PublicKey) ([]string, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if !data.IsDelegation(name) {
+		return nil, data.ErrInvalidRole{Role: name, Reason: "invalid delegation role name"}
+	}
+
+	present := make(map[string]bool)
+	if _, err := r.update(false); err == nil {
+		role, _, err := r.canonicalDelegation(name)
+		if err == nil {
+			for _, keyID := range role.KeyIDs {
+				present[keyID] = true
+			}
+		} else if _, ok := err.(data.ErrNoSuchRole); !ok {
+			return nil, err
+		}
+	} else {
+		switch err.(type) {
+		case ErrRepositoryNotExist, store.ErrOffline, store.ErrMetaNotFound:
+		default:
+			return nil, err
+		}
+	}
+
+	cl, err := r.GetChangelist()
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range cl.List() {
+		if c.Type() != changelist.TypeTargetsDelegation || c.Scope() != name {
+			continue
+		}
+		if c.Action() == changelist.ActionDelete {
+			present = make(map[string]bool)
+			continue
+		}
+		td := changelist.TufDelegation{}
+		if err := json.Unmarshal(c.Content(), &td); err != nil {
+			return nil, err
+		}
+		if td.Replace {
+			present = make(map[string]bool)
+		}
+		// keys are removed by canonical ID
+		for _, keyID := range td.RemoveKeys {
+			delete(present, keyID)
+		}
+		for _, key := range td.AddKeys {
+			keyID, err := utils.CanonicalKeyID(key)
+			if err != nil {
+				return nil, err
+			}
+			present[keyID] = true
+		}
+	}
+
+	var existing []string
+	for _, key := range keys {
+		keyID, err := utils.CanonicalKeyID(key)
+		if err != nil {
+			return nil, err
+		}
+		if present[keyID] {
+			existing = append(existing, keyID)
+		}
+	}
+	return existing, nil
+}
+
+// ReplaceDelegation creates a single changelist entry that sets the keys and
+// paths of a delegation to exactly the provided ones, with a threshold of 1.  If
+// the delegation already exists, all of its keys and paths are replaced, unlike
+// AddDelegation which merges them into the existing ones.
+func (r *NotaryRepository) ReplaceDelegation(name string, delegationKeys []data.PublicKey, paths []string) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if !data.IsDelegation(name) {
+		return data.ErrInvalidRole{Role: name, Reason: "invalid delegation role name"}
+	}
+	if len(delegationKeys) < notary.MinThreshold {
+		return data.ErrInvalidRole{Role: name, Reason: "insufficient keys to meet threshold"}
+	}
+	if err := r.checkDelegationKeys(name, delegationKeys...); err != nil {
+		return err
+	}
+
+	cl, err := changelist.NewFileChangelist(filepath.Join(r.tufRepoPath, "changelist"))
+	if err != nil {
+		return err
+	}
+	defer cl.Close()
+
+	logrus.Debugf(`Replacing delegation "%s" with threshold %d, %d keys and paths %s\n`,
+		name, notary.MinThreshold, len(delegationKeys), paths)
+
+	tdJSON, err := json.Marshal(&changelist.TufDelegation{
+		NewThreshold: notary.MinThreshold,
+		AddKeys:      data.KeyList(delegationKeys),
+		AddPaths:     paths,
+		Replace:      true,
+	})
+	if err != nil {
+		return err
+	}
+
+	template := newCreateDelegationChange(name, tdJSON)
+	return addChange(cl, template, name)
+}
+
+// SetDelegationExpiry creates a changelist entry to make a delegation expire
+// at the given time, after which it is no longer trusted, along with any
+// delegations below it.  The delegation can be given a new expiry later on.
+func (r *NotaryRepository) SetDelegationExpiry(name string, validUntil time.Time) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if !data.IsDelegation(name) {
+		return data.ErrInvalidRole{Role: name, Reason: "invalid delegation role name"}
+	}
+
+	cl, err := changelist.NewFileChangelist(filepath.Join(r.tufRepoPath, "changelist"))
+	if err != nil {
+		return err
+	}
+	defer cl.Close()
+
+	logrus.Debugf(`Setting the expiry of delegation %s to %s\n`, name, validUntil)
+
+	validUntil = validUntil.UTC()
+	tdJSON, err := json.Marshal(&changelist.TufDelegation{
+		ValidUntil: &validUntil,
+	})
+	if err != nil {
+		return err
+	}
+
+	template := newCreateDelegationChange(name, tdJSON)
+	return addChange(cl, template, name)
+}
+
 // RemoveDelegationKeysAndPaths creates changelist entries to remove provided delegation key IDs and paths.
 // This method composes RemoveDelegationPaths and RemoveDelegationKeys (each creates one changelist if called).
 func (r *NotaryRepository) RemoveDelegationKeysAndPaths(name string, keyIDs, paths []string) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
 	if len(paths) > 0 {
-		err := r.RemoveDelegationPaths(name, paths)
+		err := r.removeDelegationPaths(name, paths)
 		if err != nil {
 			return err
 		}
 	}
 	if len(keyIDs) > 0 {
-		err := r.RemoveDelegationKeys(name, keyIDs)
+		err := r.removeDelegationKeys(name, keyIDs)
 		if err != nil {
 			return err
 		}
@@ -109,7 +434,8 @@ func (r *NotaryRepository) RemoveDelegationKeysAndPaths(name string, keyIDs, pat
 
 // RemoveDelegationRole creates a changelist to remove all paths and keys from a role, and delete the role in its entirety.
 func (r *NotaryRepository) RemoveDelegationRole(name string) error {
-
+	r.lock.Lock()
+	defer r.lock.Unlock()
 	if !data.IsDelegation(name) {
 		return data.ErrInvalidRole{Role: name, Reason: "invalid delegation role name"}
 	}
@@ -128,6 +454,12 @@ func (r *NotaryRepository) RemoveDelegationRole(name string) error {
 
 // RemoveDelegationPaths creates a changelist entry to remove provided paths from an existing delegation.
 func (r *NotaryRepository) RemoveDelegationPaths(name string, paths []string) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.removeDelegationPaths(name, paths)
+}
+
+func (r *NotaryRepository) removeDelegationPaths(name string, paths []string) error {
 
 	if !data.IsDelegation(name) {
 		return data.ErrInvalidRole{Role: name, Reason: "invalid delegation role name"}
@@ -156,6 +488,12 @@ func (r *NotaryRepository) RemoveDelegationPaths(name string, paths []string) er
 // When this changelist is applied, if the specified keys are the only keys left in the role,
 // the role itself will be deleted in its entirety.
 func (r *NotaryRepository) RemoveDelegationKeys(name string, keyIDs []string) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.removeDelegationKeys(name, keyIDs)
+}
+
+func (r *NotaryRepository) removeDelegationKeys(name string, keyIDs []string) error {
 
 	if !data.IsDelegation(name) {
 		return data.ErrInvalidRole{Role: name, Reason: "invalid delegation role name"}
@@ -180,9 +518,60 @@ func (r *NotaryRepository) RemoveDelegationKeys(name string, keyIDs []string) er
 	return addChange(cl, template, name)
 }
 
+// RotateDelegationKey creates a changelist entry to replace one key of an existing
+// delegation with a new key, leaving the other keys, paths and threshold of the
+// delegation untouched.  The key being replaced is given by its canonical key
+// ID, and must be one of the keys of the delegation in the latest published
+// metadata.
+func (r *NotaryRepository) RotateDelegationKey(name, oldKeyID string, newKey data.PublicKey) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if !data.IsDelegation(name) {
+		return data.ErrInvalidRole{Role: name, Reason: "invalid delegation role name"}
+	}
+
+	// An unknown key would not be removed when the change is applied, but the
+	// new key would still be added, so check for it before staging anything
+	role, _, err := r.getDelegationRole(name)
+	if err != nil {
+		return err
+	}
+	if !utils.StrSliceContains(role.KeyIDs, oldKeyID) {
+		return data.ErrInvalidRole{
+			Role:   name,
+			Reason: fmt.Sprintf("key %s is not a key of the delegation role", oldKeyID),
+		}
+	}
+	if err := r.checkDelegationKeys(name, newKey); err != nil {
+		return err
+	}
+
+	cl, err := changelist.NewFileChangelist(filepath.Join(r.tufRepoPath, "changelist"))
+	if err != nil {
+		return err
+	}
+	defer cl.Close()
+
+	logrus.Debugf(`Replacing key %s with key %s in delegation "%s"\n`, oldKeyID, newKey.ID(), name)
+
+	// Removing and adding within the same change means the role is never left
+	// without keys, so it will not be deleted when the change is applied
+	tdJSON, err := json.Marshal(&changelist.TufDelegation{
+		AddKeys:    data.KeyList{newKey},
+		RemoveKeys: []string{oldKeyID},
+	})
+	if err != nil {
+		return err
+	}
+
+	template := newUpdateDelegationChange(name, tdJSON)
+	return addChange(cl, template, name)
+}
+
 // ClearDelegationPaths creates a changelist entry to remove all paths from an existing delegation.
 func (r *NotaryRepository) ClearDelegationPaths(name string) error {
-
+	r.lock.Lock()
+	defer r.lock.Unlock()
 	if !data.IsDelegation(name) {
 		return data.ErrInvalidRole{Role: name, Reason: "invalid delegation role name"}
 	}
@@ -206,6 +595,97 @@ func (r *NotaryRepository) ClearDelegationPaths(name string) error {
 	return addChange(cl, template, name)
 }
 
+// RenameDelegation creates changelist entries that create a new delegation role
+// with the keys, threshold, paths and targets of an existing delegation role, and
+// then remove the existing role, so that the rename happens in a single publish.
+func (r *NotaryRepository) RenameDelegation(oldName, newName string) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if !data.IsDelegation(oldName) {
+		return data.ErrInvalidRole{Role: oldName, Reason: "invalid delegation role name"}
+	}
+	if !data.IsDelegation(newName) {
+		return data.ErrInvalidRole{Role: newName, Reason: "invalid delegation role name"}
+	}
+	if oldName == newName {
+		return data.ErrInvalidRole{Role: newName, Reason: "new delegation role name is the same as the old one"}
+	}
+	// the new role would be delegated to by the old role, which is removed
+	if strings.HasPrefix(newName, oldName+"/") {
+		return data.ErrInvalidRole{Role: newName, Reason: "cannot rename a delegation role to one of its own delegations"}
+	}
+
+	// Update state of the repo to latest
+	if _, err := r.update(false); err != nil {
+		return err
+	}
+
+	oldRole, oldKeys, err := r.tufRepo.GetDelegation(oldName)
+	if err != nil {
+		return err
+	}
+	_, _, err = r.tufRepo.GetDelegation(newName)
+	if err == nil {
+		return data.ErrInvalidRole{Role: newName, Reason: "delegation role already exists"}
+	}
+	if _, ok := err.(data.ErrNoSuchRole); !ok {
+		return err
+	}
+
+	var oldTargets data.Files
+	if oldMeta, ok := r.tufRepo.Targets[oldName]; ok {
+		// the nested delegations would be lost when the old role is removed
+		if len(oldMeta.Signed.Delegations.Roles) > 0 {
+			return data.ErrInvalidRole{
+				Role:   oldName,
+				Reason: "cannot rename a delegation role that has delegations of its own",
+			}
+		}
+		oldTargets = oldMeta.Signed.Targets
+	}
+
+	pubKeys := make(data.KeyList, 0, len(oldRole.KeyIDs))
+	for _, keyID := range oldRole.KeyIDs {
+		pubKeys = append(pubKeys, oldKeys[keyID])
+	}
+	tdJSON, err := json.Marshal(&changelist.TufDelegation{
+		NewThreshold:        oldRole.Threshold,
+		AddKeys:             pubKeys,
+		AddPaths:            oldRole.Paths,
+		AddPathHashPrefixes: oldRole.PathHashPrefixes,
+	})
+	if err != nil {
+		return err
+	}
+	changes := []changelist.Change{newCreateDelegationChange(newName, tdJSON)}
+
+	for targetName, meta := range oldTargets {
+		metaJSON, err := json.Marshal(meta)
+		if err != nil {
+			return err
+		}
+		changes = append(changes, changelist.NewTufChange(
+			changelist.ActionCreate, newName, changelist.TypeTargetsTarget,
+			targetName, metaJSON))
+	}
+	changes = append(changes, newDeleteDelegationChange(oldName, nil))
+
+	cl, err := changelist.NewFileChangelist(filepath.Join(r.tufRepoPath, "changelist"))
+	if err != nil {
+		return err
+	}
+	defer cl.Close()
+
+	logrus.Debugf(`Renaming delegation "%s" to "%s"\n`, oldName, newName)
+
+	for _, c := range changes {
+		if err := cl.Add(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func newUpdateDelegationChange(name string, content []byte) *changelist.TufChange {
 	return changelist.NewTufChange(
 		changelist.ActionUpdate,
@@ -226,6 +706,64 @@ func newCreateDelegationChange(name string, content []byte) *changelist.TufChang
 	)
 }
 
+func newBaseDelegationsChange(name string, content []byte) *changelist.TufChange {
+	return changelist.NewTufChange(
+		changelist.ActionBase,
+		name,
+		changelist.TypeTargetsDelegation,
+		"", // no path for delegations
+		content,
+	)
+}
+
+// BaseDelegationsOnVersion stages resetting the delegations of a role, which
+// is targets or a delegation, to the ones in the given version of its
+// metadata, as kept in the history of metadata downloaded by this client, so
+// that the delegation changes staged after it are made to that version rather
+// than to the latest one.  This is meant for recovery: the version published
+// is still the one after the latest, so publishing overwrites every change to
+// the delegations of the role since the given version, as well as any changes
+// to them staged before this one.
+func (r *NotaryRepository) BaseDelegationsOnVersion(role string, version int) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if role != data.CanonicalTargetsRole && !data.IsDelegation(role) {
+		return data.ErrInvalidRole{Role: role, Reason: "only targets and delegations have delegations"}
+	}
+
+	raw, err := r.historyStore.GetMeta(historyName(role, version), -1)
+	if _, ok := err.(store.ErrMetaNotFound); ok {
+		return ErrVersionNotInHistory{Role: role, Version: version}
+	} else if err != nil {
+		return err
+	}
+	s := &data.Signed{}
+	if err := json.Unmarshal(raw, s); err != nil {
+		return err
+	}
+	tgts, err := data.TargetsFromSigned(s)
+	if err != nil {
+		return err
+	}
+
+	baseJSON, err := json.Marshal(&changelist.TufDelegationsBase{
+		Version:     version,
+		Delegations: tgts.Signed.Delegations,
+	})
+	if err != nil {
+		return err
+	}
+
+	cl, err := changelist.NewFileChangelist(filepath.Join(r.tufRepoPath, "changelist"))
+	if err != nil {
+		return err
+	}
+	defer cl.Close()
+
+	logrus.Debugf("Basing the delegations of %s on version %d", role, version)
+	return addChange(cl, newBaseDelegationsChange(role, baseJSON), role)
+}
+
 func newDeleteDelegationChange(name string, content []byte) *changelist.TufChange {
 	return changelist.NewTufChange(
 		changelist.ActionDelete,
@@ -239,8 +777,22 @@ func newDeleteDelegationChange(name string, content []byte) *changelist.TufChang
 // GetDelegationRoles returns the keys and roles of the repository's delegations
 // Also converts key IDs to canonical key IDs to keep consistent with signing prompts
 func (r *NotaryRepository) GetDelegationRoles() ([]*data.Role, error) {
+	return r.GetDelegationRolesToDepth(-1)
+}
+
+// GetDelegationRolesToDepth returns the keys and roles of the repository's
+// delegations, traversing at most maxDepth levels of nested delegations below
+// the top level ones.  A maxDepth of 0 returns only the delegations of the
+// targets role, and a negative maxDepth traverses the entire delegation tree.
+func (r *NotaryRepository) GetDelegationRolesToDepth(maxDepth int) ([]*data.Role, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.getDelegationRolesToDepth(maxDepth)
+}
+
+func (r *NotaryRepository) getDelegationRolesToDepth(maxDepth int) ([]*data.Role, error) {
 	// Update state of the repo to latest
-	if _, err := r.Update(false); err != nil {
+	if _, err := r.update(false); err != nil {
 		return nil, err
 	}
 
@@ -256,9 +808,16 @@ func (r *NotaryRepository) GetDelegationRoles() ([]*data.Role, error) {
 		return nil, err
 	}
 
-	// make a copy for traversing nested delegations
-	delegationsList := make([]*data.Role, len(allDelegations))
-	copy(delegationsList, allDelegations)
+	// make a copy for traversing nested delegations, keeping track of how
+	// deep in the delegation tree each one is
+	type delegationAtDepth struct {
+		role  *data.Role
+		depth int
+	}
+	delegationsList := make([]delegationAtDepth, 0, len(allDelegations))
+	for _, delegation := range allDelegations {
+		delegationsList = append(delegationsList, delegationAtDepth{role: delegation, depth: 0})
+	}
 
 	// Now traverse to lower level delegations (ex: targets/level1/level2)
 	for len(delegationsList) > 0 {
@@ -266,8 +825,13 @@ func (r *NotaryRepository) GetDelegationRoles() ([]*data.Role, error) {
 		delegation := delegationsList[0]
 		delegationsList = delegationsList[1:]
 
+		// Don't traverse past the requested depth
+		if maxDepth >= 0 && delegation.depth >= maxDepth {
+			continue
+		}
+
 		// Get metadata
-		delegationMeta, ok := r.tufRepo.Targets[delegation.Name]
+		delegationMeta, ok := r.tufRepo.Targets[delegation.role.Name]
 		// If we get an error, don't try to traverse further into this subtree because it doesn't exist or is malformed
 		if !ok {
 			continue
@@ -280,16 +844,432 @@ func (r *NotaryRepository) GetDelegationRoles() ([]*data.Role, error) {
 		}
 		allDelegations = append(allDelegations, canonicalDelegations...)
 		// Add nested delegations to the exploration list
-		delegationsList = append(delegationsList, delegationMeta.Signed.Delegations.Roles...)
+		for _, nested := range delegationMeta.Signed.Delegations.Roles {
+			delegationsList = append(delegationsList, delegationAtDepth{role: nested, depth: delegation.depth + 1})
+		}
 	}
 
 	// Convert all key IDs to canonical IDs:
 	return allDelegations, nil
 }
 
+// GetDelegationRole returns a single delegation role of the repository, with
+// canonical key IDs, along with its public keys indexed by canonical key ID
+func (r *NotaryRepository) GetDelegationRole(name string) (*data.Role, data.Keys, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.getDelegationRole(name)
+}
+
+func (r *NotaryRepository) getDelegationRole(name string) (*data.Role, data.Keys, error) {
+	if !data.IsDelegation(name) {
+		return nil, nil, data.ErrInvalidRole{Role: name, Reason: "invalid delegation role name"}
+	}
+
+	// Update state of the repo to latest
+	if _, err := r.update(false); err != nil {
+		return nil, nil, err
+	}
+	return r.canonicalDelegation(name)
+}
+
+// canonicalDelegation returns a delegation role from the currently loaded
+// metadata with canonical key IDs, along with its public keys indexed by
+// canonical key ID
+func (r *NotaryRepository) canonicalDelegation(name string) (*data.Role, data.Keys, error) {
+	role, keys, err := r.tufRepo.GetDelegation(name)
+	if err != nil {
+		// the name is valid, so an invalid role here means the parent is missing
+		if _, ok := err.(data.ErrInvalidRole); ok {
+			return nil, nil, data.ErrNoSuchRole{Role: name}
+		}
+		return nil, nil, err
+	}
+
+	// make a copy of the role so we only show canonical key IDs
+	canonicalRole := *role
+	canonicalRole.KeyIDs = make([]string, 0, len(role.KeyIDs))
+	canonicalKeys := make(data.Keys)
+	for _, keyID := range role.KeyIDs {
+		pubKey, ok := keys[keyID]
+		if !ok || pubKey == nil {
+			return nil, nil, fmt.Errorf("Could not translate canonical key IDs for %s", name)
+		}
+		canonicalKeyID, err := utils.CanonicalKeyID(pubKey)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Could not translate canonical key IDs for %s: %v", name, err)
+		}
+		canonicalRole.KeyIDs = append(canonicalRole.KeyIDs, canonicalKeyID)
+		canonicalKeys[canonicalKeyID] = pubKey
+	}
+	return &canonicalRole, canonicalKeys, nil
+}
+
+// DelegationKey describes one of the keys of a delegation role
+type DelegationKey struct {
+	ID        string         `json:"id"`
+	Algorithm string         `json:"algorithm"`
+	KeyType   string         `json:"key_type,omitempty"`
+	Expiry    *time.Time     `json:"expiry,omitempty"`
+	CanSign   bool           `json:"can_sign"`
+	PublicKey data.PublicKey `json:"-"`
+}
+
+// DelegationDetail describes a delegation role along with its resolved keys
+type DelegationDetail struct {
+	Name      string   `json:"name"`
+	Threshold int      `json:"threshold"`
+	Paths     []string `json:"paths"`
+	// PathHashPrefixes are matched against the SHA256 digests of target
+	// paths, and are the whole digests of the exact paths of the role
+	PathHashPrefixes []string        `json:"path_hash_prefixes,omitempty"`
+	Keys             []DelegationKey `json:"keys"`
+	// Expires is when the metadata of the role expires, if it has been published
+	Expires *time.Time `json:"expires,omitempty"`
+	// ValidUntil is when the delegation itself stops being trusted, if ever
+	ValidUntil *time.Time `json:"valid_until,omitempty"`
+	// PathNotes document why paths are delegated, keyed by path
+	PathNotes map[string]string `json:"path_notes,omitempty"`
+}
+
+// newDelegationDetail collects the details of a delegation role, given its
+// public keys indexed by canonical key ID and the signing keys that are
+// available locally, as listed by the CryptoService (key paths to roles).  The
+// key type (such as "ECDSA P-256") is set if it can be determined, and the
+// expiry is only set for keys that are certificates.
+func newDelegationDetail(role *data.Role, keys data.Keys, signingKeys map[string]string) DelegationDetail {
+	// non-root keys are listed by their path, which is prefixed with the GUN
+	signingKeyIDs := make(map[string]bool, len(signingKeys))
+	for keyPath := range signingKeys {
+		signingKeyIDs[filepath.Base(keyPath)] = true
+	}
+	detail := DelegationDetail{
+		Name:             role.Name,
+		Threshold:        role.Threshold,
+		Paths:            role.Paths,
+		PathHashPrefixes: role.PathHashPrefixes,
+		Keys:             make([]DelegationKey, 0, len(role.KeyIDs)),
+		ValidUntil:       role.ValidUntil,
+		PathNotes:        role.PathNotes,
+	}
+	for _, keyID := range role.KeyIDs {
+		key := DelegationKey{ID: keyID}
+		if pubKey, ok := keys[keyID]; ok {
+			key.PublicKey = pubKey
+			key.Algorithm = pubKey.Algorithm()
+			if keyType, err := trustmanager.KeyType(pubKey); err == nil {
+				key.KeyType = keyType
+			}
+			if cert, err := trustmanager.LoadCertFromPEM(pubKey.Public()); err == nil {
+				expiry := cert.NotAfter
+				key.Expiry = &expiry
+			}
+		}
+		key.CanSign = signingKeyIDs[keyID]
+		detail.Keys = append(detail.Keys, key)
+	}
+	return detail
+}
+
+// GetDelegationDetail returns the details of a single delegation role of the
+// repository, including its resolved keys
+func (r *NotaryRepository) GetDelegationDetail(name string) (DelegationDetail, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	role, keys, err := r.getDelegationRole(name)
+	if err != nil {
+		return DelegationDetail{}, err
+	}
+	detail := newDelegationDetail(role, keys, r.CryptoService.ListAllKeys())
+	detail.Expires = r.delegationMetadataExpiry(name)
+	return detail, nil
+}
+
+// ListDelegationDetails returns the details of all the delegation roles of the
+// repository, including their resolved keys
+func (r *NotaryRepository) ListDelegationDetails() ([]DelegationDetail, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	roles, err := r.getDelegationRolesToDepth(-1)
+	if err != nil {
+		return nil, err
+	}
+
+	signingKeyIDs := r.CryptoService.ListAllKeys()
+	details := make([]DelegationDetail, 0, len(roles))
+	for _, role := range roles {
+		canonicalRole, keys, err := r.canonicalDelegation(role.Name)
+		if err != nil {
+			return nil, err
+		}
+		detail := newDelegationDetail(canonicalRole, keys, signingKeyIDs)
+		detail.Expires = r.delegationMetadataExpiry(role.Name)
+		details = append(details, detail)
+	}
+	return details, nil
+}
+
+// ListOrphanedDelegationKeys returns the IDs of the delegation private keys
+// that are stored locally for this repo, but that are neither a key of any
+// delegation role in the latest metadata nor added by an unpublished change.
+func (r *NotaryRepository) ListOrphanedDelegationKeys() ([]string, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	roles, err := r.getDelegationRolesToDepth(-1)
+	if err != nil {
+		return nil, err
+	}
+	inUse := make(map[string]bool)
+	for _, role := range roles {
+		for _, keyID := range role.KeyIDs {
+			inUse[keyID] = true
+		}
+	}
+
+	// keys for delegations that have not been published yet are not orphaned
+	cl, err := r.GetChangelist()
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range cl.List() {
+		if c.Type() != changelist.TypeTargetsDelegation {
+			continue
+		}
+		td := changelist.TufDelegation{}
+		if err := json.Unmarshal(c.Content(), &td); err != nil {
+			return nil, err
+		}
+		for _, pubKey := range td.AddKeys {
+			canonicalID, err := utils.CanonicalKeyID(pubKey)
+			if err != nil {
+				return nil, err
+			}
+			inUse[canonicalID] = true
+		}
+	}
+
+	var orphaned []string
+	for keyPath, role := range r.CryptoService.ListAllKeys() {
+		keyID := path.Base(keyPath)
+		// non-root keys are listed by their path, which is prefixed with the GUN
+		if data.IsDelegation(role) && path.Dir(keyPath) == r.gun && !inUse[keyID] {
+			orphaned = append(orphaned, keyID)
+		}
+	}
+	sort.Strings(orphaned)
+	return orphaned, nil
+}
+
+// DelegationChange describes how a delegation role changed in one version of
+// its parent's metadata, as kept in the history of metadata downloaded by this
+// client.  Key IDs are canonical key IDs.
+type DelegationChange struct {
+	Version int `json:"version"`
+	// Seen is when this client first downloaded the version
+	Seen time.Time `json:"seen"`
+	// Expires is when the version of the parent's metadata expires
+	Expires   time.Time `json:"expires"`
+	Created   bool      `json:"created,omitempty"`
+	Removed   bool      `json:"removed,omitempty"`
+	KeyIDs    []string  `json:"keys"`
+	Threshold int       `json:"threshold"`
+	Paths     []string  `json:"paths"`
+
+	AddedKeys         []string `json:"added_keys,omitempty"`
+	RemovedKeys       []string `json:"removed_keys,omitempty"`
+	AddedPaths        []string `json:"added_paths,omitempty"`
+	RemovedPaths      []string `json:"removed_paths,omitempty"`
+	PreviousThreshold int      `json:"previous_threshold,omitempty"`
+}
+
+// DelegationHistory returns how the keys, threshold and paths of a delegation
+// role changed across the versions of its parent's metadata that this client
+// has downloaded, oldest first, after updating to the latest version.  Only
+// the versions in which the delegation was created, changed or removed are
+// returned.  Versions published while this client was not updating are
+// missing, so a change may describe several published changes at once.
+func (r *NotaryRepository) DelegationHistory(name string) ([]DelegationChange, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if !data.IsDelegation(name) {
+		return nil, data.ErrInvalidRole{Role: name, Reason: "invalid delegation role name"}
+	}
+	if _, err := r.update(false); err != nil {
+		return nil, err
+	}
+
+	parent := path.Dir(name)
+	latest, ok := r.tufRepo.Targets[parent]
+	if !ok {
+		return nil, data.ErrNoSuchRole{Role: name}
+	}
+
+	var (
+		changes []DelegationChange
+		prev    *data.Role
+	)
+	for version := 1; version <= latest.Signed.Version; version++ {
+		metaName := historyName(parent, version)
+		raw, err := r.historyStore.GetMeta(metaName, -1)
+		if _, ok := err.(store.ErrMetaNotFound); ok {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		s := &data.Signed{}
+		if err := json.Unmarshal(raw, s); err != nil {
+			return nil, err
+		}
+		tgts, err := data.TargetsFromSigned(s)
+		if err != nil {
+			return nil, err
+		}
+		seen, err := r.historyStore.GetMetaModTime(metaName)
+		if err != nil {
+			return nil, err
+		}
+
+		var role *data.Role
+		roles, err := translateDelegationsToCanonicalIDs(tgts.Signed.Delegations)
+		if err != nil {
+			return nil, err
+		}
+		if i := utils.FindRoleIndex(roles, name); i >= 0 {
+			role = roles[i]
+		}
+
+		change := DelegationChange{Version: version, Seen: seen, Expires: tgts.Signed.Expires}
+		switch {
+		case role == nil && prev == nil:
+			continue
+		case role == nil:
+			change.Removed = true
+			change.KeyIDs, change.Paths = []string{}, []string{}
+		default:
+			change.KeyIDs, change.Threshold, change.Paths = role.KeyIDs, role.Threshold, role.Paths
+			if change.Paths == nil {
+				change.Paths = []string{}
+			}
+			var prevKeyIDs, prevPaths []string
+			change.Created = prev == nil
+			if prev != nil {
+				prevKeyIDs, prevPaths = prev.KeyIDs, prev.Paths
+				if prev.Threshold != role.Threshold {
+					change.PreviousThreshold = prev.Threshold
+				}
+			}
+			change.AddedKeys = strSliceDifference(role.KeyIDs, prevKeyIDs)
+			change.RemovedKeys = strSliceDifference(prevKeyIDs, role.KeyIDs)
+			change.AddedPaths = strSliceDifference(role.Paths, prevPaths)
+			change.RemovedPaths = strSliceDifference(prevPaths, role.Paths)
+			if prev != nil && change.PreviousThreshold == 0 && len(change.AddedKeys) == 0 &&
+				len(change.RemovedKeys) == 0 && len(change.AddedPaths) == 0 && len(change.RemovedPaths) == 0 {
+				// the parent changed, but not this delegation
+				prev = role
+				continue
+			}
+		}
+		changes = append(changes, change)
+		prev = role
+	}
+	return changes, nil
+}
+
+// DelegationDifference describes how a delegation role differs between two
+// repositories, as returned by CompareDelegations.  Key IDs are canonical key
+// IDs.  The thresholds are only set if they differ.
+type DelegationDifference struct {
+	Role         string `json:"role"`
+	OnlyInFirst  bool   `json:"only_in_first,omitempty"`
+	OnlyInSecond bool   `json:"only_in_second,omitempty"`
+
+	FirstThreshold    int      `json:"first_threshold,omitempty"`
+	SecondThreshold   int      `json:"second_threshold,omitempty"`
+	KeysOnlyInFirst   []string `json:"keys_only_in_first,omitempty"`
+	KeysOnlyInSecond  []string `json:"keys_only_in_second,omitempty"`
+	PathsOnlyInFirst  []string `json:"paths_only_in_first,omitempty"`
+	PathsOnlyInSecond []string `json:"paths_only_in_second,omitempty"`
+}
+
+// CompareDelegations matches the delegation roles of two repositories, as
+// returned by GetDelegationRoles, by name, and returns how the keys,
+// threshold and paths of the roles that differ do, sorted by role name.  It
+// returns no differences if the delegations are the same.
+func CompareDelegations(first, second []*data.Role) []DelegationDifference {
+	firstRoles := make(map[string]*data.Role)
+	for _, role := range first {
+		firstRoles[role.Name] = role
+	}
+	secondRoles := make(map[string]*data.Role)
+	for _, role := range second {
+		secondRoles[role.Name] = role
+	}
+
+	var names []string
+	for name := range firstRoles {
+		names = append(names, name)
+	}
+	for name := range secondRoles {
+		if _, ok := firstRoles[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var diffs []DelegationDifference
+	for _, name := range names {
+		a, inFirst := firstRoles[name]
+		b, inSecond := secondRoles[name]
+		diff := DelegationDifference{Role: name, OnlyInFirst: !inSecond, OnlyInSecond: !inFirst}
+		if inFirst && inSecond {
+			if a.Threshold != b.Threshold {
+				diff.FirstThreshold, diff.SecondThreshold = a.Threshold, b.Threshold
+			}
+			diff.KeysOnlyInFirst = strSliceDifference(a.KeyIDs, b.KeyIDs)
+			diff.KeysOnlyInSecond = strSliceDifference(b.KeyIDs, a.KeyIDs)
+			diff.PathsOnlyInFirst = strSliceDifference(a.Paths, b.Paths)
+			diff.PathsOnlyInSecond = strSliceDifference(b.Paths, a.Paths)
+			if diff.FirstThreshold == 0 && len(diff.KeysOnlyInFirst) == 0 && len(diff.KeysOnlyInSecond) == 0 &&
+				len(diff.PathsOnlyInFirst) == 0 && len(diff.PathsOnlyInSecond) == 0 {
+				continue
+			}
+		}
+		diffs = append(diffs, diff)
+	}
+	return diffs
+}
+
+// strSliceDifference returns the strings in a that are not in b, sorted
+func strSliceDifference(a, b []string) []string {
+	var diff []string
+	for _, s := range a {
+		if !utils.StrSliceContains(b, s) {
+			diff = append(diff, s)
+		}
+	}
+	sort.Strings(diff)
+	return diff
+}
+
+// delegationMetadataExpiry returns when the loaded metadata of a delegation
+// role expires, or nil if there is no metadata for the role yet
+func (r *NotaryRepository) delegationMetadataExpiry(name string) *time.Time {
+	meta, ok := r.tufRepo.Targets[name]
+	if !ok {
+		return nil
+	}
+	expires := meta.Signed.Expires
+	return &expires
+}
+
 func translateDelegationsToCanonicalIDs(delegationInfo data.Delegations) ([]*data.Role, error) {
+	// copy the roles themselves, not just the pointers to them, so that the
+	// key IDs in the repo's metadata are left alone
 	canonicalDelegations := make([]*data.Role, len(delegationInfo.Roles))
-	copy(canonicalDelegations, delegationInfo.Roles)
+	for i, delegation := range delegationInfo.Roles {
+		delegationCopy := *delegation
+		canonicalDelegations[i] = &delegationCopy
+	}
 	delegationKeys := delegationInfo.Keys
 	for i, delegation := range canonicalDelegations {
 		canonicalKeyIDs := []string{}
@@ -0,0 +1,296 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/docker/notary/tuf"
+	"github.com/docker/notary/tuf/data"
+	"github.com/docker/notary/tuf/signed"
+	"github.com/docker/notary/tuf/utils"
+)
+
+// externalSigningFile is the file in the repository directory that keeps the
+// metadata of the roles that are signed with detached signatures, by role
+// name, until they are published
+const externalSigningFile = "external_signing.json"
+
+// SigningRequest prepares the metadata of a targets or delegation role that
+// the next Publish would send, with the staged changes applied, for signing
+// by an external tool.  It returns the canonical bytes to be signed, and keeps
+// the metadata so that signatures made over those bytes can be added with
+// ApplySignature.  The next Publish then sends the metadata with those
+// signatures instead of signing the role itself.  A new signing request for
+// the role replaces any previous one, along with its signatures.
+func (r *NotaryRepository) SigningRequest(role string) ([]byte, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if role != data.CanonicalTargetsRole && !data.IsDelegation(role) {
+		return nil, data.ErrInvalidRole{Role: role, Reason: "only targets and delegation roles can be signed externally"}
+	}
+	requests, err := r.loadSigningRequests()
+	if err != nil {
+		return nil, err
+	}
+
+	restore, err := r.applyChangelistForSigning(requests, role)
+	if err != nil {
+		return nil, err
+	}
+	defer restore()
+
+	targets, ok := r.tufRepo.Targets[role]
+	if !ok {
+		return nil, data.ErrInvalidRole{Role: role, Reason: "the role has no metadata to sign"}
+	}
+	targets.Signed.Version++
+	targets.Signed.Expires = data.DefaultExpires(data.CanonicalTargetsRole)
+	s, err := targets.ToSigned()
+	if err != nil {
+		return nil, err
+	}
+	s.Signatures = []data.Signature{}
+
+	requests[role] = s
+	if err := r.saveSigningRequests(requests); err != nil {
+		return nil, err
+	}
+	return s.Signed, nil
+}
+
+// ApplySignature adds a detached signature over the bytes returned by the
+// last SigningRequest for the role.  The key is given by either the ID the
+// role lists it under or its canonical ID, and has to be one of the keys of
+// the role once the staged changes are applied.  The signature is checked
+// before it is kept, and replaces any earlier signature by the same key.
+func (r *NotaryRepository) ApplySignature(role, keyID string, sig []byte) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	requests, err := r.loadSigningRequests()
+	if err != nil {
+		return err
+	}
+	s, ok := requests[role]
+	if !ok {
+		return fmt.Errorf("there is no signing request for %s", role)
+	}
+
+	restore, err := r.applyChangelistForSigning(requests, role)
+	if err != nil {
+		return err
+	}
+	defer restore()
+
+	var baseRole data.BaseRole
+	if role == data.CanonicalTargetsRole {
+		baseRole, err = r.tufRepo.GetBaseRole(role)
+	} else {
+		var delgRole data.DelegationRole
+		delgRole, err = r.tufRepo.GetDelegationRole(role)
+		baseRole = delgRole.BaseRole
+	}
+	if err != nil {
+		return err
+	}
+	key := roleKeyByID(baseRole, keyID)
+	if key == nil {
+		return signed.ErrInvalidSigningKey{Role: role, KeyID: keyID}
+	}
+
+	method := signatureAlgorithm(key)
+	verifier, ok := signed.Verifiers[method]
+	if !ok {
+		return fmt.Errorf("unsupported key type for signing: %s", key.Algorithm())
+	}
+	if err := verifier.Verify(key, sig, s.Signed); err != nil {
+		return fmt.Errorf("the signature does not verify with key %s: %v", keyID, err)
+	}
+
+	signatures := []data.Signature{{KeyID: key.ID(), Method: method, Signature: sig}}
+	for _, existing := range s.Signatures {
+		if existing.KeyID != key.ID() {
+			signatures = append(signatures, existing)
+		}
+	}
+	s.Signatures = signatures
+	return r.saveSigningRequests(requests)
+}
+
+// CanonicalMetadata returns the canonical JSON of the signed part of the
+// latest trusted metadata of a role, which is what its signatures are made
+// over, so that external tools can check or reproduce them.  The staged
+// changes are not applied.
+func (r *NotaryRepository) CanonicalMetadata(role string) ([]byte, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if !data.ValidRole(role) {
+		return nil, data.ErrInvalidRole{Role: role, Reason: "invalid role name"}
+	}
+	if _, err := r.update(false); err != nil {
+		return nil, err
+	}
+	s, err := r.signedMetadata(role)
+	if err != nil {
+		return nil, err
+	}
+	return s.Signed, nil
+}
+
+// signedMetadata is the trusted metadata of a role, with its signatures
+func (r *NotaryRepository) signedMetadata(role string) (*data.Signed, error) {
+	var (
+		s   *data.Signed
+		err error
+	)
+	switch role {
+	case data.CanonicalRootRole:
+		s, err = r.tufRepo.Root.ToSigned()
+	case data.CanonicalSnapshotRole:
+		if r.tufRepo.Snapshot == nil {
+			return nil, data.ErrInvalidRole{Role: role, Reason: "the role has no metadata"}
+		}
+		s, err = r.tufRepo.Snapshot.ToSigned()
+	case data.CanonicalTimestampRole:
+		if r.tufRepo.Timestamp == nil {
+			return nil, data.ErrInvalidRole{Role: role, Reason: "the role has no metadata"}
+		}
+		s, err = r.tufRepo.Timestamp.ToSigned()
+	default:
+		targets, ok := r.tufRepo.Targets[role]
+		if !ok {
+			return nil, data.ErrInvalidRole{Role: role, Reason: "the role has no metadata"}
+		}
+		s, err = targets.ToSigned()
+	}
+	return s, err
+}
+
+// applyChangelistForSigning updates the repo and applies the staged changes
+// to it, with the roles that have signing requests and the given role signed
+// externally, and returns a function that puts the repo back the way it was
+func (r *NotaryRepository) applyChangelistForSigning(requests map[string]*data.Signed, role string) (func(), error) {
+	if _, err := r.updateForPublish(); err != nil {
+		return nil, err
+	}
+	cl, err := r.GetChangelist()
+	if err != nil {
+		return nil, err
+	}
+	original, err := r.tufRepo.Copy()
+	if err != nil {
+		return nil, err
+	}
+	for roleName := range requests {
+		r.tufRepo.SignExternally(roleName)
+	}
+	r.tufRepo.SignExternally(role)
+	restore := func() { r.tufRepo = original }
+	if err := applyChangelist(r.tufRepo, cl); err != nil {
+		restore()
+		return nil, err
+	}
+	return restore, nil
+}
+
+func (r *NotaryRepository) loadSigningRequests() (map[string]*data.Signed, error) {
+	requests := make(map[string]*data.Signed)
+	raw, err := ioutil.ReadFile(filepath.Join(r.tufRepoPath, externalSigningFile))
+	if os.IsNotExist(err) {
+		return requests, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(raw, &requests); err != nil {
+		return nil, err
+	}
+	return requests, nil
+}
+
+func (r *NotaryRepository) saveSigningRequests(requests map[string]*data.Signed) error {
+	raw, err := json.Marshal(requests)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(r.tufRepoPath, 0700); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(r.tufRepoPath, externalSigningFile), raw, 0600)
+}
+
+func (r *NotaryRepository) clearSigningRequests() error {
+	err := os.Remove(filepath.Join(r.tufRepoPath, externalSigningFile))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// useExternalSignatures makes the metadata of the role the one from its
+// signing request, with the detached signatures that were applied to it, and
+// returns it serialized to JSON.  It fails if there are no signatures yet, or
+// if the metadata has changed since the signing request was made.
+func useExternalSignatures(tufRepo *tuf.Repo, role string, s *data.Signed) ([]byte, error) {
+	if len(s.Signatures) == 0 {
+		return nil, fmt.Errorf("no signature has been applied to the signing request for %s", role)
+	}
+	current, ok := tufRepo.Targets[role]
+	if !ok {
+		return nil, data.ErrInvalidRole{Role: role, Reason: "the role of the signing request has no metadata"}
+	}
+	requested, err := data.TargetsFromSigned(s)
+	if err != nil {
+		return nil, err
+	}
+	if requested.Signed.Version <= current.Signed.Version {
+		return nil, fmt.Errorf("the signing request for %s is for version %d, but version %d has been published since",
+			role, requested.Signed.Version, current.Signed.Version)
+	}
+
+	// the request has the version and expiry given to it when it was made, so
+	// only the rest of the metadata needs to be unchanged
+	version, expires := current.Signed.Version, current.Signed.Expires
+	current.Signed.Version, current.Signed.Expires = requested.Signed.Version, requested.Signed.Expires
+	currentSigned, err := current.ToSigned()
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(currentSigned.Signed, s.Signed) {
+		current.Signed.Version, current.Signed.Expires = version, expires
+		return nil, fmt.Errorf("the metadata of %s has changed since its signing request was made", role)
+	}
+	current.Signatures = s.Signatures
+	return json.Marshal(s)
+}
+
+// roleKeyByID finds the key of a role by either the ID it is listed under in
+// the role or its canonical ID
+func roleKeyByID(role data.BaseRole, keyID string) data.PublicKey {
+	if key, ok := role.Keys[keyID]; ok {
+		return key
+	}
+	for _, key := range role.Keys {
+		if canonicalID, err := utils.CanonicalKeyID(key); err == nil && canonicalID == keyID {
+			return key
+		}
+	}
+	return nil
+}
+
+// signatureAlgorithm is the algorithm of the signatures made with a key
+func signatureAlgorithm(key data.PublicKey) data.SigAlgorithm {
+	switch key.Algorithm() {
+	case data.ECDSAKey, data.ECDSAx509Key:
+		return data.ECDSASignature
+	case data.RSAKey, data.RSAx509Key:
+		return data.RSAPSSSignature
+	case data.ED25519Key:
+		return data.EDDSASignature
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,170 @@
+package client
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/docker/go/canonical/json"
+	"github.com/docker/notary/tuf/data"
+)
+
+// metadataDir is the directory, under the directory of a GUN in the cache,
+// that the latest metadata of each role is cached in
+const metadataDir = "metadata"
+
+// MetadataProblem is an inconsistency in the metadata of a GUN cached in a
+// trust directory
+type MetadataProblem struct {
+	// Path is the path of the file the problem is with
+	Path    string
+	Problem string
+}
+
+// CachedGUNs lists the GUNs that have metadata cached under cacheDir
+func CachedGUNs(cacheDir string) ([]string, error) {
+	tufPath := filepath.Join(cacheDir, tufDir)
+	var guns []string
+	err := filepath.Walk(tufPath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == tufPath {
+				return nil
+			}
+			return err
+		}
+		if !info.IsDir() || info.Name() != metadataDir {
+			return nil
+		}
+		gun, err := filepath.Rel(tufPath, filepath.Dir(path))
+		if err != nil {
+			return err
+		}
+		guns = append(guns, filepath.ToSlash(gun))
+		return filepath.SkipDir
+	})
+	sort.Strings(guns)
+	return guns, err
+}
+
+// CheckCachedMetadata checks that the metadata of a GUN cached under cacheDir
+// is consistent: that there is root metadata, that every file can be parsed,
+// and that the targets metadata of every role, and the snapshot, match the
+// snapshot and the timestamp that list them.  The metadata of delegations that
+// have not been downloaded yet is not missing, so only the files that are
+// there are checked.  Nothing is changed.
+func CheckCachedMetadata(cacheDir, gun string) ([]MetadataProblem, error) {
+	dir := filepath.Join(cacheDir, tufDir, filepath.FromSlash(gun), metadataDir)
+	files := make(map[string][]byte)
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == dir {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() || filepath.Ext(path) != ".json" {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		raw, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		files[strings.TrimSuffix(filepath.ToSlash(rel), ".json")] = raw
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var problems []MetadataProblem
+	report := func(role, format string, args ...interface{}) {
+		problems = append(problems, MetadataProblem{
+			Path:    filepath.Join(dir, filepath.FromSlash(role)+".json"),
+			Problem: fmt.Sprintf(format, args...),
+		})
+	}
+
+	if _, ok := files[data.CanonicalRootRole]; !ok && len(files) > 0 {
+		problems = append(problems, MetadataProblem{
+			Path:    filepath.Join(dir, data.CanonicalRootRole+".json"),
+			Problem: "there is cached metadata, but no root metadata to trust it with",
+		})
+	}
+	signed := make(map[string]*data.Signed)
+	for role, raw := range files {
+		s := &data.Signed{}
+		if err := json.Unmarshal(raw, s); err != nil {
+			report(role, "cannot be parsed: %v", err)
+			continue
+		}
+		signed[role] = s
+	}
+
+	if s, ok := signed[data.CanonicalTimestampRole]; ok {
+		timestamp, err := data.TimestampFromSigned(s)
+		if err != nil {
+			report(data.CanonicalTimestampRole, "is not timestamp metadata: %v", err)
+		} else if raw, ok := files[data.CanonicalSnapshotRole]; ok {
+			if meta, ok := timestamp.Signed.Meta[data.CanonicalSnapshotRole]; ok && !matchesFileMeta(raw, meta) {
+				report(data.CanonicalSnapshotRole, "does not match the checksum the timestamp lists for it")
+			}
+		}
+	}
+
+	if s, ok := signed[data.CanonicalSnapshotRole]; ok {
+		snapshot, err := data.SnapshotFromSigned(s)
+		if err != nil {
+			report(data.CanonicalSnapshotRole, "is not snapshot metadata: %v", err)
+		} else {
+			// the files that cannot be parsed have already been reported
+			for role := range signed {
+				if role == data.CanonicalRootRole || role == data.CanonicalSnapshotRole || role == data.CanonicalTimestampRole {
+					continue
+				}
+				meta, ok := snapshot.Signed.Meta[role]
+				switch {
+				case !ok:
+					report(role, "is not listed in the snapshot")
+				case !matchesFileMeta(files[role], meta):
+					report(role, "does not match the checksum the snapshot lists for it")
+				}
+			}
+		}
+	}
+
+	sort.Sort(metadataProblemSorter(problems))
+	return problems, nil
+}
+
+// matchesFileMeta is whether the metadata has the length and SHA-256 checksum
+// it is listed with
+func matchesFileMeta(raw []byte, meta data.FileMeta) bool {
+	if meta.Length != 0 && meta.Length != int64(len(raw)) {
+		return false
+	}
+	if expected, ok := meta.Hashes["sha256"]; ok {
+		checksum := sha256.Sum256(raw)
+		return bytes.Equal(checksum[:], expected)
+	}
+	return true
+}
+
+type metadataProblemSorter []MetadataProblem
+
+func (m metadataProblemSorter) Len() int      { return len(m) }
+func (m metadataProblemSorter) Swap(i, j int) { m[i], m[j] = m[j], m[i] }
+func (m metadataProblemSorter) Less(i, j int) bool {
+	if m[i].Path != m[j].Path {
+		return m[i].Path < m[j].Path
+	}
+	return m[i].Problem < m[j].Problem
+}
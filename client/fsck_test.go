@@ -0,0 +1,62 @@
+package client
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/docker/notary/tuf/data"
+	"github.com/stretchr/testify/require"
+)
+
+// Metadata cached by an update is consistent, and every inconsistency
+// introduced into it afterwards is reported
+func TestCheckCachedMetadata(t *testing.T) {
+	_, serverSwizzler := newServerSwizzler(t)
+	ts := readOnlyServer(t, serverSwizzler.MetadataCache, http.StatusNotFound, "docker.com/notary")
+	defer ts.Close()
+
+	repo := newBlankRepo(t, ts.URL)
+	defer os.RemoveAll(repo.baseDir)
+
+	// nothing has been cached yet
+	problems, err := CheckCachedMetadata(repo.baseDir, "docker.com/notary")
+	require.NoError(t, err)
+	require.Empty(t, problems)
+	problems, err = CheckCachedMetadata(repo.baseDir, "docker.com/other")
+	require.NoError(t, err)
+	require.Empty(t, problems)
+
+	_, err = repo.Update(false)
+	require.NoError(t, err)
+	guns, err := CachedGUNs(repo.baseDir)
+	require.NoError(t, err)
+	require.Equal(t, []string{"docker.com/notary"}, guns)
+	problems, err = CheckCachedMetadata(repo.baseDir, "docker.com/notary")
+	require.NoError(t, err)
+	require.Empty(t, problems)
+
+	dir := filepath.Join(repo.baseDir, tufDir, "docker.com/notary", metadataDir)
+	targets, err := ioutil.ReadFile(filepath.Join(dir, "targets.json"))
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "targets", "orphan.json"), targets, 0600))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "targets.json"), append(targets, ' '), 0600))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "targets", "a.json"), []byte("{"), 0600))
+	require.NoError(t, os.Remove(filepath.Join(dir, "root.json")))
+
+	problems, err = CheckCachedMetadata(repo.baseDir, "docker.com/notary")
+	require.NoError(t, err)
+	found := make(map[string]string)
+	for _, p := range problems {
+		rel, err := filepath.Rel(dir, p.Path)
+		require.NoError(t, err)
+		found[filepath.ToSlash(rel)] = p.Problem
+	}
+	require.Len(t, found, 4)
+	require.Contains(t, found[data.CanonicalRootRole+".json"], "no root metadata")
+	require.Contains(t, found["targets.json"], "does not match the checksum the snapshot lists")
+	require.Contains(t, found["targets/orphan.json"], "not listed in the snapshot")
+	require.Contains(t, found["targets/a.json"], "cannot be parsed")
+}
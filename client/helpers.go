@@ -84,18 +84,36 @@ func changeTargetsDelegation(repo *tuf.Repo, c changelist.Change) error {
 			// error that wasn't ErrNoSuchRole
 			return err
 		}
-		if err == nil {
-			// role existed, attempt to merge paths and keys
+		if err == nil && !td.Replace {
+			// role existed, attempt to merge paths and keys, into a copy of it
+			// so that a rejected change leaves it as it is
+			merged := *r
+			r = &merged
 			if err := r.AddPaths(td.AddPaths); err != nil {
 				return err
 			}
+			r.AddPathHashPrefixes(td.AddPathHashPrefixes)
+			r.AddPathNotes(td.AddPathNotes)
+			if td.ValidUntil != nil {
+				r.ValidUntil = td.ValidUntil
+			}
+			if err := checkDelegationPaths(r); err != nil {
+				return err
+			}
 			return repo.UpdateDelegations(r, td.AddKeys)
 		}
-		// create brand new role
+		if err == nil && td.ValidUntil == nil {
+			// the role is being replaced, but keeps its expiry
+			td.ValidUntil = r.ValidUntil
+		}
+		// create brand new role, or replace the existing one
 		r, err = td.ToNewRole(c.Scope())
 		if err != nil {
 			return err
 		}
+		if err := checkDelegationPaths(r); err != nil {
+			return err
+		}
 		return repo.UpdateDelegations(r, td.AddKeys)
 	case changelist.ActionUpdate:
 		td := changelist.TufDelegation{}
@@ -107,6 +125,10 @@ func changeTargetsDelegation(repo *tuf.Repo, c changelist.Change) error {
 		if err != nil {
 			return err
 		}
+		// the role is changed on a copy, so that a rejected change leaves it
+		// as it is
+		updated := *r
+		r = &updated
 
 		// We need to translate the keys from canonical ID to TUF ID for compatibility
 		canonicalToTUFID := make(map[string]string)
@@ -132,24 +154,89 @@ func changeTargetsDelegation(repo *tuf.Repo, c changelist.Change) error {
 		if err := r.AddPaths(td.AddPaths); err != nil {
 			return err
 		}
+		r.AddPathHashPrefixes(td.AddPathHashPrefixes)
+		r.AddPathNotes(td.AddPathNotes)
 
 		// Clear all paths if we're given the flag, else remove specified paths
 		if td.ClearAllPaths {
 			r.RemovePaths(r.Paths)
+			r.RemovePathHashPrefixes(r.PathHashPrefixes)
 		} else {
 			r.RemovePaths(td.RemovePaths)
+			r.RemovePathHashPrefixes(td.RemovePathHashPrefixes)
 		}
 		r.RemoveKeys(removeTUFKeyIDs)
+		if td.ValidUntil != nil {
+			r.ValidUntil = td.ValidUntil
+		}
+		if err := checkDelegationPaths(r); err != nil {
+			return err
+		}
 		return repo.UpdateDelegations(r, td.AddKeys)
 	case changelist.ActionDelete:
 		r := data.Role{Name: c.Scope()}
 		return repo.DeleteDelegation(r)
+	case changelist.ActionBase:
+		return applyDelegationsBase(repo, c)
 	default:
 		return fmt.Errorf("unsupported action against delegations: %s", c.Action())
 	}
 
 }
 
+// checkDelegationPaths rejects a delegation role with both paths and path hash
+// prefixes, since the TUF specification only allows a role one of them, so
+// other TUF clients may not interpret such a role the way notary does
+func checkDelegationPaths(r *data.Role) error {
+	if len(r.Paths) > 0 && len(r.PathHashPrefixes) > 0 {
+		return data.ErrInvalidRole{
+			Role:   r.Name,
+			Reason: "a delegation role cannot have both paths and path hash prefixes (exact paths)",
+		}
+	}
+	return nil
+}
+
+// applyDelegationsBase resets the delegations of the role in the scope of the
+// change to the ones of the earlier version in the change.  The delegations
+// that the earlier version does not have are deleted, along with their
+// metadata.  The metadata of the delegations it does have is kept as it is.
+func applyDelegationsBase(repo *tuf.Repo, c changelist.Change) error {
+	base := changelist.TufDelegationsBase{}
+	if err := json.Unmarshal(c.Content(), &base); err != nil {
+		return err
+	}
+	role := c.Scope()
+	if err := repo.VerifyCanSign(role); err != nil {
+		return err
+	}
+	p, ok := repo.Targets[role]
+	if !ok {
+		var err error
+		if p, err = repo.InitTargets(role); err != nil {
+			return err
+		}
+	}
+
+	for _, delgRole := range append([]*data.Role{}, p.Signed.Delegations.Roles...) {
+		if utils.FindRoleIndex(base.Delegations.Roles, delgRole.Name) < 0 {
+			if err := repo.DeleteDelegation(*delgRole); err != nil {
+				return err
+			}
+		}
+	}
+	if base.Delegations.Keys == nil {
+		base.Delegations.Keys = make(data.Keys)
+	}
+	if base.Delegations.Roles == nil {
+		base.Delegations.Roles = []*data.Role{}
+	}
+	p.Signed.Delegations = base.Delegations
+	p.Dirty = true
+	logrus.Debugf("reset the delegations of %s to version %d", role, base.Version)
+	return nil
+}
+
 // applies a function repeatedly, falling back on the parent role, until it no
 // longer can
 func doWithRoleFallback(role string, doFunc func(string) error) error {
@@ -277,3 +364,17 @@ func serializeCanonicalRole(tufRepo *tuf.Repo, role string) (out []byte, err err
 
 	return json.Marshal(s)
 }
+
+// signs and serializes the metadata for a targets or delegation role to JSON,
+// using only the keys with the given IDs if there are any
+func serializeTargetsRole(tufRepo *tuf.Repo, role string, keyIDs []string) ([]byte, error) {
+	if len(keyIDs) == 0 {
+		return serializeCanonicalRole(tufRepo, role)
+	}
+	s, err := tufRepo.SignTargetsWithKeys(
+		role, keyIDs, data.DefaultExpires(data.CanonicalTargetsRole))
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(s)
+}
@@ -246,6 +246,60 @@ func TestApplyTargetsDelegationCreateDelete(t *testing.T) {
 	assert.Len(t, tgts.Signed.Delegations.Keys, 0)
 }
 
+// applying a base resets the delegations of the role to the ones in the base,
+// deleting the delegations that the base doesn't have
+func TestApplyTargetsDelegationsBase(t *testing.T) {
+	repo, cs, err := testutils.EmptyRepo("docker.com/notary")
+	assert.NoError(t, err)
+
+	createDelegation := func(name string) data.PublicKey {
+		newKey, err := cs.Create(name, data.ED25519Key)
+		assert.NoError(t, err)
+		tdJSON, err := json.Marshal(&changelist.TufDelegation{
+			NewThreshold: 1,
+			AddKeys:      data.KeyList{newKey},
+			AddPaths:     []string{name},
+		})
+		assert.NoError(t, err)
+		err = applyTargetsChange(repo, changelist.NewTufChange(
+			changelist.ActionCreate, name, changelist.TypeTargetsDelegation, "", tdJSON))
+		assert.NoError(t, err)
+		return newKey
+	}
+
+	level1Key := createDelegation("targets/level1")
+	baseJSON, err := json.Marshal(&changelist.TufDelegationsBase{
+		Version:     2,
+		Delegations: repo.Targets[data.CanonicalTargetsRole].Signed.Delegations,
+	})
+	assert.NoError(t, err)
+	createDelegation("targets/other")
+	_, err = repo.InitTargets("targets/other")
+	assert.NoError(t, err)
+	assert.Len(t, repo.Targets[data.CanonicalTargetsRole].Signed.Delegations.Roles, 2)
+
+	repo.Targets[data.CanonicalTargetsRole].Dirty = false
+	err = applyTargetsChange(repo, changelist.NewTufChange(
+		changelist.ActionBase, data.CanonicalTargetsRole, changelist.TypeTargetsDelegation, "", baseJSON))
+	assert.NoError(t, err)
+
+	tgts := repo.Targets[data.CanonicalTargetsRole]
+	assert.True(t, tgts.Dirty)
+	assert.Len(t, tgts.Signed.Delegations.Roles, 1)
+	assert.Equal(t, "targets/level1", tgts.Signed.Delegations.Roles[0].Name)
+	assert.Equal(t, []string{"targets/level1"}, tgts.Signed.Delegations.Roles[0].Paths)
+	assert.Len(t, tgts.Signed.Delegations.Keys, 1)
+	_, ok := tgts.Signed.Delegations.Keys[level1Key.ID()]
+	assert.True(t, ok)
+	_, ok = repo.Targets["targets/other"]
+	assert.False(t, ok, "the metadata of a delegation the base doesn't have should be deleted")
+
+	// the content of the change has to be a base
+	err = applyTargetsChange(repo, changelist.NewTufChange(
+		changelist.ActionBase, "targets/level1", changelist.TypeTargetsDelegation, "", []byte("{")))
+	assert.Error(t, err)
+}
+
 func TestApplyTargetsDelegationCreate2SharedKey(t *testing.T) {
 	repo, cs, err := testutils.EmptyRepo("docker.com/notary")
 	assert.NoError(t, err)
@@ -0,0 +1,88 @@
+// Package metrics has a Prometheus collector of the metrics of notary client
+// repositories, for services that embed notary.  Nothing is collected unless
+// the collector is both registered with Prometheus and set as the Telemetry of
+// the repositories to monitor, for instance:
+//
+//	collector := metrics.NewCollector()
+//	prometheus.MustRegister(collector)
+//	repo.Telemetry = collector
+//	http.Handle("/metrics", prometheus.Handler())
+//
+// One collector can be shared by all of the repositories of a service.
+package metrics
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// The results a download of metadata is counted under
+const (
+	resultSuccess = "success"
+	resultFailure = "failure"
+)
+
+// Collector counts the downloads of metadata and verification failures of
+// the repositories it is the Telemetry of, and times their signing of
+// metadata.  It is a prometheus.Collector.
+type Collector struct {
+	fetches              *prometheus.CounterVec
+	verificationFailures *prometheus.CounterVec
+	signingDurations     *prometheus.SummaryVec
+}
+
+// NewCollector returns a Collector of the notary_client metrics, which has to
+// be registered with Prometheus for them to be scraped
+func NewCollector() *Collector {
+	return &Collector{
+		fetches: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "notary_client",
+			Name:      "metadata_fetches_total",
+			Help:      "How many times the metadata of a role was downloaded from the server, by role and result.",
+		}, []string{"role", "result"}),
+		verificationFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "notary_client",
+			Name:      "verification_failures_total",
+			Help:      "How many times metadata from the server was rejected, by role and reason.",
+		}, []string{"role", "reason"}),
+		signingDurations: prometheus.NewSummaryVec(prometheus.SummaryOpts{
+			Namespace: "notary_client",
+			Name:      "signing_duration_seconds",
+			Help:      "How long signing the metadata of a role to publish it took, by role.",
+		}, []string{"role"}),
+	}
+}
+
+// Describe implements prometheus.Collector
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	c.fetches.Describe(ch)
+	c.verificationFailures.Describe(ch)
+	c.signingDurations.Describe(ch)
+}
+
+// Collect implements prometheus.Collector
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	c.fetches.Collect(ch)
+	c.verificationFailures.Collect(ch)
+	c.signingDurations.Collect(ch)
+}
+
+// MetadataFetched implements client.Telemetry
+func (c *Collector) MetadataFetched(role string, err error) {
+	result := resultSuccess
+	if err != nil {
+		result = resultFailure
+	}
+	c.fetches.WithLabelValues(role, result).Inc()
+}
+
+// VerificationFailed implements client.Telemetry
+func (c *Collector) VerificationFailed(role, reason string) {
+	c.verificationFailures.WithLabelValues(role, reason).Inc()
+}
+
+// Signed implements client.Telemetry
+func (c *Collector) Signed(role string, duration time.Duration) {
+	c.signingDurations.WithLabelValues(role).Observe(duration.Seconds())
+}
@@ -0,0 +1,88 @@
+package metrics
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/notary/client"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	_ client.Telemetry     = &Collector{}
+	_ prometheus.Collector = &Collector{}
+)
+
+// collect returns the metrics the collector has, by their description and
+// labels
+func collect(t *testing.T, c *Collector) map[string]*dto.Metric {
+	ch := make(chan prometheus.Metric)
+	go func() {
+		c.Collect(ch)
+		close(ch)
+	}()
+	metrics := make(map[string]*dto.Metric)
+	for metric := range ch {
+		m := &dto.Metric{}
+		require.NoError(t, metric.Write(m))
+		key := metric.Desc().String()
+		for _, label := range m.Label {
+			key += fmt.Sprintf(" %s=%s", label.GetName(), label.GetValue())
+		}
+		metrics[key] = m
+	}
+	return metrics
+}
+
+// find returns the one metric whose key contains all of the given parts
+func find(t *testing.T, metrics map[string]*dto.Metric, parts ...string) *dto.Metric {
+	var found *dto.Metric
+	for key, metric := range metrics {
+		matches := true
+		for _, part := range parts {
+			if !strings.Contains(key, part) {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			require.Nil(t, found, "more than one metric matches %v", parts)
+			found = metric
+		}
+	}
+	require.NotNil(t, found, "no metric matches %v", parts)
+	return found
+}
+
+func TestCollector(t *testing.T) {
+	c := NewCollector()
+	require.Empty(t, collect(t, c))
+
+	c.MetadataFetched("root", nil)
+	c.MetadataFetched("root", nil)
+	c.MetadataFetched("targets", fmt.Errorf("connection refused"))
+	c.VerificationFailed("timestamp", "expired metadata")
+	c.Signed("targets", 2*time.Second)
+	c.Signed("targets", time.Second)
+
+	metrics := collect(t, c)
+	require.Len(t, metrics, 4)
+	require.Equal(t, float64(2), find(t, metrics, "notary_client_metadata_fetches_total", "role=root", "result=success").GetCounter().GetValue())
+	require.Equal(t, float64(1), find(t, metrics, "notary_client_metadata_fetches_total", "role=targets", "result=failure").GetCounter().GetValue())
+	require.Equal(t, float64(1), find(t, metrics, "notary_client_verification_failures_total", "role=timestamp", "reason=expired metadata").GetCounter().GetValue())
+	summary := find(t, metrics, "notary_client_signing_duration_seconds", "role=targets").GetSummary()
+	require.Equal(t, uint64(2), summary.GetSampleCount())
+	require.Equal(t, float64(3), summary.GetSampleSum())
+}
+
+func TestCollectorRegisters(t *testing.T) {
+	c := NewCollector()
+	require.NoError(t, prometheus.Register(c))
+	defer prometheus.Unregister(c)
+	// the metrics of a second collector would be duplicates
+	require.Error(t, prometheus.Register(NewCollector()))
+}
@@ -10,10 +10,12 @@ import (
 	"github.com/docker/notary/trustmanager"
 )
 
-// NewNotaryRepository is a helper method that returns a new notary repository.
-// It takes the base directory under where all the trust files will be stored
-// (usually ~/.docker/trust/).
-func NewNotaryRepository(baseDir, gun, baseURL string, rt http.RoundTripper,
+// NewNotaryRepositoryWithCache is a helper method that returns a new notary
+// repository.  It takes the base directory under where the keys and trusted
+// certificates will be stored (usually ~/.docker/trust/), and the directory
+// under where the downloaded TUF metadata will be cached, which is created if
+// needed.  The metadata is cached under the base directory if cacheDir is empty.
+func NewNotaryRepositoryWithCache(baseDir, cacheDir, gun, baseURL string, rt http.RoundTripper,
 	retriever passphrase.Retriever) (
 	*NotaryRepository, error) {
 
@@ -22,6 +24,6 @@ func NewNotaryRepository(baseDir, gun, baseURL string, rt http.RoundTripper,
 		return nil, fmt.Errorf("failed to create private key store in directory: %s", baseDir)
 	}
 
-	return repositoryFromKeystores(baseDir, gun, baseURL, rt,
+	return repositoryFromKeystores(baseDir, cacheDir, gun, baseURL, rt,
 		[]trustmanager.KeyStore{fileKeyStore})
 }
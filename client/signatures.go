@@ -0,0 +1,149 @@
+package client
+
+import (
+	"sort"
+
+	"github.com/docker/notary/tuf/data"
+	"github.com/docker/notary/tuf/signed"
+)
+
+// The statuses of a signature on the metadata of a role
+const (
+	// SignatureValid is a signature by one of the keys of the role that
+	// verifies
+	SignatureValid = "valid"
+	// SignatureInvalid is a signature by one of the keys of the role that
+	// does not verify, or uses a signing method that is not supported
+	SignatureInvalid = "invalid"
+	// SignatureUnauthorized is a signature by a key that the repository
+	// lists, but not for the role
+	SignatureUnauthorized = "unauthorized"
+	// SignatureUnknownKey is a signature by a key that the repository does
+	// not list at all
+	SignatureUnknownKey = "unknown key"
+)
+
+// SignatureCheck is a signature on the metadata of a role, and whether it
+// verifies against the keys of the role
+type SignatureCheck struct {
+	KeyID  string `json:"key_id"`
+	Method string `json:"method"`
+	Status string `json:"status"`
+}
+
+// RoleSignatureChecks are the checked signatures on the metadata of a role
+type RoleSignatureChecks struct {
+	Role       string           `json:"role"`
+	Threshold  int              `json:"threshold"`
+	Signatures []SignatureCheck `json:"signatures"`
+}
+
+// Valid is how many of the signatures verify against the keys of the role
+func (c RoleSignatureChecks) Valid() int {
+	valid := 0
+	for _, sig := range c.Signatures {
+		if sig.Status == SignatureValid {
+			valid++
+		}
+	}
+	return valid
+}
+
+// CheckSignatures returns, for every role that has trusted metadata, the key
+// IDs in the signatures of the metadata, and whether each one is a valid
+// signature by one of the keys of the role, an invalid one, or one by a key
+// the role does not have.  The roles are in the order root, targets and the
+// delegations by depth, snapshot, timestamp.
+func (r *NotaryRepository) CheckSignatures() ([]RoleSignatureChecks, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if _, err := r.update(false); err != nil {
+		return nil, err
+	}
+
+	knownKeys := r.knownKeyIDs()
+	targetsRoles := make([]string, 0, len(r.tufRepo.Targets))
+	for role := range r.tufRepo.Targets {
+		targetsRoles = append(targetsRoles, role)
+	}
+	sort.Sort(byDepth(targetsRoles))
+
+	roles := append([]string{data.CanonicalRootRole}, targetsRoles...)
+	if r.tufRepo.Snapshot != nil {
+		roles = append(roles, data.CanonicalSnapshotRole)
+	}
+	if r.tufRepo.Timestamp != nil {
+		roles = append(roles, data.CanonicalTimestampRole)
+	}
+
+	checks := make([]RoleSignatureChecks, 0, len(roles))
+	for _, role := range roles {
+		baseRole, err := r.baseRoleForSignatures(role)
+		if err != nil {
+			return nil, err
+		}
+		s, err := r.signedMetadata(role)
+		if err != nil {
+			return nil, err
+		}
+
+		roleChecks := RoleSignatureChecks{
+			Role:       role,
+			Threshold:  baseRole.Threshold,
+			Signatures: make([]SignatureCheck, 0, len(s.Signatures)),
+		}
+		for _, sig := range s.Signatures {
+			roleChecks.Signatures = append(roleChecks.Signatures, SignatureCheck{
+				KeyID:  sig.KeyID,
+				Method: sig.Method.String(),
+				Status: signatureStatus(s.Signed, sig, baseRole, knownKeys),
+			})
+		}
+		checks = append(checks, roleChecks)
+	}
+	return checks, nil
+}
+
+// knownKeyIDs are the IDs of every key the repository lists, for any role
+func (r *NotaryRepository) knownKeyIDs() map[string]struct{} {
+	knownKeys := make(map[string]struct{})
+	for keyID := range r.tufRepo.Root.Signed.Keys {
+		knownKeys[keyID] = struct{}{}
+	}
+	for _, targets := range r.tufRepo.Targets {
+		for keyID := range targets.Signed.Delegations.Keys {
+			knownKeys[keyID] = struct{}{}
+		}
+	}
+	return knownKeys
+}
+
+// baseRoleForSignatures is the role, with its keys and threshold, that the
+// signatures on the metadata of a role are checked against
+func (r *NotaryRepository) baseRoleForSignatures(role string) (data.BaseRole, error) {
+	if data.IsDelegation(role) {
+		delgRole, err := r.tufRepo.GetDelegationRole(role)
+		return delgRole.BaseRole, err
+	}
+	return r.tufRepo.GetBaseRole(role)
+}
+
+// signatureStatus checks a signature over the canonical bytes msg of the
+// metadata of a role
+func signatureStatus(msg []byte, sig data.Signature, role data.BaseRole, knownKeys map[string]struct{}) string {
+	key, ok := role.Keys[sig.KeyID]
+	if !ok {
+		if _, ok := knownKeys[sig.KeyID]; ok {
+			return SignatureUnauthorized
+		}
+		return SignatureUnknownKey
+	}
+	verifier, ok := signed.Verifiers[sig.Method]
+	if !ok {
+		return SignatureInvalid
+	}
+	if err := verifier.Verify(key, sig.Signature, msg); err != nil {
+		return SignatureInvalid
+	}
+	return SignatureValid
+}
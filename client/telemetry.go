@@ -0,0 +1,147 @@
+package client
+
+import (
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"github.com/docker/notary/certs"
+	tufclient "github.com/docker/notary/tuf/client"
+	"github.com/docker/notary/tuf/data"
+	"github.com/docker/notary/tuf/signed"
+	"github.com/docker/notary/tuf/store"
+)
+
+// Telemetry is told what a NotaryRepository does, so that a service embedding
+// notary can monitor it.  Its methods are called synchronously, possibly by
+// several repositories at once, so they have to be quick and safe for
+// concurrent use.  The metrics package has one that Prometheus can collect.
+type Telemetry interface {
+	// MetadataFetched is called after every download of the metadata of a
+	// role from the server, with the error if it failed.  A download that
+	// finds the cached metadata is still current has not failed.
+	MetadataFetched(role string, err error)
+	// VerificationFailed is called whenever metadata from the server is
+	// rejected, with the role it is of, or "" if that is not known, and why
+	// it was rejected
+	VerificationFailed(role, reason string)
+	// Signed is called after the metadata of a role has been signed to be
+	// published, with how long signing it took
+	Signed(role string, duration time.Duration)
+}
+
+// The reasons why metadata is rejected, besides those of
+// tufclient.VerificationFailure
+const (
+	FailureChecksum          = "checksum mismatch"
+	FailureLowVersion        = "version rollback"
+	FailureRootValidation    = "root validation"
+	FailureKeyRevoked        = "revoked key"
+	FailureUnexpectedKey     = "unexpected server key"
+	FailureExpiresSoon       = "expires soon"
+	FailureAlgorithmDisabled = "algorithm not allowed"
+)
+
+// verificationFailure tells whether an error updating the repository is the
+// rejection of metadata from the server, and if so of the metadata of which
+// role, if that is known, and why
+func verificationFailure(err error) (role, reason string, ok bool) {
+	switch err := err.(type) {
+	case tufclient.ErrVerification:
+		return err.Role(), string(err.Reason), true
+	case signed.ErrExpired:
+		return err.Role, string(tufclient.FailureExpired), true
+	case signed.ErrRoleThreshold, signed.ErrInsufficientSignatures:
+		return "", string(tufclient.FailureThreshold), true
+	case signed.ErrLowVersion:
+		return "", FailureLowVersion, true
+	case tufclient.ErrChecksumMismatch:
+		return "", FailureChecksum, true
+	case certs.ErrValidationFail, certs.ErrRootRotationFail:
+		return data.CanonicalRootRole, FailureRootValidation, true
+	case ErrKeyRevoked:
+		return err.Role, FailureKeyRevoked, true
+	case ErrAlgorithmNotAllowed:
+		return err.Role, FailureAlgorithmDisabled, true
+	case ErrUnexpectedServerKey:
+		return err.Role, FailureUnexpectedKey, true
+	case ErrExpiresSoon:
+		return err.Role, FailureExpiresSoon, true
+	}
+	return "", "", false
+}
+
+// reportVerificationFailure tells the Telemetry, if there is one, about an
+// error updating the repository if it is a verification failure
+func (r *NotaryRepository) reportVerificationFailure(err error) {
+	if r.Telemetry == nil || err == nil {
+		return
+	}
+	if role, reason, ok := verificationFailure(err); ok {
+		r.Telemetry.VerificationFailed(role, reason)
+	}
+}
+
+// reportSigned tells the Telemetry, if there is one, how long signing the
+// metadata of a role took, if it was signed
+func (r *NotaryRepository) reportSigned(role string, start time.Time, err error) {
+	if r.Telemetry != nil && err == nil {
+		r.Telemetry.Signed(role, time.Since(start))
+	}
+}
+
+// withTelemetry wraps a remote store so that the Telemetry, if there is one,
+// is told about every download of metadata from it
+func (r *NotaryRepository) withTelemetry(remote store.RemoteStore) store.RemoteStore {
+	if r.Telemetry == nil {
+		return remote
+	}
+	telemetryRemote := telemetryStore{RemoteStore: remote, telemetry: r.Telemetry}
+	if conditional, ok := remote.(store.ConditionalRemoteStore); ok {
+		return telemetryConditionalStore{telemetryStore: telemetryRemote, conditional: conditional}
+	}
+	return telemetryRemote
+}
+
+// telemetryStore is a remote store that tells a Telemetry about every
+// download of metadata from it
+type telemetryStore struct {
+	store.RemoteStore
+	telemetry Telemetry
+}
+
+func (s telemetryStore) GetMeta(name string, size int64) ([]byte, error) {
+	meta, err := s.RemoteStore.GetMeta(name, size)
+	s.telemetry.MetadataFetched(metadataRole(name), err)
+	return meta, err
+}
+
+// telemetryConditionalStore is a telemetryStore that can avoid downloading
+// metadata again, as the store it wraps can
+type telemetryConditionalStore struct {
+	telemetryStore
+	conditional store.ConditionalRemoteStore
+}
+
+func (s telemetryConditionalStore) GetMetaIfModified(name string, size int64, cached []byte) ([]byte, error) {
+	meta, err := s.conditional.GetMetaIfModified(name, size, cached)
+	if _, ok := err.(store.ErrMetaNotModified); ok {
+		s.telemetry.MetadataFetched(metadataRole(name), nil)
+	} else {
+		s.telemetry.MetadataFetched(metadataRole(name), err)
+	}
+	return meta, err
+}
+
+// metadataRole is the role that the metadata with the given name is of,
+// without the checksum that a consistent name ends with
+func metadataRole(name string) string {
+	i := strings.LastIndex(name, ".")
+	if i < 0 || len(name)-i-1 != 64 {
+		return name
+	}
+	if _, err := hex.DecodeString(name[i+1:]); err != nil {
+		return name
+	}
+	return name[:i]
+}
@@ -0,0 +1,139 @@
+package client
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/notary/certs"
+	tufclient "github.com/docker/notary/tuf/client"
+	"github.com/docker/notary/tuf/data"
+	"github.com/docker/notary/tuf/signed"
+	"github.com/stretchr/testify/require"
+)
+
+// recordingTelemetry records everything it is told
+type recordingTelemetry struct {
+	fetched  map[string][]error
+	failures []string
+	signed   []string
+}
+
+func newRecordingTelemetry() *recordingTelemetry {
+	return &recordingTelemetry{fetched: make(map[string][]error)}
+}
+
+func (r *recordingTelemetry) MetadataFetched(role string, err error) {
+	r.fetched[role] = append(r.fetched[role], err)
+}
+
+func (r *recordingTelemetry) VerificationFailed(role, reason string) {
+	r.failures = append(r.failures, role+": "+reason)
+}
+
+func (r *recordingTelemetry) Signed(role string, duration time.Duration) {
+	r.signed = append(r.signed, role)
+}
+
+// Every download of metadata in an update is reported, with the checksum
+// stripped from the consistent names of the metadata that is downloaded by
+// checksum
+func TestTelemetryMetadataFetched(t *testing.T) {
+	serverMeta, serverSwizzler := newServerSwizzler(t)
+	ts := readOnlyServer(t, serverSwizzler.MetadataCache, http.StatusNotFound, "docker.com/notary")
+	defer ts.Close()
+
+	repo := newBlankRepo(t, ts.URL)
+	defer os.RemoveAll(repo.baseDir)
+	telemetry := newRecordingTelemetry()
+	repo.Telemetry = telemetry
+
+	_, err := repo.Update(false)
+	require.NoError(t, err)
+	for role := range serverMeta {
+		require.NotEmpty(t, telemetry.fetched[role], "no download of %s reported", role)
+		for _, err := range telemetry.fetched[role] {
+			require.NoError(t, err)
+		}
+	}
+	require.Len(t, telemetry.fetched, len(serverMeta))
+	require.Empty(t, telemetry.failures)
+	require.Empty(t, telemetry.signed)
+}
+
+// Rejected metadata is reported as a verification failure
+func TestTelemetryVerificationFailed(t *testing.T) {
+	_, serverSwizzler := newServerSwizzler(t)
+	require.NoError(t, serverSwizzler.ExpireMetadata(data.CanonicalTimestampRole))
+	ts := readOnlyServer(t, serverSwizzler.MetadataCache, http.StatusNotFound, "docker.com/notary")
+	defer ts.Close()
+
+	repo := newBlankRepo(t, ts.URL)
+	defer os.RemoveAll(repo.baseDir)
+	telemetry := newRecordingTelemetry()
+	repo.Telemetry = telemetry
+
+	_, err := repo.Update(false)
+	require.Error(t, err)
+	require.Equal(t, []string{data.CanonicalTimestampRole + ": " + string(tufclient.FailureExpired)}, telemetry.failures)
+}
+
+// The signing of every role that is published is reported
+func TestTelemetrySigned(t *testing.T) {
+	ts := fullTestServer(t)
+	defer ts.Close()
+
+	repo, _ := initializeRepo(t, data.ECDSAKey, "docker.com/notary", ts.URL, false)
+	defer os.RemoveAll(repo.baseDir)
+	telemetry := newRecordingTelemetry()
+	repo.Telemetry = telemetry
+
+	require.NoError(t, repo.Publish())
+	require.Contains(t, telemetry.signed, data.CanonicalTargetsRole)
+	require.Contains(t, telemetry.signed, data.CanonicalSnapshotRole)
+
+	telemetry.signed = nil
+	addTarget(t, repo, "latest", "../fixtures/intermediate-ca.crt")
+	require.NoError(t, repo.Publish())
+	require.Equal(t, []string{data.CanonicalTargetsRole, data.CanonicalSnapshotRole}, telemetry.signed)
+	require.NotEmpty(t, telemetry.fetched[data.CanonicalTimestampRole])
+}
+
+func TestVerificationFailure(t *testing.T) {
+	for _, testCase := range []struct {
+		err    error
+		role   string
+		reason string
+	}{
+		{tufclient.ErrVerification{Chain: []string{"targets", "targets/a"}, Reason: tufclient.FailureBadSignature},
+			"targets/a", string(tufclient.FailureBadSignature)},
+		{signed.ErrExpired{Role: data.CanonicalSnapshotRole}, data.CanonicalSnapshotRole, string(tufclient.FailureExpired)},
+		{signed.ErrRoleThreshold{}, "", string(tufclient.FailureThreshold)},
+		{signed.ErrLowVersion{Actual: 1, Current: 2}, "", FailureLowVersion},
+		{certs.ErrValidationFail{Reason: "bad"}, data.CanonicalRootRole, FailureRootValidation},
+		{ErrKeyRevoked{Role: "targets/a", KeyID: "abc"}, "targets/a", FailureKeyRevoked},
+		{ErrUnexpectedServerKey{Role: data.CanonicalTimestampRole}, data.CanonicalTimestampRole, FailureUnexpectedKey},
+	} {
+		role, reason, ok := verificationFailure(testCase.err)
+		require.True(t, ok, "%v is a verification failure", testCase.err)
+		require.Equal(t, testCase.role, role)
+		require.Equal(t, testCase.reason, reason)
+	}
+
+	for _, err := range []error{fmt.Errorf("connection refused"), ErrRepoNotInitialized{}} {
+		_, _, ok := verificationFailure(err)
+		require.False(t, ok, "%v is not a verification failure", err)
+	}
+}
+
+func TestMetadataRole(t *testing.T) {
+	checksum := strings.Repeat("ab", 32)
+	require.Equal(t, "root", metadataRole("root"))
+	require.Equal(t, "root", metadataRole("root."+checksum))
+	require.Equal(t, "targets/a", metadataRole("targets/a."+checksum))
+	require.Equal(t, "targets/a.b", metadataRole("targets/a.b"))
+	require.Equal(t, "targets/a."+strings.Repeat("xy", 32), metadataRole("targets/a."+strings.Repeat("xy", 32)))
+}
@@ -6,7 +6,6 @@ import (
 	"path/filepath"
 
 	"github.com/docker/notary"
-	notaryclient "github.com/docker/notary/client"
 	"github.com/docker/notary/passphrase"
 	"github.com/docker/notary/trustmanager"
 	"github.com/spf13/cobra"
@@ -39,11 +38,14 @@ type certCommander struct {
 	// these are for command line parsing - no need to set
 	certRemoveGUN string
 	certRemoveYes bool
+	output        outputFile
 }
 
 func (c *certCommander) GetCommand() *cobra.Command {
 	cmd := cmdCertTemplate.ToCommand(nil)
-	cmd.AddCommand(cmdCertListTemplate.ToCommand(c.certList))
+	cmdCertList := cmdCertListTemplate.ToCommand(c.certList)
+	c.output.addFlags(cmdCertList)
+	cmd.AddCommand(cmdCertList)
 
 	cmdCertRemove := cmdCertRemoveTemplate.ToCommand(c.certRemove)
 	cmdCertRemove.Flags().StringVarP(
@@ -129,6 +131,9 @@ func (c *certCommander) certRemove(cmd *cobra.Command, args []string) error {
 
 	// Ask for confirmation before removing certificates, unless -y is provided
 	if !c.certRemoveYes {
+		if err := checkPromptAllowed(config); err != nil {
+			return err
+		}
 		confirmed := askConfirm()
 		if !confirmed {
 			return fmt.Errorf("Aborting action.")
@@ -138,8 +143,7 @@ func (c *certCommander) certRemove(cmd *cobra.Command, args []string) error {
 	if removeTrustData {
 		// Remove all TUF data, so call RemoveTrustData on a NotaryRepository with the GUN
 		// no online operations are performed so the transport argument is nil
-		nRepo, err := notaryclient.NewNotaryRepository(
-			trustDir, c.certRemoveGUN, getRemoteTrustServer(config), nil, c.retriever)
+		nRepo, err := newRepository(config, c.certRemoveGUN, nil, c.retriever)
 		if err != nil {
 			return fmt.Errorf("Could not establish trust data for GUN %s", c.certRemoveGUN)
 		}
@@ -182,8 +186,12 @@ func (c *certCommander) certList(cmd *cobra.Command, args []string) error {
 
 	trustedCerts := certStore.GetCertificates()
 
-	cmd.Println("")
-	prettyPrintCerts(trustedCerts, cmd.Out())
-	cmd.Println("")
-	return nil
+	out, closeOutput, err := c.output.open(cmd)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(out, "")
+	prettyPrintCerts(trustedCerts, out)
+	fmt.Fprintln(out, "")
+	return closeOutput()
 }
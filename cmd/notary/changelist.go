@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/docker/notary/passphrase"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var cmdChangelistTemplate = usageTemplate{
+	Use:   "changelist",
+	Short: "Operates on unpublished changes.",
+	Long:  `Operations on the unpublished changes of a local trusted collection.`,
+}
+
+var cmdChangelistExportTemplate = usageTemplate{
+	Use:   "export [ GUN ] [ filename ]",
+	Short: "Exports the unpublished changes to a file.",
+	Long:  "Exports the unpublished changes of the local trusted collection identified by the Globally Unique Name to a file, so that they can be imported and published from another host. This is an offline operation.",
+}
+
+var cmdChangelistImportTemplate = usageTemplate{
+	Use:   "import [ GUN ] [ filename ]",
+	Short: "Imports unpublished changes from a file.",
+	Long:  "Imports unpublished changes exported from the same Globally Unique Name into the local trusted collection. This is an offline operation.  Please then use `publish` to push the changes to the remote trusted collection.",
+}
+
+type changelistCommander struct {
+	// these need to be set
+	configGetter func() (*viper.Viper, error)
+	retriever    passphrase.Retriever
+}
+
+func (c *changelistCommander) GetCommand() *cobra.Command {
+	cmd := cmdChangelistTemplate.ToCommand(nil)
+	cmd.AddCommand(cmdChangelistExportTemplate.ToCommand(c.changelistExport))
+	cmd.AddCommand(cmdChangelistImportTemplate.ToCommand(c.changelistImport))
+	return cmd
+}
+
+// changelistExport writes the unpublished changes for a GUN to a file
+func (c *changelistCommander) changelistExport(cmd *cobra.Command, args []string) error {
+	if len(args) < 2 {
+		cmd.Usage()
+		return fmt.Errorf("Must specify a GUN and output filename for export")
+	}
+
+	config, err := c.configGetter()
+	if err != nil {
+		return err
+	}
+
+	gun := args[0]
+	exportFilename := args[1]
+
+	// no online operations are performed by export so the transport argument
+	// should be nil
+	nRepo, err := newRepository(config, gun, nil, c.retriever)
+	if err != nil {
+		return err
+	}
+
+	exportFile, err := os.Create(exportFilename)
+	if err != nil {
+		return fmt.Errorf("Error creating output file: %v", err)
+	}
+	err = nRepo.ExportChangelist(exportFile)
+	exportFile.Close()
+	if err != nil {
+		os.Remove(exportFilename)
+		return fmt.Errorf("Error exporting changelist: %v", err)
+	}
+
+	cmd.Printf("Unpublished changes for %s exported to %s\n", gun, exportFilename)
+	return nil
+}
+
+// changelistImport adds the changes from an exported changelist file to the
+// unpublished changes for a GUN
+func (c *changelistCommander) changelistImport(cmd *cobra.Command, args []string) error {
+	if len(args) < 2 {
+		cmd.Usage()
+		return fmt.Errorf("Must specify a GUN and input filename for import")
+	}
+
+	config, err := c.configGetter()
+	if err != nil {
+		return err
+	}
+
+	gun := args[0]
+	importFilename := args[1]
+
+	// no online operations are performed by import so the transport argument
+	// should be nil
+	nRepo, err := newRepository(config, gun, nil, c.retriever)
+	if err != nil {
+		return err
+	}
+
+	importFile, err := os.Open(importFilename)
+	if err != nil {
+		return fmt.Errorf("Opening file for import: %v", err)
+	}
+	defer importFile.Close()
+
+	if err := nRepo.ImportChangelist(importFile); err != nil {
+		return fmt.Errorf("Error importing changelist: %v", err)
+	}
+
+	cmd.Printf("Changes from %s staged for next publish to repository \"%s\".\n", importFilename, gun)
+	return nil
+}
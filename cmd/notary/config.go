@@ -0,0 +1,156 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"sort"
+
+	"github.com/docker/notary/trustmanager"
+	"github.com/docker/notary/utils"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var cmdConfigTemplate = usageTemplate{
+	Use:   "config",
+	Short: "Operates on the configuration.",
+	Long:  `Operations on the configuration of the notary client.`,
+}
+
+var cmdConfigValidateTemplate = usageTemplate{
+	Use:   "validate",
+	Short: "Checks the configuration for problems.",
+	Long:  "Loads the configuration the same way every other command does, from the configuration file, the NOTARY_ environment variables and the command line flags, and checks it: that the trust and cache directories exist and are writable, that the trust server URLs parse, that the root CA and TLS client certificate and key load, and that every other value is valid. All the problems found are listed, and the command fails if there are any.",
+}
+
+type configCommander struct {
+	// these need to be set
+	configLoader   func() (*viper.Viper, error)
+	configProblems func(*viper.Viper) []error
+}
+
+func (c *configCommander) GetCommand() *cobra.Command {
+	cmd := cmdConfigTemplate.ToCommand(nil)
+	cmd.AddCommand(cmdConfigValidateTemplate.ToCommand(c.configValidate))
+	return cmd
+}
+
+// configValidate lists all the problems with the configuration
+func (c *configCommander) configValidate(cmd *cobra.Command, args []string) error {
+	config, err := c.configLoader()
+	if err != nil {
+		return err
+	}
+
+	problems := c.configProblems(config)
+	problems = append(problems, directoryProblems(config, "trust_dir")...)
+	if config.GetString("cache_dir") != config.GetString("trust_dir") {
+		problems = append(problems, directoryProblems(config, "cache_dir")...)
+	}
+	problems = append(problems, serverURLProblems(config)...)
+	problems = append(problems, tlsFileProblems(config)...)
+
+	source := config.ConfigFileUsed()
+	if _, err := os.Stat(source); err != nil {
+		source = "the default configuration"
+	}
+	if len(problems) == 0 {
+		cmd.Printf("No problems found with %s\n", source)
+		return nil
+	}
+	cmd.Printf("Problems found with %s:\n", source)
+	for _, problem := range problems {
+		cmd.Printf("  - %s\n", problem)
+	}
+	return fmt.Errorf("the configuration has %d problem(s)", len(problems))
+}
+
+// directoryProblems checks that the directory in a configuration key exists,
+// and that files can be created in it
+func directoryProblems(config *viper.Viper, key string) []error {
+	dir := config.GetString(key)
+	info, err := os.Stat(dir)
+	if os.IsNotExist(err) {
+		return []error{fmt.Errorf("%s %s does not exist: create it, or set %s to an existing directory", key, dir, key)}
+	}
+	if err != nil {
+		return []error{fmt.Errorf("%s %s cannot be read: %v", key, dir, err)}
+	}
+	if !info.IsDir() {
+		return []error{fmt.Errorf("%s %s is not a directory: set %s to a directory", key, dir, key)}
+	}
+	f, err := ioutil.TempFile(dir, ".notary-validate")
+	if err != nil {
+		return []error{fmt.Errorf("%s %s is not writable: %v", key, dir, err)}
+	}
+	f.Close()
+	os.Remove(f.Name())
+	return nil
+}
+
+// serverURLProblems checks that remote_server.url and the URLs in
+// remote_server.gun_urls are absolute http or https URLs
+func serverURLProblems(config *viper.Viper) []error {
+	var problems []error
+	if serverURL := config.GetString("remote_server.url"); serverURL != "" {
+		if err := checkServerURL(serverURL); err != nil {
+			problems = append(problems, fmt.Errorf("invalid remote_server.url: %v", err))
+		}
+	}
+	gunURLs, err := parseGUNServerURLs(config)
+	if err != nil {
+		// already reported with the other values
+		return problems
+	}
+	guns := make([]string, 0, len(gunURLs))
+	for gun := range gunURLs {
+		guns = append(guns, gun)
+	}
+	sort.Strings(guns)
+	for _, gun := range guns {
+		if err := checkServerURL(gunURLs[gun]); err != nil {
+			problems = append(problems, fmt.Errorf("invalid remote_server.gun_urls URL for %s: %v", gun, err))
+		}
+	}
+	return problems
+}
+
+func checkServerURL(serverURL string) error {
+	u, err := url.Parse(serverURL)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return fmt.Errorf("%q must be an http or https URL such as https://notary-server:4443", serverURL)
+	}
+	return nil
+}
+
+// tlsFileProblems checks that the root CA and the TLS client certificate and
+// key in the configuration can be loaded
+func tlsFileProblems(config *viper.Viper) []error {
+	var problems []error
+	if rootCAFile := utils.GetPathRelativeToConfig(config, "remote_server.root_ca"); rootCAFile != "" {
+		if _, err := trustmanager.LoadCertBundleFromFile(rootCAFile); err != nil {
+			problems = append(problems, fmt.Errorf("remote_server.root_ca %s cannot be loaded: %v", rootCAFile, err))
+		}
+	}
+
+	clientCert := utils.GetPathRelativeToConfig(config, "remote_server.tls_client_cert")
+	clientKey := utils.GetPathRelativeToConfig(config, "remote_server.tls_client_key")
+	switch {
+	case clientCert == "" && clientKey == "":
+	case clientCert == "" || clientKey == "":
+		problems = append(problems, fmt.Errorf(
+			"remote_server.tls_client_cert and remote_server.tls_client_key have to be set together, or neither set"))
+	default:
+		if _, err := tls.LoadX509KeyPair(clientCert, clientKey); err != nil {
+			problems = append(problems, fmt.Errorf(
+				"the TLS client certificate %s and key %s cannot be loaded: %v", clientCert, clientKey, err))
+		}
+	}
+	return problems
+}
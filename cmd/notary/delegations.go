@@ -1,9 +1,21 @@
 package main
 
 import (
+	"encoding/csv"
+	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
 
+	"github.com/Sirupsen/logrus"
 	notaryclient "github.com/docker/notary/client"
 	"github.com/docker/notary/passphrase"
 	"github.com/docker/notary/trustmanager"
@@ -22,7 +34,37 @@ var cmdDelegationTemplate = usageTemplate{
 var cmdDelegationListTemplate = usageTemplate{
 	Use:   "list [ GUN ]",
 	Short: "Lists delegations for the Global Unique Name.",
-	Long:  "Lists all delegations known to notary for a specific Global Unique Name.",
+	Long:  "Lists all delegations known to notary for a specific Global Unique Name.  With --verbose, the notes on the paths of the delegations are listed too.",
+}
+
+var cmdDelegationInfoTemplate = usageTemplate{
+	Use:   "info [ GUN ] [ Role ]",
+	Short: "Shows the details of a delegation role.",
+	Long:  "Shows the keys, threshold and paths of a single delegation role in a specific Global Unique Name, and whether a signing key for the role is available locally.",
+}
+
+var cmdDelegationHistoryTemplate = usageTemplate{
+	Use:   "history [ GUN ] [ Role ]",
+	Short: "Shows how a delegation role changed across published versions.",
+	Long:  "Shows how the keys, threshold and paths of a delegation role in a specific Global Unique Name changed across the versions of its parent's metadata that have been downloaded, after downloading the latest version.",
+}
+
+var cmdDelegationCompareTemplate = usageTemplate{
+	Use:   "compare [ GUN 1 ] [ GUN 2 ]",
+	Short: "Compares the delegations of two Global Unique Names.",
+	Long:  "Fetches the delegations of two Global Unique Names, matches the delegation roles by name, and reports the roles that only one of them has and the differences in the keys, thresholds and paths of the others.  Exits with an error if the delegations differ.",
+}
+
+var cmdDelegationGraphTemplate = usageTemplate{
+	Use:   "graph [ GUN ]",
+	Short: "Prints the delegation tree of a Global Unique Name as a graph.",
+	Long:  "Prints the delegation tree of a specific Global Unique Name as a Graphviz DOT graph, with a node for every role and an edge from every role to each of the roles it delegates to, labelled with the threshold and the number of keys of the delegation, so that it can be drawn with for instance `dot -Tpng`.  With --json, the tree is printed as nested JSON objects instead.",
+}
+
+var cmdDelegationCoverageTemplate = usageTemplate{
+	Use:   "coverage [ GUN ] [ Path 1 ] ...",
+	Short: "Shows which delegations can sign each of the given target paths.",
+	Long:  "Shows, for each of the given target paths in a specific Global Unique Name, the delegation roles that can sign a target with that path, taking the paths of the roles they are delegated by into account, and flags the paths that no delegation covers, whose targets can only be signed with the key of the top-level targets role.  With --json, the resolution of every path is printed as JSON.",
 }
 
 var cmdDelegationRemoveTemplate = usageTemplate{
@@ -32,9 +74,33 @@ var cmdDelegationRemoveTemplate = usageTemplate{
 }
 
 var cmdDelegationAddTemplate = usageTemplate{
-	Use:   "add [ GUN ] [ Role ] <X509 file path 1> ...",
+	Use:   "add [ GUN ] [ Role ] <X509 file path or https:// URL 1> ...",
 	Short: "Add a keys to delegation using the provided public key X509 certificates.",
-	Long:  "Add a keys to delegation using the provided public key PEM encoded X509 certificates in a specific Global Unique Name.",
+	Long:  "Add a keys to delegation using the provided public key PEM encoded X509 certificates in a specific Global Unique Name.  A certificate given as an https:// URL is downloaded with the TLS and proxy settings of the trust server, except its client certificate, always verifying the certificate of the server.  A file may also be a bundle of a certificate and the CA certificates that issued it, in which case the key of the certificate that is not a CA is added.  Paths given with --paths or --path-prefix are prefixes: the role can sign every target whose path starts with one of them.  Paths given with --path-exact can only be signed as they are, and are stored as the SHA256 hashes of the paths in the path hash prefixes of the role.  As TUF requires, a role can only have either exact paths or path prefixes, not both.  A path given with --paths or --path-prefix may be given a note on why it is delegated with --path-note, such as `--path-note \"releases/=signed by the release team\"`, which is stored with the delegation for documentation only and listed by `delegation list --verbose`.  If the role already exists, the keys and paths are added to its existing ones, unless --replace is given, in which case the keys and paths of the role are staged to be replaced by exactly the given ones in a single change.  Otherwise keys that the role already has, in the staged changes or in the metadata last downloaded from the server, are skipped unless --allow-duplicate is given.",
+}
+
+var cmdDelegationAddFromCSVTemplate = usageTemplate{
+	Use:   "add-keys-from-csv [ GUN ] [ CSV file ]",
+	Short: "Adds the keys and paths of delegations listed in a CSV file.",
+	Long:  "Adds keys to delegations in a specific Global Unique Name from a CSV file with a row of role, public key X509 certificate and paths for each key, such as `targets/releases,certs/alice.pem,\"releases/,nightly/\"`.  The certificate is a file path, relative to the directory of the CSV file unless it is absolute, or an https:// URL.  The paths are a comma separated list of path prefixes, and may be left empty.  A first row starting with `role` is taken to be a header, and rows starting with # are ignored.  Every row is checked as `delegation add` would check it, and then the valid rows are all staged in the changelist, with a table of the result of each row printed at the end.  By default nothing is staged if any row is invalid, and the rows after the first invalid one are not checked; with --continue-on-error the valid rows are staged anyway.  Either way the command fails if any row could not be staged.  This is an offline operation.",
+}
+
+var cmdDelegationRotateKeyTemplate = usageTemplate{
+	Use:   "rotate-key [ GUN ] [ Role ] [ Old KeyID ] [ New X509 file path ]",
+	Short: "Replaces one key of a delegation with the provided public key X509 certificate.",
+	Long:  "Stages the removal of one key from the specified Role delegation in a specific Global Unique Name and the addition of the provided public key PEM encoded X509 certificate in its place, leaving the other keys, paths and threshold of the delegation untouched.  The certificate is a file path or an https:// URL, and is checked as `delegation add` would check it.",
+}
+
+var cmdDelegationRenameTemplate = usageTemplate{
+	Use:   "rename [ GUN ] [ Old Role ] [ New Role ]",
+	Short: "Renames a delegation role, keeping its keys, threshold, paths and targets.",
+	Long:  "Stages the creation of a new delegation role with the keys, threshold, paths and targets of an existing delegation role in a specific Global Unique Name, and the removal of the existing role, so that both are applied in the next publish.",
+}
+
+var cmdDelegationValidateCertsTemplate = usageTemplate{
+	Use:   "validate-certs [ directory ]",
+	Short: "Checks every public key certificate in a directory.",
+	Long:  "Makes the checks that `delegation add` makes on a public key certificate, that it can be parsed, has not expired, has a large enough key, is of an allowed algorithm and is not on the revocation list, on every .pem file in the directory, and reports the key ID of each certificate or why it was rejected.  Exits with an error if any certificate was rejected, so that a batch of delegate certificates can be checked before adding them.  This is an offline operation.",
 }
 
 type delegationCommander struct {
@@ -42,27 +108,196 @@ type delegationCommander struct {
 	configGetter func() (*viper.Viper, error)
 	retriever    passphrase.Retriever
 
-	paths                         []string
-	allPaths, removeAll, forceYes bool
+	paths, prefixPaths, exactPaths []string
+	allPaths, removeAll, forceYes  bool
+	skipCertValidation, outputJSON bool
+	requireCodeSigning, requireCA  bool
+	failIfEmpty, reverse           bool
+	autoParents, expiryReport      bool
+	replace, thresholdReport       bool
+	allowDuplicate, continueOnErr  bool
+	pathsOnly, namesOnly, noCache  bool
+	compact                        bool
+	sortBy, expires, requireOrg    string
+	pathNotes                      pathNotes
+	parentKeyPaths                 []string
+	depth, limit, offset           int
+	baseVersion                    int
+	output                         outputFile
 }
 
 func (d *delegationCommander) GetCommand() *cobra.Command {
 	cmd := cmdDelegationTemplate.ToCommand(nil)
-	cmd.AddCommand(cmdDelegationListTemplate.ToCommand(d.delegationsList))
+
+	cmdListDelg := cmdDelegationListTemplate.ToCommand(d.delegationsList)
+	cmdListDelg.Flags().IntVar(&d.depth, "depth", -1,
+		"Number of levels of nested delegations to list (0 lists only the delegations of targets, a negative value lists all of them)")
+	cmdListDelg.Flags().BoolVar(&d.failIfEmpty, "fail-if-empty", false,
+		"Exit with an error if the repository has no delegations")
+	cmdListDelg.Flags().StringVar(&d.sortBy, "sort", roleSortName,
+		"Order to list the delegations in: name, keycount, or expiry (earliest key expiry first)")
+	cmdListDelg.Flags().BoolVar(&d.reverse, "reverse", false, "List the delegations in descending order")
+	cmdListDelg.Flags().BoolVar(&d.expiryReport, "expiry-report", false,
+		"Only list how long it is until the soonest key or metadata expiry of each delegation, soonest first")
+	cmdListDelg.Flags().BoolVar(&d.thresholdReport, "threshold-report", false,
+		"Only list how many valid keys each delegation has against its threshold, flagging the delegations that can no longer be signed")
+	cmdListDelg.Flags().BoolVar(&d.pathsOnly, "paths-only", false,
+		"List which delegations govern each delegated path, flagging paths that unrelated delegations can both sign")
+	cmdListDelg.Flags().BoolVar(&d.namesOnly, "names-only", false,
+		"Only print the names of the delegations, one per line, for use in scripts")
+	cmdListDelg.Flags().BoolVar(&d.compact, "compact", false,
+		"Print each delegation on one line, as its name, key count, threshold and earliest expiry separated by tabs")
+	cmdListDelg.Flags().IntVar(&d.limit, "limit", 0,
+		"Maximum number of delegations to list, after sorting (0 lists all of them)")
+	cmdListDelg.Flags().IntVar(&d.offset, "offset", 0,
+		"Number of delegations to skip, after sorting, before listing")
+	cmdListDelg.Flags().BoolVar(&d.outputJSON, "json", false,
+		"Print the delegations, expiry report, threshold report or path coverage as JSON")
+	cmdListDelg.Flags().BoolVar(&d.noCache, "no-cache", false,
+		"Download and verify all the metadata from the server, even if the cached copy is still valid")
+	d.output.addFlags(cmdListDelg)
+	cmd.AddCommand(cmdListDelg)
+
+	cmdInfoDelg := cmdDelegationInfoTemplate.ToCommand(d.delegationInfo)
+	cmdInfoDelg.Flags().BoolVar(&d.outputJSON, "json", false, "Print the delegation details as JSON")
+	cmdInfoDelg.Flags().BoolVar(&d.noCache, "no-cache", false,
+		"Download and verify all the metadata from the server, even if the cached copy is still valid")
+	cmd.AddCommand(cmdInfoDelg)
+
+	cmdHistoryDelg := cmdDelegationHistoryTemplate.ToCommand(d.delegationHistory)
+	cmdHistoryDelg.Flags().BoolVar(&d.outputJSON, "json", false, "Print the changes to the delegation as JSON")
+	cmd.AddCommand(cmdHistoryDelg)
+
+	cmdCompareDelg := cmdDelegationCompareTemplate.ToCommand(d.delegationCompare)
+	cmdCompareDelg.Flags().BoolVar(&d.outputJSON, "json", false, "Print the differences as JSON")
+	cmd.AddCommand(cmdCompareDelg)
+
+	cmdGraphDelg := cmdDelegationGraphTemplate.ToCommand(d.delegationGraph)
+	cmdGraphDelg.Flags().BoolVar(&d.outputJSON, "json", false, "Print the delegation tree as JSON")
+	d.output.addFlags(cmdGraphDelg)
+	cmd.AddCommand(cmdGraphDelg)
+
+	cmdCoverageDelg := cmdDelegationCoverageTemplate.ToCommand(d.delegationCoverage)
+	cmdCoverageDelg.Flags().BoolVar(&d.outputJSON, "json", false, "Print the delegations that can sign each path as JSON")
+	d.output.addFlags(cmdCoverageDelg)
+	cmd.AddCommand(cmdCoverageDelg)
 
 	cmdRemDelg := cmdDelegationRemoveTemplate.ToCommand(d.delegationRemove)
 	cmdRemDelg.Flags().StringSliceVar(&d.paths, "paths", nil, "List of paths to remove")
 	cmdRemDelg.Flags().BoolVarP(&d.forceYes, "yes", "y", false, "Answer yes to the removal question (no confirmation)")
 	cmdRemDelg.Flags().BoolVar(&d.allPaths, "all-paths", false, "Remove all paths from this delegation")
+	d.addBaseVersionFlag(cmdRemDelg)
 	cmd.AddCommand(cmdRemDelg)
 
 	cmdAddDelg := cmdDelegationAddTemplate.ToCommand(d.delegationAdd)
 	cmdAddDelg.Flags().StringSliceVar(&d.paths, "paths", nil, "List of paths to add")
+	cmdAddDelg.Flags().StringSliceVar(&d.prefixPaths, "path-prefix", nil,
+		"List of paths to add that the role can sign every target path starting with, like --paths")
+	cmdAddDelg.Flags().StringSliceVar(&d.exactPaths, "path-exact", nil,
+		"List of target paths to add that the role can sign exactly, and nothing else starting with them")
+	d.pathNotes = pathNotes{}
+	cmdAddDelg.Flags().Var(d.pathNotes, "path-note",
+		"Note on why a path given with --paths or --path-prefix is delegated, as PATH=NOTE.  Can be given once for each path")
 	cmdAddDelg.Flags().BoolVar(&d.allPaths, "all-paths", false, "Add all paths to this delegation")
+	cmdAddDelg.Flags().BoolVar(&d.skipCertValidation, "skip-cert-validation", false,
+		"Do not check the expiry or key size of the public key certificates (DANGEROUS: for recovery and testing only)")
+	cmdAddDelg.Flags().BoolVar(&d.autoParents, "auto-parents", false,
+		"Also create any missing parent delegations, with the same keys as this delegation and no paths")
+	cmdAddDelg.Flags().StringSliceVar(&d.parentKeyPaths, "parent-key", nil,
+		"Public key certificate to create the missing parent delegations with instead (with --auto-parents)")
+	cmdAddDelg.Flags().BoolVar(&d.requireCodeSigning, "require-code-signing", false,
+		"Reject public key certificates whose key usages don't include digital signatures and code signing")
+	cmdAddDelg.Flags().BoolVar(&d.requireCA, "require-ca", false,
+		"Require each public key certificate file to be a bundle containing the CA certificates that issued the certificate")
+	cmdAddDelg.Flags().StringVar(&d.requireOrg, "require-org", "",
+		"Reject public key certificates whose subject organization (O) is not this one")
+	cmdAddDelg.Flags().StringVar(&d.expires, "expires", "",
+		"Date after which the delegation is no longer trusted, as YYYY-MM-DD or an RFC 3339 timestamp")
+	cmdAddDelg.Flags().BoolVar(&d.replace, "replace", false,
+		"Replace all the keys and paths of the role, if it exists, with the given ones instead of adding to them")
+	cmdAddDelg.Flags().BoolVar(&d.allowDuplicate, "allow-duplicate", false,
+		"Add the keys even if the role already has them, as staged or as last downloaded, instead of skipping them")
+	d.addBaseVersionFlag(cmdAddDelg)
 	cmd.AddCommand(cmdAddDelg)
+
+	cmdAddFromCSV := cmdDelegationAddFromCSVTemplate.ToCommand(d.delegationAddFromCSV)
+	cmdAddFromCSV.Flags().BoolVar(&d.continueOnErr, "continue-on-error", false,
+		"Stage the valid rows even if some rows are invalid, instead of staging nothing")
+	cmdAddFromCSV.Flags().BoolVar(&d.requireCodeSigning, "require-code-signing", false,
+		"Reject public key certificates whose key usages don't include digital signatures and code signing")
+	cmdAddFromCSV.Flags().BoolVar(&d.requireCA, "require-ca", false,
+		"Require each public key certificate file to be a bundle containing the CA certificates that issued the certificate")
+	cmdAddFromCSV.Flags().StringVar(&d.requireOrg, "require-org", "",
+		"Reject public key certificates whose subject organization (O) is not this one")
+	d.addBaseVersionFlag(cmdAddFromCSV)
+	cmd.AddCommand(cmdAddFromCSV)
+
+	cmdValidateCerts := cmdDelegationValidateCertsTemplate.ToCommand(d.delegationValidateCerts)
+	cmdValidateCerts.Flags().BoolVar(&d.requireCodeSigning, "require-code-signing", false,
+		"Reject public key certificates whose key usages don't include digital signatures and code signing")
+	cmdValidateCerts.Flags().BoolVar(&d.requireCA, "require-ca", false,
+		"Require each public key certificate file to be a bundle containing the CA certificates that issued the certificate")
+	cmdValidateCerts.Flags().StringVar(&d.requireOrg, "require-org", "",
+		"Reject public key certificates whose subject organization (O) is not this one")
+	cmdValidateCerts.Flags().BoolVar(&d.outputJSON, "json", false, "Print the report as JSON")
+	cmd.AddCommand(cmdValidateCerts)
+
+	cmdRotateKeyDelg := cmdDelegationRotateKeyTemplate.ToCommand(d.delegationRotateKey)
+	cmdRotateKeyDelg.Flags().BoolVar(&d.skipCertValidation, "skip-cert-validation", false,
+		"Do not check the expiry or key size of the public key certificate (DANGEROUS: for recovery and testing only)")
+	cmdRotateKeyDelg.Flags().BoolVar(&d.requireCodeSigning, "require-code-signing", false,
+		"Reject a public key certificate whose key usages don't include digital signatures and code signing")
+	cmdRotateKeyDelg.Flags().BoolVar(&d.requireCA, "require-ca", false,
+		"Require the public key certificate file to be a bundle containing the CA certificates that issued the certificate")
+	cmdRotateKeyDelg.Flags().StringVar(&d.requireOrg, "require-org", "",
+		"Reject a public key certificate whose subject organization (O) is not this one")
+	d.addBaseVersionFlag(cmdRotateKeyDelg)
+	cmd.AddCommand(cmdRotateKeyDelg)
+
+	cmdRenameDelg := cmdDelegationRenameTemplate.ToCommand(d.delegationRename)
+	d.addBaseVersionFlag(cmdRenameDelg)
+	cmd.AddCommand(cmdRenameDelg)
 	return cmd
 }
 
+func (d *delegationCommander) addBaseVersionFlag(cmd *cobra.Command) {
+	cmd.Flags().IntVar(&d.baseVersion, "base-version", 0,
+		"Make the change to this version of the metadata of the parent of the role, as kept in the history of downloaded metadata, "+
+			"instead of to the latest version (EXPERT: for recovery only, publishing overwrites every later change to the delegations of the parent)")
+}
+
+// checkBaseVersion checks the version given with --base-version, if any
+func (d *delegationCommander) checkBaseVersion() error {
+	if d.baseVersion < 0 {
+		return fmt.Errorf("--base-version has to be a version number of 1 or more")
+	}
+	return nil
+}
+
+// stageBaseVersion stages resetting the delegations of the parent of the
+// roles to the ones in the version given with --base-version, if any, so that
+// the changes staged after it are made to that version.  The roles have to
+// have the same parent.  Since publishing then overwrites whatever was changed
+// since that version, this comes with a loud warning.
+func (d *delegationCommander) stageBaseVersion(nRepo *notaryclient.NotaryRepository, roles ...string) error {
+	if d.baseVersion == 0 || len(roles) == 0 {
+		return nil
+	}
+	parent := path.Dir(roles[0])
+	for _, role := range roles[1:] {
+		if path.Dir(role) != parent {
+			return fmt.Errorf("--base-version can only be used for roles with the same parent, and %s and %s have different parents", roles[0], role)
+		}
+	}
+	if err := nRepo.BaseDelegationsOnVersion(parent, d.baseVersion); err != nil {
+		return fmt.Errorf("could not base the change on version %d of %s: %v", d.baseVersion, parent, err)
+	}
+	warnf(os.Stderr, "the delegations of %s are staged to be reset to version %d of its metadata, with this change made to them.  "+
+		"Publishing will OVERWRITE every change to the delegations of %s since version %d, including the ones on the server and any staged before this one.",
+		parent, d.baseVersion, parent, d.baseVersion)
+	return nil
+}
+
 // delegationsList lists all the delegations for a particular GUN
 func (d *delegationCommander) delegationsList(cmd *cobra.Command, args []string) error {
 	if len(args) != 1 {
@@ -70,6 +305,38 @@ func (d *delegationCommander) delegationsList(cmd *cobra.Command, args []string)
 		return fmt.Errorf(
 			"Please provide a Global Unique Name as an argument to list")
 	}
+	if err := checkRoleSort(d.sortBy); err != nil {
+		return err
+	}
+	if (d.expiryReport && d.pathsOnly) || (d.thresholdReport && (d.expiryReport || d.pathsOnly)) {
+		return fmt.Errorf("only one of --expiry-report, --threshold-report and --paths-only can be used")
+	}
+	if d.namesOnly && (d.expiryReport || d.thresholdReport || d.pathsOnly) {
+		return fmt.Errorf("--names-only cannot be used with --expiry-report, --threshold-report or --paths-only")
+	}
+	if d.compact && (d.expiryReport || d.thresholdReport || d.pathsOnly || d.namesOnly || d.outputJSON) {
+		return fmt.Errorf("--compact cannot be used with --expiry-report, --threshold-report, --paths-only, --names-only or --json")
+	}
+	// the expiry report covers every delegation, soonest expiry first, and the
+	// threshold report every delegation by name
+	if d.expiryReport && d.depth >= 0 {
+		return fmt.Errorf("--expiry-report and --depth cannot be used together")
+	}
+	if d.expiryReport && (d.sortBy != roleSortName || d.reverse) {
+		return fmt.Errorf("--expiry-report is always sorted by soonest expiry, and cannot be used with --sort or --reverse")
+	}
+	if d.thresholdReport && (d.depth >= 0 || d.sortBy != roleSortName || d.reverse) {
+		return fmt.Errorf("--threshold-report covers every delegation by name, and cannot be used with --depth, --sort or --reverse")
+	}
+	if d.limit < 0 || d.offset < 0 {
+		return fmt.Errorf("--limit and --offset cannot be negative")
+	}
+	if (d.limit > 0 || d.offset > 0) && (d.expiryReport || d.thresholdReport || d.pathsOnly) {
+		return fmt.Errorf("--limit and --offset cannot be used with --expiry-report, --threshold-report or --paths-only")
+	}
+	if d.outputJSON && d.namesOnly {
+		return fmt.Errorf("--json and --names-only cannot be used together")
+	}
 
 	config, err := d.configGetter()
 	if err != nil {
@@ -84,180 +351,1121 @@ func (d *delegationCommander) delegationsList(cmd *cobra.Command, args []string)
 	}
 
 	// initialize repo with transport to get latest state of the world before listing delegations
-	nRepo, err := notaryclient.NewNotaryRepository(
-		config.GetString("trust_dir"), gun, getRemoteTrustServer(config), rt, d.retriever)
+	nRepo, err := newRepository(config, gun, rt, d.retriever)
 	if err != nil {
 		return err
 	}
+	nRepo.NoCache = d.noCache
+	nRepo.StrictExpiry = config.GetDuration("strict_expiry")
 
-	delegationRoles, err := nRepo.GetDelegationRoles()
+	if d.expiryReport {
+		return d.delegationsExpiryReport(cmd, nRepo, gun)
+	}
+	if d.thresholdReport {
+		return d.delegationsThresholdReport(cmd, nRepo, gun)
+	}
+
+	delegationRoles, err := nRepo.GetDelegationRolesToDepth(d.depth)
 	if err != nil {
 		return fmt.Errorf("Error retrieving delegation roles for repository %s: %v", gun, err)
 	}
 
-	cmd.Println("")
-	prettyPrintRoles(delegationRoles, cmd.Out(), "delegations")
-	cmd.Println("")
+	if d.pathsOnly {
+		return d.delegationsPathCoverage(cmd, delegationRoles, gun)
+	}
+
+	// the key details are only needed for the key types and expiry sorting, so
+	// the roles are still listed if they can't be retrieved
+	details, err := nRepo.ListDelegationDetails()
+	if err != nil {
+		if d.sortBy == roleSortExpiry || d.compact {
+			return fmt.Errorf("Error retrieving delegation keys for repository %s: %v", gun, err)
+		}
+		logrus.Debugf("Unable to retrieve delegation key details for %s: %v", gun, err)
+	}
+	if err := sortRoles(delegationRoles, d.sortBy, d.reverse, delegationExpiries(details)); err != nil {
+		return err
+	}
+	// the roles are sorted by name when the sort keys are equal, so a page is
+	// the same across calls as long as the delegations don't change
+	total := len(delegationRoles)
+	page := paginateRoles(delegationRoles, d.offset, d.limit)
+
+	out, closeOutput, err := d.output.open(cmd)
+	if err != nil {
+		return err
+	}
+	if d.outputJSON {
+		listJSON, err := json.MarshalIndent(delegationListPage{
+			Total:       total,
+			Offset:      d.offset,
+			Delegations: page,
+		}, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(out, string(listJSON))
+	} else if d.namesOnly {
+		for _, role := range page {
+			fmt.Fprintln(out, role.Name)
+		}
+	} else if d.compact {
+		prettyPrintRolesCompact(page, earliestDelegationExpiries(details), out)
+	} else {
+		fmt.Fprintln(out, "")
+		if len(page) > 0 || total == 0 {
+			prettyPrintRolesInOrder(page, delegationKeyTypes(details), out, "delegations")
+		}
+		// --verbose is the global flag, which also raises the logging level
+		if verbose, _ := cmd.Flags().GetBool("verbose"); verbose {
+			prettyPrintPathNotes(page, out)
+		}
+		if d.limit > 0 || d.offset > 0 {
+			fmt.Fprintln(out, describePage(d.offset, len(page), total))
+		}
+		fmt.Fprintln(out, "")
+	}
+	if err := closeOutput(); err != nil {
+		return err
+	}
+
+	if d.failIfEmpty && len(delegationRoles) == 0 {
+		return fmt.Errorf("No delegations found for repository %s", gun)
+	}
 	return nil
 }
 
-// delegationRemove removes a public key from a specific role in a GUN
-func (d *delegationCommander) delegationRemove(cmd *cobra.Command, args []string) error {
-	if len(args) < 2 {
-		cmd.Usage()
-		return fmt.Errorf("must specify the Global Unique Name and the role of the delegation along with optional keyIDs and/or a list of paths to remove")
+// delegationListPage is one page of the delegations of a repository, as
+// printed by delegation list --json
+type delegationListPage struct {
+	Total       int          `json:"total"`
+	Offset      int          `json:"offset"`
+	Delegations []*data.Role `json:"delegations"`
+}
+
+// paginateRoles returns the roles after skipping offset of them, and at most
+// limit of them unless limit is 0
+func paginateRoles(roles []*data.Role, offset, limit int) []*data.Role {
+	if offset >= len(roles) {
+		return []*data.Role{}
+	}
+	roles = roles[offset:]
+	if limit > 0 && limit < len(roles) {
+		roles = roles[:limit]
 	}
+	return roles
+}
 
-	config, err := d.configGetter()
+// describePage describes which of the delegations a page lists, such as
+// "Showing delegations 11-20 of 45"
+func describePage(offset, count, total int) string {
+	if count == 0 {
+		return fmt.Sprintf("No delegations at offset %d, of %d in total", offset, total)
+	}
+	return fmt.Sprintf("Showing delegations %d-%d of %d", offset+1, offset+count, total)
+}
+
+// delegationsExpiryReport lists how long it is until the soonest expiry of
+// each of the delegations of a repository
+func (d *delegationCommander) delegationsExpiryReport(cmd *cobra.Command, nRepo *notaryclient.NotaryRepository, gun string) error {
+	details, err := nRepo.ListDelegationDetails()
 	if err != nil {
-		return err
+		return fmt.Errorf("Error retrieving delegation roles for repository %s: %v", gun, err)
 	}
+	report := newExpiryReport(details, time.Now())
 
-	gun := args[0]
-	role := args[1]
+	out, closeOutput, err := d.output.open(cmd)
+	if err != nil {
+		return err
+	}
+	if d.outputJSON {
+		jsonBytes, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			closeOutput()
+			return err
+		}
+		fmt.Fprintln(out, string(jsonBytes))
+	} else {
+		fmt.Fprintln(out, "")
+		prettyPrintExpiryReport(report, len(details) > 0, out)
+		fmt.Fprintln(out, "")
+	}
+	if err := closeOutput(); err != nil {
+		return err
+	}
 
-	// Check if role is valid delegation name before requiring any user input
-	if !data.IsDelegation(role) {
-		return fmt.Errorf("invalid delegation name %s", role)
+	if d.failIfEmpty && len(details) == 0 {
+		return fmt.Errorf("No delegations found for repository %s", gun)
 	}
+	return nil
+}
 
-	// If we're only given the gun and the role, attempt to remove all data for this delegation
-	if len(args) == 2 && d.paths == nil && !d.allPaths {
-		d.removeAll = true
+// roleExpiry is when the soonest expiry of the keys or metadata of a role is
+type roleExpiry struct {
+	Role             string    `json:"role"`
+	Expiry           time.Time `json:"expiry"`
+	ExpiresInSeconds int64     `json:"expires_in_seconds"`
+}
+
+// newExpiryReport finds the soonest key or metadata expiry of each delegation,
+// and how long from now it is (negative if it has already passed), soonest
+// first.  Delegations with no keys that expire and no published metadata are
+// left out.
+func newExpiryReport(details []notaryclient.DelegationDetail, now time.Time) []roleExpiry {
+	report := make([]roleExpiry, 0, len(details))
+	for _, detail := range details {
+		soonest := soonestExpiry(detail)
+		if soonest == nil {
+			continue
+		}
+		report = append(report, roleExpiry{
+			Role:             detail.Name,
+			Expiry:           *soonest,
+			ExpiresInSeconds: int64(soonest.Sub(now) / time.Second),
+		})
 	}
+	sort.Sort(roleExpirySorter(report))
+	return report
+}
 
-	keyIDs := []string{}
+// soonestExpiry returns the soonest expiry of the keys and metadata of a
+// delegation, if any of them expire
+func soonestExpiry(detail notaryclient.DelegationDetail) *time.Time {
+	soonest := detail.Expires
+	for _, key := range detail.Keys {
+		if key.Expiry != nil && (soonest == nil || key.Expiry.Before(*soonest)) {
+			soonest = key.Expiry
+		}
+	}
+	return soonest
+}
 
-	if len(args) > 2 {
-		keyIDs = args[2:]
+// earliestDelegationExpiries returns, for each delegation that expires, the
+// earliest of the expiries of its keys and metadata and of the delegation
+// itself
+func earliestDelegationExpiries(details []notaryclient.DelegationDetail) map[string]time.Time {
+	expiries := make(map[string]time.Time)
+	for _, detail := range details {
+		earliest := soonestExpiry(detail)
+		if detail.ValidUntil != nil && (earliest == nil || detail.ValidUntil.Before(*earliest)) {
+			earliest = detail.ValidUntil
+		}
+		if earliest != nil {
+			expiries[detail.Name] = *earliest
+		}
 	}
+	return expiries
+}
 
-	// If the user passes --all-paths, don't use any of the passed in --paths
-	if d.allPaths {
-		d.paths = nil
+// delegationsThresholdReport lists how many valid keys each of the delegations
+// of a repository has against its threshold, and fails if any of them can no
+// longer be signed
+func (d *delegationCommander) delegationsThresholdReport(cmd *cobra.Command, nRepo *notaryclient.NotaryRepository, gun string) error {
+	details, err := nRepo.ListDelegationDetails()
+	if err != nil {
+		return fmt.Errorf("Error retrieving delegation roles for repository %s: %v", gun, err)
 	}
+	report := newThresholdReport(details, time.Now())
 
-	// no online operations are performed by add so the transport argument
-	// should be nil
-	nRepo, err := notaryclient.NewNotaryRepository(
-		config.GetString("trust_dir"), gun, getRemoteTrustServer(config), nil, d.retriever)
+	out, closeOutput, err := d.output.open(cmd)
 	if err != nil {
 		return err
 	}
-
-	if d.removeAll {
-		cmd.Println("\nAre you sure you want to remove all data for this delegation? (yes/no)")
-		// Ask for confirmation before force removing delegation
-		if !d.forceYes {
-			confirmed := askConfirm()
-			if !confirmed {
-				fatalf("Aborting action.")
-			}
-		} else {
-			cmd.Println("Confirmed `yes` from flag")
-		}
-		// Delete the entire delegation
-		err = nRepo.RemoveDelegationRole(role)
+	if d.outputJSON {
+		jsonBytes, err := json.MarshalIndent(report, "", "  ")
 		if err != nil {
-			return fmt.Errorf("failed to remove delegation: %v", err)
+			closeOutput()
+			return err
 		}
+		fmt.Fprintln(out, string(jsonBytes))
 	} else {
-		if d.allPaths {
-			err = nRepo.ClearDelegationPaths(role)
-			if err != nil {
-				return fmt.Errorf("failed to remove delegation: %v", err)
+		fmt.Fprintln(out, "")
+		prettyPrintThresholdReport(report, out)
+		fmt.Fprintln(out, "")
+	}
+	if err := closeOutput(); err != nil {
+		return err
+	}
+
+	if d.failIfEmpty && len(details) == 0 {
+		return fmt.Errorf("No delegations found for repository %s", gun)
+	}
+	var belowThreshold []string
+	for _, r := range report {
+		if r.BelowThreshold {
+			belowThreshold = append(belowThreshold, r.Role)
+		}
+	}
+	if len(belowThreshold) > 0 {
+		return fmt.Errorf("%d delegation(s) of %s have fewer valid keys than their threshold: %s",
+			len(belowThreshold), gun, strings.Join(belowThreshold, ", "))
+	}
+	return nil
+}
+
+// roleThreshold is how many of the keys of a role are valid, against the
+// number of signatures the role needs
+type roleThreshold struct {
+	Role           string `json:"role"`
+	Threshold      int    `json:"threshold"`
+	Keys           int    `json:"keys"`
+	ValidKeys      int    `json:"valid_keys"`
+	BelowThreshold bool   `json:"below_threshold"`
+}
+
+// newThresholdReport counts the valid keys of each delegation, sorted by name.
+// A key is valid if it is known and, if it is a certificate, has not expired,
+// and a delegation that has fewer valid keys than its threshold is flagged as
+// below it, since it can no longer be signed.
+func newThresholdReport(details []notaryclient.DelegationDetail, now time.Time) []roleThreshold {
+	report := make([]roleThreshold, 0, len(details))
+	for _, detail := range details {
+		r := roleThreshold{Role: detail.Name, Threshold: detail.Threshold, Keys: len(detail.Keys)}
+		for _, key := range detail.Keys {
+			if key.PublicKey != nil && (key.Expiry == nil || key.Expiry.After(now)) {
+				r.ValidKeys++
 			}
 		}
-		// Remove any keys or paths that we passed in
-		err = nRepo.RemoveDelegationKeysAndPaths(role, keyIDs, d.paths)
+		r.BelowThreshold = r.ValidKeys < r.Threshold
+		report = append(report, r)
+	}
+	sort.Sort(roleThresholdSorter(report))
+	return report
+}
+
+// delegationsPathCoverage lists which of the given delegations govern each
+// delegated path
+func (d *delegationCommander) delegationsPathCoverage(cmd *cobra.Command, delegationRoles []*data.Role, gun string) error {
+	coverage := newPathCoverage(delegationRoles)
+
+	out, closeOutput, err := d.output.open(cmd)
+	if err != nil {
+		return err
+	}
+	if d.outputJSON {
+		jsonBytes, err := json.MarshalIndent(coverage, "", "  ")
 		if err != nil {
-			return fmt.Errorf("failed to remove delegation: %v", err)
+			closeOutput()
+			return err
 		}
+		fmt.Fprintln(out, string(jsonBytes))
+	} else {
+		fmt.Fprintln(out, "")
+		prettyPrintPathCoverage(coverage, out)
+		fmt.Fprintln(out, "")
+	}
+	if err := closeOutput(); err != nil {
+		return err
 	}
 
-	cmd.Println("")
-	if d.removeAll {
-		cmd.Printf("Forced removal (including all keys and paths) of delegation role %s to repository \"%s\" staged for next publish.\n", role, gun)
-	} else {
-		removingItems := ""
-		if len(keyIDs) > 0 {
-			removingItems = removingItems + fmt.Sprintf("with keys %s, ", keyIDs)
+	if d.failIfEmpty && len(delegationRoles) == 0 {
+		return fmt.Errorf("No delegations found for repository %s", gun)
+	}
+	return nil
+}
+
+// pathCoverage is which delegations list a path, and which other delegations
+// can also sign some of the targets under it
+type pathCoverage struct {
+	Path     string   `json:"path"`
+	Roles    []string `json:"roles"`
+	Overlaps []string `json:"overlaps,omitempty"`
+}
+
+// newPathCoverage maps each delegated path to the delegations that list it,
+// sorted by path.  Paths are prefixes, so a path overlaps with every path
+// that it is a prefix of or that is a prefix of it.  A delegation having a
+// path that overlaps with a path of one of its ancestors or descendants is
+// the normal narrowing of a delegation, but when unrelated delegations have
+// overlapping paths, either of them can sign the targets under both, so
+// those delegations are listed as overlaps of the path.
+func newPathCoverage(roles []*data.Role) []pathCoverage {
+	owners := make(map[string][]string)
+	for _, r := range roles {
+		for _, p := range r.Paths {
+			owners[p] = append(owners[p], r.Name)
 		}
-		if d.allPaths {
-			removingItems = removingItems + "with all paths, "
+	}
+
+	coverage := make([]pathCoverage, 0, len(owners))
+	for p, pathRoles := range owners {
+		// every delegation that can sign some target under this path
+		var signers []string
+		for other, otherRoles := range owners {
+			if strings.HasPrefix(p, other) || strings.HasPrefix(other, p) {
+				signers = append(signers, otherRoles...)
+			}
 		}
-		if d.paths != nil {
-			removingItems = removingItems + fmt.Sprintf("with paths [%s], ", prettyPrintPaths(d.paths))
+
+		overlaps := make(map[string]bool)
+		for _, a := range signers {
+			for _, b := range signers {
+				if a != b && !relatedRoles(a, b) {
+					overlaps[a] = true
+				}
+			}
+		}
+		pc := pathCoverage{Path: p, Roles: pathRoles}
+		for r := range overlaps {
+			pc.Overlaps = append(pc.Overlaps, r)
 		}
-		cmd.Printf("Removal of delegation role %s %sto repository \"%s\" staged for next publish.\n", role, removingItems, gun)
+		sort.Strings(pc.Roles)
+		sort.Strings(pc.Overlaps)
+		coverage = append(coverage, pc)
 	}
-	cmd.Println("")
+	sort.Sort(pathCoverageSorter(coverage))
+	return coverage
+}
 
-	return nil
+// pathSigners is which delegations can sign the targets with a path.  If none
+// can, only the targets role can sign them.
+type pathSigners struct {
+	Path        string   `json:"path"`
+	Roles       []string `json:"roles"`
+	TargetsOnly bool     `json:"targets_only"`
 }
 
-// delegationAdd creates a new delegation by adding a public key from a certificate to a specific role in a GUN
-func (d *delegationCommander) delegationAdd(cmd *cobra.Command, args []string) error {
-	// We must have at least the gun and role name, and at least one key or path (or the --all-paths flag) to add
-	if len(args) < 2 || len(args) < 3 && d.paths == nil && !d.allPaths {
-		cmd.Usage()
-		return fmt.Errorf("must specify the Global Unique Name and the role of the delegation along with the public key certificate paths and/or a list of paths to add")
+// newPathSigners finds the delegations that can sign a target with each of the
+// paths.  A delegation can only sign a target if its own paths and the paths
+// of every delegation between it and the targets role cover the target.
+func newPathSigners(roles []*data.Role, paths []string) []pathSigners {
+	byName := make(map[string]*data.Role, len(roles))
+	for _, r := range roles {
+		byName[r.Name] = r
+	}
+	canSign := func(r *data.Role, targetPath string) bool {
+		for name := r.Name; name != data.CanonicalTargetsRole; name = path.Dir(name) {
+			ancestor, ok := byName[name]
+			if !ok || !ancestor.CheckPaths(targetPath) {
+				return false
+			}
+		}
+		return true
 	}
 
-	config, err := d.configGetter()
-	if err != nil {
-		return err
+	signers := make([]pathSigners, 0, len(paths))
+	for _, targetPath := range paths {
+		ps := pathSigners{Path: targetPath, Roles: []string{}}
+		for _, r := range roles {
+			if canSign(r, targetPath) {
+				ps.Roles = append(ps.Roles, r.Name)
+			}
+		}
+		sort.Strings(ps.Roles)
+		ps.TargetsOnly = len(ps.Roles) == 0
+		signers = append(signers, ps)
 	}
+	return signers
+}
 
-	gun := args[0]
-	role := args[1]
+// relatedRoles returns whether one of two roles is delegated, directly or
+// not, by the other
+func relatedRoles(a, b string) bool {
+	return strings.HasPrefix(a, b+"/") || strings.HasPrefix(b, a+"/")
+}
 
-	pubKeys := []data.PublicKey{}
-	if len(args) > 2 {
-		pubKeyPaths := args[2:]
-		for _, pubKeyPath := range pubKeyPaths {
-			// Read public key bytes from PEM file
-			pubKeyBytes, err := ioutil.ReadFile(pubKeyPath)
-			if err != nil {
-				return fmt.Errorf("unable to read public key from file: %s", pubKeyPath)
+// delegationExpiries returns the earliest expiry of the keys of each of the
+// delegations, for those that have keys that expire
+func delegationExpiries(details []notaryclient.DelegationDetail) map[string]time.Time {
+	expiries := make(map[string]time.Time)
+	for _, detail := range details {
+		for _, key := range detail.Keys {
+			if key.Expiry == nil {
+				continue
 			}
-
-			// Parse PEM bytes into type PublicKey
-			pubKey, err := trustmanager.ParsePEMPublicKey(pubKeyBytes)
-			if err != nil {
-				return fmt.Errorf("unable to parse valid public key certificate from PEM file %s: %v", pubKeyPath, err)
+			if earliest, ok := expiries[detail.Name]; !ok || key.Expiry.Before(earliest) {
+				expiries[detail.Name] = *key.Expiry
 			}
-			pubKeys = append(pubKeys, pubKey)
 		}
 	}
+	return expiries
+}
 
-	for _, path := range d.paths {
-		if path == "" {
-			d.allPaths = true
-			break
+// delegationKeyTypes returns the type of each of the keys of the delegations
+// whose type is known, by canonical key ID
+func delegationKeyTypes(details []notaryclient.DelegationDetail) map[string]string {
+	keyTypes := make(map[string]string)
+	for _, detail := range details {
+		for _, key := range detail.Keys {
+			if key.KeyType != "" {
+				keyTypes[key.ID] = key.KeyType
+			}
 		}
 	}
+	return keyTypes
+}
 
-	// If the user passes --all-paths (or gave the "" path in --paths), give the "" path
-	if d.allPaths {
-		d.paths = []string{""}
+// delegationCoverage shows which delegations can sign targets with each of the
+// given paths for a particular GUN
+func (d *delegationCommander) delegationCoverage(cmd *cobra.Command, args []string) error {
+	if len(args) < 2 {
+		cmd.Usage()
+		return fmt.Errorf(
+			"Please provide a Global Unique Name and at least one target path as arguments to coverage")
 	}
 
-	// no online operations are performed by add so the transport argument
-	// should be nil
-	nRepo, err := notaryclient.NewNotaryRepository(
-		config.GetString("trust_dir"), gun, getRemoteTrustServer(config), nil, d.retriever)
+	config, err := d.configGetter()
 	if err != nil {
 		return err
 	}
 
-	// Add the delegation to the repository
-	err = nRepo.AddDelegation(role, pubKeys, d.paths)
+	gun := args[0]
+
+	rt, err := getTransport(config, gun, true)
 	if err != nil {
-		return fmt.Errorf("failed to create delegation: %v", err)
+		return err
+	}
+	nRepo, err := newRepository(config, gun, rt, d.retriever)
+	if err != nil {
+		return err
 	}
 
-	// Make keyID slice for better CLI print
+	delegationRoles, err := nRepo.GetDelegationRoles()
+	if err != nil {
+		return fmt.Errorf("Error retrieving delegation roles for repository %s: %v", gun, err)
+	}
+	signers := newPathSigners(delegationRoles, args[1:])
+
+	out, closeOutput, err := d.output.open(cmd)
+	if err != nil {
+		return err
+	}
+	if d.outputJSON {
+		signersJSON, err := json.MarshalIndent(signers, "", "  ")
+		if err != nil {
+			closeOutput()
+			return err
+		}
+		fmt.Fprintln(out, string(signersJSON))
+	} else {
+		fmt.Fprintln(out, "")
+		prettyPrintPathSigners(signers, out)
+		fmt.Fprintln(out, "")
+	}
+	return closeOutput()
+}
+
+// delegationInfo shows the details of a single delegation role for a particular GUN
+func (d *delegationCommander) delegationInfo(cmd *cobra.Command, args []string) error {
+	if len(args) != 2 {
+		cmd.Usage()
+		return fmt.Errorf(
+			"Please provide a Global Unique Name and a delegation role as arguments to info")
+	}
+
+	config, err := d.configGetter()
+	if err != nil {
+		return err
+	}
+
+	gun := args[0]
+	role := args[1]
+
+	rt, err := getTransport(config, gun, true)
+	if err != nil {
+		return err
+	}
+
+	// initialize repo with transport to get latest state of the world before showing the delegation
+	nRepo, err := newRepository(config, gun, rt, d.retriever)
+	if err != nil {
+		return err
+	}
+	nRepo.NoCache = d.noCache
+	nRepo.StrictExpiry = config.GetDuration("strict_expiry")
+
+	info, err := nRepo.GetDelegationDetail(role)
+	if err != nil {
+		if _, ok := err.(data.ErrNoSuchRole); ok {
+			return fmt.Errorf("Delegation role %s not found in repository %s", role, gun)
+		}
+		return fmt.Errorf("Error retrieving delegation role %s for repository %s: %v", role, gun, err)
+	}
+	if d.outputJSON {
+		infoJSON, err := json.MarshalIndent(info, "", "  ")
+		if err != nil {
+			return err
+		}
+		cmd.Println(string(infoJSON))
+		return nil
+	}
+
+	cmd.Println("")
+	prettyPrintDelegationInfo(info, cmd.Out())
+	cmd.Println("")
+	return nil
+}
+
+// delegationHistory prints how a delegation role changed across the versions
+// of its parent's metadata that have been downloaded
+func (d *delegationCommander) delegationHistory(cmd *cobra.Command, args []string) error {
+	if len(args) != 2 {
+		cmd.Usage()
+		return fmt.Errorf(
+			"Please provide a Global Unique Name and a delegation role as arguments to history")
+	}
+
+	config, err := d.configGetter()
+	if err != nil {
+		return err
+	}
+
+	gun := args[0]
+	role := args[1]
+
+	rt, err := getTransport(config, gun, true)
+	if err != nil {
+		return err
+	}
+
+	// initialize repo with transport to record the latest version before showing the history
+	nRepo, err := newRepository(config, gun, rt, d.retriever)
+	if err != nil {
+		return err
+	}
+
+	changes, err := nRepo.DelegationHistory(role)
+	if err != nil {
+		if _, ok := err.(data.ErrNoSuchRole); ok {
+			return fmt.Errorf("Delegation role %s not found in repository %s", role, gun)
+		}
+		return fmt.Errorf("Error retrieving the history of delegation role %s for repository %s: %v", role, gun, err)
+	}
+	if d.outputJSON {
+		if changes == nil {
+			changes = []notaryclient.DelegationChange{}
+		}
+		changesJSON, err := json.MarshalIndent(changes, "", "  ")
+		if err != nil {
+			return err
+		}
+		cmd.Println(string(changesJSON))
+		return nil
+	}
+
+	cmd.Println("")
+	prettyPrintDelegationHistory(role, changes, cmd.Out())
+	cmd.Println("")
+	return nil
+}
+
+// delegationCompare reports the differences between the delegations of two GUNs
+func (d *delegationCommander) delegationCompare(cmd *cobra.Command, args []string) error {
+	if len(args) != 2 {
+		cmd.Usage()
+		return fmt.Errorf(
+			"Please provide two Global Unique Names as arguments to compare")
+	}
+
+	config, err := d.configGetter()
+	if err != nil {
+		return err
+	}
+
+	var delegations [2][]*data.Role
+	for i, gun := range args {
+		rt, err := getTransport(config, gun, true)
+		if err != nil {
+			return err
+		}
+		nRepo, err := newRepository(config, gun, rt, d.retriever)
+		if err != nil {
+			return err
+		}
+
+		delegations[i], err = nRepo.GetDelegationRoles()
+		if err != nil {
+			return fmt.Errorf("Error retrieving delegation roles for repository %s: %v", gun, err)
+		}
+	}
+
+	diffs := notaryclient.CompareDelegations(delegations[0], delegations[1])
+	if d.outputJSON {
+		if diffs == nil {
+			diffs = []notaryclient.DelegationDifference{}
+		}
+		diffsJSON, err := json.MarshalIndent(diffs, "", "  ")
+		if err != nil {
+			return err
+		}
+		cmd.Println(string(diffsJSON))
+	} else {
+		cmd.Println("")
+		prettyPrintDelegationDifferences(args[0], args[1], diffs, cmd.Out())
+		cmd.Println("")
+	}
+
+	if len(diffs) > 0 {
+		return fmt.Errorf("The delegations of %s and %s differ", args[0], args[1])
+	}
+	return nil
+}
+
+// delegationGraph prints the delegation tree of a GUN as a DOT graph or JSON
+func (d *delegationCommander) delegationGraph(cmd *cobra.Command, args []string) error {
+	if len(args) != 1 {
+		cmd.Usage()
+		return fmt.Errorf(
+			"Please provide a Global Unique Name as an argument to graph")
+	}
+
+	config, err := d.configGetter()
+	if err != nil {
+		return err
+	}
+
+	gun := args[0]
+
+	rt, err := getTransport(config, gun, true)
+	if err != nil {
+		return err
+	}
+	nRepo, err := newRepository(config, gun, rt, d.retriever)
+	if err != nil {
+		return err
+	}
+
+	delegationRoles, err := nRepo.GetDelegationRoles()
+	if err != nil {
+		return fmt.Errorf("Error retrieving delegation roles for repository %s: %v", gun, err)
+	}
+	tree := newDelegationTree(delegationRoles)
+
+	out, closeOutput, err := d.output.open(cmd)
+	if err != nil {
+		return err
+	}
+	if d.outputJSON {
+		treeJSON, err := json.MarshalIndent(tree, "", "  ")
+		if err != nil {
+			closeOutput()
+			return err
+		}
+		fmt.Fprintln(out, string(treeJSON))
+	} else {
+		printDelegationGraph(gun, tree, out)
+	}
+	return closeOutput()
+}
+
+// delegationNode is a role in the delegation tree, with the roles it delegates
+// to.  The targets role at the top of the tree has no threshold or keys, since
+// those are given by the root rather than by a delegation.
+type delegationNode struct {
+	Name      string            `json:"name"`
+	Threshold int               `json:"threshold,omitempty"`
+	KeyIDs    []string          `json:"key_ids,omitempty"`
+	Paths     []string          `json:"paths,omitempty"`
+	Children  []*delegationNode `json:"children"`
+}
+
+// newDelegationTree arranges the delegation roles into a tree below the
+// targets role, with the children of every role sorted by name
+func newDelegationTree(roles []*data.Role) *delegationNode {
+	sorted := make([]*data.Role, len(roles))
+	copy(sorted, roles)
+	// a role sorts after its parent, which is a prefix of its name
+	sort.Sort(roleSorter(sorted))
+
+	top := &delegationNode{Name: data.CanonicalTargetsRole, Children: []*delegationNode{}}
+	nodes := map[string]*delegationNode{top.Name: top}
+	for _, role := range sorted {
+		node := &delegationNode{
+			Name:      role.Name,
+			Threshold: role.Threshold,
+			KeyIDs:    role.KeyIDs,
+			Paths:     role.Paths,
+			Children:  []*delegationNode{},
+		}
+		nodes[role.Name] = node
+
+		parent, ok := nodes[path.Dir(role.Name)]
+		for ancestor := path.Dir(role.Name); !ok && ancestor != "."; ancestor = path.Dir(ancestor) {
+			parent, ok = nodes[ancestor]
+		}
+		if !ok {
+			parent = top
+		}
+		parent.Children = append(parent.Children, node)
+	}
+	return top
+}
+
+// delegationRotateKey stages replacing one key of a delegation role in a particular GUN
+func (d *delegationCommander) delegationRotateKey(cmd *cobra.Command, args []string) error {
+	if len(args) != 4 {
+		cmd.Usage()
+		return fmt.Errorf("must specify the Global Unique Name, the role of the delegation, the key ID to replace, and the public key certificate path of the new key")
+	}
+	if err := d.checkBaseVersion(); err != nil {
+		return err
+	}
+
+	config, err := d.configGetter()
+	if err != nil {
+		return err
+	}
+
+	gun := args[0]
+	role := args[1]
+	oldKeyID := args[2]
+
+	if d.skipCertValidation {
+		warnSkipCertValidation(os.Stderr)
+	}
+	newPubKey, err := readPubKey(config, args[3], d.certParser())
+	if err != nil {
+		return err
+	}
+	newKeyID, err := utils.CanonicalKeyID(newPubKey)
+	if err != nil {
+		return err
+	}
+	if newKeyID == oldKeyID {
+		return fmt.Errorf("the new key %s is the same as the key being replaced", newKeyID)
+	}
+
+	// the latest state of the repository is needed to check that the key being
+	// replaced belongs to the delegation
+	rt, err := getTransport(config, gun, true)
+	if err != nil {
+		return err
+	}
+	nRepo, err := newRepository(config, gun, rt, d.retriever)
+	if err != nil {
+		return err
+	}
+
+	if err := d.stageBaseVersion(nRepo, role); err != nil {
+		return err
+	}
+	if err := nRepo.RotateDelegationKey(role, oldKeyID, newPubKey); err != nil {
+		return fmt.Errorf("failed to rotate delegation key: %v", err)
+	}
+
+	cmd.Println("")
+	cmd.Printf(
+		"Replacement of key %s with key %s in delegation role %s of repository \"%s\" staged for next publish.\n",
+		oldKeyID, newKeyID, role, gun)
+	cmd.Println("")
+	return nil
+}
+
+// delegationRename stages renaming a delegation role in a particular GUN
+func (d *delegationCommander) delegationRename(cmd *cobra.Command, args []string) error {
+	if len(args) != 3 {
+		cmd.Usage()
+		return fmt.Errorf("must specify the Global Unique Name, the role of the delegation to rename, and its new name")
+	}
+	if err := d.checkBaseVersion(); err != nil {
+		return err
+	}
+
+	config, err := d.configGetter()
+	if err != nil {
+		return err
+	}
+
+	gun := args[0]
+	oldRole := args[1]
+	newRole := args[2]
+
+	rt, err := getTransport(config, gun, true)
+	if err != nil {
+		return err
+	}
+
+	// initialize repo with transport to get the current keys, paths and targets
+	// of the delegation being renamed
+	nRepo, err := newRepository(config, gun, rt, d.retriever)
+	if err != nil {
+		return err
+	}
+
+	if err := d.stageBaseVersion(nRepo, oldRole, newRole); err != nil {
+		return err
+	}
+	if err := nRepo.RenameDelegation(oldRole, newRole); err != nil {
+		return fmt.Errorf("failed to rename delegation: %v", err)
+	}
+
+	cmd.Println("")
+	cmd.Printf(
+		"Renaming of delegation role %s to %s staged for next publish.\n",
+		oldRole, newRole)
+	cmd.Println("")
+	return nil
+}
+
+// delegationRemove removes a public key from a specific role in a GUN
+func (d *delegationCommander) delegationRemove(cmd *cobra.Command, args []string) error {
+	if len(args) < 2 {
+		cmd.Usage()
+		return fmt.Errorf("must specify the Global Unique Name and the role of the delegation along with optional keyIDs and/or a list of paths to remove")
+	}
+	if err := d.checkBaseVersion(); err != nil {
+		return err
+	}
+
+	config, err := d.configGetter()
+	if err != nil {
+		return err
+	}
+
+	gun := args[0]
+	role := args[1]
+
+	// Check if role is valid delegation name before requiring any user input
+	if !data.IsDelegation(role) {
+		return fmt.Errorf("invalid delegation name %s", role)
+	}
+
+	// If we're only given the gun and the role, attempt to remove all data for this delegation
+	if len(args) == 2 && d.paths == nil && !d.allPaths {
+		d.removeAll = true
+	}
+
+	keyIDs := []string{}
+
+	if len(args) > 2 {
+		keyIDs = args[2:]
+	}
+
+	// If the user passes --all-paths, don't use any of the passed in --paths
+	if d.allPaths {
+		d.paths = nil
+	}
+
+	// no online operations are performed by add so the transport argument
+	// should be nil
+	nRepo, err := newRepository(config, gun, nil, d.retriever)
+	if err != nil {
+		return err
+	}
+
+	if d.removeAll {
+		cmd.Println("\nAre you sure you want to remove all data for this delegation? (yes/no)")
+		// Ask for confirmation before force removing delegation
+		if !d.forceYes {
+			if err := checkPromptAllowed(config); err != nil {
+				return err
+			}
+			confirmed := askConfirm()
+			if !confirmed {
+				fatalf("Aborting action.")
+			}
+		} else {
+			cmd.Println("Confirmed `yes` from flag")
+		}
+		if err := d.stageBaseVersion(nRepo, role); err != nil {
+			return err
+		}
+		// Delete the entire delegation
+		err = nRepo.RemoveDelegationRole(role)
+		if err != nil {
+			return fmt.Errorf("failed to remove delegation: %v", err)
+		}
+	} else {
+		if err := d.stageBaseVersion(nRepo, role); err != nil {
+			return err
+		}
+		if d.allPaths {
+			err = nRepo.ClearDelegationPaths(role)
+			if err != nil {
+				return fmt.Errorf("failed to remove delegation: %v", err)
+			}
+		}
+		// Remove any keys or paths that we passed in
+		err = nRepo.RemoveDelegationKeysAndPaths(role, keyIDs, d.paths)
+		if err != nil {
+			return fmt.Errorf("failed to remove delegation: %v", err)
+		}
+	}
+
+	cmd.Println("")
+	change := stagedChange{GUN: gun, Role: role, KeyIDs: keyIDs, Paths: d.paths, AllPaths: d.allPaths}
+	if d.removeAll {
+		printStagedChange(cmd, config, msgDelegationRemoveAll, change)
+	} else {
+		if len(keyIDs) > 0 {
+			change.Items = change.Items + fmt.Sprintf("with keys %s, ", keyIDs)
+		}
+		if d.allPaths {
+			change.Items = change.Items + "with all paths, "
+		}
+		if d.paths != nil {
+			change.Items = change.Items + fmt.Sprintf("with paths [%s], ", prettyPrintPaths(d.paths))
+		}
+		printStagedChange(cmd, config, msgDelegationRemove, change)
+	}
+	cmd.Println("")
+
+	return nil
+}
+
+// warnSkipCertValidation warns that the certificates given to delegation add
+// will not be validated
+func warnSkipCertValidation(warnings io.Writer) {
+	warnf(warnings, "--skip-cert-validation was given, so the expiry and key size of the")
+	warnf(warnings, "provided certificates will NOT be checked.  Clients may refuse to")
+	warnf(warnings, "trust content signed by this delegation.")
+}
+
+// delegationAdd creates a new delegation by adding a public key from a certificate to a specific role in a GUN
+func (d *delegationCommander) delegationAdd(cmd *cobra.Command, args []string) error {
+	// We must have at least the gun and role name, and at least one key or path (or the --all-paths flag) to add
+	d.paths = append(d.paths, d.prefixPaths...)
+	if len(args) < 2 || len(args) < 3 && d.paths == nil && d.exactPaths == nil && !d.allPaths && d.expires == "" {
+		cmd.Usage()
+		return fmt.Errorf("must specify the Global Unique Name and the role of the delegation along with the public key certificate paths and/or a list of paths to add")
+	}
+
+	if d.replace && len(args) < 3 {
+		cmd.Usage()
+		return fmt.Errorf("must specify the public key certificate paths of the delegation with --replace")
+	}
+	if err := d.checkBaseVersion(); err != nil {
+		return err
+	}
+	if d.replace && d.autoParents {
+		return fmt.Errorf("--replace cannot be used with --auto-parents")
+	}
+	if len(d.exactPaths) > 0 && (len(d.paths) > 0 || d.allPaths) {
+		return fmt.Errorf("--path-exact cannot be used with --paths, --path-prefix or --all-paths: a delegation role can only have either exact paths or path prefixes")
+	}
+	for _, path := range d.exactPaths {
+		if path == "" {
+			return fmt.Errorf("an exact path cannot be empty: use --all-paths to let the role sign every path")
+		}
+	}
+	if err := d.pathNotes.checkPaths(d.paths); err != nil {
+		return err
+	}
+
+	var validUntil time.Time
+	if d.expires != "" {
+		var err error
+		if validUntil, err = parseDelegationExpiry(d.expires); err != nil {
+			return err
+		}
+	}
+
+	config, err := d.configGetter()
+	if err != nil {
+		return err
+	}
+
+	gun := args[0]
+	role := args[1]
+
+	if d.skipCertValidation {
+		warnSkipCertValidation(os.Stderr)
+	}
+	parsePubKey := d.certParser()
+
+	pubKeys := []data.PublicKey{}
+	if len(args) > 2 {
+		pubKeyPaths := args[2:]
+		for _, pubKeyPath := range pubKeyPaths {
+			pubKey, err := readPubKey(config, pubKeyPath, parsePubKey)
+			if err != nil {
+				return err
+			}
+			pubKeys = append(pubKeys, pubKey)
+		}
+	}
+
+	for _, path := range d.paths {
+		if path == "" {
+			d.allPaths = true
+			break
+		}
+	}
+
+	// If the user passes --all-paths (or gave the "" path in --paths), give the "" path
+	if d.allPaths {
+		d.paths = []string{""}
+	}
+
+	if len(d.parentKeyPaths) > 0 && !d.autoParents {
+		return fmt.Errorf("--parent-key can only be used with --auto-parents")
+	}
+	parentKeys := pubKeys
+	if len(d.parentKeyPaths) > 0 {
+		parentKeys = []data.PublicKey{}
+		for _, pubKeyPath := range d.parentKeyPaths {
+			pubKey, err := readPubKey(config, pubKeyPath, parsePubKey)
+			if err != nil {
+				return err
+			}
+			parentKeys = append(parentKeys, pubKey)
+		}
+	}
+
+	// no online operations are performed by add so the transport argument
+	// should be nil, unless the latest state of the repository is needed to
+	// find out which parent delegations are missing
+	var rt http.RoundTripper
+	if d.autoParents {
+		if rt, err = getTransport(config, gun, true); err != nil {
+			return err
+		}
+	}
+	nRepo, err := newRepository(config, gun, rt, d.retriever)
+	if err != nil {
+		return err
+	}
+
+	// the keys are all replaced anyway with --replace
+	if !d.allowDuplicate && !d.replace && len(pubKeys) > 0 {
+		// an out of date cache shouldn't stop keys from being added offline
+		existing, err := nRepo.ExistingDelegationKeys(role, pubKeys)
+		if err != nil {
+			warnf(os.Stderr, "could not check which keys delegation role %s already has: %v", role, err)
+		}
+		if pubKeys, err = skipExistingKeys(cmd, role, pubKeys, existing); err != nil {
+			return err
+		}
+		if len(pubKeys) == 0 && d.paths == nil && d.exactPaths == nil && d.expires == "" {
+			cmd.Printf("\nNothing to add to delegation role %s in repository \"%s\".\n\n", role, gun)
+			return nil
+		}
+	}
+
+	if err := d.stageBaseVersion(nRepo, role); err != nil {
+		return err
+	}
+
+	// Add the delegation to the repository
+	var parents []string
+	switch {
+	case d.replace:
+		err = nRepo.ReplaceDelegation(role, pubKeys, d.paths)
+	case d.autoParents:
+		parents, err = nRepo.AddDelegationWithParents(role, pubKeys, parentKeys, d.paths)
+	default:
+		err = nRepo.AddDelegation(role, pubKeys, d.paths)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create delegation: %v", err)
+	}
+	if len(d.exactPaths) > 0 {
+		if err := nRepo.AddDelegationExactPaths(role, d.exactPaths); err != nil {
+			return fmt.Errorf("failed to add the exact paths of the delegation: %v", err)
+		}
+	}
+	if len(d.pathNotes) > 0 {
+		if err := nRepo.AddDelegationPathNotes(role, d.pathNotes); err != nil {
+			return fmt.Errorf("failed to add the notes on the paths of the delegation: %v", err)
+		}
+	}
+	if d.expires != "" {
+		if err := nRepo.SetDelegationExpiry(role, validUntil); err != nil {
+			return fmt.Errorf("failed to set the expiry of the delegation: %v", err)
+		}
+	}
+
+	// Make keyID slice for better CLI print
 	pubKeyIDs := []string{}
 	for _, pubKey := range pubKeys {
 		pubKeyID, err := utils.CanonicalKeyID(pubKey)
@@ -268,16 +1476,560 @@ func (d *delegationCommander) delegationAdd(cmd *cobra.Command, args []string) e
 	}
 
 	cmd.Println("")
-	addingItems := ""
+	change := stagedChange{GUN: gun, Role: role, KeyIDs: pubKeyIDs, Paths: d.paths, AllPaths: d.allPaths, ExactPaths: d.exactPaths}
 	if len(pubKeyIDs) > 0 {
-		addingItems = addingItems + fmt.Sprintf("with keys %s, ", pubKeys)
+		change.Items = change.Items + fmt.Sprintf("with keys %s, ", pubKeyIDs)
 	}
 	if d.paths != nil || d.allPaths {
-		addingItems = addingItems + fmt.Sprintf("with paths [%s], ", prettyPrintPaths(d.paths))
+		change.Items = change.Items + fmt.Sprintf("with paths [%s], ", prettyPrintPaths(d.paths))
+	}
+	if d.exactPaths != nil {
+		change.Items = change.Items + fmt.Sprintf("with exact paths [%s], ", strings.Join(d.exactPaths, ","))
+	}
+	if len(d.pathNotes) > 0 {
+		change.Items = change.Items + fmt.Sprintf("with notes on %d paths, ", len(d.pathNotes))
+	}
+	if d.expires != "" {
+		change.Expires = validUntil.UTC().Format(time.RFC3339)
+		change.Items = change.Items + fmt.Sprintf("expiring on %s, ", change.Expires)
+	}
+	for _, parent := range parents {
+		printStagedChange(cmd, config, msgDelegationAddParent, stagedChange{GUN: gun, Role: parent})
+	}
+	if d.replace {
+		printStagedChange(cmd, config, msgDelegationReplace, change)
+	} else {
+		printStagedChange(cmd, config, msgDelegationAdd, change)
 	}
-	cmd.Printf(
-		"Addition of delegation role %s %sto repository \"%s\" staged for next publish.\n",
-		role, addingItems, gun)
 	cmd.Println("")
 	return nil
 }
+
+// The statuses of the rows of a CSV file given to delegation add-keys-from-csv
+// that are not in error
+const (
+	csvRowStaged    = "staged"
+	csvRowNotStaged = "valid, not staged"
+)
+
+// csvDelegationRow is a row of a CSV file given to delegation
+// add-keys-from-csv, and the result of adding it
+type csvDelegationRow struct {
+	Row         int
+	Role        string
+	Certificate string
+	KeyID       string
+	Status      string
+
+	pubKey data.PublicKey
+	paths  []string
+}
+
+// delegationAddFromCSV stages the addition of the keys and paths listed in a
+// CSV file to delegations in a GUN, reporting the result of each row
+func (d *delegationCommander) delegationAddFromCSV(cmd *cobra.Command, args []string) error {
+	if len(args) != 2 {
+		cmd.Usage()
+		return fmt.Errorf("must specify the Global Unique Name and the CSV file of the delegations to add")
+	}
+	if err := d.checkBaseVersion(); err != nil {
+		return err
+	}
+	config, err := d.configGetter()
+	if err != nil {
+		return err
+	}
+	gun := args[0]
+	csvPath := args[1]
+
+	rows, err := readDelegationCSV(csvPath)
+	if err != nil {
+		return err
+	}
+	if len(rows) == 0 {
+		return fmt.Errorf("no delegations found in %s", csvPath)
+	}
+
+	// no online operations are performed, so the transport is nil
+	nRepo, err := newRepository(config, gun, nil, d.retriever)
+	if err != nil {
+		return err
+	}
+	parsePubKey := d.certParser()
+	failed := 0
+	for i := range rows {
+		if err := checkDelegationCSVRow(config, &rows[i], filepath.Dir(csvPath), parsePubKey, nRepo.AllowedAlgorithms, nRepo.RevokedKeys); err != nil {
+			rows[i].Status = err.Error()
+			failed++
+			if !d.continueOnErr {
+				rows = rows[:i+1]
+				break
+			}
+		}
+	}
+
+	if failed == 0 || d.continueOnErr {
+		var roles []string
+		for _, row := range rows {
+			if row.pubKey != nil {
+				roles = append(roles, row.Role)
+			}
+		}
+		if err := d.stageBaseVersion(nRepo, roles...); err != nil {
+			return err
+		}
+
+		for i, row := range rows {
+			if row.pubKey == nil {
+				continue
+			}
+			if err := nRepo.AddDelegation(row.Role, []data.PublicKey{row.pubKey}, row.paths); err != nil {
+				rows[i].Status = fmt.Sprintf("failed to create delegation: %v", err)
+				failed++
+				continue
+			}
+			rows[i].Status = csvRowStaged
+		}
+	}
+
+	prettyPrintCSVDelegationRows(rows, cmd.Out())
+	if failed > 0 {
+		return fmt.Errorf("%d of the rows of %s could not be staged", failed, csvPath)
+	}
+	cmd.Printf("\nAddition of %d keys to delegations in repository \"%s\" staged for next publish.\n\n", len(rows), gun)
+	return nil
+}
+
+// readDelegationCSV reads the rows of a CSV file of delegations, skipping a
+// header, without checking them
+func readDelegationCSV(csvPath string) ([]csvDelegationRow, error) {
+	f, err := os.Open(csvPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	reader := csv.NewReader(f)
+	reader.Comment = '#'
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	var rows []csvDelegationRow
+	for rowNum := 1; ; rowNum++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("unable to read %s: %v", csvPath, err)
+		}
+		if rowNum == 1 && strings.EqualFold(strings.TrimSpace(record[0]), "role") {
+			continue
+		}
+		if len(record) < 2 || len(record) > 3 {
+			return nil, fmt.Errorf("row %d of %s has %d fields: every row has to have a role, a certificate and optionally paths", rowNum, csvPath, len(record))
+		}
+		row := csvDelegationRow{
+			Row:         rowNum,
+			Role:        strings.TrimSpace(record[0]),
+			Certificate: strings.TrimSpace(record[1]),
+		}
+		if len(record) == 3 {
+			for _, path := range strings.Split(record[2], ",") {
+				if path = strings.TrimSpace(path); path != "" {
+					row.paths = append(row.paths, path)
+				}
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// checkDelegationCSVRow checks the role of a row of a CSV file of delegations,
+// and reads and checks its certificate as delegation add would, including that
+// its key has not been revoked
+func checkDelegationCSVRow(config *viper.Viper, row *csvDelegationRow, baseDir string,
+	parsePubKey func([]byte) (data.PublicKey, error), allowedAlgorithms, revoked []string) error {
+
+	if !data.IsDelegation(row.Role) {
+		return data.ErrInvalidRole{Role: row.Role, Reason: "not a delegation role"}
+	}
+	location := row.Certificate
+	if location == "" {
+		return fmt.Errorf("no certificate given")
+	}
+	if !strings.Contains(location, "://") && !filepath.IsAbs(location) {
+		location = filepath.Join(baseDir, location)
+	}
+	pubKey, err := readPubKey(config, location, parsePubKey)
+	if err != nil {
+		return err
+	}
+	if row.KeyID, err = utils.CanonicalKeyID(pubKey); err != nil {
+		return err
+	}
+	if err := notaryclient.CheckAllowedAlgorithms(allowedAlgorithms, row.Role, pubKey); err != nil {
+		return err
+	}
+	if err := notaryclient.CheckRevokedKeys(revoked, row.Role, pubKey); err != nil {
+		return err
+	}
+	row.pubKey = pubKey
+	row.Status = csvRowNotStaged
+	return nil
+}
+
+// skipExistingKeys returns the keys that are not among the existing ones, given
+// by canonical ID, saying which keys are skipped
+func skipExistingKeys(cmd *cobra.Command, role string, pubKeys []data.PublicKey, existing []string) ([]data.PublicKey, error) {
+	newKeys := make([]data.PublicKey, 0, len(pubKeys))
+	for _, pubKey := range pubKeys {
+		keyID, err := utils.CanonicalKeyID(pubKey)
+		if err != nil {
+			return nil, err
+		}
+		if utils.StrSliceContains(existing, keyID) {
+			cmd.Printf("Key %s is already a key of delegation role %s, skipping it (use --allow-duplicate to add it anyway).\n", keyID, role)
+			continue
+		}
+		newKeys = append(newKeys, pubKey)
+	}
+	return newKeys, nil
+}
+
+// parseDelegationExpiry parses the expiry of a delegation given on the command
+// line, either as a date (the delegation expires at the start of that day, in
+// UTC) or as an RFC 3339 timestamp.  The expiry has to be in the future.
+func parseDelegationExpiry(expires string) (time.Time, error) {
+	validUntil, err := time.Parse("2006-01-02", expires)
+	if err != nil {
+		if validUntil, err = time.Parse(time.RFC3339, expires); err != nil {
+			return time.Time{}, fmt.Errorf(
+				"invalid delegation expiry %s: must be a date (YYYY-MM-DD) or an RFC 3339 timestamp", expires)
+		}
+	}
+	if !validUntil.After(time.Now()) {
+		return time.Time{}, fmt.Errorf("invalid delegation expiry %s: must be in the future", expires)
+	}
+	return validUntil, nil
+}
+
+// pathNotes are the notes on why paths are delegated, given with --path-note
+// as PATH=NOTE once for each path.  A path is split from its note at the first
+// =, so that paths containing @ or any other character but = can be noted.
+type pathNotes map[string]string
+
+func (n pathNotes) String() string {
+	if len(n) == 0 {
+		return ""
+	}
+	notes := make([]string, 0, len(n))
+	for p, note := range n {
+		notes = append(notes, p+"="+note)
+	}
+	sort.Strings(notes)
+	return strings.Join(notes, ",")
+}
+
+func (n pathNotes) Set(value string) error {
+	parts := strings.SplitN(value, "=", 2)
+	if len(parts) != 2 {
+		return fmt.Errorf("a path note has to be given as PATH=NOTE, not %q", value)
+	}
+	path, note := parts[0], strings.TrimSpace(parts[1])
+	if path == "" {
+		// the empty path delegates every path, which --all-paths is for
+		return fmt.Errorf("the path of the note %q cannot be empty", value)
+	}
+	if note == "" {
+		return fmt.Errorf("the note on the path %s cannot be empty", path)
+	}
+	if _, ok := n[path]; ok {
+		return fmt.Errorf("the path %s is given more than one note", path)
+	}
+	n[path] = note
+	return nil
+}
+
+func (n pathNotes) Type() string {
+	return "PATH=NOTE"
+}
+
+// checkPaths checks that every noted path is one of the paths being added, so
+// that no note is staged for a path the role is not delegated
+func (n pathNotes) checkPaths(paths []string) error {
+	for p := range n {
+		found := false
+		for _, path := range paths {
+			if path == p {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("the path %s has a note but is not given with --paths or --path-prefix", p)
+		}
+	}
+	return nil
+}
+
+// certParser returns the function parsing public key certificates with the
+// checks asked for on the command line
+func (d *delegationCommander) certParser() func([]byte) (data.PublicKey, error) {
+	parsePubKey := trustmanager.ParsePEMPublicKey
+	if d.skipCertValidation {
+		parsePubKey = trustmanager.ParsePEMPublicKeyWithoutValidation
+	}
+	if d.requireCodeSigning {
+		parsePubKey = requireCodeSigning(parsePubKey)
+	}
+	if d.requireCA {
+		parsePubKey = requireCAChain(parsePubKey)
+	}
+	if d.requireOrg != "" {
+		parsePubKey = requireOrganization(parsePubKey, d.requireOrg)
+	}
+	return parsePubKey
+}
+
+// certValidation is the result of checking one public key certificate file
+type certValidation struct {
+	File  string `json:"file"`
+	KeyID string `json:"key_id,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// delegationValidateCerts checks every public key certificate in a directory
+// as `delegation add` would, and reports the result for each of them
+func (d *delegationCommander) delegationValidateCerts(cmd *cobra.Command, args []string) error {
+	if len(args) != 1 {
+		cmd.Usage()
+		return fmt.Errorf("must specify the directory of the public key certificates")
+	}
+	config, err := d.configGetter()
+	if err != nil {
+		return err
+	}
+
+	dir := args[0]
+	certPaths, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return err
+	}
+	if len(certPaths) == 0 {
+		return fmt.Errorf("no .pem files found in %s", dir)
+	}
+	sort.Strings(certPaths)
+
+	parsePubKey := d.certParser()
+	allowedAlgorithms := config.GetStringSlice("allowed_algorithms")
+	revoked, err := revokedKeys(config)
+	if err != nil {
+		return err
+	}
+	results := make([]certValidation, 0, len(certPaths))
+	failed := 0
+	for _, certPath := range certPaths {
+		result := certValidation{File: filepath.Base(certPath)}
+		keyID, err := validateCertFile(certPath, parsePubKey, allowedAlgorithms, revoked)
+		if err != nil {
+			result.Error = err.Error()
+			failed++
+		}
+		result.KeyID = keyID
+		results = append(results, result)
+	}
+
+	if d.outputJSON {
+		jsonBytes, err := json.MarshalIndent(results, "", "  ")
+		if err != nil {
+			return err
+		}
+		cmd.Println(string(jsonBytes))
+	} else {
+		prettyPrintCertValidations(results, cmd.Out())
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of the %d certificates in %s are invalid", failed, len(results), dir)
+	}
+	return nil
+}
+
+// validateCertFile checks a public key certificate file as `delegation add`
+// would, including that its key has not been revoked, and returns the
+// canonical ID of its key, if it could be parsed
+func validateCertFile(certPath string, parsePubKey func([]byte) (data.PublicKey, error),
+	allowedAlgorithms, revoked []string) (string, error) {
+	pubKey, err := readPubKeyFile(certPath, parsePubKey)
+	if err != nil {
+		return "", err
+	}
+	keyID, err := utils.CanonicalKeyID(pubKey)
+	if err != nil {
+		return "", err
+	}
+	if err := notaryclient.CheckAllowedAlgorithms(allowedAlgorithms, filepath.Base(certPath), pubKey); err != nil {
+		return keyID, err
+	}
+	if err := notaryclient.CheckRevokedKeys(revoked, filepath.Base(certPath), pubKey); err != nil {
+		return keyID, err
+	}
+	return keyID, nil
+}
+
+// requireCodeSigning wraps a function parsing public key certificates so that
+// certificates which are not meant for code signing are rejected as well
+func requireCodeSigning(parsePubKey func([]byte) (data.PublicKey, error)) func([]byte) (data.PublicKey, error) {
+	return func(pubKeyBytes []byte) (data.PublicKey, error) {
+		pubKey, err := parsePubKey(pubKeyBytes)
+		if err != nil {
+			return nil, err
+		}
+		cert, err := trustmanager.LoadCertFromPEM(pubKey.Public())
+		if err != nil {
+			return nil, err
+		}
+		if err := trustmanager.ValidateCodeSigningUsage(cert); err != nil {
+			return nil, err
+		}
+		return pubKey, nil
+	}
+}
+
+// requireCAChain wraps a function parsing public key certificates so that the
+// leaf certificate of a bundle has to chain to the CA certificates bundled with it
+func requireCAChain(parsePubKey func([]byte) (data.PublicKey, error)) func([]byte) (data.PublicKey, error) {
+	return func(pubKeyBytes []byte) (data.PublicKey, error) {
+		pubKey, err := parsePubKey(pubKeyBytes)
+		if err != nil {
+			return nil, err
+		}
+		leaf, caCerts, err := trustmanager.LoadLeafCertFromPEMBundle(pubKeyBytes)
+		if err != nil {
+			return nil, err
+		}
+		if err := trustmanager.ValidateCertChain(leaf, caCerts); err != nil {
+			return nil, err
+		}
+		return pubKey, nil
+	}
+}
+
+// requireOrganization wraps a function parsing public key certificates so that
+// certificates whose subject does not have the given organization are
+// rejected as well
+func requireOrganization(parsePubKey func([]byte) (data.PublicKey, error), org string) func([]byte) (data.PublicKey, error) {
+	return func(pubKeyBytes []byte) (data.PublicKey, error) {
+		pubKey, err := parsePubKey(pubKeyBytes)
+		if err != nil {
+			return nil, err
+		}
+		cert, err := trustmanager.LoadCertFromPEM(pubKey.Public())
+		if err != nil {
+			return nil, err
+		}
+		for _, certOrg := range cert.Subject.Organization {
+			if certOrg == org {
+				return pubKey, nil
+			}
+		}
+		if len(cert.Subject.Organization) == 0 {
+			return nil, fmt.Errorf("the certificate for %s has no subject organization, and %q is required", cert.Subject.CommonName, org)
+		}
+		return nil, fmt.Errorf("the certificate for %s has the subject organization %q, not the required %q",
+			cert.Subject.CommonName, strings.Join(cert.Subject.Organization, ", "), org)
+	}
+}
+
+// maxCertDownloadSize is the most that is downloaded for a public key
+// certificate given by URL
+const maxCertDownloadSize = 1 << 20
+
+// readPubKey reads a PEM encoded public key certificate from a file, or from
+// an https:// URL, and parses it with the provided function
+func readPubKey(config *viper.Viper, location string, parsePubKey func([]byte) (data.PublicKey, error)) (data.PublicKey, error) {
+	if !strings.Contains(location, "://") {
+		return readPubKeyFile(location, parsePubKey)
+	}
+	pubKeyBytes, err := downloadHTTPS(config, location, "certificate", maxCertDownloadSize)
+	if err != nil {
+		return nil, err
+	}
+	pubKey, err := parsePubKey(pubKeyBytes)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse valid public key certificate from %s: %v", location, err)
+	}
+	return pubKey, nil
+}
+
+// downloadHTTPS downloads a file of at most maxSize bytes, a certificate or
+// whatever else what says it is, over HTTPS with the TLS and proxy settings of
+// the remote trust server, apart from its client certificate, which is only
+// for the trust server.  The certificate of the server is always verified,
+// even if verification of the trust server is skipped, and redirects to other
+// than https:// URLs are not followed.
+func downloadHTTPS(config *viper.Viper, fileURL, what string, maxSize int64) ([]byte, error) {
+	checkHTTPS := func(u *url.URL) error {
+		if u.Scheme != "https" {
+			return fmt.Errorf("%ss can only be downloaded from https:// URLs, not %s", what, u)
+		}
+		return nil
+	}
+	u, err := url.Parse(fileURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s URL %s: %v", what, fileURL, err)
+	}
+	if err := checkHTTPS(u); err != nil {
+		return nil, err
+	}
+
+	base, dialer, timeout, err := newBaseTransport(config, false)
+	if err != nil {
+		return nil, err
+	}
+	base.TLSClientConfig.Certificates = nil
+	var rt http.RoundTripper = base
+	if timeout > 0 {
+		rt = timeoutTransport{base: base, timeout: timeout, dialer: dialer}
+	}
+	client := &http.Client{
+		Transport: rt,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return checkHTTPS(req.URL)
+		},
+	}
+	resp, err := client.Get(u.String())
+	if err != nil {
+		return nil, fmt.Errorf("unable to download %s from %s: %v", what, fileURL, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unable to download %s from %s: %s", what, fileURL, resp.Status)
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("unable to download %s from %s: %v", what, fileURL, err)
+	}
+	if int64(len(body)) > maxSize {
+		return nil, fmt.Errorf("the %s at %s is larger than %d bytes", what, fileURL, maxSize)
+	}
+	return body, nil
+}
+
+// readPubKeyFile reads a PEM encoded public key certificate from a file, and
+// parses it with the provided function
+func readPubKeyFile(pubKeyPath string, parsePubKey func([]byte) (data.PublicKey, error)) (data.PublicKey, error) {
+	// Read public key bytes from PEM file
+	pubKeyBytes, err := ioutil.ReadFile(pubKeyPath)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read public key from file: %s", pubKeyPath)
+	}
+
+	// Parse PEM bytes into type PublicKey
+	pubKey, err := parsePubKey(pubKeyBytes)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse valid public key certificate from PEM file %s: %v", pubKeyPath, err)
+	}
+	return pubKey, nil
+}
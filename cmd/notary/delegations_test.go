@@ -1,36 +1,38 @@
 package main
 
 import (
+	"bytes"
 	"crypto/rand"
+	"crypto/tls"
 	"crypto/x509"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/docker/notary/cryptoservice"
 	"github.com/docker/notary/trustmanager"
+	"github.com/docker/notary/tuf/utils"
 	"github.com/spf13/viper"
 	"github.com/stretchr/testify/assert"
 )
 
-var testTrustDir = "trust_dir"
-
-func setup() *delegationCommander {
+// setup returns a delegationCommander using a new temporary trust directory,
+// which the caller has to remove with os.RemoveAll
+func setup(t *testing.T) (*delegationCommander, string) {
+	trustDir, err := ioutil.TempDir("", "notary-test-trust-")
+	assert.NoError(t, err)
 	return &delegationCommander{
-		configGetter: func() (*viper.Viper, error) {
-			mainViper := viper.New()
-			mainViper.Set("trust_dir", testTrustDir)
-			return mainViper, nil
-		},
-		retriever: nil,
-	}
+		configGetter: WithTrustDir(trustDir),
+		retriever:    nil,
+	}, trustDir
 }
 
 func TestAddInvalidDelegationName(t *testing.T) {
-	// Cleanup after test
-	defer os.RemoveAll(testTrustDir)
-
 	// Setup certificate
 	tempFile, err := ioutil.TempFile("/tmp", "pemfile")
 	assert.NoError(t, err)
@@ -41,7 +43,8 @@ func TestAddInvalidDelegationName(t *testing.T) {
 	defer os.Remove(tempFile.Name())
 
 	// Setup commander
-	commander := setup()
+	commander, trustDir := setup(t)
+	defer os.RemoveAll(trustDir)
 
 	// Should error due to invalid delegation name (should be prefixed by "targets/")
 	err = commander.delegationAdd(commander.GetCommand(), []string{"gun", "INVALID_NAME", tempFile.Name()})
@@ -49,9 +52,6 @@ func TestAddInvalidDelegationName(t *testing.T) {
 }
 
 func TestAddInvalidDelegationCert(t *testing.T) {
-	// Cleanup after test
-	defer os.RemoveAll(testTrustDir)
-
 	// Setup certificate
 	tempFile, err := ioutil.TempFile("/tmp", "pemfile")
 	assert.NoError(t, err)
@@ -62,7 +62,8 @@ func TestAddInvalidDelegationCert(t *testing.T) {
 	defer os.Remove(tempFile.Name())
 
 	// Setup commander
-	commander := setup()
+	commander, trustDir := setup(t)
+	defer os.RemoveAll(trustDir)
 
 	// Should error due to expired cert
 	err = commander.delegationAdd(commander.GetCommand(), []string{"gun", "targets/delegation", tempFile.Name(), "--paths", "path"})
@@ -70,9 +71,6 @@ func TestAddInvalidDelegationCert(t *testing.T) {
 }
 
 func TestAddInvalidShortPubkeyCert(t *testing.T) {
-	// Cleanup after test
-	defer os.RemoveAll(testTrustDir)
-
 	// Setup certificate
 	tempFile, err := ioutil.TempFile("/tmp", "pemfile")
 	assert.NoError(t, err)
@@ -83,19 +81,125 @@ func TestAddInvalidShortPubkeyCert(t *testing.T) {
 	defer os.Remove(tempFile.Name())
 
 	// Setup commander
-	commander := setup()
+	commander, trustDir := setup(t)
+	defer os.RemoveAll(trustDir)
 
 	// Should error due to short RSA key
 	err = commander.delegationAdd(commander.GetCommand(), []string{"gun", "targets/delegation", tempFile.Name(), "--paths", "path"})
 	assert.Error(t, err)
 }
 
-func TestRemoveInvalidDelegationName(t *testing.T) {
-	// Cleanup after test
-	defer os.RemoveAll(testTrustDir)
+func TestAddParentKeyWithoutAutoParents(t *testing.T) {
+	// Setup certificate
+	tempFile, err := ioutil.TempFile("/tmp", "pemfile")
+	assert.NoError(t, err)
+	cert, _, err := generateValidTestCert()
+	_, err = tempFile.Write(trustmanager.CertToPEM(cert))
+	assert.NoError(t, err)
+	tempFile.Close()
+	defer os.Remove(tempFile.Name())
+
+	// Setup commander
+	commander, trustDir := setup(t)
+	defer os.RemoveAll(trustDir)
+	cmd := commander.GetCommand()
+	commander.parentKeyPaths = []string{tempFile.Name()}
+
+	// Should error due to --parent-key being given without --auto-parents
+	err = commander.delegationAdd(cmd, []string{"gun", "targets/a/b", tempFile.Name()})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "--auto-parents")
+}
+
+func TestRotateKeyInvalidDelegationCert(t *testing.T) {
+	// Setup certificate
+	tempFile, err := ioutil.TempFile("/tmp", "pemfile")
+	assert.NoError(t, err)
+	cert, _, err := generateExpiredTestCert()
+	_, err = tempFile.Write(trustmanager.CertToPEM(cert))
+	assert.NoError(t, err)
+	tempFile.Close()
+	defer os.Remove(tempFile.Name())
+
+	// Setup commander
+	commander, trustDir := setup(t)
+	defer os.RemoveAll(trustDir)
+
+	// Should error due to expired cert
+	err = commander.delegationRotateKey(commander.GetCommand(), []string{"gun", "targets/delegation", "fake_key_id", tempFile.Name()})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "unable to parse valid public key certificate")
+}
+
+func TestRotateKeySameKey(t *testing.T) {
+	// Setup certificate
+	tempFile, err := ioutil.TempFile("/tmp", "pemfile")
+	assert.NoError(t, err)
+	cert, _, err := generateValidTestCert()
+	_, err = tempFile.Write(trustmanager.CertToPEM(cert))
+	assert.NoError(t, err)
+	tempFile.Close()
+	defer os.Remove(tempFile.Name())
+
+	keyID, err := utils.CanonicalKeyID(trustmanager.CertToKey(cert))
+	assert.NoError(t, err)
 
 	// Setup commander
-	commander := setup()
+	commander, trustDir := setup(t)
+	defer os.RemoveAll(trustDir)
+
+	// Should error because the new key is the one being replaced
+	err = commander.delegationRotateKey(commander.GetCommand(), []string{"gun", "targets/delegation", keyID, tempFile.Name()})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "is the same as the key being replaced")
+}
+
+// The new certificate of rotate-key is checked with the same flags as the ones
+// of delegation add
+func TestRotateKeyCertChecks(t *testing.T) {
+	tempFile, err := ioutil.TempFile("/tmp", "pemfile")
+	assert.NoError(t, err)
+	cert, _, err := generateExpiredTestCert()
+	assert.NoError(t, err)
+	_, err = tempFile.Write(trustmanager.CertToPEM(cert))
+	assert.NoError(t, err)
+	tempFile.Close()
+	defer os.Remove(tempFile.Name())
+
+	keyID, err := utils.CanonicalKeyID(trustmanager.CertToKey(cert))
+	assert.NoError(t, err)
+
+	commander, trustDir := setup(t)
+	defer os.RemoveAll(trustDir)
+
+	cmd := commander.GetCommand()
+
+	// the expired certificate is only accepted without validation
+	commander.skipCertValidation = true
+	err = commander.delegationRotateKey(cmd, []string{"gun", "targets/delegation", keyID, tempFile.Name()})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "is the same as the key being replaced")
+
+	commander.requireOrg = "Docker"
+	err = commander.delegationRotateKey(cmd, []string{"gun", "targets/delegation", keyID, tempFile.Name()})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), `"Docker"`)
+}
+
+func TestRotateKeyInvalidNumArgs(t *testing.T) {
+	// Setup commander
+	commander, trustDir := setup(t)
+	defer os.RemoveAll(trustDir)
+
+	// Should error due to invalid number of args (3 instead of 4)
+	err := commander.delegationRotateKey(commander.GetCommand(), []string{"not", "enough", "args"})
+	assert.Error(t, err)
+}
+
+func TestRemoveInvalidDelegationName(t *testing.T) {
+	// Setup commander
+	commander, trustDir := setup(t)
+	defer os.RemoveAll(trustDir)
 
 	// Should error due to invalid delegation name (should be prefixed by "targets/")
 	err := commander.delegationRemove(commander.GetCommand(), []string{"gun", "INVALID_NAME", "fake_key_id1", "fake_key_id2"})
@@ -103,11 +207,9 @@ func TestRemoveInvalidDelegationName(t *testing.T) {
 }
 
 func TestRemoveAllInvalidDelegationName(t *testing.T) {
-	// Cleanup after test
-	defer os.RemoveAll(testTrustDir)
-
 	// Setup commander
-	commander := setup()
+	commander, trustDir := setup(t)
+	defer os.RemoveAll(trustDir)
 
 	// Should error due to invalid delegation name (should be prefixed by "targets/")
 	err := commander.delegationRemove(commander.GetCommand(), []string{"gun", "INVALID_NAME"})
@@ -116,7 +218,8 @@ func TestRemoveAllInvalidDelegationName(t *testing.T) {
 
 func TestAddInvalidNumArgs(t *testing.T) {
 	// Setup commander
-	commander := setup()
+	commander, trustDir := setup(t)
+	defer os.RemoveAll(trustDir)
 
 	// Should error due to invalid number of args (2 instead of 3)
 	err := commander.delegationAdd(commander.GetCommand(), []string{"not", "enough"})
@@ -125,7 +228,8 @@ func TestAddInvalidNumArgs(t *testing.T) {
 
 func TestListInvalidNumArgs(t *testing.T) {
 	// Setup commander
-	commander := setup()
+	commander, trustDir := setup(t)
+	defer os.RemoveAll(trustDir)
 
 	// Should error due to invalid number of args (0 instead of 1)
 	err := commander.delegationsList(commander.GetCommand(), []string{})
@@ -134,7 +238,8 @@ func TestListInvalidNumArgs(t *testing.T) {
 
 func TestRemoveInvalidNumArgs(t *testing.T) {
 	// Setup commander
-	commander := setup()
+	commander, trustDir := setup(t)
+	defer os.RemoveAll(trustDir)
 
 	// Should error due to invalid number of args (1 instead of 2)
 	err := commander.delegationRemove(commander.GetCommand(), []string{"notenough"})
@@ -187,3 +292,73 @@ func generateShortRSAKeyTestCert() (*x509.Certificate, string, error) {
 	}
 	return cert, keyID, nil
 }
+
+// the --skip-cert-validation warning is written to the given writer, every
+// line prefixed with WARNING
+func TestWarnSkipCertValidation(t *testing.T) {
+	warnings := new(bytes.Buffer)
+	warnSkipCertValidation(warnings)
+	lines := strings.Split(strings.TrimSpace(warnings.String()), "\n")
+	assert.Len(t, lines, 3)
+	for _, line := range lines {
+		assert.True(t, strings.HasPrefix(line, "WARNING: "), line)
+	}
+	assert.Contains(t, warnings.String(), "will NOT be checked")
+}
+
+// Path notes are split from their paths at the first =, so paths starting
+// with or containing @ are kept whole, and an empty path is rejected rather
+// than noting the path that delegates every target
+func TestPathNotes(t *testing.T) {
+	notes := pathNotes{}
+	assert.NoError(t, notes.Set("releases/=release team"))
+	assert.NoError(t, notes.Set("@scope/pkg=scoped packages"))
+	assert.NoError(t, notes.Set("img@sha256=a=b"))
+	assert.Equal(t, pathNotes{
+		"releases/":  "release team",
+		"@scope/pkg": "scoped packages",
+		"img@sha256": "a=b",
+	}, notes)
+
+	assert.Error(t, notes.Set("releases/=again"))
+	assert.Error(t, notes.Set("=everything"))
+	assert.Error(t, notes.Set("nightly/="))
+	assert.Error(t, notes.Set("nightly/"))
+	assert.Len(t, notes, 3)
+
+	assert.NoError(t, notes.checkPaths([]string{"docs/", "releases/", "@scope/pkg", "img@sha256"}))
+	assert.Error(t, notes.checkPaths([]string{"releases/", "@scope/pkg"}))
+}
+
+// a file is downloaded without the client certificate of the trust server,
+// which is only meant for the trust server
+func TestDownloadHTTPSWithoutClientCert(t *testing.T) {
+	var sentCerts int
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sentCerts = len(r.TLS.PeerCertificates)
+		w.Write([]byte("content"))
+	}))
+	server.TLS = &tls.Config{ClientAuth: tls.RequestClientCert}
+	server.StartTLS()
+	defer server.Close()
+
+	tempDir, err := ioutil.TempDir("", "notary-test-")
+	assert.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+	caFile := filepath.Join(tempDir, "server-ca.crt")
+	serverCert, err := x509.ParseCertificate(server.TLS.Certificates[0].Certificate[0])
+	assert.NoError(t, err)
+	assert.NoError(t, ioutil.WriteFile(caFile, trustmanager.CertToPEM(serverCert), 0644))
+	fixtures, err := filepath.Abs("../../fixtures")
+	assert.NoError(t, err)
+
+	config := viper.New()
+	config.Set("remote_server.root_ca", caFile)
+	config.Set("remote_server.tls_client_cert", filepath.Join(fixtures, "notary-server.crt"))
+	config.Set("remote_server.tls_client_key", filepath.Join(fixtures, "notary-server.key"))
+
+	content, err := downloadHTTPS(config, server.URL+"/file", "file", 100)
+	assert.NoError(t, err)
+	assert.Equal(t, "content", string(content))
+	assert.Equal(t, 0, sentCerts)
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/docker/notary"
+	"github.com/docker/notary/passphrase"
+	"github.com/docker/notary/trustmanager"
+	"github.com/docker/notary/tuf/data"
+	"github.com/docker/notary/tuf/signed"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var cmdDoctorTemplate = usageTemplate{
+	Use:   "doctor",
+	Short: "Checks that keys can be generated, used and stored.",
+	Long:  "Runs a self-test before any real operation is attempted: generates an ephemeral key of every supported type, signs a payload with it and verifies the signature, writes an ephemeral key to the key store in the trust directory and reads it back, and checks that the hardware key store, if there is one, can be listed.  No existing key is used or changed, and the ephemeral keys are removed again.  Every check is reported, and the command fails if any of them fail.",
+}
+
+// doctorKeyAlias is the alias (role) the ephemeral key is written to the key
+// store with
+const doctorKeyAlias = "notary-doctor"
+
+// doctorPayload is what the ephemeral keys sign
+var doctorPayload = []byte("notary doctor self-test payload")
+
+// The results of a doctor check
+const (
+	doctorCheckOK      = "ok"
+	doctorCheckSkipped = "skipped"
+	doctorCheckFailed  = "failed"
+)
+
+// doctorCheck is the result of one check: ok, skipped, or failed with Detail
+// saying why
+type doctorCheck struct {
+	Name   string
+	Result string
+	Detail string
+}
+
+type doctorCommander struct {
+	// these need to be set
+	configGetter func() (*viper.Viper, error)
+	retriever    passphrase.Retriever
+}
+
+func (d *doctorCommander) GetCommand() *cobra.Command {
+	return cmdDoctorTemplate.ToCommand(d.doctor)
+}
+
+// doctor runs all the checks, and prints the result of each one
+func (d *doctorCommander) doctor(cmd *cobra.Command, args []string) error {
+	if len(args) > 0 {
+		cmd.Usage()
+		return fmt.Errorf("doctor takes no arguments")
+	}
+	config, err := d.configGetter()
+	if err != nil {
+		return err
+	}
+
+	var checks []doctorCheck
+	for _, keyType := range []string{data.ECDSAKey, data.RSAKey, data.ED25519Key} {
+		checks = append(checks, newDoctorCheck(
+			fmt.Sprintf("sign and verify with a %s key", keyType), checkSignAndVerify(keyType)))
+	}
+	checks = append(checks, newDoctorCheck(
+		"write and read back a key in the trust directory", checkKeyFileStore(config.GetString("trust_dir"))))
+	checks = append(checks, checkHardwareKeyStore(config, d.retriever))
+
+	prettyPrintDoctorChecks(checks, cmd.Out())
+
+	failed := 0
+	for _, check := range checks {
+		if check.Result == doctorCheckFailed {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d checks failed", failed, len(checks))
+	}
+	return nil
+}
+
+func newDoctorCheck(name string, err error) doctorCheck {
+	if err != nil {
+		return doctorCheck{Name: name, Result: doctorCheckFailed, Detail: err.Error()}
+	}
+	return doctorCheck{Name: name, Result: doctorCheckOK}
+}
+
+// generateDoctorKey generates an ephemeral private key of the given type
+func generateDoctorKey(keyType string) (data.PrivateKey, error) {
+	switch keyType {
+	case data.ECDSAKey:
+		return trustmanager.GenerateECDSAKey(rand.Reader)
+	case data.RSAKey:
+		return trustmanager.GenerateRSAKey(rand.Reader, notary.MinRSABitSize)
+	case data.ED25519Key:
+		return trustmanager.GenerateED25519Key(rand.Reader)
+	}
+	return nil, fmt.Errorf("unsupported key type %s", keyType)
+}
+
+// checkSignAndVerify generates an ephemeral key, and checks that a signature
+// by it verifies, and that the same signature over a different payload does
+// not
+func checkSignAndVerify(keyType string) error {
+	privKey, err := generateDoctorKey(keyType)
+	if err != nil {
+		return fmt.Errorf("could not generate the key: %v", err)
+	}
+	return checkSignature(privKey, data.PublicKeyFromPrivate(privKey))
+}
+
+// checkSignature signs the payload with privKey, and verifies the signature
+// against pubKey
+func checkSignature(privKey data.PrivateKey, pubKey data.PublicKey) error {
+	sig, err := privKey.Sign(rand.Reader, doctorPayload, nil)
+	if err != nil {
+		return fmt.Errorf("could not sign: %v", err)
+	}
+	verifier, ok := signed.Verifiers[privKey.SignatureAlgorithm()]
+	if !ok {
+		return fmt.Errorf("no verifier for the %s signing method", privKey.SignatureAlgorithm())
+	}
+	if err := verifier.Verify(pubKey, sig, doctorPayload); err != nil {
+		return fmt.Errorf("the signature does not verify: %v", err)
+	}
+	tampered := append([]byte("not the "), doctorPayload...)
+	if err := verifier.Verify(pubKey, sig, tampered); err == nil {
+		return fmt.Errorf("the signature also verifies over a different payload")
+	}
+	return nil
+}
+
+// checkKeyFileStore writes an ephemeral key, encrypted with a random
+// passphrase, to the key store in the trust directory, and reads it back from
+// disk with a new key store.  The key is removed again whatever happens.
+func checkKeyFileStore(trustDir string) error {
+	privKey, err := generateDoctorKey(data.ECDSAKey)
+	if err != nil {
+		return fmt.Errorf("could not generate the key: %v", err)
+	}
+	passBytes := make([]byte, 16)
+	if _, err := rand.Read(passBytes); err != nil {
+		return fmt.Errorf("could not generate a passphrase: %v", err)
+	}
+	retriever := passphrase.ConstantRetriever(hex.EncodeToString(passBytes))
+
+	writeStore, err := trustmanager.NewKeyFileStore(trustDir, retriever)
+	if err != nil {
+		return fmt.Errorf("could not open the key store: %v", err)
+	}
+	keyID := privKey.ID()
+	if err := writeStore.AddKey(keyID, doctorKeyAlias, privKey); err != nil {
+		return fmt.Errorf("could not write the key: %v", err)
+	}
+	removed := false
+	defer func() {
+		if !removed {
+			writeStore.RemoveKey(keyID)
+		}
+	}()
+
+	// a new store, so that the key is read from disk rather than from the
+	// cache of the store it was written with
+	readStore, err := trustmanager.NewKeyFileStore(trustDir, retriever)
+	if err != nil {
+		return fmt.Errorf("could not open the key store: %v", err)
+	}
+	readKey, alias, err := readStore.GetKey(keyID)
+	if err != nil {
+		return fmt.Errorf("could not read the key back: %v", err)
+	}
+	if alias != doctorKeyAlias || !bytes.Equal(readKey.Private(), privKey.Private()) {
+		return fmt.Errorf("the key read back is not the key that was written")
+	}
+	if err := checkSignature(readKey, data.PublicKeyFromPrivate(privKey)); err != nil {
+		return fmt.Errorf("the key read back cannot be used: %v", err)
+	}
+	if err := readStore.RemoveKey(keyID); err != nil {
+		return fmt.Errorf("could not remove the key: %v", err)
+	}
+	removed = true
+	return nil
+}
+
+// checkHardwareKeyStore checks that the keys in the hardware key store can be
+// listed.  It is skipped if this build has no hardware support, or there is
+// no hardware key store attached.  No key is written to the hardware, since
+// it only has room for a few.
+func checkHardwareKeyStore(config *viper.Viper, retriever passphrase.Retriever) doctorCheck {
+	name := "list the keys in the hardware key store"
+	fileKeyStore, err := trustmanager.NewKeyFileStore(config.GetString("trust_dir"), retriever)
+	if err != nil {
+		return newDoctorCheck(name, fmt.Errorf("could not open the key store: %v", err))
+	}
+	hardwareStore, err := getYubiKeyStore(fileKeyStore, retriever)
+	if err != nil || hardwareStore == nil {
+		check := doctorCheck{Name: name, Result: doctorCheckSkipped, Detail: "no hardware key store"}
+		if err != nil {
+			check.Detail = err.Error()
+		}
+		return check
+	}
+	keys := hardwareStore.ListKeys()
+	return doctorCheck{Name: name, Result: doctorCheckOK,
+		Detail: fmt.Sprintf("%d key(s) in %s", len(keys), hardwareStore.Name())}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+
+	"github.com/spf13/cobra"
+)
+
+// normalizeGUN returns the normal form of a GUN, so that the same repository
+// is always named the same way: surrounding spaces, an http:// or https://
+// scheme and trailing slashes are removed, and the host, which is everything
+// before the first /, is lowercased.  GUNs that cannot name a repository are
+// rejected.
+func normalizeGUN(gun string) (string, error) {
+	normalized := strings.TrimSpace(gun)
+	if i := strings.Index(normalized, "://"); i >= 0 {
+		scheme := strings.ToLower(normalized[:i])
+		if scheme != "http" && scheme != "https" {
+			return "", fmt.Errorf("invalid GUN %q: it cannot start with %s://", gun, normalized[:i])
+		}
+		normalized = normalized[i+len("://"):]
+	}
+	normalized = strings.TrimRight(normalized, "/")
+	if normalized == "" {
+		return "", fmt.Errorf("invalid GUN %q: it is empty", gun)
+	}
+
+	components := strings.Split(normalized, "/")
+	for _, component := range components {
+		switch component {
+		case "":
+			return "", fmt.Errorf("invalid GUN %q: it cannot start with / or contain //", gun)
+		case ".", "..":
+			return "", fmt.Errorf("invalid GUN %q: it cannot contain %s as a path component", gun, component)
+		}
+		for _, r := range component {
+			if unicode.IsSpace(r) || unicode.IsControl(r) || r == '\\' {
+				return "", fmt.Errorf("invalid GUN %q: it cannot contain %q", gun, r)
+			}
+		}
+	}
+	if len(components) > 1 {
+		components[0] = strings.ToLower(components[0])
+	}
+	return strings.Join(components, "/"), nil
+}
+
+// gunArgIndices are the positions of the arguments in the usage of a command
+// that are GUNs: the ones in [ ] or < > with GUN in their name
+func gunArgIndices(use string) []int {
+	var (
+		indices  []int
+		position int
+	)
+	fields := strings.Fields(use)
+	// the first field is the name of the command
+	for i := 1; i < len(fields); i++ {
+		field := fields[i]
+		if strings.HasPrefix(field, "-") || field == "..." {
+			continue
+		}
+		arg := field
+		closer := ""
+		switch field[0] {
+		case '[':
+			closer = "]"
+		case '<':
+			closer = ">"
+		}
+		if closer != "" {
+			for !strings.HasSuffix(fields[i], closer) && i+1 < len(fields) {
+				i++
+				arg += " " + fields[i]
+			}
+		}
+		if strings.Contains(arg, "GUN") {
+			indices = append(indices, position)
+		}
+		position++
+	}
+	return indices
+}
+
+// normalizeGUNArgs normalizes the GUN arguments of a command, at the given
+// positions, and its --gun flag if it has one, so that every GUN is in its
+// normal form before the command runs.  The normal form is printed if it
+// differs from what was given.
+func normalizeGUNArgs(cmd *cobra.Command, args []string, indices []int) error {
+	normalize := func(gun string) (string, error) {
+		normalized, err := normalizeGUN(gun)
+		if err != nil {
+			return "", err
+		}
+		if normalized != gun {
+			fmt.Fprintf(os.Stderr, "Using the GUN %s, normalized from %q\n", normalized, gun)
+		}
+		return normalized, nil
+	}
+
+	for _, i := range indices {
+		if i >= len(args) {
+			break
+		}
+		normalized, err := normalize(args[i])
+		if err != nil {
+			return err
+		}
+		args[i] = normalized
+	}
+	if flag := cmd.Flags().Lookup("gun"); flag != nil && flag.Value.String() != "" {
+		normalized, err := normalize(flag.Value.String())
+		if err != nil {
+			return err
+		}
+		return flag.Value.Set(normalized)
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalizeGUN(t *testing.T) {
+	valid := map[string]string{
+		"docker.com/notary":              "docker.com/notary",
+		"docker.com/notary/":             "docker.com/notary",
+		"https://Docker.COM/notary//":    "docker.com/notary",
+		"HTTP://docker.com:5000/Notary":  "docker.com:5000/Notary",
+		"  docker.com/Team/App ":         "docker.com/Team/App",
+		"Repo":                           "Repo",
+		"localhost:5000/a/b/c":           "localhost:5000/a/b/c",
+		"docker.io/library/ubuntu-14.04": "docker.io/library/ubuntu-14.04",
+	}
+	for gun, expected := range valid {
+		normalized, err := normalizeGUN(gun)
+		require.NoError(t, err, gun)
+		require.Equal(t, expected, normalized, gun)
+	}
+
+	for _, gun := range []string{
+		"", " ", "/", "https://", "ftp://docker.com/notary", "/docker.com/notary",
+		"docker.com//notary", "docker.com/../notary", "docker.com/./notary",
+		"docker.com/my repo", "docker.com\\notary", "docker.com/not\tary",
+	} {
+		_, err := normalizeGUN(gun)
+		require.Error(t, err, gun)
+		require.Contains(t, err.Error(), "invalid GUN")
+	}
+}
+
+func TestGUNArgIndices(t *testing.T) {
+	testCases := map[string][]int{
+		"list [ GUN ]":                                       {0},
+		"add [ GUN ] <target> <file>":                        {0},
+		"associate [ keyID ] [ GUN ] [ role ]":               {1},
+		"compare [ GUN 1 ] [ GUN 2 ]":                        {0, 1},
+		"clone [ source GUN ] [ destination GUN ]":           {0, 1},
+		"export-keys [ GUN ] --public [ output directory ]":  {0},
+		"add [ GUN ] [ Role ] <X509 file path or URL 1> ...": {0},
+		"remove [ key ID ] [ Old KeyID ] <KeyID 1> ...":      nil,
+		"rotate-key [ GUN ] [ Role ] [ Old KeyID ] [ file ]": {0},
+		"version": nil,
+	}
+	for use, expected := range testCases {
+		require.Equal(t, expected, gunArgIndices(use), use)
+	}
+}
@@ -9,6 +9,9 @@ package main
 import (
 	"bytes"
 	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
@@ -21,6 +24,7 @@ import (
 	"github.com/Sirupsen/logrus"
 	ctxu "github.com/docker/distribution/context"
 	"github.com/docker/notary"
+	notaryclient "github.com/docker/notary/client"
 	"github.com/docker/notary/cryptoservice"
 	"github.com/docker/notary/passphrase"
 	"github.com/docker/notary/server"
@@ -138,6 +142,13 @@ func TestClientTufInteraction(t *testing.T) {
 	output, err = runCommand(t, tempDir, "-s", server.URL, "verify", "gun", target)
 	assert.NoError(t, err)
 
+	// verify repo - only trusted if signed by one of the trusted roles
+	_, err = runCommand(t, tempDir, "-s", server.URL, "verify", "gun", target, "--trusted-roles", "targets/a,targets")
+	assert.NoError(t, err)
+	_, err = runCommand(t, tempDir, "-s", server.URL, "verify", "gun", target, "--trusted-roles", "targets/a")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "not one of the trusted roles")
+
 	// remove target
 	_, err = runCommand(t, tempDir, "remove", "gun", target)
 	assert.NoError(t, err)
@@ -152,8 +163,10 @@ func TestClientTufInteraction(t *testing.T) {
 	assert.False(t, strings.Contains(string(output), target))
 }
 
-// Initialize repo and test delegations commands by adding, listing, and removing delegations
-func TestClientDelegationsInteraction(t *testing.T) {
+// Initialize and publish a repo, then delete its local and remote trust data
+// and its keys
+func TestClientDeleteInteraction(t *testing.T) {
+	// -- setup --
 	setUp(t)
 
 	tempDir := tempDirWithConfig(t, "{}")
@@ -162,365 +175,558 @@ func TestClientDelegationsInteraction(t *testing.T) {
 	server := setupServer()
 	defer server.Close()
 
-	// Setup certificate
-	tempFile, err := ioutil.TempFile("", "pemfile")
+	// -- tests --
+
+	// init and publish repo
+	_, err := runCommand(t, tempDir, "-s", server.URL, "init", "gun")
+	assert.NoError(t, err)
+	_, err = runCommand(t, tempDir, "-s", server.URL, "publish", "gun")
+	assert.NoError(t, err)
+
+	// delete the local trust data only - the keys are kept, and the repo
+	// still exists on the server
+	output, err := runCommand(t, tempDir, "-s", server.URL, "delete", "gun", "--keep-keys", "-y")
+	assert.NoError(t, err)
+	assert.NotContains(t, output, "the private key")
+	assert.NotContains(t, output, "remote trust server")
+	_, err = os.Stat(filepath.Join(tempDir, "tuf", "gun"))
+	assert.True(t, os.IsNotExist(err))
+
+	output, err = runCommand(t, tempDir, "key", "list")
+	assert.NoError(t, err)
+	assert.Contains(t, output, "targets")
+
+	_, err = runCommand(t, tempDir, "-s", server.URL, "list", "gun")
+	assert.NoError(t, err)
+
+	// delete everything, remote trust data included
+	output, err = runCommand(t, tempDir, "-s", server.URL, "delete", "gun", "--remote", "-y")
+	assert.NoError(t, err)
+	assert.Contains(t, output, "the private key")
+	assert.Contains(t, output, "remote trust server")
+
+	output, err = runCommand(t, tempDir, "key", "list")
 	assert.NoError(t, err)
+	assert.NotContains(t, output, "targets")
+	assert.Contains(t, output, "root")
+
+	_, err = runCommand(t, tempDir, "-s", server.URL, "list", "gun")
+	assert.Error(t, err)
+}
+
+// Delegation keys of algorithms outside the configured allowed algorithms are
+// rejected
+func TestClientDelegationAllowedAlgorithms(t *testing.T) {
+	setUp(t)
+
+	tempDir := tempDirWithConfig(t, `{"allowed_algorithms": ["RSA 4096"]}`)
+	defer os.RemoveAll(tempDir)
+
+	server := setupServer()
+	defer server.Close()
 
+	tempFile, err := ioutil.TempFile("", "pemfile")
+	assert.NoError(t, err)
 	privKey, err := trustmanager.GenerateECDSAKey(rand.Reader)
+	assert.NoError(t, err)
 	startTime := time.Now()
-	endTime := startTime.AddDate(10, 0, 0)
-	cert, err := cryptoservice.GenerateCertificate(privKey, "gun", startTime, endTime)
+	cert, err := cryptoservice.GenerateCertificate(privKey, "gun", startTime, startTime.AddDate(10, 0, 0))
 	assert.NoError(t, err)
-
 	_, err = tempFile.Write(trustmanager.CertToPEM(cert))
 	assert.NoError(t, err)
 	tempFile.Close()
 	defer os.Remove(tempFile.Name())
 
-	rawPubBytes, _ := ioutil.ReadFile(tempFile.Name())
-	parsedPubKey, _ := trustmanager.ParsePEMPublicKey(rawPubBytes)
-	keyID, err := utils.CanonicalKeyID(parsedPubKey)
+	_, err = runCommand(t, tempDir, "-s", server.URL, "init", "gun")
+	assert.NoError(t, err)
+	_, err = runCommand(t, tempDir, "-s", server.URL, "publish", "gun")
 	assert.NoError(t, err)
 
-	var output string
+	_, err = runCommand(t, tempDir, "-s", server.URL, "delegation", "add", "gun", "targets/delegation", tempFile.Name(), "--all-paths")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "not an allowed algorithm")
+}
 
-	// -- tests --
+// Tests checking a directory of delegate certificates, which reports the key
+// ID of every valid certificate and fails if any of them is invalid
+func TestClientDelegationValidateCerts(t *testing.T) {
+	setUp(t)
 
-	// init repo
-	_, err = runCommand(t, tempDir, "-s", server.URL, "init", "gun")
-	assert.NoError(t, err)
+	tempDir := tempDirWithConfig(t, `{"allowed_algorithms": ["ECDSA P-256"], "revocation_list": "revoked.txt"}`)
+	defer os.RemoveAll(tempDir)
+	certDir := filepath.Join(tempDir, "certs")
+	assert.NoError(t, os.MkdirAll(certDir, 0700))
 
-	// publish repo
-	_, err = runCommand(t, tempDir, "-s", server.URL, "publish", "gun")
+	writeCert := func(name string, privKey data.PrivateKey, startTime, endTime time.Time) string {
+		cert, err := cryptoservice.GenerateCertificate(privKey, "gun", startTime, endTime)
+		assert.NoError(t, err)
+		assert.NoError(t, ioutil.WriteFile(filepath.Join(certDir, name), trustmanager.CertToPEM(cert), 0600))
+		keyID, err := utils.CanonicalKeyID(trustmanager.CertToKey(cert))
+		assert.NoError(t, err)
+		return keyID
+	}
+	ecdsaKey, err := trustmanager.GenerateECDSAKey(rand.Reader)
 	assert.NoError(t, err)
+	now := time.Now()
+	aliceID := writeCert("alice.pem", ecdsaKey, now, now.AddDate(1, 0, 0))
+	revocationList := filepath.Join(tempDir, "revoked.txt")
+	assert.NoError(t, ioutil.WriteFile(revocationList, []byte("# no revoked keys yet\n"), 0600))
 
-	// list delegations - none yet
-	output, err = runCommand(t, tempDir, "-s", server.URL, "delegation", "list", "gun")
+	// only the .pem files are checked
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(certDir, "README"), []byte("not a certificate"), 0600))
+	output, err := runCommand(t, tempDir, "delegation", "validate-certs", certDir)
 	assert.NoError(t, err)
-	assert.Contains(t, output, "No delegations present in this repository.")
+	assert.Contains(t, output, "alice.pem")
+	assert.Contains(t, output, aliceID)
+	assert.NotContains(t, output, "README")
 
-	// add new valid delegation with single new cert, and no path
-	output, err = runCommand(t, tempDir, "delegation", "add", "gun", "targets/delegation", tempFile.Name())
+	writeCert("expired.pem", ecdsaKey, now.AddDate(-2, 0, 0), now.AddDate(-1, 0, 0))
+	rsaKey, err := trustmanager.GenerateRSAKey(rand.Reader, 2048)
 	assert.NoError(t, err)
-	assert.Contains(t, output, "Addition of delegation role")
-	assert.NotContains(t, output, "path")
-
-	// check status - see delegation
-	output, err = runCommand(t, tempDir, "status", "gun")
+	rsaID := writeCert("rsa.pem", rsaKey, now, now.AddDate(1, 0, 0))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(certDir, "garbage.pem"), []byte("not a certificate"), 0600))
+	revokedKey, err := trustmanager.GenerateECDSAKey(rand.Reader)
 	assert.NoError(t, err)
-	assert.Contains(t, output, "Unpublished changes for gun")
+	bobID := writeCert("bob.pem", revokedKey, now, now.AddDate(1, 0, 0))
+	assert.NoError(t, ioutil.WriteFile(revocationList, []byte(bobID+"\n"), 0600))
 
-	// list delegations - none yet because still unpublished
-	output, err = runCommand(t, tempDir, "-s", server.URL, "delegation", "list", "gun")
-	assert.NoError(t, err)
-	assert.Contains(t, output, "No delegations present in this repository.")
+	output, err = runCommand(t, tempDir, "delegation", "validate-certs", certDir, "--json")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "4 of the 5 certificates")
+	var results []certValidation
+	assert.NoError(t, json.Unmarshal([]byte(output), &results))
+	assert.Len(t, results, 5)
+	assert.Equal(t, certValidation{File: "alice.pem", KeyID: aliceID}, results[0])
+	assert.Equal(t, "bob.pem", results[1].File)
+	assert.Equal(t, bobID, results[1].KeyID)
+	assert.Contains(t, results[1].Error, "has been revoked")
+	assert.Equal(t, "expired.pem", results[2].File)
+	assert.Contains(t, results[2].Error, "expired")
+	assert.Equal(t, "garbage.pem", results[3].File)
+	assert.NotEmpty(t, results[3].Error)
+	assert.Equal(t, "rsa.pem", results[4].File)
+	assert.Equal(t, rsaID, results[4].KeyID)
+	assert.Contains(t, results[4].Error, "not an allowed algorithm")
+
+	// a directory without certificates is an error
+	_, err = runCommand(t, tempDir, "delegation", "validate-certs", tempDir)
+	assert.Error(t, err)
 
-	// publish repo
+	// as is a revocation list that cannot be loaded
+	assert.NoError(t, os.Remove(revocationList))
+	_, err = runCommand(t, tempDir, "delegation", "validate-certs", certDir)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "could not load the revocation list")
+}
+
+// delegation add-keys-from-csv stages the valid rows of a CSV file of
+// delegations only if every row is valid, unless --continue-on-error is given
+func TestClientDelegationAddFromCSV(t *testing.T) {
+	setUp(t)
+
+	tempDir := tempDirWithConfig(t, "{}")
+	defer os.RemoveAll(tempDir)
+
+	server := setupServer()
+	defer server.Close()
+
+	certDir := filepath.Join(tempDir, "certs")
+	assert.NoError(t, os.MkdirAll(certDir, 0700))
+	writeCert := func(name string, startTime, endTime time.Time) string {
+		privKey, err := trustmanager.GenerateECDSAKey(rand.Reader)
+		assert.NoError(t, err)
+		cert, err := cryptoservice.GenerateCertificate(privKey, "gun", startTime, endTime)
+		assert.NoError(t, err)
+		assert.NoError(t, ioutil.WriteFile(filepath.Join(certDir, name), trustmanager.CertToPEM(cert), 0600))
+		keyID, err := utils.CanonicalKeyID(trustmanager.CertToKey(cert))
+		assert.NoError(t, err)
+		return keyID
+	}
+	now := time.Now()
+	aliceID := writeCert("alice.pem", now, now.AddDate(1, 0, 0))
+	bobID := writeCert("bob.pem", now, now.AddDate(1, 0, 0))
+	writeCert("expired.pem", now.AddDate(-2, 0, 0), now.AddDate(-1, 0, 0))
+
+	csvFile := filepath.Join(tempDir, "delegations.csv")
+	assert.NoError(t, ioutil.WriteFile(csvFile, []byte(`role,certificate,paths
+targets/releases,certs/alice.pem,"releases/,nightly/"
+# bob only signs releases
+targets/releases,`+filepath.Join(certDir, "bob.pem")+`,releases/
+releases,certs/bob.pem,
+targets/qa,certs/expired.pem,qa/
+targets/qa,certs/bob.pem,qa/
+`), 0600))
+
+	_, err := runCommand(t, tempDir, "-s", server.URL, "init", "gun")
+	assert.NoError(t, err)
 	_, err = runCommand(t, tempDir, "-s", server.URL, "publish", "gun")
 	assert.NoError(t, err)
 
-	// check status - no changelist
+	// nothing is staged, and the rows after the first invalid one are not checked
+	output, err := runCommand(t, tempDir, "delegation", "add-keys-from-csv", "gun", csvFile)
+	assert.Error(t, err)
+	assert.Contains(t, output, aliceID)
+	assert.Contains(t, output, "valid, not staged")
+	assert.Contains(t, output, "not a delegation role")
+	assert.NotContains(t, output, "expired.pem")
 	output, err = runCommand(t, tempDir, "status", "gun")
 	assert.NoError(t, err)
-	assert.Contains(t, output, "No unpublished changes for gun")
-
-	// list delegations - we should see our added delegation, with no paths
-	output, err = runCommand(t, tempDir, "-s", server.URL, "delegation", "list", "gun")
-	assert.NoError(t, err)
-	assert.Contains(t, output, "targets/delegation")
-	assert.Contains(t, output, keyID)
-	assert.NotContains(t, output, "\"\"")
+	assert.Contains(t, output, "No unpublished changes")
 
-	// add all paths to this delegation
-	output, err = runCommand(t, tempDir, "delegation", "add", "gun", "targets/delegation", "--all-paths")
-	assert.NoError(t, err)
-	assert.Contains(t, output, "Addition of delegation role")
-	assert.Contains(t, output, "\"\"")
-	assert.Contains(t, output, "<all paths>")
+	output, err = runCommand(t, tempDir, "delegation", "add-keys-from-csv", "gun", csvFile, "--continue-on-error")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "2 of the rows")
+	assert.Contains(t, output, "staged")
+	assert.Contains(t, output, "expired")
 
-	// publish repo
 	_, err = runCommand(t, tempDir, "-s", server.URL, "publish", "gun")
 	assert.NoError(t, err)
-
-	// list delegations - we should see our added delegation, with no paths
-	output, err = runCommand(t, tempDir, "-s", server.URL, "delegation", "list", "gun")
+	output, err = runCommand(t, tempDir, "-s", server.URL, "delegation", "info", "gun", "targets/releases")
 	assert.NoError(t, err)
-	assert.Contains(t, output, "targets/delegation")
-	assert.Contains(t, output, "\"\"")
-	assert.Contains(t, output, "<all paths>")
-
-	// Setup another certificate
-	tempFile2, err := ioutil.TempFile("", "pemfile2")
+	assert.Contains(t, output, aliceID)
+	assert.Contains(t, output, bobID)
+	assert.Contains(t, output, "nightly/")
+	output, err = runCommand(t, tempDir, "-s", server.URL, "delegation", "info", "gun", "targets/qa")
 	assert.NoError(t, err)
+	assert.Contains(t, output, bobID)
 
-	privKey, err = trustmanager.GenerateECDSAKey(rand.Reader)
-	startTime = time.Now()
-	endTime = startTime.AddDate(10, 0, 0)
-	cert, err = cryptoservice.GenerateCertificate(privKey, "gun", startTime, endTime)
-	assert.NoError(t, err)
+	// a row with too many fields makes the whole file unreadable
+	assert.NoError(t, ioutil.WriteFile(csvFile, []byte("targets/releases,certs/alice.pem,releases/,extra\n"), 0600))
+	_, err = runCommand(t, tempDir, "delegation", "add-keys-from-csv", "gun", csvFile, "--continue-on-error")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "row 1")
+}
 
-	_, err = tempFile2.Write(trustmanager.CertToPEM(cert))
+// delegation add records the expiry given with --expires, which has to be in
+// the future, and delegation list shows it
+func TestClientDelegationExpires(t *testing.T) {
+	setUp(t)
+
+	tempDir := tempDirWithConfig(t, "{}")
+	defer os.RemoveAll(tempDir)
+
+	server := setupServer()
+	defer server.Close()
+
+	tempFile, err := ioutil.TempFile("", "pemfile")
 	assert.NoError(t, err)
+	privKey, err := trustmanager.GenerateECDSAKey(rand.Reader)
 	assert.NoError(t, err)
-	tempFile2.Close()
-	defer os.Remove(tempFile2.Name())
-
-	rawPubBytes2, _ := ioutil.ReadFile(tempFile2.Name())
-	parsedPubKey2, _ := trustmanager.ParsePEMPublicKey(rawPubBytes2)
-	keyID2, err := utils.CanonicalKeyID(parsedPubKey2)
+	startTime := time.Now()
+	cert, err := cryptoservice.GenerateCertificate(privKey, "gun", startTime, startTime.AddDate(10, 0, 0))
 	assert.NoError(t, err)
-
-	// add to the delegation by specifying the same role, this time add a scoped path
-	output, err = runCommand(t, tempDir, "delegation", "add", "gun", "targets/delegation", tempFile2.Name(), "--paths", "path")
+	_, err = tempFile.Write(trustmanager.CertToPEM(cert))
 	assert.NoError(t, err)
-	assert.Contains(t, output, "Addition of delegation role")
+	tempFile.Close()
+	defer os.Remove(tempFile.Name())
 
-	// publish repo
+	_, err = runCommand(t, tempDir, "-s", server.URL, "init", "gun")
+	assert.NoError(t, err)
 	_, err = runCommand(t, tempDir, "-s", server.URL, "publish", "gun")
 	assert.NoError(t, err)
 
-	// list delegations - we should see two keys
-	output, err = runCommand(t, tempDir, "-s", server.URL, "delegation", "list", "gun")
+	for _, expires := range []string{"2000-01-01", "tomorrow"} {
+		_, err = runCommand(t, tempDir, "delegation", "add", "gun", "targets/delegation",
+			tempFile.Name(), "--all-paths", "--expires", expires)
+		assert.Error(t, err)
+	}
+	output, err := runCommand(t, tempDir, "status", "gun")
 	assert.NoError(t, err)
-	assert.Contains(t, output, ",")
-	assert.Contains(t, output, "path")
-	assert.Contains(t, output, keyID)
-	assert.Contains(t, output, keyID2)
+	assert.Contains(t, output, "No unpublished changes for gun")
 
-	// remove the delegation's first key
-	output, err = runCommand(t, tempDir, "delegation", "remove", "gun", "targets/delegation", keyID)
+	output, err = runCommand(t, tempDir, "delegation", "add", "gun", "targets/delegation",
+		tempFile.Name(), "--all-paths", "--expires", "2100-01-02")
 	assert.NoError(t, err)
-	assert.Contains(t, output, "Removal of delegation role")
-
-	// publish repo
+	assert.Contains(t, output, "expiring on 2100-01-02T00:00:00Z")
 	_, err = runCommand(t, tempDir, "-s", server.URL, "publish", "gun")
 	assert.NoError(t, err)
 
-	// list delegations - we should see the delegation but with only the second key
 	output, err = runCommand(t, tempDir, "-s", server.URL, "delegation", "list", "gun")
 	assert.NoError(t, err)
-	assert.NotContains(t, output, keyID)
-	assert.Contains(t, output, keyID2)
+	assert.Contains(t, output, "EXPIRES")
+	assert.Contains(t, output, "2100-01-02T00:00:00Z")
 
-	// remove the delegation's second key
-	output, err = runCommand(t, tempDir, "delegation", "remove", "gun", "targets/delegation", keyID2)
+	// the expiry can be changed on its own
+	_, err = runCommand(t, tempDir, "delegation", "add", "gun", "targets/delegation",
+		"--expires", "2100-03-04T05:06:07Z")
 	assert.NoError(t, err)
-	assert.Contains(t, output, "Removal of delegation role")
-
-	// publish repo
 	_, err = runCommand(t, tempDir, "-s", server.URL, "publish", "gun")
 	assert.NoError(t, err)
 
-	// list delegations - we should see no delegations
 	output, err = runCommand(t, tempDir, "-s", server.URL, "delegation", "list", "gun")
 	assert.NoError(t, err)
-	assert.Contains(t, output, "No delegations present in this repository.")
+	assert.Contains(t, output, "2100-03-04T05:06:07Z")
+}
 
-	// add delegation with multiple certs and multiple paths
-	output, err = runCommand(t, tempDir, "delegation", "add", "gun", "targets/delegation", tempFile.Name(), tempFile2.Name(), "--paths", "path1,path2")
-	assert.NoError(t, err)
-	assert.Contains(t, output, "Addition of delegation role")
+// With --replace, delegation add replaces the keys and paths of an existing
+// delegation instead of adding to them
+func TestClientDelegationReplace(t *testing.T) {
+	setUp(t)
 
-	// publish repo
-	_, err = runCommand(t, tempDir, "-s", server.URL, "publish", "gun")
-	assert.NoError(t, err)
+	tempDir := tempDirWithConfig(t, "{}")
+	defer os.RemoveAll(tempDir)
 
-	// list delegations - we should see two keys
-	output, err = runCommand(t, tempDir, "-s", server.URL, "delegation", "list", "gun")
-	assert.NoError(t, err)
-	assert.Contains(t, output, ",")
-	assert.Contains(t, output, "path1,path2")
-	assert.Contains(t, output, keyID)
-	assert.Contains(t, output, keyID2)
+	server := setupServer()
+	defer server.Close()
 
-	// add delegation with multiple certs and multiple paths
-	output, err = runCommand(t, tempDir, "delegation", "add", "gun", "targets/delegation", "--paths", "path3")
-	assert.NoError(t, err)
-	assert.Contains(t, output, "Addition of delegation role")
+	var certFiles, keyIDs []string
+	for i := 0; i < 2; i++ {
+		tempFile, err := ioutil.TempFile("", "pemfile")
+		assert.NoError(t, err)
+		privKey, err := trustmanager.GenerateECDSAKey(rand.Reader)
+		assert.NoError(t, err)
+		startTime := time.Now()
+		cert, err := cryptoservice.GenerateCertificate(privKey, "gun", startTime, startTime.AddDate(10, 0, 0))
+		assert.NoError(t, err)
+		_, err = tempFile.Write(trustmanager.CertToPEM(cert))
+		assert.NoError(t, err)
+		tempFile.Close()
+		defer os.Remove(tempFile.Name())
+		certFiles = append(certFiles, tempFile.Name())
+		keyIDs = append(keyIDs, privKey.ID())
+	}
 
-	// publish repo
+	_, err := runCommand(t, tempDir, "-s", server.URL, "init", "gun")
+	assert.NoError(t, err)
+	_, err = runCommand(t, tempDir, "delegation", "add", "gun", "targets/delegation",
+		certFiles[0], "--paths", "old/")
+	assert.NoError(t, err)
 	_, err = runCommand(t, tempDir, "-s", server.URL, "publish", "gun")
 	assert.NoError(t, err)
 
-	// list delegations - we should see two keys
-	output, err = runCommand(t, tempDir, "-s", server.URL, "delegation", "list", "gun")
-	assert.NoError(t, err)
-	assert.Contains(t, output, ",")
-	assert.Contains(t, output, "path1,path2,path3")
+	// --replace needs the keys of the delegation
+	_, err = runCommand(t, tempDir, "delegation", "add", "gun", "targets/delegation",
+		"--paths", "new/", "--replace")
+	assert.Error(t, err)
 
-	// just remove two paths from this delegation
-	output, err = runCommand(t, tempDir, "delegation", "remove", "gun", "targets/delegation", "--paths", "path2,path3")
+	output, err := runCommand(t, tempDir, "delegation", "add", "gun", "targets/delegation",
+		certFiles[1], "--paths", "new/", "--replace")
 	assert.NoError(t, err)
-	assert.Contains(t, output, "Removal of delegation role")
-
-	// publish repo
+	assert.Contains(t, output, "Replacement of delegation role targets/delegation")
+	output, err = runCommand(t, tempDir, "status", "gun")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, strings.Count(output, "targets/delegation"))
 	_, err = runCommand(t, tempDir, "-s", server.URL, "publish", "gun")
 	assert.NoError(t, err)
 
-	// list delegations - we should see the same two keys, and only path1
 	output, err = runCommand(t, tempDir, "-s", server.URL, "delegation", "list", "gun")
 	assert.NoError(t, err)
-	assert.Contains(t, output, ",")
-	assert.Contains(t, output, "path1")
-	assert.NotContains(t, output, "path2")
-	assert.NotContains(t, output, "path3")
-	assert.Contains(t, output, keyID)
-	assert.Contains(t, output, keyID2)
+	assert.Contains(t, output, "new/")
+	assert.NotContains(t, output, "old/")
+	assert.Contains(t, output, keyIDs[1])
+	assert.NotContains(t, output, keyIDs[0])
+}
 
-	// remove the remaining path, should not remove the delegation entirely
-	output, err = runCommand(t, tempDir, "delegation", "remove", "gun", "targets/delegation", "--paths", "path1")
-	assert.NoError(t, err)
-	assert.Contains(t, output, "Removal of delegation role")
+// delegation compare succeeds for two GUNs with the same delegations, and
+// reports the differences and fails otherwise
+func TestClientDelegationCompare(t *testing.T) {
+	setUp(t)
 
-	// publish repo
-	_, err = runCommand(t, tempDir, "-s", server.URL, "publish", "gun")
-	assert.NoError(t, err)
+	tempDir := tempDirWithConfig(t, "{}")
+	defer os.RemoveAll(tempDir)
 
-	// list delegations - we should see the same two keys, and no paths
-	output, err = runCommand(t, tempDir, "-s", server.URL, "delegation", "list", "gun")
+	server := setupServer()
+	defer server.Close()
+
+	tempFile, err := ioutil.TempFile("", "pemfile")
 	assert.NoError(t, err)
-	assert.Contains(t, output, ",")
-	assert.NotContains(t, output, "path1")
-	assert.NotContains(t, output, "path2")
-	assert.NotContains(t, output, "path3")
-	assert.Contains(t, output, keyID)
-	assert.Contains(t, output, keyID2)
-
-	// Add a bunch of individual paths so we can test a delegation remove --all-paths
-	output, err = runCommand(t, tempDir, "delegation", "add", "gun", "targets/delegation", "--paths", "abcdef,123456")
+	privKey, err := trustmanager.GenerateECDSAKey(rand.Reader)
 	assert.NoError(t, err)
-
-	// Add more individual paths so we can test a delegation remove --all-paths
-	output, err = runCommand(t, tempDir, "delegation", "add", "gun", "targets/delegation", "--paths", "banana/split,apple/crumble/pie,orange.peel,kiwi")
+	startTime := time.Now()
+	cert, err := cryptoservice.GenerateCertificate(privKey, "gun", startTime, startTime.AddDate(10, 0, 0))
 	assert.NoError(t, err)
-
-	// publish repo
-	_, err = runCommand(t, tempDir, "-s", server.URL, "publish", "gun")
+	_, err = tempFile.Write(trustmanager.CertToPEM(cert))
 	assert.NoError(t, err)
+	tempFile.Close()
+	defer os.Remove(tempFile.Name())
 
-	// list delegations - we should see all of our paths
-	output, err = runCommand(t, tempDir, "-s", server.URL, "delegation", "list", "gun")
-	assert.NoError(t, err)
-	assert.Contains(t, output, "abcdef")
-	assert.Contains(t, output, "123456")
-	assert.Contains(t, output, "banana/split")
-	assert.Contains(t, output, "apple/crumble/pie")
-	assert.Contains(t, output, "orange.peel")
-	assert.Contains(t, output, "kiwi")
+	for _, gun := range []string{"gun1", "gun2"} {
+		_, err = runCommand(t, tempDir, "-s", server.URL, "init", gun)
+		assert.NoError(t, err)
+		_, err = runCommand(t, tempDir, "delegation", "add", gun, "targets/delegation",
+			tempFile.Name(), "--paths", "path/")
+		assert.NoError(t, err)
+		_, err = runCommand(t, tempDir, "-s", server.URL, "publish", gun)
+		assert.NoError(t, err)
+	}
 
-	// Try adding "", and check that adding it with other paths clears out the others
-	output, err = runCommand(t, tempDir, "delegation", "add", "gun", "targets/delegation", "--paths", "\"\",grapefruit,pomegranate")
+	output, err := runCommand(t, tempDir, "-s", server.URL, "delegation", "compare", "gun1", "gun2")
 	assert.NoError(t, err)
+	assert.Contains(t, output, "are the same")
 
-	// publish repo
-	_, err = runCommand(t, tempDir, "-s", server.URL, "publish", "gun")
+	_, err = runCommand(t, tempDir, "delegation", "add", "gun2", "targets/delegation", "--paths", "other/")
 	assert.NoError(t, err)
-
-	// list delegations - we should see all of our old paths, and ""
-	output, err = runCommand(t, tempDir, "-s", server.URL, "delegation", "list", "gun")
+	_, err = runCommand(t, tempDir, "-s", server.URL, "publish", "gun2")
 	assert.NoError(t, err)
-	assert.Contains(t, output, "abcdef")
-	assert.Contains(t, output, "123456")
-	assert.Contains(t, output, "banana/split")
-	assert.Contains(t, output, "apple/crumble/pie")
-	assert.Contains(t, output, "orange.peel")
-	assert.Contains(t, output, "kiwi")
-	assert.Contains(t, output, "\"\"")
-	assert.NotContains(t, output, "grapefruit")
-	assert.NotContains(t, output, "pomegranate")
 
-	// Try removing just ""
-	output, err = runCommand(t, tempDir, "delegation", "remove", "gun", "targets/delegation", "--paths", "\"\"")
-	assert.NoError(t, err)
+	output, err = runCommand(t, tempDir, "-s", server.URL, "delegation", "compare", "gun1", "gun2")
+	assert.Error(t, err)
+	assert.Contains(t, output, "paths only in gun2: other/")
 
-	// publish repo
-	_, err = runCommand(t, tempDir, "-s", server.URL, "publish", "gun")
-	assert.NoError(t, err)
+	output, err = runCommand(t, tempDir, "-s", server.URL, "delegation", "compare", "gun1", "gun2", "--json")
+	assert.Error(t, err)
+	var diffs []map[string]interface{}
+	assert.NoError(t, json.Unmarshal([]byte(output), &diffs))
+	assert.Len(t, diffs, 1)
+	assert.Equal(t, "targets/delegation", diffs[0]["role"])
+}
 
-	// list delegations - we should see all of our old paths without ""
-	output, err = runCommand(t, tempDir, "-s", server.URL, "delegation", "list", "gun")
-	assert.NoError(t, err)
-	assert.Contains(t, output, "abcdef")
-	assert.Contains(t, output, "123456")
-	assert.Contains(t, output, "banana/split")
-	assert.Contains(t, output, "apple/crumble/pie")
-	assert.Contains(t, output, "orange.peel")
-	assert.Contains(t, output, "kiwi")
-	assert.NotContains(t, output, "\"\"")
+// delegation list pages through the sorted delegations with --limit and
+// --offset, and reports the total number of delegations
+func TestClientDelegationListPagination(t *testing.T) {
+	setUp(t)
 
-	// Remove --all-paths to clear out all paths from this delegation
-	output, err = runCommand(t, tempDir, "delegation", "remove", "gun", "targets/delegation", "--all-paths")
-	assert.NoError(t, err)
+	tempDir := tempDirWithConfig(t, "{}")
+	defer os.RemoveAll(tempDir)
 
-	// publish repo
+	server := setupServer()
+	defer server.Close()
+
+	_, err := runCommand(t, tempDir, "-s", server.URL, "init", "gun")
+	assert.NoError(t, err)
+	certFile := filepath.Join(tempDir, "delegation.crt")
+	_, err = runCommand(t, tempDir, "key", "generate-delegation", "gun", "targets/c", certFile)
+	assert.NoError(t, err)
+	for _, role := range []string{"targets/c", "targets/a", "targets/b"} {
+		_, err = runCommand(t, tempDir, "delegation", "add", "gun", role, certFile, "--all-paths")
+		assert.NoError(t, err)
+	}
 	_, err = runCommand(t, tempDir, "-s", server.URL, "publish", "gun")
 	assert.NoError(t, err)
 
-	// list delegations - we should see all of our paths
-	output, err = runCommand(t, tempDir, "-s", server.URL, "delegation", "list", "gun")
+	output, err := runCommand(t, tempDir, "-s", server.URL, "delegation", "list", "gun",
+		"--names-only", "--offset", "1", "--limit", "1")
 	assert.NoError(t, err)
-	assert.NotContains(t, output, "abcdef")
-	assert.NotContains(t, output, "123456")
-	assert.NotContains(t, output, "banana/split")
-	assert.NotContains(t, output, "apple/crumble/pie")
-	assert.NotContains(t, output, "orange.peel")
-	assert.NotContains(t, output, "kiwi")
+	assert.Equal(t, "targets/b\n", output)
 
-	// Check that we ignore other --paths if we pass in --all-paths on an add
-	output, err = runCommand(t, tempDir, "delegation", "add", "gun", "targets/delegation", "--all-paths", "--paths", "grapefruit,pomegranate")
+	output, err = runCommand(t, tempDir, "-s", server.URL, "delegation", "list", "gun", "--limit", "2")
 	assert.NoError(t, err)
+	assert.Contains(t, output, "targets/a")
+	assert.NotContains(t, output, "targets/c")
+	assert.Contains(t, output, "Showing delegations 1-2 of 3")
 
-	// publish repo
-	_, err = runCommand(t, tempDir, "-s", server.URL, "publish", "gun")
+	output, err = runCommand(t, tempDir, "-s", server.URL, "delegation", "list", "gun",
+		"--json", "--offset", "2", "--limit", "2")
 	assert.NoError(t, err)
+	var page delegationListPage
+	assert.NoError(t, json.Unmarshal([]byte(output), &page))
+	assert.Equal(t, 3, page.Total)
+	assert.Equal(t, 2, page.Offset)
+	assert.Len(t, page.Delegations, 1)
+	assert.Equal(t, "targets/c", page.Delegations[0].Name)
 
-	// list delegations - we should only see "", and not the other paths specified
-	output, err = runCommand(t, tempDir, "-s", server.URL, "delegation", "list", "gun")
+	output, err = runCommand(t, tempDir, "-s", server.URL, "delegation", "list", "gun", "--offset", "5")
 	assert.NoError(t, err)
-	assert.Contains(t, output, "\"\"")
-	assert.NotContains(t, output, "grapefruit")
-	assert.NotContains(t, output, "pomegranate")
+	assert.Contains(t, output, "No delegations at offset 5, of 3 in total")
 
-	// Add those extra paths we ignored to set up the next test
-	output, err = runCommand(t, tempDir, "delegation", "add", "gun", "targets/delegation", "--paths", "grapefruit,pomegranate")
-	assert.NoError(t, err)
+	_, err = runCommand(t, tempDir, "-s", server.URL, "delegation", "list", "gun", "--limit", "-1")
+	assert.Error(t, err)
+	_, err = runCommand(t, tempDir, "-s", server.URL, "delegation", "list", "gun", "--limit", "1", "--expiry-report")
+	assert.Error(t, err)
+}
 
-	// publish repo
-	_, err = runCommand(t, tempDir, "-s", server.URL, "publish", "gun")
+// With --require-code-signing, delegation add rejects certificates that are
+// not meant for code signing, such as TLS server certificates
+func TestClientDelegationRequireCodeSigning(t *testing.T) {
+	setUp(t)
+
+	tempDir := tempDirWithConfig(t, "{}")
+	defer os.RemoveAll(tempDir)
+
+	server := setupServer()
+	defer server.Close()
+
+	privKey, err := trustmanager.GenerateECDSAKey(rand.Reader)
+	assert.NoError(t, err)
+	startTime := time.Now()
+	codeSigningCert, err := cryptoservice.GenerateCertificate(privKey, "gun", startTime, startTime.AddDate(10, 0, 0))
+	assert.NoError(t, err)
+	template, err := trustmanager.NewCertificate("gun", startTime, startTime.AddDate(10, 0, 0))
+	assert.NoError(t, err)
+	template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	derBytes, err := x509.CreateCertificate(
+		rand.Reader, template, template, privKey.CryptoSigner().Public(), privKey.CryptoSigner())
+	assert.NoError(t, err)
+	serverCert, err := x509.ParseCertificate(derBytes)
 	assert.NoError(t, err)
 
-	// Check that we ignore other --paths if we pass in --all-paths on a remove
-	output, err = runCommand(t, tempDir, "delegation", "remove", "gun", "targets/delegation", "--all-paths", "--paths", "pomegranate")
+	codeSigningFile := filepath.Join(tempDir, "codesigning.crt")
+	assert.NoError(t, ioutil.WriteFile(codeSigningFile, trustmanager.CertToPEM(codeSigningCert), 0644))
+	serverFile := filepath.Join(tempDir, "server.crt")
+	assert.NoError(t, ioutil.WriteFile(serverFile, trustmanager.CertToPEM(serverCert), 0644))
+
+	_, err = runCommand(t, tempDir, "-s", server.URL, "init", "gun")
 	assert.NoError(t, err)
 
-	// publish repo
-	_, err = runCommand(t, tempDir, "-s", server.URL, "publish", "gun")
+	_, err = runCommand(t, tempDir, "delegation", "add", "gun", "targets/delegation",
+		serverFile, "--all-paths", "--require-code-signing")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "code signing")
+
+	// the check is off by default
+	_, err = runCommand(t, tempDir, "delegation", "add", "gun", "targets/other", serverFile, "--all-paths")
 	assert.NoError(t, err)
 
-	// list delegations - we should see no paths
-	output, err = runCommand(t, tempDir, "-s", server.URL, "delegation", "list", "gun")
+	_, err = runCommand(t, tempDir, "delegation", "add", "gun", "targets/delegation",
+		codeSigningFile, "--all-paths", "--require-code-signing")
 	assert.NoError(t, err)
-	assert.NotContains(t, output, "\"\"")
-	assert.NotContains(t, output, "grapefruit")
-	assert.NotContains(t, output, "pomegranate")
+}
 
-	// remove by force to delete the delegation entirely
-	output, err = runCommand(t, tempDir, "delegation", "remove", "gun", "targets/delegation", "-y")
+// With --require-org, delegation add rejects certificates whose subject does
+// not have the given organization
+func TestClientDelegationRequireOrg(t *testing.T) {
+	setUp(t)
+
+	tempDir := tempDirWithConfig(t, "{}")
+	defer os.RemoveAll(tempDir)
+
+	privKey, err := trustmanager.GenerateECDSAKey(rand.Reader)
 	assert.NoError(t, err)
-	assert.Contains(t, output, "Forced removal (including all keys and paths) of delegation role")
+	startTime := time.Now()
+	writeCert := func(name string, orgs ...string) string {
+		template, err := trustmanager.NewCertificate("gun", startTime, startTime.AddDate(10, 0, 0))
+		assert.NoError(t, err)
+		template.Subject.Organization = orgs
+		derBytes, err := x509.CreateCertificate(
+			rand.Reader, template, template, privKey.CryptoSigner().Public(), privKey.CryptoSigner())
+		assert.NoError(t, err)
+		cert, err := x509.ParseCertificate(derBytes)
+		assert.NoError(t, err)
+		certFile := filepath.Join(tempDir, name)
+		assert.NoError(t, ioutil.WriteFile(certFile, trustmanager.CertToPEM(cert), 0644))
+		return certFile
+	}
+	ourCert := writeCert("ours.crt", "Other Dept", "Example Corp")
+	theirCert := writeCert("theirs.crt", "Evil Corp")
+	noOrgCert := writeCert("none.crt")
 
-	// publish repo
-	_, err = runCommand(t, tempDir, "-s", server.URL, "publish", "gun")
+	_, err = runCommand(t, tempDir, "delegation", "add", "gun", "targets/delegation",
+		theirCert, "--all-paths", "--require-org", "Example Corp")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), `has the subject organization "Evil Corp", not the required "Example Corp"`)
+
+	_, err = runCommand(t, tempDir, "delegation", "add", "gun", "targets/delegation",
+		noOrgCert, "--all-paths", "--require-org", "Example Corp")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "no subject organization")
+
+	// the organization has to match exactly
+	_, err = runCommand(t, tempDir, "delegation", "add", "gun", "targets/delegation",
+		ourCert, "--all-paths", "--require-org", "example corp")
+	assert.Error(t, err)
+
+	_, err = runCommand(t, tempDir, "delegation", "add", "gun", "targets/delegation",
+		ourCert, "--all-paths", "--require-org", "Example Corp")
 	assert.NoError(t, err)
 
-	// list delegations - we should see no delegations
-	output, err = runCommand(t, tempDir, "-s", server.URL, "delegation", "list", "gun")
+	// the check is off by default
+	_, err = runCommand(t, tempDir, "delegation", "add", "gun", "targets/other", theirCert, "--all-paths")
 	assert.NoError(t, err)
-	assert.Contains(t, output, "No delegations present in this repository.")
 }
 
-// Initialize repo and test publishing targets with delegation roles
-func TestClientDelegationsPublishing(t *testing.T) {
+// delegation add takes the key of the leaf certificate of a bundle, and with
+// --require-ca the leaf has to chain to the CA certificates of the bundle
+func TestClientDelegationCertBundle(t *testing.T) {
 	setUp(t)
 
 	tempDir := tempDirWithConfig(t, "{}")
@@ -529,343 +735,2098 @@ func TestClientDelegationsPublishing(t *testing.T) {
 	server := setupServer()
 	defer server.Close()
 
-	// Setup certificate for delegation role
-	tempFile, err := ioutil.TempFile("", "pemfile")
-	assert.NoError(t, err)
-
-	privKey, err := trustmanager.GenerateRSAKey(rand.Reader, 2048)
-	assert.NoError(t, err)
-	privKeyBytesNoRole, err := trustmanager.KeyToPEM(privKey, "")
-	assert.NoError(t, err)
-	privKeyBytesWithRole, err := trustmanager.KeyToPEM(privKey, "user")
+	caKey, err := trustmanager.GenerateECDSAKey(rand.Reader)
 	assert.NoError(t, err)
 	startTime := time.Now()
-	endTime := startTime.AddDate(10, 0, 0)
-	cert, err := cryptoservice.GenerateCertificate(privKey, "gun", startTime, endTime)
+	template, err := trustmanager.NewCertificate("org CA", startTime, startTime.AddDate(10, 0, 0))
 	assert.NoError(t, err)
-
-	_, err = tempFile.Write(trustmanager.CertToPEM(cert))
+	template.IsCA = true
+	template.KeyUsage |= x509.KeyUsageCertSign
+	derBytes, err := x509.CreateCertificate(
+		rand.Reader, template, template, caKey.CryptoSigner().Public(), caKey.CryptoSigner())
 	assert.NoError(t, err)
-	tempFile.Close()
-	defer os.Remove(tempFile.Name())
-
-	rawPubBytes, _ := ioutil.ReadFile(tempFile.Name())
-	parsedPubKey, _ := trustmanager.ParsePEMPublicKey(rawPubBytes)
-	canonicalKeyID, err := utils.CanonicalKeyID(parsedPubKey)
+	caCert, err := x509.ParseCertificate(derBytes)
 	assert.NoError(t, err)
 
-	// Set up targets for publishing
-	tempTargetFile, err := ioutil.TempFile("", "targetfile")
+	privKey, err := trustmanager.GenerateECDSAKey(rand.Reader)
+	assert.NoError(t, err)
+	leafCert, err := cryptoservice.GenerateCASignedCertificate(
+		privKey, caKey, caCert, "gun", startTime, startTime.AddDate(10, 0, 0))
 	assert.NoError(t, err)
-	tempTargetFile.Close()
-	defer os.Remove(tempTargetFile.Name())
-
-	var target = "sdgkadga"
 
-	var output string
+	bundleFile := filepath.Join(tempDir, "bundle.crt")
+	assert.NoError(t, ioutil.WriteFile(bundleFile,
+		append(trustmanager.CertToPEM(caCert), trustmanager.CertToPEM(leafCert)...), 0644))
+	leafFile := filepath.Join(tempDir, "leaf.crt")
+	assert.NoError(t, ioutil.WriteFile(leafFile, trustmanager.CertToPEM(leafCert), 0644))
+	caFile := filepath.Join(tempDir, "ca.crt")
+	assert.NoError(t, ioutil.WriteFile(caFile, trustmanager.CertToPEM(caCert), 0644))
 
-	// init repo
 	_, err = runCommand(t, tempDir, "-s", server.URL, "init", "gun")
 	assert.NoError(t, err)
 
-	// publish repo
-	_, err = runCommand(t, tempDir, "-s", server.URL, "publish", "gun")
+	// a lone certificate is used as is, but has no CA to chain to
+	_, err = runCommand(t, tempDir, "delegation", "add", "gun", "targets/delegation", caFile, "--all-paths")
 	assert.NoError(t, err)
+	_, err = runCommand(t, tempDir, "delegation", "add", "gun", "targets/delegation",
+		caFile, "--all-paths", "--require-ca")
+	assert.Error(t, err)
 
-	// list delegations - none yet
-	output, err = runCommand(t, tempDir, "-s", server.URL, "delegation", "list", "gun")
-	assert.NoError(t, err)
-	assert.Contains(t, output, "No delegations present in this repository.")
+	// a certificate without its CA
+	_, err = runCommand(t, tempDir, "delegation", "add", "gun", "targets/releases",
+		leafFile, "--all-paths", "--require-ca")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "CA certificates")
 
-	// publish repo
+	_, err = runCommand(t, tempDir, "delegation", "add", "gun", "targets/releases",
+		bundleFile, "--all-paths", "--require-ca")
+	assert.NoError(t, err)
 	_, err = runCommand(t, tempDir, "-s", server.URL, "publish", "gun")
 	assert.NoError(t, err)
 
-	// validate that we have all keys, including snapshot
-	assertNumKeys(t, tempDir, 1, 2, true)
-
-	// rotate the snapshot key to server
-	output, err = runCommand(t, tempDir, "-s", server.URL, "key", "rotate", "gun", "-r", "--key-type", "snapshot")
+	output, err := runCommand(t, tempDir, "-s", server.URL, "delegation", "list", "gun")
 	assert.NoError(t, err)
-
-	// publish repo
-	_, err = runCommand(t, tempDir, "-s", server.URL, "publish", "gun")
+	leafKeyID, err := utils.CanonicalKeyID(trustmanager.CertToKey(leafCert))
 	assert.NoError(t, err)
+	assert.Contains(t, output, leafKeyID)
+}
 
-	// validate that we lost the snapshot signing key
-	_, signingKeyIDs := assertNumKeys(t, tempDir, 1, 1, true)
-	targetKeyID := signingKeyIDs[0]
+// delegation add downloads the certificates given as https:// URLs, verifying
+// the certificate of the server with the configured CA
+func TestClientDelegationAddFromURL(t *testing.T) {
+	setUp(t)
 
-	// add new valid delegation with single new cert
-	output, err = runCommand(t, tempDir, "delegation", "add", "gun", "targets/releases", tempFile.Name(), "--paths", "\"\"")
-	assert.NoError(t, err)
-	assert.Contains(t, output, "Addition of delegation role")
+	tempDir := tempDirWithConfig(t, "{}")
+	defer os.RemoveAll(tempDir)
 
-	// publish repo
-	_, err = runCommand(t, tempDir, "-s", server.URL, "publish", "gun")
-	assert.NoError(t, err)
+	server := setupServer()
+	defer server.Close()
 
-	// list delegations - we should see our one delegation
-	output, err = runCommand(t, tempDir, "-s", server.URL, "delegation", "list", "gun")
+	privKey, err := trustmanager.GenerateECDSAKey(rand.Reader)
+	assert.NoError(t, err)
+	startTime := time.Now()
+	cert, err := cryptoservice.GenerateCertificate(privKey, "gun", startTime, startTime.AddDate(10, 0, 0))
+	assert.NoError(t, err)
+	keyID, err := utils.CanonicalKeyID(trustmanager.CertToKey(cert))
 	assert.NoError(t, err)
-	assert.NotContains(t, output, "No delegations present in this repository.")
 
-	// remove the targets key to demonstrate that delegates don't need this key
-	keyDir := filepath.Join(tempDir, "private", "tuf_keys")
-	assert.NoError(t, os.Remove(filepath.Join(keyDir, "gun", targetKeyID+".key")))
+	mux := http.NewServeMux()
+	mux.HandleFunc("/cert.pem", func(w http.ResponseWriter, r *http.Request) {
+		w.Write(trustmanager.CertToPEM(cert))
+	})
+	mux.HandleFunc("/huge.pem", func(w http.ResponseWriter, r *http.Request) {
+		w.Write(bytes.Repeat([]byte("a"), maxCertDownloadSize+1))
+	})
+	mux.HandleFunc("/redirect.pem", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, server.URL+"/cert.pem", http.StatusFound)
+	})
+	certServer := httptest.NewTLSServer(mux)
+	defer certServer.Close()
 
-	// Note that we need to use the canonical key ID, followed by the base of the role here
-	err = ioutil.WriteFile(filepath.Join(keyDir, canonicalKeyID+"_releases.key"), privKeyBytesNoRole, 0700)
+	serverCert, err := x509.ParseCertificate(certServer.TLS.Certificates[0].Certificate[0])
 	assert.NoError(t, err)
+	caFile := filepath.Join(tempDir, "server-ca.crt")
+	assert.NoError(t, ioutil.WriteFile(caFile, trustmanager.CertToPEM(serverCert), 0644))
 
-	// add a target using the delegation -- will only add to targets/releases
-	_, err = runCommand(t, tempDir, "add", "gun", target, tempTargetFile.Name(), "--roles", "targets/releases")
+	_, err = runCommand(t, tempDir, "-s", server.URL, "init", "gun")
 	assert.NoError(t, err)
 
-	// list targets for targets/releases - we should see no targets until we publish
-	output, err = runCommand(t, tempDir, "-s", server.URL, "list", "gun", "--roles", "targets/releases")
-	assert.NoError(t, err)
-	assert.Contains(t, output, "No targets")
+	// the certificate of the server is verified, even if told not to
+	for _, flags := range [][]string{nil, {"--tls-skip-verify"}} {
+		_, err = runCommand(t, tempDir, append([]string{"delegation", "add", "gun", "targets/delegation",
+			certServer.URL + "/cert.pem", "--all-paths"}, flags...)...)
+		assert.Error(t, err, "the certificate of the server was not verified with %v", flags)
+	}
 
-	output, err = runCommand(t, tempDir, "-s", server.URL, "status", "gun")
-	assert.NoError(t, err)
+	// only https:// URLs are used, and only some of what they serve
+	for certURL, reason := range map[string]string{
+		server.URL + "/cert.pem":         "https://",
+		certServer.URL + "/redirect.pem": "https://",
+		certServer.URL + "/huge.pem":     "larger than",
+		certServer.URL + "/missing.pem":  "404",
+	} {
+		_, err = runCommand(t, tempDir, "--tlscacert", caFile,
+			"delegation", "add", "gun", "targets/delegation", certURL, "--all-paths")
+		if assert.Error(t, err, "a certificate was added from %s", certURL) {
+			assert.Contains(t, err.Error(), reason)
+		}
+	}
 
-	// publish repo
+	_, err = runCommand(t, tempDir, "--tlscacert", caFile,
+		"delegation", "add", "gun", "targets/delegation", certServer.URL+"/cert.pem", "--all-paths")
+	assert.NoError(t, err)
 	_, err = runCommand(t, tempDir, "-s", server.URL, "publish", "gun")
 	assert.NoError(t, err)
 
-	// list targets for targets/releases - we should see our target!
-	output, err = runCommand(t, tempDir, "-s", server.URL, "list", "gun", "--roles", "targets/releases")
-	assert.NoError(t, err)
-	assert.Contains(t, output, "targets/releases")
-
-	// remove the target for this role only
-	_, err = runCommand(t, tempDir, "remove", "gun", target, "--roles", "targets/releases")
+	output, err := runCommand(t, tempDir, "-s", server.URL, "delegation", "list", "gun")
 	assert.NoError(t, err)
+	assert.Contains(t, output, keyID)
+}
 
-	// publish repo
-	_, err = runCommand(t, tempDir, "-s", server.URL, "publish", "gun")
-	assert.NoError(t, err)
+// The keys on the configured revocation list can't be added to a delegation,
+// and reading from a repository with a delegation that has one fails.  If the
+// list can't be loaded, commands fail unless the revocation mode is open.
+// GUNs given with a scheme, an uppercase host or a trailing slash name the
+// same repository as their normal form, and invalid GUNs are rejected before
+// anything is done
+func TestClientNormalizesGUN(t *testing.T) {
+	setUp(t)
 
-	// list targets for targets/releases - we should see no targets
-	output, err = runCommand(t, tempDir, "-s", server.URL, "list", "gun", "--roles", "targets/releases")
-	assert.NoError(t, err)
-	assert.Contains(t, output, "No targets present")
+	tempDir := tempDirWithConfig(t, "{}")
+	defer os.RemoveAll(tempDir)
 
-	// Try adding a target with a different key style - private/tuf_keys/canonicalKeyID.key with "user" set as the "role" PEM header
-	// First remove the old key and add the new style
-	assert.NoError(t, os.Remove(filepath.Join(keyDir, canonicalKeyID+"_releases.key")))
-	err = ioutil.WriteFile(filepath.Join(keyDir, canonicalKeyID+".key"), privKeyBytesWithRole, 0700)
-	assert.NoError(t, err)
+	server := setupServer()
+	defer server.Close()
 
-	// add a target using the delegation -- will only add to targets/releases
-	_, err = runCommand(t, tempDir, "add", "gun", target, tempTargetFile.Name(), "--roles", "targets/releases")
+	tempFile, err := ioutil.TempFile("", "targetfile")
 	assert.NoError(t, err)
+	tempFile.Close()
+	defer os.Remove(tempFile.Name())
 
-	// list targets for targets/releases - we should see no targets until we publish
-	output, err = runCommand(t, tempDir, "-s", server.URL, "list", "gun", "--roles", "targets/releases")
+	_, err = runCommand(t, tempDir, "-s", server.URL, "init", "https://Docker.com/notary/")
 	assert.NoError(t, err)
-	assert.Contains(t, output, "No targets")
-
-	// publish repo
-	_, err = runCommand(t, tempDir, "-s", server.URL, "publish", "gun")
+	_, err = runCommand(t, tempDir, "add", "docker.com/notary", "sdgkadga", tempFile.Name())
 	assert.NoError(t, err)
-
-	// list targets for targets/releases - we should see our target!
-	output, err = runCommand(t, tempDir, "-s", server.URL, "list", "gun", "--roles", "targets/releases")
+	_, err = runCommand(t, tempDir, "-s", server.URL, "publish", "DOCKER.COM/notary")
 	assert.NoError(t, err)
-	assert.Contains(t, output, "targets/releases")
 
-	// remove the target for this role only
-	_, err = runCommand(t, tempDir, "remove", "gun", target, "--roles", "targets/releases")
+	output, err := runCommand(t, tempDir, "-s", server.URL, "list", "http://docker.com/notary//")
 	assert.NoError(t, err)
+	assert.Contains(t, output, "sdgkadga")
 
-	// publish repo
-	_, err = runCommand(t, tempDir, "-s", server.URL, "publish", "gun")
-	assert.NoError(t, err)
+	_, err = runCommand(t, tempDir, "-s", server.URL, "list", "docker.com/../notary")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid GUN")
+}
 
-	// Now remove this key, and make a new file to import the delegation's key from
-	assert.NoError(t, os.Remove(filepath.Join(keyDir, canonicalKeyID+".key")))
-	tempPrivFile, err := ioutil.TempFile("/tmp", "privfile")
-	assert.NoError(t, err)
-	defer os.Remove(tempPrivFile.Name())
+// a key can be fingerprinted from a certificate or by the ID it is stored
+// under, and both give the same canonical ID
+func TestClientKeyFingerprint(t *testing.T) {
+	setUp(t)
 
-	// Write the private key to a file so we can import it
-	_, err = tempPrivFile.Write(privKeyBytesNoRole)
+	tempDir := tempDirWithConfig(t, "{}")
+	defer os.RemoveAll(tempDir)
+
+	certFile := filepath.Join(tempDir, "delegation.crt")
+	_, err := runCommand(t, tempDir, "key", "generate-delegation", "gun", "targets/delegation", certFile)
 	assert.NoError(t, err)
-	tempPrivFile.Close()
 
-	// Import the private key, associating it with our delegation role
-	_, err = runCommand(t, tempDir, "key", "import", tempPrivFile.Name(), "--role", "targets/releases")
+	certPEM, err := ioutil.ReadFile(certFile)
+	assert.NoError(t, err)
+	certKey, err := trustmanager.ParsePEMPublicKey(certPEM)
+	assert.NoError(t, err)
+	canonicalID, err := utils.CanonicalKeyID(certKey)
 	assert.NoError(t, err)
 
-	// add a target using the delegation -- will only add to targets/releases
-	_, err = runCommand(t, tempDir, "add", "gun", target, tempTargetFile.Name(), "--roles", "targets/releases")
+	output, err := runCommand(t, tempDir, "key", "fingerprint", certFile)
 	assert.NoError(t, err)
+	assert.Contains(t, output, "Key ID:              "+certKey.ID())
+	assert.Contains(t, output, "Canonical key ID:    "+canonicalID)
+	assert.Contains(t, output, "SHA-256 fingerprint: "+keyFingerprint(certKey.ID()))
 
-	// list targets for targets/releases - we should see no targets until we publish
-	output, err = runCommand(t, tempDir, "-s", server.URL, "list", "gun", "--roles", "targets/releases")
+	output, err = runCommand(t, tempDir, "key", "fingerprint", canonicalID[:10])
 	assert.NoError(t, err)
-	assert.Contains(t, output, "No targets")
+	assert.Contains(t, output, "Key ID:              "+canonicalID)
+	assert.Contains(t, output, "Canonical key ID:    "+canonicalID)
 
-	// publish repo
-	_, err = runCommand(t, tempDir, "-s", server.URL, "publish", "gun")
+	_, err = runCommand(t, tempDir, "key", "fingerprint", "nosuchkey")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "could not find a local key")
+}
+
+// doctor passes on a writable trust directory, leaving no key behind, and
+// fails if keys cannot be written to it
+func TestClientDoctor(t *testing.T) {
+	setUp(t)
+
+	tempDir := tempDirWithConfig(t, "{}")
+	defer os.RemoveAll(tempDir)
+
+	output, err := runCommand(t, tempDir, "doctor")
 	assert.NoError(t, err)
+	for _, keyType := range []string{data.ECDSAKey, data.RSAKey, data.ED25519Key} {
+		assert.Contains(t, output, "sign and verify with a "+keyType+" key")
+	}
+	assert.Contains(t, output, "write and read back a key in the trust directory")
+	assert.NotContains(t, output, "failed")
 
-	// list targets for targets/releases - we should see our target!
-	output, err = runCommand(t, tempDir, "-s", server.URL, "list", "gun", "--roles", "targets/releases")
+	keysDir := filepath.Join(tempDir, notary.PrivDir, notary.NonRootKeysSubdir)
+	files, err := ioutil.ReadDir(keysDir)
 	assert.NoError(t, err)
-	assert.Contains(t, output, "targets/releases")
+	assert.Len(t, files, 0)
+
+	// keys can't be written if the directory for them is a file
+	assert.NoError(t, os.RemoveAll(keysDir))
+	assert.NoError(t, ioutil.WriteFile(keysDir, []byte("not a directory"), 0644))
+	output, err = runCommand(t, tempDir, "doctor")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "1 of 5 checks failed")
+	assert.Contains(t, output, "could not write the key")
 }
 
-// Splits a string into lines, and returns any lines that are not empty (
-// striped of whitespace)
-func splitLines(chunk string) []string {
-	splitted := strings.Split(strings.TrimSpace(chunk), "\n")
-	var results []string
+func TestClientRevocationList(t *testing.T) {
+	setUp(t)
 
-	for _, line := range splitted {
-		line := strings.TrimSpace(line)
-		if line != "" {
-			results = append(results, line)
-		}
+	tempDir := tempDirWithConfig(t, `{"revocation_list": "revoked.txt"}`)
+	defer os.RemoveAll(tempDir)
+
+	server := setupServer()
+	defer server.Close()
+
+	writeCert := func(name string) string {
+		privKey, err := trustmanager.GenerateECDSAKey(rand.Reader)
+		assert.NoError(t, err)
+		startTime := time.Now()
+		cert, err := cryptoservice.GenerateCertificate(privKey, "gun", startTime, startTime.AddDate(1, 0, 0))
+		assert.NoError(t, err)
+		assert.NoError(t, ioutil.WriteFile(filepath.Join(tempDir, name), trustmanager.CertToPEM(cert), 0600))
+		keyID, err := utils.CanonicalKeyID(trustmanager.CertToKey(cert))
+		assert.NoError(t, err)
+		return keyID
 	}
+	aliceID := writeCert("alice.pem")
+	bobID := writeCert("bob.pem")
+	revocationList := filepath.Join(tempDir, "revoked.txt")
+	assert.NoError(t, ioutil.WriteFile(revocationList, []byte("# revoked keys\n"+strings.ToUpper(bobID)+" # lost laptop\n"), 0600))
 
-	return results
-}
+	_, err := runCommand(t, tempDir, "-s", server.URL, "init", "gun")
+	assert.NoError(t, err)
+	_, err = runCommand(t, tempDir, "delegation", "add", "gun", "targets/releases",
+		filepath.Join(tempDir, "bob.pem"), "--all-paths")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "revoked")
+	_, err = runCommand(t, tempDir, "delegation", "add", "gun", "targets/releases",
+		filepath.Join(tempDir, "alice.pem"), "--all-paths")
+	assert.NoError(t, err)
+	_, err = runCommand(t, tempDir, "-s", server.URL, "publish", "gun")
+	assert.NoError(t, err)
+	_, err = runCommand(t, tempDir, "-s", server.URL, "list", "gun")
+	assert.NoError(t, err)
 
-// List keys, parses the output, and returns the unique key IDs as an array
-// of root key IDs and an array of signing key IDs.  Output expected looks like:
-//     ROLE      GUN          KEY ID                   LOCATION
-// ----------------------------------------------------------------
-//   root               8bd63a896398b558ac...   file (.../private)
-//   snapshot   repo    e9e9425cd9a85fc7a5...   file (.../private)
-//   targets    repo    f5b84e2d92708c5acb...   file (.../private)
-func getUniqueKeys(t *testing.T, tempDir string) ([]string, []string) {
-	output, err := runCommand(t, tempDir, "key", "list")
+	assert.NoError(t, ioutil.WriteFile(revocationList, []byte(aliceID+"\n"), 0600))
+	_, err = runCommand(t, tempDir, "-s", server.URL, "list", "gun")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "revoked")
+
+	// fail closed, unless told to fail open
+	assert.NoError(t, os.Remove(revocationList))
+	_, err = runCommand(t, tempDir, "-s", server.URL, "list", "gun")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "could not load the revocation list")
+	_, err = runCommand(t, tempDir, "-s", server.URL, "--revocation-mode", "open", "list", "gun")
 	assert.NoError(t, err)
-	lines := splitLines(output)
-	if len(lines) == 1 && lines[0] == "No signing keys found." {
-		return []string{}, []string{}
-	}
-	if len(lines) < 3 { // 2 lines of header, at least 1 line with keys
-		t.Logf("This output is not what is expected by the test:\n%s", output)
-	}
+	_, err = runCommand(t, tempDir, "-s", server.URL, "--revocation-mode", "ajar", "list", "gun")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "revocation_mode")
+
+	// a revocation list given by URL is only downloaded again after its TTL
+	downloads := 0
+	listServer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		downloads++
+		w.Write([]byte(aliceID + "\n"))
+	}))
+	defer listServer.Close()
+	serverCert, err := x509.ParseCertificate(listServer.TLS.Certificates[0].Certificate[0])
+	assert.NoError(t, err)
+	caFile := filepath.Join(tempDir, "server-ca.crt")
+	assert.NoError(t, ioutil.WriteFile(caFile, trustmanager.CertToPEM(serverCert), 0644))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(tempDir, "config.json"),
+		[]byte(`{"revocation_list": "`+listServer.URL+`/revoked.txt"}`), 0600))
 
-	var (
-		rootMap    = make(map[string]bool)
-		nonrootMap = make(map[string]bool)
-		root       []string
-		nonroot    []string
-	)
-	// first two lines are header
-	for _, line := range lines[2:] {
-		parts := strings.Fields(line)
-		var (
-			placeToGo map[string]bool
-			keyID     string
-		)
-		if strings.TrimSpace(parts[0]) == "root" {
-			// no gun, so there are only 3 fields
-			placeToGo, keyID = rootMap, parts[1]
-		} else {
-			// gun comes between role and key ID
-			placeToGo, keyID = nonrootMap, parts[2]
-		}
-		// keys are 32-chars long (32 byte shasum, hex-encoded)
-		assert.Len(t, keyID, 64)
-		placeToGo[keyID] = true
-	}
-	for k := range rootMap {
-		root = append(root, k)
-	}
-	for k := range nonrootMap {
-		nonroot = append(nonroot, k)
+	for i := 0; i < 2; i++ {
+		_, err = runCommand(t, tempDir, "-s", server.URL, "--tlscacert", caFile, "list", "gun")
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "revoked")
 	}
-
-	return root, nonroot
+	assert.Equal(t, 1, downloads)
 }
 
-// List keys, parses the output, and asserts something about the number of root
-// keys and number of signing keys, as well as returning them.
-func assertNumKeys(t *testing.T, tempDir string, numRoot, numSigning int,
-	rootOnDisk bool) ([]string, []string) {
+// delegation history shows the published versions in which a delegation changed
+func TestClientDelegationHistory(t *testing.T) {
+	setUp(t)
 
-	root, signing := getUniqueKeys(t, tempDir)
-	assert.Len(t, root, numRoot)
-	assert.Len(t, signing, numSigning)
-	for _, rootKeyID := range root {
-		_, err := os.Stat(filepath.Join(
-			tempDir, "private", "root_keys", rootKeyID+".key"))
-		// os.IsExist checks to see if the error is because a file already
-		// exist, and hence doesn't actually the right funciton to use here
-		assert.Equal(t, rootOnDisk, !os.IsNotExist(err))
+	tempDir := tempDirWithConfig(t, "{}")
+	defer os.RemoveAll(tempDir)
 
-		// this function is declared is in the build-tagged setup files
-		verifyRootKeyOnHardware(t, rootKeyID)
+	server := setupServer()
+	defer server.Close()
+
+	certFile := filepath.Join(tempDir, "delegation.crt")
+	_, err := runCommand(t, tempDir, "key", "generate-delegation", "gun", "targets/delegation", certFile)
+	assert.NoError(t, err)
+
+	_, err = runCommand(t, tempDir, "-s", server.URL, "init", "gun")
+	assert.NoError(t, err)
+	_, err = runCommand(t, tempDir, "-s", server.URL, "publish", "gun")
+	assert.NoError(t, err)
+
+	output, err := runCommand(t, tempDir, "-s", server.URL, "delegation", "history", "gun", "targets/delegation")
+	assert.NoError(t, err)
+	assert.Contains(t, output, "No downloaded versions")
+
+	_, err = runCommand(t, tempDir, "delegation", "add", "gun", "targets/delegation", certFile, "--paths", "path")
+	assert.NoError(t, err)
+	_, err = runCommand(t, tempDir, "-s", server.URL, "publish", "gun")
+	assert.NoError(t, err)
+	_, err = runCommand(t, tempDir, "delegation", "add", "gun", "targets/delegation", "--paths", "other")
+	assert.NoError(t, err)
+	_, err = runCommand(t, tempDir, "-s", server.URL, "publish", "gun")
+	assert.NoError(t, err)
+
+	output, err = runCommand(t, tempDir, "-s", server.URL, "delegation", "history", "gun", "targets/delegation", "--json")
+	assert.NoError(t, err)
+	var changes []map[string]interface{}
+	assert.NoError(t, json.Unmarshal([]byte(output), &changes))
+	assert.Len(t, changes, 2)
+	assert.Equal(t, true, changes[0]["created"])
+	assert.Equal(t, []interface{}{"path"}, changes[0]["added_paths"])
+	assert.Equal(t, []interface{}{"other"}, changes[1]["added_paths"])
+	assert.True(t, changes[0]["version"].(float64) < changes[1]["version"].(float64))
+	for _, field := range []string{"seen", "expires"} {
+		_, ok := changes[1][field]
+		assert.True(t, ok, "missing %s", field)
 	}
-	return root, signing
+
+	_, err = runCommand(t, tempDir, "delegation", "remove", "gun", "targets/delegation", "--paths", "path")
+	assert.NoError(t, err)
+	_, err = runCommand(t, tempDir, "-s", server.URL, "publish", "gun")
+	assert.NoError(t, err)
+
+	output, err = runCommand(t, tempDir, "-s", server.URL, "delegation", "history", "gun", "targets/delegation")
+	assert.NoError(t, err)
+	assert.Contains(t, output, "created with threshold 1")
+	assert.Contains(t, output, "removed paths path")
 }
 
-// Adds the given target to the gun, publishes it, and lists it to ensure that
-// it appears.  Returns the listing output.
-func assertSuccessfullyPublish(
-	t *testing.T, tempDir, url, gun, target, fname string) string {
+// the messages printed when delegation changes are staged can be overridden
+// in the configuration, with the fields of the change
+func TestClientDelegationMessages(t *testing.T) {
+	setUp(t)
 
-	_, err := runCommand(t, tempDir, "add", gun, target, fname)
+	tempDir := tempDirWithConfig(t, `{"messages": {
+		"delegation_add": "staged {{.Role}} in {{.GUN}} with {{len .KeyIDs}} key(s) and paths {{join .Paths \",\"}}",
+		"delegation_remove_all": "removing {{.Role}}"
+	}}`)
+	defer os.RemoveAll(tempDir)
+
+	certFile := filepath.Join(tempDir, "delegation.crt")
+	_, err := runCommand(t, tempDir, "key", "generate-delegation", "gun", "targets/a", certFile)
 	assert.NoError(t, err)
 
-	_, err = runCommand(t, tempDir, "-s", url, "publish", gun)
+	output, err := runCommand(t, tempDir, "delegation", "add", "gun", "targets/a", certFile, "--paths", "x,y")
 	assert.NoError(t, err)
+	assert.Contains(t, output, "staged targets/a in gun with 1 key(s) and paths x,y")
+	assert.NotContains(t, output, "Addition of delegation role")
 
-	output, err := runCommand(t, tempDir, "-s", url, "list", gun)
+	output, err = runCommand(t, tempDir, "delegation", "remove", "gun", "targets/a", "-y")
 	assert.NoError(t, err)
-	assert.True(t, strings.Contains(string(output), target))
+	assert.Contains(t, output, "removing targets/a")
 
-	return output
+	// a message that is not overridden is the default one
+	output, err = runCommand(t, tempDir, "delegation", "remove", "gun", "targets/a", "--paths", "x")
+	assert.NoError(t, err)
+	assert.Contains(t, output, "Removal of delegation role targets/a with paths [x], to repository \"gun\" staged for next publish.")
 }
 
-// Tests root key generation and key rotation
-func TestClientKeyGenerationRotation(t *testing.T) {
-	// -- setup --
+// a delegation change can be based on an earlier version of the metadata of
+// the parent, in which case the later changes to the delegations of the parent
+// are overwritten on publish
+func TestClientDelegationBaseVersion(t *testing.T) {
 	setUp(t)
 
 	tempDir := tempDirWithConfig(t, "{}")
 	defer os.RemoveAll(tempDir)
 
-	tempfiles := make([]string, 2)
-	for i := 0; i < 2; i++ {
-		tempFile, err := ioutil.TempFile("", "targetfile")
-		assert.NoError(t, err)
-		tempFile.Close()
-		tempfiles[i] = tempFile.Name()
-		defer os.Remove(tempFile.Name())
-	}
-
 	server := setupServer()
 	defer server.Close()
 
-	var target = "sdgkadga"
+	certFile := filepath.Join(tempDir, "delegation.crt")
+	_, err := runCommand(t, tempDir, "key", "generate-delegation", "gun", "targets/a", certFile)
+	assert.NoError(t, err)
 
-	// -- tests --
+	_, err = runCommand(t, tempDir, "-s", server.URL, "init", "gun")
+	assert.NoError(t, err)
+	_, err = runCommand(t, tempDir, "-s", server.URL, "publish", "gun")
+	assert.NoError(t, err)
+	// the first published version of targets is 2, so version 3 has targets/a,
+	// and version 4 targets/b too
+	for _, role := range []string{"targets/a", "targets/b"} {
+		_, err = runCommand(t, tempDir, "delegation", "add", "gun", role, certFile, "--paths", "path")
+		assert.NoError(t, err)
+		_, err = runCommand(t, tempDir, "-s", server.URL, "publish", "gun")
+		assert.NoError(t, err)
+	}
+	output, err := runCommand(t, tempDir, "-s", server.URL, "delegation", "list", "gun", "--names-only")
+	assert.NoError(t, err)
+	assert.Equal(t, "targets/a\ntargets/b\n", output)
 
-	// starts out with no keys
-	assertNumKeys(t, tempDir, 0, 0, true)
+	_, err = runCommand(t, tempDir, "delegation", "add", "gun", "targets/c", certFile, "--paths", "path", "--base-version", "-1")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "--base-version")
+	_, err = runCommand(t, tempDir, "delegation", "add", "gun", "targets/c", certFile, "--paths", "path", "--base-version", "10")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "version 10 of targets is not in the history")
+	_, err = runCommand(t, tempDir, "delegation", "add", "gun", "targets/a/c", certFile, "--paths", "path", "--base-version", "3")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "version 3 of targets/a is not in the history")
 
-	// generate root key produces a single root key and no other keys
-	_, err := runCommand(t, tempDir, "key", "generate", data.ECDSAKey)
+	_, err = runCommand(t, tempDir, "delegation", "add", "gun", "targets/c", certFile, "--paths", "path", "--base-version", "3")
+	assert.NoError(t, err)
+	_, err = runCommand(t, tempDir, "-s", server.URL, "publish", "gun")
 	assert.NoError(t, err)
-	assertNumKeys(t, tempDir, 1, 0, true)
 
-	// initialize a repo, should have signing keys and no new root key
-	_, err = runCommand(t, tempDir, "-s", server.URL, "init", "gun")
+	output, err = runCommand(t, tempDir, "-s", server.URL, "delegation", "list", "gun", "--names-only")
 	assert.NoError(t, err)
-	origRoot, origSign := assertNumKeys(t, tempDir, 1, 2, true)
+	assert.Equal(t, "targets/a\ntargets/c\n", output)
+}
 
-	// publish using the original keys
-	assertSuccessfullyPublish(t, tempDir, server.URL, "gun", target, tempfiles[0])
+// Targets can be added with custom metadata, which list shows as JSON
+func TestClientTargetCustomMetadata(t *testing.T) {
+	setUp(t)
 
-	// rotate the signing keys
+	tempDir := tempDirWithConfig(t, "{}")
+	defer os.RemoveAll(tempDir)
+
+	server := setupServer()
+	defer server.Close()
+
+	tempFile, err := ioutil.TempFile("", "targetfile")
+	assert.NoError(t, err)
+	tempFile.Close()
+	defer os.Remove(tempFile.Name())
+
+	_, err = runCommand(t, tempDir, "-s", server.URL, "init", "gun")
+	assert.NoError(t, err)
+
+	// values prefixed with json: have to be valid JSON
+	_, err = runCommand(t, tempDir, "add", "gun", "v1", tempFile.Name(), "--custom", "flags=json:[1,")
+	assert.Error(t, err)
+	_, err = runCommand(t, tempDir, "add", "gun", "v1", tempFile.Name(), "--custom", "platform")
+	assert.Error(t, err)
+
+	_, err = runCommand(t, tempDir, "add", "gun", "v1", tempFile.Name(),
+		"--custom", "platform=linux/amd64", "--custom", "build=json:{\"id\": 1234, \"ci\": true}")
+	assert.NoError(t, err)
+	_, err = runCommand(t, tempDir, "add", "gun", "v2", tempFile.Name())
+	assert.NoError(t, err)
+	_, err = runCommand(t, tempDir, "-s", server.URL, "publish", "gun")
+	assert.NoError(t, err)
+
+	output, err := runCommand(t, tempDir, "-s", server.URL, "list", "gun", "--json")
+	assert.NoError(t, err)
+	var targets []targetJSON
+	assert.NoError(t, json.Unmarshal([]byte(output), &targets))
+	assert.Len(t, targets, 2)
+	assert.Equal(t, "v1", targets[0].Name)
+	assert.Equal(t, data.CanonicalTargetsRole, targets[0].Role)
+	var custom bytes.Buffer
+	assert.NoError(t, json.Compact(&custom, targets[0].Custom))
+	assert.Equal(t, `{"build":{"ci":true,"id":1234},"platform":"linux/amd64"}`, custom.String())
+	assert.Equal(t, "v2", targets[1].Name)
+	assert.Empty(t, targets[1].Custom)
+}
+
+// The read commands can use the cached metadata while the server is down,
+// unless --no-cache is given
+func TestClientNoCache(t *testing.T) {
+	setUp(t)
+
+	tempDir := tempDirWithConfig(t, "{}")
+	defer os.RemoveAll(tempDir)
+
+	server := setupServer()
+
+	_, err := runCommand(t, tempDir, "-s", server.URL, "init", "gun")
+	assert.NoError(t, err)
+	_, err = runCommand(t, tempDir, "-s", server.URL, "publish", "gun")
+	assert.NoError(t, err)
+
+	output, err := runCommand(t, tempDir, "-s", server.URL, "delegation", "list", "gun", "--no-cache")
+	assert.NoError(t, err)
+	assert.Contains(t, output, "No delegations present in this repository.")
+	_, err = runCommand(t, tempDir, "-s", server.URL, "list", "gun", "--no-cache")
+	assert.NoError(t, err)
+
+	server.Close()
+
+	_, err = runCommand(t, tempDir, "-s", server.URL, "delegation", "list", "gun")
+	assert.NoError(t, err)
+	_, err = runCommand(t, tempDir, "-s", server.URL, "delegation", "list", "gun", "--no-cache")
+	assert.Error(t, err)
+	_, err = runCommand(t, tempDir, "-s", server.URL, "list", "gun")
+	assert.NoError(t, err)
+	_, err = runCommand(t, tempDir, "-s", server.URL, "list", "gun", "--no-cache")
+	assert.Error(t, err)
+}
+
+// With --as-of, the read commands check the metadata for expiry at that time
+// rather than now
+func TestClientAsOf(t *testing.T) {
+	setUp(t)
+
+	tempDir := tempDirWithConfig(t, "{}")
+	defer os.RemoveAll(tempDir)
+
+	server := setupServer()
+	defer server.Close()
+
+	_, err := runCommand(t, tempDir, "-s", server.URL, "init", "gun")
+	assert.NoError(t, err)
+	_, err = runCommand(t, tempDir, "-s", server.URL, "add", "gun", "v1", filepath.Join(tempDir, "config.json"))
+	assert.NoError(t, err)
+	_, err = runCommand(t, tempDir, "-s", server.URL, "publish", "gun")
+	assert.NoError(t, err)
+
+	// the timestamp expires within a few weeks
+	past := time.Now().Add(-time.Hour).Format(time.RFC3339)
+	future := time.Now().AddDate(0, 2, 0).Format(time.RFC3339)
+
+	output, err := runCommand(t, tempDir, "-s", server.URL, "list", "gun", "--as-of", past)
+	assert.NoError(t, err)
+	assert.Contains(t, output, "v1")
+	_, err = runCommand(t, tempDir, "-s", server.URL, "lookup", "gun", "v1", "--as-of", past)
+	assert.NoError(t, err)
+
+	_, err = runCommand(t, tempDir, "-s", server.URL, "list", "gun", "--as-of", future)
+	assert.Error(t, err)
+	_, err = runCommand(t, tempDir, "-s", server.URL, "lookup", "gun", "v1", "--as-of", future)
+	assert.Error(t, err)
+
+	_, err = runCommand(t, tempDir, "-s", server.URL, "list", "gun", "--as-of", "yesterday")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "RFC 3339")
+
+	_, err = runCommand(t, tempDir, "-s", server.URL, "list", "gun")
+	assert.NoError(t, err)
+}
+
+// key roles lists the delegations that have a key, by either of its IDs
+func TestClientKeyRotateExpiring(t *testing.T) {
+	setUp(t)
+
+	tempDir := tempDirWithConfig(t, "{}")
+	defer os.RemoveAll(tempDir)
+
+	server := setupServer()
+	defer server.Close()
+
+	// the first key expires in 10 days, the others in 10 and 20 years
+	var certFiles, keyIDs []string
+	validFor := []time.Duration{10 * 24 * time.Hour, 10 * 365 * 24 * time.Hour, 20 * 365 * 24 * time.Hour}
+	for i, name := range []string{"delegate1.crt", "delegate2.crt", "delegate3.crt"} {
+		privKey, err := trustmanager.GenerateECDSAKey(rand.Reader)
+		assert.NoError(t, err)
+		startTime := time.Now()
+		cert, err := cryptoservice.GenerateCertificate(privKey, "gun", startTime, startTime.Add(validFor[i]))
+		assert.NoError(t, err)
+		certFile := filepath.Join(tempDir, name)
+		assert.NoError(t, ioutil.WriteFile(certFile, trustmanager.CertToPEM(cert), 0644))
+		keyID, err := utils.CanonicalKeyID(trustmanager.CertToKey(cert))
+		assert.NoError(t, err)
+		certFiles = append(certFiles, certFile)
+		keyIDs = append(keyIDs, keyID)
+	}
+
+	_, err := runCommand(t, tempDir, "-s", server.URL, "init", "gun")
+	assert.NoError(t, err)
+	_, err = runCommand(t, tempDir, "delegation", "add", "gun", "targets/a", certFiles[0], "--all-paths")
+	assert.NoError(t, err)
+	_, err = runCommand(t, tempDir, "delegation", "add", "gun", "targets/b", certFiles[0], certFiles[1], "--all-paths")
+	assert.NoError(t, err)
+	_, err = runCommand(t, tempDir, "-s", server.URL, "publish", "gun")
+	assert.NoError(t, err)
+
+	output, err := runCommand(t, tempDir, "-s", server.URL, "key", "rotate-expiring", "gun", "--within", "1d")
+	assert.NoError(t, err)
+	assert.Contains(t, output, "No delegation keys of gun expire within 1d")
+
+	_, err = runCommand(t, tempDir, "-s", server.URL, "key", "rotate-expiring", "gun", "--within", "soon")
+	assert.Error(t, err)
+	_, err = runCommand(t, tempDir, "-s", server.URL, "key", "rotate-expiring", "gun",
+		"--replace", keyIDs[1]+"="+certFiles[2])
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "not a delegation key that expires within 30d")
+
+	// without confirmation nothing is staged
+	output, err = runCommand(t, tempDir, "-s", server.URL, "key", "rotate-expiring", "gun")
+	assert.Error(t, err)
+	assert.Contains(t, output, keyIDs[0])
+	assert.Contains(t, output, "targets/a, targets/b")
+	assert.NotContains(t, output, keyIDs[1])
+	output, err = runCommand(t, tempDir, "status", "gun")
+	assert.NoError(t, err)
+	assert.Contains(t, output, "No unpublished changes for gun")
+
+	output, err = runCommand(t, tempDir, "-s", server.URL, "key", "rotate-expiring", "gun", "-y")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, strings.Count(output, "Replacement of key "+keyIDs[0]))
+	_, err = runCommand(t, tempDir, "-s", server.URL, "publish", "gun")
+	assert.NoError(t, err)
+
+	output, err = runCommand(t, tempDir, "-s", server.URL, "key", "roles", "gun", keyIDs[0])
+	assert.NoError(t, err)
+	assert.Contains(t, output, "is not a key of any delegation")
+	output, err = runCommand(t, tempDir, "-s", server.URL, "key", "rotate-expiring", "gun")
+	assert.NoError(t, err)
+	assert.Contains(t, output, "No delegation keys of gun expire within 30d")
+
+	// a replacement has to outlast the window
+	_, err = runCommand(t, tempDir, "-s", server.URL, "key", "rotate-expiring", "gun", "--within", "7500d",
+		"--replace", keyIDs[1]+"="+certFiles[2], "-y")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "also expires")
+
+	output, err = runCommand(t, tempDir, "-s", server.URL, "key", "rotate-expiring", "gun", "--within", "5000d",
+		"--replace", keyIDs[1]+"="+certFiles[2], "-y")
+	assert.NoError(t, err)
+	assert.Contains(t, output, "Replacement of key "+keyIDs[1]+" with key "+keyIDs[2]+" in delegation role targets/b")
+	_, err = runCommand(t, tempDir, "-s", server.URL, "publish", "gun")
+	assert.NoError(t, err)
+
+	output, err = runCommand(t, tempDir, "-s", server.URL, "key", "roles", "gun", keyIDs[2])
+	assert.NoError(t, err)
+	assert.Contains(t, output, "targets/b")
+	assert.NotContains(t, output, "targets/a")
+}
+
+func TestClientKeyRoles(t *testing.T) {
+	setUp(t)
+
+	tempDir := tempDirWithConfig(t, "{}")
+	defer os.RemoveAll(tempDir)
+
+	server := setupServer()
+	defer server.Close()
+
+	var certFiles, keyIDs []string
+	for _, name := range []string{"delegate1.crt", "delegate2.crt"} {
+		privKey, err := trustmanager.GenerateECDSAKey(rand.Reader)
+		assert.NoError(t, err)
+		startTime := time.Now()
+		cert, err := cryptoservice.GenerateCertificate(privKey, "gun", startTime, startTime.AddDate(10, 0, 0))
+		assert.NoError(t, err)
+		certFile := filepath.Join(tempDir, name)
+		assert.NoError(t, ioutil.WriteFile(certFile, trustmanager.CertToPEM(cert), 0644))
+		keyID, err := utils.CanonicalKeyID(trustmanager.CertToKey(cert))
+		assert.NoError(t, err)
+		certFiles = append(certFiles, certFile)
+		keyIDs = append(keyIDs, keyID)
+	}
+
+	_, err := runCommand(t, tempDir, "-s", server.URL, "init", "gun")
+	assert.NoError(t, err)
+	_, err = runCommand(t, tempDir, "delegation", "add", "gun", "targets/a", certFiles[0], "--paths", "a/")
+	assert.NoError(t, err)
+	_, err = runCommand(t, tempDir, "delegation", "add", "gun", "targets/b", certFiles[0], certFiles[1], "--all-paths")
+	assert.NoError(t, err)
+	_, err = runCommand(t, tempDir, "delegation", "add", "gun", "targets/c", certFiles[1], "--paths", "c/")
+	assert.NoError(t, err)
+	_, err = runCommand(t, tempDir, "-s", server.URL, "publish", "gun")
+	assert.NoError(t, err)
+
+	output, err := runCommand(t, tempDir, "-s", server.URL, "key", "roles", "gun", keyIDs[0], "--json")
+	assert.NoError(t, err)
+	var roles []struct {
+		Name      string   `json:"name"`
+		Paths     []string `json:"paths"`
+		Threshold int      `json:"threshold"`
+	}
+	assert.NoError(t, json.Unmarshal([]byte(output), &roles))
+	var names []string
+	for _, role := range roles {
+		names = append(names, role.Name)
+	}
+	assert.Equal(t, []string{"targets/a", "targets/b"}, names)
+	assert.Equal(t, []string{"a/"}, roles[0].Paths)
+	assert.Equal(t, 1, roles[1].Threshold)
+
+	output, err = runCommand(t, tempDir, "-s", server.URL, "key", "roles", "gun", keyIDs[1])
+	assert.NoError(t, err)
+	assert.Contains(t, output, "targets/b")
+	assert.Contains(t, output, "targets/c")
+	assert.NotContains(t, output, "targets/a")
+
+	output, err = runCommand(t, tempDir, "-s", server.URL, "key", "roles", "gun", "nosuchkey")
+	assert.NoError(t, err)
+	assert.Contains(t, output, "is not a key of any delegation")
+
+	_, err = runCommand(t, tempDir, "-s", server.URL, "key", "roles", "gun")
+	assert.Error(t, err)
+}
+
+// delegation graph prints the delegation tree as a DOT graph or as JSON
+func TestClientDelegationGraph(t *testing.T) {
+	setUp(t)
+
+	tempDir := tempDirWithConfig(t, "{}")
+	defer os.RemoveAll(tempDir)
+
+	server := setupServer()
+	defer server.Close()
+
+	privKey, err := trustmanager.GenerateECDSAKey(rand.Reader)
+	assert.NoError(t, err)
+	startTime := time.Now()
+	cert, err := cryptoservice.GenerateCertificate(privKey, "gun", startTime, startTime.AddDate(10, 0, 0))
+	assert.NoError(t, err)
+	certFile := filepath.Join(tempDir, "delegate.crt")
+	assert.NoError(t, ioutil.WriteFile(certFile, trustmanager.CertToPEM(cert), 0644))
+
+	_, err = runCommand(t, tempDir, "-s", server.URL, "init", "gun")
+	assert.NoError(t, err)
+	_, err = runCommand(t, tempDir, "delegation", "add", "gun", "targets/a", certFile, "--all-paths")
+	assert.NoError(t, err)
+	_, err = runCommand(t, tempDir, "delegation", "add", "gun", "targets/b", certFile, "--paths", "b/")
+	assert.NoError(t, err)
+	_, err = runCommand(t, tempDir, "-s", server.URL, "publish", "gun")
+	assert.NoError(t, err)
+
+	output, err := runCommand(t, tempDir, "-s", server.URL, "delegation", "graph", "gun")
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(output, `digraph "gun" {`))
+	assert.Contains(t, output, `"targets" -> "targets/a" [label="1 of 1"];`)
+	assert.Contains(t, output, `"targets" -> "targets/b" [label="1 of 1"];`)
+
+	output, err = runCommand(t, tempDir, "-s", server.URL, "delegation", "graph", "gun", "--json")
+	assert.NoError(t, err)
+	var tree delegationNode
+	assert.NoError(t, json.Unmarshal([]byte(output), &tree))
+	assert.Equal(t, data.CanonicalTargetsRole, tree.Name)
+	assert.Len(t, tree.Children, 2)
+	assert.Equal(t, "targets/b", tree.Children[1].Name)
+	assert.Equal(t, []string{"b/"}, tree.Children[1].Paths)
+	assert.Equal(t, 1, tree.Children[1].Threshold)
+}
+
+// Delegation coverage lists the delegations that can sign each path, and
+// flags the paths that only targets can sign
+func TestClientDelegationCoverage(t *testing.T) {
+	setUp(t)
+
+	tempDir := tempDirWithConfig(t, "{}")
+	defer os.RemoveAll(tempDir)
+
+	server := setupServer()
+	defer server.Close()
+
+	privKey, err := trustmanager.GenerateECDSAKey(rand.Reader)
+	assert.NoError(t, err)
+	startTime := time.Now()
+	cert, err := cryptoservice.GenerateCertificate(privKey, "gun", startTime, startTime.AddDate(10, 0, 0))
+	assert.NoError(t, err)
+	certFile := filepath.Join(tempDir, "delegate.crt")
+	assert.NoError(t, ioutil.WriteFile(certFile, trustmanager.CertToPEM(cert), 0644))
+
+	_, err = runCommand(t, tempDir, "-s", server.URL, "init", "gun")
+	assert.NoError(t, err)
+	_, err = runCommand(t, tempDir, "delegation", "add", "gun", "targets/releases", certFile, "--paths", "releases/")
+	assert.NoError(t, err)
+	_, err = runCommand(t, tempDir, "-s", server.URL, "publish", "gun")
+	assert.NoError(t, err)
+
+	_, err = runCommand(t, tempDir, "-s", server.URL, "delegation", "coverage", "gun")
+	assert.Error(t, err)
+
+	output, err := runCommand(t, tempDir, "-s", server.URL, "delegation", "coverage", "gun", "releases/v1", "docs/index")
+	assert.NoError(t, err)
+	assert.Contains(t, output, "targets/releases")
+	assert.Contains(t, output, "only targets can sign")
+
+	output, err = runCommand(t, tempDir, "-s", server.URL, "delegation", "coverage", "gun", "releases/v1", "docs/index", "--json")
+	assert.NoError(t, err)
+	var signers []pathSigners
+	assert.NoError(t, json.Unmarshal([]byte(output), &signers))
+	assert.Equal(t, []pathSigners{
+		{Path: "releases/v1", Roles: []string{"targets/releases"}},
+		{Path: "docs/index", Roles: []string{}, TargetsOnly: true},
+	}, signers)
+}
+
+// With key_index enabled, every command that changes the keys updates the
+// index of the keys in the trust directory, which is not written otherwise
+func TestClientKeyIndex(t *testing.T) {
+	setUp(t)
+
+	tempDir := tempDirWithConfig(t, "{}")
+	defer os.RemoveAll(tempDir)
+	indexFile := filepath.Join(tempDir, notary.PrivDir, trustmanager.KeyIndexFile)
+
+	_, err := runCommand(t, tempDir, "key", "generate", data.ECDSAKey)
+	assert.NoError(t, err)
+	_, err = os.Stat(indexFile)
+	assert.True(t, os.IsNotExist(err))
+
+	indexedDir := tempDirWithConfig(t, `{"key_index": true}`)
+	defer os.RemoveAll(indexedDir)
+	indexFile = filepath.Join(indexedDir, notary.PrivDir, trustmanager.KeyIndexFile)
+
+	_, err = runCommand(t, indexedDir, "key", "generate", data.ECDSAKey)
+	assert.NoError(t, err)
+	index, err := trustmanager.KeyIndex(indexedDir)
+	assert.NoError(t, err)
+	assert.Len(t, index[data.CanonicalRootRole], 1)
+	indexJSON, err := ioutil.ReadFile(indexFile)
+	assert.NoError(t, err)
+	assert.Contains(t, string(indexJSON), index[data.CanonicalRootRole][0].File)
+
+	_, err = runCommand(t, indexedDir, "key", "generate", data.ECDSAKey)
+	assert.NoError(t, err)
+	var written map[string][]trustmanager.KeyIndexEntry
+	indexJSON, err = ioutil.ReadFile(indexFile)
+	assert.NoError(t, err)
+	assert.NoError(t, json.Unmarshal(indexJSON, &written))
+	assert.Len(t, written[data.CanonicalRootRole], 2)
+}
+
+// trust signatures lists the key that signed the metadata of each role and
+// whether its signature verifies
+func TestClientTrustSignatures(t *testing.T) {
+	setUp(t)
+
+	tempDir := tempDirWithConfig(t, "{}")
+	defer os.RemoveAll(tempDir)
+
+	server := setupServer()
+	defer server.Close()
+
+	_, err := runCommand(t, tempDir, "-s", server.URL, "trust", "signatures")
+	assert.Error(t, err)
+
+	_, err = runCommand(t, tempDir, "-s", server.URL, "init", "gun")
+	assert.NoError(t, err)
+	_, err = runCommand(t, tempDir, "-s", server.URL, "publish", "gun")
+	assert.NoError(t, err)
+
+	output, err := runCommand(t, tempDir, "-s", server.URL, "trust", "signatures", "gun")
+	assert.NoError(t, err)
+	for _, role := range data.BaseRoles {
+		assert.Contains(t, output, role)
+	}
+	assert.Contains(t, output, notaryclient.SignatureValid)
+	assert.NotContains(t, output, "WARNING")
+
+	output, err = runCommand(t, tempDir, "-s", server.URL, "trust", "signatures", "gun", "--json")
+	assert.NoError(t, err)
+	var checks []notaryclient.RoleSignatureChecks
+	assert.NoError(t, json.Unmarshal([]byte(output), &checks))
+	assert.Len(t, checks, len(data.BaseRoles))
+	for _, c := range checks {
+		assert.Len(t, c.Signatures, 1, c.Role)
+		assert.Equal(t, notaryclient.SignatureValid, c.Signatures[0].Status, c.Role)
+	}
+}
+
+// delegation add --path-exact lets the role sign exactly the given paths, but
+// not with prefixes as well, and delegation list marks them
+func TestClientDelegationExactPaths(t *testing.T) {
+	setUp(t)
+
+	tempDir := tempDirWithConfig(t, "{}")
+	defer os.RemoveAll(tempDir)
+
+	server := setupServer()
+	defer server.Close()
+
+	privKey, err := trustmanager.GenerateECDSAKey(rand.Reader)
+	assert.NoError(t, err)
+	startTime := time.Now()
+	cert, err := cryptoservice.GenerateCertificate(privKey, "gun", startTime, startTime.AddDate(10, 0, 0))
+	assert.NoError(t, err)
+	certFile := filepath.Join(tempDir, "delegate.crt")
+	assert.NoError(t, ioutil.WriteFile(certFile, trustmanager.CertToPEM(cert), 0644))
+
+	_, err = runCommand(t, tempDir, "-s", server.URL, "init", "gun")
+	assert.NoError(t, err)
+	_, err = runCommand(t, tempDir, "delegation", "add", "gun", "targets/releases", certFile, "--path-exact", "")
+	assert.Error(t, err)
+
+	_, err = runCommand(t, tempDir, "delegation", "add", "gun", "targets/releases", certFile,
+		"--path-prefix", "docs/", "--path-exact", "releases/v1")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "--path-exact cannot be used with --paths")
+
+	output, err := runCommand(t, tempDir, "delegation", "add", "gun", "targets/releases", certFile,
+		"--path-exact", "releases/v1")
+	assert.NoError(t, err)
+	assert.Contains(t, output, "with exact paths [releases/v1]")
+	_, err = runCommand(t, tempDir, "-s", server.URL, "publish", "gun")
+	assert.NoError(t, err)
+
+	output, err = runCommand(t, tempDir, "-s", server.URL, "delegation", "list", "gun")
+	assert.NoError(t, err)
+	assert.Contains(t, output, "sha256:"+data.PathHex("releases/v1")+" <exact path>")
+
+	output, err = runCommand(t, tempDir, "-s", server.URL, "delegation", "coverage", "gun",
+		"releases/v1", "releases/v1.1", "docs/index", "--json")
+	assert.NoError(t, err)
+	var signers []pathSigners
+	assert.NoError(t, json.Unmarshal([]byte(output), &signers))
+	assert.Equal(t, []pathSigners{
+		{Path: "releases/v1", Roles: []string{"targets/releases"}},
+		{Path: "releases/v1.1", Roles: []string{}, TargetsOnly: true},
+		{Path: "docs/index", Roles: []string{}, TargetsOnly: true},
+	}, signers)
+}
+
+// delegation add stores the notes given with the paths, which delegation list
+// shows with --verbose only
+func TestClientDelegationPathNotes(t *testing.T) {
+	setUp(t)
+
+	tempDir := tempDirWithConfig(t, "{}")
+	defer os.RemoveAll(tempDir)
+
+	server := setupServer()
+	defer server.Close()
+
+	privKey, err := trustmanager.GenerateECDSAKey(rand.Reader)
+	assert.NoError(t, err)
+	startTime := time.Now()
+	cert, err := cryptoservice.GenerateCertificate(privKey, "gun", startTime, startTime.AddDate(10, 0, 0))
+	assert.NoError(t, err)
+	certFile := filepath.Join(tempDir, "delegate.crt")
+	assert.NoError(t, ioutil.WriteFile(certFile, trustmanager.CertToPEM(cert), 0644))
+
+	_, err = runCommand(t, tempDir, "-s", server.URL, "init", "gun")
+	assert.NoError(t, err)
+	// a note has to be on a path that is being added, and cannot be on the
+	// empty path that delegates every target
+	_, err = runCommand(t, tempDir, "delegation", "add", "gun", "targets/releases", certFile,
+		"--paths", "docs/", "--path-note", "releases/=signed by the release team")
+	assert.Error(t, err)
+	_, err = runCommand(t, tempDir, "delegation", "add", "gun", "targets/releases", certFile,
+		"--paths", "docs/", "--path-note", "=signed by the release team")
+	assert.Error(t, err)
+
+	output, err := runCommand(t, tempDir, "delegation", "add", "gun", "targets/releases", certFile,
+		"--paths", "docs/,releases/,@scope/pkg,img@sha256",
+		"--path-note", "releases/=signed by the release team, and nobody else")
+	assert.NoError(t, err)
+	assert.Contains(t, output, "with paths [@scope/pkg,docs/,img@sha256,releases/]")
+	assert.Contains(t, output, "with notes on 1 paths")
+	_, err = runCommand(t, tempDir, "-s", server.URL, "publish", "gun")
+	assert.NoError(t, err)
+
+	output, err = runCommand(t, tempDir, "-s", server.URL, "delegation", "list", "gun")
+	assert.NoError(t, err)
+	assert.Contains(t, output, "@scope/pkg")
+	assert.Contains(t, output, "img@sha256")
+	assert.NotContains(t, output, "<all paths>")
+	assert.NotContains(t, output, "signed by the release team")
+
+	output, err = runCommand(t, tempDir, "-s", server.URL, "--verbose", "delegation", "list", "gun")
+	assert.NoError(t, err)
+	assert.Contains(t, output, "Path notes:")
+	assert.Contains(t, output, "signed by the release team, and nobody else")
+}
+
+// Adding a key that the delegation role already has, published or staged,
+// skips it unless --allow-duplicate is given
+func TestClientDelegationAddDuplicateKey(t *testing.T) {
+	setUp(t)
+
+	tempDir := tempDirWithConfig(t, "{}")
+	defer os.RemoveAll(tempDir)
+
+	server := setupServer()
+	defer server.Close()
+
+	var certFiles, keyIDs []string
+	for _, name := range []string{"delegate1.crt", "delegate2.crt"} {
+		privKey, err := trustmanager.GenerateECDSAKey(rand.Reader)
+		assert.NoError(t, err)
+		startTime := time.Now()
+		cert, err := cryptoservice.GenerateCertificate(privKey, "gun", startTime, startTime.AddDate(10, 0, 0))
+		assert.NoError(t, err)
+		certFile := filepath.Join(tempDir, name)
+		assert.NoError(t, ioutil.WriteFile(certFile, trustmanager.CertToPEM(cert), 0644))
+		keyID, err := utils.CanonicalKeyID(trustmanager.CertToKey(cert))
+		assert.NoError(t, err)
+		certFiles = append(certFiles, certFile)
+		keyIDs = append(keyIDs, keyID)
+	}
+
+	_, err := runCommand(t, tempDir, "-s", server.URL, "init", "gun")
+	assert.NoError(t, err)
+	_, err = runCommand(t, tempDir, "delegation", "add", "gun", "targets/a", certFiles[0], "--all-paths")
+	assert.NoError(t, err)
+
+	// the staged key is skipped
+	output, err := runCommand(t, tempDir, "delegation", "add", "gun", "targets/a", certFiles[0])
+	assert.NoError(t, err)
+	assert.Contains(t, output, "Key "+keyIDs[0]+" is already a key of delegation role targets/a")
+	assert.Contains(t, output, "Nothing to add")
+	output, err = runCommand(t, tempDir, "status", "gun")
+	assert.NoError(t, err)
+	// one change for the key and one for the paths
+	assert.Equal(t, 2, strings.Count(output, "targets/a"))
+
+	// add works offline, so the published keys are known once they have been
+	// downloaded
+	_, err = runCommand(t, tempDir, "-s", server.URL, "publish", "gun")
+	assert.NoError(t, err)
+	_, err = runCommand(t, tempDir, "-s", server.URL, "list", "gun")
+	assert.NoError(t, err)
+
+	// the published key is skipped, but the new one is added
+	output, err = runCommand(t, tempDir, "delegation", "add", "gun", "targets/a", certFiles[0], certFiles[1])
+	assert.NoError(t, err)
+	assert.Contains(t, output, "Key "+keyIDs[0]+" is already a key of delegation role targets/a")
+	assert.NotContains(t, output, "Key "+keyIDs[1]+" is already")
+	assert.Contains(t, output, "staged for next publish")
+
+	// unless told otherwise
+	output, err = runCommand(t, tempDir, "delegation", "add", "gun", "targets/a", certFiles[0], "--allow-duplicate")
+	assert.NoError(t, err)
+	assert.NotContains(t, output, "already a key")
+	assert.Contains(t, output, "staged for next publish")
+}
+
+// key prune only lists the unused delegation keys, unless told to delete them
+func TestClientKeyPrune(t *testing.T) {
+	setUp(t)
+
+	tempDir := tempDirWithConfig(t, "{}")
+	defer os.RemoveAll(tempDir)
+
+	server := setupServer()
+	defer server.Close()
+
+	_, err := runCommand(t, tempDir, "-s", server.URL, "init", "gun")
+	assert.NoError(t, err)
+	_, err = runCommand(t, tempDir, "-s", server.URL, "publish", "gun")
+	assert.NoError(t, err)
+
+	output, err := runCommand(t, tempDir, "-s", server.URL, "key", "prune", "gun")
+	assert.NoError(t, err)
+	assert.Contains(t, output, "No unused delegation keys")
+
+	// a delegation key that was never added to a delegation
+	certFile := filepath.Join(tempDir, "delegation.crt")
+	_, err = runCommand(t, tempDir, "key", "generate-delegation", "gun", "targets/unused", certFile)
+	assert.NoError(t, err)
+	_, signing := assertNumKeys(t, tempDir, 1, 3, true)
+
+	output, err = runCommand(t, tempDir, "-s", server.URL, "key", "prune", "gun")
+	assert.NoError(t, err)
+	assert.Contains(t, output, "--delete")
+	assertNumKeys(t, tempDir, 1, 3, true)
+
+	output, err = runCommand(t, tempDir, "-s", server.URL, "key", "prune", "gun", "--delete")
+	assert.NoError(t, err)
+	assert.Contains(t, output, "Removed unused delegation key")
+	_, remaining := assertNumKeys(t, tempDir, 1, 2, true)
+	for _, keyID := range remaining {
+		assert.Contains(t, signing, keyID)
+		assert.NotContains(t, output, keyID)
+	}
+}
+
+// key associate adds a stored key to another delegation, as the certificate
+// another delegation already has for it, and the key can then sign that role
+func TestClientKeyAssociate(t *testing.T) {
+	setUp(t)
+
+	tempDir := tempDirWithConfig(t, "{}")
+	defer os.RemoveAll(tempDir)
+
+	server := setupServer()
+	defer server.Close()
+
+	_, err := runCommand(t, tempDir, "-s", server.URL, "init", "gun")
+	assert.NoError(t, err)
+	certFile := filepath.Join(tempDir, "delegation.crt")
+	output, err := runCommand(t, tempDir, "key", "generate-delegation", "gun", "targets/a", certFile)
+	assert.NoError(t, err)
+	keyID := strings.TrimSpace(output[strings.Index(output, "keyID: ")+len("keyID: "):])
+	keyID = strings.Fields(keyID)[0]
+	_, err = runCommand(t, tempDir, "delegation", "add", "gun", "targets/a", certFile, "--all-paths")
+	assert.NoError(t, err)
+	_, err = runCommand(t, tempDir, "-s", server.URL, "publish", "gun")
+	assert.NoError(t, err)
+
+	// the key has to be stored, and the role has to be a delegation
+	_, err = runCommand(t, tempDir, "-s", server.URL, "key", "associate", "nonexistent", "gun", "targets/b")
+	assert.Error(t, err)
+	_, err = runCommand(t, tempDir, "-s", server.URL, "key", "associate", keyID, "gun", data.CanonicalTargetsRole)
+	assert.Error(t, err)
+	// the delegations can't be checked for a certificate of the key without
+	// the server, rather than the bare key being added instead
+	_, err = runCommand(t, tempDir, "-s", "https://127.0.0.1:9", "key", "associate", keyID, "gun", "targets/b")
+	assert.Error(t, err)
+
+	output, err = runCommand(t, tempDir, "-s", server.URL, "key", "associate", keyID, "gun", "targets/b")
+	assert.NoError(t, err)
+	assert.Contains(t, output, "staged for next publish")
+	_, err = runCommand(t, tempDir, "delegation", "add", "gun", "targets/b", "--all-paths")
+	assert.NoError(t, err)
+	_, err = runCommand(t, tempDir, "-s", server.URL, "publish", "gun")
+	assert.NoError(t, err)
+
+	output, err = runCommand(t, tempDir, "-s", server.URL, "delegation", "info", "gun", "targets/b", "--json")
+	assert.NoError(t, err)
+	var info struct {
+		Keys []struct {
+			ID        string `json:"id"`
+			Algorithm string `json:"algorithm"`
+		} `json:"keys"`
+	}
+	assert.NoError(t, json.Unmarshal([]byte(output), &info))
+	assert.Len(t, info.Keys, 1)
+	assert.Equal(t, keyID, info.Keys[0].ID)
+	assert.Equal(t, data.ECDSAx509Key, info.Keys[0].Algorithm)
+
+	// the key can sign the role it was associated with
+	tempFile, err := ioutil.TempFile("", "targetfile")
+	assert.NoError(t, err)
+	tempFile.Close()
+	defer os.Remove(tempFile.Name())
+	_, err = runCommand(t, tempDir, "add", "gun", "target", tempFile.Name(), "--roles", "targets/b")
+	assert.NoError(t, err)
+	_, err = runCommand(t, tempDir, "-s", server.URL, "publish", "gun")
+	assert.NoError(t, err)
+	output, err = runCommand(t, tempDir, "-s", server.URL, "list", "gun", "--roles", "targets/b")
+	assert.NoError(t, err)
+	assert.Contains(t, output, "targets/b")
+}
+
+// metadata larger than the maximum size for its role is not downloaded
+func TestClientMaxMetadataSize(t *testing.T) {
+	setUp(t)
+
+	tempDir := tempDirWithConfig(t, "{}")
+	defer os.RemoveAll(tempDir)
+
+	server := setupServer()
+	defer server.Close()
+
+	tempFile, err := ioutil.TempFile("", "targetfile")
+	assert.NoError(t, err)
+	tempFile.Close()
+	defer os.Remove(tempFile.Name())
+
+	_, err = runCommand(t, tempDir, "-s", server.URL, "init", "gun")
+	assert.NoError(t, err)
+	_, err = runCommand(t, tempDir, "add", "gun", "v1", tempFile.Name())
+	assert.NoError(t, err)
+	_, err = runCommand(t, tempDir, "-s", server.URL, "publish", "gun")
+	assert.NoError(t, err)
+
+	_, err = runCommand(t, tempDir, "-s", server.URL, "list", "gun", "--no-cache", "--max-metadata-size", "targets=100")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "larger than the maximum size of 100 bytes")
+
+	output, err := runCommand(t, tempDir, "-s", server.URL, "list", "gun", "--no-cache", "--max-metadata-size", "targets=1MiB")
+	assert.NoError(t, err)
+	assert.Contains(t, output, "v1")
+}
+
+// key commands and the signing keys of publish accept a prefix of a key ID or
+// the alias of a key instead of the full ID, as long as only one key matches
+func TestClientKeyByPrefixOrAlias(t *testing.T) {
+	setUp(t)
+	var target = "sdgkadga"
+
+	tempDir := tempDirWithConfig(t, "{}")
+	defer os.RemoveAll(tempDir)
+
+	server := setupServer()
+	defer server.Close()
+
+	_, err := runCommand(t, tempDir, "-s", server.URL, "init", "gun")
+	assert.NoError(t, err)
+	rootKeyIDs, _ := assertNumKeys(t, tempDir, 1, 2, true)
+
+	for _, name := range []string{rootKeyIDs[0][:12], data.CanonicalRootRole} {
+		output, err := runCommand(t, tempDir, "key", "check-passphrase", name)
+		assert.NoError(t, err, "checking the passphrase of %s", name)
+		assert.Contains(t, output, rootKeyIDs[0])
+	}
+
+	// with a second repository there are two targets keys
+	_, err = runCommand(t, tempDir, "-s", server.URL, "init", "gun2")
+	assert.NoError(t, err)
+	_, err = runCommand(t, tempDir, "key", "check-passphrase", data.CanonicalTargetsRole)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "matches more than one key")
+	_, err = runCommand(t, tempDir, "key", "check-passphrase", "0000000000")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "could not find a local key")
+
+	fileStore, err := trustmanager.NewKeyFileStore(tempDir, passphrase.ConstantRetriever(testPassphrase))
+	assert.NoError(t, err)
+	var targetsKeyID string
+	for keyPath, role := range fileStore.ListKeys() {
+		if role == data.CanonicalTargetsRole && filepath.Dir(keyPath) == "gun" {
+			targetsKeyID = filepath.Base(keyPath)
+		}
+	}
+	assert.NotEmpty(t, targetsKeyID)
+
+	tempFile, err := ioutil.TempFile("", "targetfile")
+	assert.NoError(t, err)
+	tempFile.Close()
+	defer os.Remove(tempFile.Name())
+	_, err = runCommand(t, tempDir, "add", "gun", target, tempFile.Name())
+	assert.NoError(t, err)
+	_, err = runCommand(t, tempDir, "-s", server.URL, "publish", "gun", "--signing-key", targetsKeyID[:12])
+	assert.NoError(t, err)
+	output, err := runCommand(t, tempDir, "-s", server.URL, "list", "gun")
+	assert.NoError(t, err)
+	assert.Contains(t, output, target)
+}
+
+// Initialize repo and test delegations commands by adding, listing, and removing delegations
+func TestClientDelegationsInteraction(t *testing.T) {
+	setUp(t)
+
+	tempDir := tempDirWithConfig(t, "{}")
+	defer os.RemoveAll(tempDir)
+
+	server := setupServer()
+	defer server.Close()
+
+	// Setup certificate
+	tempFile, err := ioutil.TempFile("", "pemfile")
+	assert.NoError(t, err)
+
+	privKey, err := trustmanager.GenerateECDSAKey(rand.Reader)
+	startTime := time.Now()
+	endTime := startTime.AddDate(10, 0, 0)
+	cert, err := cryptoservice.GenerateCertificate(privKey, "gun", startTime, endTime)
+	assert.NoError(t, err)
+
+	_, err = tempFile.Write(trustmanager.CertToPEM(cert))
+	assert.NoError(t, err)
+	tempFile.Close()
+	defer os.Remove(tempFile.Name())
+
+	rawPubBytes, _ := ioutil.ReadFile(tempFile.Name())
+	parsedPubKey, _ := trustmanager.ParsePEMPublicKey(rawPubBytes)
+	keyID, err := utils.CanonicalKeyID(parsedPubKey)
+	assert.NoError(t, err)
+
+	var output string
+
+	// -- tests --
+
+	// init repo
+	_, err = runCommand(t, tempDir, "-s", server.URL, "init", "gun")
+	assert.NoError(t, err)
+
+	// publish repo
+	_, err = runCommand(t, tempDir, "-s", server.URL, "publish", "gun")
+	assert.NoError(t, err)
+
+	// list delegations - none yet
+	output, err = runCommand(t, tempDir, "-s", server.URL, "delegation", "list", "gun")
+	assert.NoError(t, err)
+	assert.Contains(t, output, "No delegations present in this repository.")
+
+	// list delegations - fails if asked to when there are none
+	output, err = runCommand(t, tempDir, "-s", server.URL, "delegation", "list", "gun", "--fail-if-empty")
+	assert.Error(t, err)
+	assert.Contains(t, output, "No delegations present in this repository.")
+
+	// add new valid delegation with single new cert, and no path
+	output, err = runCommand(t, tempDir, "delegation", "add", "gun", "targets/delegation", tempFile.Name())
+	assert.NoError(t, err)
+	assert.Contains(t, output, "Addition of delegation role")
+	assert.NotContains(t, output, "path")
+
+	// check status - see delegation
+	output, err = runCommand(t, tempDir, "status", "gun")
+	assert.NoError(t, err)
+	assert.Contains(t, output, "Unpublished changes for gun")
+
+	// list delegations - none yet because still unpublished
+	output, err = runCommand(t, tempDir, "-s", server.URL, "delegation", "list", "gun")
+	assert.NoError(t, err)
+	assert.Contains(t, output, "No delegations present in this repository.")
+
+	// publish repo
+	_, err = runCommand(t, tempDir, "-s", server.URL, "publish", "gun")
+	assert.NoError(t, err)
+
+	// check status - no changelist
+	output, err = runCommand(t, tempDir, "status", "gun")
+	assert.NoError(t, err)
+	assert.Contains(t, output, "No unpublished changes for gun")
+
+	// list delegations - we should see our added delegation, with no paths
+	output, err = runCommand(t, tempDir, "-s", server.URL, "delegation", "list", "gun")
+	assert.NoError(t, err)
+	assert.Contains(t, output, "targets/delegation")
+	assert.Contains(t, output, keyID)
+	assert.NotContains(t, output, "\"\"")
+
+	_, err = runCommand(t, tempDir, "-s", server.URL, "delegation", "list", "gun", "--fail-if-empty")
+	assert.NoError(t, err)
+
+	// list delegations - sorted by the expiry of their keys
+	output, err = runCommand(t, tempDir, "-s", server.URL, "delegation", "list", "gun", "--sort", "expiry", "--reverse")
+	assert.NoError(t, err)
+	assert.Contains(t, output, "targets/delegation")
+	_, err = runCommand(t, tempDir, "-s", server.URL, "delegation", "list", "gun", "--sort", "threshold")
+	assert.Error(t, err)
+
+	// list only the names of the delegations, undecorated
+	output, err = runCommand(t, tempDir, "-s", server.URL, "delegation", "list", "gun", "--names-only")
+	assert.NoError(t, err)
+	assert.Equal(t, "targets/delegation\n", output)
+	_, err = runCommand(t, tempDir, "-s", server.URL, "delegation", "list", "gun", "--names-only", "--paths-only")
+	assert.Error(t, err)
+
+	// list each delegation on one line, with tab separated fields
+	output, err = runCommand(t, tempDir, "-s", server.URL, "delegation", "list", "gun", "--compact")
+	assert.NoError(t, err)
+	fields := strings.Split(strings.TrimSuffix(output, "\n"), "\t")
+	assert.Len(t, fields, 4)
+	assert.Equal(t, []string{"targets/delegation", "1", "1"}, fields[:3])
+	_, err = time.Parse(time.RFC3339, fields[3])
+	assert.NoError(t, err)
+	_, err = runCommand(t, tempDir, "-s", server.URL, "delegation", "list", "gun", "--compact", "--json")
+	assert.Error(t, err)
+
+	// list how long it is until the delegation expires
+	output, err = runCommand(t, tempDir, "-s", server.URL, "delegation", "list", "gun", "--expiry-report", "--json")
+	assert.NoError(t, err)
+	var report []roleExpiry
+	assert.NoError(t, json.Unmarshal([]byte(output), &report))
+	assert.Len(t, report, 1)
+	assert.Equal(t, "targets/delegation", report[0].Role)
+	assert.True(t, report[0].ExpiresInSeconds > 0)
+
+	// the expiry report is always of every delegation, soonest first
+	for _, flags := range [][]string{{"--depth", "0"}, {"--sort", "keycount"}, {"--reverse"}} {
+		_, err = runCommand(t, tempDir, append([]string{"-s", server.URL, "delegation", "list", "gun", "--expiry-report"}, flags...)...)
+		assert.Error(t, err, "--expiry-report allowed with %v", flags)
+	}
+
+	// the delegation has its one valid key, which meets its threshold
+	output, err = runCommand(t, tempDir, "-s", server.URL, "delegation", "list", "gun", "--threshold-report", "--json")
+	assert.NoError(t, err)
+	var thresholds []roleThreshold
+	assert.NoError(t, json.Unmarshal([]byte(output), &thresholds))
+	assert.Equal(t, []roleThreshold{{Role: "targets/delegation", Threshold: 1, Keys: 1, ValidKeys: 1}}, thresholds)
+	for _, flags := range [][]string{{"--depth", "0"}, {"--expiry-report"}, {"--names-only"}, {"--limit", "1"}} {
+		_, err = runCommand(t, tempDir, append([]string{"-s", server.URL, "delegation", "list", "gun", "--threshold-report"}, flags...)...)
+		assert.Error(t, err, "--threshold-report allowed with %v", flags)
+	}
+
+	// list delegations to a file - refuses to overwrite the file unless forced to
+	outputFile := filepath.Join(tempDir, "delegations.txt")
+	output, err = runCommand(t, tempDir, "-s", server.URL, "delegation", "list", "gun", "--output-file", outputFile)
+	assert.NoError(t, err)
+	assert.NotContains(t, output, "targets/delegation")
+	fileOutput, err := ioutil.ReadFile(outputFile)
+	assert.NoError(t, err)
+	assert.Contains(t, string(fileOutput), "targets/delegation")
+	assert.Contains(t, string(fileOutput), keyID)
+
+	_, err = runCommand(t, tempDir, "-s", server.URL, "delegation", "list", "gun", "--output-file", outputFile)
+	assert.Error(t, err)
+	_, err = runCommand(t, tempDir, "-s", server.URL, "delegation", "list", "gun", "--output-file", outputFile, "--force")
+	assert.NoError(t, err)
+
+	// show the details of the delegation we added - we have no signing key for it
+	output, err = runCommand(t, tempDir, "-s", server.URL, "delegation", "info", "gun", "targets/delegation")
+	assert.NoError(t, err)
+	assert.Contains(t, output, "targets/delegation")
+	assert.Contains(t, output, keyID)
+	assert.Contains(t, output, data.ECDSAx509Key)
+
+	output, err = runCommand(t, tempDir, "-s", server.URL, "delegation", "info", "gun", "targets/delegation", "--json")
+	assert.NoError(t, err)
+	assert.Contains(t, output, `"can_sign": false`)
+
+	// show the details of a delegation that does not exist
+	_, err = runCommand(t, tempDir, "-s", server.URL, "delegation", "info", "gun", "targets/nope")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "not found")
+
+	// add all paths to this delegation
+	output, err = runCommand(t, tempDir, "delegation", "add", "gun", "targets/delegation", "--all-paths")
+	assert.NoError(t, err)
+	assert.Contains(t, output, "Addition of delegation role")
+	assert.Contains(t, output, "\"\"")
+	assert.Contains(t, output, "<all paths>")
+
+	// publish repo
+	_, err = runCommand(t, tempDir, "-s", server.URL, "publish", "gun")
+	assert.NoError(t, err)
+
+	// list delegations - we should see our added delegation, with no paths
+	output, err = runCommand(t, tempDir, "-s", server.URL, "delegation", "list", "gun")
+	assert.NoError(t, err)
+	assert.Contains(t, output, "targets/delegation")
+	assert.Contains(t, output, "\"\"")
+	assert.Contains(t, output, "<all paths>")
+
+	// Setup another certificate
+	tempFile2, err := ioutil.TempFile("", "pemfile2")
+	assert.NoError(t, err)
+
+	privKey, err = trustmanager.GenerateECDSAKey(rand.Reader)
+	startTime = time.Now()
+	endTime = startTime.AddDate(10, 0, 0)
+	cert, err = cryptoservice.GenerateCertificate(privKey, "gun", startTime, endTime)
+	assert.NoError(t, err)
+
+	_, err = tempFile2.Write(trustmanager.CertToPEM(cert))
+	assert.NoError(t, err)
+	assert.NoError(t, err)
+	tempFile2.Close()
+	defer os.Remove(tempFile2.Name())
+
+	rawPubBytes2, _ := ioutil.ReadFile(tempFile2.Name())
+	parsedPubKey2, _ := trustmanager.ParsePEMPublicKey(rawPubBytes2)
+	keyID2, err := utils.CanonicalKeyID(parsedPubKey2)
+	assert.NoError(t, err)
+
+	// add to the delegation by specifying the same role, this time add a scoped path
+	output, err = runCommand(t, tempDir, "delegation", "add", "gun", "targets/delegation", tempFile2.Name(), "--paths", "path")
+	assert.NoError(t, err)
+	assert.Contains(t, output, "Addition of delegation role")
+
+	// publish repo
+	_, err = runCommand(t, tempDir, "-s", server.URL, "publish", "gun")
+	assert.NoError(t, err)
+
+	// list delegations - we should see two keys
+	output, err = runCommand(t, tempDir, "-s", server.URL, "delegation", "list", "gun")
+	assert.NoError(t, err)
+	assert.Contains(t, output, ",")
+	assert.Contains(t, output, "path")
+	assert.Contains(t, output, keyID)
+	assert.Contains(t, output, keyID2)
+
+	// list which delegations govern each path
+	output, err = runCommand(t, tempDir, "-s", server.URL, "delegation", "list", "gun", "--paths-only", "--json")
+	assert.NoError(t, err)
+	var coverage []pathCoverage
+	assert.NoError(t, json.Unmarshal([]byte(output), &coverage))
+	assert.Len(t, coverage, 2)
+	for _, pc := range coverage {
+		assert.Equal(t, []string{"targets/delegation"}, pc.Roles)
+		assert.Empty(t, pc.Overlaps)
+	}
+	_, err = runCommand(t, tempDir, "-s", server.URL, "delegation", "list", "gun", "--paths-only", "--expiry-report")
+	assert.Error(t, err)
+
+	// remove the delegation's first key
+	output, err = runCommand(t, tempDir, "delegation", "remove", "gun", "targets/delegation", keyID)
+	assert.NoError(t, err)
+	assert.Contains(t, output, "Removal of delegation role")
+
+	// publish repo
+	_, err = runCommand(t, tempDir, "-s", server.URL, "publish", "gun")
+	assert.NoError(t, err)
+
+	// list delegations - we should see the delegation but with only the second key
+	output, err = runCommand(t, tempDir, "-s", server.URL, "delegation", "list", "gun")
+	assert.NoError(t, err)
+	assert.NotContains(t, output, keyID)
+	assert.Contains(t, output, keyID2)
+
+	// remove the delegation's second key
+	output, err = runCommand(t, tempDir, "delegation", "remove", "gun", "targets/delegation", keyID2)
+	assert.NoError(t, err)
+	assert.Contains(t, output, "Removal of delegation role")
+
+	// publish repo
+	_, err = runCommand(t, tempDir, "-s", server.URL, "publish", "gun")
+	assert.NoError(t, err)
+
+	// list delegations - we should see no delegations
+	output, err = runCommand(t, tempDir, "-s", server.URL, "delegation", "list", "gun")
+	assert.NoError(t, err)
+	assert.Contains(t, output, "No delegations present in this repository.")
+
+	// add delegation with multiple certs and multiple paths
+	output, err = runCommand(t, tempDir, "delegation", "add", "gun", "targets/delegation", tempFile.Name(), tempFile2.Name(), "--paths", "path1,path2")
+	assert.NoError(t, err)
+	assert.Contains(t, output, "Addition of delegation role")
+
+	// publish repo
+	_, err = runCommand(t, tempDir, "-s", server.URL, "publish", "gun")
+	assert.NoError(t, err)
+
+	// list delegations - we should see two keys
+	output, err = runCommand(t, tempDir, "-s", server.URL, "delegation", "list", "gun")
+	assert.NoError(t, err)
+	assert.Contains(t, output, ",")
+	assert.Contains(t, output, "path1,path2")
+	assert.Contains(t, output, keyID)
+	assert.Contains(t, output, keyID2)
+
+	// add delegation with multiple certs and multiple paths
+	output, err = runCommand(t, tempDir, "delegation", "add", "gun", "targets/delegation", "--paths", "path3")
+	assert.NoError(t, err)
+	assert.Contains(t, output, "Addition of delegation role")
+
+	// publish repo
+	_, err = runCommand(t, tempDir, "-s", server.URL, "publish", "gun")
+	assert.NoError(t, err)
+
+	// list delegations - we should see two keys
+	output, err = runCommand(t, tempDir, "-s", server.URL, "delegation", "list", "gun")
+	assert.NoError(t, err)
+	assert.Contains(t, output, ",")
+	assert.Contains(t, output, "path1,path2,path3")
+
+	// just remove two paths from this delegation
+	output, err = runCommand(t, tempDir, "delegation", "remove", "gun", "targets/delegation", "--paths", "path2,path3")
+	assert.NoError(t, err)
+	assert.Contains(t, output, "Removal of delegation role")
+
+	// publish repo
+	_, err = runCommand(t, tempDir, "-s", server.URL, "publish", "gun")
+	assert.NoError(t, err)
+
+	// list delegations - we should see the same two keys, and only path1
+	output, err = runCommand(t, tempDir, "-s", server.URL, "delegation", "list", "gun")
+	assert.NoError(t, err)
+	assert.Contains(t, output, ",")
+	assert.Contains(t, output, "path1")
+	assert.NotContains(t, output, "path2")
+	assert.NotContains(t, output, "path3")
+	assert.Contains(t, output, keyID)
+	assert.Contains(t, output, keyID2)
+
+	// remove the remaining path, should not remove the delegation entirely
+	output, err = runCommand(t, tempDir, "delegation", "remove", "gun", "targets/delegation", "--paths", "path1")
+	assert.NoError(t, err)
+	assert.Contains(t, output, "Removal of delegation role")
+
+	// publish repo
+	_, err = runCommand(t, tempDir, "-s", server.URL, "publish", "gun")
+	assert.NoError(t, err)
+
+	// list delegations - we should see the same two keys, and no paths
+	output, err = runCommand(t, tempDir, "-s", server.URL, "delegation", "list", "gun")
+	assert.NoError(t, err)
+	assert.Contains(t, output, ",")
+	assert.NotContains(t, output, "path1")
+	assert.NotContains(t, output, "path2")
+	assert.NotContains(t, output, "path3")
+	assert.Contains(t, output, keyID)
+	assert.Contains(t, output, keyID2)
+
+	// Add a bunch of individual paths so we can test a delegation remove --all-paths
+	output, err = runCommand(t, tempDir, "delegation", "add", "gun", "targets/delegation", "--paths", "abcdef,123456")
+	assert.NoError(t, err)
+
+	// Add more individual paths so we can test a delegation remove --all-paths
+	output, err = runCommand(t, tempDir, "delegation", "add", "gun", "targets/delegation", "--paths", "banana/split,apple/crumble/pie,orange.peel,kiwi")
+	assert.NoError(t, err)
+
+	// publish repo
+	_, err = runCommand(t, tempDir, "-s", server.URL, "publish", "gun")
+	assert.NoError(t, err)
+
+	// list delegations - we should see all of our paths
+	output, err = runCommand(t, tempDir, "-s", server.URL, "delegation", "list", "gun")
+	assert.NoError(t, err)
+	assert.Contains(t, output, "abcdef")
+	assert.Contains(t, output, "123456")
+	assert.Contains(t, output, "banana/split")
+	assert.Contains(t, output, "apple/crumble/pie")
+	assert.Contains(t, output, "orange.peel")
+	assert.Contains(t, output, "kiwi")
+
+	// Try adding "", and check that adding it with other paths clears out the others
+	output, err = runCommand(t, tempDir, "delegation", "add", "gun", "targets/delegation", "--paths", "\"\",grapefruit,pomegranate")
+	assert.NoError(t, err)
+
+	// publish repo
+	_, err = runCommand(t, tempDir, "-s", server.URL, "publish", "gun")
+	assert.NoError(t, err)
+
+	// list delegations - we should see all of our old paths, and ""
+	output, err = runCommand(t, tempDir, "-s", server.URL, "delegation", "list", "gun")
+	assert.NoError(t, err)
+	assert.Contains(t, output, "abcdef")
+	assert.Contains(t, output, "123456")
+	assert.Contains(t, output, "banana/split")
+	assert.Contains(t, output, "apple/crumble/pie")
+	assert.Contains(t, output, "orange.peel")
+	assert.Contains(t, output, "kiwi")
+	assert.Contains(t, output, "\"\"")
+	assert.NotContains(t, output, "grapefruit")
+	assert.NotContains(t, output, "pomegranate")
+
+	// Try removing just ""
+	output, err = runCommand(t, tempDir, "delegation", "remove", "gun", "targets/delegation", "--paths", "\"\"")
+	assert.NoError(t, err)
+
+	// publish repo
+	_, err = runCommand(t, tempDir, "-s", server.URL, "publish", "gun")
+	assert.NoError(t, err)
+
+	// list delegations - we should see all of our old paths without ""
+	output, err = runCommand(t, tempDir, "-s", server.URL, "delegation", "list", "gun")
+	assert.NoError(t, err)
+	assert.Contains(t, output, "abcdef")
+	assert.Contains(t, output, "123456")
+	assert.Contains(t, output, "banana/split")
+	assert.Contains(t, output, "apple/crumble/pie")
+	assert.Contains(t, output, "orange.peel")
+	assert.Contains(t, output, "kiwi")
+	assert.NotContains(t, output, "\"\"")
+
+	// Remove --all-paths to clear out all paths from this delegation
+	output, err = runCommand(t, tempDir, "delegation", "remove", "gun", "targets/delegation", "--all-paths")
+	assert.NoError(t, err)
+
+	// publish repo
+	_, err = runCommand(t, tempDir, "-s", server.URL, "publish", "gun")
+	assert.NoError(t, err)
+
+	// list delegations - we should see all of our paths
+	output, err = runCommand(t, tempDir, "-s", server.URL, "delegation", "list", "gun")
+	assert.NoError(t, err)
+	assert.NotContains(t, output, "abcdef")
+	assert.NotContains(t, output, "123456")
+	assert.NotContains(t, output, "banana/split")
+	assert.NotContains(t, output, "apple/crumble/pie")
+	assert.NotContains(t, output, "orange.peel")
+	assert.NotContains(t, output, "kiwi")
+
+	// Check that we ignore other --paths if we pass in --all-paths on an add
+	output, err = runCommand(t, tempDir, "delegation", "add", "gun", "targets/delegation", "--all-paths", "--paths", "grapefruit,pomegranate")
+	assert.NoError(t, err)
+
+	// publish repo
+	_, err = runCommand(t, tempDir, "-s", server.URL, "publish", "gun")
+	assert.NoError(t, err)
+
+	// list delegations - we should only see "", and not the other paths specified
+	output, err = runCommand(t, tempDir, "-s", server.URL, "delegation", "list", "gun")
+	assert.NoError(t, err)
+	assert.Contains(t, output, "\"\"")
+	assert.NotContains(t, output, "grapefruit")
+	assert.NotContains(t, output, "pomegranate")
+
+	// Add those extra paths we ignored to set up the next test
+	output, err = runCommand(t, tempDir, "delegation", "add", "gun", "targets/delegation", "--paths", "grapefruit,pomegranate")
+	assert.NoError(t, err)
+
+	// publish repo
+	_, err = runCommand(t, tempDir, "-s", server.URL, "publish", "gun")
+	assert.NoError(t, err)
+
+	// Check that we ignore other --paths if we pass in --all-paths on a remove
+	output, err = runCommand(t, tempDir, "delegation", "remove", "gun", "targets/delegation", "--all-paths", "--paths", "pomegranate")
+	assert.NoError(t, err)
+
+	// publish repo
+	_, err = runCommand(t, tempDir, "-s", server.URL, "publish", "gun")
+	assert.NoError(t, err)
+
+	// list delegations - we should see no paths
+	output, err = runCommand(t, tempDir, "-s", server.URL, "delegation", "list", "gun")
+	assert.NoError(t, err)
+	assert.NotContains(t, output, "\"\"")
+	assert.NotContains(t, output, "grapefruit")
+	assert.NotContains(t, output, "pomegranate")
+
+	// remove by force to delete the delegation entirely
+	output, err = runCommand(t, tempDir, "delegation", "remove", "gun", "targets/delegation", "-y")
+	assert.NoError(t, err)
+	assert.Contains(t, output, "Forced removal (including all keys and paths) of delegation role")
+
+	// publish repo
+	_, err = runCommand(t, tempDir, "-s", server.URL, "publish", "gun")
+	assert.NoError(t, err)
+
+	// list delegations - we should see no delegations
+	output, err = runCommand(t, tempDir, "-s", server.URL, "delegation", "list", "gun")
+	assert.NoError(t, err)
+	assert.Contains(t, output, "No delegations present in this repository.")
+}
+
+// Initialize repo and test publishing targets with delegation roles
+func TestClientDelegationsPublishing(t *testing.T) {
+	setUp(t)
+
+	tempDir := tempDirWithConfig(t, "{}")
+	defer os.RemoveAll(tempDir)
+
+	server := setupServer()
+	defer server.Close()
+
+	// Setup certificate for delegation role
+	tempFile, err := ioutil.TempFile("", "pemfile")
+	assert.NoError(t, err)
+
+	privKey, err := trustmanager.GenerateRSAKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	privKeyBytesNoRole, err := trustmanager.KeyToPEM(privKey, "")
+	assert.NoError(t, err)
+	privKeyBytesWithRole, err := trustmanager.KeyToPEM(privKey, "user")
+	assert.NoError(t, err)
+	startTime := time.Now()
+	endTime := startTime.AddDate(10, 0, 0)
+	cert, err := cryptoservice.GenerateCertificate(privKey, "gun", startTime, endTime)
+	assert.NoError(t, err)
+
+	_, err = tempFile.Write(trustmanager.CertToPEM(cert))
+	assert.NoError(t, err)
+	tempFile.Close()
+	defer os.Remove(tempFile.Name())
+
+	rawPubBytes, _ := ioutil.ReadFile(tempFile.Name())
+	parsedPubKey, _ := trustmanager.ParsePEMPublicKey(rawPubBytes)
+	canonicalKeyID, err := utils.CanonicalKeyID(parsedPubKey)
+	assert.NoError(t, err)
+
+	// Set up targets for publishing
+	tempTargetFile, err := ioutil.TempFile("", "targetfile")
+	assert.NoError(t, err)
+	tempTargetFile.Close()
+	defer os.Remove(tempTargetFile.Name())
+
+	var target = "sdgkadga"
+
+	var output string
+
+	// init repo
+	_, err = runCommand(t, tempDir, "-s", server.URL, "init", "gun")
+	assert.NoError(t, err)
+
+	// publish repo
+	_, err = runCommand(t, tempDir, "-s", server.URL, "publish", "gun")
+	assert.NoError(t, err)
+
+	// list delegations - none yet
+	output, err = runCommand(t, tempDir, "-s", server.URL, "delegation", "list", "gun")
+	assert.NoError(t, err)
+	assert.Contains(t, output, "No delegations present in this repository.")
+
+	// publish repo
+	_, err = runCommand(t, tempDir, "-s", server.URL, "publish", "gun")
+	assert.NoError(t, err)
+
+	// validate that we have all keys, including snapshot
+	assertNumKeys(t, tempDir, 1, 2, true)
+
+	// rotate the snapshot key to server
+	output, err = runCommand(t, tempDir, "-s", server.URL, "key", "rotate", "gun", "-r", "--key-type", "snapshot")
+	assert.NoError(t, err)
+
+	// publish repo
+	_, err = runCommand(t, tempDir, "-s", server.URL, "publish", "gun")
+	assert.NoError(t, err)
+
+	// validate that we lost the snapshot signing key
+	_, signingKeyIDs := assertNumKeys(t, tempDir, 1, 1, true)
+	targetKeyID := signingKeyIDs[0]
+
+	// add new valid delegation with single new cert
+	output, err = runCommand(t, tempDir, "delegation", "add", "gun", "targets/releases", tempFile.Name(), "--paths", "\"\"")
+	assert.NoError(t, err)
+	assert.Contains(t, output, "Addition of delegation role")
+
+	// publish repo
+	_, err = runCommand(t, tempDir, "-s", server.URL, "publish", "gun")
+	assert.NoError(t, err)
+
+	// list delegations - we should see our one delegation
+	output, err = runCommand(t, tempDir, "-s", server.URL, "delegation", "list", "gun")
+	assert.NoError(t, err)
+	assert.NotContains(t, output, "No delegations present in this repository.")
+
+	// remove the targets key to demonstrate that delegates don't need this key
+	keyDir := filepath.Join(tempDir, "private", "tuf_keys")
+	assert.NoError(t, os.Remove(filepath.Join(keyDir, "gun", targetKeyID+".key")))
+
+	// Note that we need to use the canonical key ID, followed by the base of the role here
+	err = ioutil.WriteFile(filepath.Join(keyDir, canonicalKeyID+"_releases.key"), privKeyBytesNoRole, 0700)
+	assert.NoError(t, err)
+
+	// add a target using the delegation -- will only add to targets/releases
+	_, err = runCommand(t, tempDir, "add", "gun", target, tempTargetFile.Name(), "--roles", "targets/releases")
+	assert.NoError(t, err)
+
+	// list targets for targets/releases - we should see no targets until we publish
+	output, err = runCommand(t, tempDir, "-s", server.URL, "list", "gun", "--roles", "targets/releases")
+	assert.NoError(t, err)
+	assert.Contains(t, output, "No targets")
+
+	output, err = runCommand(t, tempDir, "-s", server.URL, "status", "gun")
+	assert.NoError(t, err)
+
+	// publish repo
+	_, err = runCommand(t, tempDir, "-s", server.URL, "publish", "gun")
+	assert.NoError(t, err)
+
+	// list targets for targets/releases - we should see our target!
+	output, err = runCommand(t, tempDir, "-s", server.URL, "list", "gun", "--roles", "targets/releases")
+	assert.NoError(t, err)
+	assert.Contains(t, output, "targets/releases")
+
+	// remove the target for this role only
+	_, err = runCommand(t, tempDir, "remove", "gun", target, "--roles", "targets/releases")
+	assert.NoError(t, err)
+
+	// publish repo
+	_, err = runCommand(t, tempDir, "-s", server.URL, "publish", "gun")
+	assert.NoError(t, err)
+
+	// list targets for targets/releases - we should see no targets
+	output, err = runCommand(t, tempDir, "-s", server.URL, "list", "gun", "--roles", "targets/releases")
+	assert.NoError(t, err)
+	assert.Contains(t, output, "No targets present")
+
+	// Try adding a target with a different key style - private/tuf_keys/canonicalKeyID.key with "user" set as the "role" PEM header
+	// First remove the old key and add the new style
+	assert.NoError(t, os.Remove(filepath.Join(keyDir, canonicalKeyID+"_releases.key")))
+	err = ioutil.WriteFile(filepath.Join(keyDir, canonicalKeyID+".key"), privKeyBytesWithRole, 0700)
+	assert.NoError(t, err)
+
+	// add a target using the delegation -- will only add to targets/releases
+	_, err = runCommand(t, tempDir, "add", "gun", target, tempTargetFile.Name(), "--roles", "targets/releases")
+	assert.NoError(t, err)
+
+	// list targets for targets/releases - we should see no targets until we publish
+	output, err = runCommand(t, tempDir, "-s", server.URL, "list", "gun", "--roles", "targets/releases")
+	assert.NoError(t, err)
+	assert.Contains(t, output, "No targets")
+
+	// publish repo
+	_, err = runCommand(t, tempDir, "-s", server.URL, "publish", "gun")
+	assert.NoError(t, err)
+
+	// list targets for targets/releases - we should see our target!
+	output, err = runCommand(t, tempDir, "-s", server.URL, "list", "gun", "--roles", "targets/releases")
+	assert.NoError(t, err)
+	assert.Contains(t, output, "targets/releases")
+
+	// remove the target for this role only
+	_, err = runCommand(t, tempDir, "remove", "gun", target, "--roles", "targets/releases")
+	assert.NoError(t, err)
+
+	// publish repo
+	_, err = runCommand(t, tempDir, "-s", server.URL, "publish", "gun")
+	assert.NoError(t, err)
+
+	// Now remove this key, and make a new file to import the delegation's key from
+	assert.NoError(t, os.Remove(filepath.Join(keyDir, canonicalKeyID+".key")))
+	tempPrivFile, err := ioutil.TempFile("/tmp", "privfile")
+	assert.NoError(t, err)
+	defer os.Remove(tempPrivFile.Name())
+
+	// Write the private key to a file so we can import it
+	_, err = tempPrivFile.Write(privKeyBytesNoRole)
+	assert.NoError(t, err)
+	tempPrivFile.Close()
+
+	// Import the private key, associating it with our delegation role
+	_, err = runCommand(t, tempDir, "key", "import", tempPrivFile.Name(), "--role", "targets/releases")
+	assert.NoError(t, err)
+
+	// add a target using the delegation -- will only add to targets/releases
+	_, err = runCommand(t, tempDir, "add", "gun", target, tempTargetFile.Name(), "--roles", "targets/releases")
+	assert.NoError(t, err)
+
+	// list targets for targets/releases - we should see no targets until we publish
+	output, err = runCommand(t, tempDir, "-s", server.URL, "list", "gun", "--roles", "targets/releases")
+	assert.NoError(t, err)
+	assert.Contains(t, output, "No targets")
+
+	// publish repo
+	_, err = runCommand(t, tempDir, "-s", server.URL, "publish", "gun")
+	assert.NoError(t, err)
+
+	// list targets for targets/releases - we should see our target!
+	output, err = runCommand(t, tempDir, "-s", server.URL, "list", "gun", "--roles", "targets/releases")
+	assert.NoError(t, err)
+	assert.Contains(t, output, "targets/releases")
+}
+
+// Splits a string into lines, and returns any lines that are not empty (
+// striped of whitespace)
+func splitLines(chunk string) []string {
+	splitted := strings.Split(strings.TrimSpace(chunk), "\n")
+	var results []string
+
+	for _, line := range splitted {
+		line := strings.TrimSpace(line)
+		if line != "" {
+			results = append(results, line)
+		}
+	}
+
+	return results
+}
+
+// List keys, parses the output, and returns the unique key IDs as an array
+// of root key IDs and an array of signing key IDs.  Output expected looks like:
+//     ROLE      GUN          KEY ID                   LOCATION             BACKEND
+// ---------------------------------------------------------------------------------------
+//   root               8bd63a896398b558ac...   file (.../private)   file: /home/.../8bd63a896398b558ac...
+//   snapshot   repo    e9e9425cd9a85fc7a5...   file (.../private)   file: /home/.../e9e9425cd9a85fc7a5...
+//   targets    repo    f5b84e2d92708c5acb...   file (.../private)   file: /home/.../f5b84e2d92708c5acb...
+func getUniqueKeys(t *testing.T, tempDir string) ([]string, []string) {
+	output, err := runCommand(t, tempDir, "key", "list")
+	assert.NoError(t, err)
+	lines := splitLines(output)
+	if len(lines) == 1 && lines[0] == "No signing keys found." {
+		return []string{}, []string{}
+	}
+	if len(lines) < 3 { // 2 lines of header, at least 1 line with keys
+		t.Logf("This output is not what is expected by the test:\n%s", output)
+	}
+
+	var (
+		rootMap    = make(map[string]bool)
+		nonrootMap = make(map[string]bool)
+		root       []string
+		nonroot    []string
+	)
+	// first two lines are header
+	for _, line := range lines[2:] {
+		parts := strings.Fields(line)
+		var (
+			placeToGo map[string]bool
+			keyID     string
+		)
+		if strings.TrimSpace(parts[0]) == "root" {
+			// no gun, so there are only 3 fields
+			placeToGo, keyID = rootMap, parts[1]
+		} else {
+			// gun comes between role and key ID
+			placeToGo, keyID = nonrootMap, parts[2]
+		}
+		// keys are 32-chars long (32 byte shasum, hex-encoded)
+		assert.Len(t, keyID, 64)
+		placeToGo[keyID] = true
+	}
+	for k := range rootMap {
+		root = append(root, k)
+	}
+	for k := range nonrootMap {
+		nonroot = append(nonroot, k)
+	}
+
+	return root, nonroot
+}
+
+// Keys can be listed with where they are stored, and only the keys in one kind
+// of storage can be listed
+func TestClientKeyListBackend(t *testing.T) {
+	setUp(t)
+
+	tempDir := tempDirWithConfig(t, "{}")
+	defer os.RemoveAll(tempDir)
+
+	server := setupServer()
+	defer server.Close()
+
+	_, err := runCommand(t, tempDir, "-s", server.URL, "init", "gun")
+	assert.NoError(t, err)
+
+	root, signing := getUniqueKeys(t, tempDir)
+	assert.Len(t, root, 1)
+	assert.Len(t, signing, 2)
+
+	output, err := runCommand(t, tempDir, "key", "list")
+	assert.NoError(t, err)
+	assert.Contains(t, output, "BACKEND")
+	assert.Contains(t, output, "file: "+filepath.Join(tempDir, "private", "root_keys", root[0]+".key"))
+
+	output, err = runCommand(t, tempDir, "key", "list", "--backend", "FILE")
+	assert.NoError(t, err)
+	for _, keyID := range append(root, signing...) {
+		assert.Contains(t, output, keyID)
+	}
+
+	output, err = runCommand(t, tempDir, "key", "list", "--backend", "pkcs11")
+	assert.NoError(t, err)
+	assert.Contains(t, output, "No signing keys found.")
+}
+
+// List keys, parses the output, and asserts something about the number of root
+// keys and number of signing keys, as well as returning them.
+func assertNumKeys(t *testing.T, tempDir string, numRoot, numSigning int,
+	rootOnDisk bool) ([]string, []string) {
+
+	root, signing := getUniqueKeys(t, tempDir)
+	assert.Len(t, root, numRoot)
+	assert.Len(t, signing, numSigning)
+	for _, rootKeyID := range root {
+		_, err := os.Stat(filepath.Join(
+			tempDir, "private", "root_keys", rootKeyID+".key"))
+		// os.IsExist checks to see if the error is because a file already
+		// exist, and hence doesn't actually the right funciton to use here
+		assert.Equal(t, rootOnDisk, !os.IsNotExist(err))
+
+		// this function is declared is in the build-tagged setup files
+		verifyRootKeyOnHardware(t, rootKeyID)
+	}
+	return root, signing
+}
+
+// Adds the given target to the gun, publishes it, and lists it to ensure that
+// it appears.  Returns the listing output.
+func assertSuccessfullyPublish(
+	t *testing.T, tempDir, url, gun, target, fname string) string {
+
+	_, err := runCommand(t, tempDir, "add", gun, target, fname)
+	assert.NoError(t, err)
+
+	_, err = runCommand(t, tempDir, "-s", url, "publish", gun)
+	assert.NoError(t, err)
+
+	output, err := runCommand(t, tempDir, "-s", url, "list", gun)
+	assert.NoError(t, err)
+	assert.True(t, strings.Contains(string(output), target))
+
+	return output
+}
+
+// Tests root key generation and key rotation
+func TestClientKeyGenerationRotation(t *testing.T) {
+	// -- setup --
+	setUp(t)
+
+	tempDir := tempDirWithConfig(t, "{}")
+	defer os.RemoveAll(tempDir)
+
+	tempfiles := make([]string, 2)
+	for i := 0; i < 2; i++ {
+		tempFile, err := ioutil.TempFile("", "targetfile")
+		assert.NoError(t, err)
+		tempFile.Close()
+		tempfiles[i] = tempFile.Name()
+		defer os.Remove(tempFile.Name())
+	}
+
+	server := setupServer()
+	defer server.Close()
+
+	var target = "sdgkadga"
+
+	// -- tests --
+
+	// starts out with no keys
+	assertNumKeys(t, tempDir, 0, 0, true)
+
+	// generate root key produces a single root key and no other keys
+	_, err := runCommand(t, tempDir, "key", "generate", data.ECDSAKey)
+	assert.NoError(t, err)
+	assertNumKeys(t, tempDir, 1, 0, true)
+
+	// initialize a repo, should have signing keys and no new root key
+	_, err = runCommand(t, tempDir, "-s", server.URL, "init", "gun")
+	assert.NoError(t, err)
+	origRoot, origSign := assertNumKeys(t, tempDir, 1, 2, true)
+
+	// publish using the original keys
+	assertSuccessfullyPublish(t, tempDir, server.URL, "gun", target, tempfiles[0])
+
+	// rotate the signing keys
 	_, err = runCommand(t, tempDir, "key", "rotate", "gun")
 	assert.NoError(t, err)
 	root, sign := assertNumKeys(t, tempDir, 1, 4, true)
@@ -878,143 +2839,710 @@ func TestClientKeyGenerationRotation(t *testing.T) {
 				found = true
 			}
 		}
-		assert.True(t, found, "Old key not found in list of old and new keys")
+		assert.True(t, found, "Old key not found in list of old and new keys")
+	}
+
+	// publish the key rotation
+	_, err = runCommand(t, tempDir, "-s", server.URL, "publish", "gun")
+	assert.NoError(t, err)
+	root, sign = assertNumKeys(t, tempDir, 1, 2, true)
+	assert.Equal(t, origRoot[0], root[0])
+	// just do a cursory rotation check that the keys aren't equal anymore
+	for _, origKey := range origSign {
+		for _, key := range sign {
+			assert.NotEqual(
+				t, key, origKey, "One of the signing keys was not removed")
+		}
+	}
+
+	// publish using the new keys
+	output := assertSuccessfullyPublish(
+		t, tempDir, server.URL, "gun", target+"2", tempfiles[1])
+	// assert that the previous target is sitll there
+	assert.True(t, strings.Contains(string(output), target))
+}
+
+// Tests backup/restore root+signing keys - repo with restored keys should be
+// able to publish successfully
+func TestClientKeyBackupAndRestore(t *testing.T) {
+	// -- setup --
+	setUp(t)
+
+	dirs := make([]string, 3)
+	for i := 0; i < 3; i++ {
+		tempDir := tempDirWithConfig(t, "{}")
+		defer os.RemoveAll(tempDir)
+		dirs[i] = tempDir
+	}
+
+	tempfiles := make([]string, 2)
+	for i := 0; i < 2; i++ {
+		tempFile, err := ioutil.TempFile("", "tempfile")
+		assert.NoError(t, err)
+		tempFile.Close()
+		tempfiles[i] = tempFile.Name()
+		defer os.Remove(tempFile.Name())
+	}
+
+	server := setupServer()
+	defer server.Close()
+
+	var (
+		target = "sdgkadga"
+		err    error
+	)
+
+	// create two repos and publish a target
+	for _, gun := range []string{"gun1", "gun2"} {
+		_, err = runCommand(t, dirs[0], "-s", server.URL, "init", gun)
+		assert.NoError(t, err)
+
+		assertSuccessfullyPublish(
+			t, dirs[0], server.URL, gun, target, tempfiles[0])
+	}
+	assertNumKeys(t, dirs[0], 1, 4, true)
+
+	// -- tests --
+	zipfile := tempfiles[0] + ".zip"
+	defer os.Remove(zipfile)
+
+	// backup then restore all keys
+	_, err = runCommand(t, dirs[0], "key", "backup", zipfile)
+	assert.NoError(t, err)
+
+	_, err = runCommand(t, dirs[1], "key", "restore", zipfile)
+	assert.NoError(t, err)
+	// all keys should be there, including root because the root key was backed up to disk,
+	// and export just backs up all the keys on disk
+	assertNumKeys(t, dirs[1], 1, 4, true)
+
+	// can list and publish to both repos using restored keys
+	for _, gun := range []string{"gun1", "gun2"} {
+		output, err := runCommand(t, dirs[1], "-s", server.URL, "list", gun)
+		assert.NoError(t, err)
+		assert.True(t, strings.Contains(string(output), target))
+
+		assertSuccessfullyPublish(
+			t, dirs[1], server.URL, gun, target+"2", tempfiles[1])
+	}
+
+	// backup and restore keys for one gun
+	_, err = runCommand(t, dirs[0], "key", "backup", zipfile, "-g", "gun1")
+	assert.NoError(t, err)
+
+	_, err = runCommand(t, dirs[2], "key", "restore", zipfile)
+	assert.NoError(t, err)
+
+	// this function is declared is in the build-tagged setup files
+	if rootOnHardware() {
+		// hardware root is still present, and the key will ONLY be on hardware
+		// and not on disk
+		assertNumKeys(t, dirs[2], 1, 2, false)
+	} else {
+		// only 2 signing keys should be there, and no root key
+		assertNumKeys(t, dirs[2], 0, 2, true)
+	}
+}
+
+// Generate a root key and export the root key only.  Return the key ID
+// exported.
+func exportRoot(t *testing.T, exportTo string) string {
+	tempDir := tempDirWithConfig(t, "{}")
+	defer os.RemoveAll(tempDir)
+
+	// generate root key produces a single root key and no other keys
+	_, err := runCommand(t, tempDir, "key", "generate", data.ECDSAKey)
+	assert.NoError(t, err)
+	oldRoot, _ := assertNumKeys(t, tempDir, 1, 0, true)
+
+	// export does not require a password
+	oldNewCommand := NewNotaryCommand
+	NewNotaryCommand = func() *cobra.Command {
+		commander := &notaryCommander{getRetriever: func() passphrase.Retriever { return nil }}
+		return commander.GetCommand()
+	}
+	defer func() { // but import will, later
+		NewNotaryCommand = oldNewCommand
+	}()
+
+	_, err = runCommand(
+		t, tempDir, "key", "export", oldRoot[0], exportTo)
+	assert.NoError(t, err)
+
+	return oldRoot[0]
+}
+
+// Tests import/export root key only
+func TestClientKeyImportExportRootOnly(t *testing.T) {
+	// -- setup --
+	setUp(t)
+
+	tempDir := tempDirWithConfig(t, "{}")
+	defer os.RemoveAll(tempDir)
+
+	server := setupServer()
+	defer server.Close()
+
+	var (
+		target    = "sdgkadga"
+		rootKeyID string
+	)
+
+	tempFile, err := ioutil.TempFile("", "pemfile")
+	assert.NoError(t, err)
+	// close later, because we might need to write to it
+	defer os.Remove(tempFile.Name())
+
+	// -- tests --
+
+	if rootOnHardware() {
+		t.Log("Cannot export a key from hardware. Will generate one to import.")
+
+		privKey, err := trustmanager.GenerateECDSAKey(rand.Reader)
+		assert.NoError(t, err)
+
+		pemBytes, err := trustmanager.EncryptPrivateKey(privKey, "root", testPassphrase)
+		assert.NoError(t, err)
+
+		nBytes, err := tempFile.Write(pemBytes)
+		assert.NoError(t, err)
+		tempFile.Close()
+		assert.Equal(t, len(pemBytes), nBytes)
+		rootKeyID = privKey.ID()
+	} else {
+		tempFile.Close()
+		rootKeyID = exportRoot(t, tempFile.Name())
+	}
+
+	// import the key
+	_, err = runCommand(t, tempDir, "key", "import", tempFile.Name())
+	assert.NoError(t, err)
+
+	// if there is hardware available, root will only be on hardware, and not
+	// on disk
+	newRoot, _ := assertNumKeys(t, tempDir, 1, 0, !rootOnHardware())
+	assert.Equal(t, rootKeyID, newRoot[0])
+
+	// Just to make sure, init a repo and publish
+	_, err = runCommand(t, tempDir, "-s", server.URL, "init", "gun")
+	assert.NoError(t, err)
+	assertNumKeys(t, tempDir, 1, 2, !rootOnHardware())
+	assertSuccessfullyPublish(
+		t, tempDir, server.URL, "gun", target, tempFile.Name())
+}
+
+// Helper method to get the subdirectory for TUF keys
+func getKeySubdir(role, gun string) string {
+	subdir := notary.PrivDir
+	switch role {
+	case data.CanonicalRootRole:
+		return filepath.Join(subdir, notary.RootKeysSubdir)
+	case data.CanonicalTargetsRole:
+		return filepath.Join(subdir, notary.NonRootKeysSubdir, gun)
+	case data.CanonicalSnapshotRole:
+		return filepath.Join(subdir, notary.NonRootKeysSubdir, gun)
+	default:
+		return filepath.Join(subdir, notary.NonRootKeysSubdir)
+	}
+}
+
+// Tests importing and exporting keys for all different roles and GUNs
+func TestClientKeyImportExportAllRoles(t *testing.T) {
+	if rootOnHardware() {
+		t.Log("Cannot import or export a non-root key from hardware. Will skip test.")
+		return
+	}
+	// -- setup --
+	setUp(t)
+
+	tempDir := tempDirWithConfig(t, "{}")
+	defer os.RemoveAll(tempDir)
+
+	server := setupServer()
+	defer server.Close()
+
+	// -- tests --
+	_, err := runCommand(t, tempDir, "-s", server.URL, "init", "gun")
+	assert.NoError(t, err)
+
+	testRoles := append(data.BaseRoles, "targets/releases")
+	// Test importing and exporting keys to all base roles and delegation role
+	for _, role := range testRoles {
+		// Do this while importing keys that have the PEM header role set or have --role set on import
+		for _, setKeyRole := range []bool{true, false} {
+			// Make a new key for this role
+			privKey, err := trustmanager.GenerateECDSAKey(rand.Reader)
+			assert.NoError(t, err)
+
+			// Make a tempfile for importing
+			tempFile, err := ioutil.TempFile("", "pemfile")
+			assert.NoError(t, err)
+
+			// Specify the role in the PEM header
+			pemBytes, err := trustmanager.EncryptPrivateKey(privKey, role, testPassphrase)
+			assert.NoError(t, err)
+			ioutil.WriteFile(tempFile.Name(), pemBytes, 0644)
+
+			// If we need to set the key role with the --role flag, do so on import
+			if setKeyRole {
+				// If it's targets/snapshot we must specify the GUN
+				if role == data.CanonicalTargetsRole || role == data.CanonicalSnapshotRole {
+					_, err = runCommand(t, tempDir, "key", "import", tempFile.Name(), "--gun", "gun", "--role", role)
+				} else {
+					_, err = runCommand(t, tempDir, "key", "import", tempFile.Name(), "--role", role)
+				}
+			} else {
+				// If it's targets/snapshot we must specify the GUN
+				if role == data.CanonicalTargetsRole || role == data.CanonicalSnapshotRole {
+					_, err = runCommand(t, tempDir, "key", "import", tempFile.Name(), "--gun", "gun")
+				} else {
+					_, err = runCommand(t, tempDir, "key", "import", tempFile.Name())
+				}
+			}
+			assert.NoError(t, err)
+
+			// Test that we imported correctly
+			keySubdir := getKeySubdir(role, "gun")
+			_, err = os.Stat(filepath.Join(tempDir, keySubdir, privKey.ID()+".key"))
+			assert.Nil(t, err)
+
+			// Remove the input file so we can test exporting
+			assert.NoError(t, os.Remove(tempFile.Name()))
+
+			// Make a tempfile for exporting to
+			tempFile, err = ioutil.TempFile("", "pemfile")
+			assert.NoError(t, err)
+
+			// Ensure exporting this key by ID gets the same key
+			_, err = runCommand(t, tempDir, "key", "export", privKey.ID(), tempFile.Name())
+			assert.NoError(t, err)
+			// Compare the bytes of the exported file and the root key file in the repo
+			exportedBytes, err := ioutil.ReadFile(tempFile.Name())
+			assert.NoError(t, err)
+			repoBytes, err := ioutil.ReadFile(filepath.Join(tempDir, keySubdir, privKey.ID()+".key"))
+			assert.NoError(t, err)
+			assert.Equal(t, repoBytes, exportedBytes)
+
+			// Ensure exporting this key and changing the passphrase works
+			_, err = runCommand(t, tempDir, "key", "export", privKey.ID(), tempFile.Name(), "-p")
+			assert.NoError(t, err)
+
+			// Remove the export file for cleanup
+			assert.NoError(t, os.Remove(tempFile.Name()))
+		}
 	}
+}
 
-	// publish the key rotation
-	_, err = runCommand(t, tempDir, "-s", server.URL, "publish", "gun")
+func assertNumCerts(t *testing.T, tempDir string, expectedNum int) []string {
+	output, err := runCommand(t, tempDir, "cert", "list")
 	assert.NoError(t, err)
-	root, sign = assertNumKeys(t, tempDir, 1, 2, true)
-	assert.Equal(t, origRoot[0], root[0])
-	// just do a cursory rotation check that the keys aren't equal anymore
-	for _, origKey := range origSign {
-		for _, key := range sign {
-			assert.NotEqual(
-				t, key, origKey, "One of the signing keys was not removed")
-		}
+	lines := splitLines(strings.TrimSpace(output))
+
+	if expectedNum == 0 {
+		assert.Len(t, lines, 1)
+		assert.Equal(t, "No trusted root certificates present.", lines[0])
+		return []string{}
 	}
 
-	// publish using the new keys
-	output := assertSuccessfullyPublish(
-		t, tempDir, server.URL, "gun", target+"2", tempfiles[1])
-	// assert that the previous target is sitll there
-	assert.True(t, strings.Contains(string(output), target))
+	assert.Len(t, lines, expectedNum+2)
+	return lines[2:]
+}
+
+// TestClientCertInteraction
+func TestClientCertInteraction(t *testing.T) {
+	// -- setup --
+	setUp(t)
+
+	tempDir := tempDirWithConfig(t, "{}")
+	defer os.RemoveAll(tempDir)
+
+	server := setupServer()
+	defer server.Close()
+
+	// -- tests --
+	_, err := runCommand(t, tempDir, "-s", server.URL, "init", "gun1")
+	assert.NoError(t, err)
+	_, err = runCommand(t, tempDir, "-s", server.URL, "init", "gun2")
+	assert.NoError(t, err)
+	certs := assertNumCerts(t, tempDir, 2)
+	// root is always on disk, because even if there's a yubikey a backup is created
+	assertNumKeys(t, tempDir, 1, 4, true)
+
+	// remove certs for one gun
+	_, err = runCommand(t, tempDir, "cert", "remove", "-g", "gun1", "-y")
+	assert.NoError(t, err)
+	certs = assertNumCerts(t, tempDir, 1)
+	// assert that when we remove cert by gun, we do not remove repo signing keys
+	// (root is always on disk, because even if there's a yubikey a backup is created)
+	assertNumKeys(t, tempDir, 1, 4, true)
+	// assert that when we remove cert by gun, we also remove TUF metadata
+	_, err = os.Stat(filepath.Join(tempDir, "tuf", "gun1"))
+	assert.Error(t, err)
+
+	// remove a single cert
+	certID := strings.Fields(certs[0])[1]
+	// passing an empty gun here because the string for the previous gun has
+	// has already been stored (a drawback of running these commands without)
+	// shelling out
+	_, err = runCommand(t, tempDir, "cert", "remove", certID, "-y", "-g", "")
+	assert.NoError(t, err)
+	assertNumCerts(t, tempDir, 0)
+	// assert that when we remove the last cert ID for a gun, we also remove TUF metadata
+	_, err = os.Stat(filepath.Join(tempDir, "tuf", "gun2"))
+	assert.Error(t, err)
+
+	// Setup certificate with nonexistent repo GUN
+	// Check that we can only remove one certificate when specifying one ID
+	startTime := time.Now()
+	privKey, err := trustmanager.GenerateECDSAKey(rand.Reader)
+	assert.NoError(t, err)
+	noGunCert, err := cryptoservice.GenerateCertificate(
+		privKey, "nonexistent", startTime, startTime.AddDate(10, 0, 0))
+	assert.NoError(t, err)
+	certStore, err := trustmanager.NewX509FileStore(filepath.Join(tempDir, "trusted_certificates"))
+	assert.NoError(t, err)
+	err = certStore.AddCert(noGunCert)
+	assert.NoError(t, err)
+
+	certs = assertNumCerts(t, tempDir, 1)
+	certID = strings.Fields(certs[0])[1]
+
+	privKey, err = trustmanager.GenerateECDSAKey(rand.Reader)
+	assert.NoError(t, err)
+	noGunCert2, err := cryptoservice.GenerateCertificate(
+		privKey, "nonexistent", startTime, startTime.AddDate(10, 0, 0))
+	assert.NoError(t, err)
+	err = certStore.AddCert(noGunCert2)
+	assert.NoError(t, err)
+
+	certs = assertNumCerts(t, tempDir, 2)
+
+	// passing an empty gun to overwrite previously stored gun
+	_, err = runCommand(t, tempDir, "cert", "remove", certID, "-y", "-g", "")
+	assert.NoError(t, err)
+
+	// Since another cert with the same GUN exists, we didn't remove everything
+	assertNumCerts(t, tempDir, 1)
+}
+
+// Stages a change on one host, exports the changelist, and imports it on
+// another host before publishing
+func TestClientChangelistExportImport(t *testing.T) {
+	// -- setup --
+	setUp(t)
+
+	tempDir := tempDirWithConfig(t, "{}")
+	defer os.RemoveAll(tempDir)
+	otherDir := tempDirWithConfig(t, "{}")
+	defer os.RemoveAll(otherDir)
+
+	server := setupServer()
+	defer server.Close()
+
+	tempFile, err := ioutil.TempFile("", "targetfile")
+	assert.NoError(t, err)
+	tempFile.Close()
+	defer os.Remove(tempFile.Name())
+
+	exportFile := filepath.Join(tempDir, "changes.json")
+	target := "sdgkadga"
+
+	// -- tests --
+
+	// init and publish the repo from the other host
+	_, err = runCommand(t, otherDir, "-s", server.URL, "init", "gun")
+	assert.NoError(t, err)
+	_, err = runCommand(t, otherDir, "-s", server.URL, "publish", "gun")
+	assert.NoError(t, err)
+
+	// stage a target and export it
+	_, err = runCommand(t, tempDir, "add", "gun", target, tempFile.Name())
+	assert.NoError(t, err)
+	_, err = runCommand(t, tempDir, "changelist", "export", "gun", exportFile)
+	assert.NoError(t, err)
+
+	// the export can't be imported into a different GUN
+	_, err = runCommand(t, otherDir, "changelist", "import", "othergun", exportFile)
+	assert.Error(t, err)
+
+	// import it on the other host - see target in the status
+	_, err = runCommand(t, otherDir, "changelist", "import", "gun", exportFile)
+	assert.NoError(t, err)
+	output, err := runCommand(t, otherDir, "status", "gun")
+	assert.NoError(t, err)
+	assert.Contains(t, output, target)
+
+	// publish from the other host - see target
+	_, err = runCommand(t, otherDir, "-s", server.URL, "publish", "gun")
+	assert.NoError(t, err)
+	output, err = runCommand(t, otherDir, "-s", server.URL, "list", "gun")
+	assert.NoError(t, err)
+	assert.Contains(t, output, target)
+}
+
+// Tests rotating the root key with an overlap, and then finishing the rotation
+func TestClientTrustRotateRoot(t *testing.T) {
+	// -- setup --
+	setUp(t)
+
+	tempDir := tempDirWithConfig(t, "{}")
+	defer os.RemoveAll(tempDir)
+	otherDir := tempDirWithConfig(t, "{}")
+	defer os.RemoveAll(otherDir)
+
+	server := setupServer()
+	defer server.Close()
+
+	tempFile, err := ioutil.TempFile("", "pemfile")
+	assert.NoError(t, err)
+	tempFile.Close()
+	defer os.Remove(tempFile.Name())
+	newRootKeyID := exportRoot(t, tempFile.Name())
+
+	// -- tests --
+
+	// init and publish the repo, and have another client see it
+	_, err = runCommand(t, tempDir, "-s", server.URL, "init", "gun")
+	assert.NoError(t, err)
+	_, err = runCommand(t, tempDir, "-s", server.URL, "publish", "gun")
+	assert.NoError(t, err)
+	_, err = runCommand(t, otherDir, "-s", server.URL, "list", "gun")
+	assert.NoError(t, err)
+
+	// a rotation that fails does not leave the new root key behind
+	_, err = runCommand(t, tempDir, "-s", server.URL, "trust", "rotate-root", "othergun", tempFile.Name(), "-y")
+	assert.Error(t, err)
+	assertNumKeys(t, tempDir, 1, 2, true)
+
+	// rotate the root key, keeping the old one
+	output, err := runCommand(t, tempDir, "-s", server.URL, "trust", "rotate-root", "gun", tempFile.Name(), "-y")
+	assert.NoError(t, err)
+	assert.Contains(t, output, newRootKeyID)
+	assert.Contains(t, output, "staged for next publish")
+	assertNumKeys(t, tempDir, 2, 2, true)
+	_, err = runCommand(t, tempDir, "-s", server.URL, "publish", "gun")
+	assert.NoError(t, err)
+
+	// the other client can still validate the repo
+	_, err = runCommand(t, otherDir, "-s", server.URL, "list", "gun")
+	assert.NoError(t, err)
+
+	// finish the rotation
+	output, err = runCommand(t, tempDir, "-s", server.URL, "trust", "rotate-root", "gun", tempFile.Name(), "--overlap-days", "0", "-y")
+	assert.NoError(t, err)
+	assert.Contains(t, output, "WARNING")
+	_, err = runCommand(t, tempDir, "-s", server.URL, "publish", "gun")
+	assert.NoError(t, err)
+
+	// the other client saw the new key during the overlap, so it still validates the repo
+	_, err = runCommand(t, otherDir, "-s", server.URL, "list", "gun")
+	assert.NoError(t, err)
+}
+
+// With --output-dir, rotate-root also writes the new root that the next
+// publish would send, without publishing it
+func TestClientTrustRotateRootOutputDir(t *testing.T) {
+	setUp(t)
+
+	tempDir := tempDirWithConfig(t, "{}")
+	defer os.RemoveAll(tempDir)
+
+	server := setupServer()
+	defer server.Close()
+
+	tempFile, err := ioutil.TempFile("", "pemfile")
+	assert.NoError(t, err)
+	tempFile.Close()
+	defer os.Remove(tempFile.Name())
+	exportRoot(t, tempFile.Name())
+
+	_, err = runCommand(t, tempDir, "-s", server.URL, "init", "gun")
+	assert.NoError(t, err)
+	_, err = runCommand(t, tempDir, "-s", server.URL, "publish", "gun")
+	assert.NoError(t, err)
+
+	outputDir := filepath.Join(tempDir, "review")
+	output, err := runCommand(t, tempDir, "-s", server.URL, "trust", "rotate-root", "gun", tempFile.Name(),
+		"-y", "--output-dir", outputDir)
+	assert.NoError(t, err)
+	assert.Contains(t, output, "staged for next publish")
+	assert.Contains(t, output, outputDir)
+
+	rootJSON, err := ioutil.ReadFile(filepath.Join(outputDir, "root.json"))
+	assert.NoError(t, err)
+	root := &data.SignedRoot{}
+	assert.NoError(t, json.Unmarshal(rootJSON, root))
+	assert.Len(t, root.Signed.Roles[data.CanonicalRootRole].KeyIDs, 2)
+	assert.Len(t, root.Signatures, 2)
+
+	// nothing has been published, and the rotation is still staged
+	output, err = runCommand(t, tempDir, "-s", server.URL, "status", "gun")
+	assert.NoError(t, err)
+	assert.Contains(t, output, "root")
+	_, err = runCommand(t, tempDir, "-s", server.URL, "publish", "gun")
+	assert.NoError(t, err)
 }
 
-// Tests backup/restore root+signing keys - repo with restored keys should be
-// able to publish successfully
-func TestClientKeyBackupAndRestore(t *testing.T) {
-	// -- setup --
+// Tests signing the targets role with a detached signature made over the bytes
+// written by sign-request, which is checked before it is staged
+func TestClientTrustSignRequestAndApply(t *testing.T) {
 	setUp(t)
+	var target = "sdgkadga"
 
-	dirs := make([]string, 3)
-	for i := 0; i < 3; i++ {
-		tempDir := tempDirWithConfig(t, "{}")
-		defer os.RemoveAll(tempDir)
-		dirs[i] = tempDir
-	}
-
-	tempfiles := make([]string, 2)
-	for i := 0; i < 2; i++ {
-		tempFile, err := ioutil.TempFile("", "tempfile")
-		assert.NoError(t, err)
-		tempFile.Close()
-		tempfiles[i] = tempFile.Name()
-		defer os.Remove(tempFile.Name())
-	}
+	tempDir := tempDirWithConfig(t, "{}")
+	defer os.RemoveAll(tempDir)
 
 	server := setupServer()
 	defer server.Close()
 
-	var (
-		target = "sdgkadga"
-		err    error
-	)
+	_, err := runCommand(t, tempDir, "-s", server.URL, "init", "gun")
+	assert.NoError(t, err)
+	_, err = runCommand(t, tempDir, "-s", server.URL, "publish", "gun")
+	assert.NoError(t, err)
 
-	// create two repos and publish a target
-	for _, gun := range []string{"gun1", "gun2"} {
-		_, err = runCommand(t, dirs[0], "-s", server.URL, "init", gun)
-		assert.NoError(t, err)
+	tempFile, err := ioutil.TempFile("", "targetfile")
+	assert.NoError(t, err)
+	tempFile.Close()
+	defer os.Remove(tempFile.Name())
+	_, err = runCommand(t, tempDir, "add", "gun", target, tempFile.Name())
+	assert.NoError(t, err)
 
-		assertSuccessfullyPublish(
-			t, dirs[0], server.URL, gun, target, tempfiles[0])
-	}
-	assertNumKeys(t, dirs[0], 1, 4, true)
+	payloadFile := filepath.Join(tempDir, "payload.bin")
+	sigFile := filepath.Join(tempDir, "sig.bin")
 
-	// -- tests --
-	zipfile := tempfiles[0] + ".zip"
-	defer os.Remove(zipfile)
+	// the output file is required
+	_, err = runCommand(t, tempDir, "-s", server.URL, "trust", "sign-request", "gun", data.CanonicalTargetsRole)
+	assert.Error(t, err)
 
-	// backup then restore all keys
-	_, err = runCommand(t, dirs[0], "key", "backup", zipfile)
+	output, err := runCommand(t, tempDir, "-s", server.URL, "trust", "sign-request", "gun",
+		data.CanonicalTargetsRole, "--out", payloadFile)
 	assert.NoError(t, err)
+	assert.Contains(t, output, payloadFile)
+	payload, err := ioutil.ReadFile(payloadFile)
+	assert.NoError(t, err)
+	assert.Contains(t, string(payload), target)
 
-	_, err = runCommand(t, dirs[1], "key", "restore", zipfile)
+	// until a signature is applied, the role cannot be published
+	_, err = runCommand(t, tempDir, "-s", server.URL, "publish", "gun")
+	assert.Error(t, err)
+
+	// sign the bytes as an external tool would, with the targets key
+	keyStore, err := trustmanager.NewKeyFileStore(tempDir, passphrase.ConstantRetriever(testPassphrase))
+	assert.NoError(t, err)
+	cs := cryptoservice.NewCryptoService("gun", keyStore)
+	keyIDs := cs.ListKeys(data.CanonicalTargetsRole)
+	assert.Len(t, keyIDs, 1)
+	privKey, _, err := cs.GetPrivateKey(keyIDs[0])
 	assert.NoError(t, err)
-	// all keys should be there, including root because the root key was backed up to disk,
-	// and export just backs up all the keys on disk
-	assertNumKeys(t, dirs[1], 1, 4, true)
 
-	// can list and publish to both repos using restored keys
-	for _, gun := range []string{"gun1", "gun2"} {
-		output, err := runCommand(t, dirs[1], "-s", server.URL, "list", gun)
-		assert.NoError(t, err)
-		assert.True(t, strings.Contains(string(output), target))
+	// a signature over other bytes is rejected
+	sig, err := privKey.Sign(rand.Reader, []byte("something else"), nil)
+	assert.NoError(t, err)
+	assert.NoError(t, ioutil.WriteFile(sigFile, sig, 0600))
+	_, err = runCommand(t, tempDir, "-s", server.URL, "trust", "sign-apply", "gun",
+		data.CanonicalTargetsRole, "--sig", sigFile, "--key", privKey.ID())
+	assert.Error(t, err)
 
-		assertSuccessfullyPublish(
-			t, dirs[1], server.URL, gun, target+"2", tempfiles[1])
-	}
+	sig, err = privKey.Sign(rand.Reader, payload, nil)
+	assert.NoError(t, err)
+	assert.NoError(t, ioutil.WriteFile(sigFile, sig, 0600))
+	output, err = runCommand(t, tempDir, "-s", server.URL, "trust", "sign-apply", "gun",
+		data.CanonicalTargetsRole, "--sig", sigFile, "--key", privKey.ID())
+	assert.NoError(t, err)
+	assert.Contains(t, output, "staged for next publish")
 
-	// backup and restore keys for one gun
-	_, err = runCommand(t, dirs[0], "key", "backup", zipfile, "-g", "gun1")
+	_, err = runCommand(t, tempDir, "-s", server.URL, "publish", "gun")
 	assert.NoError(t, err)
+	output, err = runCommand(t, tempDir, "-s", server.URL, "lookup", "gun", target)
+	assert.NoError(t, err)
+	assert.Contains(t, output, target)
+}
 
-	_, err = runCommand(t, dirs[2], "key", "restore", zipfile)
+// Tests that canonicalize prints the exact bytes the published metadata of a
+// role is signed over
+func TestClientTrustCanonicalize(t *testing.T) {
+	setUp(t)
+
+	tempDir := tempDirWithConfig(t, "{}")
+	defer os.RemoveAll(tempDir)
+
+	server := setupServer()
+	defer server.Close()
+
+	_, err := runCommand(t, tempDir, "-s", server.URL, "init", "gun")
+	assert.NoError(t, err)
+	_, err = runCommand(t, tempDir, "-s", server.URL, "publish", "gun")
 	assert.NoError(t, err)
 
-	// this function is declared is in the build-tagged setup files
-	if rootOnHardware() {
-		// hardware root is still present, and the key will ONLY be on hardware
-		// and not on disk
-		assertNumKeys(t, dirs[2], 1, 2, false)
-	} else {
-		// only 2 signing keys should be there, and no root key
-		assertNumKeys(t, dirs[2], 0, 2, true)
+	// a GUN and a role are required
+	_, err = runCommand(t, tempDir, "-s", server.URL, "trust", "canonicalize", "gun")
+	assert.Error(t, err)
+	// and the role has to exist
+	_, err = runCommand(t, tempDir, "-s", server.URL, "trust", "canonicalize", "gun", "targets/missing")
+	assert.Error(t, err)
+
+	for _, role := range data.BaseRoles {
+		output, err := runCommand(t, tempDir, "-s", server.URL, "trust", "canonicalize", "gun", role)
+		assert.NoError(t, err)
+		assert.False(t, strings.HasSuffix(output, "\n"))
+
+		var meta struct {
+			Type string `json:"_type"`
+		}
+		assert.NoError(t, json.Unmarshal([]byte(output), &meta))
+		assert.Equal(t, data.TUFTypes[role], meta.Type)
+
+		raw, err := ioutil.ReadFile(filepath.Join(tempDir, "tuf", "gun", "metadata", role+".json"))
+		assert.NoError(t, err)
+		s := &data.Signed{}
+		assert.NoError(t, json.Unmarshal(raw, s))
+		assert.Equal(t, string(s.Signed), output)
 	}
 }
 
-// Generate a root key and export the root key only.  Return the key ID
-// exported.
-func exportRoot(t *testing.T, exportTo string) string {
+func TestClientTrustCanonCheck(t *testing.T) {
+	setUp(t)
+
 	tempDir := tempDirWithConfig(t, "{}")
 	defer os.RemoveAll(tempDir)
 
-	// generate root key produces a single root key and no other keys
-	_, err := runCommand(t, tempDir, "key", "generate", data.ECDSAKey)
+	server := setupServer()
+	defer server.Close()
+
+	_, err := runCommand(t, tempDir, "-s", server.URL, "init", "gun")
+	assert.NoError(t, err)
+	_, err = runCommand(t, tempDir, "-s", server.URL, "publish", "gun")
 	assert.NoError(t, err)
-	oldRoot, _ := assertNumKeys(t, tempDir, 1, 0, true)
 
-	// export does not require a password
-	oldNewCommand := NewNotaryCommand
-	NewNotaryCommand = func() *cobra.Command {
-		commander := &notaryCommander{getRetriever: func() passphrase.Retriever { return nil }}
-		return commander.GetCommand()
+	// a GUN and a role are required
+	_, err = runCommand(t, tempDir, "-s", server.URL, "trust", "canon-check", "gun")
+	assert.Error(t, err)
+	// and the role has to exist
+	_, err = runCommand(t, tempDir, "-s", server.URL, "trust", "canon-check", "gun", "targets/missing")
+	assert.Error(t, err)
+
+	for _, role := range data.BaseRoles {
+		output, err := runCommand(t, tempDir, "-s", server.URL, "trust", "canon-check", "gun", role)
+		assert.NoError(t, err)
+		assert.Contains(t, output, "The metadata of "+role+" was sent as canonical JSON")
+		assert.Contains(t, output, notaryclient.SignatureValid)
+		assert.NotContains(t, output, "DIFFERS")
 	}
-	defer func() { // but import will, later
-		NewNotaryCommand = oldNewCommand
-	}()
 
-	_, err = runCommand(
-		t, tempDir, "key", "export", oldRoot[0], exportTo)
+	output, err := runCommand(t, tempDir, "-s", server.URL, "trust", "canon-check", "gun", data.CanonicalTargetsRole, "--json")
 	assert.NoError(t, err)
-
-	return oldRoot[0]
+	var check notaryclient.CanonicalizationCheck
+	assert.NoError(t, json.Unmarshal([]byte(output), &check))
+	assert.Equal(t, data.CanonicalTargetsRole, check.Role)
+	assert.True(t, check.Consistent())
+	assert.Len(t, check.Signatures, 1)
 }
 
-// Tests import/export root key only
-func TestClientKeyImportExportRootOnly(t *testing.T) {
-	// -- setup --
+// Tests signing the staged changes offline into a bundle, and publishing the
+// bundle afterwards
+func TestClientTrustSignOfflineAndPublishBundle(t *testing.T) {
 	setUp(t)
+	var target = "sdgkadga"
 
 	tempDir := tempDirWithConfig(t, "{}")
 	defer os.RemoveAll(tempDir)
@@ -1022,76 +3550,90 @@ func TestClientKeyImportExportRootOnly(t *testing.T) {
 	server := setupServer()
 	defer server.Close()
 
-	var (
-		target    = "sdgkadga"
-		rootKeyID string
-	)
+	_, err := runCommand(t, tempDir, "-s", server.URL, "init", "gun")
+	assert.NoError(t, err)
+	_, err = runCommand(t, tempDir, "-s", server.URL, "publish", "gun")
+	assert.NoError(t, err)
 
-	tempFile, err := ioutil.TempFile("", "pemfile")
+	tempFile, err := ioutil.TempFile("", "targetfile")
 	assert.NoError(t, err)
-	// close later, because we might need to write to it
+	tempFile.Close()
 	defer os.Remove(tempFile.Name())
+	_, err = runCommand(t, tempDir, "add", "gun", target, tempFile.Name())
+	assert.NoError(t, err)
 
-	// -- tests --
+	// the output file is required
+	_, err = runCommand(t, tempDir, "trust", "sign-offline", "gun")
+	assert.Error(t, err)
+	// the cached metadata has to be updated after publishing
+	bundleFile := filepath.Join(tempDir, "bundle.json")
+	_, err = runCommand(t, tempDir, "trust", "sign-offline", "gun", "--out", bundleFile)
+	assert.Error(t, err)
+	_, err = runCommand(t, tempDir, "-s", server.URL, "list", "gun")
+	assert.NoError(t, err)
 
-	if rootOnHardware() {
-		t.Log("Cannot export a key from hardware. Will generate one to import.")
+	// the server is not needed to sign
+	offline := httptest.NewServer(http.NotFoundHandler())
+	offline.Close()
+	output, err := runCommand(t, tempDir, "-s", offline.URL, "trust", "sign-offline", "gun", "--out", bundleFile)
+	assert.NoError(t, err)
+	assert.Contains(t, output, bundleFile)
+	assert.Contains(t, output, data.CanonicalTargetsRole)
 
-		privKey, err := trustmanager.GenerateECDSAKey(rand.Reader)
-		assert.NoError(t, err)
+	_, err = runCommand(t, tempDir, "-s", server.URL, "trust", "publish-bundle", "gun", filepath.Join(tempDir, "missing.json"))
+	assert.Error(t, err)
+	_, err = runCommand(t, tempDir, "-s", server.URL, "trust", "publish-bundle", "othergun", bundleFile)
+	assert.Error(t, err)
 
-		pemBytes, err := trustmanager.EncryptPrivateKey(privKey, "root", testPassphrase)
-		assert.NoError(t, err)
+	output, err = runCommand(t, tempDir, "-s", server.URL, "trust", "publish-bundle", "gun", bundleFile)
+	assert.NoError(t, err)
+	assert.Contains(t, output, "Signed targets with 1 signature(s), 1 required")
+	output, err = runCommand(t, tempDir, "-s", server.URL, "lookup", "gun", target)
+	assert.NoError(t, err)
+	assert.Contains(t, output, target)
 
-		nBytes, err := tempFile.Write(pemBytes)
-		assert.NoError(t, err)
-		tempFile.Close()
-		assert.Equal(t, len(pemBytes), nBytes)
-		rootKeyID = privKey.ID()
-	} else {
-		tempFile.Close()
-		rootKeyID = exportRoot(t, tempFile.Name())
-	}
+	// the bundle can't be published twice
+	_, err = runCommand(t, tempDir, "-s", server.URL, "trust", "publish-bundle", "gun", bundleFile)
+	assert.Error(t, err)
+}
 
-	// import the key
-	_, err = runCommand(t, tempDir, "key", "import", tempFile.Name())
+// Tests regenerating the snapshot offline with the local snapshot key, and
+// publishing it as a bundle
+func TestClientTrustResignSnapshot(t *testing.T) {
+	setUp(t)
+
+	tempDir := tempDirWithConfig(t, "{}")
+	defer os.RemoveAll(tempDir)
+
+	server := setupServer()
+	defer server.Close()
+
+	_, err := runCommand(t, tempDir, "-s", server.URL, "init", "gun")
+	assert.NoError(t, err)
+	_, err = runCommand(t, tempDir, "-s", server.URL, "publish", "gun")
+	assert.NoError(t, err)
+	_, err = runCommand(t, tempDir, "-s", server.URL, "list", "gun")
 	assert.NoError(t, err)
 
-	// if there is hardware available, root will only be on hardware, and not
-	// on disk
-	newRoot, _ := assertNumKeys(t, tempDir, 1, 0, !rootOnHardware())
-	assert.Equal(t, rootKeyID, newRoot[0])
+	// the output file is required
+	_, err = runCommand(t, tempDir, "trust", "resign-snapshot", "gun")
+	assert.Error(t, err)
 
-	// Just to make sure, init a repo and publish
-	_, err = runCommand(t, tempDir, "-s", server.URL, "init", "gun")
+	offline := httptest.NewServer(http.NotFoundHandler())
+	offline.Close()
+	bundleFile := filepath.Join(tempDir, "bundle.json")
+	output, err := runCommand(t, tempDir, "-s", offline.URL, "trust", "resign-snapshot", "gun", "--out", bundleFile)
 	assert.NoError(t, err)
-	assertNumKeys(t, tempDir, 1, 2, !rootOnHardware())
-	assertSuccessfullyPublish(
-		t, tempDir, server.URL, "gun", target, tempFile.Name())
-}
+	assert.Contains(t, output, data.CanonicalSnapshotRole)
 
-// Helper method to get the subdirectory for TUF keys
-func getKeySubdir(role, gun string) string {
-	subdir := notary.PrivDir
-	switch role {
-	case data.CanonicalRootRole:
-		return filepath.Join(subdir, notary.RootKeysSubdir)
-	case data.CanonicalTargetsRole:
-		return filepath.Join(subdir, notary.NonRootKeysSubdir, gun)
-	case data.CanonicalSnapshotRole:
-		return filepath.Join(subdir, notary.NonRootKeysSubdir, gun)
-	default:
-		return filepath.Join(subdir, notary.NonRootKeysSubdir)
-	}
+	output, err = runCommand(t, tempDir, "-s", server.URL, "trust", "publish-bundle", "gun", bundleFile)
+	assert.NoError(t, err)
+	assert.Contains(t, output, "Signed snapshot with 1 signature(s), 1 required")
 }
 
-// Tests importing and exporting keys for all different roles and GUNs
-func TestClientKeyImportExportAllRoles(t *testing.T) {
-	if rootOnHardware() {
-		t.Log("Cannot import or export a non-root key from hardware. Will skip test.")
-		return
-	}
-	// -- setup --
+// Tests cloning the delegations of one GUN onto another, with new keys so
+// that the clone can be published
+func TestClientTrustClone(t *testing.T) {
 	setUp(t)
 
 	tempDir := tempDirWithConfig(t, "{}")
@@ -1100,97 +3642,109 @@ func TestClientKeyImportExportAllRoles(t *testing.T) {
 	server := setupServer()
 	defer server.Close()
 
-	// -- tests --
-	_, err := runCommand(t, tempDir, "-s", server.URL, "init", "gun")
+	privKey, err := trustmanager.GenerateECDSAKey(rand.Reader)
+	assert.NoError(t, err)
+	startTime := time.Now()
+	cert, err := cryptoservice.GenerateCertificate(privKey, "gun", startTime, startTime.AddDate(10, 0, 0))
 	assert.NoError(t, err)
+	certFile := filepath.Join(tempDir, "delegate.crt")
+	assert.NoError(t, ioutil.WriteFile(certFile, trustmanager.CertToPEM(cert), 0644))
 
-	testRoles := append(data.BaseRoles, "targets/releases")
-	// Test importing and exporting keys to all base roles and delegation role
-	for _, role := range testRoles {
-		// Do this while importing keys that have the PEM header role set or have --role set on import
-		for _, setKeyRole := range []bool{true, false} {
-			// Make a new key for this role
-			privKey, err := trustmanager.GenerateECDSAKey(rand.Reader)
-			assert.NoError(t, err)
+	for _, gun := range []string{"gun", "gun2"} {
+		_, err = runCommand(t, tempDir, "-s", server.URL, "init", gun)
+		assert.NoError(t, err)
+	}
+	_, err = runCommand(t, tempDir, "delegation", "add", "gun", "targets/releases", certFile, "--paths", "releases/")
+	assert.NoError(t, err)
+	_, err = runCommand(t, tempDir, "-s", server.URL, "publish", "gun")
+	assert.NoError(t, err)
+
+	// two GUNs are required
+	_, err = runCommand(t, tempDir, "-s", server.URL, "trust", "clone", "gun")
+	assert.Error(t, err)
+
+	output, err := runCommand(t, tempDir, "-s", server.URL, "trust", "clone", "gun", "gun2", "--regenerate-keys")
+	assert.NoError(t, err)
+	assert.Contains(t, output, "Staged 1 delegations of gun on gun2")
+	assert.Contains(t, output, "targets/releases")
+	_, err = runCommand(t, tempDir, "-s", server.URL, "publish", "gun2")
+	assert.NoError(t, err)
+
+	output, err = runCommand(t, tempDir, "-s", server.URL, "delegation", "list", "gun2")
+	assert.NoError(t, err)
+	assert.Contains(t, output, "targets/releases")
+	assert.Contains(t, output, "releases/")
+	keyID, err := utils.CanonicalKeyID(trustmanager.CertToKey(cert))
+	assert.NoError(t, err)
+	assert.NotContains(t, output, keyID)
+}
 
-			// Make a tempfile for importing
-			tempFile, err := ioutil.TempFile("", "pemfile")
-			assert.NoError(t, err)
+// Tests that with the timestamp key of the server pinned for a GUN, the GUN
+// can only be read if the timestamp is signed by the pinned key
+func TestClientServerKeyPins(t *testing.T) {
+	setUp(t)
 
-			// Specify the role in the PEM header
-			pemBytes, err := trustmanager.EncryptPrivateKey(privKey, role, testPassphrase)
-			assert.NoError(t, err)
-			ioutil.WriteFile(tempFile.Name(), pemBytes, 0644)
+	tempDir := tempDirWithConfig(t, "{}")
+	defer os.RemoveAll(tempDir)
 
-			// If we need to set the key role with the --role flag, do so on import
-			if setKeyRole {
-				// If it's targets/snapshot we must specify the GUN
-				if role == data.CanonicalTargetsRole || role == data.CanonicalSnapshotRole {
-					_, err = runCommand(t, tempDir, "key", "import", tempFile.Name(), "--gun", "gun", "--role", role)
-				} else {
-					_, err = runCommand(t, tempDir, "key", "import", tempFile.Name(), "--role", role)
-				}
-			} else {
-				// If it's targets/snapshot we must specify the GUN
-				if role == data.CanonicalTargetsRole || role == data.CanonicalSnapshotRole {
-					_, err = runCommand(t, tempDir, "key", "import", tempFile.Name(), "--gun", "gun")
-				} else {
-					_, err = runCommand(t, tempDir, "key", "import", tempFile.Name())
-				}
-			}
-			assert.NoError(t, err)
+	server := setupServer()
+	defer server.Close()
 
-			// Test that we imported correctly
-			keySubdir := getKeySubdir(role, "gun")
-			_, err = os.Stat(filepath.Join(tempDir, keySubdir, privKey.ID()+".key"))
-			assert.Nil(t, err)
+	_, err := runCommand(t, tempDir, "-s", server.URL, "init", "gun")
+	assert.NoError(t, err)
+	_, err = runCommand(t, tempDir, "-s", server.URL, "publish", "gun")
+	assert.NoError(t, err)
 
-			// Remove the input file so we can test exporting
-			assert.NoError(t, os.Remove(tempFile.Name()))
+	outDir := filepath.Join(tempDir, "exported")
+	_, err = runCommand(t, tempDir, "-s", server.URL, "trust", "export-keys", "gun", "--public", outDir)
+	assert.NoError(t, err)
+	manifestBytes, err := ioutil.ReadFile(filepath.Join(outDir, "manifest.json"))
+	assert.NoError(t, err)
+	var manifest struct {
+		Roles map[string][]string `json:"roles"`
+	}
+	assert.NoError(t, json.Unmarshal(manifestBytes, &manifest))
+	timestampKeyIDs := manifest.Roles[data.CanonicalTimestampRole]
+	assert.Len(t, timestampKeyIDs, 1)
 
-			// Make a tempfile for exporting to
-			tempFile, err = ioutil.TempFile("", "pemfile")
-			assert.NoError(t, err)
+	configFile := filepath.Join(tempDir, "config.json")
+	writeConfig := func(pins string) {
+		assert.NoError(t, ioutil.WriteFile(configFile, []byte(`{"server_key_pins": `+pins+`}`), 0644))
+	}
 
-			// Ensure exporting this key by ID gets the same key
-			_, err = runCommand(t, tempDir, "key", "export", privKey.ID(), tempFile.Name())
-			assert.NoError(t, err)
-			// Compare the bytes of the exported file and the root key file in the repo
-			exportedBytes, err := ioutil.ReadFile(tempFile.Name())
-			assert.NoError(t, err)
-			repoBytes, err := ioutil.ReadFile(filepath.Join(tempDir, keySubdir, privKey.ID()+".key"))
-			assert.NoError(t, err)
-			assert.Equal(t, repoBytes, exportedBytes)
+	writeConfig(`{"gun": {"timestamp": ["` + timestampKeyIDs[0] + `"]}}`)
+	_, err = runCommand(t, tempDir, "-s", server.URL, "list", "gun")
+	assert.NoError(t, err)
 
-			// Ensure exporting this key and changing the passphrase works
-			_, err = runCommand(t, tempDir, "key", "export", privKey.ID(), tempFile.Name(), "-p")
-			assert.NoError(t, err)
+	writeConfig(`{"gun": {"timestamp": ["0123456789abcdef"]}}`)
+	_, err = runCommand(t, tempDir, "-s", server.URL, "list", "gun")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "not signed by any of the server keys pinned for it")
 
-			// Remove the export file for cleanup
-			assert.NoError(t, os.Remove(tempFile.Name()))
-		}
-	}
-}
+	// GUNs are matched regardless of case
+	writeConfig(`{"GUN": {"timestamp": ["0123456789abcdef"]}}`)
+	_, err = runCommand(t, tempDir, "-s", server.URL, "list", "gun")
+	assert.Error(t, err)
 
-func assertNumCerts(t *testing.T, tempDir string, expectedNum int) []string {
-	output, err := runCommand(t, tempDir, "cert", "list")
+	// the pins of other GUNs don't apply
+	writeConfig(`{"other": {"timestamp": ["0123456789abcdef"]}}`)
+	_, err = runCommand(t, tempDir, "-s", server.URL, "list", "gun")
 	assert.NoError(t, err)
-	lines := splitLines(strings.TrimSpace(output))
 
-	if expectedNum == 0 {
-		assert.Len(t, lines, 1)
-		assert.Equal(t, "No trusted root certificates present.", lines[0])
-		return []string{}
+	// only the timestamp and snapshot keys can be pinned
+	for _, pins := range []string{`{"gun": {"targets": ["0123456789abcdef"]}}`, `{"gun": ["0123456789abcdef"]}`} {
+		writeConfig(pins)
+		_, err = runCommand(t, tempDir, "-s", server.URL, "list", "gun")
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "invalid server_key_pins")
 	}
-
-	assert.Len(t, lines, expectedNum+2)
-	return lines[2:]
 }
 
-// TestClientCertInteraction
-func TestClientCertInteraction(t *testing.T) {
-	// -- setup --
+// Tests publishing with explicitly chosen signing keys, which has to be keys
+// of the roles being signed, and that the signatures of each role are reported
+func TestClientPublishSignWith(t *testing.T) {
 	setUp(t)
+	var target = "sdgkadga"
 
 	tempDir := tempDirWithConfig(t, "{}")
 	defer os.RemoveAll(tempDir)
@@ -1198,70 +3752,140 @@ func TestClientCertInteraction(t *testing.T) {
 	server := setupServer()
 	defer server.Close()
 
-	// -- tests --
-	_, err := runCommand(t, tempDir, "-s", server.URL, "init", "gun1")
+	_, err := runCommand(t, tempDir, "-s", server.URL, "init", "gun")
 	assert.NoError(t, err)
-	_, err = runCommand(t, tempDir, "-s", server.URL, "init", "gun2")
+	output, err := runCommand(t, tempDir, "-s", server.URL, "publish", "gun")
 	assert.NoError(t, err)
-	certs := assertNumCerts(t, tempDir, 2)
-	// root is always on disk, because even if there's a yubikey a backup is created
-	assertNumKeys(t, tempDir, 1, 4, true)
+	assert.Contains(t, output, "Signed targets with 1 signature(s), 1 required")
 
-	// remove certs for one gun
-	_, err = runCommand(t, tempDir, "cert", "remove", "-g", "gun1", "-y")
+	keyStore, err := trustmanager.NewKeyFileStore(tempDir, passphrase.ConstantRetriever(testPassphrase))
 	assert.NoError(t, err)
-	certs = assertNumCerts(t, tempDir, 1)
-	// assert that when we remove cert by gun, we do not remove repo signing keys
-	// (root is always on disk, because even if there's a yubikey a backup is created)
-	assertNumKeys(t, tempDir, 1, 4, true)
-	// assert that when we remove cert by gun, we also remove TUF metadata
-	_, err = os.Stat(filepath.Join(tempDir, "tuf", "gun1"))
-	assert.Error(t, err)
+	keyIDs := cryptoservice.NewCryptoService("gun", keyStore).ListKeys(data.CanonicalTargetsRole)
+	assert.Len(t, keyIDs, 1)
 
-	// remove a single cert
-	certID := strings.Fields(certs[0])[1]
-	// passing an empty gun here because the string for the previous gun has
-	// has already been stored (a drawback of running these commands without)
-	// shelling out
-	_, err = runCommand(t, tempDir, "cert", "remove", certID, "-y", "-g", "")
+	tempFile, err := ioutil.TempFile("", "targetfile")
 	assert.NoError(t, err)
-	assertNumCerts(t, tempDir, 0)
-	// assert that when we remove the last cert ID for a gun, we also remove TUF metadata
-	_, err = os.Stat(filepath.Join(tempDir, "tuf", "gun2"))
+	tempFile.Close()
+	defer os.Remove(tempFile.Name())
+	_, err = runCommand(t, tempDir, "add", "gun", target, tempFile.Name())
+	assert.NoError(t, err)
+
+	_, err = runCommand(t, tempDir, "-s", server.URL, "publish", "gun", "--sign-with", "nonexistent")
 	assert.Error(t, err)
 
-	// Setup certificate with nonexistent repo GUN
-	// Check that we can only remove one certificate when specifying one ID
-	startTime := time.Now()
+	// non-root keys are listed by their path, which is prefixed with the GUN
+	output, err = runCommand(t, tempDir, "-s", server.URL, "publish", "gun", "--sign-with", filepath.Base(keyIDs[0]))
+	assert.NoError(t, err)
+	assert.Contains(t, output, "Signed targets with 1 signature(s), 1 required")
+	output, err = runCommand(t, tempDir, "-s", server.URL, "lookup", "gun", target)
+	assert.NoError(t, err)
+	assert.Contains(t, output, target)
+}
+
+// Tests exporting the public keys of every role, along with a manifest of the
+// key IDs of each role
+func TestClientTrustExportKeys(t *testing.T) {
+	setUp(t)
+
+	tempDir := tempDirWithConfig(t, "{}")
+	defer os.RemoveAll(tempDir)
+
+	server := setupServer()
+	defer server.Close()
+
+	tempFile, err := ioutil.TempFile("", "pemfile")
+	assert.NoError(t, err)
 	privKey, err := trustmanager.GenerateECDSAKey(rand.Reader)
 	assert.NoError(t, err)
-	noGunCert, err := cryptoservice.GenerateCertificate(
-		privKey, "nonexistent", startTime, startTime.AddDate(10, 0, 0))
+	startTime := time.Now()
+	cert, err := cryptoservice.GenerateCertificate(privKey, "gun", startTime, startTime.AddDate(10, 0, 0))
 	assert.NoError(t, err)
-	certStore, err := trustmanager.NewX509FileStore(filepath.Join(tempDir, "trusted_certificates"))
+	_, err = tempFile.Write(trustmanager.CertToPEM(cert))
 	assert.NoError(t, err)
-	err = certStore.AddCert(noGunCert)
+	tempFile.Close()
+	defer os.Remove(tempFile.Name())
+	delegationKeyID := trustmanager.CertToKey(cert).ID()
+
+	_, err = runCommand(t, tempDir, "-s", server.URL, "init", "gun")
+	assert.NoError(t, err)
+	_, err = runCommand(t, tempDir, "delegation", "add", "gun", "targets/releases", tempFile.Name(), "--all-paths")
+	assert.NoError(t, err)
+	_, err = runCommand(t, tempDir, "-s", server.URL, "publish", "gun")
 	assert.NoError(t, err)
 
-	certs = assertNumCerts(t, tempDir, 1)
-	certID = strings.Fields(certs[0])[1]
+	outDir := filepath.Join(tempDir, "exported")
 
-	privKey, err = trustmanager.GenerateECDSAKey(rand.Reader)
+	// only public keys can be exported
+	_, err = runCommand(t, tempDir, "-s", server.URL, "trust", "export-keys", "gun", outDir)
+	assert.Error(t, err)
+	_, err = os.Stat(outDir)
+	assert.True(t, os.IsNotExist(err))
+
+	output, err := runCommand(t, tempDir, "-s", server.URL, "trust", "export-keys", "gun", "--public", outDir)
 	assert.NoError(t, err)
-	noGunCert2, err := cryptoservice.GenerateCertificate(
-		privKey, "nonexistent", startTime, startTime.AddDate(10, 0, 0))
+	assert.Contains(t, output, outDir)
+
+	manifestBytes, err := ioutil.ReadFile(filepath.Join(outDir, "manifest.json"))
 	assert.NoError(t, err)
-	err = certStore.AddCert(noGunCert2)
+	var manifest struct {
+		GUN   string              `json:"gun"`
+		Roles map[string][]string `json:"roles"`
+	}
+	assert.NoError(t, json.Unmarshal(manifestBytes, &manifest))
+	assert.Equal(t, "gun", manifest.GUN)
+	assert.Len(t, manifest.Roles, len(data.BaseRoles)+1)
+	assert.Equal(t, []string{delegationKeyID}, manifest.Roles["targets/releases"])
+
+	for role, keyIDs := range manifest.Roles {
+		assert.Len(t, keyIDs, 1, role)
+		for _, keyID := range keyIDs {
+			pemBytes, err := ioutil.ReadFile(filepath.Join(outDir, filepath.FromSlash(role), keyID+".pem"))
+			assert.NoError(t, err)
+			block, _ := pem.Decode(pemBytes)
+			assert.NotNil(t, block, role)
+		}
+	}
+	pemBytes, err := ioutil.ReadFile(filepath.Join(outDir, "targets", "releases", delegationKeyID+".pem"))
 	assert.NoError(t, err)
+	assert.Equal(t, trustmanager.CertToPEM(cert), pemBytes)
+}
 
-	certs = assertNumCerts(t, tempDir, 2)
+// Tests initializing a repo with a root key from a PEM file, which is checked
+// before anything is imported or initialized
+func TestClientInitWithRootKey(t *testing.T) {
+	setUp(t)
 
-	// passing an empty gun to overwrite previously stored gun
-	_, err = runCommand(t, tempDir, "cert", "remove", certID, "-y", "-g", "")
+	tempDir := tempDirWithConfig(t, "{}")
+	defer os.RemoveAll(tempDir)
+
+	server := setupServer()
+	defer server.Close()
+
+	// ed25519 keys can't sign the root certificate
+	edKey, err := trustmanager.GenerateED25519Key(rand.Reader)
+	assert.NoError(t, err)
+	edPEM, err := trustmanager.EncryptPrivateKey(edKey, data.CanonicalRootRole, testPassphrase)
 	assert.NoError(t, err)
+	edFile := filepath.Join(tempDir, "ed25519.pem")
+	assert.NoError(t, ioutil.WriteFile(edFile, edPEM, 0600))
 
-	// Since another cert with the same GUN exists, we didn't remove everything
-	assertNumCerts(t, tempDir, 1)
+	_, err = runCommand(t, tempDir, "-s", server.URL, "init", "gun", "--root-key", edFile)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "ECDSA or RSA")
+	assertNumKeys(t, tempDir, 0, 0, true)
+	_, err = runCommand(t, tempDir, "-s", server.URL, "init", "gun", "--root-key", filepath.Join(tempDir, "nonexistent.pem"))
+	assert.Error(t, err)
+	assertNumKeys(t, tempDir, 0, 0, true)
+
+	rootFile := filepath.Join(tempDir, "root.pem")
+	rootKeyID := exportRoot(t, rootFile)
+	output, err := runCommand(t, tempDir, "-s", server.URL, "init", "gun", "--root-key", rootFile)
+	assert.NoError(t, err)
+	assert.Contains(t, output, rootKeyID)
+	root, _ := assertNumKeys(t, tempDir, 1, 2, true)
+	assert.Equal(t, []string{rootKeyID}, root)
+	_, err = runCommand(t, tempDir, "-s", server.URL, "publish", "gun")
+	assert.NoError(t, err)
 }
 
 // Tests default root key generation
@@ -1304,6 +3928,12 @@ func TestLogLevelFlags(t *testing.T) {
 	n.verbose = false
 	n.setVerbosityLevel()
 	assert.Equal(t, "debug", logrus.GetLevel().String())
+
+	// Test that --fail-on-warning logs the warnings it fails on
+	n.debug = false
+	n.failOnWarning = true
+	n.setVerbosityLevel()
+	assert.Equal(t, "warning", logrus.GetLevel().String())
 }
 
 func TestClientKeyPassphraseChange(t *testing.T) {
@@ -1370,3 +4000,137 @@ func TestMain(m *testing.M) {
 	}
 	os.Exit(m.Run())
 }
+
+// With --fail-on-warning, a command that printed a warning fails once it has
+// finished, and the commands that print none are unaffected
+func TestClientFailOnWarning(t *testing.T) {
+	setUp(t)
+
+	tempDir := tempDirWithConfig(t, "{}")
+	defer os.RemoveAll(tempDir)
+
+	server := setupServer()
+	defer server.Close()
+
+	_, err := runCommand(t, tempDir, "-s", server.URL, "init", "gun")
+	assert.NoError(t, err)
+	_, err = runCommand(t, tempDir, "-s", server.URL, "publish", "gun")
+	assert.NoError(t, err)
+
+	_, err = runCommand(t, tempDir, "-s", server.URL, "--tls-skip-verify", "list", "gun")
+	assert.NoError(t, err)
+	_, err = runCommand(t, tempDir, "-s", server.URL, "--tls-skip-verify", "--fail-on-warning", "list", "gun")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "--fail-on-warning")
+
+	_, err = runCommand(t, tempDir, "-s", server.URL, "--fail-on-warning", "list", "gun")
+	assert.NoError(t, err)
+
+	// warnings logged by the client count too, such as for a change that
+	// can't be read
+	assert.NoError(t, ioutil.WriteFile(
+		filepath.Join(tempDir, "tuf", "gun", "changelist", "garbage"), []byte("{"), 0600))
+	_, err = runCommand(t, tempDir, "-s", server.URL, "status", "gun")
+	assert.NoError(t, err)
+	_, err = runCommand(t, tempDir, "-s", server.URL, "--fail-on-warning", "status", "gun")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "--fail-on-warning")
+}
+
+// fsck reports leftover temporary files, a stale key index and inconsistent
+// metadata, and only repairs the first two
+func TestClientTrustFsck(t *testing.T) {
+	setUp(t)
+
+	tempDir := tempDirWithConfig(t, "{}")
+	defer os.RemoveAll(tempDir)
+
+	server := setupServer()
+	defer server.Close()
+
+	_, err := runCommand(t, tempDir, "-s", server.URL, "init", "gun")
+	assert.NoError(t, err)
+	_, err = runCommand(t, tempDir, "-s", server.URL, "publish", "gun")
+	assert.NoError(t, err)
+
+	output, err := runCommand(t, tempDir, "trust", "fsck")
+	assert.NoError(t, err)
+	assert.Contains(t, output, "No problems found.")
+
+	// a temporary file is only left behind once it is no longer being written
+	tempFile := filepath.Join(tempDir, "private", "root_keys", ".abc.key.tmp12345")
+	assert.NoError(t, ioutil.WriteFile(tempFile, []byte("partial"), 0600))
+	output, err = runCommand(t, tempDir, "trust", "fsck")
+	assert.NoError(t, err)
+	assert.Contains(t, output, "No problems found.")
+	old := time.Now().Add(-time.Hour)
+	assert.NoError(t, os.Chtimes(tempFile, old, old))
+
+	indexFile := filepath.Join(tempDir, "private", "index.json")
+	assert.NoError(t, ioutil.WriteFile(indexFile, []byte("{}\n"), 0600))
+	targetsFile := filepath.Join(tempDir, "tuf", "gun", "metadata", "targets.json")
+	targets, err := ioutil.ReadFile(targetsFile)
+	assert.NoError(t, err)
+	assert.NoError(t, ioutil.WriteFile(targetsFile, append(targets, ' '), 0600))
+
+	output, err = runCommand(t, tempDir, "trust", "fsck", "gun")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "3 problem(s)")
+	assert.Contains(t, output, tempFile+": temporary file")
+	assert.Contains(t, output, indexFile+": the key index")
+	assert.Contains(t, output, targetsFile+": does not match")
+
+	// the metadata is left alone
+	output, err = runCommand(t, tempDir, "trust", "fsck", "--repair")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "1 problem(s)")
+	assert.Contains(t, output, "(repaired)")
+	_, err = os.Stat(tempFile)
+	assert.True(t, os.IsNotExist(err))
+	upToDate, err := trustmanager.KeyIndexUpToDate(tempDir)
+	assert.NoError(t, err)
+	assert.True(t, upToDate)
+	repaired, err := ioutil.ReadFile(targetsFile)
+	assert.NoError(t, err)
+	assert.Equal(t, append(targets, ' '), repaired)
+
+	assert.NoError(t, ioutil.WriteFile(targetsFile, targets, 0600))
+	_, err = runCommand(t, tempDir, "trust", "fsck")
+	assert.NoError(t, err)
+}
+
+// With --no-prompt, commands that would ask for a confirmation or a passphrase
+// fail instead of waiting for an answer
+func TestClientNoPrompt(t *testing.T) {
+	setUp(t)
+
+	tempDir := tempDirWithConfig(t, "{}")
+	defer os.RemoveAll(tempDir)
+
+	server := setupServer()
+	defer server.Close()
+
+	_, err := runCommand(t, tempDir, "-s", server.URL, "init", "gun")
+	assert.NoError(t, err)
+
+	_, err = runCommand(t, tempDir, "--no-prompt", "delete", "gun")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "interactive input required but disabled")
+	_, err = runCommand(t, tempDir, "--no-prompt", "delete", "gun", "-y")
+	assert.NoError(t, err)
+
+	// the passphrases can only come from the prompt
+	oldNewCommand := NewNotaryCommand
+	NewNotaryCommand = func() *cobra.Command {
+		commander := &notaryCommander{}
+		commander.getRetriever = commander.sourceRetriever
+		return commander.GetCommand()
+	}
+	defer func() {
+		NewNotaryCommand = oldNewCommand
+	}()
+
+	_, err = runCommand(t, tempDir, "-s", server.URL, "--no-prompt", "--passphrase-source", "prompt", "init", "gun2")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "interactive input required but disabled")
+}
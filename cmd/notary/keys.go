@@ -3,21 +3,29 @@ package main
 import (
 	"archive/zip"
 	"bufio"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	notaryclient "github.com/docker/notary/client"
 	"github.com/docker/notary/cryptoservice"
 	"github.com/docker/notary/passphrase"
 	"github.com/docker/notary/trustmanager"
 
+	"github.com/docker/go/canonical/json"
 	"github.com/docker/notary"
 	"github.com/docker/notary/tuf/data"
+	"github.com/docker/notary/tuf/signed"
+	"github.com/docker/notary/tuf/utils"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 	"io/ioutil"
@@ -26,7 +34,7 @@ import (
 var cmdKeyTemplate = usageTemplate{
 	Use:   "key",
 	Short: "Operates on keys.",
-	Long:  `Operations on private keys.`,
+	Long:  "Operations on private keys.  Commands that take a keyID also accept a prefix of the ID, or the alias the key is stored with (its role, as shown by `key list`), as long as only one key matches.",
 }
 
 var cmdKeyListTemplate = usageTemplate{
@@ -47,6 +55,18 @@ var cmdKeyGenerateRootKeyTemplate = usageTemplate{
 	Long:  "Generates a new root key with a given algorithm. If hardware key storage (e.g. a Yubikey) is available, the key will be stored both on hardware and on disk (so that it can be backed up).  Please make sure to back up and then remove this on-key disk immediately afterwards.",
 }
 
+var cmdKeyGenerateDelegationKeyTemplate = usageTemplate{
+	Use:   "generate-delegation [ GUN ] [ role ] [ certfilename ]",
+	Short: "Generates a new delegation key and a certificate for it.",
+	Long:  "Generates a new ECDSA key for the delegation role of the Globally Unique Name, and writes a certificate for it to a PEM file that can be added to the delegation with `notary delegation add`.  The certificate is valid for 10 years.  It is self-signed, unless a CA key and certificate are given with --ca-key and --ca-cert, in which case it is signed by the CA.  The passphrase of the CA key will be asked for if it is encrypted.",
+}
+
+var cmdKeyPruneTemplate = usageTemplate{
+	Use:   "prune [ GUN ]",
+	Short: "Lists, or removes, local delegation keys that are no longer used.",
+	Long:  "Lists the local delegation private keys of the Globally Unique Name that are not a key of any delegation role in the latest published metadata, nor of any unpublished delegation change.  This is a dry run unless --delete is given, in which case the keys are removed.",
+}
+
 var cmdKeysBackupTemplate = usageTemplate{
 	Use:   "backup [ zipfilename ]",
 	Short: "Backs up all your on-disk keys to a ZIP file.",
@@ -71,10 +91,15 @@ var cmdKeyImportTemplate = usageTemplate{
 	Long:  "Imports a single key from a PEM file. If a hardware key storage (e.g. Yubikey) is available, the root key will be imported into the hardware but not backed up on disk again.",
 }
 
+// minRemoveKeyIDPrefix is the fewest characters of a key ID that key remove
+// accepts as a prefix of it, so that a short prefix can't remove a key by
+// accident
+const minRemoveKeyIDPrefix = 8
+
 var cmdKeyRemoveTemplate = usageTemplate{
 	Use:   "remove [ keyID ]",
 	Short: "Removes the key with the given keyID.",
-	Long:  "Removes the key with the given keyID.  If the key is stored in more than one location, you will be asked which one to remove.",
+	Long:  fmt.Sprintf("Removes the key with the given keyID.  A prefix of the keyID has to be at least %d characters long.  If the key is stored in more than one location, you will be asked which one to remove.", minRemoveKeyIDPrefix),
 }
 
 var cmdKeyPasswdTemplate = usageTemplate{
@@ -83,6 +108,48 @@ var cmdKeyPasswdTemplate = usageTemplate{
 	Long:  "Changes the passphrase for the key with the given keyID.  Will require validation of the old passphrase.",
 }
 
+var cmdKeyVerifyIDsTemplate = usageTemplate{
+	Use:   "verify-ids",
+	Short: "Verifies that stored key IDs match their key material.",
+	Long:  "Recomputes the ID of every key known to notary from its key material, and reports any key whose stored ID does not match the recomputed canonical ID.  This may require the passphrases of the keys.",
+}
+
+var cmdKeyMigrateIDsTemplate = usageTemplate{
+	Use:   "migrate-ids",
+	Short: "Renames the keys on disk to the IDs of another key ID derivation.",
+	Long:  "Renames the keys on disk stored under their ID from the --from key ID derivation to their ID from the --to derivation, without changing the key material.  The canonical ID of a key is the ID of its public key, which notary stores keys under, and its certificate ID is the ID of its certificate, which root metadata lists it by.  The IDs are computed from the trusted certificates, so only keys with a trusted certificate are renamed.  Every renamed key is then checked by signing and verifying a message with it, and is moved back if that fails.  With --dry-run, the keys that would be renamed are only listed, and no key is decrypted.  Does not work on keys that are only in hardware (e.g. Yubikeys).  This may require the passphrases of the renamed keys.",
+}
+
+var cmdKeyCheckPassphraseTemplate = usageTemplate{
+	Use:   "check-passphrase [ keyID ]",
+	Short: "Checks the passphrase for the key with the given keyID.",
+	Long:  "Attempts to decrypt the key with the given keyID, to check that the passphrase for it is correct.  The key is not modified.",
+}
+
+var cmdKeyAssociateTemplate = usageTemplate{
+	Use:   "associate [ keyID ] [ GUN ] [ role ]",
+	Short: "Adds a stored key to a delegation role.",
+	Long:  "Stages adding the key with the given keyID, which has to be in the key stores for the Globally Unique Name, to the keys of the delegation role, so that the same key can sign several roles.  If another delegation of the Globally Unique Name already has a certificate for the key, the certificate is added, and it must not have expired; otherwise the public part of the key is added.  This is an online operation.  Please then use `publish` to push the changes to the remote trusted collection.",
+}
+
+var cmdKeyRolesTemplate = usageTemplate{
+	Use:   "roles [ GUN ] [ keyID ]",
+	Short: "Lists the delegation roles that a key can sign.",
+	Long:  "Lists every delegation role of the Globally Unique Name that has the key with the given keyID, which may be either the ID the role lists it under or its canonical ID, among its keys, along with the paths and threshold of each role, to show what a compromise or revocation of the key affects.  This is an online operation.",
+}
+
+var cmdKeyFingerprintTemplate = usageTemplate{
+	Use:   "fingerprint [ PEM file or keyID ]",
+	Short: "Prints the ID and fingerprint of a key.",
+	Long:  "Prints the ID of a key the way notary lists it, its canonical ID, which is the same whether the key is given as a public key or in a certificate, and its SHA-256 fingerprint: the key ID as colon separated pairs of hex digits, which is easier to read out when comparing a key over the phone or by email.  The key is either a PEM file of a public key or certificate, or the key ID, ID prefix or alias of a key in the local key stores, in which case the key has to be decrypted.",
+}
+
+var cmdKeyRotateExpiringTemplate = usageTemplate{
+	Use:   "rotate-expiring [ GUN ]",
+	Short: "Replaces every delegation key of a GUN that expires soon.",
+	Long:  "Finds every key of a delegation role of the Globally Unique Name whose certificate expires within --within (30d by default), and stages replacing it with a new key in every role it is a key of.  A new key is generated for each expiring key, with a self-signed certificate unless --ca-key and --ca-cert are given, except for keys given a replacement certificate with --replace KEYID=CERTFILE.  What would be rotated is listed first, and nothing is generated or staged until that is confirmed.  This is an online operation.",
+}
+
 type keyCommander struct {
 	// these need to be set
 	configGetter func() (*viper.Viper, error)
@@ -95,12 +162,34 @@ type keyCommander struct {
 	keysImportRole             string
 	rotateKeyRole              string
 	rotateKeyServerManaged     bool
+	delegationCAKeyPath        string
+	delegationCACertPath       string
+	pruneDelete                bool
+	migrateIDsFrom             string
+	migrateIDsTo               string
+	migrateIDsDryRun           bool
+	rolesJSON                  bool
+	rotateExpiringWithin       string
+	rotateExpiringReplacements []string
+	rotateExpiringYes          bool
+	listBackend                string
+	output                     outputFile
 }
 
 func (k *keyCommander) GetCommand() *cobra.Command {
 	cmd := cmdKeyTemplate.ToCommand(nil)
-	cmd.AddCommand(cmdKeyListTemplate.ToCommand(k.keysList))
+	cmdKeyList := cmdKeyListTemplate.ToCommand(k.keysList)
+	cmdKeyList.Flags().StringVar(&k.listBackend, "backend", "",
+		"Only list the keys in this kind of storage, such as file or pkcs11")
+	k.output.addFlags(cmdKeyList)
+	cmd.AddCommand(cmdKeyList)
 	cmd.AddCommand(cmdKeyGenerateRootKeyTemplate.ToCommand(k.keysGenerateRootKey))
+	cmdKeyGenerateDelegation := cmdKeyGenerateDelegationKeyTemplate.ToCommand(k.keysGenerateDelegationKey)
+	cmdKeyGenerateDelegation.Flags().StringVar(&k.delegationCAKeyPath, "ca-key", "",
+		"PEM file of the CA private key to sign the certificate with (requires --ca-cert)")
+	cmdKeyGenerateDelegation.Flags().StringVar(&k.delegationCACertPath, "ca-cert", "",
+		"PEM file of the CA certificate to sign the certificate with (requires --ca-key)")
+	cmd.AddCommand(cmdKeyGenerateDelegation)
 	cmd.AddCommand(cmdKeysRestoreTemplate.ToCommand(k.keysRestore))
 	cmdKeysImport := cmdKeyImportTemplate.ToCommand(k.keysImport)
 	cmdKeysImport.Flags().StringVarP(
@@ -111,6 +200,18 @@ func (k *keyCommander) GetCommand() *cobra.Command {
 
 	cmd.AddCommand(cmdKeyRemoveTemplate.ToCommand(k.keyRemove))
 	cmd.AddCommand(cmdKeyPasswdTemplate.ToCommand(k.keyPassphraseChange))
+	cmd.AddCommand(cmdKeyCheckPassphraseTemplate.ToCommand(k.keyCheckPassphrase))
+	cmd.AddCommand(cmdKeyVerifyIDsTemplate.ToCommand(k.keysVerifyIDs))
+	cmd.AddCommand(cmdKeyFingerprintTemplate.ToCommand(k.keyFingerprint))
+
+	cmdKeyMigrateIDs := cmdKeyMigrateIDsTemplate.ToCommand(k.keysMigrateIDs)
+	cmdKeyMigrateIDs.Flags().StringVar(&k.migrateIDsFrom, "from", canonicalKeyIDs,
+		"Key ID derivation the keys are currently stored under. Supported values: "+strings.Join(keyIDDerivationNames(), ", "))
+	cmdKeyMigrateIDs.Flags().StringVar(&k.migrateIDsTo, "to", "",
+		"Key ID derivation to rename the keys to. Supported values: "+strings.Join(keyIDDerivationNames(), ", "))
+	cmdKeyMigrateIDs.Flags().BoolVar(&k.migrateIDsDryRun, "dry-run", false,
+		"Only list the keys that would be renamed")
+	cmd.AddCommand(cmdKeyMigrateIDs)
 
 	cmdKeysBackup := cmdKeysBackupTemplate.ToCommand(k.keysBackup)
 	cmdKeysBackup.Flags().StringVarP(
@@ -134,6 +235,31 @@ func (k *keyCommander) GetCommand() *cobra.Command {
 			`and the new keys will be locally generated and stored.`)
 	cmd.AddCommand(cmdRotateKey)
 
+	cmd.AddCommand(cmdKeyAssociateTemplate.ToCommand(k.keysAssociate))
+
+	cmdKeyRoles := cmdKeyRolesTemplate.ToCommand(k.keyRoles)
+	cmdKeyRoles.Flags().BoolVar(&k.rolesJSON, "json", false, "Print the roles as JSON")
+	k.output.addFlags(cmdKeyRoles)
+	cmd.AddCommand(cmdKeyRoles)
+
+	cmdKeyRotateExpiring := cmdKeyRotateExpiringTemplate.ToCommand(k.keysRotateExpiring)
+	cmdKeyRotateExpiring.Flags().StringVar(&k.rotateExpiringWithin, "within", "30d",
+		"Rotate the keys whose certificates expire within this long, in days (e.g. 30d) or as a duration (e.g. 720h)")
+	cmdKeyRotateExpiring.Flags().StringSliceVar(&k.rotateExpiringReplacements, "replace", nil,
+		"Replace a key with the key in a certificate instead of a new key, as KEYID=CERTFILE")
+	cmdKeyRotateExpiring.Flags().StringVar(&k.delegationCAKeyPath, "ca-key", "",
+		"PEM file of the CA private key to sign the certificates of new keys with (requires --ca-cert)")
+	cmdKeyRotateExpiring.Flags().StringVar(&k.delegationCACertPath, "ca-cert", "",
+		"PEM file of the CA certificate to sign the certificates of new keys with (requires --ca-key)")
+	cmdKeyRotateExpiring.Flags().BoolVarP(&k.rotateExpiringYes, "yes", "y", false,
+		"Answer yes to the rotation question (no confirmation)")
+	cmd.AddCommand(cmdKeyRotateExpiring)
+
+	cmdKeyPrune := cmdKeyPruneTemplate.ToCommand(k.keysPrune)
+	cmdKeyPrune.Flags().BoolVar(&k.pruneDelete, "delete", false,
+		"Remove the unused delegation keys, instead of only listing them")
+	cmd.AddCommand(cmdKeyPrune)
+
 	return cmd
 }
 
@@ -152,10 +278,14 @@ func (k *keyCommander) keysList(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
-	cmd.Println("")
-	prettyPrintKeys(ks, cmd.Out())
-	cmd.Println("")
-	return nil
+	out, closeOutput, err := k.output.open(cmd)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(out, "")
+	prettyPrintKeys(ks, k.listBackend, out)
+	fmt.Fprintln(out, "")
+	return closeOutput()
 }
 
 func (k *keyCommander) keysGenerateRootKey(cmd *cobra.Command, args []string) error {
@@ -203,6 +333,104 @@ func (k *keyCommander) keysGenerateRootKey(cmd *cobra.Command, args []string) er
 	return nil
 }
 
+// keysGenerateDelegationKey generates a key for a delegation role, and writes a
+// certificate for it that is either self-signed or signed by a CA
+func (k *keyCommander) keysGenerateDelegationKey(cmd *cobra.Command, args []string) error {
+	if len(args) != 3 {
+		cmd.Usage()
+		return fmt.Errorf(
+			"Must specify a GUN, a delegation role and a file to write the certificate to")
+	}
+	gun, role, certPath := args[0], args[1], args[2]
+	if !data.IsDelegation(role) {
+		return fmt.Errorf("%s is not a valid delegation role", role)
+	}
+	if (k.delegationCAKeyPath == "") != (k.delegationCACertPath == "") {
+		return fmt.Errorf("--ca-key and --ca-cert must be used together")
+	}
+
+	// Read the CA first, so that a bad CA or passphrase does not leave a new
+	// key behind
+	var (
+		caKey  data.PrivateKey
+		caCert *x509.Certificate
+		err    error
+	)
+	if k.delegationCAKeyPath != "" {
+		if caCert, err = trustmanager.LoadCertFromFile(k.delegationCACertPath); err != nil {
+			return fmt.Errorf("Error reading the CA certificate: %v", err)
+		}
+		if caKey, err = readCAKey(k.delegationCAKeyPath, k.getRetriever()); err != nil {
+			return fmt.Errorf("Error reading the CA key: %v", err)
+		}
+	}
+
+	config, err := k.configGetter()
+	if err != nil {
+		return err
+	}
+	ks, err := k.getKeyStores(config, true)
+	if err != nil {
+		return err
+	}
+	cs := cryptoservice.NewCryptoService(gun, ks...)
+
+	pubKey, err := cs.Create(role, data.ECDSAKey)
+	if err != nil {
+		return fmt.Errorf("Failed to create a new %s key: %v", role, err)
+	}
+	cert, err := delegationCertificate(cs, pubKey.ID(), gun, caKey, caCert)
+	if err == nil {
+		err = ioutil.WriteFile(certPath, trustmanager.CertToPEM(cert), notary.PubCertPerms)
+	}
+	if err != nil {
+		// the key is of no use without a certificate
+		cs.RemoveKey(pubKey.ID())
+		return fmt.Errorf("Failed to create a certificate for the new %s key: %v", role, err)
+	}
+
+	cmd.Printf("Generated new %s key for %s with keyID: %s\n", role, gun, pubKey.ID())
+	cmd.Printf("Wrote its certificate to %s\n", certPath)
+	return nil
+}
+
+// delegationCertificate generates a certificate for the delegation key with
+// the given ID, which expires in 10 years.  The certificate is signed by the
+// CA if one is given, and is self-signed otherwise.
+func delegationCertificate(cs *cryptoservice.CryptoService, keyID, gun string,
+	caKey data.PrivateKey, caCert *x509.Certificate) (*x509.Certificate, error) {
+
+	privKey, _, err := cs.GetPrivateKey(keyID)
+	if err != nil {
+		return nil, err
+	}
+	startTime := time.Now()
+	endTime := startTime.AddDate(10, 0, 0)
+	if caKey == nil {
+		return cryptoservice.GenerateCertificate(privKey, gun, startTime, endTime)
+	}
+	return cryptoservice.GenerateCASignedCertificate(privKey, caKey, caCert, gun, startTime, endTime)
+}
+
+// readCAKey reads a PEM encoded CA private key, asking for its passphrase if
+// it is encrypted
+func readCAKey(keyPath string, retriever passphrase.Retriever) (data.PrivateKey, error) {
+	pemBytes, err := ioutil.ReadFile(keyPath)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM encoded key found in %s", keyPath)
+	}
+	if !x509.IsEncryptedPEMBlock(block) {
+		return trustmanager.ParsePEMPrivateKey(pemBytes, "")
+	}
+	privKey, _, err := trustmanager.GetPasswdDecryptBytes(
+		retriever, pemBytes, filepath.Base(keyPath), "delegation CA")
+	return privKey, err
+}
+
 // keysBackup exports a collection of keys to a ZIP file
 func (k *keyCommander) keysBackup(cmd *cobra.Command, args []string) error {
 	if len(args) < 1 {
@@ -252,13 +480,8 @@ func (k *keyCommander) keysExport(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("Must specify key ID and output filename for export")
 	}
 
-	keyID := args[0]
 	exportFilename := args[1]
 
-	if len(keyID) != notary.Sha256HexSize {
-		return fmt.Errorf("Please specify a valid key ID")
-	}
-
 	config, err := k.configGetter()
 	if err != nil {
 		return err
@@ -267,6 +490,10 @@ func (k *keyCommander) keysExport(cmd *cobra.Command, args []string) error {
 	if err != nil {
 		return err
 	}
+	keyID, err := resolveKeyID(args[0], ks)
+	if err != nil {
+		return err
+	}
 
 	// Search for this key in all of our keystores, determine whether this key has a GUN
 	keyGun := ""
@@ -405,6 +632,474 @@ func (k *keyCommander) keysImport(cmd *cobra.Command, args []string) error {
 	return nil
 }
 
+// keysPrune lists the local delegation keys of a GUN that no delegation uses
+// any more, and removes them with --delete
+func (k *keyCommander) keysPrune(cmd *cobra.Command, args []string) error {
+	if len(args) < 1 {
+		cmd.Usage()
+		return fmt.Errorf("Must specify a GUN")
+	}
+
+	config, err := k.configGetter()
+	if err != nil {
+		return err
+	}
+
+	gun := args[0]
+	rt, err := getTransport(config, gun, true)
+	if err != nil {
+		return err
+	}
+	nRepo, err := newRepository(config, gun, rt, k.getRetriever())
+	if err != nil {
+		return err
+	}
+
+	orphaned, err := nRepo.ListOrphanedDelegationKeys()
+	if err != nil {
+		return fmt.Errorf("Error retrieving delegation roles for repository %s: %v", gun, err)
+	}
+	if len(orphaned) == 0 {
+		cmd.Printf("\nNo unused delegation keys for %s.\n", gun)
+		return nil
+	}
+
+	if !k.pruneDelete {
+		cmd.Printf("\nThe following delegation keys for %s are not used by any delegation:\n\n", gun)
+		for _, keyID := range orphaned {
+			cmd.Printf("  %s\n", keyID)
+		}
+		cmd.Println("\nRun this command again with --delete to remove them.")
+		return nil
+	}
+
+	cmd.Println("")
+	for _, keyID := range orphaned {
+		if err := nRepo.CryptoService.RemoveKey(keyID); err != nil {
+			return fmt.Errorf("Error removing key %s: %v", keyID, err)
+		}
+		cmd.Printf("Removed unused delegation key %s\n", keyID)
+	}
+	return nil
+}
+
+// keyRoles lists the delegation roles of a GUN that a key is one of the keys of
+func (k *keyCommander) keyRoles(cmd *cobra.Command, args []string) error {
+	if len(args) != 2 {
+		cmd.Usage()
+		return fmt.Errorf("Must specify a GUN and a key ID")
+	}
+
+	config, err := k.configGetter()
+	if err != nil {
+		return err
+	}
+
+	gun, keyID := args[0], args[1]
+	rt, err := getTransport(config, gun, true)
+	if err != nil {
+		return err
+	}
+	nRepo, err := newRepository(config, gun, rt, k.getRetriever())
+	if err != nil {
+		return err
+	}
+
+	details, err := nRepo.ListDelegationDetails()
+	if err != nil {
+		return fmt.Errorf("Error retrieving delegation roles for repository %s: %v", gun, err)
+	}
+	roles := rolesWithKey(details, keyID)
+
+	out, closeOutput, err := k.output.open(cmd)
+	if err != nil {
+		return err
+	}
+	if k.rolesJSON {
+		rolesJSON, err := json.MarshalIndent(roles, "", "  ")
+		if err != nil {
+			closeOutput()
+			return err
+		}
+		fmt.Fprintln(out, string(rolesJSON))
+	} else {
+		fmt.Fprintln(out, "")
+		prettyPrintKeyRoles(roles, keyID, out)
+		fmt.Fprintln(out, "")
+	}
+	return closeOutput()
+}
+
+// expiringKey is a delegation key whose certificate expires soon, along with
+// the roles it is a key of and what it is to be replaced with
+type expiringKey struct {
+	KeyID  string
+	Expiry time.Time
+	Roles  []string
+	// CertPath is the certificate of the key replacing this one, or empty if
+	// a new key is generated
+	CertPath string
+	newKey   data.PublicKey
+}
+
+// expiring keys, soonest first
+type expiringKeySorter []expiringKey
+
+func (e expiringKeySorter) Len() int      { return len(e) }
+func (e expiringKeySorter) Swap(i, j int) { e[i], e[j] = e[j], e[i] }
+func (e expiringKeySorter) Less(i, j int) bool {
+	if !e[i].Expiry.Equal(e[j].Expiry) {
+		return e[i].Expiry.Before(e[j].Expiry)
+	}
+	return e[i].KeyID < e[j].KeyID
+}
+
+// keysRotateExpiring stages replacing every delegation key of a GUN whose
+// certificate expires within the window, in every role it is a key of
+func (k *keyCommander) keysRotateExpiring(cmd *cobra.Command, args []string) error {
+	if len(args) != 1 {
+		cmd.Usage()
+		return fmt.Errorf("Must specify a GUN")
+	}
+	within, err := parseExpiryWindow(k.rotateExpiringWithin)
+	if err != nil {
+		return err
+	}
+	replacements, err := parseKeyReplacements(k.rotateExpiringReplacements)
+	if err != nil {
+		return err
+	}
+	if (k.delegationCAKeyPath == "") != (k.delegationCACertPath == "") {
+		return fmt.Errorf("--ca-key and --ca-cert must be used together")
+	}
+
+	config, err := k.configGetter()
+	if err != nil {
+		return err
+	}
+
+	gun := args[0]
+	rt, err := getTransport(config, gun, true)
+	if err != nil {
+		return err
+	}
+	nRepo, err := newRepository(config, gun, rt, k.getRetriever())
+	if err != nil {
+		return err
+	}
+
+	details, err := nRepo.ListDelegationDetails()
+	if err != nil {
+		return fmt.Errorf("Error retrieving delegation roles for repository %s: %v", gun, err)
+	}
+	deadline := time.Now().Add(within)
+	expiring := expiringKeys(details, deadline)
+
+	// everything given on the command line is checked before anything is
+	// generated or staged
+	isExpiring := make(map[string]bool, len(expiring))
+	for _, key := range expiring {
+		isExpiring[key.KeyID] = true
+	}
+	for keyID := range replacements {
+		if !isExpiring[keyID] {
+			return fmt.Errorf("key %s given with --replace is not a delegation key that expires within %s", keyID, k.rotateExpiringWithin)
+		}
+	}
+	generate := false
+	for i := range expiring {
+		key := &expiring[i]
+		certPath, ok := replacements[key.KeyID]
+		if !ok {
+			generate = true
+			continue
+		}
+		if key.newKey, err = replacementKey(key.KeyID, certPath, deadline); err != nil {
+			return err
+		}
+		key.CertPath = certPath
+	}
+
+	var (
+		caKey  data.PrivateKey
+		caCert *x509.Certificate
+	)
+	if generate && k.delegationCAKeyPath != "" {
+		if caCert, err = trustmanager.LoadCertFromFile(k.delegationCACertPath); err != nil {
+			return fmt.Errorf("Error reading the CA certificate: %v", err)
+		}
+		if caKey, err = readCAKey(k.delegationCAKeyPath, k.getRetriever()); err != nil {
+			return fmt.Errorf("Error reading the CA key: %v", err)
+		}
+	}
+
+	if len(expiring) == 0 {
+		cmd.Printf("\nNo delegation keys of %s expire within %s.\n\n", gun, k.rotateExpiringWithin)
+		return nil
+	}
+	cmd.Println("")
+	prettyPrintExpiringKeys(expiring, cmd.Out())
+	cmd.Println("\nAre you sure you want to stage these key rotations? (yes/no)")
+	// Ask for confirmation before generating any key, unless -y is provided
+	if !k.rotateExpiringYes {
+		if err := checkPromptAllowed(config); err != nil {
+			return err
+		}
+		if !askConfirm() {
+			return fmt.Errorf("Aborting action.")
+		}
+	} else {
+		cmd.Println("Confirmed `yes` from flag")
+	}
+
+	var cs *cryptoservice.CryptoService
+	if generate {
+		ks, err := k.getKeyStores(config, true)
+		if err != nil {
+			return err
+		}
+		cs = cryptoservice.NewCryptoService(gun, ks...)
+	}
+
+	cmd.Println("")
+	for i := range expiring {
+		key := &expiring[i]
+		if key.newKey == nil {
+			if key.newKey, err = createDelegationKey(cs, key.Roles[0], gun, caKey, caCert); err != nil {
+				return err
+			}
+		}
+		newKeyID, err := utils.CanonicalKeyID(key.newKey)
+		if err != nil {
+			return err
+		}
+		for _, role := range key.Roles {
+			if err := nRepo.RotateDelegationKey(role, key.KeyID, key.newKey); err != nil {
+				return fmt.Errorf("failed to rotate key %s of delegation role %s: %v", key.KeyID, role, err)
+			}
+			cmd.Printf(
+				"Replacement of key %s with key %s in delegation role %s of repository \"%s\" staged for next publish.\n",
+				key.KeyID, newKeyID, role, gun)
+		}
+	}
+	cmd.Println("")
+	return nil
+}
+
+// parseExpiryWindow parses how long before their expiry keys are rotated,
+// either as a number of days such as 30d, or as a duration such as 720h
+func parseExpiryWindow(window string) (time.Duration, error) {
+	var (
+		d   time.Duration
+		err error
+	)
+	if days := strings.TrimSuffix(window, "d"); days != window {
+		var n int
+		n, err = strconv.Atoi(days)
+		d = time.Duration(n) * 24 * time.Hour
+	} else {
+		d, err = time.ParseDuration(window)
+	}
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid --within %q: must be a positive number of days (e.g. 30d) or duration (e.g. 720h)", window)
+	}
+	return d, nil
+}
+
+// parseKeyReplacements parses the KEYID=CERTFILE replacements given with
+// --replace into the certificate file for each key ID
+func parseKeyReplacements(specs []string) (map[string]string, error) {
+	replacements := make(map[string]string, len(specs))
+	for _, spec := range specs {
+		parts := strings.SplitN(spec, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("invalid --replace %q: must be KEYID=CERTFILE", spec)
+		}
+		if _, ok := replacements[parts[0]]; ok {
+			return nil, fmt.Errorf("key %s is given more than one replacement with --replace", parts[0])
+		}
+		replacements[parts[0]] = parts[1]
+	}
+	return replacements, nil
+}
+
+// expiringKeys returns the delegation keys whose certificates expire before
+// the deadline, each with the roles it is a key of, soonest first
+func expiringKeys(details []notaryclient.DelegationDetail, deadline time.Time) []expiringKey {
+	var keys []expiringKey
+	indices := make(map[string]int)
+	for _, detail := range details {
+		for _, key := range detail.Keys {
+			if key.Expiry == nil || !key.Expiry.Before(deadline) {
+				continue
+			}
+			i, ok := indices[key.ID]
+			if !ok {
+				i = len(keys)
+				indices[key.ID] = i
+				keys = append(keys, expiringKey{KeyID: key.ID, Expiry: *key.Expiry})
+			}
+			keys[i].Roles = append(keys[i].Roles, detail.Name)
+		}
+	}
+	for i := range keys {
+		sort.Strings(keys[i].Roles)
+	}
+	sort.Sort(expiringKeySorter(keys))
+	return keys
+}
+
+// replacementKey reads the certificate a key is to be replaced with, which
+// has to be of a different key, and must not expire before the deadline
+// itself
+func replacementKey(oldKeyID, certPath string, deadline time.Time) (data.PublicKey, error) {
+	newKey, err := readPubKeyFile(certPath, trustmanager.ParsePEMPublicKey)
+	if err != nil {
+		return nil, err
+	}
+	newKeyID, err := utils.CanonicalKeyID(newKey)
+	if err != nil {
+		return nil, err
+	}
+	if newKeyID == oldKeyID {
+		return nil, fmt.Errorf("the certificate %s to replace key %s with is of the same key", certPath, oldKeyID)
+	}
+	if cert, err := trustmanager.LoadCertFromPEM(newKey.Public()); err == nil && cert.NotAfter.Before(deadline) {
+		return nil, fmt.Errorf("the certificate %s to replace key %s with also expires on %s",
+			certPath, oldKeyID, cert.NotAfter.UTC().Format(time.RFC3339))
+	}
+	return newKey, nil
+}
+
+// createDelegationKey generates a new key for a delegation role, and returns
+// it as its certificate.  The key is removed again if the certificate cannot
+// be created, since it is of no use without one.
+func createDelegationKey(cs *cryptoservice.CryptoService, role, gun string,
+	caKey data.PrivateKey, caCert *x509.Certificate) (data.PublicKey, error) {
+
+	pubKey, err := cs.Create(role, data.ECDSAKey)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create a new %s key: %v", role, err)
+	}
+	cert, err := delegationCertificate(cs, pubKey.ID(), gun, caKey, caCert)
+	if err != nil {
+		cs.RemoveKey(pubKey.ID())
+		return nil, fmt.Errorf("Failed to create a certificate for the new %s key: %v", role, err)
+	}
+	return trustmanager.CertToKey(cert), nil
+}
+
+// rolesWithKey returns the delegations that have a key, given by either the
+// ID they list it under or its canonical ID, sorted by name
+func rolesWithKey(details []notaryclient.DelegationDetail, keyID string) []notaryclient.DelegationDetail {
+	roles := []notaryclient.DelegationDetail{}
+	for _, detail := range details {
+		for _, key := range detail.Keys {
+			if key.ID == keyID {
+				roles = append(roles, detail)
+				break
+			}
+			if key.PublicKey == nil {
+				continue
+			}
+			if canonicalID, err := utils.CanonicalKeyID(key.PublicKey); err == nil && canonicalID == keyID {
+				roles = append(roles, detail)
+				break
+			}
+		}
+	}
+	sort.Sort(delegationDetailsByName(roles))
+	return roles
+}
+
+type delegationDetailsByName []notaryclient.DelegationDetail
+
+func (d delegationDetailsByName) Len() int           { return len(d) }
+func (d delegationDetailsByName) Swap(i, j int)      { d[i], d[j] = d[j], d[i] }
+func (d delegationDetailsByName) Less(i, j int) bool { return d[i].Name < d[j].Name }
+
+// keysAssociate stages adding a key that is already stored to the keys of a
+// delegation role
+func (k *keyCommander) keysAssociate(cmd *cobra.Command, args []string) error {
+	if len(args) != 3 {
+		cmd.Usage()
+		return fmt.Errorf("Must specify a key ID, a GUN and a delegation role")
+	}
+	gun, role := args[1], args[2]
+	if !data.IsDelegation(role) {
+		return fmt.Errorf("%s is not a delegation role", role)
+	}
+
+	config, err := k.configGetter()
+	if err != nil {
+		return err
+	}
+	ks, err := k.getKeyStores(config, true)
+	if err != nil {
+		return err
+	}
+	keyID, err := resolveKeyID(args[0], ks)
+	if err != nil {
+		return err
+	}
+	rt, err := getTransport(config, gun, true)
+	if err != nil {
+		return err
+	}
+	nRepo, err := newRepository(config, gun, rt, k.getRetriever())
+	if err != nil {
+		return err
+	}
+
+	privKey, keyRole, err := nRepo.CryptoService.GetPrivateKey(keyID)
+	if err != nil {
+		return fmt.Errorf("Key %s not found for %s: %v", keyID, gun, err)
+	}
+	if keyRole == data.CanonicalRootRole {
+		return fmt.Errorf("Key %s is a root key, which cannot be a delegation key", keyID)
+	}
+	pubKey, err := delegationPublicKey(nRepo, privKey)
+	if err != nil {
+		return err
+	}
+
+	if err := nRepo.AddDelegation(role, []data.PublicKey{pubKey}, nil); err != nil {
+		return fmt.Errorf("Failed to add key %s to delegation role %s: %v", keyID, role, err)
+	}
+	cmd.Printf("Addition of key %s to delegation role %s in repository %s staged for next publish.\n",
+		keyID, role, gun)
+	return nil
+}
+
+// delegationPublicKey returns the public key to add to a delegation for a
+// private key.  This is the certificate of the key if a delegation of the
+// repository already has one, so that the key is delegated to the same way
+// everywhere, and the public part of the key otherwise.  It fails if the
+// certificate has expired.
+func delegationPublicKey(nRepo *notaryclient.NotaryRepository, privKey data.PrivateKey) (data.PublicKey, error) {
+	// a repository that has not been published yet has no delegations, but
+	// any other failure to get them can't be told apart from having none
+	details, err := nRepo.ListDelegationDetails()
+	if _, ok := err.(notaryclient.ErrRepositoryNotExist); ok {
+		details = nil
+	} else if err != nil {
+		return nil, fmt.Errorf("Failed to list the delegations of the repository: %v", err)
+	}
+	for _, detail := range details {
+		for _, key := range detail.Keys {
+			if key.ID != privKey.ID() || key.PublicKey == nil {
+				continue
+			}
+			if key.Expiry != nil && key.Expiry.Before(time.Now()) {
+				return nil, fmt.Errorf("The certificate of key %s, as delegated to by %s, expired on %s",
+					key.ID, detail.Name, key.Expiry.Format("2006-01-02"))
+			}
+			return key.PublicKey, nil
+		}
+	}
+	return data.PublicKeyFromPrivate(privKey), nil
+}
+
 func (k *keyCommander) keysRotate(cmd *cobra.Command, args []string) error {
 	if len(args) < 1 {
 		cmd.Usage()
@@ -443,9 +1138,7 @@ func (k *keyCommander) keysRotate(cmd *cobra.Command, args []string) error {
 			return err
 		}
 	}
-	nRepo, err := notaryclient.NewNotaryRepository(
-		config.GetString("trust_dir"), gun, getRemoteTrustServer(config),
-		rt, k.getRetriever())
+	nRepo, err := newRepository(config, gun, rt, k.getRetriever())
 	if err != nil {
 		return err
 	}
@@ -544,11 +1237,16 @@ func (k *keyCommander) keyRemove(cmd *cobra.Command, args []string) error {
 	if err != nil {
 		return err
 	}
-	keyID := args[0]
-
-	// This is an invalid ID
-	if len(keyID) != notary.Sha256HexSize {
-		return fmt.Errorf("invalid key ID provided: %s", keyID)
+	keyID, err := trustmanager.ResolveKeyIDMinPrefix(args[0], minRemoveKeyIDPrefix, ks...)
+	if err != nil {
+		if _, ok := err.(trustmanager.ErrKeyNotFound); ok && len(args[0]) < minRemoveKeyIDPrefix {
+			return fmt.Errorf("could not find a local key with the key ID or alias %s, and a key ID prefix has to be at least %d characters long to remove a key by", args[0], minRemoveKeyIDPrefix)
+		}
+		return resolveKeyIDError(args[0], err)
+	}
+	// the removal is always confirmed on the terminal
+	if err := checkPromptAllowed(config); err != nil {
+		return err
 	}
 	cmd.Println("")
 	err = removeKeyInteractively(ks, keyID, os.Stdin,
@@ -573,11 +1271,9 @@ func (k *keyCommander) keyPassphraseChange(cmd *cobra.Command, args []string) er
 		return err
 	}
 
-	keyID := args[0]
-
-	// This is an invalid ID
-	if len(keyID) != notary.Sha256HexSize {
-		return fmt.Errorf("invalid key ID provided: %s", keyID)
+	keyID, err := resolveKeyID(args[0], ks)
+	if err != nil {
+		return err
 	}
 
 	// Find the key's GUN by ID, in case it is a non-root key
@@ -610,10 +1306,441 @@ func (k *keyCommander) keyPassphraseChange(cmd *cobra.Command, args []string) er
 	return nil
 }
 
+// keyCheckPassphrase checks that the passphrase for a key is correct by
+// decrypting it
+func (k *keyCommander) keyCheckPassphrase(cmd *cobra.Command, args []string) error {
+	if len(args) < 1 {
+		cmd.Usage()
+		return fmt.Errorf("must specify the key ID of the key to check the passphrase of")
+	}
+
+	config, err := k.configGetter()
+	if err != nil {
+		return err
+	}
+	ks, err := k.getKeyStores(config, true)
+	if err != nil {
+		return err
+	}
+
+	keyID, err := resolveKeyID(args[0], ks)
+	if err != nil {
+		return err
+	}
+
+	encrypted, err := checkKeyPassphrase(ks, keyID)
+	if err != nil {
+		return err
+	}
+
+	cmd.Println("")
+	if encrypted {
+		cmd.Printf("Passphrase is correct for key ID: %s", keyID)
+	} else {
+		cmd.Printf("Key ID %s is not encrypted with a passphrase", keyID)
+	}
+	cmd.Println("")
+	return nil
+}
+
+func (k *keyCommander) keyFingerprint(cmd *cobra.Command, args []string) error {
+	if len(args) < 1 {
+		cmd.Usage()
+		return fmt.Errorf("must specify a PEM file, or the key ID of a stored key, to print the fingerprint of")
+	}
+
+	config, err := k.configGetter()
+	if err != nil {
+		return err
+	}
+
+	var pubKey data.PublicKey
+	if info, statErr := os.Stat(args[0]); statErr == nil && !info.IsDir() {
+		// only the key is fingerprinted, and nothing about the certificate is
+		// trusted, so a certificate that has expired is fine
+		pubKey, err = readPubKeyFile(args[0], trustmanager.ParsePEMPublicKeyWithoutValidation)
+	} else {
+		pubKey, err = k.storedPublicKey(config, args[0])
+	}
+	if err != nil {
+		return err
+	}
+
+	canonicalID, err := utils.CanonicalKeyID(pubKey)
+	if err != nil {
+		return err
+	}
+	cmd.Printf("Key ID:              %s\n", pubKey.ID())
+	cmd.Printf("Canonical key ID:    %s\n", canonicalID)
+	cmd.Printf("SHA-256 fingerprint: %s\n", keyFingerprint(pubKey.ID()))
+	return nil
+}
+
+// storedPublicKey decrypts the key with the given key ID, ID prefix or alias
+// in the local key stores, and returns its public part
+func (k *keyCommander) storedPublicKey(config *viper.Viper, name string) (data.PublicKey, error) {
+	ks, err := k.getKeyStores(config, true)
+	if err != nil {
+		return nil, err
+	}
+	keyID, err := resolveKeyID(name, ks)
+	if err != nil {
+		return nil, err
+	}
+
+	// Find the key's GUN by ID, in case it is a non-root key
+	var keyGUN string
+	for _, store := range ks {
+		for keypath := range store.ListKeys() {
+			if filepath.Base(keypath) == keyID {
+				keyGUN = filepath.Dir(keypath)
+			}
+		}
+	}
+	privKey, _, err := cryptoservice.NewCryptoService(keyGUN, ks...).GetPrivateKey(keyID)
+	if err != nil {
+		return nil, fmt.Errorf("could not retrieve local key for key ID provided: %s", keyID)
+	}
+	return data.PublicKeyFromPrivate(privKey), nil
+}
+
+// keyFingerprint formats a key ID, which is the hex SHA-256 of the key, as
+// colon separated pairs of uppercase hex digits
+func keyFingerprint(keyID string) string {
+	pairs := make([]string, 0, len(keyID)/2)
+	for i := 0; i+1 < len(keyID); i += 2 {
+		pairs = append(pairs, strings.ToUpper(keyID[i:i+2]))
+	}
+	return strings.Join(pairs, ":")
+}
+
+// checkKeyPassphrase decrypts the key with the given ID, using the passphrase
+// retrievers of the key stores it may be in.  It returns false if the key is
+// stored unencrypted, in which case no passphrase was checked.
+func checkKeyPassphrase(keyStores []trustmanager.KeyStore, keyID string) (bool, error) {
+	// Find the key's GUN by ID, in case it is a non-root key
+	var (
+		keyGUN   string
+		keyPath  string
+		keyStore trustmanager.KeyStore
+	)
+	for _, store := range keyStores {
+		for keypath := range store.ListKeys() {
+			if filepath.Base(keypath) == keyID {
+				keyGUN = filepath.Dir(keypath)
+				keyPath = keypath
+				keyStore = store
+			}
+		}
+	}
+	if keyStore == nil {
+		return false, fmt.Errorf("could not find a local key with key ID: %s", keyID)
+	}
+
+	// keys that can be exported and parsed without a passphrase aren't
+	// encrypted, so there is no passphrase to check.  Hardware keys can't be
+	// exported, and are always checked with the retriever.
+	if pemBytes, err := keyStore.ExportKey(keyPath); err == nil {
+		if _, err := trustmanager.ParsePEMPrivateKey(pemBytes, ""); err == nil {
+			return false, nil
+		}
+	}
+
+	cs := cryptoservice.NewCryptoService(keyGUN, keyStores...)
+	_, _, err := cs.GetPrivateKey(keyID)
+	switch err.(type) {
+	case nil:
+		return true, nil
+	case trustmanager.ErrPasswordInvalid, trustmanager.ErrAttemptsExceeded:
+		return false, fmt.Errorf("incorrect passphrase for key ID: %s", keyID)
+	default:
+		return false, fmt.Errorf("could not retrieve local key for key ID %s: %v", keyID, err)
+	}
+}
+
+// keyIDMismatch describes a stored key whose ID could not be verified against
+// its key material
+type keyIDMismatch struct {
+	keyPath    string
+	location   string
+	computedID string // empty if the key could not be read
+	err        error
+}
+
+// verifyKeyIDs recomputes the canonical ID of every key in the given key
+// stores, and returns the keys whose stored ID does not match
+func verifyKeyIDs(keyStores []trustmanager.KeyStore) (int, []keyIDMismatch) {
+	var (
+		numKeys    int
+		mismatches []keyIDMismatch
+	)
+	for _, store := range keyStores {
+		for keyPath := range store.ListKeys() {
+			numKeys++
+			privKey, _, err := store.GetKey(keyPath)
+			if err != nil {
+				mismatches = append(mismatches, keyIDMismatch{
+					keyPath: keyPath, location: store.Name(), err: err})
+				continue
+			}
+			if privKey.ID() != filepath.Base(keyPath) {
+				mismatches = append(mismatches, keyIDMismatch{
+					keyPath: keyPath, location: store.Name(), computedID: privKey.ID()})
+			}
+		}
+	}
+	return numKeys, mismatches
+}
+
+// keysVerifyIDs checks that all stored keys are stored under their canonical ID
+func (k *keyCommander) keysVerifyIDs(cmd *cobra.Command, args []string) error {
+	if len(args) > 0 {
+		cmd.Usage()
+		return fmt.Errorf("")
+	}
+
+	config, err := k.configGetter()
+	if err != nil {
+		return err
+	}
+	ks, err := k.getKeyStores(config, true)
+	if err != nil {
+		return err
+	}
+
+	numKeys, mismatches := verifyKeyIDs(ks)
+
+	cmd.Println("")
+	for _, m := range mismatches {
+		if m.err != nil {
+			cmd.Printf("Unable to verify key %s (%s): %v\n", m.keyPath, m.location, m.err)
+		} else {
+			cmd.Printf("Key %s (%s) has a canonical ID of %s\n", m.keyPath, m.location, m.computedID)
+		}
+	}
+	if len(mismatches) > 0 {
+		cmd.Println("")
+		return fmt.Errorf("%d of %d keys could not be verified", len(mismatches), numKeys)
+	}
+	cmd.Printf("All %d keys have IDs matching their key material.\n", numKeys)
+	cmd.Println("")
+	return nil
+}
+
+// The key ID derivations of key migrate-ids.  The canonical ID of a key is the
+// ID of its public key, which notary stores private keys under, and its
+// certificate ID is the ID of its certificate, which root metadata lists it by.
+const (
+	canonicalKeyIDs   = "canonical"
+	certificateKeyIDs = "certificate"
+)
+
+// keyIDDerivationNames returns the sorted names of the key ID derivations
+func keyIDDerivationNames() []string {
+	return []string{canonicalKeyIDs, certificateKeyIDs}
+}
+
+// keyIDs are the IDs of a key under each key ID derivation, by derivation name
+type keyIDs map[string]string
+
+// certKeyIDs returns the canonical and certificate IDs of the key of each of
+// the certificates.  Only the public keys are needed, so no private key is
+// decrypted.
+func certKeyIDs(certs []*x509.Certificate) ([]keyIDs, error) {
+	ids := make([]keyIDs, 0, len(certs))
+	for _, cert := range certs {
+		certKey := trustmanager.CertToKey(cert)
+		if certKey == nil {
+			continue
+		}
+		canonicalID, err := trustmanager.X509PublicKeyID(certKey)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, keyIDs{canonicalKeyIDs: canonicalID, certificateKeyIDs: certKey.ID()})
+	}
+	return ids, nil
+}
+
+// keyRenamer is a key store that can move a key to another name
+type keyRenamer interface {
+	trustmanager.KeyStore
+	RenameKey(oldName, newName string) error
+}
+
+// keyIDMigration is a stored key to be renamed from its ID under one key ID
+// derivation to its ID under another
+type keyIDMigration struct {
+	oldPath     string
+	newPath     string
+	canonicalID string
+}
+
+// planKeyIDMigrations returns the keys of the store that are stored under
+// their ID from the from derivation, along with the name they would have under
+// the to derivation, given the IDs of the keys that have certificates.  Keys
+// stored under any other ID are left alone.  The IDs are only matched against
+// the names of the keys, so no key is decrypted.  It fails if a new name is
+// already taken.
+func planKeyIDMigrations(store trustmanager.KeyStore, ids []keyIDs, from, to string) ([]keyIDMigration, error) {
+	for _, derivation := range []string{from, to} {
+		if derivation != canonicalKeyIDs && derivation != certificateKeyIDs {
+			return nil, fmt.Errorf("unknown key ID derivation %q (supported: %s)",
+				derivation, strings.Join(keyIDDerivationNames(), ", "))
+		}
+	}
+	if from == to {
+		return nil, nil
+	}
+	byOldID := make(map[string]keyIDs, len(ids))
+	for _, keyIDs := range ids {
+		byOldID[keyIDs[from]] = keyIDs
+	}
+
+	storedKeys := store.ListKeys()
+	keyPaths := make([]string, 0, len(storedKeys))
+	for keyPath := range storedKeys {
+		keyPaths = append(keyPaths, keyPath)
+	}
+	sort.Strings(keyPaths)
+
+	var migrations []keyIDMigration
+	for _, keyPath := range keyPaths {
+		keyIDs, ok := byOldID[filepath.Base(keyPath)]
+		if !ok || keyIDs[to] == keyIDs[from] {
+			continue
+		}
+		newPath := filepath.Join(filepath.Dir(keyPath), keyIDs[to])
+		if _, ok := storedKeys[newPath]; ok {
+			return nil, fmt.Errorf("cannot rename key %s: a key is already stored as %s", keyPath, newPath)
+		}
+		migrations = append(migrations, keyIDMigration{
+			oldPath: keyPath, newPath: newPath, canonicalID: keyIDs[canonicalKeyIDs]})
+	}
+	return migrations, nil
+}
+
+// migrateKeyID renames a key, and moves it back if it no longer signs
+// correctly under its new name, or is not the key its certificate is for
+func migrateKeyID(store keyRenamer, m keyIDMigration) error {
+	if err := store.RenameKey(m.oldPath, m.newPath); err != nil {
+		return err
+	}
+	if err := checkKeySigns(store, m.newPath, m.canonicalID); err != nil {
+		if undoErr := store.RenameKey(m.newPath, m.oldPath); undoErr != nil {
+			return fmt.Errorf("key %s failed to sign after being renamed to %s (%v), and could not be moved back: %v",
+				m.oldPath, m.newPath, err, undoErr)
+		}
+		return fmt.Errorf("key %s failed to sign after being renamed, and was moved back: %v", m.oldPath, err)
+	}
+	return nil
+}
+
+// checkKeySigns loads a stored key, checks that it has the given canonical ID,
+// and that its signatures verify with its public key
+func checkKeySigns(store trustmanager.KeyStore, keyPath, canonicalID string) error {
+	privKey, _, err := store.GetKey(keyPath)
+	if err != nil {
+		return err
+	}
+	if privKey.ID() != canonicalID {
+		return fmt.Errorf("the key has a canonical ID of %s rather than %s", privKey.ID(), canonicalID)
+	}
+	verifier, ok := signed.Verifiers[privKey.SignatureAlgorithm()]
+	if !ok {
+		return fmt.Errorf("no verifier for the signature algorithm %s", privKey.SignatureAlgorithm())
+	}
+	msg := []byte("notary key ID migration check")
+	sig, err := privKey.Sign(rand.Reader, msg, nil)
+	if err != nil {
+		return err
+	}
+	return verifier.Verify(data.PublicKeyFromPrivate(privKey), sig, msg)
+}
+
+// keysMigrateIDs renames the keys on disk from their IDs under one key ID
+// derivation to their IDs under another
+func (k *keyCommander) keysMigrateIDs(cmd *cobra.Command, args []string) error {
+	if len(args) > 0 {
+		cmd.Usage()
+		return fmt.Errorf("")
+	}
+	if k.migrateIDsTo == "" {
+		cmd.Usage()
+		return fmt.Errorf("Must specify the key ID derivation to migrate to with --to")
+	}
+
+	config, err := k.configGetter()
+	if err != nil {
+		return err
+	}
+	ks, err := k.getKeyStores(config, false)
+	if err != nil {
+		return err
+	}
+	store, ok := ks[0].(keyRenamer)
+	if !ok {
+		return fmt.Errorf("the keys in %s cannot be renamed", ks[0].Name())
+	}
+
+	certStore, err := trustmanager.NewX509FileStore(filepath.Join(config.GetString("trust_dir"), notary.TrustedCertsDir))
+	if err != nil {
+		return fmt.Errorf("Failed to open the trusted certificates: %v", err)
+	}
+	ids, err := certKeyIDs(certStore.GetCertificates())
+	if err != nil {
+		return err
+	}
+	migrations, err := planKeyIDMigrations(store, ids, k.migrateIDsFrom, k.migrateIDsTo)
+	if err != nil {
+		return err
+	}
+
+	cmd.Println("")
+	for _, m := range migrations {
+		if k.migrateIDsDryRun {
+			cmd.Printf("Would rename key %s to %s\n", m.oldPath, m.newPath)
+			continue
+		}
+		if err := migrateKeyID(store, m); err != nil {
+			return err
+		}
+		cmd.Printf("Renamed key %s to %s\n", m.oldPath, m.newPath)
+	}
+	if len(migrations) == 0 {
+		cmd.Printf("No keys are stored under their %s ID with a different %s ID.\n",
+			k.migrateIDsFrom, k.migrateIDsTo)
+	}
+	cmd.Println("")
+	return nil
+}
+
+// resolveKeyID finds the ID of the local key given by ID, ID prefix or alias
+func resolveKeyID(name string, keyStores []trustmanager.KeyStore) (string, error) {
+	keyID, err := trustmanager.ResolveKeyID(name, keyStores...)
+	if err != nil {
+		return "", resolveKeyIDError(name, err)
+	}
+	return keyID, nil
+}
+
+// resolveKeyIDError explains that no local key was found for a name
+func resolveKeyIDError(name string, err error) error {
+	if _, ok := err.(trustmanager.ErrKeyNotFound); ok {
+		return fmt.Errorf("could not find a local key with the key ID, ID prefix or alias: %s", name)
+	}
+	return err
+}
+
 func (k *keyCommander) getKeyStores(
 	config *viper.Viper, withHardware bool) ([]trustmanager.KeyStore, error) {
-	retriever := k.getRetriever()
+	return newKeyStores(config, k.getRetriever(), withHardware)
+}
 
+// newKeyStores opens the key store in the trust directory and, if withHardware
+// is set and one is plugged in, the hardware key store in front of it
+func newKeyStores(
+	config *viper.Viper, retriever passphrase.Retriever, withHardware bool) ([]trustmanager.KeyStore, error) {
 	directory := config.GetString("trust_dir")
 	fileKeyStore, err := trustmanager.NewKeyFileStore(directory, retriever)
 	if err != nil {
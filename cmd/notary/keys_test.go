@@ -3,17 +3,21 @@ package main
 import (
 	"bytes"
 	"crypto/rand"
+	"crypto/x509"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/docker/go/canonical/json"
 	"github.com/docker/notary"
 	"github.com/docker/notary/client"
+	"github.com/docker/notary/cryptoservice"
 	"github.com/docker/notary/passphrase"
 	"github.com/docker/notary/trustmanager"
 	"github.com/docker/notary/tuf/data"
@@ -276,6 +280,31 @@ func TestRotateKeyNoGUN(t *testing.T) {
 	assert.Contains(t, err.Error(), "Must specify a GUN")
 }
 
+// key remove won't resolve a key ID prefix shorter than the minimum, so a
+// short prefix can't remove a key by accident
+func TestKeyRemoveShortPrefix(t *testing.T) {
+	tempBaseDir, err := ioutil.TempDir("", "notary-test-")
+	assert.NoError(t, err)
+	defer os.RemoveAll(tempBaseDir)
+
+	fileStore, err := trustmanager.NewKeyFileStore(tempBaseDir, ret)
+	assert.NoError(t, err)
+	key, err := trustmanager.GenerateECDSAKey(rand.Reader)
+	assert.NoError(t, err)
+	assert.NoError(t, fileStore.AddKey(key.ID(), data.CanonicalRootRole, key))
+
+	k := &keyCommander{
+		configGetter: WithTrustDir(tempBaseDir),
+		getRetriever: func() passphrase.Retriever { return ret },
+	}
+	err = k.keyRemove(&cobra.Command{}, []string{key.ID()[:minRemoveKeyIDPrefix-1]})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), fmt.Sprintf("at least %d characters", minRemoveKeyIDPrefix))
+
+	_, _, err = fileStore.GetKey(key.ID())
+	assert.NoError(t, err)
+}
+
 // initialize a repo with keys, so they can be rotated
 func setUpRepo(t *testing.T, tempBaseDir, gun string, ret passphrase.Retriever) (
 	*httptest.Server, map[string]string) {
@@ -361,12 +390,8 @@ func TestRotateKeyBothKeys(t *testing.T) {
 	ts.Close()
 
 	k := &keyCommander{
-		configGetter: func() (*viper.Viper, error) {
-			v := viper.New()
-			v.SetDefault("trust_dir", tempBaseDir)
-			// won't need a remote server URL, since we are creating local keys
-			return v, nil
-		},
+		// won't need a remote server URL, since we are creating local keys
+		configGetter: WithTrustDir(tempBaseDir),
 		getRetriever: func() passphrase.Retriever { return ret },
 	}
 	err = k.keysRotate(&cobra.Command{}, []string{gun})
@@ -410,7 +435,7 @@ func TestChangeKeyPassphraseInvalidID(t *testing.T) {
 	}
 	err := k.keyPassphraseChange(&cobra.Command{}, []string{"too_short"})
 	assert.Error(t, err)
-	assert.Contains(t, err.Error(), "invalid key ID provided")
+	assert.Contains(t, err.Error(), "could not find a local key")
 }
 
 func TestChangeKeyPassphraseInvalidNumArgs(t *testing.T) {
@@ -431,7 +456,7 @@ func TestChangeKeyPassphraseNonexistentID(t *testing.T) {
 	// Valid ID size, but does not exist as a key ID
 	err := k.keyPassphraseChange(&cobra.Command{}, []string{strings.Repeat("x", notary.Sha256HexSize)})
 	assert.Error(t, err)
-	assert.Contains(t, err.Error(), "could not retrieve local key for key ID provided")
+	assert.Contains(t, err.Error(), "could not find a local key")
 }
 
 func TestKeyImportMismatchingRoles(t *testing.T) {
@@ -532,3 +557,336 @@ func generateTempTestKeyFile(t *testing.T, role string) string {
 	tempPrivFile.Close()
 	return tempPrivFile.Name()
 }
+
+// Keys stored under their canonical ID pass verification, and keys stored
+// under any other ID are reported along with their recomputed canonical ID.
+func TestVerifyKeyIDs(t *testing.T) {
+	store := trustmanager.NewKeyMemoryStore(ret)
+
+	goodKey, err := trustmanager.GenerateED25519Key(rand.Reader)
+	assert.NoError(t, err)
+	assert.NoError(t, store.AddKey("gun/"+goodKey.ID(), "targets", goodKey))
+
+	numKeys, mismatches := verifyKeyIDs([]trustmanager.KeyStore{store})
+	assert.Equal(t, 1, numKeys)
+	assert.Len(t, mismatches, 0)
+
+	badKey, err := trustmanager.GenerateED25519Key(rand.Reader)
+	assert.NoError(t, err)
+	assert.NoError(t, store.AddKey("gun/"+goodKey.ID()+"bad", "snapshot", badKey))
+
+	numKeys, mismatches = verifyKeyIDs([]trustmanager.KeyStore{store})
+	assert.Equal(t, 2, numKeys)
+	assert.Len(t, mismatches, 1)
+	assert.Equal(t, "gun/"+goodKey.ID()+"bad", mismatches[0].keyPath)
+	assert.Equal(t, badKey.ID(), mismatches[0].computedID)
+	assert.NoError(t, mismatches[0].err)
+}
+
+// newCertifiedKey generates a key along with a certificate for it
+func newCertifiedKey(t *testing.T) (data.PrivateKey, *x509.Certificate) {
+	privKey, err := trustmanager.GenerateECDSAKey(rand.Reader)
+	assert.NoError(t, err)
+	startTime := time.Now()
+	cert, err := cryptoservice.GenerateCertificate(privKey, "gun", startTime, startTime.AddDate(10, 0, 0))
+	assert.NoError(t, err)
+	return privKey, cert
+}
+
+// The canonical ID of a certified key is the ID of its public key, and its
+// certificate ID is the ID of its certificate
+func TestCertKeyIDs(t *testing.T) {
+	privKey, cert := newCertifiedKey(t)
+
+	ids, err := certKeyIDs([]*x509.Certificate{cert})
+	assert.NoError(t, err)
+	assert.Equal(t, []keyIDs{{
+		canonicalKeyIDs:   privKey.ID(),
+		certificateKeyIDs: trustmanager.CertToKey(cert).ID(),
+	}}, ids)
+	assert.NotEqual(t, privKey.ID(), trustmanager.CertToKey(cert).ID())
+	assert.Equal(t, []string{"canonical", "certificate"}, keyIDDerivationNames())
+}
+
+// Keys stored under their ID from one derivation are renamed to their ID from
+// another, with the same key material, and keys stored under other IDs or
+// without a certificate are left alone.  Migrating back restores the original
+// names.
+func TestMigrateKeyIDs(t *testing.T) {
+	store := trustmanager.NewKeyMemoryStore(ret)
+
+	rootKey, rootCert := newCertifiedKey(t)
+	assert.NoError(t, store.AddKey(rootKey.ID(), data.CanonicalRootRole, rootKey))
+	targetsKey, err := trustmanager.GenerateED25519Key(rand.Reader)
+	assert.NoError(t, err)
+	assert.NoError(t, store.AddKey("gun/"+targetsKey.ID(), data.CanonicalTargetsRole, targetsKey))
+	otherKey, err := trustmanager.GenerateED25519Key(rand.Reader)
+	assert.NoError(t, err)
+	assert.NoError(t, store.AddKey("gun/notanid", data.CanonicalSnapshotRole, otherKey))
+	rootBytes, err := store.ExportKey(rootKey.ID())
+	assert.NoError(t, err)
+	ids, err := certKeyIDs([]*x509.Certificate{rootCert})
+	assert.NoError(t, err)
+
+	_, err = planKeyIDMigrations(store, ids, canonicalKeyIDs, "sha512")
+	assert.Error(t, err)
+	migrations, err := planKeyIDMigrations(store, ids, canonicalKeyIDs, canonicalKeyIDs)
+	assert.NoError(t, err)
+	assert.Empty(t, migrations)
+
+	migrations, err = planKeyIDMigrations(store, ids, canonicalKeyIDs, certificateKeyIDs)
+	assert.NoError(t, err)
+	assert.Len(t, migrations, 1)
+	assert.Len(t, store.ListKeys(), 3, "planning doesn't change the store")
+
+	for _, m := range migrations {
+		assert.NoError(t, migrateKeyID(store, m))
+	}
+	certID := trustmanager.CertToKey(rootCert).ID()
+	assert.Equal(t, map[string]string{
+		certID:                   data.CanonicalRootRole,
+		"gun/" + targetsKey.ID(): data.CanonicalTargetsRole,
+		"gun/notanid":            data.CanonicalSnapshotRole,
+	}, store.ListKeys())
+	movedBytes, err := store.ExportKey(certID)
+	assert.NoError(t, err)
+	assert.Equal(t, rootBytes, movedBytes)
+
+	migrations, err = planKeyIDMigrations(store, ids, certificateKeyIDs, canonicalKeyIDs)
+	assert.NoError(t, err)
+	assert.Len(t, migrations, 1)
+	for _, m := range migrations {
+		assert.NoError(t, migrateKeyID(store, m))
+	}
+	assert.Equal(t, map[string]string{
+		rootKey.ID():             data.CanonicalRootRole,
+		"gun/" + targetsKey.ID(): data.CanonicalTargetsRole,
+		"gun/notanid":            data.CanonicalSnapshotRole,
+	}, store.ListKeys())
+}
+
+// A key that is stored under the ID of a certificate it is not the key of is
+// moved back after being renamed
+func TestMigrateKeyIDsWrongKey(t *testing.T) {
+	store := trustmanager.NewKeyMemoryStore(ret)
+
+	rootKey, rootCert := newCertifiedKey(t)
+	otherKey, err := trustmanager.GenerateECDSAKey(rand.Reader)
+	assert.NoError(t, err)
+	assert.NoError(t, store.AddKey(rootKey.ID(), data.CanonicalRootRole, otherKey))
+	ids, err := certKeyIDs([]*x509.Certificate{rootCert})
+	assert.NoError(t, err)
+
+	migrations, err := planKeyIDMigrations(store, ids, canonicalKeyIDs, certificateKeyIDs)
+	assert.NoError(t, err)
+	assert.Len(t, migrations, 1)
+	err = migrateKeyID(store, migrations[0])
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "moved back")
+	assert.Equal(t, map[string]string{rootKey.ID(): data.CanonicalRootRole}, store.ListKeys())
+}
+
+// Migrating key IDs fails before renaming anything if a new ID is taken
+func TestMigrateKeyIDsConflict(t *testing.T) {
+	store := trustmanager.NewKeyMemoryStore(ret)
+
+	privKey, cert := newCertifiedKey(t)
+	certID := trustmanager.CertToKey(cert).ID()
+	assert.NoError(t, store.AddKey(privKey.ID(), data.CanonicalRootRole, privKey))
+	assert.NoError(t, store.AddKey(certID, data.CanonicalRootRole, privKey))
+	ids, err := certKeyIDs([]*x509.Certificate{cert})
+	assert.NoError(t, err)
+
+	_, err = planKeyIDMigrations(store, ids, canonicalKeyIDs, certificateKeyIDs)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "already stored")
+}
+
+// Checking the passphrase of a key succeeds only with the passphrase the key
+// was encrypted with, doesn't reveal the passphrase, and reports keys that
+// aren't encrypted
+func TestKeyFingerprint(t *testing.T) {
+	assert.Equal(t, "AB:01:CD", keyFingerprint("ab01cd"))
+	assert.Equal(t, "", keyFingerprint(""))
+
+	key, err := trustmanager.GenerateECDSAKey(rand.Reader)
+	assert.NoError(t, err)
+	fingerprint := keyFingerprint(key.ID())
+	assert.Len(t, strings.Split(fingerprint, ":"), 32)
+	assert.Equal(t, strings.ToUpper(key.ID()), strings.Replace(fingerprint, ":", "", -1))
+}
+
+func TestParseExpiryWindow(t *testing.T) {
+	for window, expected := range map[string]time.Duration{
+		"30d":  30 * 24 * time.Hour,
+		"1d":   24 * time.Hour,
+		"720h": 720 * time.Hour,
+		"90m":  90 * time.Minute,
+	} {
+		d, err := parseExpiryWindow(window)
+		assert.NoError(t, err, window)
+		assert.Equal(t, expected, d, window)
+	}
+	for _, window := range []string{"", "d", "0d", "-1d", "1.5d", "soon", "0s", "-1h"} {
+		_, err := parseExpiryWindow(window)
+		assert.Error(t, err, window)
+	}
+}
+
+func TestExpiringKeys(t *testing.T) {
+	now := time.Now()
+	soon, later := now.Add(time.Hour), now.AddDate(1, 0, 0)
+	details := []client.DelegationDetail{
+		{Name: "targets/b", Keys: []client.DelegationKey{{ID: "k1", Expiry: &soon}, {ID: "k3"}}},
+		{Name: "targets/a", Keys: []client.DelegationKey{{ID: "k1", Expiry: &soon}, {ID: "k2", Expiry: &later}}},
+	}
+
+	keys := expiringKeys(details, now.Add(24*time.Hour))
+	assert.Len(t, keys, 1)
+	assert.Equal(t, "k1", keys[0].KeyID)
+	assert.Equal(t, []string{"targets/a", "targets/b"}, keys[0].Roles)
+
+	keys = expiringKeys(details, now.AddDate(2, 0, 0))
+	assert.Len(t, keys, 2)
+	assert.Equal(t, "k1", keys[0].KeyID)
+	assert.Equal(t, "k2", keys[1].KeyID)
+	assert.Equal(t, []string{"targets/a"}, keys[1].Roles)
+
+	assert.Empty(t, expiringKeys(details, now))
+}
+
+func TestCheckKeyPassphrase(t *testing.T) {
+	tempBaseDir, err := ioutil.TempDir("", "notary-test-")
+	assert.NoError(t, err)
+	defer os.RemoveAll(tempBaseDir)
+
+	store, err := trustmanager.NewKeyFileStore(tempBaseDir, passphrase.ConstantRetriever("rightpass"))
+	assert.NoError(t, err)
+	key, err := trustmanager.GenerateECDSAKey(rand.Reader)
+	assert.NoError(t, err)
+	assert.NoError(t, store.AddKey(filepath.Join("gun", key.ID()), "targets", key))
+
+	// a new store, so the decrypted key isn't cached
+	store, err = trustmanager.NewKeyFileStore(tempBaseDir, passphrase.ConstantRetriever("rightpass"))
+	assert.NoError(t, err)
+	encrypted, err := checkKeyPassphrase([]trustmanager.KeyStore{store}, key.ID())
+	assert.NoError(t, err)
+	assert.True(t, encrypted)
+
+	store, err = trustmanager.NewKeyFileStore(tempBaseDir, passphrase.ConstantRetriever("wrongpass"))
+	assert.NoError(t, err)
+	_, err = checkKeyPassphrase([]trustmanager.KeyStore{store}, key.ID())
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "incorrect passphrase")
+	assert.NotContains(t, err.Error(), "wrongpass")
+
+	otherKey, err := trustmanager.GenerateECDSAKey(rand.Reader)
+	assert.NoError(t, err)
+	_, err = checkKeyPassphrase([]trustmanager.KeyStore{store}, otherKey.ID())
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "could not find")
+
+	// a key stored without a passphrase is reported as unencrypted, whatever
+	// the passphrase given
+	unencryptedStore, err := trustmanager.NewKeyFileStore(tempBaseDir, passphrase.ConstantRetriever(""))
+	assert.NoError(t, err)
+	assert.NoError(t, unencryptedStore.AddKey(filepath.Join("gun", otherKey.ID()), "targets", otherKey))
+	encrypted, err = checkKeyPassphrase([]trustmanager.KeyStore{store}, otherKey.ID())
+	assert.NoError(t, err)
+	assert.False(t, encrypted)
+}
+
+// writeTestCA writes an encrypted CA key and a CA certificate for it to the
+// given directory, and returns their paths and the CA certificate
+func writeTestCA(t *testing.T, dir, passphrase string) (string, string, *x509.Certificate) {
+	caKey, err := trustmanager.GenerateECDSAKey(rand.Reader)
+	assert.NoError(t, err)
+	startTime := time.Now()
+	template, err := trustmanager.NewCertificate("org CA", startTime, startTime.AddDate(10, 0, 0))
+	assert.NoError(t, err)
+	template.IsCA = true
+	template.KeyUsage |= x509.KeyUsageCertSign
+	derBytes, err := x509.CreateCertificate(
+		rand.Reader, template, template, caKey.CryptoSigner().Public(), caKey.CryptoSigner())
+	assert.NoError(t, err)
+	caCert, err := x509.ParseCertificate(derBytes)
+	assert.NoError(t, err)
+
+	keyPEM, err := trustmanager.EncryptPrivateKey(caKey, "ca", passphrase)
+	assert.NoError(t, err)
+	keyPath := filepath.Join(dir, "ca.key")
+	certPath := filepath.Join(dir, "ca.crt")
+	assert.NoError(t, ioutil.WriteFile(keyPath, keyPEM, 0600))
+	assert.NoError(t, ioutil.WriteFile(certPath, trustmanager.CertToPEM(caCert), 0644))
+	return keyPath, certPath, caCert
+}
+
+// A generated delegation key is stored for the GUN and role, and its
+// certificate is self-signed unless a CA is given, in which case the CA key is
+// decrypted with the passphrase and the certificate chains to the CA.  A CA
+// key that cannot be decrypted leaves no new key behind.
+func TestKeysGenerateDelegationKey(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "notary-test-")
+	assert.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+	caKeyPath, caCertPath, caCert := writeTestCA(t, tempDir, "capass")
+
+	newCommander := func(pass string) *keyCommander {
+		return &keyCommander{
+			configGetter: WithTrustDir(tempDir),
+			getRetriever: func() passphrase.Retriever { return passphrase.ConstantRetriever(pass) },
+		}
+	}
+	numKeys := func() int {
+		k := newCommander("pass")
+		config, err := k.configGetter()
+		assert.NoError(t, err)
+		ks, err := k.getKeyStores(config, false)
+		assert.NoError(t, err)
+		return len(ks[0].ListKeys())
+	}
+	generate := func(k *keyCommander, role, certPath string) error {
+		return k.keysGenerateDelegationKey(&cobra.Command{}, []string{"gun", role, certPath})
+	}
+
+	// invalid arguments
+	k := newCommander("pass")
+	assert.Error(t, generate(k, "targets", filepath.Join(tempDir, "bad.crt")))
+	k.delegationCAKeyPath = caKeyPath
+	assert.Error(t, generate(k, "targets/a", filepath.Join(tempDir, "bad.crt")))
+
+	// self-signed
+	selfSignedPath := filepath.Join(tempDir, "self.crt")
+	assert.NoError(t, generate(newCommander("pass"), "targets/a", selfSignedPath))
+	cert, err := trustmanager.LoadCertFromFile(selfSignedPath)
+	assert.NoError(t, err)
+	assert.Equal(t, "gun", cert.Subject.CommonName)
+	assert.Equal(t, "gun", cert.Issuer.CommonName)
+	assert.Equal(t, 1, numKeys())
+
+	// the wrong CA passphrase does not leave a new key behind
+	k = newCommander("wrong")
+	k.delegationCAKeyPath, k.delegationCACertPath = caKeyPath, caCertPath
+	assert.Error(t, generate(k, "targets/b", filepath.Join(tempDir, "wrong.crt")))
+	assert.Equal(t, 1, numKeys())
+
+	k = newCommander("capass")
+	k.delegationCAKeyPath, k.delegationCACertPath = caKeyPath, caCertPath
+	caSignedPath := filepath.Join(tempDir, "casigned.crt")
+	assert.NoError(t, generate(k, "targets/b", caSignedPath))
+	assert.Equal(t, 2, numKeys())
+	cert, err = trustmanager.LoadCertFromFile(caSignedPath)
+	assert.NoError(t, err)
+	assert.Equal(t, caCert.Subject.CommonName, cert.Issuer.CommonName)
+	roots := x509.NewCertPool()
+	roots.AddCert(caCert)
+	_, err = cert.Verify(x509.VerifyOptions{
+		Roots:     roots,
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+	})
+	assert.NoError(t, err)
+
+	// the certificate can be used to add the delegation
+	_, err = trustmanager.ParsePEMPublicKey(trustmanager.CertToPEM(cert))
+	assert.NoError(t, err)
+}
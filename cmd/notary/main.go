@@ -2,13 +2,19 @@ package main
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/Sirupsen/logrus"
 	"github.com/docker/notary/passphrase"
-	"github.com/docker/notary/version"
+	"github.com/docker/notary/trustmanager"
+	tufclient "github.com/docker/notary/tuf/client"
+	"github.com/docker/notary/utils"
 	homedir "github.com/mitchellh/go-homedir"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
@@ -17,6 +23,7 @@ import (
 const (
 	configDir        = ".notary/"
 	defaultServerURL = "https://notary-server:4443"
+	envPrefix        = "NOTARY"
 )
 
 type usageTemplate struct {
@@ -36,7 +43,13 @@ func (u usageTemplate) ToCommand(run cobraRunE) *cobra.Command {
 	if run != nil {
 		// newer versions of cobra support a run function that returns an error,
 		// but in the meantime, this should help ease the transition
-		c.RunE = run
+		gunArgs := gunArgIndices(u.Use)
+		c.RunE = func(cmd *cobra.Command, args []string) error {
+			if err := normalizeGUNArgs(cmd, args, gunArgs); err != nil {
+				return err
+			}
+			return run(cmd, args)
+		}
 	}
 	return &c
 }
@@ -52,6 +65,46 @@ func pathRelativeToCwd(path string) string {
 	return filepath.Clean(filepath.Join(cwd, path))
 }
 
+// outputFile holds the command line options of commands that can write their
+// output to a file instead of to the console
+type outputFile struct {
+	path  string
+	force bool
+}
+
+func (o *outputFile) addFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&o.path, "output-file", "", "Write the output to this file instead of to the console")
+	cmd.Flags().BoolVar(&o.force, "force", false, "Overwrite the output file if it already exists")
+}
+
+// open returns the writer that the output of the command should go to, and a
+// function to call once all the output has been written.  The output file is
+// created readable only by the current user, and an existing file is only
+// overwritten if --force was given.
+func (o *outputFile) open(cmd *cobra.Command) (io.Writer, func() error, error) {
+	if o.path == "" {
+		return cmd.Out(), func() error { return nil }, nil
+	}
+
+	flags := os.O_WRONLY | os.O_CREATE | os.O_EXCL
+	if o.force {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	}
+	f, err := os.OpenFile(o.path, flags, 0600)
+	if err != nil {
+		if os.IsExist(err) {
+			return nil, nil, fmt.Errorf("output file %s already exists, use --force to overwrite it", o.path)
+		}
+		return nil, nil, fmt.Errorf("unable to open output file %s: %v", o.path, err)
+	}
+	// an overwritten file keeps its old permissions, so restrict them
+	if err := f.Chmod(0600); err != nil {
+		f.Close()
+		return nil, nil, fmt.Errorf("unable to restrict permissions of output file %s: %v", o.path, err)
+	}
+	return f, f.Close, nil
+}
+
 type notaryCommander struct {
 	// this needs to be set
 	getRetriever func() passphrase.Retriever
@@ -60,15 +113,55 @@ type notaryCommander struct {
 	debug             bool
 	verbose           bool
 	trustDir          string
+	cacheDir          string
 	configFile        string
 	remoteTrustServer string
+	maxTimestampAge   time.Duration
+	strictExpiry      time.Duration
+	revocationMode    string
+	maxMetadataSizes  []string
+	passphraseSources []string
+	cachePassphrases  bool
+	failOnWarning     bool
+	noPrompt          bool
+
+	tlsCAFile     string
+	tlsCertFile   string
+	tlsKeyFile    string
+	tlsSkipVerify bool
+	minTLSVersion string
+
+	connectTimeout time.Duration
+	timeout        time.Duration
+}
 
-	tlsCAFile   string
-	tlsCertFile string
-	tlsKeyFile  string
+// WithTrustDir returns a config getter for a commander that only sets the
+// trust directory, so that commanders in the same process, such as tests that
+// run in parallel, don't share state
+func WithTrustDir(trustDir string) func() (*viper.Viper, error) {
+	return func() (*viper.Viper, error) {
+		config := viper.New()
+		config.Set("trust_dir", trustDir)
+		return config, nil
+	}
 }
 
+// parseConfig loads the configuration, and fails on the first problem found
+// with it
 func (n *notaryCommander) parseConfig() (*viper.Viper, error) {
+	config, err := n.loadConfig()
+	if err != nil {
+		return nil, err
+	}
+	if problems := n.configProblems(config); len(problems) > 0 {
+		return nil, problems[0]
+	}
+	return config, nil
+}
+
+// loadConfig reads the configuration file and the environment, and applies
+// the command line flags on top of them, without checking the values
+func (n *notaryCommander) loadConfig() (*viper.Viper, error) {
 	n.setVerbosityLevel()
 
 	// Get home directory for current user
@@ -81,6 +174,10 @@ func (n *notaryCommander) parseConfig() (*viper.Viper, error) {
 	}
 
 	config := viper.New()
+	// Every configuration key can also be set with a NOTARY_ prefixed environment
+	// variable, e.g. NOTARY_REMOTE_SERVER_URL for remote_server.url.  These take
+	// precedence over the config file, and command-line flags over both.
+	utils.SetupViper(config, envPrefix)
 
 	// By default our trust directory (where keys are stored) is in ~/.notary/
 	defaultTrustDir := filepath.Join(homeDir, filepath.Dir(configDir))
@@ -113,6 +210,9 @@ func (n *notaryCommander) parseConfig() (*viper.Viper, error) {
 	if n.trustDir != "" {
 		config.Set("trust_dir", pathRelativeToCwd(n.trustDir))
 	}
+	if n.cacheDir != "" {
+		config.Set("cache_dir", pathRelativeToCwd(n.cacheDir))
+	}
 	if n.tlsCAFile != "" {
 		config.Set("remote_server.root_ca", pathRelativeToCwd(n.tlsCAFile))
 	}
@@ -123,7 +223,50 @@ func (n *notaryCommander) parseConfig() (*viper.Viper, error) {
 		config.Set("remote_server.tls_client_key", pathRelativeToCwd(n.tlsKeyFile))
 	}
 	if n.remoteTrustServer != "" {
+		// the server given on the command line is used for every GUN
 		config.Set("remote_server.url", n.remoteTrustServer)
+		config.Set("remote_server.gun_urls", map[string]string{})
+	}
+	if n.tlsSkipVerify {
+		config.Set("remote_server.skipTLSVerify", true)
+	}
+	if n.minTLSVersion != "" {
+		config.Set("remote_server.min_tls_version", n.minTLSVersion)
+	}
+	if n.connectTimeout != 0 {
+		config.Set("remote_server.connect_timeout", n.connectTimeout.String())
+	}
+	if n.timeout != 0 {
+		config.Set("remote_server.timeout", n.timeout.String())
+	}
+	if n.maxTimestampAge != 0 {
+		config.Set("max_timestamp_age", n.maxTimestampAge.String())
+	}
+	if n.strictExpiry != 0 {
+		config.Set("strict_expiry", n.strictExpiry.String())
+	}
+	if n.revocationMode != "" {
+		config.Set("revocation_mode", n.revocationMode)
+	}
+	if n.noPrompt {
+		config.Set("no_prompt", true)
+	}
+	if len(n.maxMetadataSizes) > 0 {
+		// the sizes given on the command line are added to the configured ones
+		sizes := make(map[string]interface{})
+		if configured, ok := config.Get("max_metadata_size").(map[string]interface{}); ok {
+			for role, size := range configured {
+				sizes[role] = size
+			}
+		}
+		for _, spec := range n.maxMetadataSizes {
+			parts := strings.SplitN(spec, "=", 2)
+			if len(parts) != 2 {
+				return nil, fmt.Errorf("invalid --max-metadata-size %q: must be ROLE=SIZE, such as targets=200MiB", spec)
+			}
+			sizes[parts[0]] = parts[1]
+		}
+		config.Set("max_metadata_size", sizes)
 	}
 
 	// Expands all the possible ~/ that have been given, either through -d or config
@@ -134,9 +277,84 @@ func (n *notaryCommander) parseConfig() (*viper.Viper, error) {
 	}
 	logrus.Debugf("Using the following trust directory: %s", config.GetString("trust_dir"))
 
+	// The downloaded metadata is cached in the trust directory, unless a
+	// separate cache directory is configured
+	cacheDir := config.GetString("cache_dir")
+	if cacheDir == "" {
+		cacheDir = config.GetString("trust_dir")
+	} else if expandedCacheDir, err := homedir.Expand(cacheDir); err == nil {
+		cacheDir = expandedCacheDir
+	}
+	config.Set("cache_dir", cacheDir)
+	logrus.Debugf("Using the following cache directory: %s", cacheDir)
+
 	return config, nil
 }
 
+// configProblems checks the values in the configuration that can be checked
+// without touching the file system, and returns an error for each invalid one
+func (n *notaryCommander) configProblems(config *viper.Viper) []error {
+	var problems []error
+	if _, err := parseGUNServerURLs(config); err != nil {
+		problems = append(problems, err)
+	}
+	if _, err := parseTLSVersion(config.GetString("remote_server.min_tls_version")); err != nil {
+		problems = append(problems, err)
+	}
+	if _, err := parseTimeout(config, "remote_server.connect_timeout", defaultConnectTimeout); err != nil {
+		problems = append(problems, err)
+	}
+	if _, err := parseTimeout(config, "remote_server.timeout", 0); err != nil {
+		problems = append(problems, err)
+	}
+	if maxTimestampAge := config.GetString("max_timestamp_age"); maxTimestampAge != "" {
+		if age, err := time.ParseDuration(maxTimestampAge); err != nil || age < 0 {
+			problems = append(problems, fmt.Errorf("invalid max_timestamp_age %q: must be a positive duration such as 10m", maxTimestampAge))
+		}
+	}
+	if strictExpiry := config.GetString("strict_expiry"); strictExpiry != "" {
+		if window, err := time.ParseDuration(strictExpiry); err != nil || window < 0 {
+			problems = append(problems, fmt.Errorf("invalid strict_expiry %q: must be a positive duration such as 720h", strictExpiry))
+		}
+	}
+	problems = append(problems, revocationConfigProblems(config)...)
+	problems = append(problems, messageConfigProblems(config)...)
+	if _, err := parseServerKeyPins(config); err != nil {
+		problems = append(problems, err)
+	}
+	if _, err := parseMaxMetadataSizes(config); err != nil {
+		problems = append(problems, err)
+	}
+	if limit := config.GetString("metadata_history_limit"); limit != "" {
+		if n, err := strconv.Atoi(limit); err != nil || n < 0 {
+			problems = append(problems, fmt.Errorf("invalid metadata_history_limit %q: must be a number of versions, 0 or more", limit))
+		}
+	}
+	// the passphrase sources are only used once a passphrase is needed, so
+	// check them now to report a mistake before anything is done
+	if _, err := newSourceRetriever(n.passphraseSources, false); err != nil {
+		problems = append(problems, err)
+	}
+	return problems
+}
+
+// updateKeyIndex rewrites the index of the private keys in the trust
+// directory, if key_index is enabled
+func (n *notaryCommander) updateKeyIndex(cmd *cobra.Command, args []string) error {
+	config, err := n.loadConfig()
+	if err != nil {
+		// the command itself fails on this if it reads the configuration
+		return nil
+	}
+	if !config.GetBool("key_index") {
+		return nil
+	}
+	if err := trustmanager.WriteKeyIndex(config.GetString("trust_dir")); err != nil {
+		return fmt.Errorf("error updating the key index: %v", err)
+	}
+	return nil
+}
+
 func (n *notaryCommander) GetCommand() *cobra.Command {
 	notaryCmd := cobra.Command{
 		Use:           "notary",
@@ -145,19 +363,24 @@ func (n *notaryCommander) GetCommand() *cobra.Command {
 		SilenceUsage:  true, // we don't want to print out usage for EVERY error
 		SilenceErrors: true, // we do our own error reporting with fatalf
 		Run:           func(cmd *cobra.Command, args []string) { cmd.Usage() },
+		// each command only fails on its own warnings
+		PersistentPreRun: resetWarnings,
+		// keep the key index up to date with the keys each command adds or
+		// removes, and then fail on any warning with --fail-on-warning
+		PersistentPostRunE: func(cmd *cobra.Command, args []string) error {
+			if err := n.updateKeyIndex(cmd, args); err != nil {
+				return err
+			}
+			return n.checkWarnings()
+		},
 	}
 	notaryCmd.SetOutput(os.Stdout)
-	notaryCmd.AddCommand(&cobra.Command{
-		Use:   "version",
-		Short: "Print the version number of notary",
-		Long:  `print the version number of notary`,
-		Run: func(cmd *cobra.Command, args []string) {
-			fmt.Printf("notary\n Version:    %s\n Git commit: %s\n", version.NotaryVersion, version.GitCommit)
-		},
-	})
+	notaryCmd.AddCommand((&versionCommander{}).GetCommand())
 
 	notaryCmd.PersistentFlags().StringVarP(
 		&n.trustDir, "trustDir", "d", "", "Directory where the trust data is persisted to")
+	notaryCmd.PersistentFlags().StringVar(
+		&n.cacheDir, "cache-dir", "", "Directory where the downloaded metadata is cached (defaults to the trust directory)")
 	notaryCmd.PersistentFlags().StringVarP(
 		&n.configFile, "configFile", "c", "", "Path to the configuration file to use")
 	notaryCmd.PersistentFlags().BoolVarP(&n.verbose, "verbose", "v", false, "Verbose output")
@@ -166,6 +389,33 @@ func (n *notaryCommander) GetCommand() *cobra.Command {
 	notaryCmd.PersistentFlags().StringVar(&n.tlsCAFile, "tlscacert", "", "Trust certs signed only by this CA")
 	notaryCmd.PersistentFlags().StringVar(&n.tlsCertFile, "tlscert", "", "Path to TLS certificate file")
 	notaryCmd.PersistentFlags().StringVar(&n.tlsKeyFile, "tlskey", "", "Path to TLS key file")
+	notaryCmd.PersistentFlags().BoolVar(&n.tlsSkipVerify, "tls-skip-verify", false,
+		"Do not verify the certificate of the remote trust server (insecure, for development only; ignored if a CA is set with --tlscacert)")
+	notaryCmd.PersistentFlags().StringVar(&n.minTLSVersion, "min-tls-version", "",
+		"Minimum TLS version to use with the remote trust server: 1.0, 1.1 or 1.2 (the default)")
+	notaryCmd.PersistentFlags().DurationVar(&n.connectTimeout, "connect-timeout", 0,
+		"How long connecting to the remote trust server can take (default 30s)")
+	notaryCmd.PersistentFlags().DurationVar(&n.timeout, "timeout", 0,
+		"How long each request to the remote trust server can take, including the download (default no limit)")
+	notaryCmd.PersistentFlags().DurationVar(&n.maxTimestampAge, "max-timestamp-age", 0,
+		"Refuse to use a cached timestamp fetched longer ago than this (e.g. 10m), downloading it again in full instead")
+	notaryCmd.PersistentFlags().DurationVar(&n.strictExpiry, "strict-expiry", 0,
+		"Make list, lookup, verify and delegation list and info fail if any metadata, certificate or delegation expires within this long (e.g. 720h).  "+
+			"The timestamp, and the snapshot if the server manages it, are left out, since the server only renews them once they expire")
+	notaryCmd.PersistentFlags().StringVar(&n.revocationMode, "revocation-mode", "",
+		"What to do if the configured revocation_list cannot be loaded: closed fails the command (the default), open only warns")
+	notaryCmd.PersistentFlags().StringSliceVar(&n.maxMetadataSizes, "max-metadata-size", nil,
+		"Largest metadata to download for a role, as ROLE=SIZE, e.g. targets=200MiB. Delegations are limited like targets "+
+			"(defaults: root=5MiB, timestamp=1MiB, snapshot=25MiB, targets=100MiB)")
+	notaryCmd.PersistentFlags().StringSliceVar(&n.passphraseSources, "passphrase-source", nil,
+		"Where to get the passphrases of keys from, as NAME or NAME:ARGUMENT, e.g. env, file:DIRECTORY or prompt. "+
+			"Several sources are tried in turn (default env,prompt)")
+	notaryCmd.PersistentFlags().BoolVar(&n.cachePassphrases, "cache-passphrases", false,
+		"Only ask for the passphrase of each key once within this command (the passphrases are kept in memory only)")
+	notaryCmd.PersistentFlags().BoolVar(&n.failOnWarning, "fail-on-warning", false,
+		"Exit with an error if the command printed any warning, once it has otherwise finished")
+	notaryCmd.PersistentFlags().BoolVar(&n.noPrompt, "no-prompt", false,
+		"Fail instead of prompting for a passphrase or confirmation, e.g. when running unattended")
 
 	cmdKeyGenerator := &keyCommander{
 		configGetter: n.parseConfig,
@@ -182,6 +432,26 @@ func (n *notaryCommander) GetCommand() *cobra.Command {
 		retriever:    n.getRetriever(),
 	}
 
+	cmdChangelistGenerator := &changelistCommander{
+		configGetter: n.parseConfig,
+		retriever:    n.getRetriever(),
+	}
+
+	cmdTrustGenerator := &trustCommander{
+		configGetter: n.parseConfig,
+		retriever:    n.getRetriever(),
+	}
+
+	cmdConfigGenerator := &configCommander{
+		configLoader:   n.loadConfig,
+		configProblems: n.configProblems,
+	}
+
+	cmdDoctorGenerator := &doctorCommander{
+		configGetter: n.parseConfig,
+		retriever:    n.getRetriever(),
+	}
+
 	cmdTufGenerator := &tufCommander{
 		configGetter: n.parseConfig,
 		retriever:    n.getRetriever(),
@@ -190,6 +460,10 @@ func (n *notaryCommander) GetCommand() *cobra.Command {
 	notaryCmd.AddCommand(cmdKeyGenerator.GetCommand())
 	notaryCmd.AddCommand(cmdDelegationGenerator.GetCommand())
 	notaryCmd.AddCommand(cmdCertGenerator.GetCommand())
+	notaryCmd.AddCommand(cmdChangelistGenerator.GetCommand())
+	notaryCmd.AddCommand(cmdTrustGenerator.GetCommand())
+	notaryCmd.AddCommand(cmdConfigGenerator.GetCommand())
+	notaryCmd.AddCommand(cmdDoctorGenerator.GetCommand())
 
 	cmdTufGenerator.AddToCommand(&notaryCmd)
 
@@ -197,10 +471,15 @@ func (n *notaryCommander) GetCommand() *cobra.Command {
 }
 
 func main() {
-	notaryCommander := &notaryCommander{getRetriever: getPassphraseRetriever}
+	notaryCommander := &notaryCommander{}
+	notaryCommander.getRetriever = notaryCommander.sourceRetriever
 	notaryCmd := notaryCommander.GetCommand()
 	if err := notaryCmd.Execute(); err != nil {
 		notaryCmd.Println("")
+		if verr, ok := err.(tufclient.ErrVerification); ok {
+			prettyPrintVerificationFailure(verr, notaryCmd.Out())
+			notaryCmd.Println("")
+		}
 		fatalf(err.Error())
 	}
 }
@@ -210,6 +489,16 @@ func fatalf(format string, args ...interface{}) {
 	os.Exit(1)
 }
 
+// checkPromptAllowed fails with passphrase.ErrPromptDisabled if prompting has
+// been disabled with --no-prompt, so that a command which would have to ask
+// for confirmation fails instead of waiting for an answer
+func checkPromptAllowed(config *viper.Viper) error {
+	if config.GetBool("no_prompt") {
+		return passphrase.ErrPromptDisabled
+	}
+	return nil
+}
+
 func askConfirm() bool {
 	var res string
 	_, err := fmt.Scanln(&res)
@@ -222,26 +511,66 @@ func askConfirm() bool {
 	return false
 }
 
-func getPassphraseRetriever() passphrase.Retriever {
-	baseRetriever := passphrase.PromptRetriever()
-	env := map[string]string{
-		"root":     os.Getenv("NOTARY_ROOT_PASSPHRASE"),
-		"targets":  os.Getenv("NOTARY_TARGETS_PASSPHRASE"),
-		"snapshot": os.Getenv("NOTARY_SNAPSHOT_PASSPHRASE"),
+// defaultPassphraseSources are used if no --passphrase-source is given
+var defaultPassphraseSources = []string{"env", "prompt"}
+
+// newSourceRetriever returns a Retriever trying each of the given passphrase
+// sources in turn.  With noPrompt, the prompt source fails instead of asking.
+func newSourceRetriever(specs []string, noPrompt bool) (passphrase.Retriever, error) {
+	if len(specs) == 0 {
+		specs = defaultPassphraseSources
 	}
+	retrievers := make([]passphrase.Retriever, 0, len(specs))
+	for _, spec := range specs {
+		retriever, err := passphrase.NewSourceRetriever(spec)
+		if err != nil {
+			return nil, err
+		}
+		if noPrompt && spec == "prompt" {
+			retriever = passphrase.NoPromptRetriever()
+		}
+		retrievers = append(retrievers, retriever)
+	}
+	return passphrase.ChainRetrievers(retrievers...), nil
+}
 
-	return func(keyName string, alias string, createNew bool, numAttempts int) (string, bool, error) {
-		if v := env[alias]; v != "" {
-			return v, numAttempts > 1, nil
+// sourceRetriever returns a Retriever getting the passphrases from the
+// sources given with --passphrase-source, remembering them for the rest of
+// the command with --cache-passphrases.  The commands are given their
+// retriever before the flags are parsed, so the sources are only set up once
+// the first passphrase is needed.  With no_prompt (--no-prompt), the prompt
+// source fails instead of asking.
+func (n *notaryCommander) sourceRetriever() passphrase.Retriever {
+	var (
+		once      sync.Once
+		retriever passphrase.Retriever
+		err       error
+	)
+	return func(keyName, alias string, createNew bool, numAttempts int) (string, bool, error) {
+		once.Do(func() {
+			noPrompt := n.noPrompt
+			if config, err := n.loadConfig(); err == nil {
+				noPrompt = config.GetBool("no_prompt")
+			}
+			retriever, err = newSourceRetriever(n.passphraseSources, noPrompt)
+			if err == nil && n.cachePassphrases {
+				retriever = passphrase.CachingRetriever(retriever)
+			}
+		})
+		if err != nil {
+			return "", true, err
 		}
-		return baseRetriever(keyName, alias, createNew, numAttempts)
+		return retriever(keyName, alias, createNew, numAttempts)
 	}
 }
 
-// Set the logging level to fatal on default, or the most specific level the user specified (debug or error)
+// Set the logging level to fatal on default, or the most specific level the user specified (debug or error).
+// With --fail-on-warning, warnings are logged too, so that the warnings the command fails on are shown
 func (n *notaryCommander) setVerbosityLevel() {
 	if n.debug {
 		logrus.SetLevel(logrus.DebugLevel)
+	} else if n.failOnWarning {
+		logrus.SetLevel(logrus.WarnLevel)
 	} else if n.verbose {
 		logrus.SetLevel(logrus.ErrorLevel)
 	} else {
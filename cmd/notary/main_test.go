@@ -10,6 +10,7 @@ import (
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/docker/go-connections/tlsconfig"
 	"github.com/docker/notary/passphrase"
@@ -50,7 +51,7 @@ func TestRemoteServerDefault(t *testing.T) {
 
 	config, err := commander.parseConfig()
 	assert.NoError(t, err)
-	assert.Equal(t, "https://notary-server:4443", getRemoteTrustServer(config))
+	assert.Equal(t, "https://notary-server:4443", getRemoteTrustServer(config, "gun"))
 }
 
 // providing a config file uses the config file's server url instead
@@ -72,7 +73,7 @@ func TestRemoteServerUsesConfigFile(t *testing.T) {
 
 	config, err := commander.parseConfig()
 	assert.NoError(t, err)
-	assert.Equal(t, "https://myserver", getRemoteTrustServer(config))
+	assert.Equal(t, "https://myserver", getRemoteTrustServer(config, "gun"))
 }
 
 // a command line flag overrides the config file's server url
@@ -94,7 +95,282 @@ func TestRemoteServerCommandLineFlagOverridesConfig(t *testing.T) {
 
 	config, err := commander.parseConfig()
 	assert.NoError(t, err)
-	assert.Equal(t, "http://overridden", getRemoteTrustServer(config))
+	assert.Equal(t, "http://overridden", getRemoteTrustServer(config, "gun"))
+}
+
+// The metadata cache directory defaults to the trust directory, and can be set
+// separately in the config file or on the command line
+func TestCacheDir(t *testing.T) {
+	tempDir := tempDirWithConfig(t, `{"trust_dir": "/trust"}`)
+	defer os.RemoveAll(tempDir)
+	configFile := filepath.Join(tempDir, "config.json")
+	cacheConfigFile := filepath.Join(tempDir, "cache.json")
+	assert.NoError(t, ioutil.WriteFile(cacheConfigFile, []byte(`{"trust_dir": "/trust", "cache_dir": "/cache"}`), 0644))
+
+	testCases := []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{"-c", configFile, "list"}, "/trust"},
+		{[]string{"-c", cacheConfigFile, "list"}, "/cache"},
+		{[]string{"-c", cacheConfigFile, "--cache-dir", "/overridden", "list"}, "/overridden"},
+	}
+	for _, tc := range testCases {
+		commander := &notaryCommander{
+			getRetriever: func() passphrase.Retriever { return passphrase.ConstantRetriever("pass") },
+		}
+
+		cmd := commander.GetCommand()
+		cmd.SetArgs(tc.args)
+		cmd.SetOutput(new(bytes.Buffer)) // eat the output
+		cmd.Execute()
+
+		config, err := commander.parseConfig()
+		assert.NoError(t, err)
+		assert.Equal(t, "/trust", config.GetString("trust_dir"))
+		assert.Equal(t, tc.expected, config.GetString("cache_dir"), "wrong cache directory for %v", tc.args)
+	}
+}
+
+// The maximum timestamp age can be set in the config file or with
+// --max-timestamp-age, which takes precedence, and has to be a duration
+func TestMaxTimestampAge(t *testing.T) {
+	tempDir := tempDirWithConfig(t, `{"max_timestamp_age": "5m"}`)
+	defer os.RemoveAll(tempDir)
+	configFile := filepath.Join(tempDir, "config.json")
+	invalidConfigFile := filepath.Join(tempDir, "invalid.json")
+	assert.NoError(t, ioutil.WriteFile(invalidConfigFile, []byte(`{"max_timestamp_age": "5"}`), 0644))
+
+	testCases := []struct {
+		args     []string
+		expected time.Duration
+	}{
+		{[]string{"-c", configFile, "list"}, 5 * time.Minute},
+		{[]string{"-c", configFile, "--max-timestamp-age", "1h", "list"}, time.Hour},
+		{[]string{"-c", invalidConfigFile, "--max-timestamp-age", "30s", "list"}, 30 * time.Second},
+	}
+	for _, tc := range testCases {
+		commander := &notaryCommander{
+			getRetriever: func() passphrase.Retriever { return passphrase.ConstantRetriever("pass") },
+		}
+
+		cmd := commander.GetCommand()
+		cmd.SetArgs(tc.args)
+		cmd.SetOutput(new(bytes.Buffer)) // eat the output
+		cmd.Execute()
+
+		config, err := commander.parseConfig()
+		assert.NoError(t, err)
+		assert.Equal(t, tc.expected, config.GetDuration("max_timestamp_age"), "wrong maximum age for %v", tc.args)
+	}
+
+	commander := &notaryCommander{configFile: invalidConfigFile}
+	_, err := commander.parseConfig()
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "max_timestamp_age")
+}
+
+// The strict expiry window can be set in the config file or with
+// --strict-expiry, which takes precedence, and has to be a duration
+func TestStrictExpiryConfig(t *testing.T) {
+	tempDir := tempDirWithConfig(t, `{"strict_expiry": "720h"}`)
+	defer os.RemoveAll(tempDir)
+	configFile := filepath.Join(tempDir, "config.json")
+	invalidConfigFile := filepath.Join(tempDir, "invalid.json")
+	assert.NoError(t, ioutil.WriteFile(invalidConfigFile, []byte(`{"strict_expiry": "30 days"}`), 0644))
+
+	testCases := []struct {
+		args     []string
+		expected time.Duration
+	}{
+		{[]string{"-c", configFile, "list"}, 720 * time.Hour},
+		{[]string{"-c", configFile, "--strict-expiry", "24h", "list"}, 24 * time.Hour},
+		{[]string{"-c", invalidConfigFile, "--strict-expiry", "1h", "list"}, time.Hour},
+	}
+	for _, tc := range testCases {
+		commander := &notaryCommander{
+			getRetriever: func() passphrase.Retriever { return passphrase.ConstantRetriever("pass") },
+		}
+
+		cmd := commander.GetCommand()
+		cmd.SetArgs(tc.args)
+		cmd.SetOutput(new(bytes.Buffer)) // eat the output
+		cmd.Execute()
+
+		config, err := commander.parseConfig()
+		assert.NoError(t, err)
+		assert.Equal(t, tc.expected, config.GetDuration("strict_expiry"), "wrong strict expiry for %v", tc.args)
+	}
+
+	commander := &notaryCommander{configFile: invalidConfigFile}
+	_, err := commander.parseConfig()
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "strict_expiry")
+}
+
+// The number of versions of metadata kept in the history can be set in the
+// config file, and has to be a number that is not negative
+func TestMetadataHistoryLimitConfig(t *testing.T) {
+	tempDir := tempDirWithConfig(t, `{"metadata_history_limit": 3}`)
+	defer os.RemoveAll(tempDir)
+	commander := &notaryCommander{configFile: filepath.Join(tempDir, "config.json")}
+	config, err := commander.parseConfig()
+	assert.NoError(t, err)
+	assert.Equal(t, 3, config.GetInt("metadata_history_limit"))
+
+	for _, invalid := range []string{`-1`, `"all"`} {
+		tempDir := tempDirWithConfig(t, `{"metadata_history_limit": `+invalid+`}`)
+		defer os.RemoveAll(tempDir)
+		commander := &notaryCommander{configFile: filepath.Join(tempDir, "config.json")}
+		_, err := commander.parseConfig()
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "metadata_history_limit")
+	}
+}
+
+// The minimum TLS version can be set in the config file or with
+// --min-tls-version, and has to be a known TLS version
+func TestMinTLSVersionConfig(t *testing.T) {
+	tempDir := tempDirWithConfig(t, `{"remote_server": {"min_tls_version": "1.1"}}`)
+	defer os.RemoveAll(tempDir)
+	configFile := filepath.Join(tempDir, "config.json")
+
+	commander := &notaryCommander{configFile: configFile}
+	config, err := commander.parseConfig()
+	assert.NoError(t, err)
+	assert.Equal(t, "1.1", config.GetString("remote_server.min_tls_version"))
+
+	commander = &notaryCommander{configFile: configFile, minTLSVersion: "1.2"}
+	config, err = commander.parseConfig()
+	assert.NoError(t, err)
+	assert.Equal(t, "1.2", config.GetString("remote_server.min_tls_version"))
+
+	commander = &notaryCommander{configFile: configFile, minTLSVersion: "SSLv3"}
+	_, err = commander.parseConfig()
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "minimum TLS version")
+}
+
+// The connect and request timeouts can be set in the config file, overridden
+// on the command line, and are checked when the config is parsed
+func TestTimeoutsConfig(t *testing.T) {
+	tempDir := tempDirWithConfig(t, `{"remote_server": {"connect_timeout": "5s", "timeout": "1m"}}`)
+	defer os.RemoveAll(tempDir)
+	configFile := filepath.Join(tempDir, "config.json")
+
+	commander := &notaryCommander{configFile: configFile}
+	config, err := commander.parseConfig()
+	assert.NoError(t, err)
+	assert.Equal(t, "5s", config.GetString("remote_server.connect_timeout"))
+	assert.Equal(t, "1m", config.GetString("remote_server.timeout"))
+
+	commander = &notaryCommander{configFile: configFile, connectTimeout: 10 * time.Second, timeout: 2 * time.Minute}
+	config, err = commander.parseConfig()
+	assert.NoError(t, err)
+	assert.Equal(t, "10s", config.GetString("remote_server.connect_timeout"))
+	assert.Equal(t, "2m0s", config.GetString("remote_server.timeout"))
+
+	tempDir = tempDirWithConfig(t, `{"remote_server": {"connect_timeout": "soon"}}`)
+	defer os.RemoveAll(tempDir)
+	commander = &notaryCommander{configFile: filepath.Join(tempDir, "config.json")}
+	_, err = commander.parseConfig()
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "remote_server.connect_timeout")
+}
+
+// NOTARY_ prefixed environment variables override the config file, and are
+// overridden by command line flags
+func TestRemoteServerEnvironmentOverridesConfig(t *testing.T) {
+	tempDir := tempDirWithConfig(t, `{"remote_server": {"url": "https://myserver"}}`)
+	defer os.RemoveAll(tempDir)
+	configFile := filepath.Join(tempDir, "config.json")
+
+	os.Setenv("NOTARY_REMOTE_SERVER_URL", "https://fromenv")
+	defer os.Unsetenv("NOTARY_REMOTE_SERVER_URL")
+
+	testCases := []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{"-c", configFile, "list"}, "https://fromenv"},
+		{[]string{"-c", configFile, "-s", "http://overridden", "list"}, "http://overridden"},
+	}
+	for _, tc := range testCases {
+		commander := &notaryCommander{
+			getRetriever: func() passphrase.Retriever { return passphrase.ConstantRetriever("pass") },
+		}
+
+		// set a config file, so it doesn't check ~/.notary/config.json by default,
+		// and execute a random command so that the flags are parsed
+		cmd := commander.GetCommand()
+		cmd.SetArgs(tc.args)
+		cmd.SetOutput(new(bytes.Buffer)) // eat the output
+		cmd.Execute()
+
+		config, err := commander.parseConfig()
+		assert.NoError(t, err)
+		assert.Equal(t, tc.expected, getRemoteTrustServer(config, "gun"))
+	}
+}
+
+// a GUN listed in remote_server.gun_urls, in any case, uses the server url
+// given for it, and other GUNs use the config file's server url, unless the
+// server url is given on the command line
+func TestRemoteServerPerGUN(t *testing.T) {
+	tempDir := tempDirWithConfig(t, `{"remote_server": {
+		"url": "https://myserver",
+		"gun_urls": {"docker.io/Other/Repo": "https://otherserver"}
+	}}`)
+	defer os.RemoveAll(tempDir)
+	configFile := filepath.Join(tempDir, "config.json")
+
+	testCases := []struct {
+		args     []string
+		gun      string
+		expected string
+	}{
+		{[]string{"-c", configFile, "list"}, "docker.io/Other/Repo", "https://otherserver"},
+		{[]string{"-c", configFile, "list"}, "docker.io/other/repo", "https://otherserver"},
+		{[]string{"-c", configFile, "list"}, "docker.io/other/app", "https://myserver"},
+		{[]string{"-c", configFile, "-s", "http://overridden", "list"}, "docker.io/Other/Repo", "http://overridden"},
+	}
+	for _, tc := range testCases {
+		commander := &notaryCommander{
+			getRetriever: func() passphrase.Retriever { return passphrase.ConstantRetriever("pass") },
+		}
+
+		// set a config file, so it doesn't check ~/.notary/config.json by default,
+		// and execute a random command so that the flags are parsed
+		cmd := commander.GetCommand()
+		cmd.SetArgs(tc.args)
+		cmd.SetOutput(new(bytes.Buffer)) // eat the output
+		cmd.Execute()
+
+		config, err := commander.parseConfig()
+		assert.NoError(t, err)
+		assert.Equal(t, tc.expected, getRemoteTrustServer(config, tc.gun))
+	}
+}
+
+// a remote_server.gun_urls that does not map GUNs to urls is rejected
+func TestRemoteServerPerGUNInvalid(t *testing.T) {
+	for _, gunURLs := range []string{`"https://myserver"`, `{"docker.io/notary": ""}`, `{"docker.io/notary": 1}`} {
+		tempDir := tempDirWithConfig(t, fmt.Sprintf(`{"remote_server": {"gun_urls": %s}}`, gunURLs))
+		defer os.RemoveAll(tempDir)
+		configFile := filepath.Join(tempDir, "config.json")
+
+		commander := &notaryCommander{
+			getRetriever: func() passphrase.Retriever { return passphrase.ConstantRetriever("pass") },
+		}
+		cmd := commander.GetCommand()
+		cmd.SetArgs([]string{"-c", configFile, "list"})
+		cmd.SetOutput(new(bytes.Buffer)) // eat the output
+		cmd.Execute()
+
+		_, err := commander.parseConfig()
+		assert.Error(t, err, "expected %s to be rejected", gunURLs)
+		assert.Contains(t, err.Error(), "remote_server.gun_urls")
+	}
 }
 
 var exampleValidCommands = []string{
@@ -113,11 +389,26 @@ var exampleValidCommands = []string{
 	"key import backup.pem",
 	"key remove e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
 	"key passwd e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+	"key check-passphrase e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+	"key fingerprint e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+	"key rotate-expiring repo",
+	"key prune repo",
 	"cert list",
 	"cert remove e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
 	"delegation list repo",
+	"delegation info repo targets/releases",
+	"delegation history repo targets/releases",
+	"delegation coverage repo releases/v1",
 	"delegation add repo targets/releases path/to/pem/file.pem",
 	"delegation remove repo targets/releases",
+	"delegation rename repo targets/releases targets/other",
+	"delegation rotate-key repo targets/releases e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855 path/to/pem/file.pem",
+	"delegation add-keys-from-csv repo delegations.csv",
+	"changelist export repo changes.json",
+	"changelist import repo changes.json",
+	"trust rotate-root repo newroot.pem",
+	"trust signatures repo",
+	"trust canon-check repo targets",
 }
 
 // config parsing bugs are propagated in all commands
@@ -189,8 +480,8 @@ func TestBareCommandPrintsUsageAndNoError(t *testing.T) {
 	// usage is printed
 	require.Contains(t, b.String(), "Usage:", "expected usage when running `notary`")
 
-	// notary key, notary cert, and notary delegation
-	for _, bareCommand := range []string{"key", "cert", "delegation"} {
+	// notary key, notary cert, notary delegation, notary changelist, and notary trust
+	for _, bareCommand := range []string{"key", "cert", "delegation", "changelist", "trust"} {
 		b := new(bytes.Buffer)
 		cmd := NewNotaryCommand()
 		cmd.SetOutput(b)
@@ -369,3 +660,231 @@ func TestConfigFileOverridenByCmdLineFlags(t *testing.T) {
 	assert.Len(t, m.gotten, 1)
 	assert.Equal(t, m.gotten[0], "repo.root")
 }
+
+// Output goes to the command's output unless an output file is given, in which
+// case the file is created with restrictive permissions and is only overwritten
+// if forced to.
+func TestOutputFile(t *testing.T) {
+	tempdir, err := ioutil.TempDir("", "output-file")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempdir)
+
+	b := new(bytes.Buffer)
+	cmd := NewNotaryCommand()
+	cmd.SetOutput(b)
+
+	o := outputFile{}
+	out, closeOutput, err := o.open(cmd)
+	require.NoError(t, err)
+	fmt.Fprint(out, "to the console")
+	require.NoError(t, closeOutput())
+	require.Equal(t, "to the console", b.String())
+
+	o.path = filepath.Join(tempdir, "output")
+	require.NoError(t, ioutil.WriteFile(o.path, []byte("existing"), 0644))
+
+	_, _, err = o.open(cmd)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "--force")
+	contents, err := ioutil.ReadFile(o.path)
+	require.NoError(t, err)
+	require.Equal(t, "existing", string(contents))
+
+	o.force = true
+	out, closeOutput, err = o.open(cmd)
+	require.NoError(t, err)
+	fmt.Fprint(out, "to the file")
+	require.NoError(t, closeOutput())
+
+	contents, err = ioutil.ReadFile(o.path)
+	require.NoError(t, err)
+	require.Equal(t, "to the file", string(contents))
+	fi, err := os.Stat(o.path)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0600), fi.Mode().Perm())
+}
+
+// The passphrases come from the sources given with --passphrase-source, tried
+// in turn, and an unknown source is reported before any command is run
+func TestPassphraseSources(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "notary-test-")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+	passphraseDir := filepath.Join(tempDir, "passphrases")
+	require.NoError(t, os.MkdirAll(passphraseDir, 0700))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(passphraseDir, "root"), []byte("rootpass\n"), 0600))
+	os.Setenv("TESTPREFIX_TARGETS_PASSPHRASE", "targetspass")
+	defer os.Unsetenv("TESTPREFIX_TARGETS_PASSPHRASE")
+
+	n := &notaryCommander{passphraseSources: []string{"file:" + passphraseDir, "env:TESTPREFIX"}}
+	retriever := n.sourceRetriever()
+	pass, _, err := retriever("keyID", "root", false, 0)
+	require.NoError(t, err)
+	require.Equal(t, "rootpass", pass)
+	pass, _, err = retriever("keyID", "targets", false, 0)
+	require.NoError(t, err)
+	require.Equal(t, "targetspass", pass)
+	_, _, err = retriever("keyID", "snapshot", false, 0)
+	require.Error(t, err)
+
+	configFile := filepath.Join(tempDir, "config.json")
+	require.NoError(t, ioutil.WriteFile(configFile, []byte("{}"), 0644))
+	cmd := NewNotaryCommand()
+	cmd.SetOutput(new(bytes.Buffer))
+	cmd.SetArgs([]string{"-c", configFile, "-d", tempDir, "--passphrase-source", "vault", "key", "list"})
+	err = cmd.Execute()
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "unknown passphrase source")
+}
+
+// With --cache-passphrases, the passphrase of each key is only read from the
+// sources once, and otherwise every time it is needed
+func TestCachePassphrases(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "notary-test-")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+	passphraseFile := filepath.Join(tempDir, "root")
+
+	for _, cache := range []bool{false, true} {
+		require.NoError(t, ioutil.WriteFile(passphraseFile, []byte("rootpass\n"), 0600))
+		n := &notaryCommander{passphraseSources: []string{"file:" + tempDir}, cachePassphrases: cache}
+		retriever := n.sourceRetriever()
+		pass, _, err := retriever("keyID", "root", false, 0)
+		require.NoError(t, err)
+		require.Equal(t, "rootpass", pass)
+
+		require.NoError(t, ioutil.WriteFile(passphraseFile, []byte("changed\n"), 0600))
+		pass, _, err = retriever("keyID", "root", false, 0)
+		require.NoError(t, err)
+		if cache {
+			require.Equal(t, "rootpass", pass)
+		} else {
+			require.Equal(t, "changed", pass)
+		}
+	}
+}
+
+// config validate reports nothing for a valid configuration, and every problem
+// with an invalid one
+func TestConfigValidate(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "config-validate")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+	fixtures, err := filepath.Abs(filepath.Join("..", "..", "fixtures"))
+	require.NoError(t, err)
+
+	validConfig := fmt.Sprintf(`{
+		"trust_dir": %q,
+		"remote_server": {
+			"url": "https://myserver",
+			"gun_urls": {"docker.com/notary": "http://otherserver:4443"},
+			"root_ca": %q,
+			"tls_client_cert": %q,
+			"tls_client_key": %q
+		}
+	}`, tempDir, filepath.Join(fixtures, "root-ca.crt"),
+		filepath.Join(fixtures, "notary-server.crt"), filepath.Join(fixtures, "notary-server.key"))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(tempDir, "valid.json"), []byte(validConfig), 0644))
+
+	invalidConfig := fmt.Sprintf(`{
+		"trust_dir": %q,
+		"max_timestamp_age": "soon",
+		"remote_server": {
+			"url": "notary-server:4443",
+			"gun_urls": {"docker.com/notary": "ftp://otherserver"},
+			"root_ca": %q,
+			"tls_client_cert": %q
+		}
+	}`, filepath.Join(tempDir, "missing"), filepath.Join(tempDir, "missing.crt"),
+		filepath.Join(fixtures, "notary-server.crt"))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(tempDir, "invalid.json"), []byte(invalidConfig), 0644))
+
+	runValidate := func(configFile string) (string, error) {
+		commander := &notaryCommander{
+			getRetriever: func() passphrase.Retriever { return passphrase.ConstantRetriever("pass") },
+		}
+		cmd := commander.GetCommand()
+		cmd.SetArgs([]string{"-c", configFile, "config", "validate"})
+		out := new(bytes.Buffer)
+		cmd.SetOutput(out)
+		err := cmd.Execute()
+		return out.String(), err
+	}
+
+	output, err := runValidate(filepath.Join(tempDir, "valid.json"))
+	require.NoError(t, err)
+	assert.Contains(t, output, "No problems found")
+
+	output, err = runValidate(filepath.Join(tempDir, "invalid.json"))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "6 problem(s)")
+	for _, problem := range []string{
+		"invalid max_timestamp_age",
+		"trust_dir " + filepath.Join(tempDir, "missing") + " does not exist",
+		"invalid remote_server.url",
+		"invalid remote_server.gun_urls URL for docker.com/notary",
+		"remote_server.root_ca " + filepath.Join(tempDir, "missing.crt") + " cannot be loaded",
+		"have to be set together",
+	} {
+		assert.Contains(t, output, problem)
+	}
+}
+
+// the maximum metadata sizes are read from the configuration, and the ones
+// given on the command line are added to them
+func TestMaxMetadataSizes(t *testing.T) {
+	tempDir := tempDirWithConfig(t, `{"max_metadata_size": {"targets": "200MiB", "timestamp": 2048}}`)
+	defer os.RemoveAll(tempDir)
+	configFile := filepath.Join(tempDir, "config.json")
+
+	testCases := []struct {
+		args     []string
+		expected map[string]int64
+	}{
+		{[]string{"-c", configFile, "list"}, map[string]int64{"targets": 200 << 20, "timestamp": 2048}},
+		{[]string{"-c", configFile, "--max-metadata-size", "targets/a=1KiB,targets=1GiB", "list"},
+			map[string]int64{"targets": 1 << 30, "targets/a": 1 << 10, "timestamp": 2048}},
+	}
+	for _, tc := range testCases {
+		commander := &notaryCommander{
+			getRetriever: func() passphrase.Retriever { return passphrase.ConstantRetriever("pass") },
+		}
+		cmd := commander.GetCommand()
+		cmd.SetArgs(tc.args)
+		cmd.SetOutput(new(bytes.Buffer)) // eat the output
+		cmd.Execute()
+
+		config, err := commander.parseConfig()
+		require.NoError(t, err)
+		assert.Equal(t, tc.expected, maxMetadataSizes(config))
+	}
+
+	for _, size := range []string{"targets=0", "targets=-1", "targets=lots", "notarole=1MiB", "targets"} {
+		commander := &notaryCommander{
+			getRetriever: func() passphrase.Retriever { return passphrase.ConstantRetriever("pass") },
+		}
+		cmd := commander.GetCommand()
+		cmd.SetArgs([]string{"-c", configFile, "--max-metadata-size", size, "list"})
+		cmd.SetOutput(new(bytes.Buffer)) // eat the output
+		cmd.Execute()
+
+		_, err := commander.parseConfig()
+		assert.Error(t, err, "expected %s to be rejected", size)
+	}
+}
+
+// With no_prompt, the prompt source fails instead of asking for a passphrase,
+// and the other sources are still tried first
+func TestNoPromptPassphraseSource(t *testing.T) {
+	os.Setenv("TESTPREFIX_ROOT_PASSPHRASE", "rootpass")
+	defer os.Unsetenv("TESTPREFIX_ROOT_PASSPHRASE")
+
+	retriever, err := newSourceRetriever([]string{"env:TESTPREFIX", "prompt"}, true)
+	require.NoError(t, err)
+	pass, _, err := retriever("keyID", "root", false, 0)
+	require.NoError(t, err)
+	require.Equal(t, "rootpass", pass)
+	_, giveup, err := retriever("keyID", "targets", false, 0)
+	require.Equal(t, passphrase.ErrPromptDisabled, err)
+	require.True(t, giveup)
+}
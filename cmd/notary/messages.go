@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// The names of the messages printed when a change has been staged, which are
+// the keys to override them with in the messages section of the configuration
+const (
+	msgDelegationAdd       = "delegation_add"
+	msgDelegationReplace   = "delegation_replace"
+	msgDelegationAddParent = "delegation_add_parent"
+	msgDelegationRemove    = "delegation_remove"
+	msgDelegationRemoveAll = "delegation_remove_all"
+)
+
+// defaultMessages are the text/template templates of the messages, unless
+// the configuration overrides them
+var defaultMessages = map[string]string{
+	msgDelegationAdd:       `Addition of delegation role {{.Role}} {{.Items}}to repository "{{.GUN}}" staged for next publish.`,
+	msgDelegationReplace:   `Replacement of delegation role {{.Role}} {{.Items}}in repository "{{.GUN}}" staged for next publish.`,
+	msgDelegationAddParent: `Addition of missing parent delegation role {{.Role}} to repository "{{.GUN}}" staged for next publish.`,
+	msgDelegationRemove:    `Removal of delegation role {{.Role}} {{.Items}}to repository "{{.GUN}}" staged for next publish.`,
+	msgDelegationRemoveAll: `Forced removal (including all keys and paths) of delegation role {{.Role}} to repository "{{.GUN}}" staged for next publish.`,
+}
+
+// messageFuncs are the functions that the templates of messages can use,
+// besides the text/template builtins
+var messageFuncs = template.FuncMap{
+	"join": strings.Join,
+}
+
+// stagedChange is what a message about a staged change is rendered with
+type stagedChange struct {
+	GUN        string
+	Role       string
+	KeyIDs     []string
+	Paths      []string
+	AllPaths   bool
+	ExactPaths []string
+	// Expires is the expiry given for the delegation, in RFC 3339 format
+	Expires string
+	// Items describes the keys, paths and expiry of the change in the form
+	// the default messages use, such as "with keys [...], with paths [...], "
+	Items string
+}
+
+// messageTemplate parses the template of a message, as overridden in the
+// messages section of the configuration if it is
+func messageTemplate(config *viper.Viper, name string) (*template.Template, error) {
+	text := defaultMessages[name]
+	if override := config.GetString("messages." + name); override != "" {
+		text = override
+	}
+	return template.New(name).Funcs(messageFuncs).Parse(text)
+}
+
+// printStagedChange prints the message about a staged change.  The change has
+// been staged by then, so if an overridden message cannot be rendered, that is
+// only a warning, and the default message is printed instead.
+func printStagedChange(cmd *cobra.Command, config *viper.Viper, name string, change stagedChange) {
+	var msg bytes.Buffer
+	tmpl, err := messageTemplate(config, name)
+	if err == nil {
+		err = tmpl.Execute(&msg, change)
+	}
+	if err != nil {
+		warnf(os.Stderr, "could not render the %s message from the configuration: %v", name, err)
+		msg.Reset()
+		template.Must(template.New(name).Funcs(messageFuncs).Parse(defaultMessages[name])).Execute(&msg, change)
+	}
+	cmd.Println(msg.String())
+}
+
+// messageConfigProblems checks that every message overridden in the
+// configuration is a known message, with a template that parses and only uses
+// the fields of a staged change
+func messageConfigProblems(config *viper.Viper) []error {
+	overrides := config.GetStringMapString("messages")
+	names := make([]string, 0, len(overrides))
+	for name := range overrides {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var problems []error
+	for _, name := range names {
+		if _, ok := defaultMessages[name]; !ok {
+			problems = append(problems, fmt.Errorf("unknown message %q in messages", name))
+			continue
+		}
+		tmpl, err := messageTemplate(config, name)
+		if err == nil {
+			err = tmpl.Execute(ioutil.Discard, stagedChange{})
+		}
+		if err != nil {
+			problems = append(problems, fmt.Errorf("invalid template for messages.%s: %v", name, err))
+		}
+	}
+	return problems
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/require"
+)
+
+func renderStagedChange(config *viper.Viper, name string, change stagedChange) string {
+	out := new(bytes.Buffer)
+	cmd := &cobra.Command{}
+	cmd.SetOutput(out)
+	printStagedChange(cmd, config, name, change)
+	return out.String()
+}
+
+// the default messages are the fixed ones the delegation commands have always
+// printed, and overrides get all of the fields of the change
+func TestPrintStagedChange(t *testing.T) {
+	change := stagedChange{
+		GUN:    "docker.com/notary",
+		Role:   "targets/releases",
+		KeyIDs: []string{"abc", "def"},
+		Paths:  []string{"a", "b"},
+		Items:  "with keys [abc def], with paths [a,b], ",
+	}
+
+	config := viper.New()
+	require.Equal(t,
+		"Addition of delegation role targets/releases with keys [abc def], with paths [a,b], to repository \"docker.com/notary\" staged for next publish.\n",
+		renderStagedChange(config, msgDelegationAdd, change))
+	require.Equal(t,
+		"Forced removal (including all keys and paths) of delegation role targets/releases to repository \"docker.com/notary\" staged for next publish.\n",
+		renderStagedChange(config, msgDelegationRemoveAll, change))
+
+	config.Set("messages", map[string]interface{}{
+		msgDelegationAdd:    `{{.GUN}}: {{.Role}} += {{join .KeyIDs ","}} ({{join .Paths " "}})`,
+		msgDelegationRemove: `{{.Role}} {{.NoSuchField}}`,
+	})
+	problems := messageConfigProblems(config)
+	require.Len(t, problems, 1)
+	require.Contains(t, problems[0].Error(), "NoSuchField")
+	require.Equal(t, "docker.com/notary: targets/releases += abc,def (a b)\n",
+		renderStagedChange(config, msgDelegationAdd, change))
+	// a message that cannot be rendered falls back on the default
+	require.Equal(t,
+		"Removal of delegation role targets/releases with keys [abc def], with paths [a,b], to repository \"docker.com/notary\" staged for next publish.\n",
+		renderStagedChange(config, msgDelegationRemove, change))
+}
+
+func TestMessageConfigProblems(t *testing.T) {
+	config := viper.New()
+	require.Empty(t, messageConfigProblems(config))
+
+	config.Set("messages", map[string]interface{}{
+		msgDelegationAdd: `{{.Role`,
+		"no_such_message": "hello",
+	})
+	problems := messageConfigProblems(config)
+	require.Len(t, problems, 2)
+	require.Contains(t, problems[0].Error(), "invalid template for messages.delegation_add")
+	require.Contains(t, problems[1].Error(), `unknown message "no_such_message"`)
+}
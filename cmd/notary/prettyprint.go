@@ -8,11 +8,13 @@ import (
 	"math"
 	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/docker/notary/client"
 	"github.com/docker/notary/trustmanager"
+	tufclient "github.com/docker/notary/tuf/client"
 	"github.com/docker/notary/tuf/data"
 	"github.com/olekukonko/tablewriter"
 )
@@ -29,6 +31,320 @@ func getTable(headers []string, writer io.Writer) *tablewriter.Table {
 	return table
 }
 
+// --- pretty printing an expiry report ---
+
+// role expiries by expiry, then name
+type roleExpirySorter []roleExpiry
+
+func (r roleExpirySorter) Len() int      { return len(r) }
+func (r roleExpirySorter) Swap(i, j int) { r[i], r[j] = r[j], r[i] }
+func (r roleExpirySorter) Less(i, j int) bool {
+	if !r[i].Expiry.Equal(r[j].Expiry) {
+		return r[i].Expiry.Before(r[j].Expiry)
+	}
+	return r[i].Role < r[j].Role
+}
+
+// Pretty-prints how long it is until the soonest expiry of each role, in the
+// order given.  hasDelegations tells an empty report on a repository without
+// delegations apart from one whose delegations never expire.
+func prettyPrintExpiryReport(report []roleExpiry, hasDelegations bool, writer io.Writer) {
+	if !hasDelegations {
+		writer.Write([]byte("\nNo delegations present in this repository.\n\n"))
+		return
+	}
+	if len(report) == 0 {
+		writer.Write([]byte("\nNo expiring delegation keys or metadata in this repository.\n\n"))
+		return
+	}
+
+	table := getTable([]string{"Role", "Expires", "Expires In"}, writer)
+	for _, r := range report {
+		table.Append([]string{
+			r.Role,
+			r.Expiry.UTC().Format(time.RFC3339),
+			(time.Duration(r.ExpiresInSeconds) * time.Second).String(),
+		})
+	}
+	table.Render()
+}
+
+// --- pretty printing a threshold report ---
+
+// role thresholds by name
+type roleThresholdSorter []roleThreshold
+
+func (r roleThresholdSorter) Len() int           { return len(r) }
+func (r roleThresholdSorter) Swap(i, j int)      { r[i], r[j] = r[j], r[i] }
+func (r roleThresholdSorter) Less(i, j int) bool { return r[i].Role < r[j].Role }
+
+// Pretty-prints how many valid keys each role has against its threshold, in
+// the order given, marking the roles that can no longer be signed
+func prettyPrintThresholdReport(report []roleThreshold, writer io.Writer) {
+	if len(report) == 0 {
+		writer.Write([]byte("\nNo delegations present in this repository.\n\n"))
+		return
+	}
+
+	table := getTable([]string{"Role", "Threshold", "Valid Keys", "Keys", "Status"}, writer)
+	for _, r := range report {
+		status := "ok"
+		if r.BelowThreshold {
+			status = "BELOW THRESHOLD"
+		}
+		table.Append([]string{
+			r.Role,
+			strconv.Itoa(r.Threshold),
+			strconv.Itoa(r.ValidKeys),
+			strconv.Itoa(r.Keys),
+			status,
+		})
+	}
+	table.Render()
+}
+
+// Prints the delegation tree as a Graphviz DOT graph, with an edge from every
+// role to each of the roles it delegates to, labelled with the threshold and
+// the number of keys of the delegation
+func printDelegationGraph(gun string, tree *delegationNode, writer io.Writer) {
+	fmt.Fprintf(writer, "digraph %q {\n", gun)
+	fmt.Fprintf(writer, "\t%q;\n", tree.Name)
+	var printEdges func(node *delegationNode)
+	printEdges = func(node *delegationNode) {
+		for _, child := range node.Children {
+			fmt.Fprintf(writer, "\t%q;\n", child.Name)
+			fmt.Fprintf(writer, "\t%q -> %q [label=%q];\n", node.Name, child.Name,
+				fmt.Sprintf("%d of %d", child.Threshold, len(child.KeyIDs)))
+			printEdges(child)
+		}
+	}
+	printEdges(tree)
+	fmt.Fprintln(writer, "}")
+}
+
+type pathCoverageSorter []pathCoverage
+
+func (p pathCoverageSorter) Len() int           { return len(p) }
+func (p pathCoverageSorter) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+func (p pathCoverageSorter) Less(i, j int) bool { return p[i].Path < p[j].Path }
+
+// Pretty-prints which delegations govern each delegated path, and which paths
+// unrelated delegations overlap on
+func prettyPrintPathCoverage(coverage []pathCoverage, writer io.Writer) {
+	if len(coverage) == 0 {
+		writer.Write([]byte("\nNo delegations present in this repository.\n\n"))
+		return
+	}
+
+	table := getTable([]string{"Path", "Roles", "Overlaps"}, writer)
+	for _, pc := range coverage {
+		table.Append([]string{
+			prettyPrintPaths([]string{pc.Path}),
+			strings.Join(pc.Roles, ","),
+			strings.Join(pc.Overlaps, ","),
+		})
+	}
+	table.Render()
+}
+
+// Pretty-prints the delegations that can sign each path, flagging the paths
+// that only the targets role can sign
+func prettyPrintPathSigners(signers []pathSigners, writer io.Writer) {
+	table := getTable([]string{"Path", "Delegations"}, writer)
+	targetsOnly := 0
+	for _, ps := range signers {
+		roles := strings.Join(ps.Roles, ",")
+		if ps.TargetsOnly {
+			roles = "NONE (only targets can sign)"
+			targetsOnly++
+		}
+		table.Append([]string{prettyPrintPaths([]string{ps.Path}), roles})
+	}
+	table.Render()
+	if targetsOnly > 0 {
+		fmt.Fprintf(writer, "\n%d of %d paths are not covered by any delegation\n", targetsOnly, len(signers))
+	}
+}
+
+// Pretty-prints the key ID of each checked public key certificate file, or
+// why it was rejected
+func prettyPrintCertValidations(results []certValidation, writer io.Writer) {
+	table := getTable([]string{"File", "Key ID", "Status"}, writer)
+	for _, r := range results {
+		keyID, status := r.KeyID, "ok"
+		if keyID == "" {
+			keyID = "-"
+		}
+		if r.Error != "" {
+			status = r.Error
+		}
+		table.Append([]string{r.File, keyID, status})
+	}
+	table.Render()
+}
+
+// Pretty-prints the result of each row of a CSV file given to delegation
+// add-keys-from-csv
+func prettyPrintCSVDelegationRows(rows []csvDelegationRow, writer io.Writer) {
+	table := getTable([]string{"Row", "Role", "Certificate", "Key ID", "Status"}, writer)
+	for _, r := range rows {
+		keyID := r.KeyID
+		if keyID == "" {
+			keyID = "-"
+		}
+		table.Append([]string{strconv.Itoa(r.Row), r.Role, r.Certificate, keyID, r.Status})
+	}
+	table.Render()
+}
+
+// Pretty-prints the key ID and status of every signature on the metadata of
+// each role, flagging the signatures that do not count towards the threshold
+// of the role, and warning about the roles that do not meet it
+func prettyPrintSignatureChecks(checks []client.RoleSignatureChecks, writer io.Writer) {
+	table := getTable([]string{"Role", "Key ID", "Method", "Status"}, writer)
+	var short []client.RoleSignatureChecks
+	for _, c := range checks {
+		if len(c.Signatures) == 0 {
+			table.Append([]string{c.Role, "-", "-", "NO SIGNATURES"})
+		}
+		for _, sig := range c.Signatures {
+			status := sig.Status
+			switch sig.Status {
+			case client.SignatureInvalid:
+				status = "INVALID"
+			case client.SignatureUnauthorized:
+				status = "UNAUTHORIZED (not a key of this role)"
+			case client.SignatureUnknownKey:
+				status = "UNKNOWN KEY (not listed in the repository)"
+			}
+			table.Append([]string{c.Role, sig.KeyID, sig.Method, status})
+		}
+		if c.Valid() < c.Threshold {
+			short = append(short, c)
+		}
+	}
+	table.Render()
+	if len(short) > 0 {
+		fmt.Fprintln(writer)
+	}
+	for _, c := range short {
+		warnf(writer, "%s has %d valid signature(s), but its threshold is %d", c.Role, c.Valid(), c.Threshold)
+	}
+}
+
+// Prints how many signatures each published role was signed with, out of the
+// number its threshold requires, warning about the roles that fall short
+func prettyPrintSignatureCounts(counts []client.RoleSignatures, writer, warnings io.Writer) {
+	for _, c := range counts {
+		fmt.Fprintf(writer, "Signed %s with %d signature(s), %d required\n", c.Role, c.Signatures, c.Threshold)
+		if c.Signatures < c.Threshold {
+			warnf(warnings, "%s does not have enough signatures to meet its threshold of %d", c.Role, c.Threshold)
+		}
+	}
+}
+
+// Pretty-prints the changes to a delegation role, one version per row
+func prettyPrintDelegationHistory(role string, changes []client.DelegationChange, writer io.Writer) {
+	if len(changes) == 0 {
+		fmt.Fprintf(writer, "No downloaded versions of the metadata include %s.\n", role)
+		return
+	}
+
+	table := getTable([]string{"Version", "Seen", "Changes"}, writer)
+	for _, c := range changes {
+		table.Append([]string{
+			fmt.Sprintf("%d", c.Version),
+			c.Seen.UTC().Format(time.RFC3339),
+			describeDelegationChange(c),
+		})
+	}
+	table.Render()
+}
+
+// Pretty-prints the differences between the delegations of two repositories,
+// one row per differing delegation role
+func prettyPrintDelegationDifferences(first, second string, diffs []client.DelegationDifference, writer io.Writer) {
+	if len(diffs) == 0 {
+		fmt.Fprintf(writer, "The delegations of %s and %s are the same.\n", first, second)
+		return
+	}
+
+	table := getTable([]string{"Role", "Differences"}, writer)
+	for _, diff := range diffs {
+		table.Append([]string{diff.Role, describeDelegationDifference(first, second, diff)})
+	}
+	table.Render()
+}
+
+// Describes how a delegation role differs between two repositories, such as
+// "threshold 1 in a, 2 in b; keys only in a: abc"
+func describeDelegationDifference(first, second string, diff client.DelegationDifference) string {
+	if diff.OnlyInFirst {
+		return "only in " + first
+	}
+	if diff.OnlyInSecond {
+		return "only in " + second
+	}
+	var parts []string
+	if diff.FirstThreshold != diff.SecondThreshold {
+		parts = append(parts, fmt.Sprintf("threshold %d in %s, %d in %s",
+			diff.FirstThreshold, first, diff.SecondThreshold, second))
+	}
+	if len(diff.KeysOnlyInFirst) > 0 {
+		parts = append(parts, fmt.Sprintf("keys only in %s: %s", first, strings.Join(diff.KeysOnlyInFirst, ",")))
+	}
+	if len(diff.KeysOnlyInSecond) > 0 {
+		parts = append(parts, fmt.Sprintf("keys only in %s: %s", second, strings.Join(diff.KeysOnlyInSecond, ",")))
+	}
+	if len(diff.PathsOnlyInFirst) > 0 {
+		parts = append(parts, fmt.Sprintf("paths only in %s: %s", first, prettyPrintPaths(diff.PathsOnlyInFirst)))
+	}
+	if len(diff.PathsOnlyInSecond) > 0 {
+		parts = append(parts, fmt.Sprintf("paths only in %s: %s", second, prettyPrintPaths(diff.PathsOnlyInSecond)))
+	}
+	return strings.Join(parts, "; ")
+}
+
+// Pretty-prints the chain of roles walked down to the role whose metadata
+// failed verification, and why it failed
+func prettyPrintVerificationFailure(verr tufclient.ErrVerification, writer io.Writer) {
+	fmt.Fprintf(writer, "Verification of %s failed:\n", verr.Role())
+	table := getTable([]string{"Role", "Status"}, writer)
+	for _, role := range verr.Chain[:len(verr.Chain)-1] {
+		table.Append([]string{role, "verified"})
+	}
+	table.Append([]string{verr.Role(), string(verr.Reason)})
+	table.Render()
+}
+
+// Describes a change to a delegation role, such as
+// "added keys abc; removed paths x; threshold 1 -> 2"
+func describeDelegationChange(c client.DelegationChange) string {
+	if c.Removed {
+		return "removed"
+	}
+	var parts []string
+	if c.Created {
+		parts = append(parts, fmt.Sprintf("created with threshold %d", c.Threshold))
+	}
+	if len(c.AddedKeys) > 0 {
+		parts = append(parts, "added keys "+strings.Join(c.AddedKeys, ","))
+	}
+	if len(c.RemovedKeys) > 0 {
+		parts = append(parts, "removed keys "+strings.Join(c.RemovedKeys, ","))
+	}
+	if len(c.AddedPaths) > 0 {
+		parts = append(parts, "added paths "+prettyPrintPaths(c.AddedPaths))
+	}
+	if len(c.RemovedPaths) > 0 {
+		parts = append(parts, "removed paths "+prettyPrintPaths(c.RemovedPaths))
+	}
+	if c.PreviousThreshold != 0 {
+		parts = append(parts, fmt.Sprintf("threshold %d -> %d", c.PreviousThreshold, c.Threshold))
+	}
+	return strings.Join(parts, "; ")
+}
+
 // --- pretty printing certs ---
 
 func truncateWithEllipsis(str string, maxWidth int, leftTruncate bool) string {
@@ -51,6 +367,7 @@ type keyInfo struct {
 	role     string
 	keyID    string
 	location string
+	backend  string
 }
 
 // We want to sort by gun, then by role, then by keyID, then by location
@@ -90,13 +407,27 @@ func (k keyInfoSorter) Less(i, j int) bool {
 	return false
 }
 
+// keyBackendDescription describes where a key is, as the kind of storage it
+// is in followed by where in it the key is, if that is known
+func keyBackendDescription(backend, location string) string {
+	if location == "" {
+		return backend
+	}
+	return fmt.Sprintf("%s: %s", backend, location)
+}
+
 // Given a list of KeyStores in order of listing preference, pretty-prints the
-// root keys and then the signing keys.
-func prettyPrintKeys(keyStores []trustmanager.KeyStore, writer io.Writer) {
+// root keys and then the signing keys.  If a backend is given, only the keys
+// in that kind of storage are listed.
+func prettyPrintKeys(keyStores []trustmanager.KeyStore, backendFilter string, writer io.Writer) {
 	var info []keyInfo
 
 	for _, store := range keyStores {
 		for keyPath, role := range store.ListKeys() {
+			backend, location := trustmanager.KeyBackend(store, keyPath)
+			if backendFilter != "" && !strings.EqualFold(backend, backendFilter) {
+				continue
+			}
 			gun := ""
 			if role != data.CanonicalRootRole {
 				dirPath := filepath.Dir(keyPath)
@@ -109,6 +440,7 @@ func prettyPrintKeys(keyStores []trustmanager.KeyStore, writer io.Writer) {
 				location: store.Name(),
 				gun:      gun,
 				keyID:    filepath.Base(keyPath),
+				backend:  keyBackendDescription(backend, location),
 			})
 		}
 	}
@@ -120,7 +452,7 @@ func prettyPrintKeys(keyStores []trustmanager.KeyStore, writer io.Writer) {
 
 	sort.Stable(keyInfoSorter(info))
 
-	table := getTable([]string{"ROLE", "GUN", "KEY ID", "LOCATION"}, writer)
+	table := getTable([]string{"ROLE", "GUN", "KEY ID", "LOCATION", "BACKEND"}, writer)
 
 	for _, oneKeyInfo := range info {
 		table.Append([]string{
@@ -128,6 +460,8 @@ func prettyPrintKeys(keyStores []trustmanager.KeyStore, writer io.Writer) {
 			truncateWithEllipsis(oneKeyInfo.gun, maxGUNWidth, true),
 			oneKeyInfo.keyID,
 			truncateWithEllipsis(oneKeyInfo.location, maxLocWidth, true),
+			// not truncated, since the whole path or slot is needed to find the key
+			oneKeyInfo.backend,
 		})
 	}
 	table.Render()
@@ -175,29 +509,226 @@ func prettyPrintTargets(ts []*client.TargetWithRole, writer io.Writer) {
 	table.Render()
 }
 
-// Pretty-prints the list of provided Roles
-func prettyPrintRoles(rs []*data.Role, writer io.Writer, roleType string) {
-	if len(rs) == 0 {
-		writer.Write([]byte(fmt.Sprintf("\nNo %s present in this repository.\n\n", roleType)))
-		return
+// the orders in which roles can be listed
+const (
+	roleSortName     = "name"
+	roleSortKeyCount = "keycount"
+	roleSortExpiry   = "expiry"
+)
+
+// roleOrder sorts roles by the given key, falling back to their names for
+// roles that have the same value for the key.  The expiries are the earliest
+// expiry of the keys of each role, which are only needed to sort by expiry.
+type roleOrder struct {
+	roles    []*data.Role
+	sortBy   string
+	expiries map[string]time.Time
+}
+
+func (r roleOrder) Len() int      { return len(r.roles) }
+func (r roleOrder) Swap(i, j int) { r.roles[i], r.roles[j] = r.roles[j], r.roles[i] }
+func (r roleOrder) Less(i, j int) bool {
+	ri, rj := r.roles[i], r.roles[j]
+	switch r.sortBy {
+	case roleSortKeyCount:
+		if len(ri.KeyIDs) != len(rj.KeyIDs) {
+			return len(ri.KeyIDs) < len(rj.KeyIDs)
+		}
+	case roleSortExpiry:
+		// roles with no expiring keys go last
+		ei, iExpires := r.expiries[ri.Name]
+		ej, jExpires := r.expiries[rj.Name]
+		if iExpires != jExpires {
+			return iExpires
+		}
+		if !ei.Equal(ej) {
+			return ei.Before(ej)
+		}
 	}
+	return ri.Name < rj.Name
+}
 
+// checkRoleSort returns an error if roles can't be sorted by the given key
+func checkRoleSort(sortBy string) error {
+	switch sortBy {
+	case roleSortName, roleSortKeyCount, roleSortExpiry:
+		return nil
+	}
+	return fmt.Errorf("Invalid sort order %q: must be one of %s, %s or %s",
+		sortBy, roleSortName, roleSortKeyCount, roleSortExpiry)
+}
+
+// sortRoles sorts the roles in ascending order of the given sort key, or in
+// descending order if reverse is set
+func sortRoles(rs []*data.Role, sortBy string, reverse bool, expiries map[string]time.Time) error {
+	if err := checkRoleSort(sortBy); err != nil {
+		return err
+	}
+	var order sort.Interface = roleOrder{roles: rs, sortBy: sortBy, expiries: expiries}
+	if reverse {
+		order = sort.Reverse(order)
+	}
+	sort.Sort(order)
+	return nil
+}
+
+// Prints each of the provided Roles, in the order they are given, on a line
+// of its own with tab separated fields that are always in the same order: the
+// name, the number of keys, the threshold and the earliest expiry, in RFC 3339
+// format in UTC, or - if nothing about the role expires
+func prettyPrintRolesCompact(rs []*data.Role, expiries map[string]time.Time, writer io.Writer) {
+	for _, r := range rs {
+		expiry := "-"
+		if earliest, ok := expiries[r.Name]; ok {
+			expiry = earliest.UTC().Format(time.RFC3339)
+		}
+		fmt.Fprintf(writer, "%s\t%d\t%d\t%s\n", r.Name, len(r.KeyIDs), r.Threshold, expiry)
+	}
+}
+
+// Pretty-prints the list of provided Roles, sorted by name
+func prettyPrintRoles(rs []*data.Role, keyTypes map[string]string, writer io.Writer, roleType string) {
 	// this sorter works for Role types
 	sort.Stable(roleSorter(rs))
+	prettyPrintRolesInOrder(rs, keyTypes, writer, roleType)
+}
 
-	table := getTable([]string{"Role", "Paths", "Key IDs", "Threshold"}, writer)
+// Pretty-prints the list of provided Roles in the order they are given, with
+// the type of each key (by key ID) in the same order as the key IDs
+func prettyPrintRolesInOrder(rs []*data.Role, keyTypes map[string]string, writer io.Writer, roleType string) {
+	if len(rs) == 0 {
+		writer.Write([]byte(fmt.Sprintf("\nNo %s present in this repository.\n\n", roleType)))
+		return
+	}
+
+	table := getTable([]string{"Role", "Paths", "Key IDs", "Key Types", "Threshold", "Expires"}, writer)
 
 	for _, r := range rs {
+		types := make([]string, 0, len(r.KeyIDs))
+		for _, keyID := range r.KeyIDs {
+			keyType, ok := keyTypes[keyID]
+			if !ok {
+				keyType = "-"
+			}
+			types = append(types, keyType)
+		}
 		table.Append([]string{
 			r.Name,
-			prettyPrintPaths(r.Paths),
+			prettyPrintRolePaths(r.Paths, r.PathHashPrefixes),
 			strings.Join(r.KeyIDs, ","),
+			strings.Join(types, ","),
+			fmt.Sprintf("%v", r.Threshold),
+			prettyPrintValidUntil(r.ValidUntil),
+		})
+	}
+	table.Render()
+}
+
+// Pretty-prints the notes on the paths of the roles, sorted by role and path,
+// if any role has any
+func prettyPrintPathNotes(rs []*data.Role, writer io.Writer) {
+	var rows [][]string
+	for _, r := range rs {
+		paths := make([]string, 0, len(r.PathNotes))
+		for p := range r.PathNotes {
+			paths = append(paths, p)
+		}
+		sort.Strings(paths)
+		for _, p := range paths {
+			rows = append(rows, []string{r.Name, prettyPrintPaths([]string{p}), r.PathNotes[p]})
+		}
+	}
+	if len(rows) == 0 {
+		return
+	}
+
+	fmt.Fprintln(writer, "\nPath notes:")
+	table := getTable([]string{"Role", "Path", "Note"}, writer)
+	for _, row := range rows {
+		table.Append(row)
+	}
+	table.Render()
+}
+
+// Pretty-prints the delegations that a key is one of the keys of, with their
+// paths and thresholds
+func prettyPrintKeyRoles(roles []client.DelegationDetail, keyID string, writer io.Writer) {
+	if len(roles) == 0 {
+		writer.Write([]byte(fmt.Sprintf("\nKey %s is not a key of any delegation in this repository.\n\n", keyID)))
+		return
+	}
+
+	table := getTable([]string{"Role", "Paths", "Threshold", "Keys"}, writer)
+	for _, r := range roles {
+		table.Append([]string{
+			r.Name,
+			prettyPrintRolePaths(r.Paths, r.PathHashPrefixes),
 			fmt.Sprintf("%v", r.Threshold),
+			fmt.Sprintf("%v", len(r.Keys)),
 		})
 	}
 	table.Render()
 }
 
+// Pretty-prints whether the metadata of a role was sent as canonical JSON, and
+// the status of each of its signatures over the canonical JSON and over the
+// metadata as sent, flagging the signatures whose status differs
+func prettyPrintCanonicalizationCheck(check client.CanonicalizationCheck, writer io.Writer) {
+	if check.Canonical {
+		fmt.Fprintf(writer, "\nThe metadata of %s was sent as canonical JSON.\n\n", check.Role)
+	} else {
+		fmt.Fprintf(writer, "\nThe metadata of %s was NOT sent as canonical JSON: it first differs at byte %d.\n", check.Role, check.Offset)
+		fmt.Fprintf(writer, "  sent:      %q\n", check.SentContext)
+		fmt.Fprintf(writer, "  canonical: %q\n\n", check.CanonicalContext)
+	}
+	if len(check.Signatures) == 0 {
+		fmt.Fprintln(writer, "No signatures.")
+		return
+	}
+
+	table := getTable([]string{"Key ID", "Method", "Canonical", "As Sent"}, writer)
+	for _, sig := range check.Signatures {
+		sent := sig.Sent
+		if sig.Sent != sig.Canonical {
+			sent += " (DIFFERS)"
+		}
+		table.Append([]string{sig.KeyID, sig.Method, sig.Canonical, sent})
+	}
+	table.Render()
+}
+
+// Pretty-prints the delegation keys that are about to be rotated, in the
+// order given, with what each one is replaced with
+func prettyPrintExpiringKeys(keys []expiringKey, writer io.Writer) {
+	table := getTable([]string{"Key ID", "Expires", "Roles", "Replacement"}, writer)
+	for _, k := range keys {
+		replacement := "new key"
+		if k.CertPath != "" {
+			replacement = k.CertPath
+		}
+		table.Append([]string{
+			k.KeyID,
+			k.Expiry.UTC().Format(time.RFC3339),
+			strings.Join(k.Roles, ", "),
+			replacement,
+		})
+	}
+	table.Render()
+}
+
+// Pretty-prints when a delegation expires, flagging delegations that have
+// already expired, or "-" if it never does
+func prettyPrintValidUntil(validUntil *time.Time) string {
+	if validUntil == nil {
+		return "-"
+	}
+	expiry := validUntil.UTC().Format(time.RFC3339)
+	if !validUntil.After(time.Now()) {
+		expiry += " (expired)"
+	}
+	return expiry
+}
+
 // Pretty-prints a list of delegation paths, and ensures the empty string is printed as "" in the console
 func prettyPrintPaths(paths []string) string {
 	// sort paths first
@@ -213,8 +744,104 @@ func prettyPrintPaths(paths []string) string {
 	return strings.Join(prettyPaths, ",")
 }
 
+// Pretty-prints the paths of a role, which are prefixes, followed by its path
+// hash prefixes, marking those that are whole hashes and so match an exact path
+func prettyPrintRolePaths(paths, hashPrefixes []string) string {
+	prettyPaths := prettyPrintPaths(paths)
+	if len(hashPrefixes) == 0 {
+		return prettyPaths
+	}
+	sorted := make([]string, len(hashPrefixes))
+	copy(sorted, hashPrefixes)
+	sort.Strings(sorted)
+	parts := make([]string, 0, len(sorted)+1)
+	if prettyPaths != "" {
+		parts = append(parts, prettyPaths)
+	}
+	for _, prefix := range sorted {
+		if data.IsExactPathHash(prefix) {
+			parts = append(parts, "sha256:"+prefix+" <exact path>")
+		} else {
+			parts = append(parts, "sha256:"+prefix+"* <path hash prefix>")
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+// --- pretty printing a single delegation ---
+
+// Pretty-prints the details of a single delegation role
+func prettyPrintDelegationInfo(info client.DelegationDetail, writer io.Writer) {
+	fmt.Fprintf(writer, "Role:      %s\n", info.Name)
+	fmt.Fprintf(writer, "Threshold: %d\n", info.Threshold)
+	fmt.Fprintf(writer, "Paths:     %s\n", prettyPrintRolePaths(info.Paths, info.PathHashPrefixes))
+	if info.ValidUntil != nil {
+		fmt.Fprintf(writer, "Expires:   %s\n", prettyPrintValidUntil(info.ValidUntil))
+	}
+	fmt.Fprintln(writer)
+
+	if len(info.Keys) == 0 {
+		writer.Write([]byte("No keys present in this delegation.\n"))
+		return
+	}
+
+	table := getTable([]string{"Key ID", "Algorithm", "Key Type", "Expires In", "Can Sign"}, writer)
+	for _, k := range info.Keys {
+		expiryString := "-"
+		if k.Expiry != nil {
+			expiryString = prettyPrintExpiry(*k.Expiry)
+		}
+		canSign := "no"
+		if k.CanSign {
+			canSign = "yes"
+		}
+		keyType := k.KeyType
+		if keyType == "" {
+			keyType = "-"
+		}
+		table.Append([]string{k.ID, k.Algorithm, keyType, expiryString, canSign})
+	}
+	table.Render()
+}
+
+// Prints the problems fsck found, and whether each one was, or can be,
+// repaired
+func prettyPrintFsckProblems(problems []fsckProblem, writer io.Writer) {
+	if len(problems) == 0 {
+		fmt.Fprintln(writer, "No problems found.")
+		return
+	}
+	for _, p := range problems {
+		status := "left alone, since it is not safe to repair automatically"
+		switch {
+		case p.repaired:
+			status = "repaired"
+		case p.repair != nil:
+			status = "can be repaired with --repair"
+		}
+		fmt.Fprintf(writer, "%s: %s (%s)\n", p.path, p.problem, status)
+	}
+}
+
 // --- pretty printing certs ---
 
+// Pretty-prints how many days remain until the given expiry time, or that it
+// has already passed
+func prettyPrintExpiry(expiry time.Time) string {
+	remaining := expiry.Sub(time.Now())
+	if remaining <= 0 {
+		return "expired"
+	}
+	days := math.Floor(remaining.Hours() / 24)
+	expiryString := "< 1 day"
+	if days == 1 {
+		expiryString = "1 day"
+	} else if days > 1 {
+		expiryString = fmt.Sprintf("%d days", int(days))
+	}
+	return expiryString
+}
+
 // cert by repo name then expiry time.  Don't bother sorting by fingerprint.
 type certSorter []*x509.Certificate
 
@@ -244,13 +871,7 @@ func prettyPrintCerts(certs []*x509.Certificate, writer io.Writer) {
 		"GUN", "Fingerprint of Trusted Root Certificate", "Expires In"}, writer)
 
 	for _, c := range certs {
-		days := math.Floor(c.NotAfter.Sub(time.Now()).Hours() / 24)
-		expiryString := "< 1 day"
-		if days == 1 {
-			expiryString = "1 day"
-		} else if days > 1 {
-			expiryString = fmt.Sprintf("%d days", int(days))
-		}
+		expiryString := prettyPrintExpiry(c.NotAfter)
 
 		certID, err := trustmanager.FingerprintCert(c)
 		if err != nil {
@@ -261,3 +882,14 @@ func prettyPrintCerts(certs []*x509.Certificate, writer io.Writer) {
 	}
 	table.Render()
 }
+
+// --- pretty printing the doctor checks ---
+
+// Pretty-prints the result of each doctor check, in the order they were run
+func prettyPrintDoctorChecks(checks []doctorCheck, writer io.Writer) {
+	table := getTable([]string{"Check", "Result", "Detail"}, writer)
+	for _, check := range checks {
+		table.Append([]string{check.Name, check.Result, check.Detail})
+	}
+	table.Render()
+}
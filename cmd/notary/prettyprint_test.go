@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"reflect"
+	"regexp"
 	"sort"
 	"strings"
 	"testing"
@@ -17,6 +18,7 @@ import (
 	"github.com/docker/notary/cryptoservice"
 	"github.com/docker/notary/passphrase"
 	"github.com/docker/notary/trustmanager"
+	tufclient "github.com/docker/notary/tuf/client"
 	"github.com/docker/notary/tuf/data"
 	"github.com/stretchr/testify/assert"
 )
@@ -73,7 +75,7 @@ func TestPrettyPrintZeroKeys(t *testing.T) {
 	emptyKeyStore := trustmanager.NewKeyMemoryStore(ret)
 
 	var b bytes.Buffer
-	prettyPrintKeys([]trustmanager.KeyStore{emptyKeyStore}, &b)
+	prettyPrintKeys([]trustmanager.KeyStore{emptyKeyStore}, "", &b)
 	text, err := ioutil.ReadAll(&b)
 	assert.NoError(t, err)
 
@@ -112,18 +114,18 @@ func TestPrettyPrintRootAndSigningKeys(t *testing.T) {
 
 	expected := [][]string{
 		// root always comes first
-		{root, keys[0].ID(), keyStores[0].Name()},
-		{root, keys[0].ID(), longNameShortened},
+		{root, keys[0].ID(), keyStores[0].Name(), "memory"},
+		{root, keys[0].ID(), longNameShortened, "memory"},
 		// these have no gun, so they come first
-		{"invalidRole", keys[2].ID(), keyStores[0].Name()},
-		{"targets/a", keys[1].ID(), keyStores[0].Name()},
+		{"invalidRole", keys[2].ID(), keyStores[0].Name(), "memory"},
+		{"targets/a", keys[1].ID(), keyStores[0].Name(), "memory"},
 		// these have guns, and are sorted then by guns
-		{"targets", "..." + strings.Repeat("/a", 11), keys[0].ID(), keyStores[0].Name()},
-		{"snapshot", "short/gun", keys[0].ID(), longNameShortened},
+		{"targets", "..." + strings.Repeat("/a", 11), keys[0].ID(), keyStores[0].Name(), "memory"},
+		{"snapshot", "short/gun", keys[0].ID(), longNameShortened, "memory"},
 	}
 
 	var b bytes.Buffer
-	prettyPrintKeys(keyStores, &b)
+	prettyPrintKeys(keyStores, "", &b)
 	text, err := ioutil.ReadAll(&b)
 	assert.NoError(t, err)
 
@@ -132,7 +134,7 @@ func TestPrettyPrintRootAndSigningKeys(t *testing.T) {
 
 	// starts with headers
 	assert.True(t, reflect.DeepEqual(strings.Fields(lines[0]),
-		[]string{"ROLE", "GUN", "KEY", "ID", "LOCATION"}))
+		[]string{"ROLE", "GUN", "KEY", "ID", "LOCATION", "BACKEND"}))
 	assert.Equal(t, "----", lines[1][:4])
 
 	for i, line := range lines[2:] {
@@ -144,6 +146,23 @@ func TestPrettyPrintRootAndSigningKeys(t *testing.T) {
 	}
 }
 
+// Only the keys in the given kind of storage are listed, whatever its case
+func TestPrettyPrintKeysBackendFilter(t *testing.T) {
+	ret := passphrase.ConstantRetriever("pass")
+	keyStore := trustmanager.NewKeyMemoryStore(ret)
+	key, err := trustmanager.GenerateED25519Key(rand.Reader)
+	assert.NoError(t, err)
+	assert.NoError(t, keyStore.AddKey(key.ID(), data.CanonicalRootRole, key))
+
+	var b bytes.Buffer
+	prettyPrintKeys([]trustmanager.KeyStore{keyStore}, "MEMORY", &b)
+	assert.Contains(t, b.String(), key.ID())
+
+	b.Reset()
+	prettyPrintKeys([]trustmanager.KeyStore{keyStore}, "file", &b)
+	assert.Equal(t, "No signing keys found.\n", b.String())
+}
+
 // --- tests for pretty printing targets ---
 
 // If there are no targets, no table is printed, only a line saying that there
@@ -220,7 +239,7 @@ func generateCertificate(t *testing.T, gun string, expireInHours int64) *x509.Ce
 // are no roles.
 func TestPrettyPrintZeroRoles(t *testing.T) {
 	var b bytes.Buffer
-	prettyPrintRoles([]*data.Role{}, &b, "delegations")
+	prettyPrintRoles([]*data.Role{}, nil, &b, "delegations")
 	text, err := ioutil.ReadAll(&b)
 	assert.NoError(t, err)
 
@@ -229,27 +248,32 @@ func TestPrettyPrintZeroRoles(t *testing.T) {
 	assert.Equal(t, "No delegations present in this repository.", lines[0])
 }
 
-// Roles are sorted by name, and the name, paths, and KeyIDs are printed.
+// Roles are sorted by name, and the name, paths, KeyIDs, key types and
+// expiry are printed, with "-" for keys of unknown type and roles that don't
+// expire.
 func TestPrettyPrintSortedRoles(t *testing.T) {
 	var err error
+	future := time.Date(2100, time.January, 2, 0, 0, 0, 0, time.UTC)
+	past := time.Date(2000, time.January, 2, 0, 0, 0, 0, time.UTC)
 
 	unsorted := []*data.Role{
 		{Name: "targets/zebra", Paths: []string{"stripes", "black", "white"}, RootRole: data.RootRole{KeyIDs: []string{"101"}, Threshold: 1}},
 		{Name: "targets/aardvark/unicorn/pony", Paths: []string{"rainbows"}, RootRole: data.RootRole{KeyIDs: []string{"135"}, Threshold: 1}},
-		{Name: "targets/bee", Paths: []string{"honey"}, RootRole: data.RootRole{KeyIDs: []string{"246"}, Threshold: 1}},
-		{Name: "targets/bee/wasp", Paths: []string{"honey/sting"}, RootRole: data.RootRole{KeyIDs: []string{"246", "468"}, Threshold: 1}},
+		{Name: "targets/bee", Paths: []string{"honey"}, RootRole: data.RootRole{KeyIDs: []string{"246"}, Threshold: 1}, ValidUntil: &future},
+		{Name: "targets/bee/wasp", Paths: []string{"honey/sting"}, RootRole: data.RootRole{KeyIDs: []string{"246", "468"}, Threshold: 1}, ValidUntil: &past},
 	}
 
 	var b bytes.Buffer
-	prettyPrintRoles(unsorted, &b, "delegations")
+	keyTypes := map[string]string{"101": "ed25519", "246": "RSA 2048", "468": "ECDSA P-256"}
+	prettyPrintRoles(unsorted, keyTypes, &b, "delegations")
 	text, err := ioutil.ReadAll(&b)
 	assert.NoError(t, err)
 
 	expected := [][]string{
-		{"targets/aardvark/unicorn/pony", "rainbows", "135", "1"},
-		{"targets/bee", "honey", "246", "1"},
-		{"targets/bee/wasp", "honey/sting", "246,468", "1"},
-		{"targets/zebra", "black,stripes,white", "101", "1"},
+		{"targets/aardvark/unicorn/pony", "rainbows", "135", "-", "1", "-"},
+		{"targets/bee", "honey", "246", "RSA 2048", "1", "2100-01-02T00:00:00Z"},
+		{"targets/bee/wasp", "honey/sting", "246,468", "RSA 2048,ECDSA P-256", "1", "2000-01-02T00:00:00Z (expired)"},
+		{"targets/zebra", "black,stripes,white", "101", "ed25519", "1", "-"},
 	}
 
 	lines := strings.Split(strings.TrimSpace(string(text)), "\n")
@@ -257,13 +281,146 @@ func TestPrettyPrintSortedRoles(t *testing.T) {
 
 	// starts with headers
 	assert.True(t, reflect.DeepEqual(strings.Fields(lines[0]), strings.Fields(
-		"ROLE     PATHS      KEY IDS   THRESHOLD")))
+		"ROLE     PATHS      KEY IDS   KEY TYPES   THRESHOLD   EXPIRES")))
 	assert.Equal(t, "----", lines[1][:4])
 
+	// key types contain single spaces, but columns are separated by more
 	for i, line := range lines[2:] {
-		splitted := strings.Fields(line)
-		assert.Equal(t, expected[i], splitted)
+		assert.Equal(t, expected[i], splitTableRow(line))
+	}
+}
+
+// splitTableRow splits a row of a table printed by getTable into its cells,
+// which may contain single spaces
+func splitTableRow(line string) []string {
+	return regexp.MustCompile(`\s{2,}`).Split(strings.TrimSpace(line), -1)
+}
+
+// Roles can be sorted by name, number of keys or earliest key expiry, in
+// either order, with ties broken by name
+func TestSortRoles(t *testing.T) {
+	now := time.Now()
+	roles := func() []*data.Role {
+		return []*data.Role{
+			{Name: "targets/b", RootRole: data.RootRole{KeyIDs: []string{"1", "2"}}},
+			{Name: "targets/c", RootRole: data.RootRole{KeyIDs: []string{"3"}}},
+			{Name: "targets/a", RootRole: data.RootRole{KeyIDs: []string{"4", "5"}}},
+			{Name: "targets/d", RootRole: data.RootRole{KeyIDs: []string{"6"}}},
+		}
+	}
+	// targets/d has no keys that expire
+	expiries := map[string]time.Time{
+		"targets/a": now.AddDate(1, 0, 0),
+		"targets/b": now,
+		"targets/c": now,
+	}
+
+	testCases := []struct {
+		sortBy   string
+		reverse  bool
+		expected []string
+	}{
+		{roleSortName, false, []string{"targets/a", "targets/b", "targets/c", "targets/d"}},
+		{roleSortName, true, []string{"targets/d", "targets/c", "targets/b", "targets/a"}},
+		{roleSortKeyCount, false, []string{"targets/c", "targets/d", "targets/a", "targets/b"}},
+		{roleSortKeyCount, true, []string{"targets/b", "targets/a", "targets/d", "targets/c"}},
+		{roleSortExpiry, false, []string{"targets/b", "targets/c", "targets/a", "targets/d"}},
+		{roleSortExpiry, true, []string{"targets/d", "targets/a", "targets/c", "targets/b"}},
 	}
+	for _, tc := range testCases {
+		rs := roles()
+		assert.NoError(t, sortRoles(rs, tc.sortBy, tc.reverse, expiries))
+		names := make([]string, 0, len(rs))
+		for _, r := range rs {
+			names = append(names, r.Name)
+		}
+		assert.Equal(t, tc.expected, names, "wrong order sorting by %s (reverse: %v)", tc.sortBy, tc.reverse)
+	}
+
+	err := sortRoles(roles(), "threshold", false, nil)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "threshold")
+}
+
+// The compact listing has one line per role, in the given order, with the
+// name, key count, threshold and earliest expiry of the role, which is the
+// earliest of the expiry of its keys, its metadata and the delegation itself
+func TestPrettyPrintRolesCompact(t *testing.T) {
+	now := time.Date(2016, 3, 1, 12, 0, 0, 0, time.UTC)
+	expiry := func(days int) *time.Time {
+		e := now.AddDate(0, 0, days)
+		return &e
+	}
+	details := []client.DelegationDetail{
+		{Name: "targets/b", Expires: expiry(30), Keys: []client.DelegationKey{{Expiry: expiry(10)}}},
+		{Name: "targets/a", Expires: expiry(30), ValidUntil: expiry(3), Keys: []client.DelegationKey{{Expiry: expiry(10)}}},
+		{Name: "targets/c", Keys: []client.DelegationKey{{}}},
+	}
+	roles := []*data.Role{
+		{Name: "targets/b", RootRole: data.RootRole{KeyIDs: []string{"1", "2"}, Threshold: 2}},
+		{Name: "targets/a", RootRole: data.RootRole{KeyIDs: []string{"3"}, Threshold: 1}},
+		{Name: "targets/c", RootRole: data.RootRole{KeyIDs: []string{"4"}, Threshold: 1}},
+	}
+
+	var b bytes.Buffer
+	prettyPrintRolesCompact(roles, earliestDelegationExpiries(details), &b)
+	assert.Equal(t, "targets/b\t2\t2\t2016-03-11T12:00:00Z\n"+
+		"targets/a\t1\t1\t2016-03-04T12:00:00Z\n"+
+		"targets/c\t1\t1\t-\n", b.String())
+}
+
+// The details of a delegation include the algorithm, type and expiry of each
+// key (if the key is a certificate), and whether a signing key is present.
+func TestPrettyPrintDelegationInfo(t *testing.T) {
+	cert, _, err := generateValidTestCert()
+	assert.NoError(t, err)
+	expiry := cert.NotAfter
+	expiredCert, _, err := generateExpiredTestCert()
+	assert.NoError(t, err)
+	expired := expiredCert.NotAfter
+
+	info := client.DelegationDetail{
+		Name:      "targets/bee",
+		Threshold: 1,
+		Paths:     []string{"honey", "comb"},
+		Keys: []client.DelegationKey{
+			{ID: "111", Algorithm: data.ECDSAx509Key, KeyType: "ECDSA P-256", Expiry: &expiry},
+			{ID: "222", Algorithm: data.ED25519Key, CanSign: true},
+			{ID: "333", Algorithm: data.ECDSAx509Key, KeyType: "ECDSA P-256", Expiry: &expired},
+		},
+	}
+
+	var b bytes.Buffer
+	prettyPrintDelegationInfo(info, &b)
+	text, err := ioutil.ReadAll(&b)
+	assert.NoError(t, err)
+
+	lines := strings.Split(strings.TrimSpace(string(text)), "\n")
+	assert.Len(t, lines, 9)
+	assert.Equal(t, []string{"Role:", "targets/bee"}, strings.Fields(lines[0]))
+	assert.Equal(t, []string{"Threshold:", "1"}, strings.Fields(lines[1]))
+	assert.Equal(t, []string{"Paths:", "comb,honey"}, strings.Fields(lines[2]))
+	assert.Equal(t, "", lines[3])
+	assert.Equal(t, []string{"KEY", "ID", "ALGORITHM", "KEY", "TYPE", "EXPIRES", "IN", "CAN", "SIGN"},
+		strings.Fields(lines[4]))
+	certLine := strings.Fields(lines[6])
+	assert.Len(t, certLine, 7)
+	assert.Equal(t, []string{"111", data.ECDSAx509Key, "ECDSA", "P-256"}, certLine[:4])
+	assert.Equal(t, []string{"days", "no"}, certLine[5:])
+	assert.Equal(t, []string{"222", data.ED25519Key, "-", "-", "yes"}, strings.Fields(lines[7]))
+	assert.Equal(t, []string{"333", data.ECDSAx509Key, "ECDSA", "P-256", "expired", "no"}, strings.Fields(lines[8]))
+}
+
+// The path hash prefixes of a role are printed after its paths, marking those
+// that match an exact path
+func TestPrettyPrintRolePaths(t *testing.T) {
+	exact := data.PathHex("releases/v1")
+	assert.Equal(t, "comb,honey", prettyPrintRolePaths([]string{"honey", "comb"}, nil))
+	printed := prettyPrintRolePaths([]string{"honey"}, []string{exact, "ab"})
+	assert.True(t, strings.HasPrefix(printed, "honey,"))
+	assert.Contains(t, printed, "sha256:"+exact+" <exact path>")
+	assert.Contains(t, printed, "sha256:ab* <path hash prefix>")
+	assert.Equal(t, "sha256:"+exact+" <exact path>", prettyPrintRolePaths(nil, []string{exact}))
 }
 
 // If there are no certs in the cert store store, a message that there are no
@@ -315,3 +472,286 @@ func TestPrettyPrintSortedCerts(t *testing.T) {
 		assert.Equal(t, expected[i][1], strings.Join(splitted[2:], " "))
 	}
 }
+
+// The expiry report has the soonest of the key and metadata expiries of each
+// role, soonest first, and leaves out roles with nothing that expires
+func TestExpiryReport(t *testing.T) {
+	now := time.Now()
+	expiry := func(days int) *time.Time {
+		e := now.Add(time.Duration(days) * 24 * time.Hour)
+		return &e
+	}
+	details := []client.DelegationDetail{
+		{Name: "targets/keys", Expires: expiry(30), Keys: []client.DelegationKey{{Expiry: expiry(10)}, {Expiry: expiry(5)}}},
+		{Name: "targets/meta", Expires: expiry(2), Keys: []client.DelegationKey{{Expiry: expiry(10)}}},
+		{Name: "targets/expired", Expires: expiry(30), Keys: []client.DelegationKey{{Expiry: expiry(-1)}}},
+		{Name: "targets/never", Keys: []client.DelegationKey{{}}},
+	}
+
+	report := newExpiryReport(details, now)
+	assert.Len(t, report, 3)
+	for i, expected := range []struct {
+		role string
+		days int
+	}{{"targets/expired", -1}, {"targets/meta", 2}, {"targets/keys", 5}} {
+		assert.Equal(t, expected.role, report[i].Role)
+		assert.True(t, expiry(expected.days).Equal(report[i].Expiry))
+		assert.Equal(t, int64(expected.days*24*60*60), report[i].ExpiresInSeconds)
+	}
+
+	var b bytes.Buffer
+	prettyPrintExpiryReport(report, true, &b)
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	assert.Len(t, lines, len(report)+2)
+	assert.Equal(t, []string{"ROLE", "EXPIRES", "EXPIRES", "IN"}, strings.Fields(lines[0]))
+	assert.Equal(t, "targets/expired", strings.Fields(lines[2])[0])
+	assert.Equal(t, "-24h0m0s", strings.Fields(lines[2])[2])
+
+	// delegations that never expire are not reported as missing
+	b.Reset()
+	prettyPrintExpiryReport(newExpiryReport(details[3:], now), true, &b)
+	assert.Contains(t, b.String(), "No expiring delegation keys")
+	b.Reset()
+	prettyPrintExpiryReport(nil, false, &b)
+	assert.Contains(t, b.String(), "No delegations present")
+}
+
+// The threshold report counts the keys that are known and have not expired,
+// and flags the roles with fewer of them than their threshold
+func TestThresholdReport(t *testing.T) {
+	now := time.Now()
+	expired, valid := now.Add(-time.Hour), now.Add(time.Hour)
+	pubKey := data.NewPublicKey(data.ECDSAKey, []byte("key"))
+	details := []client.DelegationDetail{
+		{Name: "targets/quorum", Threshold: 2, Keys: []client.DelegationKey{
+			{PublicKey: pubKey, Expiry: &valid}, {PublicKey: pubKey}, {PublicKey: pubKey, Expiry: &expired}}},
+		{Name: "targets/expired", Threshold: 1, Keys: []client.DelegationKey{{PublicKey: pubKey, Expiry: &expired}}},
+		{Name: "targets/unknown", Threshold: 1, Keys: []client.DelegationKey{{ID: "missing"}}},
+	}
+
+	report := newThresholdReport(details, now)
+	assert.Equal(t, []roleThreshold{
+		{Role: "targets/expired", Threshold: 1, Keys: 1, ValidKeys: 0, BelowThreshold: true},
+		{Role: "targets/quorum", Threshold: 2, Keys: 3, ValidKeys: 2},
+		{Role: "targets/unknown", Threshold: 1, Keys: 1, ValidKeys: 0, BelowThreshold: true},
+	}, report)
+
+	var b bytes.Buffer
+	prettyPrintThresholdReport(report, &b)
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	assert.Len(t, lines, len(report)+2)
+	assert.Equal(t, []string{"ROLE", "THRESHOLD", "VALID", "KEYS", "KEYS", "STATUS"}, strings.Fields(lines[0]))
+	assert.Equal(t, []string{"targets/expired", "1", "0", "1", "BELOW", "THRESHOLD"}, strings.Fields(lines[2]))
+	assert.Equal(t, []string{"targets/quorum", "2", "2", "3", "ok"}, strings.Fields(lines[3]))
+
+	b.Reset()
+	prettyPrintThresholdReport(nil, &b)
+	assert.Contains(t, b.String(), "No delegations present")
+}
+
+// The delegation tree has every role below the role that delegates to it, and
+// is printed as a DOT graph with the thresholds on the edges
+func TestDelegationGraph(t *testing.T) {
+	roles := []*data.Role{
+		{Name: "targets/b", Threshold: 1, KeyIDs: []string{"key2"}},
+		{Name: "targets/a/stable", Threshold: 1, KeyIDs: []string{"key1"}, Paths: []string{"stable/"}},
+		{Name: "targets/a", Threshold: 2, KeyIDs: []string{"key1", "key2"}},
+	}
+
+	tree := newDelegationTree(roles)
+	assert.Equal(t, &delegationNode{Name: "targets", Children: []*delegationNode{
+		{Name: "targets/a", Threshold: 2, KeyIDs: []string{"key1", "key2"}, Children: []*delegationNode{
+			{Name: "targets/a/stable", Threshold: 1, KeyIDs: []string{"key1"}, Paths: []string{"stable/"},
+				Children: []*delegationNode{}},
+		}},
+		{Name: "targets/b", Threshold: 1, KeyIDs: []string{"key2"}, Children: []*delegationNode{}},
+	}}, tree)
+
+	var b bytes.Buffer
+	printDelegationGraph("docker.com/notary", tree, &b)
+	assert.Equal(t, `digraph "docker.com/notary" {
+	"targets";
+	"targets/a";
+	"targets" -> "targets/a" [label="2 of 2"];
+	"targets/a/stable";
+	"targets/a" -> "targets/a/stable" [label="1 of 1"];
+	"targets/b";
+	"targets" -> "targets/b" [label="1 of 1"];
+}
+`, b.String())
+
+	b.Reset()
+	printDelegationGraph("docker.com/notary", newDelegationTree(nil), &b)
+	assert.Equal(t, "digraph \"docker.com/notary\" {\n\t\"targets\";\n}\n", b.String())
+}
+
+// Path coverage lists the roles for each path, and flags unrelated roles
+// whose paths overlap, but not the narrowing of a path by a child role
+func TestPathCoverage(t *testing.T) {
+	roles := []*data.Role{
+		{Name: "targets/a", Paths: []string{"releases/"}},
+		{Name: "targets/a/stable", Paths: []string{"releases/stable/"}},
+		{Name: "targets/b", Paths: []string{"releases/stable/", "docs/"}},
+		{Name: "targets/c", Paths: []string{"docs/"}},
+		{Name: "targets/d", Paths: []string{"tools/"}},
+	}
+
+	coverage := newPathCoverage(roles)
+	assert.Equal(t, []pathCoverage{
+		{Path: "docs/", Roles: []string{"targets/b", "targets/c"}, Overlaps: []string{"targets/b", "targets/c"}},
+		{Path: "releases/", Roles: []string{"targets/a"},
+			Overlaps: []string{"targets/a", "targets/a/stable", "targets/b"}},
+		{Path: "releases/stable/", Roles: []string{"targets/a/stable", "targets/b"},
+			Overlaps: []string{"targets/a", "targets/a/stable", "targets/b"}},
+		{Path: "tools/", Roles: []string{"targets/d"}},
+	}, coverage)
+
+	// a child narrowing its parent's path is not an overlap
+	coverage = newPathCoverage(roles[:2])
+	assert.Len(t, coverage, 2)
+	for _, pc := range coverage {
+		assert.Empty(t, pc.Overlaps)
+	}
+
+	var b bytes.Buffer
+	prettyPrintPathCoverage(coverage, &b)
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	assert.Len(t, lines, len(coverage)+2)
+	assert.Equal(t, []string{"PATH", "ROLES", "OVERLAPS"}, strings.Fields(lines[0]))
+	assert.Equal(t, []string{"releases/", "targets/a"}, strings.Fields(lines[2]))
+}
+
+// A path is covered by a delegation only if the paths of the delegation and of
+// all the delegations above it cover it, and the paths that no delegation
+// covers are flagged
+func TestPathSigners(t *testing.T) {
+	roles := []*data.Role{
+		{Name: "targets/a", Paths: []string{"releases/"}},
+		{Name: "targets/a/stable", Paths: []string{"releases/stable/", "docs/"}},
+		{Name: "targets/b", Paths: []string{""}},
+		{Name: "targets/b/docs", Paths: []string{"docs/"}},
+		{Name: "targets/c", Paths: []string{"tools/"}},
+	}
+
+	signers := newPathSigners(roles, []string{"releases/stable/v1", "docs/index", "tools/cli", "misc"})
+	assert.Equal(t, []pathSigners{
+		{Path: "releases/stable/v1", Roles: []string{"targets/a", "targets/a/stable", "targets/b"}},
+		{Path: "docs/index", Roles: []string{"targets/b", "targets/b/docs"}},
+		{Path: "tools/cli", Roles: []string{"targets/b", "targets/c"}},
+		{Path: "misc", Roles: []string{"targets/b"}},
+	}, signers)
+
+	signers = newPathSigners(roles[:1], []string{"releases/v1", "docs/index"})
+	assert.Equal(t, []pathSigners{
+		{Path: "releases/v1", Roles: []string{"targets/a"}},
+		{Path: "docs/index", Roles: []string{}, TargetsOnly: true},
+	}, signers)
+
+	var b bytes.Buffer
+	prettyPrintPathSigners(signers, &b)
+	text := b.String()
+	assert.Contains(t, text, "NONE (only targets can sign)")
+	assert.Contains(t, text, "1 of 2 paths are not covered by any delegation")
+}
+
+// The history of a delegation is printed one version per row, describing what
+// changed in each version, or with a message if there is no history.
+func TestPrettyPrintDelegationHistory(t *testing.T) {
+	var b bytes.Buffer
+	prettyPrintDelegationHistory("targets/bee", nil, &b)
+	assert.Equal(t, "No downloaded versions of the metadata include targets/bee.", strings.TrimSpace(b.String()))
+
+	seen := time.Date(2016, time.March, 4, 5, 6, 7, 0, time.UTC)
+	changes := []client.DelegationChange{
+		{Version: 2, Seen: seen, Created: true, KeyIDs: []string{"111"}, Threshold: 1, Paths: []string{""},
+			AddedKeys: []string{"111"}, AddedPaths: []string{""}},
+		{Version: 5, Seen: seen, KeyIDs: []string{"222"}, Threshold: 2, Paths: []string{"honey"},
+			AddedKeys: []string{"222"}, RemovedKeys: []string{"111"}, AddedPaths: []string{"honey"},
+			RemovedPaths: []string{""}, PreviousThreshold: 1},
+		{Version: 9, Seen: seen, Removed: true},
+	}
+	b.Reset()
+	prettyPrintDelegationHistory("targets/bee", changes, &b)
+
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	assert.Len(t, lines, 5)
+	assert.Equal(t, []string{"VERSION", "SEEN", "CHANGES"}, strings.Fields(lines[0]))
+	assert.Equal(t, []string{"2", "2016-03-04T05:06:07Z",
+		`created with threshold 1; added keys 111; added paths "" <all paths>`}, splitTableRow(lines[2]))
+	assert.Equal(t, []string{"5", "2016-03-04T05:06:07Z",
+		`added keys 222; removed keys 111; added paths honey; removed paths "" <all paths>; threshold 1 -> 2`},
+		splitTableRow(lines[3]))
+	assert.Equal(t, []string{"9", "2016-03-04T05:06:07Z", "removed"}, splitTableRow(lines[4]))
+}
+
+func TestPrettyPrintDelegationDifferences(t *testing.T) {
+	var b bytes.Buffer
+	prettyPrintDelegationDifferences("gun1", "gun2", nil, &b)
+	assert.Equal(t, "The delegations of gun1 and gun2 are the same.", strings.TrimSpace(b.String()))
+
+	diffs := []client.DelegationDifference{
+		{Role: "targets/a", FirstThreshold: 1, SecondThreshold: 2, KeysOnlyInFirst: []string{"111"},
+			KeysOnlyInSecond: []string{"222"}, PathsOnlyInSecond: []string{""}},
+		{Role: "targets/b", OnlyInFirst: true},
+		{Role: "targets/c", OnlyInSecond: true},
+	}
+	b.Reset()
+	prettyPrintDelegationDifferences("gun1", "gun2", diffs, &b)
+
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	assert.Len(t, lines, 5)
+	assert.Equal(t, []string{"ROLE", "DIFFERENCES"}, strings.Fields(lines[0]))
+	assert.Equal(t, []string{"targets/a",
+		`threshold 1 in gun1, 2 in gun2; keys only in gun1: 111; keys only in gun2: 222; paths only in gun2: "" <all paths>`},
+		splitTableRow(lines[2]))
+	assert.Equal(t, []string{"targets/b", "only in gun1"}, splitTableRow(lines[3]))
+	assert.Equal(t, []string{"targets/c", "only in gun2"}, splitTableRow(lines[4]))
+}
+
+// The report of checked certificates has the key ID of each valid certificate,
+// and why the others were rejected
+func TestPrettyPrintCertValidations(t *testing.T) {
+	var b bytes.Buffer
+	prettyPrintCertValidations([]certValidation{
+		{File: "alice.pem", KeyID: "111"},
+		{File: "bob.pem", Error: "expired"},
+	}, &b)
+
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	assert.Len(t, lines, 4)
+	assert.Equal(t, []string{"FILE", "KEY ID", "STATUS"}, splitTableRow(lines[0]))
+	assert.Equal(t, []string{"alice.pem", "111", "ok"}, splitTableRow(lines[2]))
+	assert.Equal(t, []string{"bob.pem", "-", "expired"}, splitTableRow(lines[3]))
+}
+
+// The number of signatures of each published role is printed, with a warning
+// for the roles without enough signatures to meet their threshold
+func TestPrettyPrintSignatureCounts(t *testing.T) {
+	var out, warnings bytes.Buffer
+	prettyPrintSignatureCounts([]client.RoleSignatures{
+		{Role: "targets", Signatures: 1, Threshold: 1},
+		{Role: "targets/a", Signatures: 1, Threshold: 2},
+	}, &out, &warnings)
+	assert.Equal(t, "Signed targets with 1 signature(s), 1 required\n"+
+		"Signed targets/a with 1 signature(s), 2 required\n", out.String())
+	assert.Equal(t, "WARNING: targets/a does not have enough signatures to meet its threshold of 2\n",
+		warnings.String())
+}
+
+// A verification failure is shown as the chain of roles down to the failing
+// role, with the reason of the failure
+func TestPrettyPrintVerificationFailure(t *testing.T) {
+	var b bytes.Buffer
+	prettyPrintVerificationFailure(tufclient.ErrVerification{
+		Chain:  []string{"targets", "targets/a", "targets/a/b"},
+		Reason: tufclient.FailureExpired,
+	}, &b)
+
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	assert.Len(t, lines, 6)
+	assert.Equal(t, "Verification of targets/a/b failed:", lines[0])
+	assert.Equal(t, []string{"ROLE", "STATUS"}, strings.Fields(lines[1]))
+	assert.Equal(t, []string{"targets", "verified"}, splitTableRow(lines[3]))
+	assert.Equal(t, []string{"targets/a", "verified"}, splitTableRow(lines[4]))
+	assert.Equal(t, []string{"targets/a/b", "expired metadata"}, splitTableRow(lines[5]))
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/docker/notary/utils"
+	"github.com/spf13/viper"
+)
+
+// The revocation modes, which decide what happens if the revocation list
+// cannot be loaded
+const (
+	// revocationModeClosed fails the command
+	revocationModeClosed = "closed"
+	// revocationModeOpen only warns, and carries on without the list
+	revocationModeOpen = "open"
+)
+
+const (
+	// defaultRevocationListTTL is how long a downloaded revocation list is
+	// used for before it is downloaded again, unless revocation_list_ttl is set
+	defaultRevocationListTTL = time.Hour
+	// revocationListCachePrefix starts the name of the file, in the cache
+	// directory, where a downloaded revocation list is kept, which ends with
+	// the hash of its URL
+	revocationListCachePrefix = "revocation_list."
+	// maxRevocationListSize is the most that is downloaded for a revocation list
+	maxRevocationListSize = 10 << 20
+)
+
+// revocationMode is the configured revocation mode, closed by default
+func revocationMode(config *viper.Viper) string {
+	if mode := config.GetString("revocation_mode"); mode != "" {
+		return strings.ToLower(mode)
+	}
+	return revocationModeClosed
+}
+
+// revocationConfigProblems checks the revocation mode and the TTL of the
+// revocation list
+func revocationConfigProblems(config *viper.Viper) []error {
+	var problems []error
+	switch revocationMode(config) {
+	case revocationModeClosed, revocationModeOpen:
+	default:
+		problems = append(problems, fmt.Errorf("invalid revocation_mode %q: must be %s or %s",
+			config.GetString("revocation_mode"), revocationModeClosed, revocationModeOpen))
+	}
+	if ttl := config.GetString("revocation_list_ttl"); ttl != "" {
+		if d, err := time.ParseDuration(ttl); err != nil || d < 0 {
+			problems = append(problems, fmt.Errorf("invalid revocation_list_ttl %q: must be a positive duration such as 1h", ttl))
+		}
+	}
+	return problems
+}
+
+// revokedKeys returns the IDs of the keys on the configured revocation list,
+// or none if there is no revocation list.  If the list cannot be loaded, that
+// is an error in the closed revocation mode, and only a warning in the open
+// one.
+func revokedKeys(config *viper.Viper) ([]string, error) {
+	location := config.GetString("revocation_list")
+	if location == "" {
+		return nil, nil
+	}
+	if !strings.Contains(location, "://") {
+		location = utils.GetPathRelativeToConfig(config, "revocation_list")
+	}
+	keyIDs, err := loadRevocationList(config, location)
+	if err != nil {
+		if revocationMode(config) == revocationModeOpen {
+			warnf(os.Stderr, "could not load the revocation list %s, so revoked keys will not be rejected: %v", location, err)
+			return nil, nil
+		}
+		return nil, fmt.Errorf("could not load the revocation list %s: %v", location, err)
+	}
+	return keyIDs, nil
+}
+
+// loadRevocationList reads the revocation list from a file, or from an
+// https:// URL.  A downloaded list is cached, and only downloaded again once
+// the cached copy is older than the TTL of the revocation list.
+func loadRevocationList(config *viper.Viper, location string) ([]string, error) {
+	if !strings.Contains(location, "://") {
+		list, err := ioutil.ReadFile(location)
+		if err != nil {
+			return nil, err
+		}
+		return parseRevocationList(list), nil
+	}
+
+	ttl := defaultRevocationListTTL
+	if configured := config.GetString("revocation_list_ttl"); configured != "" {
+		ttl = config.GetDuration("revocation_list_ttl")
+	}
+	urlHash := sha256.Sum256([]byte(location))
+	cacheFile := filepath.Join(config.GetString("cache_dir"), revocationListCachePrefix+hex.EncodeToString(urlHash[:8]))
+	if info, err := os.Stat(cacheFile); err == nil && time.Since(info.ModTime()) < ttl {
+		if list, err := ioutil.ReadFile(cacheFile); err == nil {
+			return parseRevocationList(list), nil
+		}
+	}
+
+	list, err := downloadHTTPS(config, location, "revocation list", maxRevocationListSize)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(cacheFile), 0755); err == nil {
+		// the list can still be used if it can't be cached
+		ioutil.WriteFile(cacheFile, list, 0644)
+	}
+	return parseRevocationList(list), nil
+}
+
+// parseRevocationList parses a revocation list, which has the ID of a revoked
+// key on each line.  Empty lines, and everything after a #, are ignored.
+func parseRevocationList(list []byte) []string {
+	var keyIDs []string
+	scanner := bufio.NewScanner(bytes.NewReader(list))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		if keyID := strings.ToLower(strings.TrimSpace(line)); keyID != "" {
+			keyIDs = append(keyIDs, keyID)
+		}
+	}
+	return keyIDs
+}
@@ -0,0 +1,743 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/docker/notary"
+	notaryclient "github.com/docker/notary/client"
+	"github.com/docker/notary/passphrase"
+	"github.com/docker/notary/trustmanager"
+	"github.com/docker/notary/tuf/data"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var cmdTrustTemplate = usageTemplate{
+	Use:   "trust",
+	Short: "Operates on the trust data of a repository.",
+	Long:  `Operations on the TUF trust data of a trusted collection.`,
+}
+
+var cmdTrustRotateRootTemplate = usageTemplate{
+	Use:   "rotate-root [ GUN ] [ PEM filename ]",
+	Short: "Rotates the root key of a trusted collection.",
+	Long:  "Stages replacing the root key of the trusted collection identified by the Globally Unique Name with the encrypted root private key in the PEM file.  Unless --overlap-days is 0, the current root keys are kept in the root role alongside the new key for that many days, so that clients that have not yet seen the new key can still validate the new root.  The first publish after the overlap has ended removes the current root keys.  With --output-dir, the metadata that the next publish would send is also signed and written to that directory for offline review.",
+}
+
+var cmdTrustSignRequestTemplate = usageTemplate{
+	Use:   "sign-request [ GUN ] [ role ]",
+	Short: "Writes the bytes to sign for a role with an external tool.",
+	Long:  "Writes the canonical bytes of the metadata that the next publish would send for the targets or delegation role of the trusted collection identified by the Globally Unique Name, with the staged changes applied, to the file given with --out.  Once signed by an external tool, the signature can be added with `sign-apply`, and the next publish sends the metadata of the role with the detached signatures instead of signing it.  This is an online operation.",
+}
+
+var cmdTrustSignApplyTemplate = usageTemplate{
+	Use:   "sign-apply [ GUN ] [ role ]",
+	Short: "Adds a detached signature to the signing request of a role.",
+	Long:  "Adds the detached signature in the file given with --sig, made by the key given with --key over the bytes written by `sign-request`, to the metadata of the role of the trusted collection identified by the Globally Unique Name.  The signature is checked before it is staged for the next publish.",
+}
+
+var cmdTrustExportKeysTemplate = usageTemplate{
+	Use:   "export-keys [ GUN ] --public [ output directory ]",
+	Short: "Exports the public keys of every role of a trusted collection.",
+	Long:  "Writes the public keys of the root, targets, snapshot, timestamp and delegation roles of the trusted collection identified by the Globally Unique Name to the output directory, as PEM files named after the role and the key ID (e.g. targets/releases/<key ID>.pem), along with a manifest.json listing the key IDs of each role.  Only public keys can be exported, so --public is required.  This is an online operation.",
+}
+
+var cmdTrustCanonicalizeTemplate = usageTemplate{
+	Use:   "canonicalize [ GUN ] [ role ]",
+	Short: "Prints the canonical bytes a role's metadata is signed over.",
+	Long:  "Writes the canonical JSON of the signed part of the latest metadata of the role of the trusted collection identified by the Globally Unique Name to stdout, without the signatures and exactly as it is signed, so that the signatures can be checked or reproduced by other tools.  The staged changes are not included.  This is an online operation.",
+}
+
+var cmdTrustSignOfflineTemplate = usageTemplate{
+	Use:   "sign-offline [ GUN ]",
+	Short: "Signs the staged changes into a bundle to publish later.",
+	Long:  "Signs the metadata that the next publish would send for the trusted collection identified by the Globally Unique Name, with the staged changes applied and the local keys, and writes it as a bundle to the file given with --out, so that it can be sent to the server later with `publish-bundle`, from this or another host.  This is an offline operation, so the cached metadata needs to be up to date: run an online command such as `list` after every publish.  The staged changes are kept until the next publish.",
+}
+
+var cmdTrustResignSnapshotTemplate = usageTemplate{
+	Use:   "resign-snapshot [ GUN ]",
+	Short: "Signs the staged changes and a regenerated snapshot into a bundle.",
+	Long:  "Signs the staged changes like `sign-offline`, and regenerates the snapshot of the trusted collection identified by the Globally Unique Name from the metadata they result in and signs it with the local snapshot key, writing them as a bundle to the file given with --out for `publish-bundle`.  Fails if the snapshot key is not available locally, for instance because the snapshot is signed by the server.  This is an offline operation, so the cached metadata needs to be up to date.",
+}
+
+var cmdTrustPublishBundleTemplate = usageTemplate{
+	Use:   "publish-bundle [ GUN ] [ bundle ]",
+	Short: "Publishes a bundle of metadata signed by sign-offline.",
+	Long:  "Sends the metadata in a bundle written by `sign-offline` to the remote trusted collection identified by the Globally Unique Name, once the signatures, expiry and version of the metadata of every role in it have been checked.  No keys are needed to publish a bundle.",
+}
+
+var cmdTrustCloneTemplate = usageTemplate{
+	Use:   "clone [ source GUN ] [ destination GUN ]",
+	Short: "Stages the delegations of one trusted collection on another.",
+	Long:  "Stages delegation roles with the names, thresholds, paths and expiry of all the delegation roles of the trusted collection identified by the source Globally Unique Name on the one identified by the destination Globally Unique Name, which should have been initialized, for its next publish.  The roles get the same keys as in the source collection, which only their owners can sign with, unless --regenerate-keys is given, in which case a new key is generated for every key of every role, with a self-signed certificate.  This is an online operation.",
+}
+
+var cmdTrustSignaturesTemplate = usageTemplate{
+	Use:   "signatures [ GUN ]",
+	Short: "Shows which keys signed the metadata of each role.",
+	Long:  "Prints, for every role of the trusted collection identified by the Globally Unique Name that has metadata, the IDs of the keys in the signatures of the metadata, and whether each signature verifies against the keys of the role.  Signatures by keys that the collection lists for other roles only, and by keys it does not list at all, are flagged.  This is an online operation.",
+}
+
+var cmdTrustCanonCheckTemplate = usageTemplate{
+	Use:   "canon-check [ GUN ] [ role ]",
+	Short: "Checks that a role's metadata is canonical JSON.",
+	Long:  "Re-canonicalizes the signed part of the latest metadata of the role of the trusted collection identified by the Globally Unique Name, exactly as the server sent it, and checks every signature on it over both the canonical JSON that notary verifies signatures over and the bytes as sent.  Any difference is flagged, with where the metadata as sent first differs from the canonical JSON, to help diagnose why other TUF implementations fail to verify metadata that notary accepts, or the other way around.  The command fails if there is a discrepancy.  This is an online operation.",
+}
+
+var cmdTrustFsckTemplate = usageTemplate{
+	Use:   "fsck [ GUN ]",
+	Short: "Checks the local trust directory for inconsistencies.",
+	Long:  "Scans the trust directory, and the cache directory if it is separate, for temporary files left behind by interrupted writes, a key index that does not list exactly the keys in the directory, and cached metadata that is missing its root, cannot be parsed, or does not match the snapshot or timestamp that lists it.  The metadata of only the trusted collection identified by the Globally Unique Name is checked if one is given, and that of every cached collection otherwise.  With --repair, the problems that are safe to fix are fixed: the temporary files are removed and the key index is rebuilt.  Problems with the metadata are only reported, since it cannot be told whether the metadata or what lists it is wrong.  The command fails if any problem is left.  This is an offline operation.",
+}
+
+// fsckTempFileAge is how old a temporary file has to be for fsck to consider
+// it left behind, rather than being written by a command that is running
+const fsckTempFileAge = time.Minute
+
+// fsckProblem is an inconsistency found by fsck, with how to repair it if it
+// is safe to
+type fsckProblem struct {
+	path    string
+	problem string
+	// repair fixes the problem, and is nil if the problem is ambiguous
+	repair   func() error
+	repaired bool
+}
+
+// exportKeysManifest is the file written by `trust export-keys` that lists
+// the key IDs of each role
+const exportKeysManifest = "manifest.json"
+
+// exportedKeys is the manifest written by `trust export-keys`
+type exportedKeys struct {
+	GUN   string              `json:"gun"`
+	Roles map[string][]string `json:"roles"`
+}
+
+type trustCommander struct {
+	// these need to be set
+	configGetter func() (*viper.Viper, error)
+	retriever    passphrase.Retriever
+
+	// these are for command line parsing - no need to set
+	overlapDays int
+	forceYes    bool
+	outputDir   string
+	signOut     string
+	signSig     string
+	signKeyID   string
+	public      bool
+	regenerate  bool
+	outputJSON  bool
+	fsckRepair  bool
+}
+
+func (t *trustCommander) GetCommand() *cobra.Command {
+	cmd := cmdTrustTemplate.ToCommand(nil)
+
+	cmdRotateRoot := cmdTrustRotateRootTemplate.ToCommand(t.trustRotateRoot)
+	cmdRotateRoot.Flags().IntVar(&t.overlapDays, "overlap-days", 30,
+		"Number of days to keep trusting the current root keys alongside the new one. "+
+			"0 removes the current root keys immediately, locking out clients that have not seen the new key.")
+	cmdRotateRoot.Flags().BoolVarP(&t.forceYes, "yes", "y", false, "Answer yes to the rotation question (no confirmation)")
+	cmdRotateRoot.Flags().StringVar(&t.outputDir, "output-dir", "",
+		"Directory to also write the signed metadata that the next publish would send to, for review")
+	cmd.AddCommand(cmdRotateRoot)
+
+	cmdSignRequest := cmdTrustSignRequestTemplate.ToCommand(t.trustSignRequest)
+	cmdSignRequest.Flags().StringVar(&t.signOut, "out", "", "File to write the bytes to sign to")
+	cmd.AddCommand(cmdSignRequest)
+
+	cmdSignApply := cmdTrustSignApplyTemplate.ToCommand(t.trustSignApply)
+	cmdSignApply.Flags().StringVar(&t.signSig, "sig", "", "File with the detached signature")
+	cmdSignApply.Flags().StringVar(&t.signKeyID, "key", "", "ID of the key that made the signature")
+	cmd.AddCommand(cmdSignApply)
+
+	cmdExportKeys := cmdTrustExportKeysTemplate.ToCommand(t.trustExportKeys)
+	cmdExportKeys.Flags().BoolVar(&t.public, "public", false, "Export the public keys")
+	cmd.AddCommand(cmdExportKeys)
+
+	cmd.AddCommand(cmdTrustCanonicalizeTemplate.ToCommand(t.trustCanonicalize))
+
+	cmdSignOffline := cmdTrustSignOfflineTemplate.ToCommand(t.trustSignOffline)
+	cmdSignOffline.Flags().StringVar(&t.signOut, "out", "", "File to write the bundle to")
+	cmd.AddCommand(cmdSignOffline)
+
+	cmdResignSnapshot := cmdTrustResignSnapshotTemplate.ToCommand(t.trustResignSnapshot)
+	cmdResignSnapshot.Flags().StringVar(&t.signOut, "out", "", "File to write the bundle to")
+	cmd.AddCommand(cmdResignSnapshot)
+
+	cmd.AddCommand(cmdTrustPublishBundleTemplate.ToCommand(t.trustPublishBundle))
+
+	cmdClone := cmdTrustCloneTemplate.ToCommand(t.trustClone)
+	cmdClone.Flags().BoolVar(&t.regenerate, "regenerate-keys", false,
+		"Generate new keys for the delegation roles instead of using the keys of the source collection")
+	cmd.AddCommand(cmdClone)
+
+	cmdSignatures := cmdTrustSignaturesTemplate.ToCommand(t.trustSignatures)
+	cmdSignatures.Flags().BoolVar(&t.outputJSON, "json", false, "Print the signatures of each role as JSON")
+	cmd.AddCommand(cmdSignatures)
+
+	cmdCanonCheck := cmdTrustCanonCheckTemplate.ToCommand(t.trustCanonCheck)
+	cmdCanonCheck.Flags().BoolVar(&t.outputJSON, "json", false, "Print the result of the check as JSON")
+	cmd.AddCommand(cmdCanonCheck)
+
+	cmdFsck := cmdTrustFsckTemplate.ToCommand(t.trustFsck)
+	cmdFsck.Flags().BoolVar(&t.fsckRepair, "repair", false,
+		"Fix the problems that are safe to fix: remove leftover temporary files and rebuild the key index")
+	cmd.AddCommand(cmdFsck)
+
+	return cmd
+}
+
+// trustRotateRoot stages a rotation of the root key for a particular GUN
+func (t *trustCommander) trustRotateRoot(cmd *cobra.Command, args []string) error {
+	if len(args) != 2 {
+		cmd.Usage()
+		return fmt.Errorf("Must specify a GUN and the PEM file of the new root key")
+	}
+	if t.overlapDays < 0 {
+		return fmt.Errorf("The overlap must be a positive number of days, or 0 for no overlap")
+	}
+
+	config, err := t.configGetter()
+	if err != nil {
+		return err
+	}
+
+	gun := args[0]
+	// decrypt the key to make sure it can be used, and to find out its ID
+	pemBytes, privKey, err := readRootKey(args[1], t.retriever)
+	if err != nil {
+		return err
+	}
+	newKeyID := privKey.ID()
+	if t.outputDir != "" {
+		if err := os.MkdirAll(t.outputDir, 0700); err != nil {
+			return fmt.Errorf("Error creating the output directory: %v", err)
+		}
+	}
+	overlapEnd := time.Now().AddDate(0, 0, t.overlapDays)
+
+	rt, err := getTransport(config, gun, true)
+	if err != nil {
+		return err
+	}
+
+	nRepo, err := newRepository(config, gun, rt, t.retriever)
+	if err != nil {
+		return err
+	}
+
+	cmd.Printf("\nRotating the root key of %s will:\n", gun)
+	cmd.Printf("  1. Add the new root key %s to the root role.\n", newKeyID)
+	if t.overlapDays > 0 {
+		cmd.Println("  2. Keep the current root keys in the root role, so that clients that have")
+		cmd.Println("     not seen the new root key yet can still validate the new root.")
+		cmd.Println("  3. Sign the new root with both the current and the new root keys when it")
+		cmd.Printf("     is published with `notary publish %s`.\n", gun)
+		cmd.Printf("  4. Remove the current root keys from the root role on the first publish\n")
+		cmd.Printf("     after the overlap ends on %s.\n", overlapEnd.Format("2006-01-02"))
+	} else {
+		cmd.Println("  2. Remove the current root keys from the root role.")
+		cmd.Println("  3. Sign the new root with only the new root key when it is published")
+		cmd.Printf("     with `notary publish %s`.\n\n", gun)
+		warnf(cmd.Out(), "clients that have not seen the new root key will no longer be able")
+		warnf(cmd.Out(), "to validate this repository.")
+	}
+	cmd.Println("\nAre you sure you want to rotate the root key? (yes/no)")
+
+	// Ask for confirmation before rotating, unless -y is provided
+	if !t.forceYes {
+		if err := checkPromptAllowed(config); err != nil {
+			return err
+		}
+		confirmed := askConfirm()
+		if !confirmed {
+			return fmt.Errorf("Aborting action.")
+		}
+	}
+
+	// the key has to be in the keystore for the rotation to use it, so remove
+	// it again if the rotation fails, rather than leave an unused root key behind
+	imported, err := importRootKey(nRepo, pemBytes, newKeyID)
+	if err != nil {
+		return err
+	}
+
+	if t.overlapDays > 0 {
+		err = nRepo.RotateRootKeyWithOverlap(newKeyID, overlapEnd)
+	} else {
+		err = nRepo.RotateRootKey(newKeyID, false)
+	}
+	if err != nil {
+		if imported {
+			nRepo.CryptoService.RemoveKey(newKeyID)
+		}
+		return fmt.Errorf("Error rotating the root key: %v", err)
+	}
+
+	cmd.Printf("\nRotation of the root key of %s staged for next publish.\n", gun)
+
+	if t.outputDir != "" {
+		roles, err := nRepo.WritePendingMetadata(t.outputDir)
+		if err != nil {
+			return fmt.Errorf("Error writing the metadata to publish to %s: %v", t.outputDir, err)
+		}
+		cmd.Printf("Wrote the metadata of %s that would be published to %s\n", strings.Join(roles, ", "), t.outputDir)
+	}
+	return nil
+}
+
+// trustSignRequest writes the bytes to sign externally for a role
+func (t *trustCommander) trustSignRequest(cmd *cobra.Command, args []string) error {
+	if len(args) != 2 {
+		cmd.Usage()
+		return fmt.Errorf("Must specify a GUN and a role")
+	}
+	if t.signOut == "" {
+		cmd.Usage()
+		return fmt.Errorf("Must specify the file to write the bytes to sign to with --out")
+	}
+
+	nRepo, err := t.getRepository(args[0])
+	if err != nil {
+		return err
+	}
+	payload, err := nRepo.SigningRequest(args[1])
+	if err != nil {
+		return fmt.Errorf("Error making the signing request: %v", err)
+	}
+	if err := ioutil.WriteFile(t.signOut, payload, 0644); err != nil {
+		return fmt.Errorf("Error writing the bytes to sign: %v", err)
+	}
+	cmd.Printf("Wrote the bytes to sign for %s in %s to %s\n", args[1], args[0], t.signOut)
+	return nil
+}
+
+// trustSignApply adds a detached signature to the signing request of a role
+func (t *trustCommander) trustSignApply(cmd *cobra.Command, args []string) error {
+	if len(args) != 2 {
+		cmd.Usage()
+		return fmt.Errorf("Must specify a GUN and a role")
+	}
+	if t.signSig == "" || t.signKeyID == "" {
+		cmd.Usage()
+		return fmt.Errorf("Must specify the signature file with --sig and the ID of the signing key with --key")
+	}
+	sig, err := ioutil.ReadFile(t.signSig)
+	if err != nil {
+		return fmt.Errorf("Error reading the signature: %v", err)
+	}
+
+	nRepo, err := t.getRepository(args[0])
+	if err != nil {
+		return err
+	}
+	if err := nRepo.ApplySignature(args[1], t.signKeyID, sig); err != nil {
+		return fmt.Errorf("Error applying the signature: %v", err)
+	}
+	cmd.Printf("Signature of %s by key %s staged for next publish.\n", args[1], t.signKeyID)
+	return nil
+}
+
+// trustCanonicalize writes the canonical bytes of the metadata of a role to
+// stdout, as they are, with no trailing newline
+func (t *trustCommander) trustCanonicalize(cmd *cobra.Command, args []string) error {
+	if len(args) != 2 {
+		cmd.Usage()
+		return fmt.Errorf("Must specify a GUN and a role")
+	}
+
+	nRepo, err := t.getRepository(args[0])
+	if err != nil {
+		return err
+	}
+	canonical, err := nRepo.CanonicalMetadata(args[1])
+	if err != nil {
+		return fmt.Errorf("Error getting the metadata of %s: %v", args[1], err)
+	}
+	_, err = cmd.Out().Write(canonical)
+	return err
+}
+
+// trustSignatures prints the key IDs that signed the metadata of each role,
+// and whether each signature verifies
+func (t *trustCommander) trustSignatures(cmd *cobra.Command, args []string) error {
+	if len(args) != 1 {
+		cmd.Usage()
+		return fmt.Errorf("Must specify a GUN")
+	}
+
+	nRepo, err := t.getRepository(args[0])
+	if err != nil {
+		return err
+	}
+	checks, err := nRepo.CheckSignatures()
+	if err != nil {
+		return fmt.Errorf("Error checking the signatures of %s: %v", args[0], err)
+	}
+	if t.outputJSON {
+		jsonBytes, err := json.MarshalIndent(checks, "", "  ")
+		if err != nil {
+			return err
+		}
+		cmd.Println(string(jsonBytes))
+		return nil
+	}
+	prettyPrintSignatureChecks(checks, cmd.Out())
+	return nil
+}
+
+// trustCanonCheck checks that the metadata of a role is sent as canonical
+// JSON, and that its signatures verify the same way over what was sent as over
+// the canonical JSON
+func (t *trustCommander) trustCanonCheck(cmd *cobra.Command, args []string) error {
+	if len(args) != 2 {
+		cmd.Usage()
+		return fmt.Errorf("Must specify a GUN and a role")
+	}
+
+	nRepo, err := t.getRepository(args[0])
+	if err != nil {
+		return err
+	}
+	check, err := nRepo.CheckCanonicalization(args[1])
+	if err != nil {
+		return fmt.Errorf("Error checking the canonicalization of %s: %v", args[1], err)
+	}
+	if t.outputJSON {
+		jsonBytes, err := json.MarshalIndent(check, "", "  ")
+		if err != nil {
+			return err
+		}
+		cmd.Println(string(jsonBytes))
+	} else {
+		prettyPrintCanonicalizationCheck(check, cmd.Out())
+	}
+	if !check.Consistent() {
+		return fmt.Errorf("canonicalization discrepancy in the metadata of %s", args[1])
+	}
+	return nil
+}
+
+// trustFsck checks the trust directory for inconsistencies, repairing the
+// safe ones with --repair
+func (t *trustCommander) trustFsck(cmd *cobra.Command, args []string) error {
+	if len(args) > 1 {
+		cmd.Usage()
+		return fmt.Errorf("Must specify at most one GUN")
+	}
+	config, err := t.configGetter()
+	if err != nil {
+		return err
+	}
+	trustDir, cacheDir := config.GetString("trust_dir"), config.GetString("cache_dir")
+
+	dirs := []string{trustDir}
+	if filepath.Clean(cacheDir) != filepath.Clean(trustDir) {
+		dirs = append(dirs, cacheDir)
+	}
+	var problems []fsckProblem
+	for _, dir := range dirs {
+		tempFiles, err := leftoverTempFiles(dir, time.Now().Add(-fsckTempFileAge))
+		if err != nil {
+			return fmt.Errorf("Error scanning %s: %v", dir, err)
+		}
+		problems = append(problems, tempFiles...)
+	}
+
+	// the key index is only checked if it is kept
+	indexFile := filepath.Join(trustDir, notary.PrivDir, trustmanager.KeyIndexFile)
+	if _, err := os.Stat(indexFile); err == nil || config.GetBool("key_index") {
+		upToDate, err := trustmanager.KeyIndexUpToDate(trustDir)
+		if err != nil {
+			return fmt.Errorf("Error checking the key index: %v", err)
+		}
+		if !upToDate {
+			problems = append(problems, fsckProblem{
+				path:    indexFile,
+				problem: "the key index does not list exactly the keys in the trust directory",
+				repair:  func() error { return trustmanager.WriteKeyIndex(trustDir) },
+			})
+		}
+	}
+
+	guns := args
+	if len(guns) == 0 {
+		if guns, err = notaryclient.CachedGUNs(cacheDir); err != nil {
+			return fmt.Errorf("Error listing the cached trusted collections: %v", err)
+		}
+	}
+	for _, gun := range guns {
+		metadataProblems, err := notaryclient.CheckCachedMetadata(cacheDir, gun)
+		if err != nil {
+			return fmt.Errorf("Error checking the metadata of %s: %v", gun, err)
+		}
+		for _, p := range metadataProblems {
+			problems = append(problems, fsckProblem{path: p.Path, problem: p.Problem})
+		}
+	}
+
+	left := 0
+	for i := range problems {
+		if t.fsckRepair && problems[i].repair != nil {
+			if err := problems[i].repair(); err != nil {
+				return fmt.Errorf("Error repairing %s: %v", problems[i].path, err)
+			}
+			problems[i].repaired = true
+		} else {
+			left++
+		}
+	}
+	prettyPrintFsckProblems(problems, cmd.Out())
+	if left > 0 {
+		return fmt.Errorf("%d problem(s) left in the trust directory", left)
+	}
+	return nil
+}
+
+// leftoverTempFiles finds the temporary files under dir that were last written
+// to before the given time, each of which can be removed
+func leftoverTempFiles(dir string, before time.Time) ([]fsckProblem, error) {
+	var problems []fsckProblem
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == dir {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() || !trustmanager.IsTempFileName(info.Name()) || !info.ModTime().Before(before) {
+			return nil
+		}
+		problems = append(problems, fsckProblem{
+			path:    path,
+			problem: "temporary file left behind by an interrupted write",
+			repair:  func() error { return os.Remove(path) },
+		})
+		return nil
+	})
+	return problems, err
+}
+
+// trustClone stages the delegation roles of one GUN on another
+func (t *trustCommander) trustClone(cmd *cobra.Command, args []string) error {
+	if len(args) != 2 {
+		cmd.Usage()
+		return fmt.Errorf("Must specify a source and a destination GUN")
+	}
+
+	src, err := t.getRepository(args[0])
+	if err != nil {
+		return err
+	}
+	dst, err := t.getRepository(args[1])
+	if err != nil {
+		return err
+	}
+	roles, err := src.Clone(dst, t.regenerate)
+	if err != nil {
+		return fmt.Errorf("Error cloning the delegations of %s onto %s: %v", args[0], args[1], err)
+	}
+	if len(roles) == 0 {
+		cmd.Printf("%s has no delegations to clone.\n", args[0])
+		return nil
+	}
+	cmd.Printf("Staged %d delegations of %s on %s for next publish:\n", len(roles), args[0], args[1])
+	for _, role := range roles {
+		cmd.Printf("  %s\n", role)
+	}
+	return nil
+}
+
+// trustSignOffline signs the staged changes of a GUN into a bundle, without
+// contacting the server
+func (t *trustCommander) trustSignOffline(cmd *cobra.Command, args []string) error {
+	return t.writeBundle(cmd, args, (*notaryclient.NotaryRepository).SignOffline)
+}
+
+// trustResignSnapshot signs the staged changes of a GUN and a regenerated
+// snapshot into a bundle, without contacting the server
+func (t *trustCommander) trustResignSnapshot(cmd *cobra.Command, args []string) error {
+	return t.writeBundle(cmd, args, (*notaryclient.NotaryRepository).ResignSnapshot)
+}
+
+// writeBundle writes the bundle signed by the given method of the repository
+// of a GUN to the file given with --out
+func (t *trustCommander) writeBundle(cmd *cobra.Command, args []string,
+	sign func(*notaryclient.NotaryRepository) (*notaryclient.Bundle, error)) error {
+
+	if len(args) != 1 {
+		cmd.Usage()
+		return fmt.Errorf("Must specify a GUN")
+	}
+	if t.signOut == "" {
+		cmd.Usage()
+		return fmt.Errorf("Must specify the file to write the bundle to with --out")
+	}
+
+	config, err := t.configGetter()
+	if err != nil {
+		return err
+	}
+	nRepo, err := newRepository(config, args[0], nil, t.retriever)
+	if err != nil {
+		return err
+	}
+	bundle, err := sign(nRepo)
+	if err != nil {
+		return fmt.Errorf("Error signing the staged changes: %v", err)
+	}
+	bundleJSON, err := json.MarshalIndent(bundle, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(t.signOut, bundleJSON, 0644); err != nil {
+		return fmt.Errorf("Error writing the bundle: %v", err)
+	}
+	cmd.Printf("Wrote the signed metadata of %s for %s to %s\n", strings.Join(bundle.Roles(), ", "), args[0], t.signOut)
+	return nil
+}
+
+// trustPublishBundle sends a bundle written by sign-offline to the server
+func (t *trustCommander) trustPublishBundle(cmd *cobra.Command, args []string) error {
+	if len(args) != 2 {
+		cmd.Usage()
+		return fmt.Errorf("Must specify a GUN and a bundle")
+	}
+	bundleJSON, err := ioutil.ReadFile(args[1])
+	if err != nil {
+		return fmt.Errorf("Error reading the bundle: %v", err)
+	}
+	bundle := &notaryclient.Bundle{}
+	if err := json.Unmarshal(bundleJSON, bundle); err != nil {
+		return fmt.Errorf("Error reading the bundle: %v", err)
+	}
+
+	nRepo, err := t.getRepository(args[0])
+	if err != nil {
+		return err
+	}
+	cmd.Println("Pushing bundle to", args[0])
+	if err := nRepo.PublishBundle(bundle); err != nil {
+		return fmt.Errorf("Error publishing the bundle: %v", err)
+	}
+	prettyPrintSignatureCounts(nRepo.PublishedSignatures(), cmd.Out(), os.Stderr)
+	return nil
+}
+
+// trustExportKeys writes the public keys of every role of a GUN as PEM files,
+// along with a manifest of the key IDs of each role
+func (t *trustCommander) trustExportKeys(cmd *cobra.Command, args []string) error {
+	if len(args) != 2 {
+		cmd.Usage()
+		return fmt.Errorf("Must specify a GUN and an output directory")
+	}
+	if !t.public {
+		cmd.Usage()
+		return fmt.Errorf("Only public keys can be exported, with --public")
+	}
+	gun, outDir := args[0], args[1]
+
+	nRepo, err := t.getRepository(gun)
+	if err != nil {
+		return err
+	}
+	roleKeys, err := nRepo.ListRoleKeys()
+	if err != nil {
+		return err
+	}
+
+	// encode every key before writing anything, so that an unsupported key
+	// doesn't leave a partial export behind
+	pemKeys := make(map[string][]byte)
+	manifest := exportedKeys{GUN: gun, Roles: make(map[string][]string, len(roleKeys))}
+	for role, keys := range roleKeys {
+		keyIDs := make([]string, 0, len(keys))
+		for keyID, pubKey := range keys {
+			pemBytes, err := trustmanager.PublicKeyToPEM(pubKey)
+			if err != nil {
+				return fmt.Errorf("Error encoding key %s of %s: %v", keyID, role, err)
+			}
+			pemKeys[filepath.Join(filepath.FromSlash(role), keyID+".pem")] = pemBytes
+			keyIDs = append(keyIDs, keyID)
+		}
+		sort.Strings(keyIDs)
+		manifest.Roles[role] = keyIDs
+	}
+	manifestBytes, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	for keyPath, pemBytes := range pemKeys {
+		fullPath := filepath.Join(outDir, keyPath)
+		if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+			return fmt.Errorf("Error creating the output directory: %v", err)
+		}
+		if err := ioutil.WriteFile(fullPath, pemBytes, 0644); err != nil {
+			return fmt.Errorf("Error writing the public key: %v", err)
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(outDir, exportKeysManifest), manifestBytes, 0644); err != nil {
+		return fmt.Errorf("Error writing the manifest: %v", err)
+	}
+	cmd.Printf("Exported %d public keys of %d roles of %s to %s\n", len(pemKeys), len(roleKeys), gun, outDir)
+	return nil
+}
+
+// getRepository gets the notary repository of a GUN, as configured
+func (t *trustCommander) getRepository(gun string) (*notaryclient.NotaryRepository, error) {
+	config, err := t.configGetter()
+	if err != nil {
+		return nil, err
+	}
+	rt, err := getTransport(config, gun, true)
+	if err != nil {
+		return nil, err
+	}
+	return newRepository(config, gun, rt, t.retriever)
+}
+
+// readRootKey reads an encrypted root private key from a PEM file, and
+// decrypts it to check that it can be used as a root key
+func readRootKey(path string, retriever passphrase.Retriever) ([]byte, data.PrivateKey, error) {
+	pemBytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Error reading input file: %v", err)
+	}
+	privKey, _, err := trustmanager.GetPasswdDecryptBytes(
+		retriever, pemBytes, "", "imported "+data.CanonicalRootRole)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Error reading the root key: %v", err)
+	}
+	// the root key has to be able to sign the x509 certificate of the root role
+	switch privKey.Algorithm() {
+	case data.ECDSAKey, data.RSAKey:
+	default:
+		return nil, nil, fmt.Errorf("Root keys must be ECDSA or RSA keys, not %s keys", privKey.Algorithm())
+	}
+	return pemBytes, privKey, nil
+}
+
+// importRootKey imports the root key with the given ID into the key stores
+// of the repository, unless it is already in one of them, and returns whether
+// it was imported
+func importRootKey(nRepo *notaryclient.NotaryRepository, pemBytes []byte, keyID string) (bool, error) {
+	if nRepo.CryptoService.GetKey(keyID) != nil {
+		return false, nil
+	}
+	if err := nRepo.CryptoService.ImportRootKey(bytes.NewReader(pemBytes)); err != nil {
+		return false, fmt.Errorf("Error importing the root key: %v", err)
+	}
+	return true, nil
+}
@@ -2,18 +2,26 @@ package main
 
 import (
 	"bufio"
-	"crypto/sha256"
+	"bytes"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"math"
 	"net"
 	"net/http"
 	"net/url"
 	"os"
+	"os/exec"
+	"runtime"
+	"sort"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
-	"crypto/subtle"
-
 	"github.com/Sirupsen/logrus"
 	"github.com/docker/distribution/registry/client/auth"
 	"github.com/docker/distribution/registry/client/transport"
@@ -69,10 +77,16 @@ var cmdTufStatusTemplate = usageTemplate{
 	Long:  "Displays status of unpublished changes to the local trusted collection identified by the Globally Unique Name.",
 }
 
+var cmdTufDeleteTemplate = usageTemplate{
+	Use:   "delete [ GUN ]",
+	Short: "Deletes all local trust data and keys for a trusted collection.",
+	Long:  "Deletes the local trust data and private keys of the trusted collection identified by the Globally Unique Name. Root keys are kept, since they may be used by other collections.  With --remote, the trust data is also deleted from the remote trust server.",
+}
+
 var cmdTufVerifyTemplate = usageTemplate{
 	Use:   "verify [ GUN ] <target>",
 	Short: "Verifies if the content is included in the remote trusted collection",
-	Long:  "Verifies if the data passed in STDIN is included in the remote trusted collection identified by the Global Unique Name.",
+	Long:  "Verifies if the data passed in STDIN is included in the remote trusted collection identified by the Global Unique Name.  With --trusted-roles, the target also has to be signed by one of the given roles.",
 }
 
 type tufCommander struct {
@@ -81,23 +95,128 @@ type tufCommander struct {
 	retriever    passphrase.Retriever
 
 	// these are for command line parsing - no need to set
-	roles []string
+	roles           []string
+	onlyRoles       []string
+	trustedRoles    []string
+	signingKey      string
+	signWith        []string
+	postPublishHook string
+	rootKey         string
+	deleteRemote    bool
+	keepKeys        bool
+	forceYes        bool
+	noCache         bool
+	asOf            string
+	outputJSON      bool
+	custom          customMetadata
+	output          outputFile
+}
+
+// customJSONPrefix marks the values given with --custom that are JSON, rather
+// than strings
+const customJSONPrefix = "json:"
+
+// customMetadata is the custom metadata of a target, given with --custom as
+// KEY=VALUE once for each key.  A value is a string, unless it starts with
+// json: in which case the rest of it has to be valid JSON.
+type customMetadata map[string]json.RawMessage
+
+func (c customMetadata) String() string {
+	if len(c) == 0 {
+		return ""
+	}
+	custom, err := json.Marshal(map[string]json.RawMessage(c))
+	if err != nil {
+		return ""
+	}
+	return string(custom)
+}
+
+func (c customMetadata) Set(value string) error {
+	parts := strings.SplitN(value, "=", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return fmt.Errorf("custom metadata has to be given as KEY=VALUE, not %q", value)
+	}
+	key, val := parts[0], parts[1]
+	if _, ok := c[key]; ok {
+		return fmt.Errorf("the custom metadata %s is given more than once", key)
+	}
+	if strings.HasPrefix(val, customJSONPrefix) {
+		var compacted bytes.Buffer
+		if err := json.Compact(&compacted, []byte(strings.TrimPrefix(val, customJSONPrefix))); err != nil {
+			return fmt.Errorf("the value of the custom metadata %s is not valid JSON: %v", key, err)
+		}
+		c[key] = compacted.Bytes()
+		return nil
+	}
+	raw, err := json.Marshal(val)
+	if err != nil {
+		return err
+	}
+	c[key] = raw
+	return nil
+}
+
+func (c customMetadata) Type() string {
+	return "KEY=VALUE"
 }
 
 func (t *tufCommander) AddToCommand(cmd *cobra.Command) {
-	cmd.AddCommand(cmdTufInitTemplate.ToCommand(t.tufInit))
+	cmdTufInit := cmdTufInitTemplate.ToCommand(t.tufInit)
+	cmdTufInit.Flags().StringVar(&t.rootKey, "root-key", "",
+		"PEM file of an encrypted root private key to initialize the repository with, instead of an existing or new key")
+	cmd.AddCommand(cmdTufInit)
 	cmd.AddCommand(cmdTufStatusTemplate.ToCommand(t.tufStatus))
-	cmd.AddCommand(cmdTufPublishTemplate.ToCommand(t.tufPublish))
-	cmd.AddCommand(cmdTufLookupTemplate.ToCommand(t.tufLookup))
-	cmd.AddCommand(cmdTufVerifyTemplate.ToCommand(t.tufVerify))
+
+	cmdTufPublish := cmdTufPublishTemplate.ToCommand(t.tufPublish)
+	cmdTufPublish.Flags().StringVar(&t.signingKey, "signing-key", "",
+		"ID, ID prefix or alias of the key to sign the targets and delegation roles being published with, instead of every available key for each role")
+	cmdTufPublish.Flags().StringSliceVar(&t.signWith, "sign-with", nil,
+		"ID, ID prefix or alias of a key to sign the targets and delegation roles being published with, instead of every available key for each role. "+
+			"Can be given several times to meet a threshold above 1")
+	cmdTufPublish.Flags().StringVar(&t.postPublishHook, "post-publish-hook", "",
+		"Command to run after a successful publish, overriding post_publish_hook in the config")
+	cmd.AddCommand(cmdTufPublish)
+
+	cmdTufLookup := cmdTufLookupTemplate.ToCommand(t.tufLookup)
+	t.addOnlyRolesFlag(cmdTufLookup)
+	t.addNoCacheFlag(cmdTufLookup)
+	t.addAsOfFlag(cmdTufLookup)
+	cmd.AddCommand(cmdTufLookup)
+
+	cmdTufVerify := cmdTufVerifyTemplate.ToCommand(t.tufVerify)
+	t.addOnlyRolesFlag(cmdTufVerify)
+	t.addNoCacheFlag(cmdTufVerify)
+	t.addAsOfFlag(cmdTufVerify)
+	cmdTufVerify.Flags().StringSliceVar(&t.trustedRoles, "trusted-roles", nil,
+		"Only trust the target if it is signed by one of these roles, e.g. targets/releases,targets/qa")
+	cmd.AddCommand(cmdTufVerify)
+
+	cmdTufDelete := cmdTufDeleteTemplate.ToCommand(t.tufDelete)
+	cmdTufDelete.Flags().BoolVar(&t.deleteRemote, "remote", false,
+		"Also delete the trust data from the remote trust server")
+	cmdTufDelete.Flags().BoolVar(&t.keepKeys, "keep-keys", false,
+		"Keep the private keys of the trusted collection, and only delete its trust data")
+	cmdTufDelete.Flags().BoolVarP(&t.forceYes, "yes", "y", false, "Answer yes to the deletion question (no confirmation)")
+	cmd.AddCommand(cmdTufDelete)
 
 	cmdTufList := cmdTufListTemplate.ToCommand(t.tufList)
 	cmdTufList.Flags().StringSliceVarP(
 		&t.roles, "roles", "r", nil, "Delegation roles to list targets for (will shadow targets role)")
+	t.addOnlyRolesFlag(cmdTufList)
+	t.addNoCacheFlag(cmdTufList)
+	t.addAsOfFlag(cmdTufList)
+	cmdTufList.Flags().BoolVar(&t.outputJSON, "json", false,
+		"Print the targets as JSON, along with their custom metadata")
+	t.output.addFlags(cmdTufList)
 	cmd.AddCommand(cmdTufList)
 
 	cmdTufAdd := cmdTufAddTemplate.ToCommand(t.tufAdd)
 	cmdTufAdd.Flags().StringSliceVarP(&t.roles, "roles", "r", nil, "Delegation roles to add this target to")
+	t.custom = customMetadata{}
+	cmdTufAdd.Flags().Var(t.custom, "custom",
+		"Custom metadata to attach to the target, as KEY=VALUE.  The value is a string, or JSON if it starts with "+
+			customJSONPrefix+".  Can be given once for each key")
 	cmd.AddCommand(cmdTufAdd)
 
 	cmdTufRemove := cmdTufRemoveTemplate.ToCommand(t.tufRemove)
@@ -121,8 +240,7 @@ func (t *tufCommander) tufAdd(cmd *cobra.Command, args []string) error {
 
 	// no online operations are performed by add so the transport argument
 	// should be nil
-	nRepo, err := notaryclient.NewNotaryRepository(
-		config.GetString("trust_dir"), gun, getRemoteTrustServer(config), nil, t.retriever)
+	nRepo, err := newRepository(config, gun, nil, t.retriever)
 	if err != nil {
 		return err
 	}
@@ -131,6 +249,11 @@ func (t *tufCommander) tufAdd(cmd *cobra.Command, args []string) error {
 	if err != nil {
 		return err
 	}
+	if len(t.custom) > 0 {
+		if target.Custom, err = json.Marshal(map[string]json.RawMessage(t.custom)); err != nil {
+			return err
+		}
+	}
 	// If roles is empty, we default to adding to targets
 	if err = nRepo.AddTarget(target, t.roles...); err != nil {
 		return err
@@ -158,12 +281,15 @@ func (t *tufCommander) tufInit(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
-	nRepo, err := notaryclient.NewNotaryRepository(
-		config.GetString("trust_dir"), gun, getRemoteTrustServer(config), rt, t.retriever)
+	nRepo, err := newRepository(config, gun, rt, t.retriever)
 	if err != nil {
 		return err
 	}
 
+	if t.rootKey != "" {
+		return t.tufInitWithRootKey(cmd, nRepo)
+	}
+
 	rootKeyList := nRepo.CryptoService.ListKeys(data.CanonicalRootRole)
 
 	var rootKeyID string
@@ -187,11 +313,82 @@ func (t *tufCommander) tufInit(cmd *cobra.Command, args []string) error {
 	return nil
 }
 
+// tufInitWithRootKey initializes the repository with the root key in the
+// --root-key file, which is checked before it is imported or any metadata is
+// created, and removed again if initialization fails
+func (t *tufCommander) tufInitWithRootKey(cmd *cobra.Command, nRepo *notaryclient.NotaryRepository) error {
+	pemBytes, privKey, err := readRootKey(t.rootKey, t.retriever)
+	if err != nil {
+		return err
+	}
+	rootKeyID := privKey.ID()
+	imported, err := importRootKey(nRepo, pemBytes, rootKeyID)
+	if err != nil {
+		return err
+	}
+	cmd.Printf("Using the root key from %s: %s\n", t.rootKey, rootKeyID)
+
+	if err := nRepo.Initialize(rootKeyID); err != nil {
+		if imported {
+			nRepo.CryptoService.RemoveKey(rootKeyID)
+		}
+		return err
+	}
+	return nil
+}
+
+func (t *tufCommander) addOnlyRolesFlag(cmd *cobra.Command) {
+	cmd.Flags().StringSliceVar(&t.onlyRoles, "only-roles", nil,
+		"Only fetch and verify these delegation roles, their ancestors and their descendants, skipping the other delegations")
+}
+
+func (t *tufCommander) addNoCacheFlag(cmd *cobra.Command) {
+	cmd.Flags().BoolVar(&t.noCache, "no-cache", false,
+		"Download and verify all the metadata from the server, even if the cached copy is still valid")
+}
+
+func (t *tufCommander) addAsOfFlag(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&t.asOf, "as-of", "",
+		"Check the metadata, certificates and delegations for expiry as of this time, given in RFC 3339 format "+
+			"(e.g. 2016-03-01T12:00:00Z), instead of now, to check whether the collection was validly trusted then")
+}
+
+// asOfTime parses the time given with --as-of, which is the zero time if it
+// was not given
+func (t *tufCommander) asOfTime() (time.Time, error) {
+	if t.asOf == "" {
+		return time.Time{}, nil
+	}
+	asOf, err := time.Parse(time.RFC3339, t.asOf)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("--as-of has to be a time in RFC 3339 format, such as 2016-03-01T12:00:00Z: %v", err)
+	}
+	return asOf, nil
+}
+
+// checkOnlyRoles checks that the roles given with --only-roles are delegation
+// roles
+func (t *tufCommander) checkOnlyRoles() error {
+	for _, role := range t.onlyRoles {
+		if !data.IsDelegation(role) {
+			return fmt.Errorf("--only-roles only takes delegation roles, not %s", role)
+		}
+	}
+	return nil
+}
+
 func (t *tufCommander) tufList(cmd *cobra.Command, args []string) error {
 	if len(args) < 1 {
 		cmd.Usage()
 		return fmt.Errorf("Must specify a GUN")
 	}
+	if err := t.checkOnlyRoles(); err != nil {
+		return err
+	}
+	asOf, err := t.asOfTime()
+	if err != nil {
+		return err
+	}
 	config, err := t.configGetter()
 	if err != nil {
 		return err
@@ -203,11 +400,14 @@ func (t *tufCommander) tufList(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
-	nRepo, err := notaryclient.NewNotaryRepository(
-		config.GetString("trust_dir"), gun, getRemoteTrustServer(config), rt, t.retriever)
+	nRepo, err := newRepository(config, gun, rt, t.retriever)
 	if err != nil {
 		return err
 	}
+	nRepo.OnlyRoles = t.onlyRoles
+	nRepo.NoCache = t.noCache
+	nRepo.StrictExpiry = config.GetDuration("strict_expiry")
+	nRepo.AsOf = asOf
 
 	// Retrieve the remote list of signed targets, prioritizing the passed-in list over targets
 	roles := append(t.roles, data.CanonicalTargetsRole)
@@ -216,8 +416,45 @@ func (t *tufCommander) tufList(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
-	prettyPrintTargets(targetList, cmd.Out())
-	return nil
+	out, closeOutput, err := t.output.open(cmd)
+	if err != nil {
+		return err
+	}
+	if t.outputJSON {
+		listJSON, err := json.MarshalIndent(newTargetsJSON(targetList), "", "  ")
+		if err != nil {
+			closeOutput()
+			return err
+		}
+		fmt.Fprintln(out, string(listJSON))
+	} else {
+		prettyPrintTargets(targetList, out)
+	}
+	return closeOutput()
+}
+
+// targetJSON is a target as printed by list --json
+type targetJSON struct {
+	Name   string            `json:"name"`
+	Hashes map[string]string `json:"hashes"`
+	Length int64             `json:"length"`
+	Role   string            `json:"role"`
+	Custom json.RawMessage   `json:"custom,omitempty"`
+}
+
+// newTargetsJSON converts the targets to be printed as JSON, with the hashes
+// in hex like the table does, sorted by name
+func newTargetsJSON(targets []*notaryclient.TargetWithRole) []targetJSON {
+	sort.Stable(targetsSorter(targets))
+	list := make([]targetJSON, 0, len(targets))
+	for _, t := range targets {
+		hashes := make(map[string]string, len(t.Hashes))
+		for algorithm, hash := range t.Hashes {
+			hashes[algorithm] = hex.EncodeToString(hash)
+		}
+		list = append(list, targetJSON{Name: t.Name, Hashes: hashes, Length: t.Length, Role: t.Role, Custom: t.Custom})
+	}
+	return list
 }
 
 func (t *tufCommander) tufLookup(cmd *cobra.Command, args []string) error {
@@ -225,6 +462,13 @@ func (t *tufCommander) tufLookup(cmd *cobra.Command, args []string) error {
 		cmd.Usage()
 		return fmt.Errorf("Must specify a GUN and target")
 	}
+	if err := t.checkOnlyRoles(); err != nil {
+		return err
+	}
+	asOf, err := t.asOfTime()
+	if err != nil {
+		return err
+	}
 	config, err := t.configGetter()
 	if err != nil {
 		return err
@@ -238,11 +482,14 @@ func (t *tufCommander) tufLookup(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
-	nRepo, err := notaryclient.NewNotaryRepository(
-		config.GetString("trust_dir"), gun, getRemoteTrustServer(config), rt, t.retriever)
+	nRepo, err := newRepository(config, gun, rt, t.retriever)
 	if err != nil {
 		return err
 	}
+	nRepo.OnlyRoles = t.onlyRoles
+	nRepo.NoCache = t.noCache
+	nRepo.StrictExpiry = config.GetDuration("strict_expiry")
+	nRepo.AsOf = asOf
 
 	target, err := nRepo.GetTargetByName(targetName)
 	if err != nil {
@@ -265,8 +512,7 @@ func (t *tufCommander) tufStatus(cmd *cobra.Command, args []string) error {
 	}
 	gun := args[0]
 
-	nRepo, err := notaryclient.NewNotaryRepository(
-		config.GetString("trust_dir"), gun, getRemoteTrustServer(config), nil, t.retriever)
+	nRepo, err := newRepository(config, gun, nil, t.retriever)
 	if err != nil {
 		return err
 	}
@@ -309,18 +555,159 @@ func (t *tufCommander) tufPublish(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
-	nRepo, err := notaryclient.NewNotaryRepository(
-		config.GetString("trust_dir"), gun, getRemoteTrustServer(config), rt, t.retriever)
+	nRepo, err := newRepository(config, gun, rt, t.retriever)
 	if err != nil {
 		return err
 	}
+	if config.GetString("metadata_history_limit") != "" {
+		nRepo.HistoryLimit = config.GetInt("metadata_history_limit")
+	}
 
-	if err = nRepo.Publish(); err != nil {
+	signingKeys := t.signWith
+	if t.signingKey != "" {
+		signingKeys = append(signingKeys, t.signingKey)
+	}
+	if len(signingKeys) > 0 {
+		signingKeys, err = resolveSigningKeys(config, t.retriever, signingKeys)
+		if err != nil {
+			return err
+		}
+		err = nRepo.PublishWithSigningKeys(signingKeys)
+	} else {
+		err = nRepo.Publish()
+	}
+	if err != nil {
 		return err
 	}
+	prettyPrintSignatureCounts(nRepo.PublishedSignatures(), cmd.Out(), os.Stderr)
+
+	hook := t.postPublishHook
+	if hook == "" {
+		hook = config.GetString("post_publish_hook")
+	}
+	if hook != "" {
+		runPostPublishHook(hook, gun, getRemoteTrustServer(config, gun), nRepo.PublishedRoles(),
+			cmd.Out(), os.Stderr)
+	}
 	return nil
 }
 
+// resolveSigningKeys finds the IDs of the keys given by ID, ID prefix or alias
+// to sign with
+func resolveSigningKeys(config *viper.Viper, retriever passphrase.Retriever, names []string) ([]string, error) {
+	ks, err := newKeyStores(config, retriever, true)
+	if err != nil {
+		return nil, err
+	}
+	keyIDs := make([]string, 0, len(names))
+	for _, name := range names {
+		keyID, err := resolveKeyID(name, ks)
+		if err != nil {
+			return nil, err
+		}
+		keyIDs = append(keyIDs, keyID)
+	}
+	return keyIDs, nil
+}
+
+func (t *tufCommander) tufDelete(cmd *cobra.Command, args []string) error {
+	if len(args) < 1 {
+		cmd.Usage()
+		return fmt.Errorf("Must specify a GUN")
+	}
+
+	config, err := t.configGetter()
+	if err != nil {
+		return err
+	}
+	gun := args[0]
+	trustDir := config.GetString("trust_dir")
+
+	// the local trust data can be deleted offline, so only get a transport if
+	// the remote trust data is also being deleted
+	var rt http.RoundTripper
+	if t.deleteRemote {
+		if rt, err = getTransport(config, gun, false); err != nil {
+			return err
+		}
+	}
+
+	nRepo, err := newRepository(config, gun, rt, t.retriever)
+	if err != nil {
+		return err
+	}
+
+	// List everything about to be deleted
+	cmd.Printf("Deleting %s will remove:\n\n", gun)
+	cmd.Printf("  - the unpublished changes and trusted certificates in %s\n", trustDir)
+	cmd.Printf("  - the cached metadata in %s\n", config.GetString("cache_dir"))
+	var keyIDs []string
+	if !t.keepKeys {
+		keyIDs = nRepo.ListRepoKeys()
+		for _, keyID := range keyIDs {
+			cmd.Printf("  - the private key %s\n", keyID)
+		}
+	}
+	if t.deleteRemote {
+		cmd.Printf("  - all of the trust data on the remote trust server %s\n", getRemoteTrustServer(config, gun))
+	}
+	cmd.Println("\nRoot keys are not removed, since they may be used by other collections.")
+	cmd.Println("\nAre you sure you want to delete this trusted collection? (yes/no)")
+
+	// Ask for confirmation before deleting, unless -y is provided
+	if !t.forceYes {
+		if err := checkPromptAllowed(config); err != nil {
+			return err
+		}
+		confirmed := askConfirm()
+		if !confirmed {
+			return fmt.Errorf("Aborting action.")
+		}
+	}
+
+	// Delete the remote trust data first, so that if it fails the local trust
+	// data is still around to retry with
+	if t.deleteRemote {
+		if err := nRepo.DeleteRemoteTrustData(); err != nil {
+			return err
+		}
+	}
+	if err := nRepo.DeleteTrustData(); err != nil {
+		return fmt.Errorf("Failed to delete trust data for %s: %v", gun, err)
+	}
+	if len(keyIDs) > 0 {
+		if err := nRepo.DeleteRepoKeys(); err != nil {
+			return fmt.Errorf("Failed to delete the keys of %s: %v", gun, err)
+		}
+	}
+
+	cmd.Printf("Deleted %s\n", gun)
+	return nil
+}
+
+// runPostPublishHook runs the hook command through the shell, describing the
+// publish in NOTARY_GUN, NOTARY_PUBLISHED_ROLES (comma separated) and
+// NOTARY_SERVER_URL.  The publish has already succeeded by the time the hook
+// runs, so a failing hook is only reported as a warning.
+func runPostPublishHook(hook, gun, serverURL string, roles []string, out, warnings io.Writer) {
+	var hookCmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		hookCmd = exec.Command("cmd", "/C", hook)
+	} else {
+		hookCmd = exec.Command("sh", "-c", hook)
+	}
+	hookCmd.Env = append(os.Environ(),
+		"NOTARY_GUN="+gun,
+		"NOTARY_PUBLISHED_ROLES="+strings.Join(roles, ","),
+		"NOTARY_SERVER_URL="+serverURL,
+	)
+	hookCmd.Stdout = out
+	hookCmd.Stderr = warnings
+	if err := hookCmd.Run(); err != nil {
+		warnf(warnings, "post-publish hook failed, but %s was already published: %v", gun, err)
+	}
+}
+
 func (t *tufCommander) tufRemove(cmd *cobra.Command, args []string) error {
 	if len(args) < 2 {
 		return fmt.Errorf("Must specify a GUN and target")
@@ -335,8 +722,7 @@ func (t *tufCommander) tufRemove(cmd *cobra.Command, args []string) error {
 
 	// no online operation are performed by remove so the transport argument
 	// should be nil.
-	repo, err := notaryclient.NewNotaryRepository(
-		config.GetString("trust_dir"), gun, getRemoteTrustServer(config), nil, t.retriever)
+	repo, err := newRepository(config, gun, nil, t.retriever)
 	if err != nil {
 		return err
 	}
@@ -355,6 +741,13 @@ func (t *tufCommander) tufVerify(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("Must specify a GUN and target")
 	}
 
+	if err := t.checkOnlyRoles(); err != nil {
+		return err
+	}
+	asOf, err := t.asOfTime()
+	if err != nil {
+		return err
+	}
 	config, err := t.configGetter()
 	if err != nil {
 		return err
@@ -374,36 +767,42 @@ func (t *tufCommander) tufVerify(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
-	nRepo, err := notaryclient.NewNotaryRepository(
-		config.GetString("trust_dir"), gun, getRemoteTrustServer(config), rt, t.retriever)
+	nRepo, err := newRepository(config, gun, rt, t.retriever)
 	if err != nil {
 		return err
 	}
-
-	target, err := nRepo.GetTargetByName(targetName)
-	if err != nil {
+	nRepo.OnlyRoles = t.onlyRoles
+	nRepo.NoCache = t.noCache
+	nRepo.StrictExpiry = config.GetDuration("strict_expiry")
+	nRepo.AsOf = asOf
+
+	if _, err := nRepo.VerifyTarget(gun, targetName, bytes.NewReader(payload), t.trustedRoles...); err != nil {
+		switch err.(type) {
+		case notaryclient.ErrTargetMismatch:
+			return fmt.Errorf("notary: data not present in the trusted collection")
+		case notaryclient.ErrUntrustedRole:
+			return fmt.Errorf("notary: %v", err)
+		}
 		return fmt.Errorf("error retrieving target by name:%s, error:%v", targetName, err)
 	}
-
-	// Create hasher and hash data
-	stdinHash := sha256.Sum256(payload)
-	serverHash := target.Hashes["sha256"]
-
-	if subtle.ConstantTimeCompare(stdinHash[:], serverHash) == 0 {
-		return fmt.Errorf("notary: data not present in the trusted collection")
-	}
 	_, _ = os.Stdout.Write(payload)
 	return nil
 }
 
 type passwordStore struct {
 	anonymous bool
+	// noPrompt makes the credentials empty instead of asking for them
+	noPrompt bool
 }
 
 func (ps passwordStore) Basic(u *url.URL) (string, string) {
 	if ps.anonymous {
 		return "", ""
 	}
+	if ps.noPrompt {
+		logrus.Errorf("not asking for the credentials of %s: %s", u.Host, passphrase.ErrPromptDisabled)
+		return "", ""
+	}
 
 	stdin := bufio.NewReader(os.Stdin)
 	fmt.Fprintf(os.Stdout, "Enter username: ")
@@ -437,6 +836,26 @@ func (ps passwordStore) Basic(u *url.URL) (string, string) {
 	return username, password
 }
 
+// newRepository makes a repository for a GUN that uses the given transport,
+// or none to work offline.  Every command builds its repositories here, so
+// that they all apply the same configured algorithms, limits, pins and
+// revoked keys
+func newRepository(config *viper.Viper, gun string, rt http.RoundTripper, retriever passphrase.Retriever) (*notaryclient.NotaryRepository, error) {
+	nRepo, err := notaryclient.NewNotaryRepositoryWithCache(
+		config.GetString("trust_dir"), config.GetString("cache_dir"), gun, getRemoteTrustServer(config, gun), rt, retriever)
+	if err != nil {
+		return nil, err
+	}
+	nRepo.AllowedAlgorithms = config.GetStringSlice("allowed_algorithms")
+	nRepo.MaxTimestampAge = config.GetDuration("max_timestamp_age")
+	nRepo.ServerKeyPins = serverKeyPins(config, gun)
+	nRepo.MaxMetadataSizes = maxMetadataSizes(config)
+	if nRepo.RevokedKeys, err = revokedKeys(config); err != nil {
+		return nil, err
+	}
+	return nRepo, nil
+}
+
 // getTransport returns an http.RoundTripper to be used for all http requests.
 // It correctly handles the auth challenge/credentials required to interact
 // with a notary server over both HTTP Basic Auth and the JWT auth implemented
@@ -447,16 +866,31 @@ func (ps passwordStore) Basic(u *url.URL) (string, string) {
 func getTransport(config *viper.Viper, gun string, readOnly bool) (http.RoundTripper, error) {
 	// Attempt to get a root CA from the config file. Nil is the host defaults.
 	rootCAFile := utils.GetPathRelativeToConfig(config, "remote_server.root_ca")
-	clientCert := utils.GetPathRelativeToConfig(config, "remote_server.tls_client_cert")
-	clientKey := utils.GetPathRelativeToConfig(config, "remote_server.tls_client_key")
+	insecureSkipVerify := skipTLSVerify(config, rootCAFile, os.Stderr)
 
-	insecureSkipVerify := false
-	if config.IsSet("remote_server.skipTLSVerify") {
-		insecureSkipVerify = config.GetBool("remote_server.skipTLSVerify")
+	base, dialer, timeout, err := newBaseTransport(config, insecureSkipVerify)
+	if err != nil {
+		return nil, err
 	}
+	trustServerURL := getRemoteTrustServer(config, gun)
+	return tokenAuth(trustServerURL, base, gun, readOnly, config.GetBool("no_prompt"), dialer, timeout)
+}
+
+// newBaseTransport sets up a transport with the TLS, proxy and connection
+// settings of the configuration, without any authentication, and returns it
+// along with the dialer it connects with and the timeout of each request made
+// through it
+func newBaseTransport(config *viper.Viper, insecureSkipVerify bool) (*http.Transport, *connectDialer, time.Duration, error) {
+	rootCAFile := utils.GetPathRelativeToConfig(config, "remote_server.root_ca")
+	clientCert := utils.GetPathRelativeToConfig(config, "remote_server.tls_client_cert")
+	clientKey := utils.GetPathRelativeToConfig(config, "remote_server.tls_client_key")
 
 	if clientCert == "" && clientKey != "" || clientCert != "" && clientKey == "" {
-		return nil, fmt.Errorf("either pass both client key and cert, or neither")
+		return nil, nil, 0, fmt.Errorf("either pass both client key and cert, or neither")
+	}
+	minTLSVersion, err := parseTLSVersion(config.GetString("remote_server.min_tls_version"))
+	if err != nil {
+		return nil, nil, 0, err
 	}
 
 	tlsConfig, err := tlsconfig.Client(tlsconfig.Options{
@@ -466,32 +900,67 @@ func getTransport(config *viper.Viper, gun string, readOnly bool) (http.RoundTri
 		KeyFile:            clientKey,
 	})
 	if err != nil {
-		return nil, fmt.Errorf("unable to configure TLS: %s", err.Error())
+		return nil, nil, 0, fmt.Errorf("unable to configure TLS: %s", err.Error())
+	}
+	tlsConfig.MinVersion = minTLSVersion
+	connectTimeout, err := parseTimeout(config, "remote_server.connect_timeout", defaultConnectTimeout)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	timeout, err := parseTimeout(config, "remote_server.timeout", 0)
+	if err != nil {
+		return nil, nil, 0, err
 	}
 
+	dialer := &connectDialer{dialer: &net.Dialer{
+		Timeout:   connectTimeout,
+		KeepAlive: 30 * time.Second,
+		DualStack: true,
+	}}
 	base := &http.Transport{
-		Proxy: http.ProxyFromEnvironment,
-		Dial: (&net.Dialer{
-			Timeout:   30 * time.Second,
-			KeepAlive: 30 * time.Second,
-			DualStack: true,
-		}).Dial,
+		Proxy:               http.ProxyFromEnvironment,
+		Dial:                dialer.Dial,
 		TLSHandshakeTimeout: 10 * time.Second,
 		TLSClientConfig:     tlsConfig,
 		DisableKeepAlives:   true,
 	}
-	trustServerURL := getRemoteTrustServer(config)
-	return tokenAuth(trustServerURL, base, gun, readOnly)
+	return base, dialer, timeout, nil
 }
 
+// skipTLSVerify returns whether the certificate of the remote trust server
+// should not be verified, printing a warning to the writer every time it is
+// not.  A root CA pins the server's certificate, so if one is set verification
+// is never skipped.
+func skipTLSVerify(config *viper.Viper, rootCAFile string, warnings io.Writer) bool {
+	if !config.GetBool("remote_server.skipTLSVerify") {
+		return false
+	}
+	if rootCAFile != "" {
+		warnf(warnings, "a root CA is configured, so TLS verification of the trust server will not be skipped")
+		return false
+	}
+	warnf(warnings, "TLS verification of the trust server is DISABLED.")
+	warnf(warnings, "The identity of the trust server cannot be verified, so this must only be used for development.")
+	return true
+}
+
+// tokenAuth checks that the trust server can be reached, and returns the
+// transport to use with it, or nil if it cannot be reached.  If the timeout
+// is not 0, every request through the transport has to complete within it,
+// and the dialer, if any, tells the requests that could not connect in time
+// from the ones that were too slow once connected.
+// With noPrompt, the credentials the server asks for are not asked for.
 func tokenAuth(trustServerURL string, baseTransport *http.Transport, gun string,
-	readOnly bool) (http.RoundTripper, error) {
+	readOnly, noPrompt bool, dialer *connectDialer, timeout time.Duration) (http.RoundTripper, error) {
 
 	// TODO(dmcgowan): add notary specific headers
 	authTransport := transport.NewTransport(baseTransport)
+	pingTimeout := timeout
+	if pingTimeout == 0 {
+		pingTimeout = defaultPingTimeout
+	}
 	pingClient := &http.Client{
-		Transport: authTransport,
-		Timeout:   5 * time.Second,
+		Transport: timeoutTransport{base: authTransport, timeout: pingTimeout, dialer: dialer},
 	}
 	endpoint, err := url.Parse(trustServerURL)
 	if err != nil {
@@ -510,6 +979,11 @@ func tokenAuth(trustServerURL string, baseTransport *http.Transport, gun string,
 		return nil, err
 	}
 	resp, err := pingClient.Do(req)
+	if err != nil && isTLSVersionError(err) {
+		// going offline would hide that the server can never be used
+		return nil, fmt.Errorf("the trust server %s does not support TLS %s or later, which is the minimum allowed (see --min-tls-version): %v",
+			trustServerURL, tlsVersionName(baseTransport.TLSClientConfig), err)
+	}
 	if err != nil {
 		logrus.Errorf("could not reach %s: %s", trustServerURL, err.Error())
 		logrus.Info("continuing in offline mode")
@@ -532,16 +1006,353 @@ func tokenAuth(trustServerURL string, baseTransport *http.Transport, gun string,
 		return nil, err
 	}
 
-	ps := passwordStore{anonymous: readOnly}
+	ps := passwordStore{anonymous: readOnly, noPrompt: noPrompt}
 	tokenHandler := auth.NewTokenHandler(authTransport, ps, gun, "push", "pull")
 	basicHandler := auth.NewBasicHandler(ps)
 	modifier := transport.RequestModifier(auth.NewAuthorizer(challengeManager, tokenHandler, basicHandler))
-	return transport.NewTransport(baseTransport, modifier), nil
+	rt := transport.NewTransport(baseTransport, modifier)
+	if timeout == 0 {
+		return rt, nil
+	}
+	return timeoutTransport{base: rt, timeout: timeout, dialer: dialer}, nil
+}
+
+// tlsVersions are the TLS versions that can be given as the minimum version to
+// use with the trust server
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+}
+
+// parseTLSVersion parses the minimum TLS version to use with the trust server,
+// which is TLS 1.2 if none is given
+func parseTLSVersion(version string) (uint16, error) {
+	if version == "" {
+		return tls.VersionTLS12, nil
+	}
+	v, ok := tlsVersions[version]
+	if !ok {
+		return 0, fmt.Errorf("invalid minimum TLS version %q: must be one of 1.0, 1.1 or 1.2", version)
+	}
+	return v, nil
+}
+
+// tlsVersionName is the name of the minimum TLS version of a TLS configuration
+func tlsVersionName(tlsConfig *tls.Config) string {
+	if tlsConfig != nil {
+		for name, v := range tlsVersions {
+			if v == tlsConfig.MinVersion {
+				return name
+			}
+		}
+	}
+	return "1.0"
+}
+
+// isTLSVersionError returns whether an error is the failure of a TLS handshake
+// because the client and server have no protocol version in common
+func isTLSVersionError(err error) bool {
+	msg := err.Error()
+	return strings.Contains(msg, "protocol version not supported") ||
+		strings.Contains(msg, "unsupported protocol version") ||
+		strings.Contains(msg, "no supported versions satisfy")
+}
+
+const (
+	// defaultConnectTimeout is how long connecting to the trust server can
+	// take, unless a connect timeout is configured
+	defaultConnectTimeout = 30 * time.Second
+	// defaultPingTimeout is how long checking that the trust server can be
+	// reached can take, unless a timeout is configured
+	defaultPingTimeout = 5 * time.Second
+)
+
+// parseTimeout parses the timeout set in the configuration under the key,
+// returning the default if none is set
+func parseTimeout(config *viper.Viper, key string, defaultTimeout time.Duration) (time.Duration, error) {
+	value := config.GetString(key)
+	if value == "" {
+		return defaultTimeout, nil
+	}
+	timeout, err := time.ParseDuration(value)
+	if err != nil || timeout < 0 {
+		return 0, fmt.Errorf("invalid %s %q: must be a positive duration such as 10s", key, value)
+	}
+	return timeout, nil
+}
+
+// errCouldNotConnect is returned when no connection to the trust server could
+// be made, as opposed to a request that timed out once connected
+type errCouldNotConnect struct {
+	host    string
+	timeout time.Duration
+	// err is why the connection failed, or nil if it only took too long
+	err error
+}
+
+func (err errCouldNotConnect) Error() string {
+	if netErr, ok := err.err.(net.Error); err.err == nil || (ok && netErr.Timeout()) {
+		return fmt.Sprintf("could not connect to %s within %s", err.host, err.timeout)
+	}
+	return fmt.Sprintf("could not connect to %s: %v", err.host, err.err)
+}
+
+// errRequestTimeout is returned when a request to the trust server did not
+// complete within the timeout, after connecting to it
+type errRequestTimeout struct {
+	url     string
+	timeout time.Duration
+}
+
+func (err errRequestTimeout) Error() string {
+	return fmt.Sprintf("the request to %s did not complete within %s (see --timeout)", err.url, err.timeout)
 }
 
-func getRemoteTrustServer(config *viper.Viper) string {
+// connectDialer dials with its dialer, reporting the connections that could
+// not be made, including the ones that took longer than the dialer's timeout,
+// as errCouldNotConnect.  It counts the connections it made, so that a request
+// that timed out can tell whether it ever got one.
+type connectDialer struct {
+	dialer    *net.Dialer
+	connected int64
+}
+
+// Dial connects to the address on the named network
+func (d *connectDialer) Dial(network, addr string) (net.Conn, error) {
+	conn, err := d.dialer.Dial(network, addr)
+	if err != nil {
+		return nil, errCouldNotConnect{host: addr, timeout: d.dialer.Timeout, err: err}
+	}
+	atomic.AddInt64(&d.connected, 1)
+	return conn, nil
+}
+
+// connections is how many connections the dialer has made so far
+func (d *connectDialer) connections() int64 {
+	if d == nil {
+		return 0
+	}
+	return atomic.LoadInt64(&d.connected)
+}
+
+// requestCanceler is a transport that can cancel the requests in flight
+type requestCanceler interface {
+	CancelRequest(req *http.Request)
+}
+
+// timeoutTransport limits how long each request, including reading the body
+// of the response, can take, by cancelling the requests of its base transport
+// that run out of time.  A request that times out before its dialer connected
+// fails with errCouldNotConnect, and one that times out later, or without a
+// dialer to tell, with errRequestTimeout.
+type timeoutTransport struct {
+	base    http.RoundTripper
+	timeout time.Duration
+	dialer  *connectDialer
+}
+
+func (t timeoutTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	canceler, ok := t.base.(requestCanceler)
+	if !ok {
+		return nil, fmt.Errorf("cannot time out the request to %s: its transport cannot cancel requests", req.URL)
+	}
+	connections := t.dialer.connections()
+	timedOut := new(int32)
+	timer := time.AfterFunc(t.timeout, func() {
+		atomic.StoreInt32(timedOut, 1)
+		canceler.CancelRequest(req)
+	})
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		timer.Stop()
+		if atomic.LoadInt32(timedOut) == 1 {
+			if t.dialer != nil && t.dialer.connections() == connections {
+				return nil, errCouldNotConnect{host: req.URL.Host, timeout: t.timeout}
+			}
+			return nil, errRequestTimeout{url: req.URL.String(), timeout: t.timeout}
+		}
+		return nil, err
+	}
+	resp.Body = &timeoutBody{ReadCloser: resp.Body, timer: timer, timedOut: timedOut,
+		err: errRequestTimeout{url: req.URL.String(), timeout: t.timeout}}
+	return resp, nil
+}
+
+// timeoutBody is the body of a response read within the timeout of its request
+type timeoutBody struct {
+	io.ReadCloser
+	timer    *time.Timer
+	timedOut *int32
+	err      errRequestTimeout
+}
+
+func (b *timeoutBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err != nil && err != io.EOF && atomic.LoadInt32(b.timedOut) == 1 {
+		return n, b.err
+	}
+	return n, err
+}
+
+func (b *timeoutBody) Close() error {
+	b.timer.Stop()
+	return b.ReadCloser.Close()
+}
+
+// getRemoteTrustServer is the URL of the trust server of a GUN: the one given
+// for the GUN in remote_server.gun_urls, if any, or else remote_server.url
+func getRemoteTrustServer(config *viper.Viper, gun string) string {
+	if gunURLs, err := parseGUNServerURLs(config); err == nil {
+		if gunURL, ok := gunURLs[strings.ToLower(gun)]; ok {
+			return gunURL
+		}
+	}
 	if configRemote := config.GetString("remote_server.url"); configRemote != "" {
 		return configRemote
 	}
 	return defaultServerURL
 }
+
+// parseGUNServerURLs reads the remote_server.gun_urls configuration, which
+// maps GUNs to the URLs of the trust servers they are on.  Like in
+// parseServerKeyPins, the GUNs are lowercased.
+func parseGUNServerURLs(config *viper.Viper) (map[string]string, error) {
+	gunURLs := make(map[string]string)
+	raw := config.Get("remote_server.gun_urls")
+	if raw == nil {
+		return gunURLs, nil
+	}
+	invalid := fmt.Errorf("invalid remote_server.gun_urls: must map each GUN to the URL of its trust server")
+	encoded, err := json.Marshal(raw)
+	if err != nil {
+		return nil, invalid
+	}
+	if err := json.Unmarshal(encoded, &gunURLs); err != nil {
+		return nil, invalid
+	}
+	normalized := make(map[string]string, len(gunURLs))
+	for gun, gunURL := range gunURLs {
+		if gunURL == "" {
+			return nil, invalid
+		}
+		normalizedGUN, err := normalizeGUN(gun)
+		if err != nil {
+			return nil, fmt.Errorf("invalid remote_server.gun_urls: %v", err)
+		}
+		normalized[strings.ToLower(normalizedGUN)] = gunURL
+	}
+	return normalized, nil
+}
+
+// parseServerKeyPins reads the server_key_pins configuration, which maps each
+// GUN to the IDs of the server keys pinned for its timestamp and snapshot
+// roles, by role.  The GUNs are lowercased, since viper may lowercase the keys
+// of maps in the configuration, so they are matched regardless of case.
+func parseServerKeyPins(config *viper.Viper) (map[string]map[string][]string, error) {
+	pins := make(map[string]map[string][]string)
+	raw := config.Get("server_key_pins")
+	if raw == nil {
+		return pins, nil
+	}
+	invalid := fmt.Errorf(
+		"invalid server_key_pins: must map each GUN to the key IDs pinned for its %s and %s roles",
+		data.CanonicalTimestampRole, data.CanonicalSnapshotRole)
+	encoded, err := json.Marshal(raw)
+	if err != nil {
+		return nil, invalid
+	}
+	if err := json.Unmarshal(encoded, &pins); err != nil {
+		return nil, invalid
+	}
+	normalized := make(map[string]map[string][]string, len(pins))
+	for gun, rolePins := range pins {
+		for role, keyIDs := range rolePins {
+			if role != data.CanonicalTimestampRole && role != data.CanonicalSnapshotRole || len(keyIDs) == 0 {
+				return nil, invalid
+			}
+		}
+		normalizedGUN, err := normalizeGUN(gun)
+		if err != nil {
+			return nil, fmt.Errorf("invalid server_key_pins: %v", err)
+		}
+		normalized[strings.ToLower(normalizedGUN)] = rolePins
+	}
+	return normalized, nil
+}
+
+// sizeUnits are the units that metadata sizes can be given in
+var sizeUnits = []struct {
+	suffix string
+	size   int64
+}{
+	{"GiB", 1 << 30},
+	{"MiB", 1 << 20},
+	{"KiB", 1 << 10},
+	{"B", 1},
+}
+
+// parseSize parses a size in bytes, which can be given in KiB, MiB or GiB
+func parseSize(size string) (int64, bool) {
+	unit := int64(1)
+	for _, u := range sizeUnits {
+		if strings.HasSuffix(size, u.suffix) {
+			size, unit = strings.TrimSpace(strings.TrimSuffix(size, u.suffix)), u.size
+			break
+		}
+	}
+	n, err := strconv.ParseInt(size, 10, 64)
+	if err != nil || n <= 0 || n > math.MaxInt64/unit {
+		return 0, false
+	}
+	return n * unit, true
+}
+
+// parseMaxMetadataSizes reads the max_metadata_size configuration, which maps
+// role names to the largest size of their metadata to download
+func parseMaxMetadataSizes(config *viper.Viper) (map[string]int64, error) {
+	sizes := make(map[string]int64)
+	raw := config.Get("max_metadata_size")
+	if raw == nil {
+		return sizes, nil
+	}
+	invalid := fmt.Errorf(
+		"invalid max_metadata_size: must map role names to sizes in bytes, such as 1048576 or 1MiB")
+	encoded, err := json.Marshal(raw)
+	if err != nil {
+		return nil, invalid
+	}
+	var values map[string]interface{}
+	if err := json.Unmarshal(encoded, &values); err != nil {
+		return nil, invalid
+	}
+	for role, value := range values {
+		if !data.ValidRole(role) {
+			return nil, invalid
+		}
+		size, ok := parseSize(fmt.Sprint(value))
+		if !ok {
+			return nil, invalid
+		}
+		sizes[role] = size
+	}
+	return sizes, nil
+}
+
+func maxMetadataSizes(config *viper.Viper) map[string]int64 {
+	sizes, err := parseMaxMetadataSizes(config)
+	if err != nil {
+		return nil
+	}
+	return sizes
+}
+
+// serverKeyPins are the server keys pinned for the roles of a GUN, if any.  The
+// configuration has been checked when it was parsed.
+func serverKeyPins(config *viper.Viper, gun string) map[string][]string {
+	pins, err := parseServerKeyPins(config)
+	if err != nil {
+		return nil
+	}
+	return pins[strings.ToLower(gun)]
+}
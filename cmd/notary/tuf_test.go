@@ -1,10 +1,21 @@
 package main
 
 import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"io/ioutil"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
 	"testing"
+	"time"
 
+	"github.com/docker/notary/passphrase"
+	"github.com/spf13/viper"
 	"github.com/stretchr/testify/require"
 )
 
@@ -14,7 +25,7 @@ func TestTokenAuth(t *testing.T) {
 		baseTransport = &http.Transport{}
 		gun           = "test"
 	)
-	auth, err := tokenAuth("https://localhost:9999", baseTransport, gun, readOnly)
+	auth, err := tokenAuth("https://localhost:9999", baseTransport, gun, readOnly, false, nil, 0)
 	require.NoError(t, err)
 	require.Nil(t, auth)
 }
@@ -33,7 +44,7 @@ func TestTokenAuth200Status(t *testing.T) {
 	s := httptest.NewServer(http.HandlerFunc(NotAuthorizedTestHandler))
 	defer s.Close()
 
-	auth, err := tokenAuth(s.URL, baseTransport, gun, readOnly)
+	auth, err := tokenAuth(s.URL, baseTransport, gun, readOnly, false, nil, 0)
 	require.NoError(t, err)
 	require.NotNil(t, auth)
 }
@@ -51,7 +62,7 @@ func TestTokenAuth401Status(t *testing.T) {
 	s := httptest.NewServer(http.HandlerFunc(NotAuthorizedTestHandler))
 	defer s.Close()
 
-	auth, err := tokenAuth(s.URL, baseTransport, gun, readOnly)
+	auth, err := tokenAuth(s.URL, baseTransport, gun, readOnly, false, nil, 0)
 	require.NoError(t, err)
 	require.NotNil(t, auth)
 }
@@ -69,7 +80,172 @@ func TestTokenAuthNon200Non401Status(t *testing.T) {
 	s := httptest.NewServer(http.HandlerFunc(NotFoundTestHandler))
 	defer s.Close()
 
-	auth, err := tokenAuth(s.URL, baseTransport, gun, readOnly)
+	auth, err := tokenAuth(s.URL, baseTransport, gun, readOnly, false, nil, 0)
 	require.NoError(t, err)
 	require.Nil(t, auth)
 }
+
+// TLS verification is only skipped when asked to, with a warning every time,
+// and never if a root CA is configured
+func TestSkipTLSVerify(t *testing.T) {
+	config := viper.New()
+	warnings := new(bytes.Buffer)
+	require.False(t, skipTLSVerify(config, "", warnings))
+	require.Empty(t, warnings.String())
+
+	config.Set("remote_server.skipTLSVerify", true)
+	require.True(t, skipTLSVerify(config, "", warnings))
+	require.Contains(t, warnings.String(), "TLS verification of the trust server is DISABLED")
+
+	warnings.Reset()
+	require.False(t, skipTLSVerify(config, "root-ca.crt", warnings))
+	require.Contains(t, warnings.String(), "will not be skipped")
+}
+
+// The minimum TLS version defaults to 1.2, and a server that only supports
+// older versions is reported rather than treated as being offline
+func TestMinTLSVersion(t *testing.T) {
+	v, err := parseTLSVersion("")
+	require.NoError(t, err)
+	require.Equal(t, uint16(tls.VersionTLS12), v)
+	v, err = parseTLSVersion("1.1")
+	require.NoError(t, err)
+	require.Equal(t, uint16(tls.VersionTLS11), v)
+	_, err = parseTLSVersion("1.3")
+	require.Error(t, err)
+
+	s := httptest.NewUnstartedServer(http.HandlerFunc(StatusOKTestHandler))
+	s.TLS = &tls.Config{MinVersion: tls.VersionTLS10, MaxVersion: tls.VersionTLS11}
+	s.StartTLS()
+	defer s.Close()
+
+	baseTransport := &http.Transport{
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true, MinVersion: tls.VersionTLS11},
+	}
+	auth, err := tokenAuth(s.URL, baseTransport, "test", true, false, nil, 0)
+	require.NoError(t, err)
+	require.NotNil(t, auth)
+
+	baseTransport = &http.Transport{
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true, MinVersion: tls.VersionTLS12},
+	}
+	_, err = tokenAuth(s.URL, baseTransport, "test", true, false, nil, 0)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "does not support TLS 1.2")
+}
+
+// Failing to connect to the trust server is reported differently from a
+// request that is too slow once connected
+func TestConnectAndRequestTimeouts(t *testing.T) {
+	config := viper.New()
+	timeout, err := parseTimeout(config, "remote_server.timeout", defaultConnectTimeout)
+	require.NoError(t, err)
+	require.Equal(t, defaultConnectTimeout, timeout)
+	config.Set("remote_server.timeout", "2m")
+	timeout, err = parseTimeout(config, "remote_server.timeout", 0)
+	require.NoError(t, err)
+	require.Equal(t, 2*time.Minute, timeout)
+	config.Set("remote_server.timeout", "-1s")
+	_, err = parseTimeout(config, "remote_server.timeout", 0)
+	require.Error(t, err)
+
+	// nothing listens on the port of a closed listener
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := l.Addr().String()
+	l.Close()
+	dialer := &connectDialer{dialer: &net.Dialer{Timeout: time.Second}}
+	_, err = dialer.Dial("tcp", addr)
+	require.IsType(t, errCouldNotConnect{}, err)
+	require.Contains(t, err.Error(), "could not connect to "+addr)
+	require.EqualValues(t, 0, dialer.connections())
+
+	// a connection that never completes
+	release := make(chan struct{})
+	blocked := &http.Transport{
+		Dial: func(network, addr string) (net.Conn, error) {
+			<-release
+			return nil, errors.New("released")
+		},
+	}
+	req, err := http.NewRequest("GET", "http://notary-server:4443/v2/", nil)
+	require.NoError(t, err)
+	_, err = timeoutTransport{base: blocked, timeout: 50 * time.Millisecond, dialer: dialer}.RoundTrip(req)
+	require.IsType(t, errCouldNotConnect{}, err)
+	require.Contains(t, err.Error(), "could not connect to notary-server:4443 within 50ms")
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow-body" {
+			w.WriteHeader(200)
+			w.(http.Flusher).Flush()
+		}
+		<-release
+	}))
+	defer s.Close()
+	defer close(release)
+	rt := timeoutTransport{base: &http.Transport{Dial: dialer.Dial}, timeout: 50 * time.Millisecond, dialer: dialer}
+
+	// slow to respond once connected
+	req, err = http.NewRequest("GET", s.URL+"/slow-headers", nil)
+	require.NoError(t, err)
+	_, err = rt.RoundTrip(req)
+	require.IsType(t, errRequestTimeout{}, err)
+	require.Contains(t, err.Error(), "did not complete within 50ms")
+
+	// slow to send the body
+	req, err = http.NewRequest("GET", s.URL+"/slow-body", nil)
+	require.NoError(t, err)
+	resp, err := rt.RoundTrip(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	_, err = ioutil.ReadAll(resp.Body)
+	require.IsType(t, errRequestTimeout{}, err)
+}
+
+// the post-publish hook is told about the publish through its environment, and
+// a failing hook only warns
+func TestRunPostPublishHook(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the hook commands below need a POSIX shell")
+	}
+	out, warnings := new(bytes.Buffer), new(bytes.Buffer)
+	runPostPublishHook(`echo "$NOTARY_GUN $NOTARY_PUBLISHED_ROLES $NOTARY_SERVER_URL"`,
+		"docker.com/notary", "https://notary-server:4443", []string{"snapshot", "targets"},
+		out, warnings)
+	require.Equal(t, "docker.com/notary snapshot,targets https://notary-server:4443\n", out.String())
+	require.Empty(t, warnings.String())
+
+	out.Reset()
+	runPostPublishHook("exit 3", "docker.com/notary", "https://notary-server:4443", nil,
+		out, warnings)
+	require.Contains(t, warnings.String(),
+		"WARNING: post-publish hook failed, but docker.com/notary was already published")
+}
+
+// every command builds its repositories with the configured algorithms,
+// limits and revoked keys
+func TestNewRepositoryAppliesConfig(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "notary-test-")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	revocationList := filepath.Join(tempDir, "revoked.txt")
+	require.NoError(t, ioutil.WriteFile(revocationList, []byte("abc123\n"), 0600))
+
+	config := viper.New()
+	config.Set("trust_dir", tempDir)
+	config.Set("allowed_algorithms", []string{"ecdsa"})
+	config.Set("max_timestamp_age", "10m")
+	config.Set("revocation_list", revocationList)
+
+	nRepo, err := newRepository(config, "docker.com/notary", nil, passphrase.ConstantRetriever("pass"))
+	require.NoError(t, err)
+	require.Equal(t, []string{"ecdsa"}, nRepo.AllowedAlgorithms)
+	require.Equal(t, 10*time.Minute, nRepo.MaxTimestampAge)
+	require.Equal(t, []string{"abc123"}, nRepo.RevokedKeys)
+
+	// in the closed revocation mode, a list that can't be loaded stops the command
+	config.Set("revocation_list", filepath.Join(tempDir, "missing.txt"))
+	_, err = newRepository(config, "docker.com/notary", nil, passphrase.ConstantRetriever("pass"))
+	require.Error(t, err)
+}
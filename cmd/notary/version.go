@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"runtime"
+
+	"github.com/docker/notary/trustmanager"
+	"github.com/docker/notary/tuf/signed"
+	"github.com/docker/notary/version"
+	"github.com/spf13/cobra"
+)
+
+var cmdVersionTemplate = usageTemplate{
+	Use:   "version",
+	Short: "Print the version number of notary",
+	Long:  `print the version number of notary`,
+}
+
+// buildInfo describes the notary binary and the cryptographic algorithms it
+// supports, for consumption by tools
+type buildInfo struct {
+	Version             string   `json:"version"`
+	GitCommit           string   `json:"git_commit"`
+	GoVersion           string   `json:"go_version"`
+	SignatureAlgorithms []string `json:"signature_algorithms"`
+	ECDSACurves         []string `json:"ecdsa_curves"`
+}
+
+func newBuildInfo() buildInfo {
+	return buildInfo{
+		Version:             version.NotaryVersion,
+		GitCommit:           version.GitCommit,
+		GoVersion:           runtime.Version(),
+		SignatureAlgorithms: signed.SupportedSignatureAlgorithms(),
+		ECDSACurves:         trustmanager.SupportedCurves(),
+	}
+}
+
+type versionCommander struct {
+	// these are for command line parsing - no need to set
+	outputJSON bool
+}
+
+func (v *versionCommander) GetCommand() *cobra.Command {
+	cmd := cmdVersionTemplate.ToCommand(v.printVersion)
+	cmd.Flags().BoolVar(&v.outputJSON, "json", false,
+		"Print the version, build and supported algorithm information as JSON")
+	return cmd
+}
+
+func (v *versionCommander) printVersion(cmd *cobra.Command, args []string) error {
+	info := newBuildInfo()
+	if !v.outputJSON {
+		cmd.Printf("notary\n Version:    %s\n Git commit: %s\n", info.Version, info.GitCommit)
+		return nil
+	}
+
+	out, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return err
+	}
+	cmd.Println(string(out))
+	return nil
+}
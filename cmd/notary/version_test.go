@@ -0,0 +1,37 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/docker/notary/tuf/data"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func runVersion(t *testing.T, outputJSON bool) string {
+	b := new(bytes.Buffer)
+	v := &versionCommander{}
+	cmd := v.GetCommand()
+	cmd.SetOutput(b)
+	v.outputJSON = outputJSON
+	require.NoError(t, v.printVersion(cmd, nil))
+	return b.String()
+}
+
+// the plain version output is human readable, and --json includes the build
+// information and the supported algorithms
+func TestVersion(t *testing.T) {
+	out := runVersion(t, false)
+	assert.True(t, strings.HasPrefix(out, "notary\n Version:"), "unexpected output: %s", out)
+
+	var info buildInfo
+	require.NoError(t, json.Unmarshal([]byte(runVersion(t, true)), &info))
+	assert.Equal(t, runtime.Version(), info.GoVersion)
+	assert.Contains(t, info.SignatureAlgorithms, string(data.ECDSASignature))
+	assert.Contains(t, info.SignatureAlgorithms, string(data.EDDSASignature))
+	assert.Equal(t, []string{"P-256", "P-384", "P-521"}, info.ECDSACurves)
+}
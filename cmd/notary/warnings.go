@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sync/atomic"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	// the warnings the client libraries log count as printed warnings too
+	logrus.AddHook(warningCounter{})
+}
+
+// warningsPrinted counts the lines of warning printed by the command being
+// run, so that --fail-on-warning can fail it once it has otherwise succeeded
+var warningsPrinted int32
+
+// warnf prints a line of warning to the writer, and records that a warning
+// was printed
+func warnf(warnings io.Writer, format string, args ...interface{}) {
+	atomic.AddInt32(&warningsPrinted, 1)
+	fmt.Fprintf(warnings, "WARNING: "+format+"\n", args...)
+}
+
+// warningCounter is a logrus hook that records the warnings that are logged
+type warningCounter struct{}
+
+func (warningCounter) Levels() []logrus.Level {
+	return []logrus.Level{logrus.WarnLevel}
+}
+
+func (warningCounter) Fire(*logrus.Entry) error {
+	atomic.AddInt32(&warningsPrinted, 1)
+	return nil
+}
+
+// resetWarnings forgets the warnings of any command run before this one
+func resetWarnings(cmd *cobra.Command, args []string) {
+	atomic.StoreInt32(&warningsPrinted, 0)
+}
+
+// checkWarnings fails the command if --fail-on-warning was given and it
+// printed any warning
+func (n *notaryCommander) checkWarnings() error {
+	if !n.failOnWarning {
+		return nil
+	}
+	if atomic.LoadInt32(&warningsPrinted) > 0 {
+		return fmt.Errorf("failing because of the warnings above, since --fail-on-warning was given")
+	}
+	return nil
+}
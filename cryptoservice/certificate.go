@@ -1,6 +1,7 @@
 package cryptoservice
 
 import (
+	"bytes"
 	"crypto"
 	"crypto/rand"
 	"crypto/x509"
@@ -21,6 +22,53 @@ func GenerateCertificate(rootKey data.PrivateKey, gun string, startTime, endTime
 	return generateCertificate(signer, gun, startTime, endTime)
 }
 
+// GenerateCASignedCertificate generates an X509 Certificate for a key from a
+// template, given a GUN and validity interval, signed by the provided CA key and
+// certificate instead of being self-signed
+func GenerateCASignedCertificate(key, caKey data.PrivateKey, caCert *x509.Certificate, gun string, startTime, endTime time.Time) (*x509.Certificate, error) {
+	signer := key.CryptoSigner()
+	if signer == nil {
+		return nil, fmt.Errorf("key type not supported for Certificate generation: %s\n", key.Algorithm())
+	}
+	caSigner := caKey.CryptoSigner()
+	if caSigner == nil {
+		return nil, fmt.Errorf("CA key type not supported for Certificate signing: %s\n", caKey.Algorithm())
+	}
+	if !caCert.IsCA {
+		return nil, fmt.Errorf("the provided CA certificate is not a CA: %s", caCert.Subject.CommonName)
+	}
+
+	// make sure the CA key actually belongs to the CA certificate
+	caCertPubKey, err := x509.MarshalPKIXPublicKey(caCert.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the public key of the CA certificate: %v", err)
+	}
+	caSignerPubKey, err := x509.MarshalPKIXPublicKey(caSigner.Public())
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the public key of the CA key: %v", err)
+	}
+	if !bytes.Equal(caCertPubKey, caSignerPubKey) {
+		return nil, fmt.Errorf("the provided CA key does not match the CA certificate")
+	}
+
+	template, err := trustmanager.NewCertificate(gun, startTime, endTime)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the certificate template for: %s (%v)", gun, err)
+	}
+
+	derBytes, err := x509.CreateCertificate(rand.Reader, template, caCert, signer.Public(), caSigner)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the certificate for: %s (%v)", gun, err)
+	}
+
+	cert, err := x509.ParseCertificate(derBytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse the certificate for key: %s (%v)", gun, err)
+	}
+
+	return cert, nil
+}
+
 // GenerateTestingCertificate generates a non-expired X509 Certificate from a template, given a GUN.
 // Good enough for tests where expiration does not really matter; do not use if you care about the policy.
 func GenerateTestingCertificate(signer crypto.Signer, gun string) (*x509.Certificate, error) {
@@ -34,3 +34,48 @@ func TestGenerateCertificate(t *testing.T) {
 	// Check CommonName
 	assert.Equal(t, cert.Subject.CommonName, gun)
 }
+
+func TestGenerateCASignedCertificate(t *testing.T) {
+	caKey, err := trustmanager.GenerateECDSAKey(rand.Reader)
+	assert.NoError(t, err, "could not generate CA key")
+	startTime := time.Now()
+
+	// a self-signed certificate that is not a CA cannot sign other certificates
+	notCACert, err := GenerateCertificate(caKey, "notaca", startTime, startTime.AddDate(10, 0, 0))
+	assert.NoError(t, err)
+
+	caTemplate, err := trustmanager.NewCertificate("ca", startTime, startTime.AddDate(10, 0, 0))
+	assert.NoError(t, err)
+	caTemplate.IsCA = true
+	caTemplate.KeyUsage |= x509.KeyUsageCertSign
+	caDER, err := x509.CreateCertificate(
+		rand.Reader, caTemplate, caTemplate, caKey.CryptoSigner().Public(), caKey.CryptoSigner())
+	assert.NoError(t, err)
+	caCert, err := x509.ParseCertificate(caDER)
+	assert.NoError(t, err)
+
+	privKey, err := trustmanager.GenerateECDSAKey(rand.Reader)
+	assert.NoError(t, err, "could not generate key")
+
+	gun := "docker.com/notary"
+	_, err = GenerateCASignedCertificate(privKey, caKey, notCACert, gun, startTime, startTime.AddDate(1, 0, 0))
+	assert.Error(t, err)
+
+	// the CA key must match the CA certificate
+	_, err = GenerateCASignedCertificate(privKey, privKey, caCert, gun, startTime, startTime.AddDate(1, 0, 0))
+	assert.Error(t, err)
+
+	cert, err := GenerateCASignedCertificate(privKey, caKey, caCert, gun, startTime, startTime.AddDate(1, 0, 0))
+	assert.NoError(t, err, "could not generate certificate")
+	assert.Equal(t, gun, cert.Subject.CommonName)
+	assert.Equal(t, caCert.Subject.CommonName, cert.Issuer.CommonName)
+
+	// the certificate chains to the CA
+	roots := x509.NewCertPool()
+	roots.AddCert(caCert)
+	_, err = cert.Verify(x509.VerifyOptions{
+		Roots:     roots,
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+	})
+	assert.NoError(t, err)
+}
@@ -125,6 +125,9 @@ func (cs *CryptoService) ImportRoleKey(pemBytes []byte, role string, newPassphra
 	} else {
 		// Parse the private key to get the key ID so that we can import it to the correct location
 		privKey, err := trustmanager.ParsePEMPrivateKey(pemBytes, "")
+		if _, ok := err.(trustmanager.ErrUnsupportedCurve); ok {
+			return err
+		}
 		if err != nil {
 			privKey, _, err = trustmanager.GetPasswdDecryptBytes(newPassphraseRetriever, pemBytes, role, string(role))
 			if err != nil {
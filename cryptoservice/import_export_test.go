@@ -3,6 +3,9 @@ package cryptoservice
 import (
 	"archive/zip"
 	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
 	"fmt"
 	"io/ioutil"
 	"net/http"
@@ -14,6 +17,7 @@ import (
 
 	"github.com/docker/notary/trustmanager"
 	"github.com/docker/notary/tuf/data"
+	"github.com/docker/notary/tuf/signed"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -494,3 +498,54 @@ func TestImportExportNonRootKeyReencrypt(t *testing.T) {
 	assert.Equal(t, data.CanonicalSnapshotRole, alias)
 	assert.Equal(t, snapshotKeyID, key.ID())
 }
+
+// ECDSA keys on P-256 and P-384 can be imported, and sign with the parameters
+// of their curve
+func TestImportRoleKeyECDSACurves(t *testing.T) {
+	for _, curve := range []elliptic.Curve{elliptic.P256(), elliptic.P384()} {
+		ecdsaPrivKey, err := ecdsa.GenerateKey(curve, rand.Reader)
+		assert.NoError(t, err)
+		privKey, err := trustmanager.ECDSAToPrivateKey(ecdsaPrivKey)
+		assert.NoError(t, err)
+		pemBytes, err := trustmanager.EncryptPrivateKey(privKey, data.CanonicalTargetsRole, oldPassphrase)
+		assert.NoError(t, err)
+
+		cs := NewCryptoService("docker.com/notary", trustmanager.NewKeyMemoryStore(oldPassphraseRetriever))
+		err = cs.ImportRoleKey(pemBytes, data.CanonicalTargetsRole, oldPassphraseRetriever)
+		assert.NoError(t, err, "could not import %s key", curve.Params().Name)
+
+		imported, _, err := cs.GetPrivateKey(privKey.ID())
+		assert.NoError(t, err, "could not unlock %s key", curve.Params().Name)
+		assert.Equal(t, privKey.Private(), imported.Private())
+
+		msg := []byte("message")
+		sig, err := imported.Sign(rand.Reader, msg, nil)
+		assert.NoError(t, err)
+		assert.Len(t, sig, 2*((curve.Params().BitSize+7)>>3))
+		verifier := signed.Verifiers[data.ECDSASignature]
+		assert.NoError(t, verifier.Verify(data.PublicKeyFromPrivate(imported), sig, msg))
+	}
+}
+
+// ECDSA keys on curves that notary doesn't support are rejected with an error
+// naming the curve, whether or not they are encrypted
+func TestImportRoleKeyUnsupportedCurve(t *testing.T) {
+	ecdsaPrivKey, err := ecdsa.GenerateKey(elliptic.P224(), rand.Reader)
+	assert.NoError(t, err)
+	privKey, err := trustmanager.ECDSAToPrivateKey(ecdsaPrivKey)
+	assert.NoError(t, err)
+
+	plainPEM, err := trustmanager.KeyToPEM(privKey, data.CanonicalTargetsRole)
+	assert.NoError(t, err)
+	encryptedPEM, err := trustmanager.EncryptPrivateKey(privKey, data.CanonicalTargetsRole, oldPassphrase)
+	assert.NoError(t, err)
+
+	for _, pemBytes := range [][]byte{plainPEM, encryptedPEM} {
+		cs := NewCryptoService("docker.com/notary", trustmanager.NewKeyMemoryStore(oldPassphraseRetriever))
+		err = cs.ImportRoleKey(pemBytes, data.CanonicalTargetsRole, oldPassphraseRetriever)
+		assert.Error(t, err)
+		assert.IsType(t, trustmanager.ErrUnsupportedCurve{}, err)
+		assert.Contains(t, err.Error(), "P-224")
+		assert.Len(t, cs.ListAllKeys(), 0)
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"io"
 	"os"
 	"strings"
+	"sync"
 
 	"path/filepath"
 
@@ -199,3 +200,33 @@ func ConstantRetriever(constantPassphrase string) Retriever {
 		return constantPassphrase, false, nil
 	}
 }
+
+// CachingRetriever returns a new Retriever which remembers the passphrases
+// that the given retriever returns, by key name and alias, so that each one
+// is only asked for once.  A passphrase is forgotten if it is asked for again
+// because it was incorrect.  The passphrases are only kept in memory.
+func CachingRetriever(retriever Retriever) Retriever {
+	var (
+		lock        sync.Mutex
+		passphrases = make(map[[2]string]string)
+	)
+	return func(keyName, alias string, createNew bool, numAttempts int) (string, bool, error) {
+		id := [2]string{keyName, alias}
+		lock.Lock()
+		if numAttempts > 0 {
+			delete(passphrases, id)
+		} else if passphrase, ok := passphrases[id]; ok {
+			lock.Unlock()
+			return passphrase, false, nil
+		}
+		lock.Unlock()
+
+		passphrase, giveup, err := retriever(keyName, alias, createNew, numAttempts)
+		if err == nil && !giveup {
+			lock.Lock()
+			passphrases[id] = passphrase
+			lock.Unlock()
+		}
+		return passphrase, giveup, err
+	}
+}
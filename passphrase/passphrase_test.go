@@ -93,3 +93,46 @@ func TestGetRootTargetsDelegation(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Empty(t, text)
 }
+
+// CachingRetriever only asks for the passphrase of each key once, unless the
+// passphrase it gave was incorrect, and does not remember failures
+func TestCachingRetriever(t *testing.T) {
+	var asked []string
+	passphrases := map[string]string{"key1": "pass1", "key2": "pass2"}
+	retriever := CachingRetriever(func(keyName, alias string, createNew bool, numAttempts int) (string, bool, error) {
+		asked = append(asked, keyName)
+		pass, ok := passphrases[keyName]
+		if !ok {
+			return "", true, ErrTooManyAttempts
+		}
+		return pass, false, nil
+	})
+
+	for i := 0; i < 2; i++ {
+		pass, giveUp, err := retriever("key1", "targets/a", false, 0)
+		assert.NoError(t, err)
+		assert.False(t, giveUp)
+		assert.Equal(t, "pass1", pass)
+	}
+	pass, _, err := retriever("key2", "targets/a", false, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, "pass2", pass)
+	assert.Equal(t, []string{"key1", "key2"}, asked)
+
+	// a retry asks again, and remembers the new passphrase
+	passphrases["key1"] = "newpass1"
+	pass, _, err = retriever("key1", "targets/a", false, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, "newpass1", pass)
+	pass, _, err = retriever("key1", "targets/a", false, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, "newpass1", pass)
+	assert.Equal(t, []string{"key1", "key2", "key1"}, asked)
+
+	for i := 0; i < 2; i++ {
+		_, giveUp, err := retriever("key3", "targets/a", false, 0)
+		assert.Error(t, err)
+		assert.True(t, giveUp)
+	}
+	assert.Equal(t, []string{"key1", "key2", "key1", "key3", "key3"}, asked)
+}
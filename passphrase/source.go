@@ -0,0 +1,167 @@
+package passphrase
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// ErrNoPassphrase is returned by the Retriever of a passphrase source that does
+// not have a passphrase for a key, so that ChainRetrievers moves on to the
+// next Retriever.
+var ErrNoPassphrase = errors.New("no passphrase available")
+
+// ErrPromptDisabled is returned instead of asking for a passphrase on the
+// terminal when prompting has been disabled, for instance because nobody is
+// there to answer.
+var ErrPromptDisabled = errors.New("interactive input required but disabled")
+
+// NoPromptRetriever returns a Retriever that gives up with ErrPromptDisabled
+// for every key, to use in place of PromptRetriever where prompting is
+// disabled.
+func NoPromptRetriever() Retriever {
+	return func(keyName, alias string, createNew bool, numAttempts int) (string, bool, error) {
+		return "", true, ErrPromptDisabled
+	}
+}
+
+// SourceFactory creates the Retriever of a passphrase source.  It is given
+// the argument that follows the name of the source in the specification
+// passed to NewSourceRetriever, which is empty if there is none.
+type SourceFactory func(arg string) (Retriever, error)
+
+var (
+	sourcesLock sync.Mutex
+	sources     = make(map[string]SourceFactory)
+)
+
+func init() {
+	RegisterSource("env", envSource)
+	RegisterSource("file", fileSource)
+	RegisterSource("prompt", promptSource)
+}
+
+// RegisterSource makes a passphrase source available to NewSourceRetriever
+// under the given name.  Programs embedding notary can register their own
+// sources, such as a secrets manager, from an init function; the Retriever of
+// the source should return ErrNoPassphrase for the keys it has no passphrase
+// for.  It panics if the name is already registered.
+func RegisterSource(name string, factory SourceFactory) {
+	sourcesLock.Lock()
+	defer sourcesLock.Unlock()
+	if _, ok := sources[name]; ok {
+		panic(fmt.Sprintf("passphrase source %s is already registered", name))
+	}
+	sources[name] = factory
+}
+
+// SourceNames returns the sorted names of the registered passphrase sources
+func SourceNames() []string {
+	sourcesLock.Lock()
+	defer sourcesLock.Unlock()
+	names := make([]string, 0, len(sources))
+	for name := range sources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewSourceRetriever returns the Retriever of the registered passphrase source
+// given by spec, which is either the name of the source or the name followed
+// by a colon and the argument of the source, e.g. "file:/etc/notary/passphrases".
+func NewSourceRetriever(spec string) (Retriever, error) {
+	name, arg := spec, ""
+	if i := strings.Index(spec, ":"); i >= 0 {
+		name, arg = spec[:i], spec[i+1:]
+	}
+	sourcesLock.Lock()
+	factory, ok := sources[name]
+	sourcesLock.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown passphrase source %q (available: %s)",
+			name, strings.Join(SourceNames(), ", "))
+	}
+	retriever, err := factory(arg)
+	if err != nil {
+		return nil, fmt.Errorf("invalid passphrase source %q: %v", spec, err)
+	}
+	return retriever, nil
+}
+
+// ChainRetrievers returns a Retriever that asks each of the given retrievers
+// in turn, until one of them returns something other than ErrNoPassphrase.
+func ChainRetrievers(retrievers ...Retriever) Retriever {
+	return func(keyName, alias string, createNew bool, numAttempts int) (string, bool, error) {
+		for _, retriever := range retrievers {
+			passphrase, giveup, err := retriever(keyName, alias, createNew, numAttempts)
+			if err != ErrNoPassphrase {
+				return passphrase, giveup, err
+			}
+		}
+		return "", true, fmt.Errorf("no passphrase found for the %s key %s", alias, keyName)
+	}
+}
+
+// envSource gets the passphrases from environment variables named after the
+// alias of the key, e.g. NOTARY_TARGETS_RELEASES_PASSPHRASE for the
+// targets/releases key.  The argument replaces the NOTARY prefix.
+func envSource(prefix string) (Retriever, error) {
+	if prefix == "" {
+		prefix = "NOTARY"
+	}
+	return func(keyName, alias string, createNew bool, numAttempts int) (string, bool, error) {
+		if v := os.Getenv(envPassphraseVar(prefix, alias)); v != "" {
+			return v, numAttempts > 1, nil
+		}
+		return "", false, ErrNoPassphrase
+	}, nil
+}
+
+// envPassphraseVar is the environment variable holding the passphrase of the
+// keys with the given alias
+func envPassphraseVar(prefix, alias string) string {
+	name := strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToUpper(r)
+		}
+		return '_'
+	}, alias)
+	return prefix + "_" + name + "_PASSPHRASE"
+}
+
+// fileSource gets the passphrases from the files of the directory given as
+// the argument, named after the alias of the key, e.g. targets/releases for
+// the targets/releases key.  A trailing newline is not part of the passphrase.
+func fileSource(dir string) (Retriever, error) {
+	if dir == "" {
+		return nil, errors.New("the directory of the passphrase files is required, as file:DIRECTORY")
+	}
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", dir)
+	}
+	return func(keyName, alias string, createNew bool, numAttempts int) (string, bool, error) {
+		passphraseBytes, err := ioutil.ReadFile(filepath.Join(dir, filepath.FromSlash(alias)))
+		if os.IsNotExist(err) {
+			return "", false, ErrNoPassphrase
+		}
+		if err != nil {
+			return "", true, err
+		}
+		return strings.TrimRight(string(passphraseBytes), "\r\n"), numAttempts > 1, nil
+	}, nil
+}
+
+// promptSource asks for the passphrases on the terminal
+func promptSource(arg string) (Retriever, error) {
+	if arg != "" {
+		return nil, errors.New("the prompt source takes no argument")
+	}
+	return PromptRetriever(), nil
+}
@@ -0,0 +1,103 @@
+package passphrase
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// The env source reads the variables named after the alias, with the prefix
+// given as its argument
+func TestEnvSource(t *testing.T) {
+	defer os.Unsetenv("NOTARY_TARGETS_RELEASES_PASSPHRASE")
+	defer os.Unsetenv("OTHER_ROOT_PASSPHRASE")
+	os.Setenv("NOTARY_TARGETS_RELEASES_PASSPHRASE", "releasespass")
+	os.Setenv("OTHER_ROOT_PASSPHRASE", "rootpass")
+
+	retriever, err := NewSourceRetriever("env")
+	assert.NoError(t, err)
+	pass, giveup, err := retriever("keyID", "targets/releases", false, 0)
+	assert.NoError(t, err)
+	assert.False(t, giveup)
+	assert.Equal(t, "releasespass", pass)
+	_, _, err = retriever("keyID", "root", false, 0)
+	assert.Equal(t, ErrNoPassphrase, err)
+
+	retriever, err = NewSourceRetriever("env:OTHER")
+	assert.NoError(t, err)
+	pass, _, err = retriever("keyID", "root", false, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, "rootpass", pass)
+}
+
+// The file source reads the files named after the alias in its directory
+func TestFileSource(t *testing.T) {
+	dir, err := ioutil.TempDir("", "notary-passphrase-")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, "targets"), 0700))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "targets", "releases"), []byte("releasespass\n"), 0600))
+
+	_, err = NewSourceRetriever("file")
+	assert.Error(t, err)
+	_, err = NewSourceRetriever("file:" + filepath.Join(dir, "missing"))
+	assert.Error(t, err)
+
+	retriever, err := NewSourceRetriever("file:" + dir)
+	assert.NoError(t, err)
+	pass, giveup, err := retriever("keyID", "targets/releases", false, 0)
+	assert.NoError(t, err)
+	assert.False(t, giveup)
+	assert.Equal(t, "releasespass", pass)
+	_, _, err = retriever("keyID", "root", false, 0)
+	assert.Equal(t, ErrNoPassphrase, err)
+}
+
+// Registered sources can be chained, falling back to the next source when one
+// has no passphrase for a key
+func TestRegisterAndChainSources(t *testing.T) {
+	RegisterSource("test-constant", func(arg string) (Retriever, error) {
+		return func(keyName, alias string, createNew bool, numAttempts int) (string, bool, error) {
+			if alias != "root" {
+				return "", false, ErrNoPassphrase
+			}
+			return arg, false, nil
+		}, nil
+	})
+	assert.Contains(t, SourceNames(), "test-constant")
+	assert.Panics(t, func() { RegisterSource("test-constant", nil) })
+
+	_, err := NewSourceRetriever("nonexistent")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "test-constant")
+
+	first, err := NewSourceRetriever("test-constant:rootpass")
+	assert.NoError(t, err)
+	retriever := ChainRetrievers(first, ConstantRetriever("otherpass"))
+	pass, _, err := retriever("keyID", "root", false, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, "rootpass", pass)
+	pass, _, err = retriever("keyID", "targets", false, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, "otherpass", pass)
+
+	_, giveup, err := ChainRetrievers(first)("keyID", "targets", false, 0)
+	assert.Error(t, err)
+	assert.True(t, giveup)
+}
+
+// With prompting disabled, a chain of sources fails instead of prompting once
+// the other sources have no passphrase for a key
+func TestNoPromptRetriever(t *testing.T) {
+	retriever := ChainRetrievers(ConstantRetriever("pass"), NoPromptRetriever())
+	pass, _, err := retriever("keyID", "root", false, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, "pass", pass)
+
+	_, giveup, err := NoPromptRetriever()("keyID", "root", false, 0)
+	assert.Equal(t, ErrPromptDisabled, err)
+	assert.True(t, giveup)
+}
@@ -17,6 +17,7 @@ import (
 	"github.com/docker/notary/server/timestamp"
 	"github.com/docker/notary/tuf/data"
 	"github.com/docker/notary/tuf/signed"
+	tufstore "github.com/docker/notary/tuf/store"
 	"github.com/docker/notary/tuf/validation"
 )
 
@@ -122,7 +123,31 @@ func getHandler(ctx context.Context, w http.ResponseWriter, r *http.Request, var
 		return errors.ErrNoStorage.WithDetail(nil)
 	}
 
-	return getRole(ctx, w, store, gun, tufRole, checksum)
+	var out bytes.Buffer
+	if err := getRole(ctx, &out, store, gun, tufRole, checksum); err != nil {
+		return err
+	}
+
+	// let clients that already have this metadata skip downloading it again
+	etag := tufstore.ETag(out.Bytes())
+	w.Header().Set("ETag", etag)
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return nil
+	}
+	_, err := w.Write(out.Bytes())
+	return err
+}
+
+// etagMatches returns whether an If-None-Match header value, which may be a
+// comma separated list of entity tags, includes the given entity tag
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		if strings.TrimSpace(candidate) == etag {
+			return true
+		}
+	}
+	return false
 }
 
 // DeleteHandler deletes all data for a GUN. A 200 responses indicates success.
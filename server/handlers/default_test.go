@@ -199,6 +199,49 @@ func TestGetHandlerRoot(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+// The get handler sends the ETag of the metadata, and does not send the
+// metadata again if the request has a matching If-None-Match header
+func TestGetHandlerETag(t *testing.T) {
+	metaStore := storage.NewMemStorage()
+	repo, _, err := testutils.EmptyRepo("gun")
+	assert.NoError(t, err)
+
+	ctx := context.Background()
+	ctx = context.WithValue(ctx, "metaStore", metaStore)
+
+	root, err := repo.SignRoot(data.DefaultExpires("root"))
+	assert.NoError(t, err)
+	rootJSON, err := json.Marshal(root)
+	assert.NoError(t, err)
+	metaStore.UpdateCurrent("gun", storage.MetaUpdate{Role: "root", Version: 1, Data: rootJSON})
+
+	vars := map[string]string{
+		"imageName": "gun",
+		"tufRole":   "root",
+	}
+
+	for _, ifNoneMatch := range []string{"", `"other"`} {
+		req := &http.Request{
+			Header: http.Header{"If-None-Match": []string{ifNoneMatch}},
+			Body:   ioutil.NopCloser(bytes.NewBuffer(nil)),
+		}
+		rw := httptest.NewRecorder()
+		assert.NoError(t, getHandler(ctx, rw, req, vars))
+		assert.Equal(t, http.StatusOK, rw.Code)
+		assert.Equal(t, store.ETag(rootJSON), rw.HeaderMap.Get("ETag"))
+		assert.Equal(t, rootJSON, rw.Body.Bytes())
+	}
+
+	req := &http.Request{
+		Header: http.Header{"If-None-Match": []string{`"other", ` + store.ETag(rootJSON)}},
+		Body:   ioutil.NopCloser(bytes.NewBuffer(nil)),
+	}
+	rw := httptest.NewRecorder()
+	assert.NoError(t, getHandler(ctx, rw, req, vars))
+	assert.Equal(t, http.StatusNotModified, rw.Code)
+	assert.Empty(t, rw.Body.Bytes())
+}
+
 func TestGetHandlerTimestamp(t *testing.T) {
 	metaStore := storage.NewMemStorage()
 	repo, crypto, err := testutils.EmptyRepo("gun")
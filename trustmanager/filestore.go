@@ -2,9 +2,11 @@ package trustmanager
 
 import (
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"regexp"
 	"strings"
 )
 
@@ -58,7 +60,65 @@ func (f *SimpleFileStore) Add(name string, data []byte) error {
 		return err
 	}
 	createDirectory(filepath.Dir(filePath), f.perms)
-	return ioutil.WriteFile(filePath, data, f.perms)
+	return writeFileAtomic(filePath, data, f.perms)
+}
+
+// writeData writes data to a temporary file, and is replaced in tests to
+// simulate failing part way through a write
+var writeData = func(w io.Writer, data []byte) error {
+	_, err := w.Write(data)
+	return err
+}
+
+// tempFileName matches the names of the temporary files that writeFileAtomic
+// writes: the name of the file, hidden, followed by .tmp and a random number
+var tempFileName = regexp.MustCompile(`^\..+\.tmp[0-9]+$`)
+
+// IsTempFileName is whether a file name is that of a temporary file written
+// while a file was being replaced, which is only left behind by a write that
+// was interrupted
+func IsTempFileName(name string) bool {
+	return tempFileName.MatchString(name)
+}
+
+// writeFileAtomic writes data to a temporary file next to filePath, syncs it
+// and renames it over filePath, so that a crash or error part way through
+// leaves either the old file or the new one, never a partially written one.
+func writeFileAtomic(filePath string, data []byte, perms os.FileMode) error {
+	dir, name := filepath.Split(filePath)
+	tmp, err := ioutil.TempFile(dir, "."+name+".tmp")
+	if err != nil {
+		return err
+	}
+	// once the temporary file has been renamed this fails harmlessly
+	defer os.Remove(tmp.Name())
+
+	if err := writeData(tmp, data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(perms); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), filePath); err != nil {
+		return err
+	}
+
+	// sync the directory so that the rename itself survives a crash; not all
+	// platforms support this, so it is done on a best effort basis
+	if d, err := os.Open(filepath.Dir(filePath)); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
 }
 
 // Remove removes a file identified by name
@@ -2,8 +2,10 @@ package trustmanager
 
 import (
 	"crypto/rand"
+	"errors"
 	"fmt"
 	"github.com/stretchr/testify/assert"
+	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
@@ -42,6 +44,66 @@ func TestAddFile(t *testing.T) {
 	assert.Equal(t, testData, b, "unexpected content in the file: %s", expectedFilePath)
 }
 
+// If writing a file fails part way through, the file that was there before is
+// left intact, and no partially written or temporary files are left behind
+func TestAddFileFailsPartWay(t *testing.T) {
+	oldData := []byte("This is the key that was there before.")
+	newData := []byte("This is the key that should have replaced it.")
+	testName := "docker.com/notary/key"
+	testExt := ".key"
+	perms := os.FileMode(0700)
+
+	tempBaseDir, err := ioutil.TempDir("", "notary-test-")
+	assert.NoError(t, err)
+	defer os.RemoveAll(tempBaseDir)
+
+	store := &SimpleFileStore{
+		baseDir: tempBaseDir,
+		fileExt: testExt,
+		perms:   perms,
+	}
+	assert.NoError(t, store.Add(testName, oldData))
+
+	writeData = func(w io.Writer, data []byte) error {
+		w.Write(data[:len(data)/2])
+		return errors.New("simulated crash")
+	}
+	defer func() {
+		writeData = func(w io.Writer, data []byte) error {
+			_, err := w.Write(data)
+			return err
+		}
+	}()
+	err = store.Add(testName, newData)
+	assert.EqualError(t, err, "simulated crash")
+
+	b, err := store.Get(testName)
+	assert.NoError(t, err)
+	assert.Equal(t, oldData, b)
+
+	files, err := ioutil.ReadDir(filepath.Join(tempBaseDir, filepath.Dir(testName)))
+	assert.NoError(t, err)
+	assert.Len(t, files, 1)
+	assert.Equal(t, filepath.Base(testName)+testExt, files[0].Name())
+	assert.Equal(t, perms, files[0].Mode().Perm())
+}
+
+// The temporary files written while replacing a file are recognized by name
+func TestIsTempFileName(t *testing.T) {
+	tempBaseDir, err := ioutil.TempDir("", "notary-test-")
+	assert.NoError(t, err)
+	defer os.RemoveAll(tempBaseDir)
+
+	tmp, err := ioutil.TempFile(tempBaseDir, ".abcdef.key.tmp")
+	assert.NoError(t, err)
+	tmp.Close()
+	assert.True(t, IsTempFileName(filepath.Base(tmp.Name())))
+
+	for _, name := range []string{"abcdef.key", ".abcdef.key", "abcdef.key.tmp1", ".abcdef.key.tmp", "index.json"} {
+		assert.False(t, IsTempFileName(name), name)
+	}
+}
+
 func TestRemoveFile(t *testing.T) {
 	testName := "docker.com/notary/certificate"
 	testExt := ".crt"
@@ -50,6 +50,23 @@ func (s *KeyFileStore) Name() string {
 	return fmt.Sprintf("file (%s)", s.SimpleFileStore.BaseDir())
 }
 
+// Backend implements KeyLocator
+func (s *KeyFileStore) Backend() string {
+	return "file"
+}
+
+// KeyLocation is the path of the file a key is in
+func (s *KeyFileStore) KeyLocation(name string) (string, error) {
+	s.Lock()
+	defer s.Unlock()
+	for _, kf := range listKeyFiles(s) {
+		if kf.name == name {
+			return s.GetPath(kf.file)
+		}
+	}
+	return "", ErrKeyNotFound{KeyID: name}
+}
+
 // AddKey stores the contents of a PEM-encoded private key as a PEM block
 func (s *KeyFileStore) AddKey(name, role string, privKey data.PrivateKey) error {
 	s.Lock()
@@ -92,6 +109,14 @@ func (s *KeyFileStore) ImportKey(pemBytes []byte, alias string) error {
 	return importKey(s, s.Retriever, s.cachedKeys, alias, pemBytes)
 }
 
+// RenameKey moves the key stored under oldName to newName, keeping its
+// encrypted bytes as they are
+func (s *KeyFileStore) RenameKey(oldName, newName string) error {
+	s.Lock()
+	defer s.Unlock()
+	return renameKey(s, s.cachedKeys, oldName, newName)
+}
+
 // NewKeyMemoryStore returns a new KeyMemoryStore which holds keys in memory
 func NewKeyMemoryStore(passphraseRetriever passphrase.Retriever) *KeyMemoryStore {
 	memStore := NewMemoryFileStore()
@@ -108,6 +133,17 @@ func (s *KeyMemoryStore) Name() string {
 	return "memory"
 }
 
+// Backend implements KeyLocator.  The keys are only in memory, so they have
+// no location.
+func (s *KeyMemoryStore) Backend() string {
+	return "memory"
+}
+
+// KeyLocation implements KeyLocator
+func (s *KeyMemoryStore) KeyLocation(name string) (string, error) {
+	return "", nil
+}
+
 // AddKey stores the contents of a PEM-encoded private key as a PEM block
 func (s *KeyMemoryStore) AddKey(name, alias string, privKey data.PrivateKey) error {
 	s.Lock()
@@ -150,6 +186,14 @@ func (s *KeyMemoryStore) ImportKey(pemBytes []byte, alias string) error {
 	return importKey(s, s.Retriever, s.cachedKeys, alias, pemBytes)
 }
 
+// RenameKey moves the key stored under oldName to newName, keeping its
+// encrypted bytes as they are
+func (s *KeyMemoryStore) RenameKey(oldName, newName string) error {
+	s.Lock()
+	defer s.Unlock()
+	return renameKey(s, s.cachedKeys, oldName, newName)
+}
+
 func addKey(s LimitedFileStore, passphraseRetriever passphrase.Retriever, cachedKeys map[string]*cachedKey, name, role string, privKey data.PrivateKey) error {
 
 	var (
@@ -160,15 +204,19 @@ func addKey(s LimitedFileStore, passphraseRetriever passphrase.Retriever, cached
 
 	for attempts := 0; ; attempts++ {
 		chosenPassphrase, giveup, err = passphraseRetriever(name, role, true, attempts)
-		if err != nil {
-			continue
-		}
 		if giveup {
+			if err != nil {
+				// asking again won't help
+				return ErrPasswordInvalid{Reason: err}
+			}
 			return ErrAttemptsExceeded{}
 		}
 		if attempts > 10 {
 			return ErrAttemptsExceeded{}
 		}
+		if err != nil {
+			continue
+		}
 		break
 	}
 
@@ -233,6 +281,22 @@ func getKey(s LimitedFileStore, passphraseRetriever passphrase.Retriever, cached
 // their corresponding aliases.
 func listKeys(s LimitedFileStore) map[string]string {
 	keyIDMap := make(map[string]string)
+	for _, kf := range listKeyFiles(s) {
+		keyIDMap[kf.name] = kf.alias
+	}
+	return keyIDMap
+}
+
+// keyFile is a file in a key store holding a key: its name, which is the key
+// ID prefixed with the GUN for non-root keys, the alias (role) of the key and
+// the path of the file without its extension
+type keyFile struct {
+	name, alias, file string
+}
+
+// listKeyFiles lists the files in the store that hold keys
+func listKeyFiles(s LimitedFileStore) []keyFile {
+	var keyFiles []keyFile
 
 	for _, f := range s.ListFiles() {
 		// Remove the prefix of the directory from the filename
@@ -259,7 +323,7 @@ func listKeys(s LimitedFileStore) map[string]string {
 				continue
 			}
 			if role, ok := block.Headers["role"]; ok {
-				keyIDMap[keyIDFull] = role
+				keyFiles = append(keyFiles, keyFile{name: keyIDFull, alias: role, file: f})
 			}
 		} else {
 			// The keyID is the first part of the keyname
@@ -267,10 +331,10 @@ func listKeys(s LimitedFileStore) map[string]string {
 			// in a key named abcde_root, abcde is the keyID and root is the KeyAlias
 			keyID := keyIDFull[:underscoreIndex]
 			keyAlias := keyIDFull[underscoreIndex+1:]
-			keyIDMap[keyID] = keyAlias
+			keyFiles = append(keyFiles, keyFile{name: keyID, alias: keyAlias, file: f})
 		}
 	}
-	return keyIDMap
+	return keyFiles
 }
 
 // RemoveKey removes the key from the keyfilestore
@@ -294,6 +358,40 @@ func removeKey(s LimitedFileStore, cachedKeys map[string]*cachedKey, name string
 	return nil
 }
 
+// renameKey moves the file of a key to the new name.  Keys in the legacy
+// filename format stay in that format, so that their bytes don't change.
+func renameKey(s LimitedFileStore, cachedKeys map[string]*cachedKey, oldName, newName string) error {
+	if _, _, err := getKeyRole(s, newName); err == nil {
+		return fmt.Errorf("a key is already stored as %s", newName)
+	}
+	role, legacy, err := getKeyRole(s, oldName)
+	if err != nil {
+		return err
+	}
+
+	oldFile, newFile := oldName, newName
+	if legacy {
+		oldFile = oldName + "_" + role
+		newFile = newName + "_" + role
+	}
+	oldFile = filepath.Join(getSubdir(role), oldFile)
+	newFile = filepath.Join(getSubdir(role), newFile)
+
+	keyBytes, err := s.Get(oldFile)
+	if err != nil {
+		return err
+	}
+	if err := s.Add(newFile, keyBytes); err != nil {
+		return err
+	}
+	if err := s.Remove(oldFile); err != nil {
+		s.Remove(newFile)
+		return err
+	}
+	delete(cachedKeys, oldName)
+	return nil
+}
+
 // Assumes 2 subdirectories, 1 containing root keys and 1 containing tuf keys
 func getSubdir(alias string) string {
 	if alias == "root" {
@@ -338,7 +436,7 @@ func GetPasswdDecryptBytes(passphraseRetriever passphrase.Retriever, pemBytes []
 		passwd, giveup, err = passphraseRetriever(name, alias, false, attempts)
 		// Check if the passphrase retriever got an error or if it is telling us to give up
 		if giveup || err != nil {
-			return nil, "", ErrPasswordInvalid{}
+			return nil, "", ErrPasswordInvalid{Reason: err}
 		}
 		if attempts > 10 {
 			return nil, "", ErrAttemptsExceeded{}
@@ -346,6 +444,10 @@ func GetPasswdDecryptBytes(passphraseRetriever passphrase.Retriever, pemBytes []
 
 		// Try to convert PEM encoded bytes back to a PrivateKey using the passphrase
 		privKey, err = ParsePEMPrivateKey(pemBytes, passwd)
+		if _, ok := err.(ErrUnsupportedCurve); ok {
+			// the key was decrypted, so asking again won't help
+			return nil, "", err
+		}
 		if err != nil {
 			retErr = ErrPasswordInvalid{}
 		} else {
@@ -2,6 +2,7 @@ package trustmanager
 
 import (
 	"crypto/rand"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
@@ -268,6 +269,36 @@ func TestListKeys(t *testing.T) {
 	assert.Len(t, keyMap, len(roles))
 }
 
+// The location of a key is the path of the file it is stored in
+func TestKeyLocation(t *testing.T) {
+	testName := "docker.com/notary/root"
+
+	// Temporary directory where test files will be created
+	tempBaseDir, err := ioutil.TempDir("", "notary-test-")
+	assert.NoError(t, err, "failed to create a temporary directory")
+	defer os.RemoveAll(tempBaseDir)
+
+	store, err := NewKeyFileStore(tempBaseDir, passphraseRetriever)
+	assert.NoError(t, err, "failed to create new key filestore")
+
+	privKey, err := GenerateECDSAKey(rand.Reader)
+	assert.NoError(t, err, "could not generate private key")
+	assert.NoError(t, store.AddKey(testName, data.CanonicalRootRole, privKey))
+
+	backend, location := KeyBackend(store, testName)
+	assert.Equal(t, "file", backend)
+	assert.Equal(t, filepath.Join(tempBaseDir, notary.PrivDir, notary.RootKeysSubdir, testName+".key"), location)
+	_, err = os.Stat(location)
+	assert.NoError(t, err)
+
+	_, err = store.KeyLocation("nonexistent")
+	assert.IsType(t, ErrKeyNotFound{}, err)
+
+	backend, location = KeyBackend(NewKeyMemoryStore(passphraseRetriever), testName)
+	assert.Equal(t, "memory", backend)
+	assert.Equal(t, "", location)
+}
+
 func TestAddGetKeyMemStore(t *testing.T) {
 	testName := "docker.com/notary/root"
 	testAlias := "root"
@@ -390,6 +421,37 @@ func TestGetDecryptedWithConsistentlyInvalidPassphrase(t *testing.T) {
 	// the key forever
 }
 
+// A passphrase retriever that gives up with an error fails adding and getting
+// keys straight away, with its error
+func TestPassphraseRetrieverGivesUpWithError(t *testing.T) {
+	retrieverErr := errors.New("interactive input required but disabled")
+	var giveUpRetriever = func(keyID string, alias string, createNew bool, numAttempts int) (string, bool, error) {
+		return "", true, retrieverErr
+	}
+
+	tempBaseDir, err := ioutil.TempDir("", "notary-test-")
+	assert.NoError(t, err, "failed to create a temporary directory")
+	defer os.RemoveAll(tempBaseDir)
+
+	privKey, err := GenerateECDSAKey(rand.Reader)
+	assert.NoError(t, err, "could not generate private key")
+
+	store, err := NewKeyFileStore(tempBaseDir, giveUpRetriever)
+	assert.NoError(t, err, "failed to create new key filestore")
+	err = store.AddKey(privKey.ID(), "root", privKey)
+	assert.IsType(t, ErrPasswordInvalid{}, err)
+	assert.Contains(t, err.Error(), retrieverErr.Error())
+
+	store, err = NewKeyFileStore(tempBaseDir, passphraseRetriever)
+	assert.NoError(t, err, "failed to create new key filestore")
+	assert.NoError(t, store.AddKey(privKey.ID(), "root", privKey))
+	store, err = NewKeyFileStore(tempBaseDir, giveUpRetriever)
+	assert.NoError(t, err, "failed to create new key filestore")
+	_, _, err = store.GetKey(privKey.ID())
+	assert.IsType(t, ErrPasswordInvalid{}, err)
+	assert.Contains(t, err.Error(), retrieverErr.Error())
+}
+
 // testGetDecryptedWithInvalidPassphrase takes two keystores so it can add to
 // one and get from the other (to work around caching)
 func testGetDecryptedWithInvalidPassphrase(t *testing.T, store KeyStore, newStore KeyStore, expectedFailureType interface{}) {
@@ -453,6 +515,52 @@ func testRemoveKeyWithRole(t *testing.T, role, expectedSubdir string) {
 	assert.Error(t, err, "file should not exist")
 }
 
+// Renaming a key moves its file, with the same bytes, to the new name, in
+// the same filename format
+func TestRenameKey(t *testing.T) {
+	tempBaseDir, err := ioutil.TempDir("", "notary-test-")
+	assert.NoError(t, err, "failed to create a temporary directory")
+	defer os.RemoveAll(tempBaseDir)
+
+	store, err := NewKeyFileStore(tempBaseDir, passphraseRetriever)
+	assert.NoError(t, err, "failed to create new key filestore")
+
+	privKey, err := GenerateECDSAKey(rand.Reader)
+	assert.NoError(t, err, "could not generate private key")
+	assert.NoError(t, store.AddKey("gun/old", data.CanonicalTargetsRole, privKey))
+	oldBytes, err := store.ExportKey("gun/old")
+	assert.NoError(t, err)
+
+	assert.NoError(t, store.RenameKey("gun/old", "gun/new"))
+	assert.Equal(t, map[string]string{"gun/new": data.CanonicalTargetsRole}, store.ListKeys())
+	newBytes, err := store.ExportKey("gun/new")
+	assert.NoError(t, err)
+	assert.Equal(t, oldBytes, newBytes)
+	gotKey, _, err := store.GetKey("gun/new")
+	assert.NoError(t, err)
+	assert.Equal(t, privKey.Private(), gotKey.Private())
+	_, _, err = store.GetKey("gun/old")
+	assert.Error(t, err)
+
+	// the new name can't already be taken
+	assert.NoError(t, store.AddKey("gun/other", data.CanonicalSnapshotRole, privKey))
+	assert.Error(t, store.RenameKey("gun/other", "gun/new"))
+	assert.Len(t, store.ListKeys(), 2)
+
+	// legacy keys keep the role in their filename
+	legacyPath := filepath.Join(tempBaseDir, notary.PrivDir, notary.RootKeysSubdir, "legacy_root."+keyExtension)
+	pemBytes, err := KeyToPEM(privKey, "")
+	assert.NoError(t, err)
+	assert.NoError(t, os.MkdirAll(filepath.Dir(legacyPath), 0700))
+	assert.NoError(t, ioutil.WriteFile(legacyPath, pemBytes, 0600))
+	assert.NoError(t, store.RenameKey("legacy", "renamed"))
+	renamedBytes, err := ioutil.ReadFile(filepath.Join(filepath.Dir(legacyPath), "renamed_root."+keyExtension))
+	assert.NoError(t, err)
+	assert.Equal(t, pemBytes, renamedBytes)
+	_, err = os.Stat(legacyPath)
+	assert.True(t, os.IsNotExist(err))
+}
+
 func TestKeysAreCached(t *testing.T) {
 	testName := "docker.com/notary/root"
 	testAlias := "alias"
@@ -632,3 +740,116 @@ func assertImportKeySuccess(
 	assert.Equal(t, expectedKey.Private(), reimportedKey.Private())
 	assert.Equal(t, expectedKey.Public(), reimportedKey.Public())
 }
+
+// A key can be found by its ID, a prefix of its ID or its alias, in any of the
+// key stores, as long as only one key matches
+func TestResolveKeyID(t *testing.T) {
+	fileStore := NewKeyMemoryStore(passphraseRetriever)
+	hardwareStore := NewKeyMemoryStore(passphraseRetriever)
+
+	rootKey, err := GenerateECDSAKey(rand.Reader)
+	assert.NoError(t, err)
+	assert.NoError(t, fileStore.AddKey(rootKey.ID(), data.CanonicalRootRole, rootKey))
+	// the same key in two stores is still one key
+	assert.NoError(t, hardwareStore.AddKey(rootKey.ID(), data.CanonicalRootRole, rootKey))
+
+	var targetsKeyIDs []string
+	for _, gun := range []string{"docker.com/notary", "docker.com/other"} {
+		targetsKey, err := GenerateECDSAKey(rand.Reader)
+		assert.NoError(t, err)
+		assert.NoError(t, fileStore.AddKey(filepath.Join(gun, targetsKey.ID()), data.CanonicalTargetsRole, targetsKey))
+		targetsKeyIDs = append(targetsKeyIDs, targetsKey.ID())
+	}
+
+	for _, name := range []string{rootKey.ID(), rootKey.ID()[:12], data.CanonicalRootRole} {
+		keyID, err := ResolveKeyID(name, hardwareStore, fileStore)
+		assert.NoError(t, err, "resolving %s", name)
+		assert.Equal(t, rootKey.ID(), keyID, "resolving %s", name)
+	}
+	keyID, err := ResolveKeyID(targetsKeyIDs[1][:12], fileStore)
+	assert.NoError(t, err)
+	assert.Equal(t, targetsKeyIDs[1], keyID)
+
+	_, err = ResolveKeyID(data.CanonicalTargetsRole, fileStore)
+	assert.IsType(t, ErrAmbiguousKey{}, err)
+	assert.Len(t, err.(ErrAmbiguousKey).KeyIDs, 2)
+	for _, keyID := range targetsKeyIDs {
+		assert.Contains(t, err.Error(), keyID)
+	}
+
+	for _, name := range []string{"", data.CanonicalSnapshotRole, "not-a-key-id"} {
+		_, err = ResolveKeyID(name, hardwareStore, fileStore)
+		assert.IsType(t, ErrKeyNotFound{}, err, "resolving %q", name)
+	}
+}
+
+// A prefix shorter than the minimum doesn't match a key, but a full ID or an
+// alias still does
+func TestResolveKeyIDMinPrefix(t *testing.T) {
+	store := NewKeyMemoryStore(passphraseRetriever)
+	rootKey, err := GenerateECDSAKey(rand.Reader)
+	assert.NoError(t, err)
+	assert.NoError(t, store.AddKey(rootKey.ID(), data.CanonicalRootRole, rootKey))
+
+	for _, name := range []string{rootKey.ID(), rootKey.ID()[:8], data.CanonicalRootRole} {
+		keyID, err := ResolveKeyIDMinPrefix(name, 8, store)
+		assert.NoError(t, err, "resolving %s", name)
+		assert.Equal(t, rootKey.ID(), keyID, "resolving %s", name)
+	}
+	_, err = ResolveKeyIDMinPrefix(rootKey.ID()[:7], 8, store)
+	assert.IsType(t, ErrKeyNotFound{}, err)
+}
+
+// The key index lists the keys by role, with the GUN of the non-root keys and
+// the file each key is in, and is only written once there is a key directory
+func TestWriteKeyIndex(t *testing.T) {
+	tempBaseDir, err := ioutil.TempDir("", "notary-test-")
+	assert.NoError(t, err, "failed to create a temporary directory")
+	defer os.RemoveAll(tempBaseDir)
+
+	assert.NoError(t, WriteKeyIndex(tempBaseDir))
+	_, err = os.Stat(filepath.Join(tempBaseDir, notary.PrivDir))
+	assert.True(t, os.IsNotExist(err))
+
+	store, err := NewKeyFileStore(tempBaseDir, passphraseRetriever)
+	assert.NoError(t, err, "failed to create new key filestore")
+	rootKey, err := GenerateECDSAKey(rand.Reader)
+	assert.NoError(t, err)
+	targetsKey, err := GenerateECDSAKey(rand.Reader)
+	assert.NoError(t, err)
+	assert.NoError(t, store.AddKey(rootKey.ID(), data.CanonicalRootRole, rootKey))
+	assert.NoError(t, store.AddKey(filepath.Join("docker.com/notary", targetsKey.ID()), data.CanonicalTargetsRole, targetsKey))
+
+	upToDate, err := KeyIndexUpToDate(tempBaseDir)
+	assert.NoError(t, err)
+	assert.False(t, upToDate)
+	assert.NoError(t, WriteKeyIndex(tempBaseDir))
+	upToDate, err = KeyIndexUpToDate(tempBaseDir)
+	assert.NoError(t, err)
+	assert.True(t, upToDate)
+	indexJSON, err := ioutil.ReadFile(filepath.Join(tempBaseDir, notary.PrivDir, KeyIndexFile))
+	assert.NoError(t, err)
+	var index map[string][]KeyIndexEntry
+	assert.NoError(t, json.Unmarshal(indexJSON, &index))
+	assert.Equal(t, map[string][]KeyIndexEntry{
+		data.CanonicalRootRole: {
+			{KeyID: rootKey.ID(), File: notary.RootKeysSubdir + "/" + rootKey.ID() + ".key"},
+		},
+		data.CanonicalTargetsRole: {
+			{KeyID: targetsKey.ID(), GUN: "docker.com/notary",
+				File: notary.NonRootKeysSubdir + "/docker.com/notary/" + targetsKey.ID() + ".key"},
+		},
+	}, index)
+
+	// the index is not mistaken for a key, and follows the keys as they change
+	assert.Len(t, store.ListKeys(), 2)
+	assert.NoError(t, store.RemoveKey(rootKey.ID()))
+	upToDate, err = KeyIndexUpToDate(tempBaseDir)
+	assert.NoError(t, err)
+	assert.False(t, upToDate)
+	assert.NoError(t, WriteKeyIndex(tempBaseDir))
+	index, err = KeyIndex(tempBaseDir)
+	assert.NoError(t, err)
+	assert.Len(t, index, 1)
+	assert.Len(t, index[data.CanonicalTargetsRole], 1)
+}
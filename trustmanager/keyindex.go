@@ -0,0 +1,114 @@
+package trustmanager
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/docker/notary"
+)
+
+// KeyIndexFile is the file, in the private key directory, that WriteKeyIndex
+// writes the index of the keys to
+const KeyIndexFile = "index.json"
+
+// KeyIndexEntry is a key listed in the key index
+type KeyIndexEntry struct {
+	KeyID string `json:"key_id"`
+	GUN   string `json:"gun,omitempty"`
+	// File is the path of the file holding the key, relative to the private
+	// key directory
+	File string `json:"file"`
+}
+
+// keyIndexEntries sorts index entries by GUN, then by key ID
+type keyIndexEntries []KeyIndexEntry
+
+func (k keyIndexEntries) Len() int      { return len(k) }
+func (k keyIndexEntries) Swap(i, j int) { k[i], k[j] = k[j], k[i] }
+func (k keyIndexEntries) Less(i, j int) bool {
+	if k[i].GUN != k[j].GUN {
+		return k[i].GUN < k[j].GUN
+	}
+	return k[i].KeyID < k[j].KeyID
+}
+
+// KeyIndex lists the keys in the file key store under baseDir by their alias
+// (role), with the file each one is stored in.
+func KeyIndex(baseDir string) (map[string][]KeyIndexEntry, error) {
+	fileStore, err := NewPrivateSimpleFileStore(filepath.Join(baseDir, notary.PrivDir), keyExtension)
+	if err != nil {
+		return nil, err
+	}
+	index := make(map[string][]KeyIndexEntry)
+	for _, kf := range listKeyFiles(fileStore) {
+		entry := KeyIndexEntry{
+			KeyID: filepath.Base(kf.name),
+			File:  filepath.ToSlash(fileStore.genFileName(kf.file)),
+		}
+		if gun := filepath.Dir(kf.name); gun != "." {
+			entry.GUN = filepath.ToSlash(gun)
+		}
+		index[kf.alias] = append(index[kf.alias], entry)
+	}
+	for _, entries := range index {
+		sort.Sort(keyIndexEntries(entries))
+	}
+	return index, nil
+}
+
+// keyIndexJSON is the index of the keys in the file key store under baseDir,
+// as it is written to KeyIndexFile
+func keyIndexJSON(baseDir string) ([]byte, error) {
+	index, err := KeyIndex(baseDir)
+	if err != nil {
+		return nil, err
+	}
+	indexJSON, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(indexJSON, '\n'), nil
+}
+
+// KeyIndexUpToDate is whether KeyIndexFile exists and lists exactly the keys
+// in the file key store under baseDir
+func KeyIndexUpToDate(baseDir string) (bool, error) {
+	indexJSON, err := keyIndexJSON(baseDir)
+	if err != nil {
+		return false, err
+	}
+	existing, err := ioutil.ReadFile(filepath.Join(baseDir, notary.PrivDir, KeyIndexFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return bytes.Equal(existing, indexJSON), nil
+}
+
+// WriteKeyIndex writes the index of the keys in the file key store under
+// baseDir to KeyIndexFile in the private key directory, so that the store can
+// be browsed by role rather than by key ID.  The keys themselves are not
+// touched, and the file is only rewritten if the index has changed.  Nothing
+// is written if there is no private key directory yet.
+func WriteKeyIndex(baseDir string) error {
+	privDir := filepath.Join(baseDir, notary.PrivDir)
+	if _, err := os.Stat(privDir); os.IsNotExist(err) {
+		return nil
+	}
+	indexJSON, err := keyIndexJSON(baseDir)
+	if err != nil {
+		return err
+	}
+
+	indexFile := filepath.Join(privDir, KeyIndexFile)
+	if existing, err := ioutil.ReadFile(indexFile); err == nil && bytes.Equal(existing, indexJSON) {
+		return nil
+	}
+	return writeFileAtomic(indexFile, indexJSON, private)
+}
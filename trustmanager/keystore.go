@@ -2,6 +2,9 @@ package trustmanager
 
 import (
 	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
 
 	"github.com/docker/notary/tuf/data"
 )
@@ -16,11 +19,17 @@ func (err ErrAttemptsExceeded) Error() string {
 
 // ErrPasswordInvalid is returned when signing fails. It could also mean the signing
 // key file was corrupted, but we have no way to distinguish.
-type ErrPasswordInvalid struct{}
+type ErrPasswordInvalid struct {
+	// Reason is the error the passphrase retriever gave up with, if any
+	Reason error
+}
 
 // ErrPasswordInvalid is returned when signing fails. It could also mean the signing
 // key file was corrupted, but we have no way to distinguish.
 func (err ErrPasswordInvalid) Error() string {
+	if err.Reason != nil {
+		return fmt.Sprintf("could not get the passphrase, operation has failed: %v", err.Reason)
+	}
 	return "password invalid, operation has failed."
 }
 
@@ -34,10 +43,70 @@ func (err ErrKeyNotFound) Error() string {
 	return fmt.Sprintf("signing key not found: %s", err.KeyID)
 }
 
+// ErrAmbiguousKey is returned when a key is given by a prefix of its ID or by
+// its alias, and more than one key matches.
+type ErrAmbiguousKey struct {
+	Name   string
+	KeyIDs []string
+}
+
+// Error lists the key IDs that the name matches
+func (err ErrAmbiguousKey) Error() string {
+	return fmt.Sprintf("%s matches more than one key, use one of the key IDs instead: %s",
+		err.Name, strings.Join(err.KeyIDs, ", "))
+}
+
 const (
 	keyExtension = "key"
 )
 
+// ResolveKeyID finds the ID of the key in the key stores that a name refers
+// to, which can be the ID of the key, a prefix of the ID, or the alias the key
+// was stored with.  A full ID always refers to its own key.  Otherwise the name
+// has to match exactly one key, or ErrAmbiguousKey is returned.  If no key
+// matches, ErrKeyNotFound is returned.
+func ResolveKeyID(name string, keyStores ...KeyStore) (string, error) {
+	return ResolveKeyIDMinPrefix(name, 1, keyStores...)
+}
+
+// ResolveKeyIDMinPrefix is like ResolveKeyID, but a name shorter than
+// minPrefix is not matched against the prefixes of the key IDs, so that it
+// can't pick a key by accident.
+func ResolveKeyIDMinPrefix(name string, minPrefix int, keyStores ...KeyStore) (string, error) {
+	if name == "" {
+		return "", ErrKeyNotFound{KeyID: name}
+	}
+	// the same key can be in more than one store, such as a hardware key and
+	// its backup, so matches are collected by ID
+	matches := make(map[string]bool)
+	for _, store := range keyStores {
+		for keyPath, alias := range store.ListKeys() {
+			// non-root keys are listed by their path, which is prefixed with the GUN
+			keyID := filepath.Base(keyPath)
+			if keyID == name {
+				return keyID, nil
+			}
+			if (len(name) >= minPrefix && strings.HasPrefix(keyID, name)) || alias == name {
+				matches[keyID] = true
+			}
+		}
+	}
+
+	keyIDs := make([]string, 0, len(matches))
+	for keyID := range matches {
+		keyIDs = append(keyIDs, keyID)
+	}
+	switch len(keyIDs) {
+	case 0:
+		return "", ErrKeyNotFound{KeyID: name}
+	case 1:
+		return keyIDs[0], nil
+	default:
+		sort.Strings(keyIDs)
+		return "", ErrAmbiguousKey{Name: name, KeyIDs: keyIDs}
+	}
+}
+
 // KeyStore is a generic interface for private key storage
 type KeyStore interface {
 	// Add Key adds a key to the KeyStore, and if the key already exists,
@@ -51,6 +120,32 @@ type KeyStore interface {
 	Name() string
 }
 
+// KeyLocator is implemented by the key stores that can tell where each of
+// their keys physically lives
+type KeyLocator interface {
+	// Backend is the kind of storage the keys are in, such as "file" or
+	// "pkcs11"
+	Backend() string
+	// KeyLocation is where in the backend the key with the given name (as
+	// listed by ListKeys) is, such as the path of its file or its slot
+	KeyLocation(name string) (string, error)
+}
+
+// KeyBackend returns the kind of storage a key is in, and where in it the
+// key is if that is known.  Key stores that are not KeyLocators are only known
+// by their Name.
+func KeyBackend(s KeyStore, name string) (backend, location string) {
+	locator, ok := s.(KeyLocator)
+	if !ok {
+		return s.Name(), ""
+	}
+	location, err := locator.KeyLocation(name)
+	if err != nil {
+		return locator.Backend(), ""
+	}
+	return locator.Backend(), location
+}
+
 type cachedKey struct {
 	alias string
 	key   data.PrivateKey
@@ -15,6 +15,7 @@ import (
 	"math/big"
 	"net/http"
 	"net/url"
+	"sort"
 	"time"
 
 	"github.com/Sirupsen/logrus"
@@ -191,6 +192,51 @@ func LoadCertBundleFromPEM(pemBytes []byte) ([]*x509.Certificate, error) {
 	return certificates, nil
 }
 
+// LoadLeafCertFromPEMBundle loads a PEM encoded certificate, or a bundle of a
+// certificate together with the CA certificates that issued it, in any order.
+// It returns the leaf certificate and the CA certificates of the bundle.  A
+// bundle must contain exactly one certificate that is not a CA.
+func LoadLeafCertFromPEMBundle(pemBytes []byte) (*x509.Certificate, []*x509.Certificate, error) {
+	certs, err := LoadCertBundleFromPEM(pemBytes)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(certs) == 1 {
+		return certs[0], nil, nil
+	}
+
+	leafCerts := GetLeafCerts(certs)
+	switch len(leafCerts) {
+	case 0:
+		return nil, nil, errors.New("no leaf certificate found in the certificate bundle")
+	case 1:
+		return leafCerts[0], GetIntermediateCerts(certs), nil
+	default:
+		return nil, nil, fmt.Errorf("found %d leaf certificates in the certificate bundle, expected one", len(leafCerts))
+	}
+}
+
+// ValidateCertChain checks that a leaf certificate was issued by one of the
+// given CA certificates, either directly or through the other ones.
+func ValidateCertChain(leaf *x509.Certificate, caCerts []*x509.Certificate) error {
+	if len(caCerts) == 0 {
+		return errors.New("no CA certificates to validate the certificate chain with")
+	}
+	pool := x509.NewCertPool()
+	for _, caCert := range caCerts {
+		pool.AddCert(caCert)
+	}
+	_, err := leaf.Verify(x509.VerifyOptions{
+		Roots:         pool,
+		Intermediates: pool,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	if err != nil {
+		return fmt.Errorf("certificate does not chain to the CA certificates: %v", err)
+	}
+	return nil
+}
+
 // GetLeafCerts parses a list of x509 Certificates and returns all of them
 // that aren't CA
 func GetLeafCerts(certs []*x509.Certificate) []*x509.Certificate {
@@ -216,6 +262,46 @@ func GetIntermediateCerts(certs []*x509.Certificate) []*x509.Certificate {
 	return intCerts
 }
 
+// ErrUnsupportedCurve is returned when an ECDSA key is on an elliptic curve
+// that notary does not sign with
+type ErrUnsupportedCurve struct {
+	Curve string
+}
+
+// Error implements error, naming the unsupported curve
+func (err ErrUnsupportedCurve) Error() string {
+	return fmt.Sprintf("unsupported elliptic curve: %s", err.Curve)
+}
+
+// supportedCurves are the elliptic curves of the ECDSA keys that can be
+// imported.  Signatures are sized according to the curve of the key.
+var supportedCurves = map[string]bool{
+	elliptic.P256().Params().Name: true,
+	elliptic.P384().Params().Name: true,
+	elliptic.P521().Params().Name: true,
+}
+
+// SupportedCurves returns the sorted names of the elliptic curves of the ECDSA
+// keys that can be imported
+func SupportedCurves() []string {
+	curves := make([]string, 0, len(supportedCurves))
+	for curve := range supportedCurves {
+		curves = append(curves, curve)
+	}
+	sort.Strings(curves)
+	return curves
+}
+
+// checkECDSACurve returns an ErrUnsupportedCurve naming the curve of the key if
+// it isn't one of the supported curves
+func checkECDSACurve(ecdsaPrivKey *ecdsa.PrivateKey) error {
+	curve := ecdsaPrivKey.Curve.Params().Name
+	if !supportedCurves[curve] {
+		return ErrUnsupportedCurve{Curve: curve}
+	}
+	return nil
+}
+
 // ParsePEMPrivateKey returns a data.PrivateKey from a PEM encoded private key. It
 // only supports RSA (PKCS#1) and attempts to decrypt using the passphrase, if encrypted.
 func ParsePEMPrivateKey(pemBytes []byte, passphrase string) (data.PrivateKey, error) {
@@ -266,6 +352,9 @@ func ParsePEMPrivateKey(pemBytes []byte, passphrase string) (data.PrivateKey, er
 		if err != nil {
 			return nil, fmt.Errorf("could not parse DER encoded private key: %v", err)
 		}
+		if err := checkECDSACurve(ecdsaPrivKey); err != nil {
+			return nil, err
+		}
 
 		tufECDSAPrivateKey, err := ECDSAToPrivateKey(ecdsaPrivKey)
 		if err != nil {
@@ -302,7 +391,19 @@ func ParsePEMPrivateKey(pemBytes []byte, passphrase string) (data.PrivateKey, er
 }
 
 // ParsePEMPublicKey returns a data.PublicKey from a PEM encoded public key or certificate.
+// If a bundle of certificates is given, the key is that of its leaf certificate.
 func ParsePEMPublicKey(pubKeyBytes []byte) (data.PublicKey, error) {
+	return parsePEMPublicKey(pubKeyBytes, true)
+}
+
+// ParsePEMPublicKeyWithoutValidation is like ParsePEMPublicKey, but does not
+// check the certificate's validity window or key size.  It should only be
+// used when the caller has explicitly asked for the checks to be skipped.
+func ParsePEMPublicKeyWithoutValidation(pubKeyBytes []byte) (data.PublicKey, error) {
+	return parsePEMPublicKey(pubKeyBytes, false)
+}
+
+func parsePEMPublicKey(pubKeyBytes []byte, validate bool) (data.PublicKey, error) {
 	pemBlock, _ := pem.Decode(pubKeyBytes)
 	if pemBlock == nil {
 		return nil, errors.New("no valid public key found")
@@ -310,15 +411,21 @@ func ParsePEMPublicKey(pubKeyBytes []byte) (data.PublicKey, error) {
 
 	switch pemBlock.Type {
 	case "CERTIFICATE":
-		cert, err := x509.ParseCertificate(pemBlock.Bytes)
+		cert, _, err := LoadLeafCertFromPEMBundle(pubKeyBytes)
 		if err != nil {
 			return nil, fmt.Errorf("could not parse provided certificate: %v", err)
 		}
-		err = ValidateCertificate(cert)
-		if err != nil {
-			return nil, fmt.Errorf("invalid certificate: %v", err)
+		if validate {
+			err = ValidateCertificate(cert)
+			if err != nil {
+				return nil, fmt.Errorf("invalid certificate: %v", err)
+			}
+		}
+		pubKey := CertToKey(cert)
+		if pubKey == nil {
+			return nil, fmt.Errorf("unsupported public key algorithm in certificate: %v", cert.PublicKeyAlgorithm)
 		}
-		return CertToKey(cert), nil
+		return pubKey, nil
 	default:
 		return nil, fmt.Errorf("unsupported PEM block type %q, expected certificate", pemBlock.Type)
 	}
@@ -349,6 +456,22 @@ func ValidateCertificate(c *x509.Certificate) error {
 	return nil
 }
 
+// ValidateCodeSigningUsage checks that a certificate is meant for signing
+// code, like the certificates notary generates: it has to allow digital
+// signatures in its key usage, and code signing in its extended key usage.
+// This rejects, for instance, TLS server certificates.
+func ValidateCodeSigningUsage(c *x509.Certificate) error {
+	if c.KeyUsage&x509.KeyUsageDigitalSignature == 0 {
+		return fmt.Errorf("certificate key usage does not include digital signatures")
+	}
+	for _, usage := range c.ExtKeyUsage {
+		if usage == x509.ExtKeyUsageCodeSigning {
+			return nil
+		}
+	}
+	return fmt.Errorf("certificate extended key usage does not include code signing")
+}
+
 // GenerateRSAKey generates an RSA private key and returns a TUF PrivateKey
 func GenerateRSAKey(random io.Reader, bits int) (data.PrivateKey, error) {
 	rsaPrivKey, err := rsa.GenerateKey(random, bits)
@@ -381,7 +504,10 @@ func RSAToPrivateKey(rsaPrivKey *rsa.PrivateKey) (data.PrivateKey, error) {
 	return data.NewRSAPrivateKey(pubKey, rsaPrivBytes)
 }
 
-// GenerateECDSAKey generates an ECDSA Private key and returns a TUF PrivateKey
+// GenerateECDSAKey generates an ECDSA Private key and returns a TUF PrivateKey.
+// crypto/ecdsa may mix randomness of its own into the bytes read from random,
+// so tests that need the same key every time should use
+// testutils.GenerateSeededKey instead.
 func GenerateECDSAKey(random io.Reader) (data.PrivateKey, error) {
 	ecdsaPrivKey, err := ecdsa.GenerateKey(elliptic.P256(), random)
 	if err != nil {
@@ -486,6 +612,24 @@ func KeyToPEM(privKey data.PrivateKey, role string) ([]byte, error) {
 	return pem.EncodeToMemory(block), nil
 }
 
+// PublicKeyToPEM returns a PEM encoded public key.  Keys that are certificates
+// are already PEM encoded, and are returned as they are.  RSA and ECDSA keys
+// are PKIX encoded, while ED25519 keys are the raw key bytes.
+func PublicKeyToPEM(pubKey data.PublicKey) ([]byte, error) {
+	var bt string
+	switch pubKey.Algorithm() {
+	case data.RSAx509Key, data.ECDSAx509Key:
+		return pubKey.Public(), nil
+	case data.RSAKey, data.ECDSAKey:
+		bt = "PUBLIC KEY"
+	case data.ED25519Key:
+		bt = "ED25519 PUBLIC KEY"
+	default:
+		return nil, fmt.Errorf("algorithm %s not supported", pubKey.Algorithm())
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: bt, Bytes: pubKey.Public()}), nil
+}
+
 // EncryptPrivateKey returns an encrypted PEM key given a Privatekey
 // and a passphrase
 func EncryptPrivateKey(key data.PrivateKey, role, passphrase string) ([]byte, error) {
@@ -601,6 +745,41 @@ func X509PublicKeyID(certPubKey data.PublicKey) (string, error) {
 	return key.ID(), nil
 }
 
+// KeyType describes the algorithm and size or curve of a public key, such as
+// "ECDSA P-256", "RSA 2048" or "ed25519".  For certificates, the key in the
+// certificate is described.
+func KeyType(pubKey data.PublicKey) (string, error) {
+	var (
+		key interface{}
+		err error
+	)
+	switch pubKey.Algorithm() {
+	case data.ED25519Key:
+		return "ed25519", nil
+	case data.ECDSAx509Key, data.RSAx509Key:
+		var cert *x509.Certificate
+		if cert, err = LoadCertFromPEM(pubKey.Public()); err == nil {
+			key = cert.PublicKey
+		}
+	case data.ECDSAKey, data.RSAKey:
+		key, err = x509.ParsePKIXPublicKey(pubKey.Public())
+	default:
+		return "", fmt.Errorf("unsupported key algorithm %q", pubKey.Algorithm())
+	}
+	if err != nil {
+		return "", err
+	}
+
+	switch k := key.(type) {
+	case *ecdsa.PublicKey:
+		return "ECDSA " + k.Curve.Params().Name, nil
+	case *rsa.PublicKey:
+		return fmt.Sprintf("RSA %d", k.N.BitLen()), nil
+	default:
+		return "", fmt.Errorf("%s key does not contain an ECDSA or RSA key", pubKey.Algorithm())
+	}
+}
+
 // FilterCertsExpiredSha1 can be used as the filter function to cert store
 // initializers to filter out all expired or SHA-1 certificate that we
 // shouldn't load.
@@ -5,6 +5,7 @@ import (
 	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/x509"
+	"encoding/pem"
 	"io/ioutil"
 	"strings"
 	"testing"
@@ -204,3 +205,199 @@ func TestECDSAX509PublickeyID(t *testing.T) {
 
 	assert.Equal(t, tufPrivKey.ID(), tufID)
 }
+
+// An expired certificate is rejected by ParsePEMPublicKey, but can still be
+// parsed by ParsePEMPublicKeyWithoutValidation
+func TestParsePEMPublicKeyWithoutValidation(t *testing.T) {
+	privKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+
+	startTime := time.Now().AddDate(-2, 0, 0)
+	template, err := NewCertificate("expired", startTime, startTime.AddDate(1, 0, 0))
+	assert.NoError(t, err)
+	derBytes, err := x509.CreateCertificate(
+		rand.Reader, template, template, &privKey.PublicKey, privKey)
+	assert.NoError(t, err)
+	pemBytes := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: derBytes})
+
+	_, err = ParsePEMPublicKey(pemBytes)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid certificate")
+
+	pubKey, err := ParsePEMPublicKeyWithoutValidation(pemBytes)
+	assert.NoError(t, err)
+	assert.Equal(t, data.ECDSAx509Key, pubKey.Algorithm())
+
+	// non-certificate PEM blocks are still rejected
+	_, err = ParsePEMPublicKeyWithoutValidation(
+		pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: derBytes}))
+	assert.Error(t, err)
+}
+
+// Only certificates that allow digital signatures and code signing pass
+// ValidateCodeSigningUsage
+func TestValidateCodeSigningUsage(t *testing.T) {
+	startTime := time.Now()
+	cert, err := NewCertificate("codesigning", startTime, startTime.AddDate(10, 0, 0))
+	assert.NoError(t, err)
+	assert.NoError(t, ValidateCodeSigningUsage(cert))
+
+	cert.KeyUsage = x509.KeyUsageKeyEncipherment
+	assert.Error(t, ValidateCodeSigningUsage(cert))
+
+	cert.KeyUsage = x509.KeyUsageDigitalSignature
+	cert.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	assert.Error(t, ValidateCodeSigningUsage(cert))
+
+	cert.ExtKeyUsage = nil
+	assert.Error(t, ValidateCodeSigningUsage(cert))
+
+	cert.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageCodeSigning}
+	assert.NoError(t, ValidateCodeSigningUsage(cert))
+}
+
+// createTestCert creates a certificate for a new key, signed by the given CA,
+// or self-signed if no CA is given
+func createTestCert(t *testing.T, cn string, isCA bool, issuer *x509.Certificate, issuerKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	privKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	startTime := time.Now()
+	template, err := NewCertificate(cn, startTime, startTime.AddDate(10, 0, 0))
+	assert.NoError(t, err)
+	if isCA {
+		template.IsCA = true
+		template.KeyUsage |= x509.KeyUsageCertSign
+	}
+	if issuer == nil {
+		issuer, issuerKey = template, privKey
+	}
+	derBytes, err := x509.CreateCertificate(rand.Reader, template, issuer, &privKey.PublicKey, issuerKey)
+	assert.NoError(t, err)
+	cert, err := x509.ParseCertificate(derBytes)
+	assert.NoError(t, err)
+	return cert, privKey
+}
+
+// The leaf of a certificate bundle is found whatever the order of the
+// certificates, it has to chain to the CA certificates of the bundle, and the
+// public key parsed from a bundle is that of the leaf
+func TestLoadLeafCertFromPEMBundle(t *testing.T) {
+	rootCert, rootKey := createTestCert(t, "root", true, nil, nil)
+	intCert, intKey := createTestCert(t, "intermediate", true, rootCert, rootKey)
+	leafCert, _ := createTestCert(t, "leaf", false, intCert, intKey)
+	otherLeafCert, _ := createTestCert(t, "other", false, nil, nil)
+
+	bundle := func(certs ...*x509.Certificate) []byte {
+		var pemBytes []byte
+		for _, cert := range certs {
+			pemBytes = append(pemBytes, CertToPEM(cert)...)
+		}
+		return pemBytes
+	}
+
+	leaf, caCerts, err := LoadLeafCertFromPEMBundle(bundle(rootCert, leafCert, intCert))
+	assert.NoError(t, err)
+	assert.Equal(t, leafCert.Raw, leaf.Raw)
+	assert.Len(t, caCerts, 2)
+	assert.NoError(t, ValidateCertChain(leaf, caCerts))
+
+	pubKey, err := ParsePEMPublicKey(bundle(intCert, leafCert))
+	assert.NoError(t, err)
+	assert.Equal(t, CertToKey(leafCert).ID(), pubKey.ID())
+
+	// a single certificate is returned as is, even if it is a CA
+	leaf, caCerts, err = LoadLeafCertFromPEMBundle(bundle(rootCert))
+	assert.NoError(t, err)
+	assert.Equal(t, rootCert.Raw, leaf.Raw)
+	assert.Empty(t, caCerts)
+	assert.Error(t, ValidateCertChain(leaf, caCerts))
+
+	// the leaf might not be identifiable
+	_, _, err = LoadLeafCertFromPEMBundle(bundle(rootCert, intCert))
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "no leaf certificate")
+	_, err = ParsePEMPublicKey(bundle(leafCert, otherLeafCert, intCert))
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "found 2 leaf certificates")
+
+	// the chain is broken without the intermediate
+	leaf, caCerts, err = LoadLeafCertFromPEMBundle(bundle(leafCert, rootCert))
+	assert.NoError(t, err)
+	assert.Error(t, ValidateCertChain(leaf, caCerts))
+}
+
+// KeyType describes the algorithm and the curve or size of plain keys, and of
+// the keys in certificates
+func TestKeyType(t *testing.T) {
+	ecdsaKey, err := GenerateECDSAKey(rand.Reader)
+	assert.NoError(t, err)
+	rsaKey, err := GenerateRSAKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	edKey, err := GenerateED25519Key(rand.Reader)
+	assert.NoError(t, err)
+
+	privKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	assert.NoError(t, err)
+	startTime := time.Now()
+	template, err := NewCertificate("something", startTime, startTime.AddDate(10, 0, 0))
+	assert.NoError(t, err)
+	derBytes, err := x509.CreateCertificate(
+		rand.Reader, template, template, &privKey.PublicKey, privKey)
+	assert.NoError(t, err)
+	cert, err := x509.ParseCertificate(derBytes)
+	assert.NoError(t, err)
+
+	rsaCert, err := LoadCertFromFile("../fixtures/notary-server.crt")
+	assert.NoError(t, err)
+	rsaCertKeyType, err := KeyType(CertToKey(rsaCert))
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(rsaCertKeyType, "RSA "), rsaCertKeyType)
+
+	for expected, pubKey := range map[string]data.PublicKey{
+		"ECDSA P-256": data.PublicKeyFromPrivate(ecdsaKey),
+		"RSA 2048":    data.PublicKeyFromPrivate(rsaKey),
+		"ed25519":     data.PublicKeyFromPrivate(edKey),
+		"ECDSA P-384": CertToKey(cert),
+	} {
+		keyType, err := KeyType(pubKey)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, keyType)
+	}
+
+	_, err = KeyType(data.NewPublicKey(data.ECDSAKey, []byte("not a key")))
+	assert.Error(t, err)
+}
+
+// Public keys that are certificates are written as they are, and the others
+// as PEM blocks of the key bytes
+func TestPublicKeyToPEM(t *testing.T) {
+	ecdsaKey, err := GenerateECDSAKey(rand.Reader)
+	assert.NoError(t, err)
+	ecdsaPubKey := data.PublicKeyFromPrivate(ecdsaKey)
+	pemBytes, err := PublicKeyToPEM(ecdsaPubKey)
+	assert.NoError(t, err)
+	block, _ := pem.Decode(pemBytes)
+	assert.NotNil(t, block)
+	assert.Equal(t, "PUBLIC KEY", block.Type)
+	_, err = x509.ParsePKIXPublicKey(block.Bytes)
+	assert.NoError(t, err)
+
+	edKey, err := GenerateED25519Key(rand.Reader)
+	assert.NoError(t, err)
+	pemBytes, err = PublicKeyToPEM(data.PublicKeyFromPrivate(edKey))
+	assert.NoError(t, err)
+	block, _ = pem.Decode(pemBytes)
+	assert.NotNil(t, block)
+	assert.Equal(t, "ED25519 PUBLIC KEY", block.Type)
+	assert.Equal(t, edKey.Public(), block.Bytes)
+
+	cert, err := LoadCertFromFile("../fixtures/notary-server.crt")
+	assert.NoError(t, err)
+	certKey := CertToKey(cert)
+	pemBytes, err = PublicKeyToPEM(certKey)
+	assert.NoError(t, err)
+	assert.Equal(t, certKey.Public(), pemBytes)
+	parsed, err := ParsePEMPublicKeyWithoutValidation(pemBytes)
+	assert.NoError(t, err)
+	assert.Equal(t, certKey.ID(), parsed.ID())
+}
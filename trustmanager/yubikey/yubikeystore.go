@@ -613,6 +613,20 @@ func (s YubiKeyStore) Name() string {
 	return "yubikey"
 }
 
+// Backend implements trustmanager.KeyLocator
+func (s YubiKeyStore) Backend() string {
+	return "pkcs11"
+}
+
+// KeyLocation is the slot of the Yubikey that a key is in
+func (s *YubiKeyStore) KeyLocation(keyID string) (string, error) {
+	key, ok := s.keys[keyID]
+	if !ok || len(key.slotID) == 0 {
+		return "", trustmanager.ErrKeyNotFound{KeyID: keyID}
+	}
+	return fmt.Sprintf("slot %d", key.slotID[0]), nil
+}
+
 func (s *YubiKeyStore) setLibLoader(loader pkcs11LibLoader) {
 	s.libLoader = loader
 }
@@ -875,7 +889,7 @@ func login(ctx IPKCS11Ctx, session pkcs11.SessionHandle, passRetriever passphras
 		passwd, giveup, err := passRetriever(user, "yubikey", false, attempts)
 		// Check if the passphrase retriever got an error or if it is telling us to give up
 		if giveup || err != nil {
-			return trustmanager.ErrPasswordInvalid{}
+			return trustmanager.ErrPasswordInvalid{Reason: err}
 		}
 		if attempts > 2 {
 			return trustmanager.ErrAttemptsExceeded{}
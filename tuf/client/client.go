@@ -6,6 +6,9 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"path"
+	"strings"
+	"time"
 
 	"github.com/Sirupsen/logrus"
 	"github.com/docker/notary"
@@ -21,6 +24,78 @@ type Client struct {
 	local  *tuf.Repo
 	remote store.RemoteStore
 	cache  store.MetadataStore
+
+	// MaxTimestampAge, if set, is how long ago the cached timestamp can have
+	// last been fetched from the remote for it to still be used.  An older
+	// one is always downloaded again in full, and not used as a fallback if
+	// the remote can't be reached.
+	MaxTimestampAge time.Duration
+
+	// OnlyRoles, if set, limits the delegations that are downloaded and
+	// verified to these roles, their ancestors and their descendants, so that
+	// the unrelated branches of a large delegation tree are skipped.
+	OnlyRoles []string
+
+	// NoCache, if set, makes every piece of metadata be downloaded from the
+	// remote and verified, even when the cached copy is still valid.  The
+	// cache is only used to check that no metadata has been rolled back, and
+	// is updated with what was downloaded.
+	NoCache bool
+
+	// MaxMetadataSizes, if set, overrides DefaultMaxMetadataSizes for the
+	// roles it has a size for.  See MaxMetadataSize.
+	MaxMetadataSizes map[string]int64
+
+	// AsOf, if set, is the time the metadata and delegations are checked for
+	// expiry at, instead of the present time, to check whether they were
+	// valid at some point in the past.
+	AsOf time.Time
+}
+
+// DefaultMaxMetadataSizes are the largest sizes, in bytes, of the metadata of
+// each role that are downloaded, so that a server can't exhaust the memory of
+// the client by sending endless metadata.  Delegations are limited to the
+// size of targets.
+var DefaultMaxMetadataSizes = map[string]int64{
+	data.CanonicalRootRole:      5 << 20,
+	data.CanonicalTimestampRole: notary.MaxTimestampSize,
+	data.CanonicalSnapshotRole:  25 << 20,
+	data.CanonicalTargetsRole:   notary.MaxDownloadSize,
+}
+
+// MaxMetadataSize is the largest size of the metadata of a role that is
+// downloaded, given the sizes set by role name: the size set for the role, or
+// for targets if the role is a delegation without a size of its own, or else
+// the default size for the role.
+func MaxMetadataSize(sizes map[string]int64, role string) int64 {
+	for _, limits := range []map[string]int64{sizes, DefaultMaxMetadataSizes} {
+		if size, ok := limits[role]; ok {
+			return size
+		}
+		if size, ok := limits[data.CanonicalTargetsRole]; ok && data.IsDelegation(role) {
+			return size
+		}
+	}
+	return notary.MaxDownloadSize
+}
+
+// GetMetaWithLimit downloads the metadata of a role whose size isn't known
+// with get, failing with ErrMetaTooLarge rather than returning it cut short if
+// it is larger than the limit.
+func GetMetaWithLimit(role string, limit int64, get func(size int64) ([]byte, error)) ([]byte, error) {
+	// one byte more than the limit tells metadata over the limit apart
+	raw, err := get(limit + 1)
+	if _, ok := err.(store.ErrMaliciousServer); ok {
+		// the server announced more than was asked for
+		return nil, ErrMetaTooLarge{Role: role, Limit: limit}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(raw)) > limit {
+		return nil, ErrMetaTooLarge{Role: role, Limit: limit}
+	}
+	return raw, nil
 }
 
 // NewClient initialized a Client with the given repo, remote source of content, and cache
@@ -114,7 +189,7 @@ func (c Client) checkRoot() error {
 		return ErrCorruptedCache{file: "root.json"}
 	}
 
-	if signed.IsExpired(root.Signed.Expires) {
+	if root.Signed.Expires.Before(c.now()) {
 		return tuf.ErrLocalRootExpired{}
 	}
 	return nil
@@ -170,6 +245,9 @@ func (c *Client) downloadRoot() error {
 			download = true
 		}
 	}
+	if c.NoCache {
+		download = true
+	}
 	var s *data.Signed
 	var raw []byte
 	if download {
@@ -207,7 +285,7 @@ func (c Client) verifyRoot(role string, s *data.Signed, minVersion int) error {
 		return err
 	}
 	// Verify using the rootRole loaded from the known root.json
-	if err = signed.Verify(s, rootRole, minVersion); err != nil {
+	if err = signed.VerifyAt(s, rootRole, minVersion, c.now()); err != nil {
 		logrus.Debug("root did not verify with existing keys")
 		return err
 	}
@@ -235,7 +313,7 @@ func (c Client) verifyRoot(role string, s *data.Signed, minVersion int) error {
 		logrus.Debug("root role with new keys not loaded")
 		return err
 	}
-	err = signed.Verify(s, rootRole, minVersion)
+	err = signed.VerifyAt(s, rootRole, minVersion, c.now())
 	if err != nil {
 		logrus.Debug("root did not verify with new keys")
 		return err
@@ -271,9 +349,30 @@ func (c *Client) downloadTimestamp() error {
 			old = cached
 		}
 	}
+
 	// unlike root, targets and snapshot, always try and download timestamps
-	// from remote, only using the cache one if we couldn't reach remote.
-	raw, s, err := c.downloadSigned(role, notary.MaxTimestampSize, nil)
+	// from remote, only using the cache one if we couldn't reach remote.  If
+	// the remote can tell us that the timestamp has not changed since we
+	// cached it, the cached copy is used as if it had been downloaded again,
+	// unless it is older than the maximum age.
+	var (
+		raw   []byte
+		s     *data.Signed
+		stale = c.cachedTimestampStale()
+	)
+	if conditional, ok := c.remote.(store.ConditionalRemoteStore); ok && old != nil && !stale && !c.NoCache {
+		raw, err = GetMetaWithLimit(role, MaxMetadataSize(c.MaxMetadataSizes, role), func(size int64) ([]byte, error) {
+			return conditional.GetMetaIfModified(role, size, cachedTS)
+		})
+		if _, ok := err.(store.ErrMetaNotModified); ok {
+			logrus.Debug("timestamp has not been modified, using cached timestamp")
+			raw, s, err = cachedTS, old, nil
+		} else if err == nil {
+			s, err = parseSigned(role, raw, nil)
+		}
+	} else {
+		raw, s, err = c.downloadSigned(role, -1, nil)
+	}
 	if err == nil {
 		ts, err = c.verifyTimestamp(s, version)
 		if err == nil {
@@ -283,11 +382,16 @@ func (c *Client) downloadTimestamp() error {
 			return nil
 		}
 	}
-	if old == nil {
-		// couldn't retrieve valid data from server and don't have unmarshallable data in cache.
+	if old == nil || c.NoCache {
+		// couldn't retrieve valid data from server and don't have unmarshallable data in cache,
+		// or aren't allowed to use it
 		logrus.Debug("no cached timestamp available")
 		return err
 	}
+	if stale {
+		logrus.Debug(err.Error())
+		return ErrStaleTimestamp{maxAge: c.MaxTimestampAge}
+	}
 	logrus.Debug(err.Error())
 	logrus.Warn("Error while downloading remote metadata, using cached timestamp - this might not be the latest version available remotely")
 	ts, err = c.verifyTimestamp(old, version)
@@ -299,6 +403,32 @@ func (c *Client) downloadTimestamp() error {
 	return nil
 }
 
+// now is the time the metadata is checked for expiry at
+func (c Client) now() time.Time {
+	if c.AsOf.IsZero() {
+		return time.Now()
+	}
+	return c.AsOf
+}
+
+// cachedTimestampStale checks whether the cached timestamp was last fetched
+// longer than MaxTimestampAge ago.  If there is a maximum age but the cache
+// can't tell how old its timestamp is, the timestamp is considered stale.
+func (c *Client) cachedTimestampStale() bool {
+	if c.MaxTimestampAge <= 0 {
+		return false
+	}
+	timedCache, ok := c.cache.(store.ModTimeStore)
+	if !ok {
+		return true
+	}
+	modTime, err := timedCache.GetMetaModTime(data.CanonicalTimestampRole)
+	if err != nil {
+		return true
+	}
+	return time.Since(modTime) > c.MaxTimestampAge
+}
+
 // verifies that a timestamp is valid, and returned the SignedTimestamp object to add to the tuf repo
 func (c *Client) verifyTimestamp(s *data.Signed, minVersion int) (*data.SignedTimestamp, error) {
 	timestampRole, err := c.local.GetBaseRole(data.CanonicalTimestampRole)
@@ -306,7 +436,7 @@ func (c *Client) verifyTimestamp(s *data.Signed, minVersion int) (*data.SignedTi
 		logrus.Debug("no timestamp role loaded")
 		return nil, err
 	}
-	if err := signed.Verify(s, timestampRole, minVersion); err != nil {
+	if err := signed.VerifyAt(s, timestampRole, minVersion, c.now()); err != nil {
 		return nil, err
 	}
 	return data.TimestampFromSigned(s)
@@ -353,6 +483,9 @@ func (c *Client) downloadSnapshot() error {
 			download = true
 		}
 	}
+	if c.NoCache {
+		download = true
+	}
 	var s *data.Signed
 	if download {
 		raw, s, err = c.downloadSigned(role, size, expectedSha256)
@@ -369,7 +502,7 @@ func (c *Client) downloadSnapshot() error {
 		logrus.Debug("no snapshot role loaded")
 		return err
 	}
-	err = signed.Verify(s, snapshotRole, version)
+	err = signed.VerifyAt(s, snapshotRole, version, c.now())
 	if err != nil {
 		return err
 	}
@@ -414,7 +547,7 @@ func (c *Client) downloadTargets(role string) error {
 				continue
 			}
 			logrus.Error("Error getting targets file:", err)
-			return err
+			return verificationError(role, err)
 		}
 		t, err := data.TargetsFromSigned(s)
 		if err != nil {
@@ -425,32 +558,118 @@ func (c *Client) downloadTargets(role string) error {
 			return err
 		}
 
-		// push delegated roles contained in the targets file onto the stack
+		// push delegated roles contained in the targets file onto the stack,
+		// apart from expired delegations, which are no longer trusted
 		for _, r := range t.Signed.Delegations.Roles {
+			if !r.ValidAt(c.now()) {
+				logrus.Debugf("skipping %s, which expired on %s", r.Name, r.ValidUntil)
+				continue
+			}
+			if !c.wantRole(r.Name) {
+				logrus.Debugf("skipping %s, which is not related to the requested roles", r.Name)
+				continue
+			}
 			stack.Push(r.Name)
 		}
 	}
 	return nil
 }
 
+// wantRole returns whether the delegation role should be downloaded, which is
+// when it is in OnlyRoles, or is an ancestor or a descendant of one of them
+func (c *Client) wantRole(role string) bool {
+	if len(c.OnlyRoles) == 0 {
+		return true
+	}
+	for _, only := range c.OnlyRoles {
+		if role == only || strings.HasPrefix(only, role+"/") || strings.HasPrefix(role, only+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// verificationError returns an ErrVerification with the role chain of the
+// given role if err is a failure to verify its metadata, or err otherwise
+func verificationError(role string, err error) error {
+	var reason VerificationFailure
+	switch e := err.(type) {
+	case signed.ErrExpired:
+		reason = FailureExpired
+	case signed.ErrRoleThreshold:
+		switch {
+		case len(e.BadSignatures) > 0:
+			reason = FailureBadSignature
+		case len(e.UnknownKeys) > 0:
+			reason = FailureKeyNotFound
+		default:
+			reason = FailureThreshold
+		}
+	case signed.ErrLowVersion, ErrChecksumMismatch, data.ErrInvalidRole:
+		reason = FailureInvalid
+	default:
+		switch err {
+		case signed.ErrNoSignatures:
+			reason = FailureThreshold
+		case signed.ErrWrongType:
+			reason = FailureInvalid
+		default:
+			return err
+		}
+	}
+
+	chain := []string{role}
+	for r := role; data.IsDelegation(r); {
+		r = path.Dir(r)
+		chain = append([]string{r}, chain...)
+	}
+	return ErrVerification{Chain: chain, Reason: reason, Err: err}
+}
+
+// downloadSigned downloads the metadata of a role, which has the given size,
+// or -1 if it is not known, and is limited to the maximum metadata size of the
+// role either way
 func (c *Client) downloadSigned(role string, size int64, expectedSha256 []byte) ([]byte, *data.Signed, error) {
 	rolePath := utils.ConsistentName(role, expectedSha256)
-	raw, err := c.remote.GetMeta(rolePath, size)
+	limit := MaxMetadataSize(c.MaxMetadataSizes, role)
+	var (
+		raw []byte
+		err error
+	)
+	switch {
+	case size > limit:
+		return nil, nil, ErrMetaTooLarge{Role: role, Limit: limit}
+	case size < 0:
+		raw, err = GetMetaWithLimit(role, limit, func(size int64) ([]byte, error) {
+			return c.remote.GetMeta(rolePath, size)
+		})
+	default:
+		raw, err = c.remote.GetMeta(rolePath, size)
+	}
 	if err != nil {
 		return nil, nil, err
 	}
+	s, err := parseSigned(role, raw, expectedSha256)
+	if err != nil {
+		return nil, nil, err
+	}
+	return raw, s, nil
+}
+
+// parseSigned checks the checksum of downloaded metadata, if there is an
+// expected one, and parses it
+func parseSigned(role string, raw, expectedSha256 []byte) (*data.Signed, error) {
 	if expectedSha256 != nil {
 		genHash := sha256.Sum256(raw)
 		if !bytes.Equal(genHash[:], expectedSha256) {
-			return nil, nil, ErrChecksumMismatch{role: role}
+			return nil, ErrChecksumMismatch{role: role}
 		}
 	}
 	s := &data.Signed{}
-	err = json.Unmarshal(raw, s)
-	if err != nil {
-		return nil, nil, err
+	if err := json.Unmarshal(raw, s); err != nil {
+		return nil, err
 	}
-	return raw, s, nil
+	return s, nil
 }
 
 func (c Client) getTargetsFile(role string, snapshotMeta data.Files, consistent bool) (*data.Signed, error) {
@@ -491,6 +710,9 @@ func (c Client) getTargetsFile(role string, snapshotMeta data.Files, consistent
 		}
 	}
 
+	if c.NoCache {
+		download = true
+	}
 	size := snapshotMeta[role].Length
 	var s *data.Signed
 	if download {
@@ -517,7 +739,7 @@ func (c Client) getTargetsFile(role string, snapshotMeta data.Files, consistent
 			return nil, err
 		}
 	}
-	if err = signed.Verify(s, targetOrDelgRole, version); err != nil {
+	if err = signed.VerifyAt(s, targetOrDelgRole, version, c.now()); err != nil {
 		return nil, err
 	}
 	logrus.Debugf("successfully verified %s", role)
@@ -4,11 +4,15 @@ import (
 	"crypto/sha256"
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"strconv"
 	"testing"
 	"time"
 
 	"github.com/Sirupsen/logrus"
+	"github.com/docker/notary"
 	tuf "github.com/docker/notary/tuf"
 	"github.com/docker/notary/tuf/testutils"
 	"github.com/stretchr/testify/assert"
@@ -332,6 +336,49 @@ func TestDownloadTargetsHappy(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+// Metadata larger than the maximum size of its role is not downloaded, whether
+// its size is known beforehand or not
+func TestDownloadMaxMetadataSize(t *testing.T) {
+	assert.Equal(t, int64(100), MaxMetadataSize(map[string]int64{"targets": 100}, "targets/a"))
+	assert.Equal(t, int64(10), MaxMetadataSize(map[string]int64{"targets": 100, "targets/a": 10}, "targets/a"))
+	assert.Equal(t, notary.MaxTimestampSize, MaxMetadataSize(map[string]int64{"targets": 100}, "timestamp"))
+	assert.Equal(t, notary.MaxDownloadSize, MaxMetadataSize(nil, "targets/a/b"))
+
+	repo, _, err := testutils.EmptyRepo("docker.com/notary")
+	assert.NoError(t, err)
+	localStorage := store.NewMemoryStore(nil)
+	remoteStorage := store.NewMemoryStore(nil)
+	client := NewClient(repo, remoteStorage, localStorage)
+
+	signedTargets, err := repo.SignTargets("targets", data.DefaultExpires("targets"))
+	assert.NoError(t, err)
+	targetsJSON, err := json.Marshal(signedTargets)
+	assert.NoError(t, err)
+	assert.NoError(t, remoteStorage.SetMeta("targets", targetsJSON))
+	repo.SignSnapshot(data.DefaultExpires("snapshot"))
+	signedTimestamp, err := repo.SignTimestamp(data.DefaultExpires("timestamp"))
+	assert.NoError(t, err)
+	timestampJSON, err := json.Marshal(signedTimestamp)
+	assert.NoError(t, err)
+	assert.NoError(t, remoteStorage.SetMeta("timestamp", timestampJSON))
+
+	client.MaxMetadataSizes = map[string]int64{
+		"targets":   int64(len(targetsJSON)) - 1,
+		"timestamp": int64(len(timestampJSON)) - 1,
+	}
+	err = client.downloadTargets("targets")
+	assert.Equal(t, ErrMetaTooLarge{Role: "targets", Limit: int64(len(targetsJSON)) - 1}, err)
+	err = client.downloadTimestamp()
+	assert.Equal(t, ErrMetaTooLarge{Role: "timestamp", Limit: int64(len(timestampJSON)) - 1}, err)
+
+	client.MaxMetadataSizes = map[string]int64{
+		"targets":   int64(len(targetsJSON)),
+		"timestamp": int64(len(timestampJSON)),
+	}
+	assert.NoError(t, client.downloadTargets("targets"))
+	assert.NoError(t, client.downloadTimestamp())
+}
+
 // TestDownloadTargetsLarge: Check that we can download very large targets metadata files,
 // which may be caused by adding a large number of targets.
 // This test is slow, so it will not run in short mode.
@@ -485,7 +532,121 @@ func TestDownloadTargetChecksumMismatch(t *testing.T) {
 	repo.Snapshot = &snap
 
 	err = client.downloadTargets("targets")
-	assert.IsType(t, ErrChecksumMismatch{}, err)
+	assert.IsType(t, ErrVerification{}, err)
+	verr := err.(ErrVerification)
+	assert.IsType(t, ErrChecksumMismatch{}, verr.Err)
+	assert.Equal(t, []string{"targets"}, verr.Chain)
+	assert.Equal(t, FailureInvalid, verr.Reason)
+}
+
+// With OnlyRoles, only the named delegations, their ancestors and their
+// descendants are downloaded, and the other branches are skipped
+func TestDownloadTargetsOnlyRoles(t *testing.T) {
+	repo, cs, err := testutils.EmptyRepo("docker.com/notary")
+	assert.NoError(t, err)
+	localStorage := store.NewMemoryStore(nil)
+	remoteStorage := store.NewMemoryStore(nil)
+	client := NewClient(repo, remoteStorage, localStorage)
+	client.OnlyRoles = []string{"targets/level1/a"}
+
+	delegations := []string{
+		"targets/level1",
+		"targets/level1/a",
+		"targets/level1/a/i",
+		"targets/level1/b",
+		"targets/level2",
+		"targets/level2/a",
+	}
+	for _, r := range delegations {
+		k, err := cs.Create(r, data.ED25519Key)
+		assert.NoError(t, err)
+		role, err := data.NewRole(r, 1, []string{k.ID()}, []string{""})
+		assert.NoError(t, err)
+		assert.NoError(t, repo.UpdateDelegations(role, []data.PublicKey{k}))
+		_, err = repo.InitTargets(r)
+		assert.NoError(t, err)
+	}
+	for _, r := range append(delegations, data.CanonicalTargetsRole) {
+		signedOrig, err := repo.SignTargets(r, data.DefaultExpires("targets"))
+		assert.NoError(t, err)
+		orig, err := json.Marshal(signedOrig)
+		assert.NoError(t, err)
+		assert.NoError(t, remoteStorage.SetMeta(r, orig))
+	}
+	_, err = repo.SignSnapshot(data.DefaultExpires("snapshot"))
+	assert.NoError(t, err)
+
+	for _, r := range append(delegations, data.CanonicalTargetsRole) {
+		delete(repo.Targets, r)
+	}
+	assert.NoError(t, client.downloadTargets("targets"))
+
+	for _, r := range []string{"targets", "targets/level1", "targets/level1/a", "targets/level1/a/i"} {
+		_, ok := repo.Targets[r]
+		assert.True(t, ok, "%s should have been downloaded", r)
+	}
+	for _, r := range []string{"targets/level1/b", "targets/level2", "targets/level2/a"} {
+		_, ok := repo.Targets[r]
+		assert.False(t, ok, "%s should have been skipped", r)
+	}
+}
+
+// When the metadata of a delegation deep in the tree fails verification, the
+// error has the chain of roles down to it and the reason of the failure
+func TestDownloadTargetsDeepVerificationFailure(t *testing.T) {
+	failures := map[VerificationFailure]func(s *data.Signed){
+		FailureBadSignature: func(s *data.Signed) { s.Signatures[0].Signature = []byte("12345") },
+		FailureKeyNotFound:  func(s *data.Signed) { s.Signatures[0].KeyID = "unknown" },
+		FailureThreshold:    func(s *data.Signed) { s.Signatures = []data.Signature{} },
+		FailureExpired:      nil,
+	}
+	delegations := []string{"targets/a", "targets/a/b", "targets/a/b/c"}
+
+	for reason, tamper := range failures {
+		repo, cs, err := testutils.EmptyRepo("docker.com/notary")
+		assert.NoError(t, err)
+		localStorage := store.NewMemoryStore(nil)
+		remoteStorage := store.NewMemoryStore(nil)
+		client := NewClient(repo, remoteStorage, localStorage)
+
+		for _, r := range delegations {
+			k, err := cs.Create(r, data.ED25519Key)
+			assert.NoError(t, err)
+			role, err := data.NewRole(r, 1, []string{k.ID()}, []string{""})
+			assert.NoError(t, err)
+			assert.NoError(t, repo.UpdateDelegations(role, []data.PublicKey{k}))
+			_, err = repo.InitTargets(r)
+			assert.NoError(t, err)
+		}
+
+		for _, r := range append(delegations, data.CanonicalTargetsRole) {
+			expires := data.DefaultExpires("targets")
+			if r == "targets/a/b/c" && tamper == nil {
+				expires = time.Now().Add(-time.Hour)
+			}
+			signedOrig, err := repo.SignTargets(r, expires)
+			assert.NoError(t, err)
+			if r == "targets/a/b/c" && tamper != nil {
+				tamper(signedOrig)
+				// the snapshot has to match the tampered metadata
+				repo.Targets[r].Signatures = signedOrig.Signatures
+			}
+			orig, err := json.Marshal(signedOrig)
+			assert.NoError(t, err)
+			assert.NoError(t, remoteStorage.SetMeta(r, orig))
+		}
+		_, err = repo.SignSnapshot(data.DefaultExpires("snapshot"))
+		assert.NoError(t, err)
+
+		err = client.downloadTargets("targets")
+		assert.IsType(t, ErrVerification{}, err, "expected a verification error for %s", reason)
+		if verr, ok := err.(ErrVerification); ok {
+			assert.Equal(t, []string{"targets", "targets/a", "targets/a/b", "targets/a/b/c"}, verr.Chain)
+			assert.Equal(t, "targets/a/b/c", verr.Role())
+			assert.Equal(t, reason, verr.Reason)
+			assert.Contains(t, verr.Error(), "targets -> targets/a -> targets/a/b -> targets/a/b/c")
+		}
+	}
 }
 
 // TestDownloadTargetsNoChecksum: it's never valid to download any targets
@@ -646,6 +807,167 @@ func TestDownloadTimestampHappy(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+// conditionalStore is a remote store that only sends metadata if it is not
+// the same as the caller's cached copy, and counts what it sends
+type conditionalStore struct {
+	store.RemoteStore
+	sent        int
+	notModified int
+}
+
+func (c *conditionalStore) GetMetaIfModified(name string, size int64, cached []byte) ([]byte, error) {
+	meta, err := c.RemoteStore.GetMeta(name, size)
+	if err != nil {
+		return nil, err
+	}
+	if store.ETag(meta) == store.ETag(cached) {
+		c.notModified++
+		return nil, store.ErrMetaNotModified{Resource: name}
+	}
+	c.sent++
+	return meta, nil
+}
+
+func (c *conditionalStore) GetMeta(name string, size int64) ([]byte, error) {
+	c.sent++
+	return c.RemoteStore.GetMeta(name, size)
+}
+
+// If the remote can tell that the cached timestamp is the same as its own,
+// the timestamp is not downloaded again, but a changed one is
+func TestDownloadTimestampNotModified(t *testing.T) {
+	repo, _, err := testutils.EmptyRepo("docker.com/notary")
+	assert.NoError(t, err)
+
+	tsSigned, err := repo.SignTimestamp(data.DefaultExpires("timestamp"))
+	assert.NoError(t, err)
+	ts, err := json.Marshal(tsSigned)
+	assert.NoError(t, err)
+	localStorage := store.NewMemoryStore(map[string][]byte{data.CanonicalTimestampRole: ts})
+	remoteStorage := &conditionalStore{
+		RemoteStore: store.NewMemoryStore(map[string][]byte{data.CanonicalTimestampRole: ts}),
+	}
+	client := NewClient(repo, remoteStorage, localStorage)
+
+	assert.NoError(t, client.downloadTimestamp())
+	assert.Equal(t, 1, remoteStorage.notModified)
+	assert.Equal(t, 0, remoteStorage.sent)
+	assert.NotNil(t, repo.Timestamp)
+
+	// publish a new timestamp, which is downloaded and cached
+	newSigned, err := repo.SignTimestamp(data.DefaultExpires("timestamp"))
+	assert.NoError(t, err)
+	newTS, err := json.Marshal(newSigned)
+	assert.NoError(t, err)
+	assert.NoError(t, remoteStorage.SetMeta(data.CanonicalTimestampRole, newTS))
+
+	assert.NoError(t, client.downloadTimestamp())
+	assert.Equal(t, 1, remoteStorage.sent)
+	cached, err := localStorage.GetMeta(data.CanonicalTimestampRole, -1)
+	assert.NoError(t, err)
+	assert.Equal(t, newTS, cached)
+}
+
+// With a maximum timestamp age, a cached timestamp that was fetched longer ago
+// is downloaded again in full rather than just confirmed, and is not used if
+// the remote can't be reached, nor is a cached timestamp of unknown age
+func TestDownloadTimestampMaxAge(t *testing.T) {
+	repo, _, err := testutils.EmptyRepo("docker.com/notary")
+	assert.NoError(t, err)
+
+	tsSigned, err := repo.SignTimestamp(data.DefaultExpires("timestamp"))
+	assert.NoError(t, err)
+	ts, err := json.Marshal(tsSigned)
+	assert.NoError(t, err)
+
+	tempDir, err := ioutil.TempDir("", "timestamp-age")
+	assert.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+	localStorage, err := store.NewFilesystemStore(tempDir, "metadata", "json")
+	assert.NoError(t, err)
+	assert.NoError(t, localStorage.SetMeta(data.CanonicalTimestampRole, ts))
+	makeOld := func() {
+		old := time.Now().Add(-2 * time.Hour)
+		assert.NoError(t, os.Chtimes(filepath.Join(tempDir, "metadata", "timestamp.json"), old, old))
+	}
+
+	remoteStorage := &conditionalStore{
+		RemoteStore: store.NewMemoryStore(map[string][]byte{data.CanonicalTimestampRole: ts}),
+	}
+	client := NewClient(repo, remoteStorage, localStorage)
+	client.MaxTimestampAge = time.Hour
+
+	assert.NoError(t, client.downloadTimestamp())
+	assert.Equal(t, 1, remoteStorage.notModified)
+	assert.Equal(t, 0, remoteStorage.sent)
+
+	makeOld()
+	assert.NoError(t, client.downloadTimestamp())
+	assert.Equal(t, 1, remoteStorage.notModified)
+	assert.Equal(t, 1, remoteStorage.sent)
+
+	// the timestamp was fetched again, so it can be used if the remote is offline
+	client = NewClient(repo, store.OfflineStore{}, localStorage)
+	client.MaxTimestampAge = time.Hour
+	assert.NoError(t, client.downloadTimestamp())
+
+	makeOld()
+	err = client.downloadTimestamp()
+	assert.Error(t, err)
+	assert.IsType(t, ErrStaleTimestamp{}, err)
+
+	client.MaxTimestampAge = 0
+	assert.NoError(t, client.downloadTimestamp())
+
+	// the memory store can't tell how old its timestamp is
+	client = NewClient(repo, store.OfflineStore{},
+		store.NewMemoryStore(map[string][]byte{data.CanonicalTimestampRole: ts}))
+	client.MaxTimestampAge = time.Hour
+	err = client.downloadTimestamp()
+	assert.Error(t, err)
+	assert.IsType(t, ErrStaleTimestamp{}, err)
+}
+
+// With NoCache, the timestamp, snapshot and targets are downloaded again even
+// though the cached copies are valid, and the cached timestamp is not used if
+// the remote can't be reached
+func TestNoCacheDownloadsEverything(t *testing.T) {
+	repo, _, err := testutils.EmptyRepo("docker.com/notary")
+	assert.NoError(t, err)
+
+	localStorage := store.NewMemoryStore(nil)
+	remoteStorage := &conditionalStore{RemoteStore: store.NewMemoryStore(nil)}
+	tgtsSigned, err := repo.SignTargets(data.CanonicalTargetsRole, data.DefaultExpires("targets"))
+	assert.NoError(t, err)
+	snapSigned, err := repo.SignSnapshot(data.DefaultExpires("snapshot"))
+	assert.NoError(t, err)
+	tsSigned, err := repo.SignTimestamp(data.DefaultExpires("timestamp"))
+	assert.NoError(t, err)
+	for role, s := range map[string]*data.Signed{
+		data.CanonicalTargetsRole:   tgtsSigned,
+		data.CanonicalSnapshotRole:  snapSigned,
+		data.CanonicalTimestampRole: tsSigned,
+	} {
+		meta, err := json.Marshal(s)
+		assert.NoError(t, err)
+		assert.NoError(t, localStorage.SetMeta(role, meta))
+		assert.NoError(t, remoteStorage.SetMeta(role, meta))
+	}
+
+	client := NewClient(repo, remoteStorage, localStorage)
+	client.NoCache = true
+	assert.NoError(t, client.downloadTimestamp())
+	assert.NoError(t, client.downloadSnapshot())
+	assert.NoError(t, client.downloadTargets(data.CanonicalTargetsRole))
+	assert.Equal(t, 3, remoteStorage.sent)
+	assert.Equal(t, 0, remoteStorage.notModified)
+
+	client = NewClient(repo, store.OfflineStore{}, localStorage)
+	assert.NoError(t, client.downloadTimestamp())
+	client.NoCache = true
+	assert.Error(t, client.downloadTimestamp())
+}
+
 func TestDownloadSnapshotHappy(t *testing.T) {
 	repo, _, err := testutils.EmptyRepo("docker.com/notary")
 	assert.NoError(t, err)
@@ -2,6 +2,8 @@ package client
 
 import (
 	"fmt"
+	"strings"
+	"time"
 )
 
 // ErrChecksumMismatch - a checksum failed verification
@@ -22,6 +24,28 @@ func (e ErrMissingMeta) Error() string {
 	return fmt.Sprintf("tuf: sha256 checksum required for %s", e.role)
 }
 
+// ErrStaleTimestamp - the timestamp couldn't be fetched, and the cached one
+// is older than the maximum age allowed
+type ErrStaleTimestamp struct {
+	maxAge time.Duration
+}
+
+func (e ErrStaleTimestamp) Error() string {
+	return fmt.Sprintf(
+		"tuf: could not fetch the timestamp, and the cached timestamp is older than the maximum age of %s", e.maxAge)
+}
+
+// ErrMetaTooLarge - the metadata of a role is larger than the most that is
+// allowed to be downloaded for it
+type ErrMetaTooLarge struct {
+	Role  string
+	Limit int64
+}
+
+func (e ErrMetaTooLarge) Error() string {
+	return fmt.Sprintf("tuf: the %s metadata is larger than the maximum size of %d bytes allowed for it", e.Role, e.Limit)
+}
+
 // ErrCorruptedCache - local data is incorrect
 type ErrCorruptedCache struct {
 	file string
@@ -30,3 +54,34 @@ type ErrCorruptedCache struct {
 func (e ErrCorruptedCache) Error() string {
 	return fmt.Sprintf("cache is corrupted: %s", e.file)
 }
+
+// VerificationFailure is the reason why the metadata of a role was rejected
+type VerificationFailure string
+
+// The reasons why the metadata of a role can be rejected
+const (
+	FailureBadSignature VerificationFailure = "bad signature"
+	FailureExpired      VerificationFailure = "expired metadata"
+	FailureThreshold    VerificationFailure = "threshold not met"
+	FailureKeyNotFound  VerificationFailure = "key not found"
+	FailureInvalid      VerificationFailure = "invalid metadata"
+)
+
+// ErrVerification - the metadata of a targets or delegation role failed
+// verification.  Chain is the chain of roles walked to get to the role, from
+// the targets role down to the role that failed, which is the last one.
+type ErrVerification struct {
+	Chain  []string
+	Reason VerificationFailure
+	Err    error
+}
+
+// Role returns the name of the role that failed verification
+func (e ErrVerification) Role() string {
+	return e.Chain[len(e.Chain)-1]
+}
+
+func (e ErrVerification) Error() string {
+	return fmt.Sprintf("tuf: verification of %s failed (%s) in the role chain %s: %v",
+		e.Role(), e.Reason, strings.Join(e.Chain, " -> "), e.Err)
+}
@@ -1,10 +1,13 @@
 package data
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"path"
 	"regexp"
 	"strings"
+	"time"
 
 	"github.com/Sirupsen/logrus"
 )
@@ -119,7 +122,8 @@ func (b BaseRole) ListKeyIDs() []string {
 // DelegationRole is an internal representation of a delegation role, with its public keys included
 type DelegationRole struct {
 	BaseRole
-	Paths []string
+	Paths            []string
+	PathHashPrefixes []string
 }
 
 func listKeys(keyMap map[string]PublicKey) KeyList {
@@ -150,7 +154,8 @@ func (d DelegationRole) Restrict(child DelegationRole) (DelegationRole, error) {
 			Name:      child.Name,
 			Threshold: child.Threshold,
 		},
-		Paths: RestrictDelegationPathPrefixes(d.Paths, child.Paths),
+		Paths:            RestrictDelegationPathPrefixes(d.Paths, child.Paths),
+		PathHashPrefixes: RestrictDelegationPathPrefixes(d.PathHashPrefixes, child.PathHashPrefixes),
 	}, nil
 }
 
@@ -163,18 +168,43 @@ func (d DelegationRole) IsParentOf(child DelegationRole) bool {
 
 // CheckPaths checks if a given path is valid for the role
 func (d DelegationRole) CheckPaths(path string) bool {
-	return checkPaths(path, d.Paths)
+	return checkPaths(path, d.Paths, d.PathHashPrefixes)
 }
 
-func checkPaths(path string, permitted []string) bool {
+// checkPaths is whether a path starts with one of the permitted paths, or the
+// hash of the path starts with one of the permitted path hash prefixes
+func checkPaths(path string, permitted, hashPrefixes []string) bool {
 	for _, p := range permitted {
 		if strings.HasPrefix(path, p) {
 			return true
 		}
 	}
+	if len(hashPrefixes) == 0 {
+		return false
+	}
+	pathHex := PathHex(path)
+	for _, p := range hashPrefixes {
+		if strings.HasPrefix(pathHex, p) {
+			return true
+		}
+	}
 	return false
 }
 
+// PathHex is the hex encoded SHA256 digest of a target path, which the path
+// hash prefixes of a role are matched against.  Since it is the full digest,
+// a role with it as a path hash prefix can sign that exact path only.
+func PathHex(path string) string {
+	digest := sha256.Sum256([]byte(path))
+	return hex.EncodeToString(digest[:])
+}
+
+// IsExactPathHash is whether a path hash prefix is a whole digest, as made by
+// PathHex, which matches a single path
+func IsExactPathHash(prefix string) bool {
+	return len(prefix) == hex.EncodedLen(sha256.Size)
+}
+
 // RestrictDelegationPathPrefixes returns the list of valid delegationPaths that are prefixed by parentPaths
 func RestrictDelegationPathPrefixes(parentPaths, delegationPaths []string) []string {
 	validPaths := []string{}
@@ -212,6 +242,15 @@ type Role struct {
 	RootRole
 	Name  string   `json:"name"`
 	Paths []string `json:"paths,omitempty"`
+	// PathHashPrefixes are matched against the hash of target paths, as
+	// computed by PathHex, rather than the paths themselves
+	PathHashPrefixes []string `json:"path_hash_prefixes,omitempty"`
+	// ValidUntil is when a delegation stops being trusted, if it was given
+	// an expiry when it was added
+	ValidUntil *time.Time `json:"valid_until,omitempty"`
+	// PathNotes document why paths are delegated, keyed by path.  They are
+	// only informational, and play no part in which targets the role can sign.
+	PathNotes map[string]string `json:"path_notes,omitempty"`
 }
 
 // NewRole creates a new Role object from the given parameters
@@ -240,7 +279,13 @@ func NewRole(name string, threshold int, keyIDs, paths []string) (*Role, error)
 
 // CheckPaths checks if a given path is valid for the role
 func (r Role) CheckPaths(path string) bool {
-	return checkPaths(path, r.Paths)
+	return checkPaths(path, r.Paths, r.PathHashPrefixes)
+}
+
+// ValidAt checks whether the role is still trusted at the given time, which
+// is always the case for roles without an expiry
+func (r Role) ValidAt(t time.Time) bool {
+	return r.ValidUntil == nil || t.Before(*r.ValidUntil)
 }
 
 // AddKeys merges the ids into the current list of role key ids
@@ -257,14 +302,60 @@ func (r *Role) AddPaths(paths []string) error {
 	return nil
 }
 
+// AddPathHashPrefixes merges the path hash prefixes into the current list of
+// role path hash prefixes
+func (r *Role) AddPathHashPrefixes(prefixes []string) {
+	if len(prefixes) == 0 {
+		return
+	}
+	r.PathHashPrefixes = mergeStrSlices(r.PathHashPrefixes, prefixes)
+}
+
+// AddPathNotes merges the notes into the current notes of the role paths,
+// replacing the notes the paths already had
+func (r *Role) AddPathNotes(notes map[string]string) {
+	if len(notes) == 0 {
+		return
+	}
+	// the map may be shared with copies of the role, so it is not changed
+	merged := make(map[string]string, len(r.PathNotes)+len(notes))
+	for p, note := range r.PathNotes {
+		merged[p] = note
+	}
+	for p, note := range notes {
+		merged[p] = note
+	}
+	r.PathNotes = merged
+}
+
 // RemoveKeys removes the ids from the current list of key ids
 func (r *Role) RemoveKeys(ids []string) {
 	r.KeyIDs = subtractStrSlices(r.KeyIDs, ids)
 }
 
-// RemovePaths removes the paths from the current list of role paths
+// RemovePaths removes the paths from the current list of role paths, along
+// with their notes
 func (r *Role) RemovePaths(paths []string) {
 	r.Paths = subtractStrSlices(r.Paths, paths)
+	if len(r.PathNotes) == 0 {
+		return
+	}
+	notes := make(map[string]string, len(r.PathNotes))
+	for _, p := range r.Paths {
+		if note, ok := r.PathNotes[p]; ok {
+			notes[p] = note
+		}
+	}
+	if len(notes) == 0 {
+		notes = nil
+	}
+	r.PathNotes = notes
+}
+
+// RemovePathHashPrefixes removes the path hash prefixes from the current list
+// of role path hash prefixes
+func (r *Role) RemovePathHashPrefixes(prefixes []string) {
+	r.PathHashPrefixes = subtractStrSlices(r.PathHashPrefixes, prefixes)
 }
 
 func mergeStrSlices(orig, new []string) []string {
@@ -94,6 +94,59 @@ func TestAddPathNil(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestAddRemovePathHashPrefixes(t *testing.T) {
+	role, err := NewRole("targets/a", 1, []string{"abc"}, nil)
+	assert.NoError(t, err)
+	role.AddPathHashPrefixes([]string{"12"})
+	role.AddPathHashPrefixes([]string{"12", "34"})
+	assert.Equal(t, []string{"12", "34"}, role.PathHashPrefixes)
+	role.RemovePathHashPrefixes([]string{"12"})
+	assert.Equal(t, []string{"34"}, role.PathHashPrefixes)
+}
+
+// Notes on paths replace the earlier notes of the same paths, and are removed
+// along with their paths, without the copies of the role being changed
+func TestPathNotes(t *testing.T) {
+	role, err := NewRole("targets/a", 1, []string{"abc"}, []string{"docs/", "releases/"})
+	assert.NoError(t, err)
+	role.AddPathNotes(map[string]string{"docs/": "docs team", "releases/": "release team"})
+	copied := *role
+	role.AddPathNotes(map[string]string{"docs/": "doc writers"})
+	assert.Equal(t, map[string]string{"docs/": "doc writers", "releases/": "release team"}, role.PathNotes)
+	assert.Equal(t, "docs team", copied.PathNotes["docs/"])
+
+	role.RemovePaths([]string{"docs/"})
+	assert.Equal(t, map[string]string{"releases/": "release team"}, role.PathNotes)
+	role.RemovePaths(role.Paths)
+	assert.Nil(t, role.PathNotes)
+}
+
+// A path hash prefix that is a whole digest matches its exact path only,
+// while paths keep matching every path they are a prefix of
+func TestCheckPathHashPrefixes(t *testing.T) {
+	exact := PathHex("releases/v1")
+	assert.True(t, IsExactPathHash(exact))
+	assert.False(t, IsExactPathHash(exact[:8]))
+
+	role, err := NewRole("targets/a", 1, []string{"abc"}, []string{"docs/"})
+	assert.NoError(t, err)
+	role.AddPathHashPrefixes([]string{exact})
+	assert.True(t, role.CheckPaths("releases/v1"))
+	assert.False(t, role.CheckPaths("releases/v1.1"))
+	assert.False(t, role.CheckPaths("releases/v"))
+	assert.True(t, role.CheckPaths("docs/index"))
+
+	delgRole := DelegationRole{PathHashPrefixes: []string{exact[:1]}}
+	assert.True(t, delgRole.CheckPaths("releases/v1"))
+
+	// a child keeps only the path hash prefixes within those of its parent
+	parent := DelegationRole{BaseRole: BaseRole{Name: "targets/a"}, PathHashPrefixes: []string{exact[:2]}}
+	child := DelegationRole{BaseRole: BaseRole{Name: "targets/a/b"}, PathHashPrefixes: []string{exact, "zz"}}
+	restricted, err := parent.Restrict(child)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{exact}, restricted.PathHashPrefixes)
+}
+
 func TestErrNoSuchRole(t *testing.T) {
 	var err error = ErrNoSuchRole{Role: "test"}
 	assert.True(t, strings.HasSuffix(err.Error(), "test"))
@@ -119,11 +119,12 @@ type Files map[string]FileMeta
 type Hashes map[string][]byte
 
 // FileMeta contains the size and hashes for a metadata or target file. Custom
-// data can be optionally added.
+// data can be optionally added.  It is a pointer because a RawMessage is only
+// marshalled as is when it is addressable, which FileMetas in maps are not.
 type FileMeta struct {
-	Length int64           `json:"length"`
-	Hashes Hashes          `json:"hashes"`
-	Custom json.RawMessage `json:"custom,omitempty"`
+	Length int64            `json:"length"`
+	Hashes Hashes           `json:"hashes"`
+	Custom *json.RawMessage `json:"custom,omitempty"`
 }
 
 // NewFileMeta generates a FileMeta object from the reader, using the
@@ -36,8 +36,13 @@ func (e ErrLowVersion) Error() string {
 	return fmt.Sprintf("version %d is lower than current version %d", e.Actual, e.Current)
 }
 
-// ErrRoleThreshold indicates we did not validate enough signatures to meet the threshold
-type ErrRoleThreshold struct{}
+// ErrRoleThreshold indicates we did not validate enough signatures to meet the threshold.
+// When returned by VerifySignatures, it lists the keys of the role whose signatures
+// were invalid, and the keys of signatures that aren't keys of the role.
+type ErrRoleThreshold struct {
+	BadSignatures []string
+	UnknownKeys   []string
+}
 
 func (e ErrRoleThreshold) Error() string {
 	return "valid signatures did not meet threshold"
@@ -70,3 +75,14 @@ func (e ErrNoKeys) Error() string {
 	return fmt.Sprintf("could not find necessary signing keys, at least one of these keys must be available: %s",
 		strings.Join(e.KeyIDs, ", "))
 }
+
+// ErrInvalidSigningKey indicates a key that was explicitly requested for
+// signing is not one of the keys of the role being signed
+type ErrInvalidSigningKey struct {
+	Role  string
+	KeyID string
+}
+
+func (e ErrInvalidSigningKey) Error() string {
+	return fmt.Sprintf("key %s is not a valid signing key for role %s", e.KeyID, e.Role)
+}
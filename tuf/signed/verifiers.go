@@ -10,6 +10,7 @@ import (
 	"fmt"
 	"math/big"
 	"reflect"
+	"sort"
 
 	"github.com/Sirupsen/logrus"
 	"github.com/agl/ed25519"
@@ -32,6 +33,17 @@ var Verifiers = map[data.SigAlgorithm]Verifier{
 	data.EDDSASignature:       Ed25519Verifier{},
 }
 
+// SupportedSignatureAlgorithms returns the sorted names of the signature
+// algorithms that have a registered verifier
+func SupportedSignatureAlgorithms() []string {
+	algorithms := make([]string, 0, len(Verifiers))
+	for algorithm := range Verifiers {
+		algorithms = append(algorithms, string(algorithm))
+	}
+	sort.Strings(algorithms)
+	return algorithms
+}
+
 // RegisterVerifier provides a convenience function for init() functions
 // to register additional verifiers or replace existing ones.
 func RegisterVerifier(algorithm data.SigAlgorithm, v Verifier) {
@@ -23,6 +23,12 @@ var (
 // VerifyRoot checks if a given root file is valid against a known set of keys.
 // Threshold is always assumed to be 1
 func VerifyRoot(s *data.Signed, minVersion int, keys map[string]data.PublicKey) error {
+	return VerifyRootAt(s, minVersion, keys, time.Now())
+}
+
+// VerifyRootAt is VerifyRoot, checking the expiry of the root as of the time
+// now rather than the present time
+func VerifyRootAt(s *data.Signed, minVersion int, keys map[string]data.PublicKey, now time.Time) error {
 	if len(s.Signatures) == 0 {
 		return ErrNoSignatures
 	}
@@ -56,7 +62,7 @@ func VerifyRoot(s *data.Signed, minVersion int, keys map[string]data.PublicKey)
 			continue
 		}
 		// threshold of 1 so return on first success
-		return verifyMeta(s, data.CanonicalRootRole, minVersion)
+		return verifyMeta(s, data.CanonicalRootRole, minVersion, now)
 	}
 	return ErrRoleThreshold{}
 }
@@ -64,13 +70,19 @@ func VerifyRoot(s *data.Signed, minVersion int, keys map[string]data.PublicKey)
 // Verify checks the signatures and metadata (expiry, version) for the signed role
 // data
 func Verify(s *data.Signed, role data.BaseRole, minVersion int) error {
-	if err := verifyMeta(s, role.Name, minVersion); err != nil {
+	return VerifyAt(s, role, minVersion, time.Now())
+}
+
+// VerifyAt is Verify, checking the expiry of the metadata as of the time now
+// rather than the present time
+func VerifyAt(s *data.Signed, role data.BaseRole, minVersion int, now time.Time) error {
+	if err := verifyMeta(s, role.Name, minVersion, now); err != nil {
 		return err
 	}
 	return VerifySignatures(s, role)
 }
 
-func verifyMeta(s *data.Signed, role string, minVersion int) error {
+func verifyMeta(s *data.Signed, role string, minVersion int, now time.Time) error {
 	sm := &data.SignedCommon{}
 	if err := json.Unmarshal(s.Signed, sm); err != nil {
 		return err
@@ -78,7 +90,7 @@ func verifyMeta(s *data.Signed, role string, minVersion int) error {
 	if !data.ValidTUFType(sm.Type, role) {
 		return ErrWrongType
 	}
-	if IsExpired(sm.Expires) {
+	if sm.Expires.Before(now) {
 		logrus.Errorf("Metadata for %s expired", role)
 		return ErrExpired{Role: role, Expired: sm.Expires.Format("Mon Jan 2 15:04:05 MST 2006")}
 	}
@@ -117,11 +129,13 @@ func VerifySignatures(s *data.Signed, roleData data.BaseRole) error {
 	}
 
 	valid := make(map[string]struct{})
+	var bad, unknown []string
 	for _, sig := range s.Signatures {
 		logrus.Debug("verifying signature for key ID: ", sig.KeyID)
 		key, ok := roleData.Keys[sig.KeyID]
 		if !ok {
 			logrus.Debugf("continuing b/c keyid lookup was nil: %s\n", sig.KeyID)
+			unknown = append(unknown, sig.KeyID)
 			continue
 		}
 		// method lookup is consistent due to Unmarshal JSON doing lower case for us.
@@ -134,13 +148,14 @@ func VerifySignatures(s *data.Signed, roleData data.BaseRole) error {
 
 		if err := verifier.Verify(key, sig.Signature, msg); err != nil {
 			logrus.Debugf("continuing b/c signature was invalid\n")
+			bad = append(bad, sig.KeyID)
 			continue
 		}
 		valid[sig.KeyID] = struct{}{}
 
 	}
 	if len(valid) < roleData.Threshold {
-		return ErrRoleThreshold{}
+		return ErrRoleThreshold{BadSignatures: bad, UnknownKeys: unknown}
 	}
 
 	return nil
@@ -192,3 +192,23 @@ func assertErrExpired(t *testing.T, err error, expected ErrExpired) {
 	}
 	assert.Equal(t, actual.Expired, expected.Expired)
 }
+
+func TestVerifyAt(t *testing.T) {
+	cryptoService := NewEd25519()
+	k, err := cryptoService.Create("root", data.ED25519Key)
+	assert.NoError(t, err)
+	role := data.BaseRole{Name: "root", Keys: data.Keys{k.ID(): k}, Threshold: 1}
+
+	expires := time.Now().Add(-time.Hour)
+	meta := &data.SignedCommon{Type: data.TUFTypes["root"], Version: 1, Expires: expires}
+	b, err := json.MarshalCanonical(meta)
+	assert.NoError(t, err)
+	s := &data.Signed{Signed: b}
+	assert.NoError(t, Sign(cryptoService, s, k))
+
+	assert.Error(t, Verify(s, role, 1))
+	assert.NoError(t, VerifyAt(s, role, 1, expires.Add(-time.Minute)))
+	assertErrExpired(t, VerifyAt(s, role, 1, expires.Add(time.Minute)),
+		ErrExpired{"root", expires.Format("Mon Jan 2 15:04:05 MST 2006")})
+	assert.NoError(t, VerifyRootAt(s, 1, role.Keys, expires.Add(-time.Minute)))
+}
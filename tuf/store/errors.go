@@ -11,3 +11,13 @@ type ErrMetaNotFound struct {
 func (err ErrMetaNotFound) Error() string {
 	return fmt.Sprintf("%s trust data unavailable.  Has a notary repository been initialized?", err.Resource)
 }
+
+// ErrMetaNotModified indicates that a particular piece of metadata in the
+// store is the same as the copy the caller already has
+type ErrMetaNotModified struct {
+	Resource string
+}
+
+func (err ErrMetaNotModified) Error() string {
+	return fmt.Sprintf("%s trust data has not been modified", err.Resource)
+}
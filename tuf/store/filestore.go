@@ -7,6 +7,7 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"time"
 )
 
 // NewFilesystemStore creates a new store in a directory tree
@@ -58,6 +59,19 @@ func (f *FilesystemStore) GetMeta(name string, size int64) ([]byte, error) {
 	return meta[:size], nil
 }
 
+// GetMetaModTime returns when the meta for the given name (a role) was last
+// written
+func (f *FilesystemStore) GetMetaModTime(name string) (time.Time, error) {
+	info, err := os.Stat(f.getPath(name))
+	if err != nil {
+		if os.IsNotExist(err) {
+			err = ErrMetaNotFound{Resource: name}
+		}
+		return time.Time{}, err
+	}
+	return info.ModTime(), nil
+}
+
 // SetMultiMeta sets the metadata for multiple roles in one operation
 func (f *FilesystemStore) SetMultiMeta(metas map[string][]byte) error {
 	for role, blob := range metas {
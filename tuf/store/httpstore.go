@@ -12,6 +12,8 @@ package store
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -107,7 +109,8 @@ func tryUnmarshalError(resp *http.Response, defaultError error) error {
 	}
 	var parsedErrors struct {
 		Errors []struct {
-			Detail validation.SerializableError `json:"detail"`
+			Message string          `json:"message"`
+			Detail  json.RawMessage `json:"detail"`
 		} `json:"errors"`
 	}
 	if err := json.Unmarshal(bodyBytes, &parsedErrors); err != nil {
@@ -116,11 +119,19 @@ func tryUnmarshalError(resp *http.Response, defaultError error) error {
 	if len(parsedErrors.Errors) != 1 {
 		return defaultError
 	}
-	err = parsedErrors.Errors[0].Detail.Error
-	if err == nil {
-		return defaultError
+	parsed := parsedErrors.Errors[0]
+
+	var detail validation.SerializableError
+	if err := json.Unmarshal(parsed.Detail, &detail); err == nil && detail.Error != nil {
+		return detail.Error
+	}
+	// some rejections, such as of an invalid role, only name the role that
+	// was rejected as the detail
+	var role string
+	if err := json.Unmarshal(parsed.Detail, &role); err == nil && role != "" {
+		return ErrInvalidOperation{msg: fmt.Sprintf("%s: %s", parsed.Message, role)}
 	}
-	return err
+	return defaultError
 }
 
 func translateStatusToError(resp *http.Response, resource string) error {
@@ -141,6 +152,17 @@ func translateStatusToError(resp *http.Response, resource string) error {
 // not an exact length.
 // If size is -1, this corresponds to "infinite," but we cut off at 100MB
 func (s HTTPStore) GetMeta(name string, size int64) ([]byte, error) {
+	return s.getMeta(name, size, "")
+}
+
+// GetMetaIfModified is like GetMeta, but sends the ETag of the cached copy
+// of the named meta file, so that if the server's copy is the same it
+// returns ErrMetaNotModified instead of sending the file again.
+func (s HTTPStore) GetMetaIfModified(name string, size int64, cached []byte) ([]byte, error) {
+	return s.getMeta(name, size, ETag(cached))
+}
+
+func (s HTTPStore) getMeta(name string, size int64, etag string) ([]byte, error) {
 	url, err := s.buildMetaURL(name)
 	if err != nil {
 		return nil, err
@@ -149,11 +171,18 @@ func (s HTTPStore) GetMeta(name string, size int64) ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
 	resp, err := s.roundTrip.RoundTrip(req)
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
+	if etag != "" && resp.StatusCode == http.StatusNotModified {
+		logrus.Debugf("%s has not been modified", name)
+		return nil, ErrMetaNotModified{Resource: name}
+	}
 	if err := translateStatusToError(resp, name); err != nil {
 		logrus.Debugf("received HTTP status %d when requesting %s.", resp.StatusCode, name)
 		return nil, err
@@ -173,6 +202,13 @@ func (s HTTPStore) GetMeta(name string, size int64) ([]byte, error) {
 	return body, nil
 }
 
+// ETag returns the HTTP entity tag of a piece of metadata, which is the quoted
+// hex encoded sha256 of it
+func ETag(meta []byte) string {
+	digest := sha256.Sum256(meta)
+	return fmt.Sprintf("%q", hex.EncodeToString(digest[:]))
+}
+
 // SetMeta uploads a piece of TUF metadata to the server
 func (s HTTPStore) SetMeta(name string, blob []byte) error {
 	url, err := s.buildMetaURL("")
@@ -242,9 +278,23 @@ func (s HTTPStore) SetMultiMeta(metas map[string][]byte) error {
 	return translateStatusToError(resp, "POST metadata endpoint")
 }
 
-// RemoveAll in the interface is not supported, admins should use the DeleteHandler endpoint directly to delete remote data for a GUN
+// RemoveAll deletes all of the metadata for the GUN from the server, using the
+// server's DELETE endpoint
 func (s HTTPStore) RemoveAll() error {
-	return errors.New("remove all functionality not supported for HTTPStore")
+	url, err := s.buildMetaURL("")
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("DELETE", url.String(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := s.roundTrip.RoundTrip(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return translateStatusToError(resp, "DELETE metadata endpoint")
 }
 
 func (s HTTPStore) buildMetaURL(name string) (*url.URL, error) {
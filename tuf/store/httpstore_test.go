@@ -79,6 +79,37 @@ func TestHTTPStoreGetMeta(t *testing.T) {
 
 }
 
+// GetMetaIfModified sends the ETag of the cached copy, and returns
+// ErrMetaNotModified if the server says its copy is the same
+func TestHTTPStoreGetMetaIfModified(t *testing.T) {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == ETag([]byte(testRoot)) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Write([]byte(testRoot))
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+	remote, err := NewHTTPStore(server.URL, "metadata", "txt", "key", &http.Transport{})
+	assert.NoError(t, err)
+	store, ok := remote.(ConditionalRemoteStore)
+	assert.True(t, ok, "HTTPStore should support conditional requests")
+
+	_, err = store.GetMetaIfModified("root", 4801, []byte(testRoot))
+	assert.Error(t, err)
+	assert.IsType(t, ErrMetaNotModified{}, err)
+
+	j, err := store.GetMetaIfModified("root", 4801, []byte("{}"))
+	assert.NoError(t, err)
+	assert.Equal(t, testRoot, string(j))
+
+	// GetMeta never asks the server for only modified metadata
+	j, err = store.GetMeta("root", 4801)
+	assert.NoError(t, err)
+	assert.Equal(t, testRoot, string(j))
+}
+
 // Test that passing -1 to httpstore's GetMeta will return all content
 func TestHTTPStoreGetAllMeta(t *testing.T) {
 	handler := func(w http.ResponseWriter, r *http.Request) {
@@ -292,20 +323,43 @@ func TestTranslateErrorsWhenCannotParse400(t *testing.T) {
 	}
 }
 
+// If it's a 400 whose detail is only the name of the rejected role, the
+// InvalidOperation names the role
+func TestTranslateErrorsParse400RoleDetail(t *testing.T) {
+	errorBody := bytes.NewBuffer([]byte(
+		`{"errors": [{"code": "INVALID_ROLE", "message": "invalid role", "detail": "targets/a"}]}`))
+	errorResp := http.Response{
+		StatusCode: http.StatusBadRequest,
+		Body:       ioutil.NopCloser(errorBody),
+	}
+
+	err := translateStatusToError(&errorResp, "")
+	assert.IsType(t, ErrInvalidOperation{}, err)
+	assert.Contains(t, err.Error(), "targets/a")
+}
+
+// RemoveAll sends a DELETE to the base metadata URL of the GUN, and fails if
+// the server does not accept it
 func TestHTTPStoreRemoveAll(t *testing.T) {
-	// Set up a simple handler and server for our store
+	var (
+		method, path string
+		status       = http.StatusOK
+	)
 	handler := func(w http.ResponseWriter, r *http.Request) {
-		w.Write([]byte(testRoot))
+		method, path = r.Method, r.URL.Path
+		w.WriteHeader(status)
 	}
 	server := httptest.NewServer(http.HandlerFunc(handler))
 	defer server.Close()
-	store, err := NewHTTPStore(server.URL, "metadata", "json", "key", http.DefaultTransport)
+	store, err := NewHTTPStore(server.URL+"/v2/docker.com/notary/_trust/tuf/", "", "json", "key", http.DefaultTransport)
 	assert.NoError(t, err)
 
-	// currently unsupported since there is no use case
-	// check for the error
-	err = store.RemoveAll()
-	assert.Error(t, err)
+	assert.NoError(t, store.RemoveAll())
+	assert.Equal(t, "DELETE", method)
+	assert.Equal(t, "/v2/docker.com/notary/_trust/tuf/", path)
+
+	status = http.StatusUnauthorized
+	assert.Error(t, store.RemoveAll())
 }
 
 func TestHTTPOffline(t *testing.T) {
@@ -1,5 +1,7 @@
 package store
 
+import "time"
+
 // MetadataStore must be implemented by anything that intends to interact
 // with a store of TUF files
 type MetadataStore interface {
@@ -26,3 +28,18 @@ type RemoteStore interface {
 	MetadataStore
 	PublicKeyStore
 }
+
+// ConditionalRemoteStore is a RemoteStore that can avoid downloading a piece
+// of metadata again if it is the same as a copy the caller already has.
+// GetMetaIfModified returns ErrMetaNotModified in that case.
+type ConditionalRemoteStore interface {
+	RemoteStore
+	GetMetaIfModified(name string, size int64, cached []byte) ([]byte, error)
+}
+
+// ModTimeStore is a MetadataStore that can also tell when a piece of metadata
+// was last written to it, so that the age of cached metadata can be checked
+type ModTimeStore interface {
+	MetadataStore
+	GetMetaModTime(name string) (time.Time, error)
+}
@@ -0,0 +1,186 @@
+package testutils
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/big"
+	"time"
+
+	"github.com/docker/notary/trustmanager"
+	"github.com/docker/notary/tuf/data"
+)
+
+// The helpers in this file generate the same keys and certificates every time
+// they are given the same seed, so that tests can compare them against golden
+// files instead of regenerating their fixtures.  They are FOR TESTING ONLY:
+// anyone who knows the seed knows the private key, so they must never be used
+// for keys that protect anything.
+
+// seededReader is an endless stream of bytes derived from a seed, as the
+// SHA-256 of the seed followed by a counter, for each block of 32 bytes
+type seededReader struct {
+	seed    []byte
+	counter uint64
+	block   []byte
+}
+
+// NewSeededReader returns a reader of an endless, reproducible stream of
+// bytes derived from the seed.  FOR TESTING ONLY, since the bytes are
+// entirely predictable from the seed.
+func NewSeededReader(seed []byte) io.Reader {
+	return &seededReader{seed: append([]byte(nil), seed...)}
+}
+
+func (r *seededReader) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		if len(r.block) == 0 {
+			var counter [8]byte
+			binary.BigEndian.PutUint64(counter[:], r.counter)
+			r.counter++
+			block := sha256.Sum256(append(append([]byte(nil), r.seed...), counter[:]...))
+			r.block = block[:]
+		}
+		copied := copy(p[n:], r.block)
+		r.block = r.block[copied:]
+		n += copied
+	}
+	return n, nil
+}
+
+// GenerateSeededKey generates a private key of the given algorithm entirely
+// from the bytes read from seed, which should be a reader returned by
+// NewSeededReader for the key to be reproducible.  Only ECDSA and ED25519
+// keys can be generated this way, since crypto/ecdsa and crypto/rsa may mix
+// in randomness of their own.  FOR TESTING ONLY.
+func GenerateSeededKey(algorithm string, seed io.Reader) (data.PrivateKey, error) {
+	switch algorithm {
+	case data.ECDSAKey:
+		ecdsaPrivKey, err := seededECDSAKey(elliptic.P256(), seed)
+		if err != nil {
+			return nil, err
+		}
+		return trustmanager.ECDSAToPrivateKey(ecdsaPrivKey)
+	case data.ED25519Key:
+		return trustmanager.GenerateED25519Key(seed)
+	default:
+		return nil, fmt.Errorf("keys of type %s cannot be generated from a seed", algorithm)
+	}
+}
+
+// seededECDSAKey derives a private key from the bytes read from seed, the
+// way FIPS 186-4 (B.4.1) derives one from random bits
+func seededECDSAKey(curve elliptic.Curve, seed io.Reader) (*ecdsa.PrivateKey, error) {
+	d, err := seededScalar(curve, seed)
+	if err != nil {
+		return nil, err
+	}
+	priv := &ecdsa.PrivateKey{D: d}
+	priv.PublicKey.Curve = curve
+	priv.PublicKey.X, priv.PublicKey.Y = curve.ScalarBaseMult(d.Bytes())
+	return priv, nil
+}
+
+// seededScalar reads a number between 1 and the order of the curve minus 1
+// from seed
+func seededScalar(curve elliptic.Curve, seed io.Reader) (*big.Int, error) {
+	params := curve.Params()
+	b := make([]byte, params.BitSize/8+8)
+	if _, err := io.ReadFull(seed, b); err != nil {
+		return nil, err
+	}
+	k := new(big.Int).SetBytes(b)
+	n := new(big.Int).Sub(params.N, big.NewInt(1))
+	k.Mod(k, n)
+	return k.Add(k, big.NewInt(1)), nil
+}
+
+// GenerateSeededCertificate generates a self-signed X509 certificate for a
+// key, like cryptoservice.GenerateCertificate, but with its serial number and
+// signature taken from seed instead of being random, so that the same key,
+// GUN, validity interval and seed always give the same certificate.  FOR
+// TESTING ONLY.
+func GenerateSeededCertificate(key data.PrivateKey, gun string, startTime, endTime time.Time, seed io.Reader) (*x509.Certificate, error) {
+	signer := key.CryptoSigner()
+	if signer == nil {
+		return nil, fmt.Errorf("key type not supported for Certificate generation: %s", key.Algorithm())
+	}
+	if ecdsaPrivKey, ok := signer.(*ecdsa.PrivateKey); ok {
+		signer = seededECDSASigner{PrivateKey: ecdsaPrivKey, seed: seed}
+	}
+
+	template, err := trustmanager.NewCertificate(gun, startTime, endTime)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the certificate template for: %s (%v)", gun, err)
+	}
+	serial := make([]byte, 16)
+	if _, err := io.ReadFull(seed, serial); err != nil {
+		return nil, err
+	}
+	template.SerialNumber = new(big.Int).SetBytes(serial)
+
+	derBytes, err := x509.CreateCertificate(seed, template, template, signer.Public(), signer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the certificate for: %s (%v)", gun, err)
+	}
+	return x509.ParseCertificate(derBytes)
+}
+
+// seededECDSASigner signs with an ECDSA key using nonces read from seed, since
+// crypto/ecdsa may mix in randomness of its own
+type seededECDSASigner struct {
+	*ecdsa.PrivateKey
+	seed io.Reader
+}
+
+type ecdsaSignature struct {
+	R, S *big.Int
+}
+
+// Sign signs the digest with a nonce read from the seed, ignoring rand, and
+// returns the ASN.1 encoded signature that crypto.Signer requires
+func (s seededECDSASigner) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	params := s.Curve.Params()
+	e := hashToInt(digest, params.N)
+	for {
+		k, err := seededScalar(s.Curve, s.seed)
+		if err != nil {
+			return nil, err
+		}
+		x, _ := s.Curve.ScalarBaseMult(k.Bytes())
+		r := new(big.Int).Mod(x, params.N)
+		if r.Sign() == 0 {
+			continue
+		}
+		// s = k^-1 (e + r*d) mod N
+		sig := new(big.Int).Mul(r, s.D)
+		sig.Add(sig, e)
+		sig.Mul(sig, new(big.Int).ModInverse(k, params.N))
+		sig.Mod(sig, params.N)
+		if sig.Sign() == 0 {
+			continue
+		}
+		return asn1.Marshal(ecdsaSignature{R: r, S: sig})
+	}
+}
+
+// hashToInt converts a digest to a number no longer than the order of the
+// curve, as described in SEC 1 (4.1.3)
+func hashToInt(digest []byte, n *big.Int) *big.Int {
+	orderBytes := (n.BitLen() + 7) / 8
+	if len(digest) > orderBytes {
+		digest = digest[:orderBytes]
+	}
+	e := new(big.Int).SetBytes(digest)
+	if excess := len(digest)*8 - n.BitLen(); excess > 0 {
+		e.Rsh(e, uint(excess))
+	}
+	return e
+}
@@ -0,0 +1,86 @@
+package testutils
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/docker/notary/trustmanager"
+	"github.com/docker/notary/tuf/data"
+	"github.com/docker/notary/tuf/signed"
+	"github.com/docker/notary/tuf/utils"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSeededReader(t *testing.T) {
+	read := func(seed string, sizes ...int) []byte {
+		r := NewSeededReader([]byte(seed))
+		var all []byte
+		for _, size := range sizes {
+			b := make([]byte, size)
+			_, err := io.ReadFull(r, b)
+			require.NoError(t, err)
+			all = append(all, b...)
+		}
+		return all
+	}
+	// the stream is the same however it is read
+	require.Equal(t, read("seed", 100), read("seed", 1, 31, 33, 35))
+	require.NotEqual(t, read("seed", 100), read("other seed", 100))
+}
+
+// The same seed always gives the same key, which can sign like any other
+func TestGenerateSeededKey(t *testing.T) {
+	for _, algorithm := range []string{data.ECDSAKey, data.ED25519Key} {
+		key, err := GenerateSeededKey(algorithm, NewSeededReader([]byte("seed")))
+		require.NoError(t, err)
+		require.Equal(t, algorithm, key.Algorithm())
+
+		again, err := GenerateSeededKey(algorithm, NewSeededReader([]byte("seed")))
+		require.NoError(t, err)
+		require.Equal(t, key.ID(), again.ID())
+		require.Equal(t, key.Private(), again.Private())
+
+		other, err := GenerateSeededKey(algorithm, NewSeededReader([]byte("other seed")))
+		require.NoError(t, err)
+		require.NotEqual(t, key.ID(), other.ID())
+
+		msg := []byte("message")
+		sig, err := key.Sign(rand.Reader, msg, nil)
+		require.NoError(t, err)
+		require.NoError(t, signed.Verifiers[key.SignatureAlgorithm()].Verify(data.PublicKeyFromPrivate(key), sig, msg))
+	}
+
+	_, err := GenerateSeededKey(data.RSAKey, NewSeededReader([]byte("seed")))
+	require.Error(t, err)
+}
+
+// The same key, GUN, validity interval and seed always give the same valid
+// certificate
+func TestGenerateSeededCertificate(t *testing.T) {
+	key, err := GenerateSeededKey(data.ECDSAKey, NewSeededReader([]byte("key")))
+	require.NoError(t, err)
+	start := time.Date(2016, time.January, 1, 0, 0, 0, 0, time.UTC)
+	end := start.AddDate(10, 0, 0)
+
+	cert, err := GenerateSeededCertificate(key, "docker.com/notary", start, end, NewSeededReader([]byte("cert")))
+	require.NoError(t, err)
+	require.NoError(t, cert.CheckSignature(cert.SignatureAlgorithm, cert.RawTBSCertificate, cert.Signature))
+	require.Equal(t, "docker.com/notary", cert.Subject.CommonName)
+	require.True(t, cert.NotAfter.Equal(end))
+
+	again, err := GenerateSeededCertificate(key, "docker.com/notary", start, end, NewSeededReader([]byte("cert")))
+	require.NoError(t, err)
+	require.True(t, bytes.Equal(cert.Raw, again.Raw))
+
+	other, err := GenerateSeededCertificate(key, "docker.com/notary", start, end, NewSeededReader([]byte("other cert")))
+	require.NoError(t, err)
+	require.False(t, bytes.Equal(cert.Raw, other.Raw))
+
+	// the certificate is of the key
+	keyID, err := utils.CanonicalKeyID(trustmanager.CertToKey(cert))
+	require.NoError(t, err)
+	require.Equal(t, key.ID(), keyID)
+}
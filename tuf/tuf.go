@@ -60,6 +60,14 @@ type Repo struct {
 	Snapshot      *data.SignedSnapshot
 	Timestamp     *data.SignedTimestamp
 	cryptoService signed.CryptoService
+
+	// AsOf, if set, is the time delegations are checked for expiry at,
+	// instead of the present time
+	AsOf time.Time
+
+	// externallySigned are the roles that are signed outside of the repo,
+	// which can be changed without a signing key for them
+	externallySigned map[string]bool
 }
 
 // NewRepo initializes a Repo instance with a CryptoService.
@@ -67,12 +75,81 @@ type Repo struct {
 // can be nil.
 func NewRepo(cryptoService signed.CryptoService) *Repo {
 	repo := &Repo{
-		Targets:       make(map[string]*data.SignedTargets),
-		cryptoService: cryptoService,
+		Targets:          make(map[string]*data.SignedTargets),
+		cryptoService:    cryptoService,
+		externallySigned: make(map[string]bool),
 	}
 	return repo
 }
 
+// now is the time delegations are checked for expiry at
+func (tr Repo) now() time.Time {
+	if tr.AsOf.IsZero() {
+		return time.Now()
+	}
+	return tr.AsOf
+}
+
+// SignExternally marks a role as signed outside of the repo, with detached
+// signatures, so that VerifyCanSign allows changes to it even if none of its
+// signing keys are available.
+func (tr *Repo) SignExternally(roleName string) {
+	tr.externallySigned[roleName] = true
+}
+
+// Copy returns a deep copy of the repository's metadata, sharing the same
+// CryptoService, so that the copy can be modified without affecting the
+// original.
+func (tr *Repo) Copy() (*Repo, error) {
+	repo := NewRepo(tr.cryptoService)
+	repo.AsOf = tr.AsOf
+	for role := range tr.externallySigned {
+		repo.externallySigned[role] = true
+	}
+	if tr.Root != nil {
+		root := &data.SignedRoot{}
+		if err := copyMeta(tr.Root, root); err != nil {
+			return nil, err
+		}
+		// the serialized root does not include whether it is dirty
+		root.Dirty = tr.Root.Dirty
+		repo.Root = root
+	}
+	for role, targets := range tr.Targets {
+		t := &data.SignedTargets{}
+		if err := copyMeta(targets, t); err != nil {
+			return nil, err
+		}
+		t.Dirty = targets.Dirty
+		repo.Targets[role] = t
+	}
+	if tr.Snapshot != nil {
+		repo.Snapshot = &data.SignedSnapshot{}
+		if err := copyMeta(tr.Snapshot, repo.Snapshot); err != nil {
+			return nil, err
+		}
+		repo.Snapshot.Dirty = tr.Snapshot.Dirty
+	}
+	if tr.Timestamp != nil {
+		repo.Timestamp = &data.SignedTimestamp{}
+		if err := copyMeta(tr.Timestamp, repo.Timestamp); err != nil {
+			return nil, err
+		}
+		repo.Timestamp.Dirty = tr.Timestamp.Dirty
+	}
+	return repo, nil
+}
+
+// copyMeta deep copies one piece of metadata into another by round tripping
+// it through JSON
+func copyMeta(from, to interface{}) error {
+	b, err := json.Marshal(from)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, to)
+}
+
 // AddBaseKeys is used to add keys to the role in root.json
 func (tr *Repo) AddBaseKeys(role string, keys ...data.PublicKey) error {
 	if tr.Root == nil {
@@ -129,10 +206,10 @@ func (tr *Repo) RemoveBaseKeys(role string, keyIDs ...string) error {
 	// remove keys from specified role
 	for _, k := range keyIDs {
 		toDelete[k] = struct{}{}
-		for _, rk := range tr.Root.Signed.Roles[role].KeyIDs {
-			if k != rk {
-				keep = append(keep, rk)
-			}
+	}
+	for _, rk := range tr.Root.Signed.Roles[role].KeyIDs {
+		if _, ok := toDelete[rk]; !ok {
+			keep = append(keep, rk)
 		}
 	}
 	tr.Root.Signed.Roles[role].KeyIDs = keep
@@ -219,6 +296,7 @@ func (tr *Repo) GetDelegationRole(name string) (data.DelegationRole, error) {
 	// until finding the desired role, or we run out of targets files to search.
 	delegationRoles := signedTargetData.Signed.Delegations.Roles
 	var foundRole *data.Role
+	now := tr.now()
 	for len(delegationRoles) > 0 {
 		delgRole := delegationRoles[0]
 		delegationRoles = delegationRoles[1:]
@@ -230,8 +308,15 @@ func (tr *Repo) GetDelegationRole(name string) (data.DelegationRole, error) {
 		}
 
 		// If the current role is an ancestor of our desired role, add its children
-		// to the queue of roles to investigate.
+		// to the queue of roles to investigate.  Nothing below an ancestor whose
+		// delegation has expired is trusted any longer.
 		if strings.HasPrefix(name, delgRole.Name+"/") {
+			if !delgRole.ValidAt(now) {
+				return data.DelegationRole{}, data.ErrInvalidRole{
+					Role:   name,
+					Reason: fmt.Sprintf("delegation %s expired on %s", delgRole.Name, delgRole.ValidUntil.Format(time.RFC3339)),
+				}
+			}
 			if delegationMeta, ok := tr.Targets[delgRole.Name]; ok {
 				delegationRoles = append(delegationRoles, delegationMeta.Signed.Delegations.Roles...)
 			}
@@ -244,6 +329,12 @@ func (tr *Repo) GetDelegationRole(name string) (data.DelegationRole, error) {
 	if foundRole == nil {
 		return data.DelegationRole{}, data.ErrInvalidRole{Role: name, Reason: "delegation does not exist"}
 	}
+	if !foundRole.ValidAt(now) {
+		return data.DelegationRole{}, data.ErrInvalidRole{
+			Role:   name,
+			Reason: fmt.Sprintf("delegation expired on %s", foundRole.ValidUntil.Format(time.RFC3339)),
+		}
+	}
 
 	pubKeys := make(map[string]data.PublicKey)
 	parentRoleName := path.Dir(foundRole.Name)
@@ -270,7 +361,8 @@ func (tr *Repo) GetDelegationRole(name string) (data.DelegationRole, error) {
 			Keys:      pubKeys,
 			Threshold: foundRole.Threshold,
 		},
-		Paths: foundRole.Paths,
+		Paths:            foundRole.Paths,
+		PathHashPrefixes: foundRole.PathHashPrefixes,
 	}, nil
 }
 
@@ -547,16 +639,17 @@ func (tr Repo) TargetMeta(role, path string) *data.FileMeta {
 }
 
 // TargetDelegations returns a slice of Roles that are valid publishers
-// for the target path provided.
+// for the target path provided.  Delegations that have expired are skipped.
 func (tr Repo) TargetDelegations(role, path, pathHex string) []*data.Role {
 	if pathHex == "" {
 		pathDigest := sha256.Sum256([]byte(path))
 		pathHex = hex.EncodeToString(pathDigest[:])
 	}
 	var roles []*data.Role
+	now := tr.now()
 	if t, ok := tr.Targets[role]; ok {
 		for _, r := range t.Signed.Delegations.Roles {
-			if r.CheckPaths(path) {
+			if r.CheckPaths(path) && r.ValidAt(now) {
 				roles = append(roles, r)
 			}
 		}
@@ -596,7 +689,8 @@ func (tr Repo) FindTarget(path string) *data.FileMeta {
 // signing key for the role, false otherwise.  This does not check that we have
 // enough signing keys to meet the threshold, since we want to support the use
 // case of multiple signers for a role.  It returns an error if the role doesn't
-// exist or if there are no signing keys.
+// exist or if there are no signing keys.  Roles marked with SignExternally
+// only need to exist.
 func (tr *Repo) VerifyCanSign(roleName string) error {
 	var (
 		role data.BaseRole
@@ -616,6 +710,9 @@ func (tr *Repo) VerifyCanSign(roleName string) error {
 	if err != nil {
 		return data.ErrInvalidRole{Role: roleName, Reason: "does not exist"}
 	}
+	if tr.externallySigned[roleName] {
+		return nil
+	}
 
 	for keyID, k := range role.Keys {
 		check := []string{keyID}
@@ -748,6 +845,37 @@ func (tr *Repo) SignRoot(expires time.Time) (*data.Signed, error) {
 
 // SignTargets signs the targets file for the given top level or delegated targets role
 func (tr *Repo) SignTargets(role string, expires time.Time) (*data.Signed, error) {
+	return tr.signTargets(role, nil, expires)
+}
+
+// SignTargetsWithKey signs the targets file for the given role using only the
+// key with the given ID, rather than every available key for the role.  The
+// key ID may be either the ID the role lists the key under or its canonical
+// ID.  An ErrInvalidSigningKey is returned if the key is not one of the
+// role's keys.
+func (tr *Repo) SignTargetsWithKey(role, keyID string, expires time.Time) (*data.Signed, error) {
+	return tr.SignTargetsWithKeys(role, []string{keyID}, expires)
+}
+
+// SignTargetsWithKeys is like SignTargetsWithKey, but signs with each of the
+// keys with the given IDs, so that a role with a threshold above 1 can be
+// signed with enough of its keys at once.  Every key has to be one of the
+// role's keys.
+func (tr *Repo) SignTargetsWithKeys(role string, keyIDs []string, expires time.Time) (*data.Signed, error) {
+	if len(keyIDs) == 0 {
+		return nil, signed.ErrInvalidSigningKey{Role: role}
+	}
+	for _, keyID := range keyIDs {
+		if keyID == "" {
+			return nil, signed.ErrInvalidSigningKey{Role: role, KeyID: keyID}
+		}
+	}
+	return tr.signTargets(role, keyIDs, expires)
+}
+
+// signTargets signs the targets file for the given role, restricting the
+// signing keys to the ones with the given IDs if there are any
+func (tr *Repo) signTargets(role string, keyIDs []string, expires time.Time) (*data.Signed, error) {
 	logrus.Debugf("sign targets called for role %s", role)
 	if _, ok := tr.Targets[role]; !ok {
 		return nil, data.ErrInvalidRole{
@@ -755,15 +883,11 @@ func (tr *Repo) SignTargets(role string, expires time.Time) (*data.Signed, error
 			Reason: "SignTargets called with non-existant targets role",
 		}
 	}
-	tr.Targets[role].Signed.Expires = expires
-	tr.Targets[role].Signed.Version++
-	signed, err := tr.Targets[role].ToSigned()
-	if err != nil {
-		logrus.Debug("errored getting targets data.Signed object")
-		return nil, err
-	}
 
-	var targets data.BaseRole
+	var (
+		targets data.BaseRole
+		err     error
+	)
 	if role == data.CanonicalTargetsRole {
 		targets, err = tr.GetBaseRole(role)
 	} else {
@@ -777,6 +901,28 @@ func (tr *Repo) SignTargets(role string, expires time.Time) (*data.Signed, error
 		return nil, err
 	}
 
+	if len(keyIDs) > 0 {
+		// validate the keys before touching the version or expiry, so that a
+		// bad key ID leaves the role unchanged
+		signingKeys := make(map[string]data.PublicKey, len(keyIDs))
+		for _, keyID := range keyIDs {
+			key, err := roleKey(targets, keyID)
+			if err != nil {
+				return nil, err
+			}
+			signingKeys[key.ID()] = key
+		}
+		targets.Keys = signingKeys
+	}
+
+	tr.Targets[role].Signed.Expires = expires
+	tr.Targets[role].Signed.Version++
+	signed, err := tr.Targets[role].ToSigned()
+	if err != nil {
+		logrus.Debug("errored getting targets data.Signed object")
+		return nil, err
+	}
+
 	signed, err = tr.sign(signed, targets)
 	if err != nil {
 		logrus.Debug("errored signing ", role)
@@ -786,6 +932,20 @@ func (tr *Repo) SignTargets(role string, expires time.Time) (*data.Signed, error
 	return signed, nil
 }
 
+// roleKey finds the key of a role by either the ID it is listed under in the
+// role or its canonical ID
+func roleKey(role data.BaseRole, keyID string) (data.PublicKey, error) {
+	if key, ok := role.Keys[keyID]; ok {
+		return key, nil
+	}
+	for _, key := range role.Keys {
+		if canonicalID, err := utils.CanonicalKeyID(key); err == nil && canonicalID == keyID {
+			return key, nil
+		}
+	}
+	return nil, signed.ErrInvalidSigningKey{Role: role.Name, KeyID: keyID}
+}
+
 // SignSnapshot updates the snapshot based on the current targets and root then signs it
 func (tr *Repo) SignSnapshot(expires time.Time) (*data.Signed, error) {
 	logrus.Debug("signing snapshot...")
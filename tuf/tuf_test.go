@@ -8,6 +8,7 @@ import (
 	"path"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/docker/notary/tuf/data"
 	"github.com/docker/notary/tuf/signed"
@@ -103,6 +104,178 @@ func TestInitRepo(t *testing.T) {
 	writeRepo(t, "/tmp/tufrepo", repo)
 }
 
+// Replacing the keys of a base role that has more than one key removes all of
+// the old keys, and keeps any of them that are also in the new set of keys
+func TestReplaceBaseKeysMultipleKeys(t *testing.T) {
+	ed25519 := signed.NewEd25519()
+	repo := initRepo(t, ed25519)
+
+	oldRoot, err := repo.GetBaseRole(data.CanonicalRootRole)
+	assert.NoError(t, err)
+	keepKey := oldRoot.ListKeys()[0]
+
+	extraKeys := make([]data.PublicKey, 2)
+	for i := range extraKeys {
+		extraKeys[i], err = ed25519.Create("root", data.ED25519Key)
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, repo.AddBaseKeys(data.CanonicalRootRole, extraKeys...))
+
+	newKey, err := ed25519.Create("root", data.ED25519Key)
+	assert.NoError(t, err)
+	assert.NoError(t, repo.ReplaceBaseKeys(data.CanonicalRootRole, keepKey, newKey))
+
+	root, err := repo.GetBaseRole(data.CanonicalRootRole)
+	assert.NoError(t, err)
+	assert.Len(t, root.Keys, 2)
+	assert.Len(t, repo.Root.Signed.Roles[data.CanonicalRootRole].KeyIDs, 2)
+	for _, k := range []data.PublicKey{keepKey, newKey} {
+		_, ok := root.Keys[k.ID()]
+		assert.True(t, ok, "missing key %s", k.ID())
+	}
+	for _, k := range extraKeys {
+		_, ok := repo.Root.Signed.Keys[k.ID()]
+		assert.False(t, ok, "replaced key %s is still in root", k.ID())
+	}
+}
+
+// Copy produces a repo with the same metadata that can be modified without
+// affecting the original
+func TestRepoCopy(t *testing.T) {
+	ed25519 := signed.NewEd25519()
+	repo := initRepo(t, ed25519)
+	repo.Root.Dirty = true
+
+	testKey, err := ed25519.Create("targets/test", data.ED25519Key)
+	assert.NoError(t, err)
+	role, err := data.NewRole("targets/test", 1, []string{testKey.ID()}, []string{""})
+	assert.NoError(t, err)
+	assert.NoError(t, repo.UpdateDelegations(role, data.KeyList{testKey}))
+	_, err = repo.InitTargets("targets/test")
+	assert.NoError(t, err)
+
+	repoCopy, err := repo.Copy()
+	assert.NoError(t, err)
+	assert.Equal(t, repo.Root.Signed.Version, repoCopy.Root.Signed.Version)
+	assert.True(t, repoCopy.Root.Dirty)
+	assert.Len(t, repoCopy.Targets, 2)
+	assert.Equal(t, repo.Targets["targets/test"].Dirty, repoCopy.Targets["targets/test"].Dirty)
+	assert.NotNil(t, repoCopy.Snapshot)
+	assert.NotNil(t, repoCopy.Timestamp)
+
+	delgRole, err := repoCopy.GetDelegationRole("targets/test")
+	assert.NoError(t, err)
+	assert.Contains(t, delgRole.ListKeyIDs(), testKey.ID())
+
+	_, err = repoCopy.SignTargets("targets/test", data.DefaultExpires("targets"))
+	assert.NoError(t, err)
+	assert.NotEqual(t, repo.Targets["targets/test"].Signed.Version,
+		repoCopy.Targets["targets/test"].Signed.Version)
+	assert.Empty(t, repo.Targets["targets/test"].Signatures)
+}
+
+// Copy keeps the time the repo is checked at, and which of its metadata is
+// dirty
+func TestRepoCopyKeepsUnserializedState(t *testing.T) {
+	repo := initRepo(t, signed.NewEd25519())
+	repo.AsOf = time.Now().AddDate(-1, 0, 0)
+	repo.Root.Dirty = true
+	repo.Targets[data.CanonicalTargetsRole].Dirty = true
+	repo.Snapshot.Dirty = true
+	repo.Timestamp.Dirty = true
+
+	repoCopy, err := repo.Copy()
+	assert.NoError(t, err)
+	assert.True(t, repo.AsOf.Equal(repoCopy.AsOf))
+	assert.True(t, repoCopy.Root.Dirty)
+	assert.True(t, repoCopy.Targets[data.CanonicalTargetsRole].Dirty)
+	assert.True(t, repoCopy.Snapshot.Dirty)
+	assert.True(t, repoCopy.Timestamp.Dirty)
+
+	repo.Root.Dirty = false
+	repo.Targets[data.CanonicalTargetsRole].Dirty = false
+	repo.Snapshot.Dirty = false
+	repo.Timestamp.Dirty = false
+
+	repoCopy, err = repo.Copy()
+	assert.NoError(t, err)
+	assert.False(t, repoCopy.Root.Dirty)
+	assert.False(t, repoCopy.Targets[data.CanonicalTargetsRole].Dirty)
+	assert.False(t, repoCopy.Snapshot.Dirty)
+	assert.False(t, repoCopy.Timestamp.Dirty)
+}
+
+// SignTargetsWithKey signs with only the requested key, and rejects keys that
+// are not keys of the role without modifying the role
+func TestSignTargetsWithKey(t *testing.T) {
+	ed25519 := signed.NewEd25519()
+	repo := initRepo(t, ed25519)
+
+	extraKey, err := ed25519.Create("targets", data.ED25519Key)
+	assert.NoError(t, err)
+	assert.NoError(t, repo.AddBaseKeys(data.CanonicalTargetsRole, extraKey))
+
+	s, err := repo.SignTargets(data.CanonicalTargetsRole, data.DefaultExpires("targets"))
+	assert.NoError(t, err)
+	assert.Len(t, s.Signatures, 2)
+
+	repo.Targets[data.CanonicalTargetsRole].Signatures = nil
+	s, err = repo.SignTargetsWithKey(
+		data.CanonicalTargetsRole, extraKey.ID(), data.DefaultExpires("targets"))
+	assert.NoError(t, err)
+	assert.Len(t, s.Signatures, 1)
+	assert.Equal(t, extraKey.ID(), s.Signatures[0].KeyID)
+
+	otherKey, err := ed25519.Create("targets", data.ED25519Key)
+	assert.NoError(t, err)
+	version := repo.Targets[data.CanonicalTargetsRole].Signed.Version
+	_, err = repo.SignTargetsWithKey(
+		data.CanonicalTargetsRole, otherKey.ID(), data.DefaultExpires("targets"))
+	assert.Error(t, err)
+	assert.IsType(t, signed.ErrInvalidSigningKey{}, err)
+	assert.Equal(t, version, repo.Targets[data.CanonicalTargetsRole].Signed.Version)
+
+	_, err = repo.SignTargetsWithKey(
+		data.CanonicalTargetsRole, "", data.DefaultExpires("targets"))
+	assert.Error(t, err)
+}
+
+// SignTargetsWithKeys signs with each of the requested keys, all of which have
+// to be keys of the role
+func TestSignTargetsWithKeys(t *testing.T) {
+	ed25519 := signed.NewEd25519()
+	repo := initRepo(t, ed25519)
+	targetsRole, err := repo.GetBaseRole(data.CanonicalTargetsRole)
+	assert.NoError(t, err)
+	firstKey := targetsRole.ListKeys()[0]
+
+	secondKey, err := ed25519.Create("targets", data.ED25519Key)
+	assert.NoError(t, err)
+	thirdKey, err := ed25519.Create("targets", data.ED25519Key)
+	assert.NoError(t, err)
+	assert.NoError(t, repo.AddBaseKeys(data.CanonicalTargetsRole, secondKey, thirdKey))
+
+	repo.Targets[data.CanonicalTargetsRole].Signatures = nil
+	s, err := repo.SignTargetsWithKeys(data.CanonicalTargetsRole,
+		[]string{firstKey.ID(), thirdKey.ID()}, data.DefaultExpires("targets"))
+	assert.NoError(t, err)
+	assert.Len(t, s.Signatures, 2)
+	signedBy := []string{s.Signatures[0].KeyID, s.Signatures[1].KeyID}
+	assert.Contains(t, signedBy, firstKey.ID())
+	assert.Contains(t, signedBy, thirdKey.ID())
+
+	otherKey, err := ed25519.Create("targets", data.ED25519Key)
+	assert.NoError(t, err)
+	version := repo.Targets[data.CanonicalTargetsRole].Signed.Version
+	_, err = repo.SignTargetsWithKeys(data.CanonicalTargetsRole,
+		[]string{firstKey.ID(), otherKey.ID()}, data.DefaultExpires("targets"))
+	assert.IsType(t, signed.ErrInvalidSigningKey{}, err)
+	assert.Equal(t, version, repo.Targets[data.CanonicalTargetsRole].Signed.Version)
+
+	_, err = repo.SignTargetsWithKeys(data.CanonicalTargetsRole, nil, data.DefaultExpires("targets"))
+	assert.Error(t, err)
+}
+
 func TestUpdateDelegations(t *testing.T) {
 	ed25519 := signed.NewEd25519()
 	repo := initRepo(t, ed25519)
@@ -639,6 +812,43 @@ func TestGetDelegationParentMissing(t *testing.T) {
 	assert.IsType(t, data.ErrInvalidRole{}, err)
 }
 
+// Once a delegation has expired, neither it nor the delegations below it can
+// be retrieved with GetDelegationRole, and it is no longer a valid publisher
+// for any path, while its entry in its parent's metadata is left alone
+func TestGetDelegationRoleExpired(t *testing.T) {
+	ed25519 := signed.NewEd25519()
+	repo := initRepo(t, ed25519)
+
+	testKey, err := ed25519.Create("meh", data.ED25519Key)
+	assert.NoError(t, err)
+
+	past := time.Now().Add(-time.Hour)
+	role, err := data.NewRole("targets/level1", 1, []string{testKey.ID()}, []string{""})
+	assert.NoError(t, err)
+	role.ValidUntil = &past
+	assert.NoError(t, repo.UpdateDelegations(role, data.KeyList{testKey}))
+	_, err = repo.InitTargets("targets/level1")
+	assert.NoError(t, err)
+
+	for _, name := range []string{"targets/level1", "targets/level1/level2"} {
+		_, err = repo.GetDelegationRole(name)
+		assert.Error(t, err)
+		assert.IsType(t, data.ErrInvalidRole{}, err)
+	}
+	assert.Empty(t, repo.TargetDelegations(data.CanonicalTargetsRole, "path", ""))
+
+	gottenRole, _, err := repo.GetDelegation("targets/level1")
+	assert.NoError(t, err)
+	assert.Equal(t, role, gottenRole)
+
+	future := time.Now().Add(time.Hour)
+	role.ValidUntil = &future
+	assert.NoError(t, repo.UpdateDelegations(role, data.KeyList{testKey}))
+	_, err = repo.GetDelegationRole("targets/level1")
+	assert.NoError(t, err)
+	assert.Len(t, repo.TargetDelegations(data.CanonicalTargetsRole, "path", ""), 1)
+}
+
 // Adding targets to a role that exists and has metadata (like targets)
 // correctly adds the target
 func TestAddTargetsRoleAndMetadataExist(t *testing.T) {
@@ -37,12 +37,17 @@ func (err ErrBadRoot) Error() string {
 	return fmt.Sprintf("The root metadata is invalid: %s", err.Msg)
 }
 
-// ErrBadTargets represents a failure to validate a targets (incl delegations)
+// ErrBadTargets represents a failure to validate a targets (incl delegations).
+// Role is the targets or delegation role that failed validation, if known.
 type ErrBadTargets struct {
-	Msg string
+	Msg  string
+	Role string
 }
 
 func (err ErrBadTargets) Error() string {
+	if err.Role != "" {
+		return fmt.Sprintf("The targets metadata for %s is invalid: %s", err.Role, err.Msg)
+	}
 	return fmt.Sprintf("The targets metadata is invalid: %s", err.Msg)
 }
 
@@ -29,7 +29,8 @@ func TestUnmarshalSerialiableErrorSuccessfully(t *testing.T) {
 		ErrValidation{"bad validation"},
 		ErrBadHierarchy{Missing: "root", Msg: "badness"},
 		ErrBadRoot{"bad root"},
-		ErrBadTargets{"bad targets"},
+		ErrBadTargets{Msg: "bad targets"},
+		ErrBadTargets{Msg: "bad targets", Role: "targets/a"},
 		ErrBadSnapshot{"bad snapshot"},
 	}
 